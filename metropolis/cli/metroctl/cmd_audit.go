@@ -0,0 +1,123 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+
+	"source.monogon.dev/go/clitable"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var auditCmd = &cobra.Command{
+	Short: "Shows the cluster audit log.",
+	Long: `Shows the cluster audit log.
+
+The audit log contains an entry for every call to a cluster management RPC
+which changes the state of the cluster, eg. approving or deleting nodes, or
+reconfiguring the cluster. Entries can be limited with a CEL expression passed
+to --filter, in which the entry is available as 'entry'.`,
+	Use:     "audit [--filter] [--follow] [--output] [--columns]",
+	Example: "metroctl audit --filter 'entry.identity == \"owner\" && entry.method.endsWith(\"/DeleteNode\")'",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			return err
+		}
+
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		srv, err := mgmt.GetAuditLog(ctx, &apb.GetAuditLogRequest{
+			Filter: flags.filter,
+			Follow: follow,
+		})
+		if err != nil {
+			return fmt.Errorf("while calling Management.GetAuditLog: %w", err)
+		}
+
+		o := io.WriteCloser(os.Stdout)
+		if flags.output != "" {
+			of, err := os.Create(flags.output)
+			if err != nil {
+				return fmt.Errorf("couldn't create the output file at %s: %w", flags.output, err)
+			}
+			defer of.Close()
+			o = of
+		}
+
+		var columns map[string]bool
+		if flags.columns != "" {
+			columns = make(map[string]bool)
+			for _, p := range strings.Split(flags.columns, ",") {
+				p = strings.ToLower(p)
+				p = strings.TrimSpace(p)
+				columns[p] = true
+			}
+		}
+
+		var t clitable.Table
+		for {
+			entry, err := srv.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("while receiving audit log: %w", err)
+			}
+			if follow {
+				// Print entries as they arrive instead of waiting for the
+				// stream to end, which it doesn't when following.
+				fmt.Fprintf(o, "%s %s %s %s %s\n", entry.Time.AsTime().Format(time.RFC3339), entry.Identity, entry.Method, auditResult(entry), entry.Request)
+				continue
+			}
+			t.Add(auditEntry(entry))
+		}
+		t.Print(o, columns)
+		return nil
+	},
+	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+}
+
+// auditResult returns a short description of the result of an audited call.
+func auditResult(e *apb.AuditEntry) string {
+	code := codes.Code(e.Code)
+	if code == codes.OK {
+		return "OK"
+	}
+	return fmt.Sprintf("%s: %s", code, e.Error)
+}
+
+func auditEntry(e *apb.AuditEntry) clitable.Entry {
+	res := clitable.Entry{}
+	res.Add("time", e.Time.AsTime().Format(time.RFC3339))
+	res.Add("identity", e.Identity)
+	res.Add("method", e.Method)
+	res.Add("result", auditResult(e))
+	res.Add("request", e.Request)
+	return res
+}
+
+func init() {
+	auditCmd.Flags().Bool("follow", false, "Keep showing new entries as they are appended to the audit log")
+	rootCmd.AddCommand(auditCmd)
+}
@@ -4,20 +4,33 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"source.monogon.dev/go/clitable"
 	"source.monogon.dev/metropolis/cli/metroctl/core"
+
+	apb "source.monogon.dev/metropolis/proto/api"
 )
 
 func init() {
 	certCmd.AddCommand(certExportCmd)
+	certCmd.AddCommand(certListCmd)
+	certCmd.AddCommand(certRevokeCmd)
+	certCmd.AddCommand(certRotateOwnerCmd)
 
 	rootCmd.AddCommand(certCmd)
 }
@@ -32,7 +45,8 @@ var certExportCmd = &cobra.Command{
 	Use:     "export",
 	Example: "metroctl cert export",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ocert, opkey, err := core.GetOwnerCredentials(flags.configPath)
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		ocert, opkey, err := getOwnerCredentials(ctx)
 		if errors.Is(err, core.ErrNoCredentials) {
 			return fmt.Errorf("you have to take ownership of the cluster first: %w", err)
 		}
@@ -56,3 +70,140 @@ var certExportCmd = &cobra.Command{
 	},
 	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
 }
+
+var certListCmd = &cobra.Command{
+	Short:   "Lists certificates issued to users of the cluster",
+	Use:     "list [--output] [--columns]",
+	Example: "metroctl cert list",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		res, err := mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+		if err != nil {
+			return fmt.Errorf("while calling Management.ListCertificates: %w", err)
+		}
+
+		o := io.WriteCloser(os.Stdout)
+		if flags.output != "" {
+			of, err := os.Create(flags.output)
+			if err != nil {
+				return fmt.Errorf("couldn't create the output file at %s: %w", flags.output, err)
+			}
+			defer of.Close()
+			o = of
+		}
+
+		var columns map[string]bool
+		if flags.columns != "" {
+			columns = make(map[string]bool)
+			for _, p := range strings.Split(flags.columns, ",") {
+				p = strings.ToLower(p)
+				p = strings.TrimSpace(p)
+				columns[p] = true
+			}
+		}
+
+		var t clitable.Table
+		for _, c := range res.Certificates {
+			t.Add(certificateEntry(c))
+		}
+		t.Print(o, columns)
+		return nil
+	},
+	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+}
+
+// certificateStatus returns a short description of the state of a
+// certificate.
+func certificateStatus(c *apb.Certificate) string {
+	switch {
+	case c.Revoked && c.BindingRemoved:
+		return "revoked (binding removed)"
+	case c.Revoked:
+		return "revoked"
+	case time.Now().After(c.NotAfter.AsTime()):
+		return "expired"
+	default:
+		return "valid"
+	}
+}
+
+func certificateEntry(c *apb.Certificate) clitable.Entry {
+	res := clitable.Entry{}
+	res.Add("serial", c.SerialNumber)
+	res.Add("identity", c.Identity)
+	res.Add("not after", c.NotAfter.AsTime().Format(time.RFC3339))
+	res.Add("status", certificateStatus(c))
+	return res
+}
+
+var certRevokeCmd = &cobra.Command{
+	Short: "Revokes a certificate issued to a user of the cluster",
+	Long: `Revokes a certificate issued to a user of the cluster.
+
+Once revoked, the certificate is rejected by all cluster nodes, and no further
+certificates are issued to its identity for its public key. Certificates of the
+current owner key cannot be revoked, use 'metroctl cert rotate-owner' instead.
+Serial numbers can be retrieved with 'metroctl cert list'.`,
+	Use:     "revoke [serial]",
+	Example: "metroctl cert revoke 3a1f9c...",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		_, err = mgmt.RevokeCertificate(ctx, &apb.RevokeCertificateRequest{
+			SerialNumber: args[0],
+		})
+		if err != nil {
+			return fmt.Errorf("while calling Management.RevokeCertificate: %w", err)
+		}
+		log.Printf("Revoked certificate %s.", args[0])
+		return nil
+	},
+	Args: PrintUsageOnWrongArgs(cobra.ExactArgs(1)),
+}
+
+var certRotateOwnerCmd = &cobra.Command{
+	Short: "Replaces the owner key of the cluster",
+	Long: `Replaces the owner key of the cluster with a newly generated one.
+
+This should be done if the owner key might have been compromised, eg. because a
+device holding it was lost. All unexpired certificates of the previous owner key
+are revoked, and only the new key can retrieve owner certificates afterwards.
+The new key replaces the owner key in the metroctl configuration directory.`,
+	Use:     "rotate-owner",
+	Example: "metroctl cert rotate-owner",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		err = core.RotateOwnerKey(flags.configPath, func(pub ed25519.PublicKey) error {
+			_, err := mgmt.RotateOwnerKey(ctx, &apb.RotateOwnerKeyRequest{
+				PublicKey: pub,
+			})
+			if err != nil {
+				return fmt.Errorf("while calling Management.RotateOwnerKey: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Rotated owner key, saved new key to %s.", filepath.Join(flags.configPath, core.OwnerKeyFileName))
+		return nil
+	},
+	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+}
@@ -0,0 +1,141 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"source.monogon.dev/metropolis/node/core/backup"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var clusterBackupCmd = &cobra.Command{
+	Short: "Saves a snapshot of the cluster's control plane state.",
+	Long: `Saves a snapshot of the cluster's control plane state.
+
+The snapshot contains the entire consensus database of the cluster, including
+the cluster CA and all other cluster secrets, so it must be stored securely.
+It can be used to recover the cluster after losing its control plane by
+installing a new node with metroctl install --restore.`,
+	Use:     "backup <file>",
+	Example: "metroctl cluster backup cluster.snapshot",
+	Args:    PrintUsageOnWrongArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		srv, err := mgmt.TakeSnapshot(ctx, &apb.TakeSnapshotRequest{})
+		if err != nil {
+			return fmt.Errorf("while calling Management.TakeSnapshot: %w", err)
+		}
+
+		// Write to a temporary file first, so that an interrupted backup does
+		// not leave a truncated snapshot behind.
+		path := args[0]
+		f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+		if err != nil {
+			return fmt.Errorf("while creating snapshot file: %w", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		total := 0
+		for {
+			res, err := srv.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("while receiving snapshot: %w", err)
+			}
+			if _, err := f.Write(res.Data); err != nil {
+				return fmt.Errorf("while writing snapshot: %w", err)
+			}
+			total += len(res.Data)
+		}
+		if total == 0 {
+			return fmt.Errorf("received empty snapshot")
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("while writing snapshot: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("while writing snapshot: %w", err)
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return fmt.Errorf("while saving snapshot: %w", err)
+		}
+		log.Printf("Saved snapshot (%d bytes) to %s", total, path)
+		return nil
+	},
+}
+
+var clusterBackupKeyCmd = &cobra.Command{
+	Short: "Saves the key with which periodic cluster backups are encrypted.",
+	Long: `Saves the key with which periodic cluster backups are encrypted.
+
+Periodic backups (see the backup field of metroctl cluster configure) are
+encrypted with a key derived from the cluster CA. The key does not change for
+the lifetime of the cluster and is required to restore from such a backup with
+metroctl install --restore --restore-key, so it should be saved once and stored
+securely, separately from the backups.`,
+	Use:     "backup-key <file>",
+	Example: "metroctl cluster backup-key cluster.backup-key",
+	Args:    PrintUsageOnWrongArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		res, err := mgmt.GetBackupKey(ctx, &apb.GetBackupKeyRequest{})
+		if err != nil {
+			return fmt.Errorf("while calling Management.GetBackupKey: %w", err)
+		}
+		if len(res.Key) != backup.KeySize {
+			return fmt.Errorf("received key of invalid size %d", len(res.Key))
+		}
+		if err := os.WriteFile(args[0], []byte(hex.EncodeToString(res.Key)+"\n"), 0600); err != nil {
+			return fmt.Errorf("while saving key: %w", err)
+		}
+		log.Printf("Saved backup key to %s", args[0])
+		return nil
+	},
+}
+
+// readBackupKey reads a backup key file as saved by metroctl cluster
+// backup-key.
+func readBackupKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != backup.KeySize {
+		return nil, fmt.Errorf("%s does not contain a valid backup key", path)
+	}
+	return key, nil
+}
+
+func init() {
+	clusterCmd.AddCommand(clusterBackupCmd)
+	clusterCmd.AddCommand(clusterBackupKeyCmd)
+}
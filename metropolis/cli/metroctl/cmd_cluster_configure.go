@@ -4,17 +4,33 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"source.monogon.dev/osbase/oci"
+	"source.monogon.dev/osbase/oci/osimage"
+	"source.monogon.dev/osbase/oci/signature"
+	"source.monogon.dev/osbase/tpm/eventlog"
+
 	apb "source.monogon.dev/metropolis/proto/api"
 	cpb "source.monogon.dev/metropolis/proto/common"
 )
@@ -60,6 +76,531 @@ var configurableClusterKeys = []configurableClusterKey{
 			return strings.Join(res, ", "), nil
 		},
 	},
+	{
+		key:         "os_image_signing_keys",
+		description: "list of PEM files with public keys trusted to sign OS images, as generated by cosign",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"os_image_signing_keys"},
+				},
+			}
+			for _, v := range value {
+				key, err := readPublicKeyPEM(v)
+				if err != nil {
+					return nil, err
+				}
+				res.NewConfig.OsImageSigningKeys = append(res.NewConfig.OsImageSigningKeys, key)
+			}
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			var res []string
+			for _, k := range c.OsImageSigningKeys {
+				res = append(res, fmt.Sprintf("sha256:%x", sha256.Sum256(k)))
+			}
+			return strings.Join(res, ", "), nil
+		},
+	},
+	{
+		key:         "backup",
+		description: "periodic consensus backups as <interval> <local|oci://host/repository|oci+http://host/repository> [retain], or nothing to disable",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"backup"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			if len(value) < 2 || len(value) > 3 {
+				return nil, fmt.Errorf("backup takes an interval, a destination and optionally the number of retained backups")
+			}
+			interval, err := time.ParseDuration(value[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval: %w", err)
+			}
+			b := &cpb.ClusterConfiguration_Backup{
+				Interval: durationpb.New(interval),
+			}
+			if err := parseBackupDestination(b, value[1]); err != nil {
+				return nil, err
+			}
+			if len(value) == 3 {
+				retain, err := strconv.ParseUint(value[2], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number of retained backups: %w", err)
+				}
+				b.Retain = uint32(retain)
+			}
+			res.NewConfig.Backup = b
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			b := c.Backup
+			if b == nil || b.Interval == nil {
+				return "disabled", nil
+			}
+			var dst string
+			switch d := b.Destination.(type) {
+			case *cpb.ClusterConfiguration_Backup_Oci:
+				scheme := "oci"
+				if d.Oci.Scheme == "http" {
+					scheme = "oci+http"
+				}
+				dst = fmt.Sprintf("%s://%s/%s", scheme, d.Oci.Host, d.Oci.Repository)
+			case *cpb.ClusterConfiguration_Backup_Local_:
+				dst = "local"
+			default:
+				dst = "unknown"
+			}
+			retain := "default number of"
+			if b.Retain != 0 {
+				retain = strconv.FormatUint(uint64(b.Retain), 10)
+			}
+			return fmt.Sprintf("every %v to %s, retaining %s backups", b.Interval.AsDuration(), dst, retain), nil
+		},
+	},
+	{
+		key:         "log_shipping",
+		description: "ship logs as <syslog://host:port|syslog+tls://host:port|http(s)://otlp-endpoint> [dn], or nothing to disable",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"log_shipping"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			if len(value) > 2 {
+				return nil, fmt.Errorf("log_shipping takes a destination and optionally a DN")
+			}
+			ls := &cpb.ClusterConfiguration_LogShipping{}
+			if err := parseLogShippingDestination(ls, value[0]); err != nil {
+				return nil, err
+			}
+			if len(value) == 2 {
+				ls.Dn = value[1]
+			}
+			res.NewConfig.LogShipping = ls
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			ls := c.LogShipping
+			var dst string
+			switch d := ls.GetDestination().(type) {
+			case *cpb.ClusterConfiguration_LogShipping_Syslog_:
+				scheme := "syslog"
+				if d.Syslog.Tls {
+					scheme = "syslog+tls"
+				}
+				dst = fmt.Sprintf("%s://%s", scheme, d.Syslog.Address)
+			case *cpb.ClusterConfiguration_LogShipping_Otlp:
+				dst = d.Otlp.Endpoint
+			default:
+				return "disabled", nil
+			}
+			if ls.Dn != "" {
+				return fmt.Sprintf("logs at %s to %s", ls.Dn, dst), nil
+			}
+			return fmt.Sprintf("all logs to %s", dst), nil
+		},
+	},
+	{
+		key:         "dns",
+		description: "list of encrypted upstream DNS servers as <tls|https>://[server-name@]ip[:port][/path][?ca=ca.pem], or nothing to use the nodes' DNS servers",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"dns"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			d := &cpb.ClusterConfiguration_DNS{}
+			for _, v := range value {
+				u, err := parseDNSUpstream(v)
+				if err != nil {
+					return nil, err
+				}
+				d.Upstreams = append(d.Upstreams, u)
+			}
+			res.NewConfig.Dns = d
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			var res []string
+			for _, u := range c.Dns.GetUpstreams() {
+				scheme := "tls"
+				if u.Protocol == cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS {
+					scheme = "https"
+				}
+				s := fmt.Sprintf("%s://", scheme)
+				if u.ServerName != "" {
+					s += u.ServerName + "@"
+				}
+				s += u.Address + u.Path
+				if n := len(u.CaCertificates); n > 0 {
+					s += fmt.Sprintf(" (%d pinned CAs)", n)
+				}
+				res = append(res, s)
+			}
+			if len(res) == 0 {
+				return "nodes' DNS servers", nil
+			}
+			return strings.Join(res, ", "), nil
+		},
+	},
+	{
+		key:         "tpm_attestation",
+		description: "<required|recorded> followed by allowed sets of PCR values as <pcr>=<sha256-hex>[,<pcr>=<sha256-hex>...] and PEM files with trusted EK CA certificates as ek-ca=<path>, or nothing to only record attestation without policy",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"tpm_attestation"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			a := &cpb.ClusterConfiguration_TPMAttestation{}
+			switch value[0] {
+			case "required":
+				a.Required = true
+			case "recorded":
+			default:
+				return nil, fmt.Errorf("tpm_attestation must start with required or recorded")
+			}
+			for _, v := range value[1:] {
+				if path, ok := strings.CutPrefix(v, "ek-ca="); ok {
+					cas, err := readCertificatesPEM(path)
+					if err != nil {
+						return nil, err
+					}
+					a.EkCaCertificates = append(a.EkCaCertificates, cas...)
+					continue
+				}
+				pcrs, err := parsePCRValues(v)
+				if err != nil {
+					return nil, err
+				}
+				a.AllowedPcrValues = append(a.AllowedPcrValues, pcrs)
+			}
+			res.NewConfig.TpmAttestation = a
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			a := c.TpmAttestation
+			mode := "recorded"
+			if a.GetRequired() {
+				mode = "required"
+			}
+			var sets []string
+			for _, values := range a.GetAllowedPcrValues() {
+				var pcrs []string
+				for index, value := range values.Pcrs {
+					pcrs = append(pcrs, fmt.Sprintf("%d=%s", index, hex.EncodeToString(value)))
+				}
+				sort.Strings(pcrs)
+				sets = append(sets, strings.Join(pcrs, ","))
+			}
+			eks := "any EK"
+			if n := len(a.GetEkCaCertificates()); n > 0 {
+				eks = fmt.Sprintf("EKs certified by %d CAs", n)
+			}
+			if len(sets) == 0 {
+				return fmt.Sprintf("%s, %s, any PCR values", mode, eks), nil
+			}
+			return fmt.Sprintf("%s, %s, PCR values %s", mode, eks, strings.Join(sets, " or ")), nil
+		},
+	},
+	{
+		key:         "measured_boot_policy",
+		description: "optionally secure-boot, followed by allowed boot applications as SHA256 Authenticode digests or paths to OS images, or nothing to allow any",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"measured_boot_policy"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			p := &cpb.ClusterConfiguration_MeasuredBootPolicy{}
+			if value[0] == "secure-boot" {
+				p.RequireSecureBoot = true
+				value = value[1:]
+			}
+			for _, v := range value {
+				digest, err := readBootApplicationDigest(v)
+				if err != nil {
+					return nil, err
+				}
+				p.AllowedBootApplications = append(p.AllowedBootApplications, digest)
+			}
+			res.NewConfig.MeasuredBootPolicy = p
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			p := c.MeasuredBootPolicy
+			secureBoot := "secure boot optional"
+			if p.GetRequireSecureBoot() {
+				secureBoot = "secure boot required"
+			}
+			var apps []string
+			for _, digest := range p.GetAllowedBootApplications() {
+				apps = append(apps, hex.EncodeToString(digest))
+			}
+			if len(apps) == 0 {
+				return fmt.Sprintf("%s, any boot applications", secureBoot), nil
+			}
+			return fmt.Sprintf("%s, boot applications %s", secureBoot, strings.Join(apps, ", ")), nil
+		},
+	},
+	{
+		key:         "time",
+		description: "optionally consensus-time-servers, followed by NTP servers as IP addresses or host names, or nothing to use the default NTP pool",
+		set: func(value []string) (*apb.ConfigureClusterRequest, error) {
+			res := &apb.ConfigureClusterRequest{
+				NewConfig: &cpb.ClusterConfiguration{},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"time"},
+				},
+			}
+			if len(value) == 0 {
+				return res, nil
+			}
+			t := &cpb.ClusterConfiguration_Time{}
+			if value[0] == "consensus-time-servers" {
+				t.ConsensusTimeServers = true
+				value = value[1:]
+			}
+			t.NtpServers = value
+			res.NewConfig.Time = t
+			return res, nil
+		},
+		get: func(c *cpb.ClusterConfiguration) (string, error) {
+			t := c.Time
+			servers := "default NTP pool"
+			if len(t.GetNtpServers()) > 0 {
+				servers = strings.Join(t.NtpServers, ", ")
+			}
+			if t.GetConsensusTimeServers() {
+				return fmt.Sprintf("%s, served by consensus members", servers), nil
+			}
+			return servers, nil
+		},
+	},
+}
+
+// readBootApplicationDigest returns the SHA256 Authenticode digest of a boot
+// application, given either directly as hex or as the path to an OS image in
+// OCI layout, in which case the digest of its EFI payload is calculated.
+func readBootApplicationDigest(value string) ([]byte, error) {
+	if d, err := hex.DecodeString(value); err == nil && len(d) == sha256.Size {
+		return d, nil
+	}
+	image, err := oci.ReadLayout(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a SHA256 digest nor an OS image: %w", value, err)
+	}
+	osImage, err := osimage.Read(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OS image %q: %w", value, err)
+	}
+	payload, err := osImage.Payload("kernel.efi")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OS image %q: %w", value, err)
+	}
+	r, err := payload.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OS image %q: %w", value, err)
+	}
+	defer r.Close()
+	efi, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OS image %q: %w", value, err)
+	}
+	digest, err := eventlog.AuthenticodeDigest(bytes.NewReader(efi), int64(len(efi)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash EFI payload of OS image %q: %w", value, err)
+	}
+	return digest, nil
+}
+
+// parsePCRValues parses a set of expected PCR values given as
+// <pcr>=<sha256-hex>[,<pcr>=<sha256-hex>...].
+func parsePCRValues(value string) (*cpb.ClusterConfiguration_TPMAttestation_PCRValues, error) {
+	res := &cpb.ClusterConfiguration_TPMAttestation_PCRValues{
+		Pcrs: make(map[uint32][]byte),
+	}
+	for _, pair := range strings.Split(value, ",") {
+		index, digest, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("PCR value %q must be <pcr>=<sha256-hex>", pair)
+		}
+		i, err := strconv.ParseUint(index, 10, 32)
+		if err != nil || i > 15 {
+			return nil, fmt.Errorf("PCR value %q: PCR must be between 0 and 15", pair)
+		}
+		d, err := hex.DecodeString(digest)
+		if err != nil || len(d) != sha256.Size {
+			return nil, fmt.Errorf("PCR value %q: value must be a hex-encoded SHA256 digest", pair)
+		}
+		if _, ok := res.Pcrs[uint32(i)]; ok {
+			return nil, fmt.Errorf("PCR value %q: PCR %d given twice", pair, i)
+		}
+		res.Pcrs[uint32(i)] = d
+	}
+	return res, nil
+}
+
+// parseDNSUpstream parses an encrypted upstream DNS server given as a tls://
+// or https:// URL. The user part of the URL is the server name, the host is
+// the IP address with optional port, and for https the path is the DNS over
+// HTTPS endpoint. CA certificates to pin are read from the PEM files given
+// in ca query parameters.
+func parseDNSUpstream(value string) (*cpb.ClusterConfiguration_DNS_Upstream, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", value, err)
+	}
+	res := &cpb.ClusterConfiguration_DNS_Upstream{
+		Address:    u.Host,
+		ServerName: u.User.Username(),
+	}
+	switch u.Scheme {
+	case "tls":
+		res.Protocol = cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_TLS
+		if u.Path != "" {
+			return nil, fmt.Errorf("invalid upstream %q: tls upstreams cannot have a path", value)
+		}
+	case "https":
+		res.Protocol = cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS
+		res.Path = u.Path
+	default:
+		return nil, fmt.Errorf("invalid upstream %q: must be a tls:// or https:// URL", value)
+	}
+	for _, path := range u.Query()["ca"] {
+		cas, err := readCertificatesPEM(path)
+		if err != nil {
+			return nil, err
+		}
+		res.CaCertificates = append(res.CaCertificates, cas...)
+	}
+	return res, nil
+}
+
+// readCertificatesPEM reads all PEM-encoded certificates from the given file
+// and returns their DER encodings.
+func readCertificatesPEM(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificates: %w", err)
+	}
+	var res [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		res = append(res, block.Bytes)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s does not contain any PEM-encoded certificates", path)
+	}
+	return res, nil
+}
+
+// parseLogShippingDestination parses a log shipping destination given as a
+// syslog:// or syslog+tls:// URL with host and port, or as an http(s) URL of
+// an OTLP logs endpoint, and sets it in ls.
+func parseLogShippingDestination(ls *cpb.ClusterConfiguration_LogShipping, value string) error {
+	if address, ok := strings.CutPrefix(value, "syslog://"); ok {
+		ls.Destination = &cpb.ClusterConfiguration_LogShipping_Syslog_{
+			Syslog: &cpb.ClusterConfiguration_LogShipping_Syslog{Address: address},
+		}
+		return nil
+	}
+	if address, ok := strings.CutPrefix(value, "syslog+tls://"); ok {
+		ls.Destination = &cpb.ClusterConfiguration_LogShipping_Syslog_{
+			Syslog: &cpb.ClusterConfiguration_LogShipping_Syslog{Address: address, Tls: true},
+		}
+		return nil
+	}
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		ls.Destination = &cpb.ClusterConfiguration_LogShipping_Otlp{
+			Otlp: &cpb.ClusterConfiguration_LogShipping_OTLP{Endpoint: value},
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid destination %q: must be a syslog://, syslog+tls://, http:// or https:// URL", value)
+}
+
+// parseBackupDestination parses a backup destination given as either "local"
+// or an OCI repository URL with scheme oci (using https) or oci+http, and sets
+// it in b.
+func parseBackupDestination(b *cpb.ClusterConfiguration_Backup, value string) error {
+	if value == "local" {
+		b.Destination = &cpb.ClusterConfiguration_Backup_Local_{
+			Local: &cpb.ClusterConfiguration_Backup_Local{},
+		}
+		return nil
+	}
+	var scheme string
+	var rest string
+	if r, ok := strings.CutPrefix(value, "oci://"); ok {
+		scheme, rest = "https", r
+	} else if r, ok := strings.CutPrefix(value, "oci+http://"); ok {
+		scheme, rest = "http", r
+	} else {
+		return fmt.Errorf("invalid destination %q: must be local or an oci:// or oci+http:// URL", value)
+	}
+	host, repository, ok := strings.Cut(rest, "/")
+	if !ok || host == "" || repository == "" {
+		return fmt.Errorf("invalid destination %q: must contain host and repository", value)
+	}
+	b.Destination = &cpb.ClusterConfiguration_Backup_Oci{
+		Oci: &cpb.ClusterConfiguration_Backup_OCI{
+			Scheme:     scheme,
+			Host:       host,
+			Repository: repository,
+		},
+	}
+	return nil
+}
+
+// readPublicKeyPEM reads a PEM-encoded public key from the given file and
+// returns its DER encoding.
+func readPublicKeyPEM(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s does not contain a PEM-encoded public key", path)
+	}
+	if _, err := signature.ParsePublicKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return block.Bytes, nil
 }
 
 var clusterConfigureCommand = &cobra.Command{
@@ -143,7 +684,7 @@ Available configuration fields:
 
 func init() {
 	for _, key := range configurableClusterKeys {
-		clusterConfigureCommand.Long += fmt.Sprintf("  - %s: %s", key.key, key.description)
+		clusterConfigureCommand.Long += fmt.Sprintf("  - %s: %s\n", key.key, key.description)
 	}
 	clusterCmd.AddCommand(clusterConfigureCommand)
 }
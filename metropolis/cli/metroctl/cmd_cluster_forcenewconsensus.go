@@ -0,0 +1,116 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var clusterForceNewConsensusCmd = &cobra.Command{
+	Short: "Recovers the cluster from a permanent loss of consensus quorum.",
+	Long: `Recovers the cluster from a permanent loss of consensus quorum.
+
+If a majority of the consensus members of a cluster is gone for good, the
+remaining members cannot make progress and the cluster is stuck. This command
+restarts consensus on one of the remaining members as the only member of a new
+consensus cluster, which retains all data held by that member. All other nodes
+then lose their ConsensusMember role, which can be given to them again to have
+them rejoin consensus from scratch.
+
+This is a dangerous operation. Any writes which have not been replicated to the
+given node are lost, and if any other consensus members are in fact still
+running, they are cut off from the cluster. Pick the remaining member which was
+most recently up to date. The node refuses to perform the operation while it
+still sees a consensus leader, unless --bypass-quorum-check is given.
+
+As the cluster is not reachable through its control plane anymore, the node
+must be addressed directly, and the cluster CA must already be known to
+metroctl.`,
+	Use:     "force-new-consensus <node-id> <address>",
+	Example: "metroctl cluster force-new-consensus metropolis-25fa5f5e9349381d4a5e9e59de0215e3 10.0.0.2",
+	Args:    PrintUsageOnWrongArgs(cobra.ExactArgs(2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bypassQuorumCheck, err := cmd.Flags().GetBool("bypass-quorum-check")
+		if err != nil {
+			return err
+		}
+		id, address := args[0], args[1]
+
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cacert, err := core.GetClusterCA(flags.configPath)
+		if err != nil {
+			return fmt.Errorf("could not get CA certificate: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "This will force node %s at %s to become the only consensus member of the cluster.\n", id, address)
+		fmt.Fprintf(os.Stderr, "Writes not replicated to this node will be lost, and any other consensus members still running will be cut off.\n")
+		ok, err := confirmForceNewConsensus(ctx, os.Stdin, os.Stderr, id)
+		if err != nil {
+			return fmt.Errorf("while reading confirmation: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("aborted, node ID not confirmed")
+		}
+
+		cc, err := newAuthenticatedNodeClient(ctx, id, address, cacert)
+		if err != nil {
+			return err
+		}
+		nmgmt := apb.NewNodeManagementClient(cc)
+		_, err = nmgmt.ForceNewConsensus(ctx, &apb.ForceNewConsensusRequest{
+			NodeId:            id,
+			BypassQuorumCheck: bypassQuorumCheck,
+		})
+		if err != nil {
+			return fmt.Errorf("while calling NodeManagement.ForceNewConsensus: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Consensus is restarting on node %s. Other nodes will lose their ConsensusMember role once it is back up.\n", id)
+		return nil
+	},
+}
+
+// confirmForceNewConsensus asks the user to confirm forcing a new consensus
+// cluster by typing in the node ID, and returns whether they did so.
+func confirmForceNewConsensus(ctx context.Context, in io.Reader, out io.Writer, id string) (bool, error) {
+	fmt.Fprintf(out, "Type the node ID to confirm: ")
+
+	resC := make(chan string)
+	errC := make(chan error)
+	go func() {
+		// Like the TOFU prompt, this leaks until a full line is read if the
+		// context is canceled, which is fine as metroctl exits then.
+		res, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && res != "") {
+			errC <- err
+		} else {
+			resC <- res
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case err := <-errC:
+		return false, err
+	case res := <-resC:
+		return strings.TrimSpace(res) == id, nil
+	}
+}
+
+func init() {
+	clusterForceNewConsensusCmd.Flags().Bool("bypass-quorum-check", false, "Force a new consensus cluster even if the node still sees a consensus leader")
+	clusterCmd.AddCommand(clusterForceNewConsensusCmd)
+}
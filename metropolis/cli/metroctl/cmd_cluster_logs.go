@@ -0,0 +1,283 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/proto/api"
+	"source.monogon.dev/osbase/logtree"
+	lpb "source.monogon.dev/osbase/logtree/proto"
+)
+
+var clusterLogFlags metroctlLogFlags
+
+var clusterLogsCmd = &cobra.Command{
+	Short: "Get/stream logs from all nodes of the cluster",
+	Long: `Get or stream logs from all nodes of the cluster.
+
+This requests logs from all nodes matching --filter (or all nodes of the cluster
+if not set) concurrently, and merges them into a single stream ordered by time,
+in which every entry is prefixed with the ID of the node it was logged on. All
+other flags work like for 'metroctl node logs', and are applied on every node.
+
+Entries are ordered by the timestamps assigned on the nodes, so the order is
+only as accurate as the synchronization of the nodes' clocks. Raw log lines
+carry no timestamp and are ordered after the last leveled entry of the same
+node. When streaming with --follow, new entries are held back for up to a
+second to order them with entries from other nodes.
+
+Nodes which cannot be reached are skipped with a warning.
+`,
+	Use:     "logs [--filter] [--dn] [--follow]",
+	Example: "metroctl cluster logs --dn root.enrolment --filter 'node.roles.consensus_member != null'",
+	Args:    PrintUsageOnWrongArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		req, err := clusterLogFlags.request()
+		if err != nil {
+			return err
+		}
+
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := api.NewManagementClient(cc)
+		nodes, err := core.GetNodes(ctx, mgmt, flags.filter)
+		if err != nil {
+			return fmt.Errorf("when getting node info: %w", err)
+		}
+		if len(nodes) == 0 {
+			return fmt.Errorf("no nodes matched")
+		}
+
+		cacert, err := core.GetClusterCAWithTOFU(ctx, connectOptions())
+		if err != nil {
+			return fmt.Errorf("could not get CA certificate: %w", err)
+		}
+
+		var sources []*clusterLogSource
+		for _, n := range nodes {
+			if n.Status == nil || n.Status.ExternalAddress == "" {
+				fmt.Fprintf(os.Stderr, "=== Skipping %s: node has no external address\n", n.Id)
+				continue
+			}
+			cl, err := newAuthenticatedNodeClient(ctx, n.Id, n.Status.ExternalAddress, cacert)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "=== Skipping %s: %v\n", n.Id, err)
+				continue
+			}
+			defer cl.Close()
+			sources = append(sources, &clusterLogSource{
+				node:   n.Id,
+				client: api.NewNodeManagementClient(cl),
+			})
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("none of the matched nodes can be reached")
+		}
+
+		fmt.Printf("=== Logs from %d nodes:\n", len(sources))
+		failed := mergeClusterLogs(ctx, sources, req, printClusterEntry)
+		if failed > 0 {
+			return fmt.Errorf("failed to get logs from %d of %d nodes", failed, len(sources))
+		}
+		fmt.Println("=== Done.")
+		return nil
+	},
+}
+
+// clusterLogSource is a node from which logs are requested.
+type clusterLogSource struct {
+	node   string
+	client api.NodeManagementClient
+}
+
+// clusterLogEntry is a log entry received from a node.
+type clusterLogEntry struct {
+	node  string
+	entry *lpb.LogEntry
+	// time by which the entry is ordered. For raw entries, which carry no
+	// timestamp, it is the one of the previous leveled entry of the node.
+	time time.Time
+	// seq is the order in which entries were received, which is used to order
+	// entries with the same time.
+	seq uint64
+}
+
+// clusterLogEvent is sent by the goroutine receiving logs from a node.
+type clusterLogEvent struct {
+	source  int
+	entries []*lpb.LogEntry
+	// err is set if the stream failed, or io.EOF if it ended.
+	err error
+}
+
+// clusterLogWindow is how long a node which sends no log entries holds back
+// entries from other nodes that might need to be ordered after entries which
+// it has yet to send.
+const clusterLogWindow = time.Second
+
+// clusterLogNode is the state of a node kept while merging logs.
+type clusterLogNode struct {
+	// last is the time of the newest entry received from the node.
+	last time.Time
+	// lastReceived is the local time at which the last entries were received
+	// from the node.
+	lastReceived time.Time
+	done         bool
+}
+
+// clusterLogHeap is a min-heap of entries ordered by time.
+type clusterLogHeap []*clusterLogEntry
+
+func (h clusterLogHeap) Len() int { return len(h) }
+func (h clusterLogHeap) Less(i, j int) bool {
+	if h[i].time.Equal(h[j].time) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].time.Before(h[j].time)
+}
+func (h clusterLogHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *clusterLogHeap) Push(x any)   { *h = append(*h, x.(*clusterLogEntry)) }
+func (h *clusterLogHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// mergeClusterLogs requests logs from all sources concurrently and calls emit
+// for each entry, in order of time. An entry is only emitted once every node
+// has either sent a newer entry, finished sending entries, or has not sent any
+// entries for clusterLogWindow. It returns the number of sources whose logs
+// could not be retrieved completely.
+func mergeClusterLogs(ctx context.Context, sources []*clusterLogSource, req *api.LogsRequest, emit func(e *clusterLogEntry)) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	eventC := make(chan clusterLogEvent)
+	for i, s := range sources {
+		go func() {
+			send := func(ev clusterLogEvent) bool {
+				select {
+				case eventC <- ev:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+			srv, err := s.client.Logs(ctx, req)
+			if err != nil {
+				send(clusterLogEvent{source: i, err: err})
+				return
+			}
+			for {
+				res, err := srv.Recv()
+				if err != nil {
+					send(clusterLogEvent{source: i, err: err})
+					return
+				}
+				entries := append(res.BacklogEntries, res.StreamEntries...)
+				if !send(clusterLogEvent{source: i, entries: entries}) {
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	nodes := make([]clusterLogNode, len(sources))
+	for i := range nodes {
+		nodes[i].lastReceived = start
+	}
+	var pending clusterLogHeap
+	var seq uint64
+	remaining := len(sources)
+	failed := 0
+
+	// flush emits all pending entries which cannot be preceded by entries yet
+	// to be received.
+	flush := func() {
+		now := time.Now()
+		for pending.Len() > 0 {
+			next := pending[0]
+			for _, n := range nodes {
+				if n.done || !n.last.Before(next.time) || now.Sub(n.lastReceived) >= clusterLogWindow {
+					continue
+				}
+				return
+			}
+			emit(heap.Pop(&pending).(*clusterLogEntry))
+		}
+	}
+
+	t := time.NewTicker(clusterLogWindow / 10)
+	defer t.Stop()
+	for remaining > 0 {
+		select {
+		case <-ctx.Done():
+			return failed
+		case <-t.C:
+		case ev := <-eventC:
+			n := &nodes[ev.source]
+			n.lastReceived = time.Now()
+			for _, e := range ev.entries {
+				ce := &clusterLogEntry{
+					node:  sources[ev.source].node,
+					entry: e,
+					time:  n.last,
+					seq:   seq,
+				}
+				seq++
+				if l := e.GetLeveled(); l != nil && l.Timestamp != nil {
+					ce.time = l.Timestamp.AsTime()
+				}
+				if ce.time.After(n.last) {
+					n.last = ce.time
+				}
+				heap.Push(&pending, ce)
+			}
+			if ev.err != nil {
+				n.done = true
+				remaining--
+				if !errors.Is(ev.err, io.EOF) {
+					fmt.Fprintf(os.Stderr, "=== Log stream from %s failed: %v\n", sources[ev.source].node, ev.err)
+					failed++
+				}
+			}
+		}
+		flush()
+	}
+	return failed
+}
+
+func printClusterEntry(e *clusterLogEntry) {
+	entry, err := logtree.LogEntryFromProto(e.entry)
+	if err != nil {
+		fmt.Printf("%s invalid stream entry: %v\n", e.node, err)
+		return
+	}
+	if clusterLogFlags.concise {
+		fmt.Printf("%s %s\n", e.node, entry.ConciseString(logtree.MetropolisShortenDict, 0))
+	} else {
+		fmt.Printf("%s %s\n", e.node, entry.String())
+	}
+}
+
+func init() {
+	clusterLogFlags.addFlags(clusterLogsCmd)
+	clusterCmd.AddCommand(clusterLogsCmd)
+}
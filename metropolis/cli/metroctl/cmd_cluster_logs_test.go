@@ -0,0 +1,244 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/metropolis/proto/api"
+	lpb "source.monogon.dev/osbase/logtree/proto"
+)
+
+// fakeLogsClient serves Logs from a channel of responses. The stream ends
+// once the channel is closed.
+type fakeLogsClient struct {
+	api.NodeManagementClient
+	resC chan *api.LogsResponse
+	err  error
+}
+
+func (f *fakeLogsClient) Logs(ctx context.Context, _ *api.LogsRequest, _ ...grpc.CallOption) (grpc.ServerStreamingClient[api.LogsResponse], error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &fakeLogsStream{ctx: ctx, resC: f.resC}, nil
+}
+
+type fakeLogsStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	resC chan *api.LogsResponse
+}
+
+func (f *fakeLogsStream) Recv() (*api.LogsResponse, error) {
+	select {
+	case res, ok := <-f.resC:
+		if !ok {
+			return nil, io.EOF
+		}
+		return res, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+var testLogsEpoch = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// leveled returns a leveled entry logged the given number of seconds after
+// testLogsEpoch.
+func leveled(msg string, sec int) *lpb.LogEntry {
+	return &lpb.LogEntry{
+		Dn: "test",
+		Kind: &lpb.LogEntry_Leveled_{Leveled: &lpb.LogEntry_Leveled{
+			Lines:     []string{msg},
+			Timestamp: timestamppb.New(testLogsEpoch.Add(time.Duration(sec) * time.Second)),
+			Severity:  lpb.LeveledLogSeverity_LEVELED_LOG_SEVERITY_INFO,
+		}},
+	}
+}
+
+func raw(msg string) *lpb.LogEntry {
+	return &lpb.LogEntry{
+		Dn:   "test",
+		Kind: &lpb.LogEntry_Raw_{Raw: &lpb.LogEntry_Raw{Data: msg}},
+	}
+}
+
+// entryMessage returns a message identifying an emitted entry in tests.
+func entryMessage(e *clusterLogEntry) string {
+	if r := e.entry.GetRaw(); r != nil {
+		return e.node + ":" + r.Data
+	}
+	return e.node + ":" + strings.Join(e.entry.GetLeveled().Lines, "\n")
+}
+
+// newFakeSources returns a source for each of the given node IDs, and the
+// channels through which their responses are sent.
+func newFakeSources(nodes ...string) ([]*clusterLogSource, []chan *api.LogsResponse) {
+	var sources []*clusterLogSource
+	var chans []chan *api.LogsResponse
+	for _, n := range nodes {
+		c := make(chan *api.LogsResponse)
+		sources = append(sources, &clusterLogSource{
+			node:   n,
+			client: &fakeLogsClient{resC: c},
+		})
+		chans = append(chans, c)
+	}
+	return sources, chans
+}
+
+// runMerge runs mergeClusterLogs in the background. The returned function
+// waits for it to return, and returns the emitted entries and the number of
+// failed sources.
+func runMerge(ctx context.Context, sources []*clusterLogSource) func() ([]string, int) {
+	var got []string
+	doneC := make(chan int)
+	go func() {
+		doneC <- mergeClusterLogs(ctx, sources, &api.LogsRequest{}, func(e *clusterLogEntry) {
+			got = append(got, entryMessage(e))
+		})
+	}()
+	return func() ([]string, int) {
+		failed := <-doneC
+		return got, failed
+	}
+}
+
+// TestMergeClusterLogsOrder ensures that backlogs from multiple nodes are
+// merged in order of time.
+func TestMergeClusterLogsOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sources, chans := newFakeSources("a", "b", "c")
+	wait := runMerge(ctx, sources)
+
+	chans[0] <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled("1", 1), leveled("4", 4), leveled("7", 7)}}
+	chans[1] <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled("2", 2), leveled("5", 5)}}
+	chans[2] <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled("3", 3), leveled("6", 6), leveled("8", 8)}}
+	for _, c := range chans {
+		close(c)
+	}
+
+	got, failed := wait()
+	if failed != 0 {
+		t.Errorf("%d sources failed, wanted none", failed)
+	}
+	want := "a:1,b:2,c:3,a:4,b:5,c:6,a:7,c:8"
+	if got := strings.Join(got, ","); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+// TestMergeClusterLogsTies ensures that entries with the same time keep the
+// order in which they were logged on their node, and that raw entries are
+// ordered after the preceding leveled entry of their node.
+func TestMergeClusterLogsTies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sources, chans := newFakeSources("a", "b")
+	wait := runMerge(ctx, sources)
+
+	chans[0] <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled("1", 1), raw("raw1"), leveled("2", 1), raw("raw2"), leveled("3", 3)}}
+	chans[1] <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled("1", 1), leveled("2", 1), leveled("3", 2)}}
+	for _, c := range chans {
+		close(c)
+	}
+
+	got, failed := wait()
+	if failed != 0 {
+		t.Errorf("%d sources failed, wanted none", failed)
+	}
+	if want := 8; len(got) != want {
+		t.Fatalf("wanted %d entries, got %q", want, got)
+	}
+	// Entries of the same node keep their order.
+	for _, node := range []string{"a", "b"} {
+		var own []string
+		for _, e := range got {
+			if strings.HasPrefix(e, node+":") {
+				own = append(own, e)
+			}
+		}
+		want := map[string]string{
+			"a": "a:1,a:raw1,a:2,a:raw2,a:3",
+			"b": "b:1,b:2,b:3",
+		}[node]
+		if got := strings.Join(own, ","); want != got {
+			t.Errorf("node %s: wanted %q, got %q", node, want, got)
+		}
+	}
+	// The entries at second 1 of both nodes come before the rest.
+	for i, e := range got[:6] {
+		if e == "b:3" || e == "a:3" {
+			t.Errorf("entry %d is %q, wanted an entry logged at second 1", i, e)
+		}
+	}
+	if want, got := "b:3,a:3", strings.Join(got[6:], ","); want != got {
+		t.Errorf("wanted last entries %q, got %q", want, got)
+	}
+}
+
+// TestMergeClusterLogsStream ensures that entries streamed by one node are held
+// back until all other nodes have sent newer entries, so that entries streamed
+// by multiple nodes are interleaved in order of time.
+func TestMergeClusterLogsStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sources, chans := newFakeSources("a", "b")
+	wait := runMerge(ctx, sources)
+
+	chans[0] <- &api.LogsResponse{StreamEntries: []*lpb.LogEntry{leveled("1", 1), leveled("3", 3)}}
+	chans[1] <- &api.LogsResponse{StreamEntries: []*lpb.LogEntry{leveled("2", 2)}}
+	chans[0] <- &api.LogsResponse{StreamEntries: []*lpb.LogEntry{leveled("5", 5)}}
+	chans[1] <- &api.LogsResponse{StreamEntries: []*lpb.LogEntry{leveled("4", 4)}}
+	chans[1] <- &api.LogsResponse{StreamEntries: []*lpb.LogEntry{leveled("6", 6)}}
+	for _, c := range chans {
+		close(c)
+	}
+
+	got, failed := wait()
+	if failed != 0 {
+		t.Errorf("%d sources failed, wanted none", failed)
+	}
+	want := "a:1,b:2,a:3,b:4,a:5,b:6"
+	if got := strings.Join(got, ","); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+// TestMergeClusterLogsFailure ensures that failed sources are counted and do
+// not hold back entries from other nodes.
+func TestMergeClusterLogsFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sources, chans := newFakeSources("a", "b")
+	sources = append(sources, &clusterLogSource{
+		node:   "c",
+		client: &fakeLogsClient{err: errors.New("unreachable")},
+	})
+	wait := runMerge(ctx, sources)
+
+	for i, c := range chans {
+		c <- &api.LogsResponse{BacklogEntries: []*lpb.LogEntry{leveled(fmt.Sprint(i), i)}}
+		close(c)
+	}
+
+	got, failed := wait()
+	if want := 1; failed != want {
+		t.Errorf("%d sources failed, wanted %d", failed, want)
+	}
+	if want, got := "a:0,b:1", strings.Join(got, ","); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
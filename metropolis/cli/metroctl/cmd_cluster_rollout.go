@@ -0,0 +1,199 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"source.monogon.dev/go/clitable"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var clusterRolloutCmd = &cobra.Command{
+	Short: "Manages cluster-wide OS updates.",
+	Use:   "rollout",
+}
+
+var clusterRolloutStartCmd = &cobra.Command{
+	Short: "Starts a cluster-wide OS update.",
+	Long: `Starts a cluster-wide OS update.
+
+The update is carried out by the cluster itself: nodes are updated in batches
+of at most --max-unavailable nodes, and the cluster waits for every node to come
+back healthy before proceeding. Consensus members are always updated one at a
+time. The set of updated nodes can be limited with --filter.`,
+	Use:     "start --image-ref <ref> [--activation-mode] [--max-unavailable] [--health-timeout] [--filter]",
+	Example: "metroctl cluster rollout start --image-ref registry.example/monogon-os/node:0.1-amd64@sha256:345db5d8fc468218c5232bf54a1358b6825c28d658fa12c9a1edcc7539690686",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		imageRef, err := cmd.Flags().GetString("image-ref")
+		if err != nil {
+			return err
+		}
+		if len(imageRef) == 0 {
+			return fmt.Errorf("flag image-ref is required")
+		}
+		osImage, err := parseImageRef(imageRef)
+		if err != nil {
+			return fmt.Errorf("invalid image-ref: %w", err)
+		}
+
+		activationMode, err := cmd.Flags().GetString("activation-mode")
+		if err != nil {
+			return err
+		}
+		var am apb.ActivationMode
+		switch strings.ToLower(activationMode) {
+		case "reboot":
+			am = apb.ActivationMode_ACTIVATION_MODE_REBOOT
+		case "kexec":
+			am = apb.ActivationMode_ACTIVATION_MODE_KEXEC
+		default:
+			return fmt.Errorf("invalid value for flag activation-mode")
+		}
+
+		maxUnavailable, err := cmd.Flags().GetUint32("max-unavailable")
+		if err != nil {
+			return err
+		}
+		healthTimeout, err := cmd.Flags().GetDuration("health-timeout")
+		if err != nil {
+			return err
+		}
+		healthGateTimeout, err := cmd.Flags().GetDuration("health-gate-timeout")
+		if err != nil {
+			return err
+		}
+
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		policy := &apb.RolloutPolicy{
+			MaxUnavailable: maxUnavailable,
+			ActivationMode: am,
+			HealthTimeout:  durationpb.New(healthTimeout),
+		}
+		if healthGateTimeout != 0 {
+			policy.HealthGateTimeout = durationpb.New(healthGateTimeout)
+		}
+		res, err := mgmt.StartRollout(ctx, &apb.StartRolloutRequest{
+			OsImage: osImage,
+			Policy:  policy,
+			Filter:  flags.filter,
+		})
+		if err != nil {
+			return fmt.Errorf("while calling Management.StartRollout: %w", err)
+		}
+		log.Printf("Started rollout %s on %d nodes.", res.Rollout.Id, len(res.Rollout.Nodes))
+		return nil
+	},
+	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+}
+
+var clusterRolloutStatusCmd = &cobra.Command{
+	Short: "Shows the progress of the current cluster-wide OS update.",
+	Use:   "status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		res, err := mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+		if err != nil {
+			return fmt.Errorf("while calling Management.GetRollout: %w", err)
+		}
+		return printRollout(res.Rollout)
+	},
+	Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+}
+
+// newClusterRolloutPauseCmd returns the command pausing (or, if paused is
+// false, resuming) the current rollout.
+func newClusterRolloutPauseCmd(paused bool) *cobra.Command {
+	use, short := "pause", "Pauses the current cluster-wide OS update."
+	if !paused {
+		use, short = "resume", "Resumes the current cluster-wide OS update."
+	}
+	return &cobra.Command{
+		Short: short,
+		Use:   use,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+			cc, err := newAuthenticatedClient(ctx)
+			if err != nil {
+				return fmt.Errorf("while creating client: %w", err)
+			}
+			mgmt := apb.NewManagementClient(cc)
+
+			res, err := mgmt.PauseRollout(ctx, &apb.PauseRolloutRequest{
+				Paused: paused,
+			})
+			if err != nil {
+				return fmt.Errorf("while calling Management.PauseRollout: %w", err)
+			}
+			log.Printf("Rollout %s is now %s.", res.Rollout.Id, rolloutStateString(res.Rollout.State))
+			return nil
+		},
+		Args: PrintUsageOnWrongArgs(cobra.NoArgs),
+	}
+}
+
+func rolloutStateString(s apb.Rollout_State) string {
+	return strings.ToLower(strings.TrimPrefix(s.String(), "STATE_"))
+}
+
+func printRollout(ro *apb.Rollout) error {
+	if ro == nil {
+		log.Printf("No rollout.")
+		return nil
+	}
+	fmt.Printf("Rollout %s: %s\n", ro.Id, rolloutStateString(ro.State))
+	img := ro.OsImage
+	fmt.Printf("Image: %s://%s/%s:%s@%s\n", img.Scheme, img.Host, img.Repository, img.Tag, img.Digest)
+	fmt.Printf("Started: %s\n\n", ro.Created.AsTime().Format(time.RFC3339))
+
+	var t clitable.Table
+	for _, n := range ro.Nodes {
+		e := clitable.Entry{}
+		e.Add("node id", n.Id)
+		e.Add("state", strings.TrimPrefix(n.State.String(), "STATE_"))
+		if n.Started != nil {
+			e.Add("started", n.Started.AsTime().Format(time.RFC3339))
+		}
+		e.Add("message", n.Message)
+		t.Add(e)
+	}
+	t.Print(os.Stdout, nil)
+	return nil
+}
+
+func init() {
+	clusterRolloutStartCmd.Flags().String("image-ref", "", "Reference to the new version stored in an OCI registry, in the format [http[s]://]host[:port]/repository[:tag]@digest")
+	clusterRolloutStartCmd.Flags().String("activation-mode", "reboot", "How the update should be activated (kexec, reboot)")
+	clusterRolloutStartCmd.Flags().Uint32("max-unavailable", 1, "Maximum nodes which can be unavailable during the update process")
+	clusterRolloutStartCmd.Flags().Duration("health-timeout", 15*time.Minute, "Time a node has to come back healthy after being instructed to update")
+	clusterRolloutStartCmd.Flags().Duration("health-gate-timeout", 0, "Time after which nodes roll back the update if they did not become healthy (default 10m)")
+
+	clusterRolloutCmd.AddCommand(clusterRolloutStartCmd)
+	clusterRolloutCmd.AddCommand(clusterRolloutStatusCmd)
+	clusterRolloutCmd.AddCommand(newClusterRolloutPauseCmd(true))
+	clusterRolloutCmd.AddCommand(newClusterRolloutPauseCmd(false))
+	clusterCmd.AddCommand(clusterRolloutCmd)
+}
@@ -0,0 +1,85 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+
+	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var clusterUnlockRestoreCmd = &cobra.Command{
+	Short: "Submits the snapshot key to a node restoring a cluster.",
+	Long: `Submits the snapshot key to a node restoring a cluster.
+
+A node installed with metroctl install --restore only carries the encrypted
+snapshot, and waits for its key to be submitted with this command before
+restoring the cluster. The key file is the one given to metroctl install
+--restore-key.
+
+The node has no cluster identity yet, so it cannot be authenticated. The
+connection is encrypted, but an attacker able to intercept it could obtain the
+key. Only use this command on a trusted network.`,
+	Use:     "unlock-restore <address> <key-file>",
+	Example: "metroctl cluster unlock-restore 10.0.0.2 cluster.restore-key",
+	Args:    PrintUsageOnWrongArgs(cobra.ExactArgs(2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		address := args[0]
+		key, err := readBackupKey(args[1])
+		if err != nil {
+			return err
+		}
+
+		// The node does not check the client certificate, use a throwaway
+		// key.
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("while generating key: %w", err)
+		}
+		creds, err := rpc.NewEphemeralCredentials(priv, rpc.WantInsecure())
+		if err != nil {
+			return fmt.Errorf("while creating credentials: %w", err)
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if flags.proxyAddr != "" {
+			socksDialer, err := proxy.SOCKS5("tcp", flags.proxyAddr, nil, proxy.Direct)
+			if err != nil {
+				return fmt.Errorf("failed to build a SOCKS dialer: %w", err)
+			}
+			opts = append(opts, grpc.WithContextDialer(func(_ context.Context, addr string) (net.Conn, error) {
+				return socksDialer.Dial("tcp", addr)
+			}))
+		}
+		cc, err := grpc.NewClient(net.JoinHostPort(address, node.RestoreUnlockPort.PortString()), opts...)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		defer cc.Close()
+
+		_, err = apb.NewRestoreUnlockClient(cc).Unlock(ctx, &apb.UnlockRequest{Key: key})
+		if err != nil {
+			return fmt.Errorf("while calling RestoreUnlock.Unlock: %w", err)
+		}
+		log.Printf("Node %s unlocked, restoring cluster.", address)
+		return nil
+	},
+}
+
+func init() {
+	clusterCmd.AddCommand(clusterUnlockRestoreCmd)
+}
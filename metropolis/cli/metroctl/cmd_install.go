@@ -6,8 +6,12 @@ package main
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/rand"
 	_ "embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 
@@ -21,6 +25,7 @@ import (
 	"source.monogon.dev/metropolis/cli/flagdefs"
 	"source.monogon.dev/metropolis/cli/metroctl/core"
 	common "source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/backup"
 	"source.monogon.dev/osbase/structfs"
 )
 
@@ -39,6 +44,12 @@ var bootstrapStorageSecurityPolicy = flagdefs.StorageSecurityPolicyPflag(install
 var imagePath = installCmd.PersistentFlags().StringP("image", "", "", "Path to the OCI layout directory containing the Metropolis OS image to be installed")
 var nodeParamPath = installCmd.PersistentFlags().String("node-params", "", "Path to the metropolis.proto.api.NodeParameters prototext file (advanced usage only)")
 
+// restore is a flag controlling node parameters included in the installer
+// image. If set, the installed node will restore a cluster from the given
+// snapshot, as created by metroctl cluster backup, or from a periodic backup.
+var restore = installCmd.PersistentFlags().String("restore", "", "Create a disaster recovery installer image restoring the cluster from the given snapshot or periodic backup file.")
+var restoreKey = installCmd.PersistentFlags().String("restore-key", "", "Path to the key file with which the node is unlocked by metroctl cluster unlock-restore. For periodic backups, the backup key file as saved by metroctl cluster backup-key. For snapshots, a new key is generated and saved to this path.")
+
 func makeNodeParams() (*api.NodeParameters, error) {
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
 
@@ -59,7 +70,70 @@ func makeNodeParams() (*api.NodeParameters, error) {
 		params = &api.NodeParameters{}
 	}
 
-	if *bootstrap {
+	if *restore != "" {
+		if *bootstrap {
+			return nil, fmt.Errorf("--bootstrap and --restore cannot be used together")
+		}
+		snapshot, err := os.ReadFile(*restore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		if *restoreKey == "" {
+			return nil, fmt.Errorf("--restore-key is required with --restore")
+		}
+		// The snapshot is only stored encrypted on the installation medium,
+		// the node waits for the key to be submitted with metroctl cluster
+		// unlock-restore. Periodic backups are already encrypted with the
+		// backup key, snapshots taken with metroctl cluster backup are
+		// encrypted with a new key.
+		if backup.IsEncrypted(snapshot) {
+			key, err := readBackupKey(*restoreKey)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := backup.Decrypt(key, snapshot); err != nil {
+				return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+			}
+		} else {
+			key := make([]byte, backup.KeySize)
+			if _, err := rand.Read(key); err != nil {
+				return nil, fmt.Errorf("failed to generate restore key: %w", err)
+			}
+			f, err := os.OpenFile(*restoreKey, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create restore key file: %w", err)
+			}
+			_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to save restore key: %w", err)
+			}
+			snapshot, err = backup.Encrypt(key, snapshot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt snapshot: %w", err)
+			}
+			log.Printf("Saved restore key to %s", *restoreKey)
+		}
+		// Make the node verify that the snapshot belongs to the cluster we
+		// know, if any.
+		var caCertificate []byte
+		ca, err := core.GetClusterCA(flags.configPath)
+		switch {
+		case err == nil:
+			caCertificate = ca.Raw
+		case errors.Is(err, core.ErrNoCACertificate):
+		default:
+			return nil, fmt.Errorf("failed to get cluster CA: %w", err)
+		}
+		params.Cluster = &api.NodeParameters_ClusterRestore_{
+			ClusterRestore: &api.NodeParameters_ClusterRestore{
+				EncryptedSnapshot: snapshot,
+				CaCertificate:     caCertificate,
+			},
+		}
+	} else if *bootstrap {
 		if flags.cluster == "" {
 			return nil, fmt.Errorf("when bootstrapping a cluster, the --cluster parameter is required")
 		}
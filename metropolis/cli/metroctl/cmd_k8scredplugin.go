@@ -4,18 +4,21 @@
 package main
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 
 	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/node/core/identity"
 )
 
 var k8scredpluginCmd = &cobra.Command{
@@ -27,7 +30,8 @@ cluster. This should never be directly called by end users.`,
 	Args:   PrintUsageOnWrongArgs(cobra.ExactArgs(0)),
 	Hidden: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cert, key, err := core.GetOwnerCredentials(flags.configPath)
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cert, key, err := getOwnerCredentials(ctx)
 		if errors.Is(err, core.ErrNoCredentials) {
 			return fmt.Errorf("no credentials found on your machine")
 		}
@@ -49,6 +53,9 @@ cluster. This should never be directly called by end users.`,
 			Status: &clientauthentication.ExecCredentialStatus{
 				ClientCertificateData: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
 				ClientKeyData:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Key})),
+				// Makes client-go call the plugin again once the certificate is
+				// due for renewal.
+				ExpirationTimestamp: &metav1.Time{Time: identity.RenewalTime(cert)},
 			},
 		}
 		if err := json.NewEncoder(os.Stdout).Encode(cred); err != nil {
@@ -16,6 +16,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"source.monogon.dev/go/clitable"
 	"source.monogon.dev/metropolis/cli/metroctl/core"
@@ -205,10 +208,18 @@ var nodeUpdateCmd = &cobra.Command{
 			excludedNodes[n] = true
 		}
 
+		healthGateTimeout, err := cmd.Flags().GetDuration("health-gate-timeout")
+		if err != nil {
+			return err
+		}
+
 		updateReq := &apb.UpdateNodeRequest{
 			OsImage:        osImage,
 			ActivationMode: am,
 		}
+		if healthGateTimeout != 0 {
+			updateReq.HealthGateTimeout = durationpb.New(healthGateTimeout)
+		}
 
 		var wg sync.WaitGroup
 
@@ -239,10 +250,15 @@ var nodeUpdateCmd = &cobra.Command{
 				start := time.Now()
 				_, err = nodeMgmt.UpdateNode(ctx, updateReq)
 				if err != nil {
-					log.Printf("update request to node %s failed: %v", n.Id, err)
+					if status.Code(err) == codes.PermissionDenied {
+						log.Printf("node %s rejected the OS image: %s", n.Id, status.Convert(err).Message())
+					} else {
+						log.Printf("update request to node %s failed: %v", n.Id, err)
+					}
 					// A failed UpdateNode does not mean that the node is now unavailable as it
 					// hasn't started activating yet.
 					unavailableSemaphore.Release(1)
+					return
 				}
 				// Wait for the internal activation sleep plus the heartbeat
 				// to make sure the node has missed one heartbeat (or is
@@ -483,6 +499,7 @@ func init() {
 	nodeUpdateCmd.Flags().String("activation-mode", "reboot", "How the update should be activated (kexec, reboot, none)")
 	nodeUpdateCmd.Flags().Uint64("max-unavailable", 1, "Maximum nodes which can be unavailable during the update process")
 	nodeUpdateCmd.Flags().StringArray("exclude", nil, "List of nodes to exclude (useful with the \"all\" argument)")
+	nodeUpdateCmd.Flags().Duration("health-gate-timeout", 0, "Time after which nodes roll back the update if they did not become healthy (default 10m)")
 
 	nodeDeleteCmd.Flags().Bool("bypass-has-roles", false, "Allows to bypass the HasRoles check")
 	nodeDeleteCmd.Flags().Bool("bypass-not-decommissioned", false, "Allows to bypass the NotDecommissioned check")
@@ -7,8 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"source.monogon.dev/metropolis/cli/metroctl/core"
 	"source.monogon.dev/metropolis/proto/api"
@@ -28,6 +32,14 @@ type metroctlLogFlags struct {
 	concise bool
 	// backlog: >0 for a concrete limit, -1 for all, 0 for none
 	backlog int
+	// boot ID to get logs from, or empty for the current boot.
+	boot string
+	// since and until limit logs to a time range, each given as either a
+	// timestamp or a duration before now.
+	since string
+	until string
+	// grep is a regexp which log lines must match.
+	grep string
 }
 
 var logFlags metroctlLogFlags
@@ -55,12 +67,28 @@ By default, all available logs are returned. To limit the number of historical
 log lines (a.k.a. 'backlog') to return, set --backlog. This similar to requesting
 all lines and then piping the result through 'tail' - but more efficient, as no
 unnecessary lines are fetched.
+
+Logs from previous boots of the node are persisted on the node and can be
+requested by passing the boot ID (as reported in the node status when the boot
+was current) with --boot. These logs cannot be streamed.
+
+To only get logs from a time range, set --since and/or --until, either to an
+RFC 3339 timestamp or to a duration before now (eg. 10m). As raw log lines
+(eg. from external processes) carry no timestamps, only leveled logs are
+returned then. To only get log lines matching a regular expression, set --grep.
+Both are evaluated on the node, and --backlog then limits the number of
+matching lines.
 `,
 	Use:  "logs [node-id]",
 	Args: PrintUsageOnWrongArgs(cobra.MinimumNArgs(1)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 
+		req, err := logFlags.request()
+		if err != nil {
+			return err
+		}
+
 		// First connect to the main management service and figure out the node's IP
 		// address.
 		cc, err := newAuthenticatedClient(ctx)
@@ -97,35 +125,7 @@ unnecessary lines are fetched.
 		}
 		nmgmt := api.NewNodeManagementClient(cl)
 
-		streamMode := api.LogsRequest_STREAM_MODE_DISABLE
-		if logFlags.follow {
-			streamMode = api.LogsRequest_STREAM_MODE_UNBUFFERED
-		}
-		var filters []*cpb.LogFilter
-		if !logFlags.exact {
-			filters = append(filters, &cpb.LogFilter{
-				Filter: &cpb.LogFilter_WithChildren_{
-					WithChildren: &cpb.LogFilter_WithChildren{},
-				},
-			})
-		}
-		backlogMode := api.LogsRequest_BACKLOG_MODE_ALL
-		var backlogCount int64
-		switch {
-		case logFlags.backlog > 0:
-			backlogMode = api.LogsRequest_BACKLOG_MODE_COUNT
-			backlogCount = int64(logFlags.backlog)
-		case logFlags.backlog == 0:
-			backlogMode = api.LogsRequest_BACKLOG_MODE_DISABLE
-		}
-
-		srv, err := nmgmt.Logs(ctx, &api.LogsRequest{
-			Dn:           logFlags.dn,
-			BacklogMode:  backlogMode,
-			BacklogCount: backlogCount,
-			StreamMode:   streamMode,
-			Filters:      filters,
-		})
+		srv, err := nmgmt.Logs(ctx, req)
 		if err != nil {
 			return fmt.Errorf("failed to get logs: %w", err)
 		}
@@ -150,6 +150,101 @@ unnecessary lines are fetched.
 	},
 }
 
+// request builds a LogsRequest as specified by the flags.
+func (f *metroctlLogFlags) request() (*api.LogsRequest, error) {
+	req := &api.LogsRequest{
+		Dn:          f.dn,
+		BacklogMode: api.LogsRequest_BACKLOG_MODE_ALL,
+		StreamMode:  api.LogsRequest_STREAM_MODE_DISABLE,
+	}
+	if f.follow {
+		req.StreamMode = api.LogsRequest_STREAM_MODE_UNBUFFERED
+	}
+	switch {
+	case f.backlog > 0:
+		req.BacklogMode = api.LogsRequest_BACKLOG_MODE_COUNT
+		req.BacklogCount = int64(f.backlog)
+	case f.backlog == 0:
+		req.BacklogMode = api.LogsRequest_BACKLOG_MODE_DISABLE
+	}
+	if f.boot != "" {
+		id, err := uuid.Parse(f.boot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boot ID: %w", err)
+		}
+		req.BootId = id[:]
+	}
+
+	if !f.exact {
+		req.Filters = append(req.Filters, &cpb.LogFilter{
+			Filter: &cpb.LogFilter_WithChildren_{
+				WithChildren: &cpb.LogFilter_WithChildren{},
+			},
+		})
+	}
+	now := time.Now()
+	if f.since != "" || f.until != "" {
+		tr := &cpb.LogFilter_TimeRange{}
+		if f.since != "" {
+			t, err := parseLogTime(f.since, now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --since: %w", err)
+			}
+			tr.Since = timestamppb.New(t)
+		}
+		if f.until != "" {
+			t, err := parseLogTime(f.until, now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --until: %w", err)
+			}
+			tr.Until = timestamppb.New(t)
+		}
+		req.Filters = append(req.Filters, &cpb.LogFilter{
+			Filter: &cpb.LogFilter_TimeRange_{
+				TimeRange: tr,
+			},
+		})
+	}
+	if f.grep != "" {
+		if _, err := regexp.Compile(f.grep); err != nil {
+			return nil, fmt.Errorf("invalid --grep: %w", err)
+		}
+		req.Filters = append(req.Filters, &cpb.LogFilter{
+			Filter: &cpb.LogFilter_MessageRegexp_{
+				MessageRegexp: &cpb.LogFilter_MessageRegexp{
+					Regexp: f.grep,
+				},
+			},
+		})
+	}
+	return req, nil
+}
+
+// addFlags registers the flags common to all commands retrieving logs.
+func (f *metroctlLogFlags) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&f.follow, "follow", "f", false, "Continue streaming logs after fetching backlog.")
+	cmd.Flags().StringVar(&f.dn, "dn", "", "Distinguished Name to get logs from (and children, if --exact is not set). If not set, defaults to '', which is the top-level DN.")
+	cmd.Flags().BoolVarP(&f.exact, "exact", "e", false, "Only show logs for exactly the DN, do not recurse down the tree.")
+	cmd.Flags().BoolVarP(&f.concise, "concise", "c", false, "Output concise logs.")
+	cmd.Flags().IntVar(&f.backlog, "backlog", -1, "How many lines of historical log data to return. The default (-1) returns all available lines. Zero value means no backlog is returned (useful when using --follow).")
+	cmd.Flags().StringVar(&f.since, "since", "", "Only return logs at or after this time, given as an RFC 3339 timestamp or a duration before now (eg. 10m).")
+	cmd.Flags().StringVar(&f.until, "until", "", "Only return logs before this time, given as an RFC 3339 timestamp or a duration before now (eg. 10m).")
+	cmd.Flags().StringVar(&f.grep, "grep", "", "Only return log lines matching this regular expression.")
+}
+
+// parseLogTime parses a point in time given as either an RFC 3339 timestamp or
+// a duration before now.
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a duration", value)
+	}
+	return now.Add(-d), nil
+}
+
 func printEntry(e *lpb.LogEntry) {
 	entry, err := logtree.LogEntryFromProto(e)
 	if err != nil {
@@ -164,10 +259,7 @@ func printEntry(e *lpb.LogEntry) {
 }
 
 func init() {
-	nodeLogsCmd.Flags().BoolVarP(&logFlags.follow, "follow", "f", false, "Continue streaming logs after fetching backlog.")
-	nodeLogsCmd.Flags().StringVar(&logFlags.dn, "dn", "", "Distinguished Name to get logs from (and children, if --exact is not set). If not set, defaults to '', which is the top-level DN.")
-	nodeLogsCmd.Flags().BoolVarP(&logFlags.exact, "exact", "e", false, "Only show logs for exactly the DN, do not recurse down the tree.")
-	nodeLogsCmd.Flags().BoolVarP(&logFlags.concise, "concise", "c", false, "Output concise logs.")
-	nodeLogsCmd.Flags().IntVar(&logFlags.backlog, "backlog", -1, "How many lines of historical log data to return. The default (-1) returns all available lines. Zero value means no backlog is returned (useful when using --follow).")
+	logFlags.addFlags(nodeLogsCmd)
+	nodeLogsCmd.Flags().StringVar(&logFlags.boot, "boot", "", "ID of a previous boot to get logs from. If not set, logs from the current boot are returned.")
 	nodeCmd.AddCommand(nodeLogsCmd)
 }
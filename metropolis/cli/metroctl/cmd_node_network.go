@@ -0,0 +1,89 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	netpb "source.monogon.dev/osbase/net/proto"
+)
+
+var nodeSetNetworkCmd = &cobra.Command{
+	Short: "Change the network configuration of a node",
+	Long: `Change the network configuration of a node at runtime.
+
+The new configuration is read from an osbase.net.proto.Net prototext file given
+with --config, or network autoconfiguration is used if --autoconfigure is set.
+
+The node applies the new configuration live and then has to reach the cluster
+within --confirm-timeout. Otherwise, it reverts to its previous configuration.
+Only a confirmed configuration is persisted and used on subsequent boots.
+
+As the connection to the node might be interrupted by the new configuration,
+this command can fail even if the configuration was accepted by the node.
+	`,
+	Use:          "set-network [node-id] [--config|--autoconfigure]",
+	Example:      "metroctl node set-network metropolis-c556e31c3fa2bf0a36e9ccb9fd5d6056 --config net.txtpb",
+	Args:         PrintUsageOnWrongArgs(cobra.ExactArgs(1)),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			return err
+		}
+		autoconfigure, err := cmd.Flags().GetBool("autoconfigure")
+		if err != nil {
+			return err
+		}
+		confirmTimeout, err := cmd.Flags().GetDuration("confirm-timeout")
+		if err != nil {
+			return err
+		}
+		if (configPath == "") == !autoconfigure {
+			return fmt.Errorf("exactly one of --config and --autoconfigure must be set")
+		}
+
+		req := apb.SetNetworkConfigRequest{}
+		if configPath != "" {
+			configRaw, err := os.ReadFile(configPath)
+			if err != nil {
+				return fmt.Errorf("failed to read network configuration: %w", err)
+			}
+			var config netpb.Net
+			if err := prototext.Unmarshal(configRaw, &config); err != nil {
+				return fmt.Errorf("failed to parse network configuration: %w", err)
+			}
+			req.NetworkConfig = &config
+		}
+		if confirmTimeout != 0 {
+			req.ConfirmTimeout = durationpb.New(confirmTimeout)
+		}
+
+		nmgmt, err := newNodeClient(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to create node client: %w", err)
+		}
+		if _, err := nmgmt.SetNetworkConfig(ctx, &req); err != nil {
+			return fmt.Errorf("SetNetworkConfig RPC failed: %w", err)
+		}
+		log.Printf("Node %v accepted the new network configuration", args[0])
+		return nil
+	},
+}
+
+func init() {
+	nodeSetNetworkCmd.Flags().String("config", "", "Path to the osbase.net.proto.Net prototext file containing the new network configuration")
+	nodeSetNetworkCmd.Flags().Bool("autoconfigure", false, "Use network autoconfiguration instead of a static configuration")
+	nodeSetNetworkCmd.Flags().Duration("confirm-timeout", 0, "Time within which the node must reach the cluster with the new configuration before reverting it (default 1m)")
+	nodeCmd.AddCommand(nodeSetNetworkCmd)
+}
@@ -0,0 +1,117 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/proto/api"
+)
+
+var nodeRunnablesDN string
+
+var nodeRunnablesCmd = &cobra.Command{
+	Short: "Show the supervision tree of a node",
+	Long: `Show the supervision tree of a node.
+
+All services on a node are runnables in a supervision tree, which are restarted
+by their supervisor whenever they fail. This shows every runnable in the tree
+along with its current state, the number of times it has been restarted since
+the node booted, the time since it has reached its current state, and the error
+with which it last failed. A runnable which is frequently restarted or keeps
+being in the DEAD state is likely crash-looping, and its logs can be inspected
+using 'metroctl node logs --dn <dn>'.
+
+To only show a subtree, set --dn.
+`,
+	Use:     "runnables [node-id]",
+	Example: "metroctl node runnables metropolis-c556e31c3fa2bf0a36e9ccb9fd5d6056 --dn root.role",
+	Args:    PrintUsageOnWrongArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := api.NewManagementClient(cc)
+		nodes, err := core.GetNodes(ctx, mgmt, fmt.Sprintf("node.id == %q", args[0]))
+		if err != nil {
+			return fmt.Errorf("when getting node info: %w", err)
+		}
+
+		if len(nodes) == 0 {
+			return fmt.Errorf("no such node")
+		}
+		if len(nodes) > 1 {
+			return fmt.Errorf("expression matched more than one node")
+		}
+		n := nodes[0]
+		if n.Status == nil || n.Status.ExternalAddress == "" {
+			return fmt.Errorf("node has no external address")
+		}
+
+		cacert, err := core.GetClusterCAWithTOFU(ctx, connectOptions())
+		if err != nil {
+			return fmt.Errorf("could not get CA certificate: %w", err)
+		}
+		cl, err := newAuthenticatedNodeClient(ctx, n.Id, n.Status.ExternalAddress, cacert)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		defer cl.Close()
+		nmgmt := api.NewNodeManagementClient(cl)
+
+		res, err := nmgmt.GetRunnables(ctx, &api.GetRunnablesRequest{
+			Dn: nodeRunnablesDN,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get runnables: %w", err)
+		}
+		printRunnables(res.Runnables)
+		return nil
+	},
+}
+
+// printRunnables prints runnables as a tree, with every runnable indented
+// below its parent. The runnables must be sorted as returned by
+// NodeManagement.GetRunnables.
+func printRunnables(runnables []*api.GetRunnablesResponse_Runnable) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "RUNNABLE\tSTATE\tRESTARTS\tSINCE\tLAST ERROR\n")
+	// Indent relative to the least deep runnable, which is the root of the
+	// returned subtree.
+	minDepth := -1
+	for _, r := range runnables {
+		if d := strings.Count(r.Dn, "."); minDepth == -1 || d < minDepth {
+			minDepth = d
+		}
+	}
+	for _, r := range runnables {
+		parts := strings.Split(r.Dn, ".")
+		name := strings.Repeat("  ", len(parts)-1-minDepth) + parts[len(parts)-1]
+		if len(parts)-1 == minDepth {
+			name = r.Dn
+		}
+		state := strings.TrimPrefix(r.State.String(), "STATE_")
+		since := r.TimeInState.AsDuration().Round(time.Second)
+		// Only the first line of the error is shown, as the table would be
+		// unreadable otherwise.
+		lastError, _, _ := strings.Cut(r.LastError, "\n")
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", name, state, r.Restarts, since, lastError)
+	}
+	w.Flush()
+}
+
+func init() {
+	nodeRunnablesCmd.Flags().StringVar(&nodeRunnablesDN, "dn", "", "Only show the subtree of runnables at this DN")
+	nodeCmd.AddCommand(nodeRunnablesCmd)
+}
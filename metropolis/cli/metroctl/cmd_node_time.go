@@ -0,0 +1,59 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+var nodeSetTimeCmd = &cobra.Command{
+	Short: "Override the NTP servers of a node",
+	Long: `Override the NTP servers of a node.
+
+The node synchronizes its clock with the given NTP servers (IP addresses or
+host names) instead of the time sources from the cluster configuration. If no
+servers are given, the override is removed and the node uses the cluster
+configuration again.
+	`,
+	Use:          "set-time [node-id] [ntp-server, ...]",
+	Example:      "metroctl node set-time metropolis-c556e31c3fa2bf0a36e9ccb9fd5d6056 192.0.2.1 ntp.example.com",
+	Args:         PrintUsageOnWrongArgs(cobra.MinimumNArgs(1)),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		cc, err := newAuthenticatedClient(ctx)
+		if err != nil {
+			return fmt.Errorf("while creating client: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		_, err = mgmt.UpdateNodeTimeConfiguration(ctx, &apb.UpdateNodeTimeConfigurationRequest{
+			Node: &apb.UpdateNodeTimeConfigurationRequest_Id{
+				Id: args[0],
+			},
+			TimeConfiguration: &cpb.NodeTimeConfiguration{
+				NtpServers: args[1:],
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("UpdateNodeTimeConfiguration RPC failed: %w", err)
+		}
+		if len(args) == 1 {
+			log.Printf("Removed NTP server override of node %s.", args[0])
+		} else {
+			log.Printf("Updated NTP servers of node %s.", args[0])
+		}
+		return nil
+	},
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeSetTimeCmd)
+}
@@ -82,6 +82,36 @@ func WriteOwnerKey(path string, priv ed25519.PrivateKey) error {
 	return nil
 }
 
+// RotateOwnerKey generates a new owner key and calls rotate with its public
+// key, which is expected to make it the owner key of the cluster. If rotate
+// succeeds, the new key replaces the owner key at a given metroctl
+// configuration directory path, and the owner certificate of the previous key
+// is removed. Until then, the new key is kept next to the owner key, so that it
+// is not lost if it cannot be moved into place.
+func RotateOwnerKey(path string, rotate func(pub ed25519.PublicKey) error) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("when generating key: %w", err)
+	}
+	keyPath := filepath.Join(path, OwnerKeyFileName)
+	newPath := keyPath + ".new"
+	pemPriv := pem.EncodeToMemory(&pem.Block{Type: ownerKeyType, Bytes: priv})
+	if err := os.WriteFile(newPath, pemPriv, 0600); err != nil {
+		return fmt.Errorf("when saving key: %w", err)
+	}
+	if err := rotate(pub); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	if err := os.Rename(newPath, keyPath); err != nil {
+		return fmt.Errorf("owner key rotated, but the new key at %s could not be moved into place: %w", newPath, err)
+	}
+	if err := os.Remove(filepath.Join(path, OwnerCertificateFileName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("when removing previous owner certificate: %w", err)
+	}
+	return nil
+}
+
 // WriteCACertificate writes the given der-encoded X509 certificate to the given
 // metorctl configuration directory path.
 func WriteCACertificate(path string, der []byte) error {
@@ -5,25 +5,96 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"golang.org/x/net/proxy"
 	"google.golang.org/grpc"
 
 	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/metropolis/node/core/rpc/resolver"
+	apb "source.monogon.dev/metropolis/proto/api"
 )
 
+// getOwnerCredentials loads the owner credentials from the metroctl
+// configuration, renewing the certificate first if it is due for renewal. If
+// renewal fails but the certificate is still valid, the current certificate is
+// returned.
+func getOwnerCredentials(ctx context.Context) (*x509.Certificate, ed25519.PrivateKey, error) {
+	ocert, opkey, err := core.GetOwnerCredentials(flags.configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().Before(identity.RenewalTime(ocert)) {
+		return ocert, opkey, nil
+	}
+	renewed, err := renewOwnerCertificate(ctx, ocert, opkey)
+	if err != nil {
+		if time.Now().After(ocert.NotAfter) {
+			return nil, nil, fmt.Errorf("owner certificate expired at %s and could not be renewed: %w", ocert.NotAfter, err)
+		}
+		log.Printf("Warning: could not renew owner certificate (expires at %s): %v", ocert.NotAfter, err)
+		return ocert, opkey, nil
+	}
+	return renewed, opkey, nil
+}
+
+// renewOwnerCertificate retrieves a renewed certificate for the owner key from
+// the cluster via AAA.Escrow and saves it to the metroctl configuration.
+func renewOwnerCertificate(ctx context.Context, ocert *x509.Certificate, opkey ed25519.PrivateKey) (*x509.Certificate, error) {
+	if len(flags.clusterEndpoints) == 0 {
+		return nil, fmt.Errorf("no cluster endpoint given")
+	}
+	// Do not trust a new CA here, as this might run non-interactively.
+	ca, err := core.GetClusterCA(flags.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster CA: %w", err)
+	}
+	opts, err := core.DialOpts(ctx, connectOptions())
+	if err != nil {
+		return nil, fmt.Errorf("while configuring dial options: %w", err)
+	}
+	creds, err := rpc.NewEphemeralCredentials(opkey, rpc.WantRemoteCluster(ca))
+	if err != nil {
+		return nil, fmt.Errorf("while generating ephemeral credentials: %w", err)
+	}
+	opts = append(opts, grpc.WithTransportCredentials(creds))
+	cc, err := grpc.NewClient(resolver.MetropolisControlAddress, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("while creating client: %w", err)
+	}
+	defer cc.Close()
+
+	tlsc, err := rpc.RetrieveIdentityCertificate(ctx, apb.NewAAAClient(cc), ocert.Subject.CommonName, opkey)
+	if err != nil {
+		return nil, fmt.Errorf("while retrieving certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(tlsc.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("cluster returned invalid certificate: %w", err)
+	}
+	if !cert.NotAfter.After(ocert.NotAfter) {
+		return nil, fmt.Errorf("cluster did not renew certificate yet")
+	}
+	if err := core.WriteOwnerCertificate(flags.configPath, cert.Raw); err != nil {
+		return nil, fmt.Errorf("failed to store renewed certificate: %w", err)
+	}
+	return cert, nil
+}
+
 func newAuthenticatedClient(ctx context.Context) (*grpc.ClientConn, error) {
 	// Collect credentials, validate command parameters, and create the grpc
 	// client.
-	ocert, opkey, err := core.GetOwnerCredentials(flags.configPath)
+	ocert, opkey, err := getOwnerCredentials(ctx)
 	if errors.Is(err, core.ErrNoCredentials) {
 		return nil, fmt.Errorf("you have to take ownership of the cluster first: %w", err)
 	}
@@ -60,7 +131,7 @@ func newAuthenticatedClient(ctx context.Context) (*grpc.ClientConn, error) {
 func newAuthenticatedNodeClient(ctx context.Context, id, address string, cacert *x509.Certificate) (*grpc.ClientConn, error) {
 	// Collect credentials, validate command parameters, and create the grpc
 	// client.
-	ocert, opkey, err := core.GetOwnerCredentials(flags.configPath)
+	ocert, opkey, err := getOwnerCredentials(ctx)
 	if errors.Is(err, core.ErrNoCredentials) {
 		return nil, fmt.Errorf("you have to take ownership of the cluster first: %w", err)
 	}
@@ -76,7 +147,7 @@ func newAuthenticatedNodeHTTPTransport(ctx context.Context, id string) (*http.Tr
 	if err != nil {
 		return nil, fmt.Errorf("could not get CA certificate: %w", err)
 	}
-	ocert, opkey, err := core.GetOwnerCredentials(flags.configPath)
+	ocert, opkey, err := getOwnerCredentials(ctx)
 	if errors.Is(err, core.ErrNoCredentials) {
 		return nil, fmt.Errorf("you have to take ownership of the cluster first: %w", err)
 	}
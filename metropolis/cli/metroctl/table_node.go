@@ -8,6 +8,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/uuid"
+
 	"source.monogon.dev/go/clitable"
 	apb "source.monogon.dev/metropolis/proto/api"
 	cpb "source.monogon.dev/metropolis/proto/common"
@@ -55,13 +57,55 @@ func nodeEntry(n *apb.Node) clitable.Entry {
 	}
 	res.Add("tpm", tpm)
 
+	if a := n.Attestation; a != nil {
+		attestation := strings.ToLower(strings.ReplaceAll(a.Result.String(), "RESULT_", ""))
+		if a.Reason != "" {
+			attestation += ": " + a.Reason
+		}
+		res.Add("attestation", attestation)
+	}
+	if mb := n.MeasuredBoot; mb != nil {
+		measuredBoot := strings.ToLower(strings.ReplaceAll(mb.Result.String(), "RESULT_", ""))
+		if mb.Reason != "" {
+			measuredBoot += ": " + mb.Reason
+		}
+		res.Add("measured boot", measuredBoot)
+	}
+
 	if n.Status != nil && n.Status.Version != nil {
 		res.Add("version", version.Semver(n.Status.Version))
 	}
+	if d := n.Status.GetOsImageDigest(); d != "" {
+		res.Add("os image", d)
+	}
+	if rb := n.Status.GetLastRollback(); rb != nil {
+		from := "unknown"
+		if rb.FromVersion != nil {
+			from = version.Semver(rb.FromVersion)
+		}
+		res.Add("rollback", fmt.Sprintf("rolled back from %s to %s", from, version.Semver(rb.ToVersion)))
+	}
+	if uf := n.Status.GetUpdateFailure(); uf != nil {
+		res.Add("update failure", uf.Reason)
+	}
+	if bootID, err := uuid.FromBytes(n.Status.GetBootId()); err == nil {
+		res.Add("boot id", bootID.String())
+	}
 
 	tshs := n.TimeSinceHeartbeat.GetSeconds()
 	res.Add("heartbeat", fmt.Sprintf("%ds", tshs))
 
+	if t := n.Status.GetTime(); t != nil {
+		clock := "unsynchronized"
+		if t.Synchronized {
+			clock = fmt.Sprintf("synchronized to %s, offset %s", t.Reference, t.Offset.AsDuration())
+		}
+		res.Add("time", clock)
+	}
+	if servers := n.TimeConfiguration.GetNtpServers(); len(servers) > 0 {
+		res.Add("ntp servers", strings.Join(servers, ","))
+	}
+
 	if l := n.Labels; l != nil {
 		var labels []string
 		for _, pair := range l.Pairs {
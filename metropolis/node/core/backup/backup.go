@@ -0,0 +1,106 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package backup implements the encryption of consensus snapshots taken as
+// scheduled backups of the cluster's control plane state.
+//
+// Backups are encrypted with AES-256-GCM using a key derived from the private
+// key of the cluster CA. As the CA never changes, the key is stable for the
+// lifetime of the cluster, so it only needs to be retrieved once by the
+// cluster's operators (see Management.GetBackupKey) and stored alongside
+// other disaster recovery material. Note that the key is required to decrypt
+// a backup, but the CA private key is only contained in the backup itself.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// KeySize is the size of a backup encryption key in bytes.
+	KeySize = 32
+
+	// magic is prepended to every encrypted backup, and identifies the format
+	// of the rest of the data.
+	magic = "MBACKUP1"
+
+	// keyInfo is the HKDF info string used to derive backup keys.
+	keyInfo = "metropolis-backup-encryption-v1"
+)
+
+// Key derives the backup encryption key from the cluster CA private key.
+func Key(caPrivateKey ed25519.PrivateKey) ([]byte, error) {
+	if len(caPrivateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid CA private key")
+	}
+	key := make([]byte, KeySize)
+	r := hkdf.New(sha256.New, caPrivateKey.Seed(), nil, []byte(keyInfo))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, fmt.Errorf("could not derive key: %w", err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts a snapshot with the given backup encryption key.
+func Encrypt(key, snapshot []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	out := make([]byte, 0, len(magic)+len(nonce)+len(snapshot)+aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, snapshot, []byte(magic)), nil
+}
+
+// IsEncrypted returns whether the given data looks like an encrypted backup,
+// as opposed to a plain snapshot.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Decrypt decrypts a backup encrypted by Encrypt, returning the snapshot.
+func Decrypt(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		return nil, errors.New("not an encrypted backup")
+	}
+	data = data[len(magic):]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("backup truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	snapshot, err := aead.Open(nil, nonce, ciphertext, []byte(magic))
+	if err != nil {
+		return nil, errors.New("could not decrypt backup: wrong key or corrupted data")
+	}
+	return snapshot, nil
+}
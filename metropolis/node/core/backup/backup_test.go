@@ -0,0 +1,70 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package backup
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestRoundtrip(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := Key(priv)
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	key2, err := Key(priv)
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	if !bytes.Equal(key, key2) {
+		t.Fatalf("Key is not deterministic")
+	}
+
+	snapshot := []byte("some snapshot data")
+	enc, err := Encrypt(key, snapshot)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(enc) {
+		t.Errorf("IsEncrypted returned false for encrypted backup")
+	}
+	if IsEncrypted(snapshot) {
+		t.Errorf("IsEncrypted returned true for plain snapshot")
+	}
+	if bytes.Contains(enc, snapshot) {
+		t.Errorf("Encrypted backup contains plaintext")
+	}
+	dec, err := Decrypt(key, enc)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(dec, snapshot) {
+		t.Errorf("Decrypted data is %q, wanted %q", dec, snapshot)
+	}
+
+	// Decrypting with another CA's key must fail.
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := Key(otherPriv)
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	if _, err := Decrypt(otherKey, enc); err == nil {
+		t.Errorf("Decrypt with wrong key succeeded")
+	}
+
+	// Tampering must be detected.
+	enc[len(enc)-1] ^= 1
+	if _, err := Decrypt(key, enc); err == nil {
+		t.Errorf("Decrypt of tampered backup succeeded")
+	}
+}
@@ -74,6 +74,7 @@ func (m *Manager) Run(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("while reading cluster directory: %w", err)
 		}
+		m.removeRestoreParameters(ctx)
 		return m.join(ctx, configuration, cd, true)
 	}
 
@@ -92,6 +93,7 @@ func (m *Manager) Run(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("while reading cluster directory: %w", err)
 		}
+		m.removeRestoreParameters(ctx)
 		return m.join(ctx, configuration, cd, false)
 	}
 
@@ -102,8 +104,10 @@ func (m *Manager) Run(ctx context.Context) error {
 		err = m.bootstrap(ctx, inner.ClusterBootstrap)
 	case *apb.NodeParameters_ClusterRegister_:
 		err = m.register(ctx, inner.ClusterRegister)
+	case *apb.NodeParameters_ClusterRestore_:
+		err = m.restore(ctx, inner.ClusterRestore)
 	default:
-		err = fmt.Errorf("node parameters misconfigured: neither cluster_bootstrap nor cluster_register set")
+		err = fmt.Errorf("node parameters misconfigured: none of cluster_bootstrap, cluster_register or cluster_restore set")
 	}
 
 	if err == nil {
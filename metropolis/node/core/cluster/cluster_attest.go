@@ -0,0 +1,69 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"source.monogon.dev/osbase/supervisor"
+	"source.monogon.dev/osbase/tpm"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+)
+
+// attest performs TPM remote attestation against the curator, returning an
+// Attestation to be sent in the following RegisterNode or JoinNode call, which
+// must be made with the same client. If the node has no TPM or attestation
+// fails locally, nil is returned, and the node continues without attestation.
+// It is then up to the cluster to decide whether it accepts the node.
+func attest(ctx context.Context, cur ipb.CuratorClient) *ipb.Attestation {
+	if !tpm.IsInitialized() {
+		return nil
+	}
+	a, err := attestTPM(ctx, cur)
+	if err != nil {
+		supervisor.Logger(ctx).Warningf("TPM: attestation failed, continuing without: %v", err)
+		return nil
+	}
+	return a
+}
+
+func attestTPM(ctx context.Context, cur ipb.CuratorClient) (*ipb.Attestation, error) {
+	ekPub, ekCert, err := tpm.GetEKPublic()
+	if err != nil {
+		return nil, fmt.Errorf("could not get EK: %w", err)
+	}
+	akPub, err := tpm.GetAKPublic()
+	if err != nil {
+		return nil, fmt.Errorf("could not get AK: %w", err)
+	}
+	ch, err := cur.GetAttestationChallenge(ctx, &ipb.GetAttestationChallengeRequest{
+		EkPublicKey:   ekPub,
+		AkPublic:      akPub,
+		EkCertificate: ekCert,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get challenge: %w", err)
+	}
+	secret, err := tpm.SolveAKChallenge(ch.CredentialBlob, ch.EncryptedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("could not solve challenge: %w", err)
+	}
+	quote, signature, err := tpm.AttestPlatform(ch.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not quote PCRs: %w", err)
+	}
+	pcrs, err := tpm.GetPCRs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read PCRs: %w", err)
+	}
+	return &ipb.Attestation{
+		Nonce:           ch.Nonce,
+		ActivatedSecret: secret,
+		Quote:           quote,
+		QuoteSignature:  signature,
+		Pcrs:            pcrs,
+	}, nil
+}
@@ -18,6 +18,7 @@ import (
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/roleserve"
 	"source.monogon.dev/osbase/supervisor"
+	"source.monogon.dev/osbase/tpm"
 
 	apb "source.monogon.dev/metropolis/proto/api"
 	cpb "source.monogon.dev/metropolis/proto/common"
@@ -40,20 +41,32 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 		}
 	}
 
+	bd, err := m.bootstrapNode(ctx, cc, bootstrap.Labels)
+	if err != nil {
+		return err
+	}
+	bd.Cluster.InitialOwnerKey = bootstrap.OwnerPublicKey
+	return m.bootstrapFinish(ctx, bd)
+}
+
+// bootstrapNode sets up this node as the first node of a cluster with the given
+// configuration: it mounts new storage, generates the node's keys and saves
+// its credentials. The returned BootstrapData is populated with node data and
+// the cluster configuration, and is to be completed by the caller.
+func (m *Manager) bootstrapNode(ctx context.Context, cc *curator.Cluster, nodeLabels *cpb.NodeLabels) (*roleserve.BootstrapData, error) {
 	tpmUsage, err := cc.NodeTPMUsage(m.haveTPM)
 	if err != nil {
-		return fmt.Errorf("cannot bootstrap cluster: %w", err)
+		return nil, fmt.Errorf("cannot bootstrap cluster: %w", err)
 	}
 
 	storageSecurity, err := cc.NodeStorageSecurity()
 	if err != nil {
-		return fmt.Errorf("cannot bootstrap cluster: %w", err)
+		return nil, fmt.Errorf("cannot bootstrap cluster: %w", err)
 	}
 
 	supervisor.Logger(ctx).Infof("TPM: cluster policy: %s, node: %s", cc.TPMMode, tpmUsage)
 	supervisor.Logger(ctx).Infof("Storage Security: cluster policy: %s, node: %s", cc.StorageSecurityPolicy, storageSecurity)
 
-	ownerKey := bootstrap.OwnerPublicKey
 	var configuration ppb.SealedConfiguration
 
 	// Mount new storage with generated CUK, and save NUK into sealed config proto.
@@ -72,20 +85,20 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 	cuk, err := m.storageRoot.Data.MountNew(&configuration, storageSecurity)
 	close(storageDone)
 	if err != nil {
-		return fmt.Errorf("could not make and mount data partition: %w", err)
+		return nil, fmt.Errorf("could not make and mount data partition: %w", err)
 	}
 	nuk := configuration.NodeUnlockKey
 
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return fmt.Errorf("could not generate node keypair: %w", err)
+		return nil, fmt.Errorf("could not generate node keypair: %w", err)
 	}
 	id := identity.NodeID(pub)
 	supervisor.Logger(ctx).Infof("Bootstrapping: node public key: %s", hex.EncodeToString(pub))
 
 	jpub, jpriv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return fmt.Errorf("could not generate join keypair: %w", err)
+		return nil, fmt.Errorf("could not generate join keypair: %w", err)
 	}
 	supervisor.Logger(ctx).Infof("Bootstrapping: node public join key: %s", hex.EncodeToString(jpub))
 
@@ -103,10 +116,10 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 	}
 	cdirRaw, err := proto.Marshal(directory)
 	if err != nil {
-		return fmt.Errorf("couldn't marshal ClusterDirectory: %w", err)
+		return nil, fmt.Errorf("couldn't marshal ClusterDirectory: %w", err)
 	}
 	if err = m.storageRoot.ESP.Metropolis.ClusterDirectory.Write(cdirRaw, 0644); err != nil {
-		return fmt.Errorf("writing cluster directory failed: %w", err)
+		return nil, fmt.Errorf("writing cluster directory failed: %w", err)
 	}
 
 	sc := ppb.SealedConfiguration{
@@ -118,12 +131,12 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 		StorageSecurity: storageSecurity,
 	}
 	if err = m.storageRoot.ESP.Metropolis.SealedConfiguration.SealSecureBoot(&sc, tpmUsage); err != nil {
-		return fmt.Errorf("writing sealed configuration failed: %w", err)
+		return nil, fmt.Errorf("writing sealed configuration failed: %w", err)
 	}
 	supervisor.Logger(ctx).Infof("Saved bootstrapped node's credentials.")
 
 	labels := make(map[string]string)
-	if l := bootstrap.Labels; l != nil {
+	if l := nodeLabels; l != nil {
 		if nlabels := len(l.Pairs); nlabels > common.MaxLabelsPerNode {
 			supervisor.Logger(ctx).Warningf("Too many labels (%d, limit %d), truncating...", nlabels, common.MaxLabelsPerNode)
 			l.Pairs = l.Pairs[:common.MaxLabelsPerNode]
@@ -144,6 +157,17 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 		}
 	}
 
+	// Record the EK of the node's TPM in the cluster, so that the node can be
+	// attested when it joins the cluster after a reboot.
+	var ekPub []byte
+	if m.haveTPM {
+		ekPub, _, err = tpm.GetEKPublic()
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("TPM: could not get endorsement key, it will be recorded on first attestation instead: %v", err)
+			ekPub = nil
+		}
+	}
+
 	bd := roleserve.BootstrapData{}
 	bd.Node.ID = id
 	bd.Node.PrivateKey = priv
@@ -151,10 +175,16 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 	bd.Node.NodeUnlockKey = nuk
 	bd.Node.JoinKey = jpriv
 	bd.Node.TPMUsage = tpmUsage
+	bd.Node.EKPublicKey = ekPub
 	bd.Node.Labels = labels
-	bd.Cluster.InitialOwnerKey = ownerKey
 	bd.Cluster.Configuration = cc
-	m.roleServer.ProvideBootstrapData(&bd)
+	return &bd, nil
+}
+
+// bootstrapFinish provides the given BootstrapData to the role server, which
+// then brings up the control plane.
+func (m *Manager) bootstrapFinish(ctx context.Context, bd *roleserve.BootstrapData) error {
+	m.roleServer.ProvideBootstrapData(bd)
 
 	if err := m.updateService.MarkBootSuccessful(); err != nil {
 		supervisor.Logger(ctx).Errorf("Failed to mark boot as successful: %v", err)
@@ -83,8 +83,10 @@ func (m *Manager) join(ctx context.Context, sc *ppb.SealedConfiguration, cd *cpb
 		bo := backoff.NewExponentialBackOff()
 		bo.MaxElapsedTime = 0
 		backoff.Retry(func() error {
+			// Challenges are single-use, attest again on every attempt.
 			jr, err = cur.JoinNode(ctx, &ipb.JoinNodeRequest{
 				UsingSealedConfiguration: sealed,
+				Attestation:              attest(ctx, cur),
 			})
 			if err != nil {
 				supervisor.Logger(ctx).Warningf("Join failed: %v", err)
@@ -109,12 +111,19 @@ func (m *Manager) join(ctx context.Context, sc *ppb.SealedConfiguration, cd *cpb
 	if err := creds.Read(&m.storageRoot.Data.Node.Credentials); err != nil {
 		return fmt.Errorf("while reading node credentials: %w", err)
 	}
-	m.roleServer.ProvideJoinData(creds, cd)
+	m.roleServer.ProvideJoinData(ctx, creds, cd)
 
 	// After successfully joining cluster, mark boot as successful.
 	// This allows the update service to mark the currently-booted slot as good
-	// if an update has been performed.
-	if err := m.updateService.MarkBootSuccessful(); err != nil {
+	// if an update has been performed. If the update has a health gate, the
+	// roleserver will instead do this once the node has proven to be healthy.
+	gate, err := m.updateService.HealthGate()
+	if err != nil {
+		supervisor.Logger(ctx).Errorf("Failed to check for update health gate: %v", err)
+	}
+	if gate != nil {
+		supervisor.Logger(ctx).Infof("Running an update with health gate, deferring marking boot as successful.")
+	} else if err := m.updateService.MarkBootSuccessful(); err != nil {
 		supervisor.Logger(ctx).Errorf("Failed to mark boot as successful: %v", err)
 	}
 
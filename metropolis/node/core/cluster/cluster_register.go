@@ -124,6 +124,7 @@ func (m *Manager) register(ctx context.Context, register *apb.NodeParameters_Clu
 		JoinKey:        jpub,
 		HaveLocalTpm:   m.haveTPM,
 		Labels:         register.Labels,
+		Attestation:    attest(ctx, cur),
 	})
 	if err != nil {
 		return fmt.Errorf("register call failed: %w", err)
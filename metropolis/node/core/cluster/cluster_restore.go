@@ -0,0 +1,171 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package cluster
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/backup"
+	"source.monogon.dev/metropolis/node/core/consensus"
+	"source.monogon.dev/metropolis/node/core/curator"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/supervisor"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+// restore bootstraps this node as the first node of a cluster whose state is
+// restored from a consensus snapshot. Apart from the origin of the cluster
+// state, this is the same as bootstrapping a new cluster.
+func (m *Manager) restore(ctx context.Context, restore *apb.NodeParameters_ClusterRestore) error {
+	supervisor.Logger(ctx).Infof("Restoring cluster from encrypted snapshot (%d bytes)...", len(restore.EncryptedSnapshot))
+	if !backup.IsEncrypted(restore.EncryptedSnapshot) {
+		return fmt.Errorf("invalid snapshot: not encrypted")
+	}
+	raw, err := awaitRestoreKey(ctx, restore.EncryptedSnapshot)
+	if err != nil {
+		return err
+	}
+
+	snap, err := consensus.ParseSnapshot(raw)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	cc, err := curator.ClusterConfigurationFromSnapshot(snap)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	supervisor.Logger(ctx).Infof("Snapshot contains %d keys.", snap.Len())
+
+	bd, err := m.bootstrapNode(ctx, cc, restore.Labels)
+	if err != nil {
+		return err
+	}
+	bd.Cluster.Restore = snap
+	bd.Cluster.RestoreCACertificate = restore.CaCertificate
+	if err := m.bootstrapFinish(ctx, bd); err != nil {
+		return err
+	}
+	m.removeRestoreParameters(ctx)
+	return nil
+}
+
+// removeRestoreParameters removes the encrypted snapshot from the node
+// parameters on the ESP once the restored cluster state has been committed,
+// as it contains all cluster secrets. It's called after every start of a node
+// which has been enrolled into a cluster, so that the snapshot is also removed
+// if the node restarted before it could do so after the restore.
+func (m *Manager) removeRestoreParameters(ctx context.Context) {
+	params, err := m.storageRoot.ESP.Metropolis.NodeParameters.Unmarshal()
+	if err != nil {
+		if !errors.Is(err, localstorage.ErrNoParameters) {
+			supervisor.Logger(ctx).Warningf("Could not read node parameters to remove restore snapshot: %v", err)
+		}
+		return
+	}
+	if params.GetClusterRestore() == nil {
+		return
+	}
+	params.Cluster = nil
+	paramsRaw, err := proto.Marshal(params)
+	if err != nil {
+		supervisor.Logger(ctx).Errorf("Could not marshal node parameters to remove restore snapshot: %v", err)
+		return
+	}
+	// Write is atomic, so that a crash cannot leave behind corrupted
+	// parameters.
+	if err := m.storageRoot.ESP.Metropolis.NodeParameters.Write(paramsRaw, 0644); err != nil {
+		supervisor.Logger(ctx).Errorf("Could not remove restore snapshot from node parameters: %v", err)
+		return
+	}
+	supervisor.Logger(ctx).Infof("Removed restore snapshot from node parameters.")
+}
+
+// awaitRestoreKey serves RestoreUnlock until an operator submits the key with
+// which the given snapshot is encrypted, and returns the decrypted snapshot.
+//
+// RestoreUnlock is served with an ephemeral self-signed certificate, which
+// protects the key against passive eavesdroppers. An active attacker could
+// intercept the key, but would also need the encrypted snapshot from the
+// node's installation medium to make use of it.
+func awaitRestoreKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     rpc.UnknownNotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate self-signed certificate: %w", err)
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certBytes},
+			PrivateKey:  priv,
+		}},
+	})
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", node.RestoreUnlockPort))
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on restore unlock port: %w", err)
+	}
+	u := &restoreUnlock{
+		encrypted: encrypted,
+		snapshotC: make(chan []byte, 1),
+	}
+	srv := grpc.NewServer(grpc.Creds(creds))
+	apb.RegisterRestoreUnlockServer(srv, u)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	supervisor.Logger(ctx).Infof("Waiting for the snapshot key to be submitted on port %d, eg. with metroctl cluster unlock-restore...", node.RestoreUnlockPort)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case snapshot := <-u.snapshotC:
+		supervisor.Logger(ctx).Infof("Snapshot key received.")
+		return snapshot, nil
+	}
+}
+
+// restoreUnlock implements RestoreUnlock for awaitRestoreKey.
+type restoreUnlock struct {
+	encrypted []byte
+	snapshotC chan []byte
+}
+
+func (r *restoreUnlock) Unlock(ctx context.Context, req *apb.UnlockRequest) (*apb.UnlockResponse, error) {
+	snapshot, err := backup.Decrypt(req.Key, r.encrypted)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key: %v", err)
+	}
+	select {
+	case r.snapshotC <- snapshot:
+	default:
+	}
+	return &apb.UnlockResponse{}, nil
+}
@@ -41,6 +41,13 @@ type Config struct {
 	// different certificates will be used.
 	NodePrivateKey ed25519.PrivateKey
 
+	// Restore is set if this instance is to bootstrap a new cluster seeded
+	// with the data of a snapshot of a previous cluster. All keys of the
+	// snapshot, including the consensus PKI, are restored before the consensus
+	// PKI is bootstrapped, so the previous PKI is retained. It is ignored if
+	// JoinCluster is set or if the instance has already been bootstrapped.
+	Restore *Snapshot
+
 	testOverrides testOverrides
 }
 
@@ -81,6 +81,7 @@ import (
 	"math/big"
 	"net"
 	"net/url"
+	"os"
 	"time"
 
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
@@ -156,11 +157,16 @@ type Service struct {
 
 	value memory.Value[*Status]
 	ca    *pki.Certificate
+
+	// forceC is used by ForceNewCluster to request the running etcd server to
+	// be restarted as a new cluster.
+	forceC chan *forceRequest
 }
 
 func New(config Config) *Service {
 	return &Service{
 		config: &config,
+		forceC: make(chan *forceRequest),
 	}
 }
 
@@ -248,16 +254,41 @@ func (s *Service) Run(ctx context.Context) error {
 	// Start etcd ...
 	supervisor.Logger(ctx).Infof("Starting etcd...")
 	cfg := s.config.build(true)
+	force, err := s.config.Data.ForceNewCluster.Exists()
+	if err != nil {
+		return fmt.Errorf("when checking for force new cluster marker: %w", err)
+	}
+	if force {
+		supervisor.Logger(ctx).Warningf("Forcing new cluster, this member will be the only member of the cluster!")
+		cfg.ForceNewCluster = true
+	}
 	server, err := embed.StartEtcd(cfg)
 	if err != nil {
 		return fmt.Errorf("when starting etcd: %w", err)
 	}
 
-	// ... wait for server to be ready...
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-server.Server.ReadyNotify():
+	// ... wait for server to be ready. Without quorum, this never happens, so
+	// also allow ForceNewCluster to restart the server here...
+	for ready := false; !ready; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case req := <-s.forceC:
+			if s.handleForce(server, req) {
+				server.Close()
+				return errForceNewCluster
+			}
+		case <-server.Server.ReadyNotify():
+			ready = true
+		}
+	}
+
+	// ... stop forcing a new cluster once that succeeded...
+	if force {
+		if err := os.Remove(s.config.Data.ForceNewCluster.FullPath()); err != nil {
+			return fmt.Errorf("when removing force new cluster marker: %w", err)
+		}
+		supervisor.Logger(ctx).Infof("New cluster forced.")
 	}
 
 	// ... build a client to its' socket...
@@ -307,12 +338,21 @@ func (s *Service) Run(ctx context.Context) error {
 	// Wait until server dies for whatever reason, update status when that
 	// happens.
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
-	select {
-	case err = <-server.Err():
-		err = fmt.Errorf("server returned error: %w", err)
-	case <-ctx.Done():
-		server.Close()
-		err = ctx.Err()
+	for {
+		select {
+		case err = <-server.Err():
+			err = fmt.Errorf("server returned error: %w", err)
+		case req := <-s.forceC:
+			if !s.handleForce(server, req) {
+				continue
+			}
+			server.Close()
+			err = errForceNewCluster
+		case <-ctx.Done():
+			server.Close()
+			err = ctx.Err()
+		}
+		break
 	}
 
 	st.stopped = true
@@ -379,6 +419,14 @@ func (s *Service) bootstrap(ctx context.Context) error {
 		return fmt.Errorf("when getting bootstrap client: %w", err)
 	}
 
+	// ... restore data from a snapshot if requested ...
+	if r := s.config.Restore; r != nil {
+		supervisor.Logger(ctx).Infof("Bootstrapping PKI: restoring %d keys from snapshot...", r.Len())
+		if err := r.restore(ctx, cl); err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+	}
+
 	// ... and build PKI there. This is idempotent, so we will never override
 	// anything that's already in the cluster, instead just retrieve it.
 	supervisor.Logger(ctx).Infof("Bootstrapping PKI: etcd running, building PKI...")
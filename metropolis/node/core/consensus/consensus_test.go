@@ -8,12 +8,16 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
+	clientv3 "go.etcd.io/etcd/client/v3"
+
 	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
 	"source.monogon.dev/metropolis/test/util"
@@ -324,3 +328,258 @@ func TestJoin(t *testing.T) {
 		t.Fatalf("test key value missing: %v", res.Kvs)
 	}
 }
+
+func TestRestoreSnapshot(t *testing.T) {
+	b := prep(t)
+	defer b.close()
+
+	// Start first node, write some data and take a snapshot.
+	etcd := New(Config{
+		Data:           &b.root.Data.Etcd,
+		Ephemeral:      &b.root.Ephemeral.Consensus,
+		NodeID:         "node1",
+		NodePrivateKey: b.privkey,
+		testOverrides: testOverrides{
+			externalPort:    3002,
+			etcdMetricsPort: 3200,
+		},
+	})
+	ctxC, _ := supervisor.TestHarness(t, etcd.Run)
+	defer ctxC()
+
+	w := etcd.Watch()
+	st, err := w.Get(b.ctx)
+	if err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+	cl, err := st.CuratorClient()
+	if err != nil {
+		t.Fatalf("CuratorClient: %v", err)
+	}
+	defer cl.Close()
+	if _, err := cl.Put(b.ctx, "/foo", "bar"); err != nil {
+		t.Fatalf("test key creation failed: %v", err)
+	}
+	if _, err := cl.Put(b.ctx, "/deleted", "baz"); err != nil {
+		t.Fatalf("test key creation failed: %v", err)
+	}
+	if _, err := cl.Delete(b.ctx, "/deleted"); err != nil {
+		t.Fatalf("test key deletion failed: %v", err)
+	}
+	lease, err := cl.Grant(b.ctx, 60)
+	if err != nil {
+		t.Fatalf("lease creation failed: %v", err)
+	}
+	if _, err := cl.Put(b.ctx, "/leased", "qux", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatalf("test key creation failed: %v", err)
+	}
+
+	r, err := st.Snapshot(b.ctx)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("could not read snapshot: %v", err)
+	}
+	firstCA, err := etcd.config.Data.PeerPKI.CACertificate.Read()
+	if err != nil {
+		t.Fatalf("could not read CA file: %v", err)
+	}
+
+	snap, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatalf("ParseSnapshot: %v", err)
+	}
+	if v, ok := snap.CuratorValue("/foo"); !ok || string(v) != "bar" {
+		t.Errorf("snapshot value of /foo: wanted bar, got %q (present: %v)", v, ok)
+	}
+	if _, ok := snap.CuratorValue("/deleted"); ok {
+		t.Errorf("deleted key present in snapshot")
+	}
+	if _, ok := snap.CuratorValue("/leased"); ok {
+		t.Errorf("leased key present in snapshot")
+	}
+
+	// Corrupted snapshots must be rejected.
+	corrupted := bytes.Clone(data)
+	corrupted[0] ^= 0xff
+	if _, err := ParseSnapshot(corrupted); err == nil {
+		t.Errorf("corrupted snapshot parsed successfully")
+	}
+
+	// Bootstrap a new node from the snapshot and ensure data and PKI are
+	// retained.
+	b2 := prep(t)
+	defer b2.close()
+
+	etcd2 := New(Config{
+		Data:           &b2.root.Data.Etcd,
+		Ephemeral:      &b2.root.Ephemeral.Consensus,
+		NodeID:         "node2",
+		NodePrivateKey: b2.privkey,
+		Restore:        snap,
+		testOverrides: testOverrides{
+			externalPort:    3003,
+			etcdMetricsPort: 3201,
+		},
+	})
+	ctxC, _ = supervisor.TestHarness(t, etcd2.Run)
+	defer ctxC()
+
+	w2 := etcd2.Watch()
+	st2, err := w2.Get(b.ctx)
+	if err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+	cl2, err := st2.CuratorClient()
+	if err != nil {
+		t.Fatalf("CuratorClient: %v", err)
+	}
+	defer cl2.Close()
+
+	res, err := cl2.Get(b.ctx, "/foo")
+	if err != nil {
+		t.Fatalf("test key retrieval failed: %v", err)
+	}
+	if len(res.Kvs) != 1 || string(res.Kvs[0].Value) != "bar" {
+		t.Fatalf("test key value missing: %v", res.Kvs)
+	}
+	res, err = cl2.Get(b.ctx, "/leased")
+	if err != nil {
+		t.Fatalf("test key retrieval failed: %v", err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Fatalf("leased key restored: %v", res.Kvs)
+	}
+
+	secondCA, err := etcd2.config.Data.PeerPKI.CACertificate.Read()
+	if err != nil {
+		t.Fatalf("could not read CA file: %v", err)
+	}
+	if !bytes.Equal(firstCA, secondCA) {
+		t.Fatalf("wanted same, got different CAs after restore")
+	}
+}
+
+func TestForceNewCluster(t *testing.T) {
+	b := prep(t)
+	defer b.close()
+
+	// Start a cluster of two nodes.
+	etcd := New(Config{
+		Data:           &b.root.Data.Etcd,
+		Ephemeral:      &b.root.Ephemeral.Consensus,
+		NodeID:         "node1",
+		NodePrivateKey: b.privkey,
+		testOverrides: testOverrides{
+			externalPort:    3004,
+			externalAddress: "localhost",
+			etcdMetricsPort: 3202,
+		},
+	})
+	// Run etcd as a child runnable, as it exits with an error to restart when
+	// forcing a new cluster.
+	ctxC, _ := supervisor.TestHarness(t, func(ctx context.Context) error {
+		if err := supervisor.Run(ctx, "etcd", etcd.Run); err != nil {
+			return err
+		}
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	defer ctxC()
+
+	w := etcd.Watch()
+	defer w.Close()
+	st, err := w.Get(b.ctx)
+	if err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+	cl, err := st.CuratorClient()
+	if err != nil {
+		t.Fatalf("CuratorClient: %v", err)
+	}
+	if _, err := cl.Put(b.ctx, "/foo", "bar"); err != nil {
+		t.Fatalf("test key creation failed: %v", err)
+	}
+
+	b2 := prep(t)
+	defer b2.close()
+	join, err := st.AddNode(b.ctx, "node2", b2.privkey.Public().(ed25519.PublicKey), &AddNodeOption{
+		externalAddress: "localhost",
+		externalPort:    3005,
+	})
+	if err != nil {
+		t.Fatalf("could not add node: %v", err)
+	}
+	etcd2 := New(Config{
+		Data:           &b2.root.Data.Etcd,
+		Ephemeral:      &b2.root.Ephemeral.Consensus,
+		NodeID:         "node2",
+		NodePrivateKey: b2.privkey,
+		JoinCluster:    join,
+		testOverrides: testOverrides{
+			externalPort:    3005,
+			externalAddress: "localhost",
+			etcdMetricsPort: 3203,
+		},
+	})
+	ctxC2, _ := supervisor.TestHarness(t, etcd2.Run)
+	w2 := etcd2.Watch()
+	if _, err := w2.Get(b.ctx); err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+	w2.Close()
+
+	// With quorum, forcing a new cluster must be refused.
+	if err := etcd.ForceNewCluster(b.ctx, false); !errors.Is(err, ErrHasQuorum) {
+		t.Fatalf("ForceNewCluster with quorum: wanted ErrHasQuorum, got %v", err)
+	}
+
+	// Stop the second node, which loses quorum, then force a new cluster.
+	ctxC2()
+	for {
+		err := etcd.ForceNewCluster(b.ctx, false)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrHasQuorum) {
+			t.Fatalf("ForceNewCluster: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Wait for etcd to come back up as the only member.
+	for {
+		st, err = w.Get(b.ctx)
+		if err != nil {
+			t.Fatalf("could not get status: %v", err)
+		}
+		if !st.stopped {
+			break
+		}
+	}
+	cl, err = st.CuratorClient()
+	if err != nil {
+		t.Fatalf("CuratorClient: %v", err)
+	}
+	res, err := cl.Get(b.ctx, "/foo")
+	if err != nil {
+		t.Fatalf("test key retrieval failed: %v", err)
+	}
+	if len(res.Kvs) != 1 || string(res.Kvs[0].Value) != "bar" {
+		t.Fatalf("test key value missing: %v", res.Kvs)
+	}
+	members, err := st.ClusterClient().MemberList(b.ctx)
+	if err != nil {
+		t.Fatalf("MemberList: %v", err)
+	}
+	if len(members.Members) != 1 {
+		t.Errorf("wanted 1 member, got %d", len(members.Members))
+	}
+	if exists, err := b.root.Data.Etcd.ForceNewCluster.Exists(); err != nil || exists {
+		t.Errorf("force new cluster marker still present (err: %v)", err)
+	}
+}
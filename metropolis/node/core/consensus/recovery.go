@@ -0,0 +1,102 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"source.monogon.dev/metropolis/node/core/localstorage"
+)
+
+var (
+	// ErrHasQuorum is returned by ForceNewCluster if the cluster still has
+	// quorum.
+	ErrHasQuorum = errors.New("consensus has quorum")
+
+	errForceNewCluster = errors.New("restarting to force new cluster")
+)
+
+// forceRequest is a ForceNewCluster call passed to the running etcd server.
+type forceRequest struct {
+	bypassQuorumCheck bool
+	// res receives the result of the request.
+	res chan error
+}
+
+// ForceNewCluster recovers from a permanent loss of quorum by restarting the
+// local etcd member as the only member of the cluster, retaining its data.
+//
+// This is unsafe: any other members which are still running are cut off from
+// the cluster, and writes which have not been replicated to this member are
+// lost. Thus, unless bypassQuorumCheck is set, ForceNewCluster refuses to do
+// anything if the local member knows of a cluster leader, ie. if the cluster
+// still has quorum.
+//
+// The restart is performed asynchronously. Once the member is running again,
+// the curator removes the ConsensusMember role from all other nodes, as they
+// are no longer members.
+func (s *Service) ForceNewCluster(ctx context.Context, bypassQuorumCheck bool) error {
+	req := &forceRequest{
+		bypassQuorumCheck: bypassQuorumCheck,
+		res:               make(chan error, 1),
+	}
+	select {
+	case s.forceC <- req:
+	case <-ctx.Done():
+		return fmt.Errorf("etcd not running: %w", ctx.Err())
+	}
+	select {
+	case err := <-req.res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleForce is called by Run to handle a forceRequest for the given running
+// server. It returns true if the server should be restarted.
+func (s *Service) handleForce(server *embed.Etcd, req *forceRequest) bool {
+	// The server doesn't need quorum to know about the current leader. Clients
+	// can't be used here, as they are not served until the server is ready,
+	// which without quorum never happens.
+	if !req.bypassQuorumCheck && server.Server.Leader() != 0 {
+		req.res <- ErrHasQuorum
+		return false
+	}
+	if err := s.config.Data.ForceNewCluster.Write(nil, 0600); err != nil {
+		req.res <- fmt.Errorf("could not write force new cluster marker: %w", err)
+		return false
+	}
+	req.res <- nil
+	return true
+}
+
+// DiscardData removes the etcd data of a node which is no longer a consensus
+// member, so that it can join the cluster again from scratch if it becomes a
+// consensus member again later. The peer PKI is kept, so that etcd will never
+// bootstrap a new cluster on this node afterwards.
+func DiscardData(data *localstorage.DataEtcdDirectory) error {
+	dir := data.Data.FullPath()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		entries = nil
+	} else if err != nil {
+		return fmt.Errorf("could not list etcd data: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("could not remove etcd data: %w", err)
+		}
+	}
+	if err := os.Remove(data.ForceNewCluster.FullPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove force new cluster marker: %w", err)
+	}
+	return nil
+}
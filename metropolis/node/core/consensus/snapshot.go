@@ -0,0 +1,162 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Snapshot is the key/value data contained in an etcd snapshot, as emitted by
+// the etcd Maintenance.Snapshot API (and Status.Snapshot).
+//
+// Only the latest revision of every key is retained. Keys attached to leases
+// are dropped, as leases are not restored and such keys (eg. leader election
+// locks) only make sense while their owner is alive.
+type Snapshot struct {
+	// kvs maps raw (non-namespaced) etcd keys to their values.
+	kvs map[string][]byte
+}
+
+const (
+	// snapshotRevBytesLen is the length of revision keys in the etcd MVCC
+	// backend: 8 bytes main revision, '_', 8 bytes sub revision.
+	snapshotRevBytesLen = 17
+	// snapshotTombstone marks revision keys of deletions in the etcd MVCC
+	// backend.
+	snapshotTombstone = 't'
+)
+
+// ParseSnapshot parses an etcd snapshot. If the snapshot contains a trailing
+// SHA256 checksum (as added by the etcd Maintenance.Snapshot API), it is
+// verified.
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	// The snapshot API appends a SHA256 of the database. The database itself is
+	// always a multiple of the bbolt page size, so a trailing hash can be
+	// detected by its length.
+	if len(data)%512 == sha256.Size {
+		db, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+		if got := sha256.Sum256(db); !bytes.Equal(got[:], sum) {
+			return nil, errors.New("snapshot checksum mismatch")
+		}
+		data = db
+	}
+
+	// bbolt can only open files, so write the database to a temporary file.
+	f, err := os.CreateTemp("", "etcd-snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not write temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("could not write temporary file: %w", err)
+	}
+
+	db, err := bbolt.Open(f.Name(), 0400, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open snapshot database: %w", err)
+	}
+	defer db.Close()
+
+	s := &Snapshot{
+		kvs: make(map[string][]byte),
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("key"))
+		if b == nil {
+			return errors.New("no key bucket")
+		}
+		// Keys in the bucket are revisions, so iterating over them in order
+		// replays all changes.
+		return b.ForEach(func(rev, value []byte) error {
+			if len(rev) < snapshotRevBytesLen {
+				return fmt.Errorf("invalid revision key %x", rev)
+			}
+			var kv mvccpb.KeyValue
+			if err := kv.Unmarshal(value); err != nil {
+				return fmt.Errorf("could not unmarshal revision %x: %w", rev, err)
+			}
+			if len(rev) > snapshotRevBytesLen && rev[snapshotRevBytesLen] == snapshotTombstone {
+				delete(s.kvs, string(kv.Key))
+				return nil
+			}
+			if kv.Lease != 0 {
+				delete(s.kvs, string(kv.Key))
+				return nil
+			}
+			s.kvs[string(kv.Key)] = kv.Value
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot database: %w", err)
+	}
+	return s, nil
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.kvs)
+}
+
+// CuratorValue returns the value of a key in the namespace used by the
+// Curator, ie. as if retrieved from a Status.CuratorClient.
+func (s *Snapshot) CuratorValue(key string) ([]byte, bool) {
+	v, ok := s.kvs["namespaced:curator/"+key]
+	return v, ok
+}
+
+// restore writes all keys from the snapshot into etcd, overwriting any
+// existing values.
+func (s *Snapshot) restore(ctx context.Context, kv clientv3.KV) error {
+	keys := make([]string, 0, len(s.kvs))
+	for k := range s.kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Write keys in batches, staying well below etcd's default limits of 128
+	// operations and 1.5MiB per request.
+	const (
+		batchOps   = 64
+		batchBytes = 512 * 1024
+	)
+	var ops []clientv3.Op
+	size := 0
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		if _, err := kv.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return err
+		}
+		ops, size = nil, 0
+		return nil
+	}
+	for _, k := range keys {
+		v := s.kvs[k]
+		if len(ops) >= batchOps || (len(ops) > 0 && size+len(k)+len(v) > batchBytes) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		ops = append(ops, clientv3.OpPut(k, string(v)))
+		size += len(k) + len(v)
+	}
+	return flush()
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/ed25519"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 
@@ -89,6 +90,14 @@ func (s *Status) ClusterClient() clientv3.Cluster {
 	return s.cl
 }
 
+// Snapshot returns a consistent snapshot of the whole etcd database, as
+// emitted by the etcd Maintenance.Snapshot API. It can be parsed with
+// ParseSnapshot and used to restore a cluster via Config.Restore. The caller
+// must close the returned reader.
+func (s *Status) Snapshot(ctx context.Context) (io.ReadCloser, error) {
+	return s.cl.Snapshot(ctx)
+}
+
 // AddNode creates a new consensus member corresponding to a given node ID
 // if one does not yet exist. The member will at first be marked as a
 // Learner, ensuring it does not take part in quorum until it has finished
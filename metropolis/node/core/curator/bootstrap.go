@@ -4,15 +4,16 @@
 package curator
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/protobuf/proto"
 
+	"source.monogon.dev/metropolis/node/core/consensus"
 	"source.monogon.dev/metropolis/node/core/consensus/client"
-	"source.monogon.dev/metropolis/node/core/identity"
-	"source.monogon.dev/osbase/pki"
 
 	ppb "source.monogon.dev/metropolis/node/core/curator/proto/private"
 )
@@ -52,14 +53,7 @@ func BootstrapNodeFinish(ctx context.Context, etcd client.Namespaced, node *Node
 	if err != nil {
 		return nil, nil, fmt.Errorf("when ensuring CA: %w", err)
 	}
-	nodeCert := &pki.Certificate{
-		Namespace: &pkiNamespace,
-		Issuer:    pkiCA,
-		Template:  identity.NodeCertificate(node.ID()),
-		Mode:      pki.CertificateExternal,
-		PublicKey: node.pubkey,
-		Name:      fmt.Sprintf("node-%s", node.ID()),
-	}
+	nodeCert := pkiNodeCertificate(node)
 	nodeCertBytes, err = nodeCert.Ensure(ctx, etcd)
 	if err != nil {
 		err = fmt.Errorf("when ensuring node cert: %w", err)
@@ -112,3 +106,74 @@ func BootstrapNodeFinish(ctx context.Context, etcd client.Namespaced, node *Node
 
 	return
 }
+
+// ClusterConfigurationFromSnapshot retrieves the cluster configuration stored
+// within a consensus snapshot, as used by the cluster restore workflow to
+// configure the restoring node before the curator is running.
+func ClusterConfigurationFromSnapshot(snap *consensus.Snapshot) (*Cluster, error) {
+	raw, ok := snap.CuratorValue(clusterConfigurationKey)
+	if !ok {
+		return nil, errors.New("snapshot does not contain cluster configuration")
+	}
+	return clusterUnmarshal(raw)
+}
+
+// RestoreNodeFinish is the equivalent of BootstrapNodeFinish for a cluster
+// which is restored from a consensus snapshot: it saves the given Node into
+// etcd, which already contains the rest of the cluster state, and issues its
+// node certificate from the restored cluster CA. If expectedCA is given, the
+// restored CA certificate must be equal to it.
+//
+// Roles of other nodes are not touched here. Nodes which were consensus members
+// at the time the snapshot was taken are not etcd members of the restored
+// cluster, so the curator leader removes their ConsensusMember role once it
+// is running.
+//
+// This must only be used by the cluster restore logic. It is idempotent, thus
+// can be called repeatedly in case of intermittent failures in the restore
+// logic.
+func RestoreNodeFinish(ctx context.Context, etcd client.Namespaced, node *Node, expectedCA []byte) (caCertBytes, nodeCertBytes []byte, err error) {
+	// See BootstrapNodeFinish.
+	pkiCA.PrivateKey = nil
+	pkiCA.PublicKey = nil
+
+	// The CA is present in the restored data, so this only retrieves it.
+	caCertBytes, err = pkiCA.Ensure(ctx, etcd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("when ensuring CA: %w", err)
+	}
+	if expectedCA != nil && !bytes.Equal(caCertBytes, expectedCA) {
+		return nil, nil, errors.New("restored cluster CA does not match expected CA certificate")
+	}
+	nodeCert := pkiNodeCertificate(node)
+	nodeCertBytes, err = nodeCert.Ensure(ctx, etcd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("when ensuring node cert: %w", err)
+	}
+
+	nodePath, err := node.etcdNodePath()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get node key: %w", err)
+	}
+	nodeRaw, err := proto.Marshal(node.proto())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal node: %w", err)
+	}
+	joinKeyPath, err := node.etcdJoinKeyPath()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get join key: %w", err)
+	}
+
+	// We don't care about the result's success - this is idempotent.
+	_, err = etcd.Txn(ctx).If(
+		clientv3.Compare(clientv3.CreateRevision(nodePath), "=", 0),
+		clientv3.Compare(clientv3.CreateRevision(joinKeyPath), "=", 0),
+	).Then(
+		clientv3.OpPut(nodePath, string(nodeRaw)),
+		clientv3.OpPut(joinKeyPath, node.ID()),
+	).Commit()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to store restored node: %w", err)
+	}
+	return
+}
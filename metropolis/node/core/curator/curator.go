@@ -47,6 +47,13 @@ type Config struct {
 	// resiliency against short network partitions.
 	// A value less or equal to zero will default to 60 seconds.
 	LeaderTTL time.Duration
+	// BackupPath is the directory in which consensus database backups are
+	// stored if the cluster is configured to store backups locally. If empty,
+	// local backups are not available on this node.
+	BackupPath string
+	// Revocations are used to reject revoked certificates in the curator's
+	// gRPC server.
+	Revocations *identity.Revocations
 }
 
 // Service is the Curator service. See the package-level documentation for more
@@ -356,6 +363,8 @@ func (s *Service) Run(ctx context.Context) error {
 		consensusStatus: st,
 		consensus:       s.config.Consensus,
 		status:          &s.status,
+		backupPath:      s.config.BackupPath,
+		revocations:     s.config.Revocations,
 	}
 	if err := supervisor.Run(ctx, "listener", lis.run); err != nil {
 		return fmt.Errorf("when starting listener: %w", err)
@@ -111,6 +111,8 @@ func buildNodeFilter(ctx context.Context, expr string) (nodeFilter, error) {
 		// into CEL environments.
 		enumDeclarations(cpb.NodeState_name),
 		enumDeclarations(apb.Node_Health_name),
+		enumDeclarations(apb.Node_AttestationStatus_name),
+		enumDeclarations(cpb.NodeAttestation_Result_name),
 	)
 	if err != nil {
 		return nil, err
@@ -124,3 +126,34 @@ func buildNodeFilter(ctx context.Context, expr string) (nodeFilter, error) {
 		return keep, err
 	}, nil
 }
+
+// auditFilter are functions created by buildAuditFilter, corresponding to a
+// specific CEL filter expression, that wrap evaluateFilter.
+type auditFilter func(ctx context.Context, entry *apb.AuditEntry) (bool, error)
+
+// buildAuditFilter wraps buildFilter to return an audit entry filtering
+// function based on the CEL filter expression expr. Given an empty filter
+// expression, it returns a function that keeps every entry.
+func buildAuditFilter(ctx context.Context, expr string) (auditFilter, error) {
+	if expr == "" {
+		return func(_ context.Context, _ *apb.AuditEntry) (bool, error) {
+			return true, nil
+		}, nil
+	}
+
+	fprg, err := buildFilter(ctx, expr,
+		cel.Types(&apb.AuditEntry{}),
+		cel.Declarations(
+			celdecls.NewVar("entry", celdecls.NewTypeParamType("metropolis.proto.api.AuditEntry")),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, e *apb.AuditEntry) (bool, error) {
+		return evaluateFilter(ctx, fprg, map[string]interface{}{
+			"entry": e,
+		})
+	}, nil
+}
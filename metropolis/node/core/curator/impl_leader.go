@@ -37,6 +37,16 @@ type leaderState struct {
 	// used to detect possibly re-used WireGuard public keys without having to get
 	// all nodes from etcd.
 	clusternetCache map[string]string
+
+	// auditLastID is the ID of the last audit entry appended by this leader, or
+	// zero if none was appended yet. It is guarded by muAudit.
+	auditLastID uint64
+
+	// attestationChallenges maps nonces (as strings) to pending attestation
+	// challenges returned by GetAttestationChallenge. It is guarded by
+	// muAttestation.
+	attestationChallenges map[string]*attestationChallenge
+	muAttestation         sync.Mutex
 }
 
 // leadership represents the curator leader's ability to perform actions as a
@@ -71,10 +81,31 @@ type leadership struct {
 	consensusStatus *consensus.Status
 	consensus       consensus.ServiceHandle
 
+	// nodeCredentials are the credentials of the node running this leader.
+	// They are used as a TLS client certificate when pushing backups. Nil in
+	// tests.
+	nodeCredentials *identity.NodeCredentials
+	// backupPath is the local directory in which backups are stored, see
+	// Config.BackupPath.
+	backupPath string
+
 	// muRegisterTicket guards changes to the register ticket. Its usage semantics
 	// are the same as for muNodes, as described above.
 	muRegisterTicket sync.Mutex
 
+	// muRollout guards changes to the current rollout. If muNodes also needs to
+	// be taken, muRollout must be taken first.
+	muRollout sync.Mutex
+
+	// muAccess guards changes to roles, role bindings and issued user
+	// certificates. Its usage semantics are the same as for muNodes, as
+	// described above.
+	muAccess sync.Mutex
+
+	// muAudit serializes appends to the audit log, ensuring that audit entry
+	// IDs are unique and monotonic.
+	muAudit sync.Mutex
+
 	// ls contains the current leader's non-persistent local state.
 	ls leaderState
 }
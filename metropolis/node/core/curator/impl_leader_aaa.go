@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/subtle"
+	"crypto/x509"
 	"errors"
 
 	"google.golang.org/grpc/codes"
@@ -30,10 +31,11 @@ type leaderAAA struct {
 }
 
 // getOwnerPubkey returns the public key of the configured owner of the cluster.
+// It is set at cluster bootstrap and can be replaced with RotateOwnerKey.
 //
 // MVP: this should be turned into a proper user/entity system.
-func (a *leaderAAA) getOwnerPubkey(ctx context.Context) (ed25519.PublicKey, error) {
-	res, err := a.etcd.Get(ctx, initialOwnerEtcdPath)
+func (l *leadership) getOwnerPubkey(ctx context.Context) (ed25519.PublicKey, error) {
+	res, err := l.etcd.Get(ctx, initialOwnerEtcdPath)
 	if err != nil {
 		if !errors.Is(err, ctx.Err()) {
 			return nil, status.Error(codes.Unavailable, "could not retrieve initial owner status in etcd")
@@ -54,10 +56,10 @@ func (a *leaderAAA) getOwnerPubkey(ctx context.Context) (ed25519.PublicKey, erro
 	return iom.PublicKey, nil
 }
 
-// Escrow implements the AAA Escrow gRPC method, but currently only for the
-// initial cluster owner exchange workflow. That is, the client presents a
-// self-signed certificate for the public key of the InitialClusterOwner public
-// key defined in the cluster bootstrap configuration, and receives a
+// Escrow implements the AAA Escrow gRPC method. The client presents a
+// self-signed certificate for either the InitialClusterOwner public key
+// defined in the cluster bootstrap configuration (for the 'owner' identity) or
+// the public key of a role binding (for any other identity), and receives a
 // certificate which can be used to perform further management actions.
 func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 	ctx := srv.Context()
@@ -79,9 +81,9 @@ func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 		return status.Errorf(codes.InvalidArgument, "client parameters must be set")
 	}
 
-	// MVP: only support authenticating as 'owner' identity.
-	if msg.Parameters.RequestedIdentityName != "owner" {
-		return status.Errorf(codes.Unimplemented, "only owner escrow is currently implemented")
+	name := msg.Parameters.RequestedIdentityName
+	if name != "owner" && !reAccessName.MatchString(name) {
+		return status.Errorf(codes.InvalidArgument, "invalid requested identity name")
 	}
 
 	if len(msg.Parameters.PublicKey) != ed25519.PublicKeySize {
@@ -116,30 +118,70 @@ func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 		return status.Errorf(codes.Unimplemented, "client parameters public_key different from transport public key unimplemented")
 	}
 
-	// Check client public key is the same as the cluster owner pubkey.
-	opk, err := a.getOwnerPubkey(ctx)
+	a.muAccess.Lock()
+	defer a.muAccess.Unlock()
+
+	// A revoked certificate was issued for this identity and key, which
+	// therefore must not receive any further certificates. Certificates of the
+	// current owner key cannot be revoked, so this never locks out the owner.
+	revoked, err := publicKeyRevoked(ctx, a.leadership, name, pk)
 	if err != nil {
 		return err
 	}
-	if subtle.ConstantTimeCompare(pk, opk) != 1 {
-		return status.Errorf(codes.PermissionDenied, "public key not authorized to escrow owner credentials")
+	if revoked {
+		return status.Errorf(codes.PermissionDenied, "public key has been revoked")
 	}
 
-	// Everything okay, send response with certificate.
-	//
-	// MVP: The emitted certificate is valid forever.
-	oc := pki.Certificate{
-		Namespace: &pkiNamespace,
-		Issuer:    pkiCA,
-		Template:  identity.UserCertificate("owner"),
-		Name:      "owner",
-		Mode:      pki.CertificateExternal,
-		PublicKey: pk,
+	var oc pki.Certificate
+	if name == "owner" {
+		// Check client public key is the same as the cluster owner pubkey.
+		opk, err := a.getOwnerPubkey(ctx)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(pk, opk) != 1 {
+			return status.Errorf(codes.PermissionDenied, "public key not authorized to escrow owner credentials")
+		}
+
+		// Everything okay, send response with certificate. A fresh one is
+		// emitted on every escrow, as the owner key can be rotated.
+		oc = pki.Certificate{
+			Namespace: &pkiNamespace,
+			Issuer:    pkiCA,
+			Template:  identity.UserCertificate("owner"),
+			Mode:      pki.CertificateEphemeral,
+			PublicKey: pk,
+			Lifetime:  identity.UserCertificateLifetime,
+		}
+	} else {
+		permissions, err := a.boundPermissions(ctx, name, pk)
+		if err != nil {
+			return err
+		}
+
+		// The permissions are embedded into the certificate, so a fresh one is
+		// emitted on every escrow to reflect the current role bindings.
+		oc = pki.Certificate{
+			Namespace: &pkiNamespace,
+			Issuer:    pkiCA,
+			Template:  identity.ScopedUserCertificate(name, permissions),
+			Mode:      pki.CertificateEphemeral,
+			PublicKey: pk,
+			Lifetime:  identity.UserCertificateLifetime,
+		}
 	}
 	ocBytes, err := oc.Ensure(ctx, a.etcd)
 	if err != nil {
 		return status.Errorf(codes.Unavailable, "ensuring new certificate failed: %v", err)
 	}
+	ocCert, err := x509.ParseCertificate(ocBytes)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "parsing new certificate failed: %v", err)
+	}
+	// Record the certificate, so that it can be listed and revoked.
+	if err := certificateSave(ctx, a.leadership, ocCert); err != nil {
+		return err
+	}
 
 	return srv.Send(&apb.EscrowResponse{
 		Fulfilled: []*apb.EscrowResponse_ProofRequest{
@@ -150,3 +192,26 @@ func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 		EmittedCertificate: ocBytes,
 	})
 }
+
+// boundPermissions returns the names of the permissions granted to a non-owner
+// identity by its role binding, after checking that the binding is for the
+// given public key. It must be called with muAccess held.
+func (a *leaderAAA) boundPermissions(ctx context.Context, name string, pk []byte) ([]string, error) {
+	denied := status.Errorf(codes.PermissionDenied, "public key not authorized to escrow credentials for %q", name)
+	binding, err := roleBindingLoad(ctx, a.leadership, name)
+	if err == errRoleBindingNotFound {
+		return nil, denied
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(pk, binding.PublicKey) != 1 {
+		return nil, denied
+	}
+	permissions, err := roleBindingPermissions(ctx, a.leadership, binding)
+	if err != nil {
+		rpc.Trace(ctx).Printf("Identity %q: %v", name, err)
+		return nil, denied
+	}
+	return permissions, nil
+}
@@ -0,0 +1,270 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"math/big"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ppb "source.monogon.dev/metropolis/node/core/curator/proto/private"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+	epb "source.monogon.dev/metropolis/proto/ext"
+)
+
+// checkGrantable ensures that the caller holds all of the given permissions.
+// This prevents users with PERMISSION_MANAGE_ACCESS from escalating their own
+// privileges by creating or binding roles which grant more than they already
+// have.
+func checkGrantable(ctx context.Context, perms []epb.Permission) error {
+	pi := rpc.GetPeerInfo(ctx)
+	if pi == nil {
+		return status.Error(codes.PermissionDenied, "unknown caller")
+	}
+	for _, p := range perms {
+		if err := pi.CheckPermissions(rpc.Permissions{p: true}); err != nil {
+			return status.Errorf(codes.PermissionDenied, "cannot grant %s, which the caller does not hold", p)
+		}
+	}
+	return nil
+}
+
+func (l *leaderManagement) ListRoles(ctx context.Context, req *apb.ListRolesRequest) (*apb.ListRolesResponse, error) {
+	roles, err := rolesList(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+	return &apb.ListRolesResponse{
+		Roles: roles,
+	}, nil
+}
+
+func (l *leaderManagement) PutRole(ctx context.Context, req *apb.PutRoleRequest) (*apb.PutRoleResponse, error) {
+	if err := validateRole(req.Role); err != nil {
+		return nil, err
+	}
+	if err := checkGrantable(ctx, req.Role.Permissions); err != nil {
+		return nil, err
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	if err := accessSave(ctx, l.leadership, roleEtcdPrefix+req.Role.Name, req.Role); err != nil {
+		return nil, err
+	}
+	rpc.Trace(ctx).Printf("Role %q saved with permissions %v", req.Role.Name, req.Role.Permissions)
+	return &apb.PutRoleResponse{}, nil
+}
+
+func (l *leaderManagement) DeleteRole(ctx context.Context, req *apb.DeleteRoleRequest) (*apb.DeleteRoleResponse, error) {
+	if !reAccessName.MatchString(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid role name %q", req.Name)
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	if _, err := roleLoad(ctx, l.leadership, req.Name); err != nil {
+		return nil, err
+	}
+	bindings, err := roleBindingsList(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bindings {
+		for _, r := range b.Roles {
+			if r == req.Name {
+				return nil, status.Errorf(codes.FailedPrecondition, "role is still bound to identity %q", b.Identity)
+			}
+		}
+	}
+	if err := accessSave(ctx, l.leadership, roleEtcdPrefix+req.Name, nil); err != nil {
+		return nil, err
+	}
+	rpc.Trace(ctx).Printf("Role %q deleted", req.Name)
+	return &apb.DeleteRoleResponse{}, nil
+}
+
+func (l *leaderManagement) ListRoleBindings(ctx context.Context, req *apb.ListRoleBindingsRequest) (*apb.ListRoleBindingsResponse, error) {
+	bindings, err := roleBindingsList(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+	return &apb.ListRoleBindingsResponse{
+		RoleBindings: bindings,
+	}, nil
+}
+
+func (l *leaderManagement) PutRoleBinding(ctx context.Context, req *apb.PutRoleBindingRequest) (*apb.PutRoleBindingResponse, error) {
+	if err := validateRoleBinding(req.RoleBinding); err != nil {
+		return nil, err
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	for _, name := range req.RoleBinding.Roles {
+		r, err := roleLoad(ctx, l.leadership, name)
+		if err != nil {
+			if err == errRoleNotFound {
+				return nil, status.Errorf(codes.FailedPrecondition, "role %q does not exist", name)
+			}
+			return nil, err
+		}
+		if err := checkGrantable(ctx, r.Permissions); err != nil {
+			return nil, err
+		}
+	}
+	prev, err := roleBindingLoad(ctx, l.leadership, req.RoleBinding.Identity)
+	switch {
+	case err == errRoleBindingNotFound:
+	case err != nil:
+		return nil, err
+	case !bytes.Equal(prev.PublicKey, req.RoleBinding.PublicKey):
+		// The previous key is no longer bound, revoke its certificates.
+		if err := revokeBindingCertificates(ctx, l.leadership, req.RoleBinding.Identity); err != nil {
+			return nil, err
+		}
+	}
+	key := roleBindingEtcdPrefix + req.RoleBinding.Identity
+	if err := accessSave(ctx, l.leadership, key, req.RoleBinding); err != nil {
+		return nil, err
+	}
+	rpc.Trace(ctx).Printf("Identity %q bound to roles %v", req.RoleBinding.Identity, req.RoleBinding.Roles)
+	return &apb.PutRoleBindingResponse{}, nil
+}
+
+func (l *leaderManagement) DeleteRoleBinding(ctx context.Context, req *apb.DeleteRoleBindingRequest) (*apb.DeleteRoleBindingResponse, error) {
+	if !reAccessName.MatchString(req.Identity) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid identity %q", req.Identity)
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	if _, err := roleBindingLoad(ctx, l.leadership, req.Identity); err != nil {
+		return nil, err
+	}
+	if err := revokeBindingCertificates(ctx, l.leadership, req.Identity); err != nil {
+		return nil, err
+	}
+	if err := accessSave(ctx, l.leadership, roleBindingEtcdPrefix+req.Identity, nil); err != nil {
+		return nil, err
+	}
+	rpc.Trace(ctx).Printf("Role binding of %q deleted", req.Identity)
+	return &apb.DeleteRoleBindingResponse{}, nil
+}
+
+func (l *leaderManagement) ListCertificates(ctx context.Context, req *apb.ListCertificatesRequest) (*apb.ListCertificatesResponse, error) {
+	certs, err := certificatesList(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].NotBefore.AsTime().Before(certs[j].NotBefore.AsTime())
+	})
+	return &apb.ListCertificatesResponse{
+		Certificates: certs,
+	}, nil
+}
+
+func (l *leaderManagement) RevokeCertificate(ctx context.Context, req *apb.RevokeCertificateRequest) (*apb.RevokeCertificateResponse, error) {
+	serial, ok := new(big.Int).SetString(req.SerialNumber, 16)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid serial number %q", req.SerialNumber)
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	// Only certificates issued via AAA.Escrow can be revoked, so that node
+	// certificates cannot be revoked by accident.
+	c, err := certificateLoad(ctx, l.leadership, serial)
+	if err != nil {
+		return nil, err
+	}
+	// Revoking a certificate of the current owner key would permanently lock
+	// out the owner. The key needs to be rotated instead, which also revokes
+	// its certificates.
+	if c.Identity == "owner" {
+		opk, err := l.getOwnerPubkey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if opk.Equal(ed25519.PublicKey(c.PublicKey)) {
+			return nil, status.Error(codes.FailedPrecondition, "certificates of the current owner key cannot be revoked, rotate the owner key instead")
+		}
+	}
+	// Record that the key was explicitly revoked, even if the certificate was
+	// already revoked when its role binding was removed.
+	if err := revokedKeySave(ctx, l.leadership, c); err != nil {
+		return nil, err
+	}
+	if c.BindingRemoved {
+		c.BindingRemoved = false
+		if err := certificateRecordSave(ctx, l.leadership, c); err != nil {
+			return nil, err
+		}
+	}
+	if err := pkiCA.RevokeSerial(ctx, l.etcd, serial); err != nil {
+		rpc.Trace(ctx).Printf("could not revoke certificate: %v", err)
+		return nil, status.Error(codes.Unavailable, "could not revoke certificate")
+	}
+	rpc.Trace(ctx).Printf("Certificate %s of %q revoked", c.SerialNumber, c.Identity)
+	return &apb.RevokeCertificateResponse{}, nil
+}
+
+func (l *leaderManagement) RotateOwnerKey(ctx context.Context, req *apb.RotateOwnerKeyRequest) (*apb.RotateOwnerKeyResponse, error) {
+	if len(req.PublicKey) != ed25519.PublicKeySize {
+		return nil, status.Error(codes.InvalidArgument, "public_key must be a valid Ed25519 public key")
+	}
+	pk := ed25519.PublicKey(req.PublicKey)
+	// The owner holds all permissions, so whoever sets the owner key must
+	// already hold them, too.
+	var all []epb.Permission
+	for v := range epb.Permission_name {
+		if p := epb.Permission(v); p != epb.Permission_PERMISSION_UNSPECIFIED && p != epb.Permission_PERMISSION_UPDATE_NODE_SELF {
+			all = append(all, p)
+		}
+	}
+	if err := checkGrantable(ctx, all); err != nil {
+		return nil, err
+	}
+
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	opk, err := l.getOwnerPubkey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opk.Equal(pk) {
+		return nil, status.Error(codes.InvalidArgument, "public_key is already the owner key")
+	}
+	revoked, err := publicKeyRevoked(ctx, l.leadership, "owner", pk)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, status.Error(codes.InvalidArgument, "public_key has been revoked")
+	}
+
+	// Replace the key first, so that no further certificates are escrowed for
+	// the previous key while its certificates are revoked.
+	if err := accessSave(ctx, l.leadership, initialOwnerEtcdPath, &ppb.InitialOwner{PublicKey: pk}); err != nil {
+		return nil, err
+	}
+	if err := revokeIdentityCertificates(ctx, l.leadership, "owner", false); err != nil {
+		return nil, err
+	}
+	rpc.Trace(ctx).Printf("Owner key rotated")
+	return &apb.RotateOwnerKeyResponse{}, nil
+}
@@ -0,0 +1,392 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/tpm"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// attestationChallengeTimeout is the time after which a challenge returned
+	// by GetAttestationChallenge expires if it wasn't used.
+	attestationChallengeTimeout = 5 * time.Minute
+	// maxAttestationChallenges is the maximum number of pending attestation
+	// challenges. As GetAttestationChallenge is unauthenticated, this limits
+	// the memory which can be consumed by callers.
+	maxAttestationChallenges = 1024
+	// maxAttestationChallengesPerSource is the maximum number of pending
+	// attestation challenges retrieved from a single source address. As
+	// ephemeral certificates are free to generate, this keeps a single host
+	// from exhausting maxAttestationChallenges.
+	maxAttestationChallengesPerSource = 8
+	// maxAttestationKeySize is the maximum size of the EK and AK submitted to
+	// GetAttestationChallenge.
+	maxAttestationKeySize = 4096
+	// maxEKCertificateSize is the maximum size of the EK certificate submitted
+	// to GetAttestationChallenge.
+	maxEKCertificateSize = 8192
+	// attestationPCRs is the number of PCRs quoted by nodes, ie. the SRTM PCRs
+	// 0-15.
+	attestationPCRs = 16
+)
+
+// attestationChallenge is a pending challenge returned by
+// GetAttestationChallenge, kept in leaderState until it is used in a
+// RegisterNode or JoinNode call or expires.
+type attestationChallenge struct {
+	// nonce identifies the challenge, and must be quoted by the TPM.
+	nonce []byte
+	// caller is the public key of the ephemeral certificate with which the
+	// challenge was retrieved. The challenge can only be used by the same
+	// caller.
+	caller []byte
+	// source is the address of the host which retrieved the challenge, used
+	// to limit the number of pending challenges per host.
+	source string
+	// ekPub is the DER-encoded PKIX public key of the EK of the TPM which the
+	// challenge was made for.
+	ekPub []byte
+	// akPub is the TPM2B_PUBLIC of the AK which the challenge was made for.
+	akPub []byte
+	// ekCert is the certificate of the EK, which has been checked to certify
+	// ekPub, but not to be issued by a trusted CA.
+	ekCert *x509.Certificate
+	// secret is the secret which only the TPM holding both keys can recover.
+	secret []byte
+	// expires is the time after which the challenge cannot be used anymore.
+	expires time.Time
+}
+
+func (l *leaderCurator) GetAttestationChallenge(ctx context.Context, req *ipb.GetAttestationChallengeRequest) (*ipb.GetAttestationChallengeResponse, error) {
+	// Call is unauthenticated - verify the other side has connected with an
+	// ephemeral certificate, to which the challenge will be bound.
+	pi := rpc.GetPeerInfo(ctx)
+	if pi == nil || pi.Unauthenticated == nil || pi.Unauthenticated.SelfSignedPublicKey == nil {
+		return nil, status.Error(codes.Unauthenticated, "connection must be established with a self-signed ephemeral certificate")
+	}
+
+	c, res, err := newAttestationChallenge(pi.Unauthenticated.SelfSignedPublicKey, req)
+	if err != nil {
+		return nil, err
+	}
+	c.source = peerSource(ctx)
+
+	if err := l.addAttestationChallenge(c); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// addAttestationChallenge adds c to the pending challenges, replacing any
+// challenge pending for the same caller. A gRPC status is returned if too many
+// challenges are pending.
+func (l *leaderCurator) addAttestationChallenge(c *attestationChallenge) error {
+	l.ls.muAttestation.Lock()
+	defer l.ls.muAttestation.Unlock()
+	if l.ls.attestationChallenges == nil {
+		l.ls.attestationChallenges = make(map[string]*attestationChallenge)
+	}
+	// Drop expired challenges, and any challenge previously retrieved by the
+	// same caller, which can only have one pending challenge at a time.
+	now := time.Now()
+	fromSource := 0
+	for k, pc := range l.ls.attestationChallenges {
+		if now.After(pc.expires) || bytes.Equal(pc.caller, c.caller) {
+			delete(l.ls.attestationChallenges, k)
+			continue
+		}
+		if pc.source == c.source {
+			fromSource++
+		}
+	}
+	if fromSource >= maxAttestationChallengesPerSource {
+		return status.Error(codes.ResourceExhausted, "too many pending attestation challenges from this address")
+	}
+	if len(l.ls.attestationChallenges) >= maxAttestationChallenges {
+		return status.Error(codes.ResourceExhausted, "too many pending attestation challenges")
+	}
+	l.ls.attestationChallenges[string(c.nonce)] = c
+	return nil
+}
+
+// peerSource returns the host address of the gRPC peer of ctx, or an empty
+// string if it is not known.
+func peerSource(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// newAttestationChallenge creates a challenge for the TPM with the EK and AK
+// given in req, bound to caller. A gRPC status is returned if req is invalid.
+func newAttestationChallenge(caller []byte, req *ipb.GetAttestationChallengeRequest) (*attestationChallenge, *ipb.GetAttestationChallengeResponse, error) {
+	if len(req.EkPublicKey) == 0 || len(req.EkPublicKey) > maxAttestationKeySize {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "ek_public_key must be set and at most %d bytes long", maxAttestationKeySize)
+	}
+	if len(req.AkPublic) == 0 || len(req.AkPublic) > maxAttestationKeySize {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "ak_public must be set and at most %d bytes long", maxAttestationKeySize)
+	}
+	if len(req.EkCertificate) == 0 || len(req.EkCertificate) > maxEKCertificateSize {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "ek_certificate must be set and at most %d bytes long", maxEKCertificateSize)
+	}
+	ekCert, err := parseEKCertificate(req.EkCertificate, req.EkPublicKey)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid ek_certificate: %v", err)
+	}
+
+	secret := make([]byte, 32)
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, nil, status.Errorf(codes.Unavailable, "could not generate secret: %v", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, status.Errorf(codes.Unavailable, "could not generate nonce: %v", err)
+	}
+	credBlob, encSecret, err := tpm.MakeAKChallenge(req.EkPublicKey, req.AkPublic, secret)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "could not make challenge: %v", err)
+	}
+
+	c := &attestationChallenge{
+		nonce:   nonce,
+		caller:  caller,
+		ekPub:   req.EkPublicKey,
+		akPub:   req.AkPublic,
+		ekCert:  ekCert,
+		secret:  secret,
+		expires: time.Now().Add(attestationChallengeTimeout),
+	}
+	return c, &ipb.GetAttestationChallengeResponse{
+		Nonce:           nonce,
+		CredentialBlob:  credBlob,
+		EncryptedSecret: encSecret,
+	}, nil
+}
+
+// takeAttestationChallenge removes the challenge with the given nonce from the
+// pending challenges and returns it, or returns nil if no such challenge is
+// pending or it has expired.
+func (l *leaderCurator) takeAttestationChallenge(nonce []byte) *attestationChallenge {
+	l.ls.muAttestation.Lock()
+	defer l.ls.muAttestation.Unlock()
+	c, ok := l.ls.attestationChallenges[string(nonce)]
+	if !ok {
+		return nil
+	}
+	delete(l.ls.attestationChallenges, string(nonce))
+	if time.Now().After(c.expires) {
+		return nil
+	}
+	return c
+}
+
+// verifyAttestation verifies an attestation submitted by caller as part of the
+// given operation (RegisterNode or JoinNode) and returns its outcome. If ekPub
+// is set, the attestation must have been performed by the TPM with this EK.
+//
+// The outcome is always returned, even if the attestation failed. The caller
+// is responsible for recording it and enforcing the cluster's policy with
+// enforceAttestation.
+func (l *leaderCurator) verifyAttestation(ctx context.Context, operation string, caller []byte, a *ipb.Attestation, ekPub []byte, policy *cpb.ClusterConfiguration_TPMAttestation) *cpb.NodeAttestation {
+	res := &cpb.NodeAttestation{
+		Result:    cpb.NodeAttestation_RESULT_MISSING,
+		Timestamp: tpb.Now(),
+		Operation: operation,
+	}
+	if a == nil {
+		return res
+	}
+
+	c := l.takeAttestationChallenge(a.Nonce)
+	if c == nil {
+		return failAttestation(ctx, res, "unknown or expired challenge")
+	}
+	if !bytes.Equal(c.caller, caller) {
+		res.EkPublicKey = c.ekPub
+		return failAttestation(ctx, res, "challenge was retrieved by another caller")
+	}
+	return checkAttestation(ctx, res, c, a, ekPub, policy)
+}
+
+// checkAttestation verifies that the attestation a responds to the challenge c
+// and sets the outcome in res, which is then returned. If ekPub is set, the
+// attestation must have been performed by the TPM with this EK. If policy is
+// set, the quoted PCRs must be allowed by it.
+func checkAttestation(ctx context.Context, res *cpb.NodeAttestation, c *attestationChallenge, a *ipb.Attestation, ekPub []byte, policy *cpb.ClusterConfiguration_TPMAttestation) *cpb.NodeAttestation {
+	res.EkPublicKey = c.ekPub
+	if !bytes.Equal(c.nonce, a.Nonce) {
+		return failAttestation(ctx, res, "nonce does not match challenge")
+	}
+	// Recovering the secret proves that the AK is resident in the TPM holding
+	// the EK.
+	if subtle.ConstantTimeCompare(c.secret, a.ActivatedSecret) != 1 {
+		return failAttestation(ctx, res, "invalid activated secret")
+	}
+	if ekPub != nil && !bytes.Equal(ekPub, c.ekPub) {
+		return failAttestation(ctx, res, "endorsement key differs from the one recorded for the node")
+	}
+	if cas := policy.GetEkCaCertificates(); len(cas) > 0 {
+		if err := verifyEKCertificate(c.ekCert, cas); err != nil {
+			return failAttestation(ctx, res, "invalid endorsement key certificate: %v", err)
+		}
+	}
+
+	quote, err := tpm.VerifyAttestPlatform(a.Nonce, c.akPub, a.Quote, a.QuoteSignature)
+	if err != nil {
+		return failAttestation(ctx, res, "invalid quote: %v", err)
+	}
+	qi := quote.AttestedQuoteInfo
+	if qi == nil || qi.PCRSelection.Hash != tpm2.AlgSHA256 || len(qi.PCRSelection.PCRs) != attestationPCRs {
+		return failAttestation(ctx, res, "quote does not cover SHA256 PCRs 0-%d", attestationPCRs-1)
+	}
+	for i, pcr := range qi.PCRSelection.PCRs {
+		if pcr != i {
+			return failAttestation(ctx, res, "quote does not cover SHA256 PCRs 0-%d", attestationPCRs-1)
+		}
+	}
+	if len(a.Pcrs) != attestationPCRs {
+		return failAttestation(ctx, res, "expected %d PCR values, got %d", attestationPCRs, len(a.Pcrs))
+	}
+	h := sha256.New()
+	for i, pcr := range a.Pcrs {
+		if len(pcr) != sha256.Size {
+			return failAttestation(ctx, res, "PCR %d has invalid length %d", i, len(pcr))
+		}
+		h.Write(pcr)
+	}
+	if !bytes.Equal(h.Sum(nil), qi.PCRDigest) {
+		return failAttestation(ctx, res, "PCR values do not match quote")
+	}
+	res.Pcrs = a.Pcrs
+
+	if allowed := policy.GetAllowedPcrValues(); len(allowed) > 0 {
+		matched := false
+		for _, values := range allowed {
+			if pcrsMatch(a.Pcrs, values.Pcrs) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return failAttestation(ctx, res, "PCR values not allowed by cluster configuration")
+		}
+	}
+
+	res.Result = cpb.NodeAttestation_RESULT_PASSED
+	return res
+}
+
+// oidSubjectAltName is the OID of the subject alternative name extension.
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// parseEKCertificate parses a DER-encoded EK certificate and checks that it
+// certifies the given DER-encoded PKIX public key.
+func parseEKCertificate(der, ekPub []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported public key: %w", err)
+	}
+	if !bytes.Equal(certPub, ekPub) {
+		return nil, fmt.Errorf("certifies a different public key")
+	}
+	return cert, nil
+}
+
+// verifyEKCertificate checks that an EK certificate was issued by one of the
+// given DER-encoded CA certificates, as configured in
+// TPMAttestation.ek_ca_certificates.
+func verifyEKCertificate(cert *x509.Certificate, cas [][]byte) error {
+	if cert == nil {
+		return fmt.Errorf("missing")
+	}
+	roots := x509.NewCertPool()
+	for _, der := range cas {
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid CA certificate in cluster configuration: %w", err)
+		}
+		roots.AddCert(ca)
+	}
+	// EK certificates identify the TPM in a critical subject alternative
+	// name containing a directoryName, which crypto/x509 does not handle. It
+	// is not relevant for verifying the issuer, so ignore it.
+	c := *cert
+	c.UnhandledCriticalExtensions = slices.DeleteFunc(slices.Clone(c.UnhandledCriticalExtensions), func(oid asn1.ObjectIdentifier) bool {
+		return oid.Equal(oidSubjectAltName)
+	})
+	_, err := c.Verify(x509.VerifyOptions{
+		Roots: roots,
+		// EK certificates carry the TCG specific EK certificate extended key
+		// usage.
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// failAttestation marks res as failed for the given reason and returns it.
+func failAttestation(ctx context.Context, res *cpb.NodeAttestation, format string, args ...any) *cpb.NodeAttestation {
+	res.Result = cpb.NodeAttestation_RESULT_FAILED
+	res.Reason = fmt.Sprintf(format, args...)
+	rpc.Trace(ctx).Printf("Attestation failed: %s", res.Reason)
+	return res
+}
+
+// pcrsMatch returns whether all expected PCR values are equal to the given
+// PCR values.
+func pcrsMatch(pcrs [][]byte, expected map[uint32][]byte) bool {
+	for index, value := range expected {
+		if int(index) >= len(pcrs) || !bytes.Equal(pcrs[index], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// enforceAttestation returns a gRPC status if the given attestation outcome is
+// not accepted by the cluster's TPM attestation policy.
+func enforceAttestation(cl *Cluster, a *cpb.NodeAttestation) error {
+	if !cl.TPMAttestation.GetRequired() {
+		return nil
+	}
+	switch a.Result {
+	case cpb.NodeAttestation_RESULT_PASSED:
+		return nil
+	case cpb.NodeAttestation_RESULT_MISSING:
+		return status.Error(codes.PermissionDenied, "cluster requires TPM attestation")
+	default:
+		return status.Errorf(codes.PermissionDenied, "TPM attestation failed: %s", a.Reason)
+	}
+}
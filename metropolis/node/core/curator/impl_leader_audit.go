@@ -0,0 +1,129 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"context"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+// nodeManagementMethodPrefix is the prefix of the full gRPC method names of
+// the NodeManagement service, calls to which are reported by nodes.
+const nodeManagementMethodPrefix = "/metropolis.proto.api.NodeManagement/"
+
+// auditRecord is an rpc.AuditRecorder which appends entries to the audit log.
+func (l *leadership) auditRecord(ctx context.Context, entry *apb.AuditEntry) error {
+	if err := auditAppend(ctx, l, entry); err != nil {
+		auditFailures.Inc()
+		return err
+	}
+	return nil
+}
+
+// ReportAuditEntry implements Curator.ReportAuditEntry, which records calls to
+// the NodeManagement service of a node in the audit log.
+func (l *leaderCurator) ReportAuditEntry(ctx context.Context, req *ipb.ReportAuditEntryRequest) (*ipb.ReportAuditEntryResponse, error) {
+	pi := rpc.GetPeerInfo(ctx)
+	if pi == nil || pi.Node == nil {
+		return nil, status.Error(codes.PermissionDenied, "only nodes can report audit entries")
+	}
+	entry := req.Entry
+	if entry == nil {
+		return nil, status.Error(codes.InvalidArgument, "entry must be set")
+	}
+	if !strings.HasPrefix(entry.Method, nodeManagementMethodPrefix) {
+		return nil, status.Errorf(codes.InvalidArgument, "method must be part of the NodeManagement service")
+	}
+	if entry.Time == nil {
+		entry.Time = tpb.Now()
+	}
+	entry.Node = pi.Node.ID
+	if err := auditAppend(ctx, l.leadership, entry); err != nil {
+		return nil, err
+	}
+	return &ipb.ReportAuditEntryResponse{}, nil
+}
+
+// GetAuditLog implements Management.GetAuditLog, which streams entries of the
+// audit log.
+func (l *leaderManagement) GetAuditLog(req *apb.GetAuditLogRequest, srv apb.Management_GetAuditLogServer) error {
+	ctx := srv.Context()
+
+	filter, err := buildAuditFilter(ctx, req.Filter)
+	if err != nil {
+		return err
+	}
+
+	lastID := req.AfterId
+	send := func(value []byte) error {
+		var entry apb.AuditEntry
+		if err := proto.Unmarshal(value, &entry); err != nil {
+			rpc.Trace(ctx).Printf("Unmarshalling audit entry failed: %v", err)
+			return nil
+		}
+		if entry.Id <= lastID {
+			return nil
+		}
+		lastID = entry.Id
+		keep, err := filter(ctx, &entry)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			return nil
+		}
+		return srv.Send(&entry)
+	}
+
+	// Retrieve and send existing entries.
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(auditKey(lastID+1), clientv3.WithRange(clientv3.GetPrefixRangeEnd(auditEtcdPrefix))))
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		return status.Errorf(codes.Unavailable, "could not retrieve audit log: %v", err)
+	}
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		if err := send(kv.Value); err != nil {
+			return err
+		}
+	}
+	if !req.Follow {
+		return nil
+	}
+
+	// Send new entries as they get appended. The watch starts right after the
+	// revision of the initial retrieval, so no entries are missed.
+	wch := l.etcd.Watch(ctx, auditEtcdPrefix, clientv3.WithPrefix(), clientv3.WithRev(res.Header.Revision+1))
+	for wres := range wch {
+		if err := wres.Err(); err != nil {
+			if rpcErr, ok := rpcError(err); ok {
+				return rpcErr
+			}
+			return status.Errorf(codes.Unavailable, "could not watch audit log: %v", err)
+		}
+		for _, ev := range wres.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			if err := send(ev.Kv.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unavailable, "audit log watch closed")
+}
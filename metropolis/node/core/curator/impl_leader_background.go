@@ -22,6 +22,15 @@ func (l *leaderBackground) background(ctx context.Context) error {
 	if err := supervisor.Run(ctx, "sync-etcd", l.backgroundSyncEtcd); err != nil {
 		return err
 	}
+	if err := supervisor.Run(ctx, "rollout", l.backgroundRollout); err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "backup", l.backgroundBackup); err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "certificates", l.backgroundCertificates); err != nil {
+		return err
+	}
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
 	<-ctx.Done()
@@ -161,3 +170,35 @@ func (l *leaderBackground) doSyncEtcd(ctx context.Context) error {
 
 	return nil
 }
+
+// backgroundCertificates removes the records of user certificates once they
+// have been expired for certificateRetention, so that they do not accumulate
+// in etcd.
+func (l *leaderBackground) backgroundCertificates(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	for {
+		if err := l.doRemoveExpiredCertificates(ctx); err != nil {
+			return err
+		}
+		// Process every hour.
+		select {
+		case <-time.After(time.Hour):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *leaderBackground) doRemoveExpiredCertificates(ctx context.Context) error {
+	l.muAccess.Lock()
+	defer l.muAccess.Unlock()
+
+	n, err := certificatesRemoveExpired(ctx, l.leadership, time.Now().Add(-certificateRetention))
+	if err != nil {
+		return fmt.Errorf("could not remove expired certificates: %w", err)
+	}
+	if n > 0 {
+		supervisor.Logger(ctx).Infof("Removed records of %d expired certificates.", n)
+	}
+	return nil
+}
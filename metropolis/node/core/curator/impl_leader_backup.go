@@ -0,0 +1,317 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/opencontainers/go-digest"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/node/core/backup"
+	"source.monogon.dev/metropolis/node/core/productinfo"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/oci/registry"
+	"source.monogon.dev/osbase/supervisor"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// backupLastKey is the etcd key at which the time of the last successful
+	// backup is stored, so that the backup schedule survives leader changes.
+	backupLastKey = "/backup/last"
+
+	// backupDefaultRetain is the number of backups kept if the cluster
+	// configuration doesn't specify it.
+	backupDefaultRetain = 7
+
+	// backupTimeFormat is the format of the time at which a backup was taken,
+	// used as its tag or file name. Backups sort chronologically by name.
+	backupTimeFormat = "20060102T150405Z"
+
+	// backupFileSuffix is the suffix of locally stored backup files.
+	backupFileSuffix = ".backup"
+
+	// backupArtifactType and backupMediaType are the OCI artifact type and
+	// layer media type of backups pushed to an OCI repository.
+	backupArtifactType = "application/vnd.monogon.metropolis.backup.v1"
+	backupMediaType    = "application/vnd.monogon.metropolis.backup.v1.encrypted"
+)
+
+// backgroundBackup periodically takes encrypted backups of the consensus
+// database as configured in the cluster configuration.
+func (l *leaderBackground) backgroundBackup(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	for {
+		// Check every minute, which is the minimum backup interval.
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// Failing backups should not affect other leader processing, so errors
+		// are only logged and counted, and the backup is retried on the next
+		// check.
+		if err := l.doBackup(ctx); err != nil {
+			backupFailures.Inc()
+			supervisor.Logger(ctx).Warningf("Backup failed: %v", err)
+		}
+	}
+}
+
+func (l *leaderBackground) doBackup(ctx context.Context) error {
+	cl, err := clusterLoad(ctx, l.leadership)
+	if err != nil {
+		return fmt.Errorf("could not load cluster configuration: %w", err)
+	}
+	cfg := cl.Backup
+	if cfg == nil || cfg.Interval == nil || cfg.Destination == nil {
+		return nil
+	}
+
+	last, err := l.backupLastLoad(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Sub(last) < cfg.Interval.AsDuration() {
+		return nil
+	}
+
+	data, err := l.backupTake(ctx)
+	if err != nil {
+		return err
+	}
+	retain := int(cfg.Retain)
+	if retain == 0 {
+		retain = backupDefaultRetain
+	}
+	name := now.UTC().Format(backupTimeFormat)
+	switch dst := cfg.Destination.(type) {
+	case *cpb.ClusterConfiguration_Backup_Oci:
+		err = l.backupPushOCI(ctx, dst.Oci, name, data, retain)
+	case *cpb.ClusterConfiguration_Backup_Local_:
+		err = l.backupWriteLocal(name, data, retain)
+	default:
+		err = fmt.Errorf("unknown destination %T", dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = l.txnAsLeader(ctx, clientv3.OpPut(backupLastKey, now.UTC().Format(time.RFC3339Nano)))
+	if err != nil {
+		return fmt.Errorf("could not save time of last backup: %w", err)
+	}
+	backupLastSuccess.Set(float64(now.Unix()))
+	backupLastSize.Set(float64(len(data)))
+	supervisor.Logger(ctx).Infof("Backup %s (%d bytes) done.", name, len(data))
+	return nil
+}
+
+// backupLastLoad returns the time of the last successful backup, or the zero
+// time if no backup has been taken yet.
+func (l *leaderBackground) backupLastLoad(ctx context.Context) (time.Time, error) {
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(backupLastKey))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not load time of last backup: %w", err)
+	}
+	kvs := res.Responses[0].GetResponseRange().Kvs
+	if len(kvs) != 1 {
+		return time.Time{}, nil
+	}
+	last, err := time.Parse(time.RFC3339Nano, string(kvs[0].Value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of last backup: %w", err)
+	}
+	return last, nil
+}
+
+// backupKey returns the key with which backups are encrypted.
+func (l *leadership) backupKey(ctx context.Context) ([]byte, error) {
+	if _, err := pkiCA.Ensure(ctx, l.etcd); err != nil {
+		return nil, fmt.Errorf("could not load cluster CA: %w", err)
+	}
+	return backup.Key(pkiCA.PrivateKey)
+}
+
+// backupTake takes an encrypted snapshot of the consensus database.
+func (l *leaderBackground) backupTake(ctx context.Context) ([]byte, error) {
+	if l.consensusStatus == nil {
+		return nil, fmt.Errorf("consensus not available")
+	}
+	key, err := l.backupKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := l.consensusStatus.Snapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not take snapshot: %w", err)
+	}
+	defer r.Close()
+	snapshot, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	return backup.Encrypt(key, snapshot)
+}
+
+// backupClient returns a registry client for the given destination. If
+// running with node credentials, they are presented to the registry as a TLS
+// client certificate.
+func (l *leaderBackground) backupClient(ctx context.Context, dst *cpb.ClusterConfiguration_Backup_OCI) *registry.Client {
+	client := &registry.Client{
+		GetBackOff: func() backoff.BackOff {
+			return backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(5 * time.Minute))
+		},
+		RetryNotify: func(err error, d time.Duration) {
+			supervisor.Logger(ctx).Warningf("Error while pushing backup, retrying in %v: %v", d, err)
+		},
+		UserAgent:  "MonogonOS/" + productinfo.Get().VersionString,
+		Scheme:     dst.Scheme,
+		Host:       dst.Host,
+		Repository: dst.Repository,
+	}
+	if l.nodeCredentials != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			GetClientCertificate: l.nodeCredentials.GetClientCertificate,
+		}
+		client.Transport = transport
+	}
+	return client
+}
+
+// backupPushOCI pushes a backup as an artifact to an OCI repository, tagged
+// with its name, and then deletes all but the newest retain backups.
+func (l *leaderBackground) backupPushOCI(ctx context.Context, dst *cpb.ClusterConfiguration_Backup_OCI, name string, data []byte, retain int) error {
+	client := l.backupClient(ctx, dst)
+
+	configDigest, err := client.PushBlob(ctx, ocispecv1.DescriptorEmptyJSON.Data)
+	if err != nil {
+		return fmt.Errorf("could not push config: %w", err)
+	}
+	layerDigest, err := client.PushBlob(ctx, data)
+	if err != nil {
+		return fmt.Errorf("could not push backup: %w", err)
+	}
+	manifest := ocispecv1.Manifest{
+		MediaType:    ocispecv1.MediaTypeImageManifest,
+		ArtifactType: backupArtifactType,
+		Config: ocispecv1.Descriptor{
+			MediaType: ocispecv1.MediaTypeEmptyJSON,
+			Digest:    digest.Digest(configDigest),
+			Size:      int64(len(ocispecv1.DescriptorEmptyJSON.Data)),
+		},
+		Layers: []ocispecv1.Descriptor{{
+			MediaType: backupMediaType,
+			Digest:    digest.Digest(layerDigest),
+			Size:      int64(len(data)),
+		}},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(&manifest)
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %w", err)
+	}
+	if _, err := client.PushManifest(ctx, name, ocispecv1.MediaTypeImageManifest, manifestBytes); err != nil {
+		return fmt.Errorf("could not push manifest: %w", err)
+	}
+
+	tags, err := client.ListTags(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	for _, tag := range backupsToDelete(tags, retain) {
+		// Delete by digest, as not all registries support deleting tags.
+		image, err := client.Read(ctx, tag, "")
+		if err != nil {
+			return fmt.Errorf("could not read backup %s for deletion: %w", tag, err)
+		}
+		if err := client.DeleteManifest(ctx, image.ManifestDigest); err != nil {
+			return fmt.Errorf("could not delete backup %s: %w", tag, err)
+		}
+		supervisor.Logger(ctx).Infof("Deleted old backup %s.", tag)
+	}
+	return nil
+}
+
+// backupWriteLocal writes a backup to the local backup directory, and then
+// deletes all but the newest retain backups.
+func (l *leaderBackground) backupWriteLocal(name string, data []byte, retain int) error {
+	if l.backupPath == "" {
+		return fmt.Errorf("local backups not available on this node")
+	}
+	if err := os.MkdirAll(l.backupPath, 0700); err != nil {
+		return fmt.Errorf("could not create backup directory: %w", err)
+	}
+	// Write to a temporary file first, so that a partially written backup is
+	// never mistaken for a complete one.
+	path := filepath.Join(l.backupPath, name+backupFileSuffix)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+
+	entries, err := os.ReadDir(l.backupPath)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if n, ok := strings.CutSuffix(entry.Name(), backupFileSuffix); ok {
+			names = append(names, n)
+		}
+	}
+	for _, n := range backupsToDelete(names, retain) {
+		if err := os.Remove(filepath.Join(l.backupPath, n+backupFileSuffix)); err != nil {
+			return fmt.Errorf("could not delete backup %s: %w", n, err)
+		}
+	}
+	return nil
+}
+
+// backupsToDelete returns the names of all backups except for the newest
+// retain ones. Names which are not backup names are ignored.
+func backupsToDelete(names []string, retain int) []string {
+	var backups []string
+	for _, name := range names {
+		if _, err := time.Parse(backupTimeFormat, name); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	slices.Sort(backups)
+	if len(backups) <= retain {
+		return nil
+	}
+	return backups[:len(backups)-retain]
+}
+
+// GetBackupKey implements Management.GetBackupKey.
+func (l *leaderManagement) GetBackupKey(ctx context.Context, req *apb.GetBackupKeyRequest) (*apb.GetBackupKeyResponse, error) {
+	key, err := l.backupKey(ctx)
+	if err != nil {
+		rpc.Trace(ctx).Printf("backupKey: %v", err)
+		return nil, status.Error(codes.Unavailable, "could not derive backup key")
+	}
+	return &apb.GetBackupKeyResponse{Key: key}, nil
+}
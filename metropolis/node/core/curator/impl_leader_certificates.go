@@ -13,6 +13,7 @@ import (
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	kpki "source.monogon.dev/metropolis/node/kubernetes/pki"
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
 func issueKubernetesWorkerCertificates(ctx context.Context, kp *kpki.PKI, nodeID string, req *ipb.IssueCertificateRequest_KubernetesWorker) (*ipb.IssueCertificateResponse, error) {
@@ -90,20 +91,73 @@ func (l *leaderCurator) IssueCertificate(ctx context.Context, req *ipb.IssueCert
 		return nil, status.Errorf(codes.Unavailable, "could not load node info: %v", err)
 	}
 
-	pki, err := kpki.FromLocalConsensus(ctx, l.consensus)
-	if err != nil {
-		return nil, status.Errorf(codes.Unavailable, "could not get kube PKI: %v", err)
-	}
-
 	// Issue certificate if appropriate.
 	switch kind := req.Kind.(type) {
 	case *ipb.IssueCertificateRequest_KubernetesWorker_:
+		if pi.Node.CertificateExpired {
+			rpc.Trace(ctx).Printf("refusing to issue kube worker certificates for node %s with expired certificate", id)
+			return nil, status.Errorf(codes.Unauthenticated, "node %s must renew its expired certificate first", id)
+		}
 		if node.kubernetesWorker == nil {
 			rpc.Trace(ctx).Printf("refusing to issue kube worker certificates for node %s", id)
 			return nil, status.Errorf(codes.PermissionDenied, "node %s cannot request a kubelet certificate", id)
 		}
+		pki, err := kpki.FromLocalConsensus(ctx, l.consensus)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "could not get kube PKI: %v", err)
+		}
 		return issueKubernetesWorkerCertificates(ctx, pki, node.ID(), kind.KubernetesWorker)
+	case *ipb.IssueCertificateRequest_Node_:
+		if node.state != cpb.NodeState_NODE_STATE_UP {
+			rpc.Trace(ctx).Printf("refusing to renew certificate for node %s in state %s", id, node.state)
+			return nil, status.Errorf(codes.PermissionDenied, "node %s is not UP", id)
+		}
+		return l.issueNodeCertificate(ctx, node)
 	default:
 		return nil, status.Error(codes.InvalidArgument, "certificate kind must be set")
 	}
 }
+
+// issueNodeCertificate returns the certificate of the given node, which is
+// reissued by the cluster CA if it needs renewal.
+func (l *leaderCurator) issueNodeCertificate(ctx context.Context, node *Node) (*ipb.IssueCertificateResponse, error) {
+	caCertBytes, err := pkiCA.Ensure(ctx, l.etcd)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get CA certificate: %v", err)
+	}
+	nodeCertBytes, err := pkiNodeCertificate(node).Ensure(ctx, l.etcd)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not renew node certificate: %v", err)
+	}
+	return &ipb.IssueCertificateResponse{
+		Kind: &ipb.IssueCertificateResponse_Node_{
+			Node: &ipb.IssueCertificateResponse_Node{
+				CaCertificate:   caCertBytes,
+				NodeCertificate: nodeCertBytes,
+			},
+		},
+	}, nil
+}
+
+// WatchCRL implements the WatchCRL API by piping the CRL of the cluster CA from
+// etcd to the caller.
+func (l *leaderCurator) WatchCRL(_ *ipb.WatchCRLRequest, srv ipb.Curator_WatchCRLServer) error {
+	ctx := srv.Context()
+
+	w := pkiCA.WatchCRL(l.etcd)
+	defer w.Close()
+
+	for {
+		crl, err := w.Get(ctx)
+		if err != nil {
+			if rpcErr, ok := rpcError(err); ok {
+				return rpcErr
+			}
+			rpc.Trace(ctx).Printf("etcd watch failed: %v", err)
+			return status.Error(codes.Unavailable, "internal error")
+		}
+		if err := srv.Send(&ipb.WatchCRLResponse{Crl: crl.Raw}); err != nil {
+			return err
+		}
+	}
+}
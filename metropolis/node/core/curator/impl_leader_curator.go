@@ -27,7 +27,6 @@ import (
 	cpb "source.monogon.dev/metropolis/proto/common"
 	"source.monogon.dev/osbase/event"
 	"source.monogon.dev/osbase/event/etcd"
-	"source.monogon.dev/osbase/pki"
 )
 
 // leaderCurator implements the Curator gRPC API (ipb.Curator) as a curator
@@ -200,12 +199,14 @@ func nodeValueConverter(key, value []byte) (*nodeAtID, error) {
 func (n *Node) appendToEvent(ev *ipb.WatchEvent) {
 	np := n.proto()
 	ev.Nodes = append(ev.Nodes, &ipb.Node{
-		Id:         n.ID(),
-		Roles:      np.Roles,
-		Status:     np.Status,
-		Clusternet: np.Clusternet,
-		State:      np.FsmState,
-		Labels:     np.Labels,
+		Id:                n.ID(),
+		Roles:             np.Roles,
+		Status:            np.Status,
+		Clusternet:        np.Clusternet,
+		State:             np.FsmState,
+		Labels:            np.Labels,
+		Update:            np.Update,
+		TimeConfiguration: np.TimeConfiguration,
 	})
 }
 
@@ -261,6 +262,7 @@ func (l *leaderCurator) UpdateNodeStatus(ctx context.Context, req *ipb.UpdateNod
 	// ... update its' status ...
 	node.status = req.Status
 	node.status.Timestamp = tpb.Now()
+	node.updateStatus = req.UpdateStatus
 	// ... and save it to etcd.
 	if err := nodeSave(ctx, l.leadership, node); err != nil {
 		return nil, err
@@ -370,6 +372,18 @@ func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeR
 		return nil, err
 	}
 
+	// Verify the node's TPM, if it attested. The EK of a node passing
+	// attestation is recorded, and further attestations of the node must be
+	// made by the same TPM.
+	attestation := l.verifyAttestation(ctx, "RegisterNode", pubkey, req.Attestation, nil, cl.TPMAttestation)
+	if err := enforceAttestation(cl, attestation); err != nil {
+		return nil, err
+	}
+	var ekPub []byte
+	if attestation.Result == cpb.NodeAttestation_RESULT_PASSED {
+		ekPub = attestation.EkPublicKey
+	}
+
 	// Populate node labels if applicable.
 	labels := make(map[string]string)
 	if l := req.Labels; l != nil {
@@ -399,12 +413,14 @@ func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeR
 
 	// No node exists, create one.
 	node = &Node{
-		id:       id,
-		pubkey:   pubkey,
-		jkey:     req.JoinKey,
-		state:    cpb.NodeState_NODE_STATE_NEW,
-		tpmUsage: tpmUsage,
-		labels:   labels,
+		id:          id,
+		pubkey:      pubkey,
+		jkey:        req.JoinKey,
+		state:       cpb.NodeState_NODE_STATE_NEW,
+		tpmUsage:    tpmUsage,
+		labels:      labels,
+		ekPub:       ekPub,
+		attestation: attestation,
 	}
 	if err := nodeSave(ctx, l.leadership, node); err != nil {
 		return nil, err
@@ -497,14 +513,7 @@ func (l *leaderCurator) CommitNode(ctx context.Context, req *ipb.CommitNodeReque
 	if err != nil {
 		return nil, status.Errorf(codes.Unavailable, "could not get CA certificate: %v", err)
 	}
-	nodeCert := &pki.Certificate{
-		Namespace: &pkiNamespace,
-		Issuer:    pkiCA,
-		Template:  identity.NodeCertificate(node.ID()),
-		Mode:      pki.CertificateExternal,
-		PublicKey: node.pubkey,
-		Name:      fmt.Sprintf("node-%s", node.ID()),
-	}
+	nodeCert := pkiNodeCertificate(node)
 	nodeCertBytes, err := nodeCert.Ensure(ctx, l.etcd)
 	if err != nil {
 		return nil, status.Errorf(codes.Unavailable, "could not emit node credentials: %v", err)
@@ -578,6 +587,23 @@ func (l *leaderCurator) JoinNode(ctx context.Context, req *ipb.JoinNodeRequest)
 		return nil, status.Errorf(codes.FailedPrecondition, "node isn't UP, cannot join")
 	}
 
+	// The measurements reported by the node during its previous boot do not
+	// apply anymore, and are replaced once the node reports its measurements
+	// again.
+	node.measuredBoot = nil
+
+	// Verify the node's TPM, if it attested, and record the outcome, even if
+	// the node is then rejected. The EK of nodes which registered before
+	// attestation was available is not recorded here, as the node might have
+	// been compromised since, but only via Management.SetNodeEndorsementKey.
+	node.attestation = l.verifyAttestation(ctx, "JoinNode", jkey, req.Attestation, node.ekPub, cl.TPMAttestation)
+	if err := nodeSave(ctx, l.leadership, node); err != nil {
+		return nil, err
+	}
+	if err := enforceAttestation(cl, node.attestation); err != nil {
+		return nil, err
+	}
+
 	// Return the Node's CUK, completing the Join Flow.
 	return &ipb.JoinNodeResponse{
 		ClusterUnlockKey: node.clusterUnlockKey,
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"crypto/x509"
 	"errors"
 	"sort"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 
 	common "source.monogon.dev/metropolis/node"
@@ -140,7 +142,7 @@ func (l *leaderManagement) GetClusterInfo(ctx context.Context, req *apb.GetClust
 // nodeHeartbeatTimestamp returns the node nid's last heartbeat timestamp, as
 // seen from the Curator leader's perspective. If no heartbeats were received
 // from the node, a zero time.Time value is returned.
-func (l *leaderManagement) nodeHeartbeatTimestamp(nid string) time.Time {
+func (l *leadership) nodeHeartbeatTimestamp(nid string) time.Time {
 	smv, ok := l.ls.heartbeatTimestamps.Load(nid)
 	if ok {
 		return smv.(time.Time)
@@ -150,7 +152,7 @@ func (l *leaderManagement) nodeHeartbeatTimestamp(nid string) time.Time {
 
 // nodeHealth returns the node's health, along with the duration since last
 // heartbeat was received, given a current timestamp.
-func (l *leaderManagement) nodeHealth(node *Node, now time.Time) (apb.Node_Health, time.Duration) {
+func (l *leadership) nodeHealth(node *Node, now time.Time) (apb.Node_Health, time.Duration) {
 	// Get the last received node heartbeat's timestamp.
 	nid := node.ID()
 	nts := l.nodeHeartbeatTimestamp(nid)
@@ -181,6 +183,52 @@ func (l *leaderManagement) nodeHealth(node *Node, now time.Time) (apb.Node_Healt
 	return nh, lhb
 }
 
+// apiNode converts a Node into its public API representation as returned by
+// Management.GetNodes, assessing its health against the given timestamp.
+func (l *leadership) apiNode(node *Node, now time.Time) *apb.Node {
+	// Convert node roles.
+	roles := &cpb.NodeRoles{}
+	if node.kubernetesController != nil {
+		roles.KubernetesController = &cpb.NodeRoles_KubernetesController{}
+	}
+	if node.kubernetesWorker != nil {
+		roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{}
+	}
+	if node.consensusMember != nil {
+		roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
+	}
+
+	// Assess the node's health.
+	health, lhb := l.nodeHealth(node, now)
+
+	entry := &apb.Node{
+		Pubkey:             node.pubkey,
+		Id:                 node.ID(),
+		State:              node.state,
+		Status:             node.status,
+		Roles:              roles,
+		TimeSinceHeartbeat: dpb.New(lhb),
+		Health:             health,
+		TpmUsage:           node.tpmUsage,
+		Labels:             &cpb.NodeLabels{},
+		Attestation:        node.attestation,
+		MeasuredBoot:       node.measuredBoot,
+		AttestationStatus:  nodeAttestationStatus(node),
+		TimeConfiguration:  node.timeConfiguration,
+		EkPublicKey:        node.ekPub,
+	}
+	for k, v := range node.labels {
+		entry.Labels.Pairs = append(entry.Labels.Pairs, &cpb.NodeLabels_Pair{
+			Key:   k,
+			Value: v,
+		})
+	}
+	sort.Slice(entry.Labels.Pairs, func(i, j int) bool {
+		return entry.Labels.Pairs[i].Key < entry.Labels.Pairs[j].Key
+	})
+	return entry
+}
+
 // GetNodes implements Management.GetNodes, which returns a list of nodes from
 // the point of view of the cluster.
 func (l *leaderManagement) GetNodes(req *apb.GetNodesRequest, srv apb.Management_GetNodesServer) error {
@@ -214,53 +262,18 @@ func (l *leaderManagement) GetNodes(req *apb.GetNodesRequest, srv apb.Management
 			rpc.Trace(ctx).Printf("Unmarshalling node %q failed: %v", kv.Value, err)
 			continue
 		}
-
-		// Convert node roles.
-		roles := &cpb.NodeRoles{}
-		if node.kubernetesController != nil {
-			roles.KubernetesController = &cpb.NodeRoles_KubernetesController{}
-		}
-		if node.kubernetesWorker != nil {
-			roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{}
-		}
-		if node.consensusMember != nil {
-			roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
-		}
-
-		// Assess the node's health.
-		health, lhb := l.nodeHealth(node, now)
-
-		entry := apb.Node{
-			Pubkey:             node.pubkey,
-			Id:                 node.ID(),
-			State:              node.state,
-			Status:             node.status,
-			Roles:              roles,
-			TimeSinceHeartbeat: dpb.New(lhb),
-			Health:             health,
-			TpmUsage:           node.tpmUsage,
-			Labels:             &cpb.NodeLabels{},
-		}
-		for k, v := range node.labels {
-			entry.Labels.Pairs = append(entry.Labels.Pairs, &cpb.NodeLabels_Pair{
-				Key:   k,
-				Value: v,
-			})
-		}
-		sort.Slice(entry.Labels.Pairs, func(i, j int) bool {
-			return entry.Labels.Pairs[i].Key < entry.Labels.Pairs[j].Key
-		})
+		entry := l.apiNode(node, now)
 
 		// Evaluate the filter expression for this node. Send the node, if it's
 		// kept by the filter.
-		keep, err := filter(ctx, &entry)
+		keep, err := filter(ctx, entry)
 		if err != nil {
 			return err
 		}
 		if !keep {
 			continue
 		}
-		if err := srv.Send(&entry); err != nil {
+		if err := srv.Send(entry); err != nil {
 			return err
 		}
 	}
@@ -558,6 +571,105 @@ func (l *leaderManagement) UpdateNodeLabels(ctx context.Context, req *apb.Update
 	return &apb.UpdateNodeLabelsResponse{}, nil
 }
 
+// UpdateNodeTimeConfiguration implements
+// Management.UpdateNodeTimeConfiguration, which sets or clears the time
+// configuration override of a node.
+func (l *leaderManagement) UpdateNodeTimeConfiguration(ctx context.Context, req *apb.UpdateNodeTimeConfigurationRequest) (*apb.UpdateNodeTimeConfigurationResponse, error) {
+	// Get node ID from request.
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.UpdateNodeTimeConfigurationRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		// Convert the pubkey into node ID.
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.UpdateNodeTimeConfigurationRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	tc := req.TimeConfiguration
+	for i, server := range tc.GetNtpServers() {
+		if err := common.ValidateNTPServer(server); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ntp_servers[%d] %q: %v", i, server, err)
+		}
+	}
+	// An override without NTP servers is equivalent to no override.
+	if len(tc.GetNtpServers()) == 0 {
+		tc = nil
+	}
+
+	// Take l.muNodes before modifying the node.
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "while loading node %s: %v", id, err)
+	}
+	node.timeConfiguration = tc
+	if err := nodeSave(ctx, l.leadership, node); err != nil {
+		return nil, err
+	}
+
+	return &apb.UpdateNodeTimeConfigurationResponse{}, nil
+}
+
+// SetNodeEndorsementKey implements Management.SetNodeEndorsementKey, which
+// records the EK of a node which registered without one.
+func (l *leaderManagement) SetNodeEndorsementKey(ctx context.Context, req *apb.SetNodeEndorsementKeyRequest) (*apb.SetNodeEndorsementKeyResponse, error) {
+	// Get node ID from request.
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.SetNodeEndorsementKeyRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		// Convert the pubkey into node ID.
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.SetNodeEndorsementKeyRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	if len(req.EkPublicKey) == 0 || len(req.EkPublicKey) > maxAttestationKeySize {
+		return nil, status.Errorf(codes.InvalidArgument, "ek_public_key must be set and at most %d bytes long", maxAttestationKeySize)
+	}
+	if _, err := x509.ParsePKIXPublicKey(req.EkPublicKey); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ek_public_key: %v", err)
+	}
+
+	// Take l.muNodes before modifying the node.
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "while loading node %s: %v", id, err)
+	}
+	if node.tpmUsage != cpb.NodeTPMUsage_NODE_TPM_USAGE_PRESENT_AND_USED {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s does not use a TPM", id)
+	}
+	if node.ekPub != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s already has an endorsement key", id)
+	}
+	node.ekPub = req.EkPublicKey
+	if err := nodeSave(ctx, l.leadership, node); err != nil {
+		return nil, err
+	}
+
+	return &apb.SetNodeEndorsementKeyResponse{}, nil
+}
+
 func (l *leaderManagement) ConfigureCluster(ctx context.Context, req *apb.ConfigureClusterRequest) (*apb.ConfigureClusterResponse, error) {
 	l.muCluster.Lock()
 	defer l.muCluster.Unlock()
@@ -602,6 +714,14 @@ func (l *leaderManagement) ConfigureCluster(ctx context.Context, req *apb.Config
 		return nil, status.Errorf(codes.Internal, "failed to save cluster config: %v", err)
 	}
 
+	// Check all nodes against a changed measured boot policy, so that their
+	// measured boot status reflects it without waiting for them to reboot.
+	if !proto.Equal(existing.MeasuredBootPolicy, merged.MeasuredBootPolicy) {
+		if err := l.recheckMeasuredBoot(ctx, merged.MeasuredBootPolicy); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to check nodes against new measured boot policy: %v", err)
+		}
+	}
+
 	return &apb.ConfigureClusterResponse{
 		ResultingConfig: merged,
 	}, nil
@@ -0,0 +1,247 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/tpm/eventlog"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// maxEventLogSize is the maximum size of the event log accepted by
+// ReportMeasurements. Event logs of real machines are usually well below
+// 100KiB.
+const maxEventLogSize = 1024 * 1024
+
+func (l *leaderCurator) ReportMeasurements(srv ipb.Curator_ReportMeasurementsServer) error {
+	// Ensure that the caller is a node. Nodes can only report their own
+	// measurements.
+	ctx := srv.Context()
+	pi := rpc.GetPeerInfo(ctx)
+	if pi == nil || pi.Node == nil {
+		return status.Error(codes.PermissionDenied, "only nodes can report measurements")
+	}
+	id := pi.Node.ID
+
+	req, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	creq := req.GetChallenge()
+	if creq == nil {
+		return status.Error(codes.InvalidArgument, "first message must be a challenge request")
+	}
+
+	// The measurements are only as trustworthy as the TPM which quotes them,
+	// so the node must already be bound to its TPM.
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if err != nil {
+		return err
+	}
+	if node.ekPub == nil {
+		return status.Error(codes.FailedPrecondition, "no endorsement key recorded for node")
+	}
+
+	// The challenge is local to this call, and thus doesn't need to be stored
+	// in the leader state.
+	c, cres, err := newAttestationChallenge(node.pubkey, creq)
+	if err != nil {
+		return err
+	}
+	err = srv.Send(&ipb.ReportMeasurementsResponse{
+		Kind: &ipb.ReportMeasurementsResponse_Challenge{Challenge: cres},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err = srv.Recv()
+	if err != nil {
+		return err
+	}
+	report := req.GetReport()
+	if report == nil || report.Attestation == nil {
+		return status.Error(codes.InvalidArgument, "second message must be a report with an attestation")
+	}
+	if len(report.EventLog) > maxEventLogSize {
+		return status.Errorf(codes.InvalidArgument, "event_log must be at most %d bytes long", maxEventLogSize)
+	}
+	if time.Now().After(c.expires) {
+		return status.Error(codes.DeadlineExceeded, "challenge expired")
+	}
+
+	cl, err := clusterLoad(ctx, l.leadership)
+	if err != nil {
+		return err
+	}
+	res := verifyMeasurements(ctx, c, report, node.ekPub, cl.MeasuredBootPolicy)
+
+	// Reload the node under lock, as it might have been modified while the
+	// node was busy responding to the challenge. The policy is checked again,
+	// as it might have been changed (and all nodes checked against it) in the
+	// meantime.
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+	node, err = nodeLoad(ctx, l.leadership, id)
+	if err != nil {
+		return err
+	}
+	cl, err = clusterLoad(ctx, l.leadership)
+	if err != nil {
+		return err
+	}
+	res = checkMeasuredBootPolicy(ctx, res, cl.MeasuredBootPolicy)
+	node.measuredBoot = res
+	if err := nodeSave(ctx, l.leadership, node); err != nil {
+		return err
+	}
+
+	return srv.Send(&ipb.ReportMeasurementsResponse{
+		Kind: &ipb.ReportMeasurementsResponse_Result{Result: res},
+	})
+}
+
+// verifyMeasurements verifies the measured boot event log in report against
+// the PCR values attested in response to the challenge c by the TPM with the
+// EK ekPub, and checks the measurements against the given policy.
+func verifyMeasurements(ctx context.Context, c *attestationChallenge, report *ipb.ReportMeasurementsRequest_Report, ekPub []byte, policy *cpb.ClusterConfiguration_MeasuredBootPolicy) *cpb.NodeMeasuredBoot {
+	res := &cpb.NodeMeasuredBoot{
+		Timestamp: tpb.Now(),
+	}
+
+	a := checkAttestation(ctx, &cpb.NodeAttestation{}, c, report.Attestation, ekPub, nil)
+	if a.Result != cpb.NodeAttestation_RESULT_PASSED {
+		return failMeasuredBoot(ctx, res, "attestation failed: %s", a.Reason)
+	}
+	res.Pcrs = a.Pcrs
+
+	if len(report.EventLog) == 0 {
+		res.Result = cpb.NodeAttestation_RESULT_MISSING
+		return res
+	}
+	el, err := eventlog.ParseEventLog(report.EventLog)
+	if err != nil {
+		return failMeasuredBoot(ctx, res, "invalid event log: %v", err)
+	}
+	events, err := el.Verify(eventlog.ConvertRawPCRs(a.Pcrs))
+	if err != nil {
+		return failMeasuredBoot(ctx, res, "event log does not match quoted PCRs: %v", err)
+	}
+	res.BootApplications = eventlog.BootApplications(events)
+	sb, err := eventlog.ParseSecurebootState(events)
+	if err != nil {
+		rpc.Trace(ctx).Printf("Could not determine secure boot state: %v", err)
+	} else {
+		res.SecureBoot = sb.Enabled
+	}
+	res.EventLogVerified = true
+
+	return checkMeasuredBootPolicy(ctx, res, policy)
+}
+
+// checkMeasuredBootPolicy checks the measurements of a verified event log in
+// res against the given policy, and returns res with the new outcome. res is
+// returned unchanged if its event log was not verified.
+func checkMeasuredBootPolicy(ctx context.Context, res *cpb.NodeMeasuredBoot, policy *cpb.ClusterConfiguration_MeasuredBootPolicy) *cpb.NodeMeasuredBoot {
+	if !res.EventLogVerified {
+		return res
+	}
+	res = proto.Clone(res).(*cpb.NodeMeasuredBoot)
+	res.Reason = ""
+
+	if policy.GetRequireSecureBoot() && !res.SecureBoot {
+		return failMeasuredBoot(ctx, res, "secure boot is not enabled")
+	}
+	if allowed := policy.GetAllowedBootApplications(); len(allowed) > 0 {
+		if len(res.BootApplications) == 0 {
+			return failMeasuredBoot(ctx, res, "no boot applications measured")
+		}
+		for _, app := range res.BootApplications {
+			if !containsDigest(allowed, app) {
+				return failMeasuredBoot(ctx, res, "boot application %x not allowed by cluster configuration", app)
+			}
+		}
+	}
+
+	res.Result = cpb.NodeAttestation_RESULT_PASSED
+	return res
+}
+
+// recheckMeasuredBoot checks the verified measurements of all nodes against
+// the given policy, updating their measured boot status.
+func (l *leadership) recheckMeasuredBoot(ctx context.Context, policy *cpb.ClusterConfiguration_MeasuredBootPolicy) error {
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+	if err != nil {
+		return fmt.Errorf("could not get nodes: %w", err)
+	}
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		node, err := nodeUnmarshal(kv)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal node %q: %w", kv.Key, err)
+		}
+		if !node.measuredBoot.GetEventLogVerified() {
+			continue
+		}
+		mb := checkMeasuredBootPolicy(ctx, node.measuredBoot, policy)
+		if proto.Equal(mb, node.measuredBoot) {
+			continue
+		}
+		node.measuredBoot = mb
+		if err := nodeSave(ctx, l, node); err != nil {
+			return fmt.Errorf("could not save node %s: %w", node.ID(), err)
+		}
+	}
+	return nil
+}
+
+// failMeasuredBoot marks res as failed for the given reason and returns it.
+func failMeasuredBoot(ctx context.Context, res *cpb.NodeMeasuredBoot, format string, args ...any) *cpb.NodeMeasuredBoot {
+	res.Result = cpb.NodeAttestation_RESULT_FAILED
+	res.Reason = fmt.Sprintf(format, args...)
+	rpc.Trace(ctx).Printf("Measured boot verification failed: %s", res.Reason)
+	return res
+}
+
+// containsDigest returns whether digest is one of digests.
+func containsDigest(digests [][]byte, digest []byte) bool {
+	for _, d := range digests {
+		if bytes.Equal(d, digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeAttestationStatus summarizes the outcomes of attestation and measured
+// boot verification of a node, as exposed in the management API.
+func nodeAttestationStatus(node *Node) apb.Node_AttestationStatus {
+	attestation := node.attestation.GetResult()
+	measuredBoot := node.measuredBoot.GetResult()
+	switch {
+	case attestation == cpb.NodeAttestation_RESULT_FAILED, measuredBoot == cpb.NodeAttestation_RESULT_FAILED:
+		return apb.Node_ATTESTATION_STATUS_FAILED
+	case measuredBoot == cpb.NodeAttestation_RESULT_PASSED:
+		return apb.Node_ATTESTATION_STATUS_VERIFIED
+	case attestation == cpb.NodeAttestation_RESULT_PASSED:
+		return apb.Node_ATTESTATION_STATUS_ATTESTED
+	default:
+		return apb.Node_ATTESTATION_STATUS_UNATTESTED
+	}
+}
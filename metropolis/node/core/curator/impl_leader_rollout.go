@@ -0,0 +1,461 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+const (
+	// rolloutDefaultHealthTimeout is the health timeout used for rollouts which
+	// don't specify one.
+	rolloutDefaultHealthTimeout = 15 * time.Minute
+)
+
+// StartRollout implements Management.StartRollout.
+func (l *leaderManagement) StartRollout(ctx context.Context, req *apb.StartRolloutRequest) (*apb.StartRolloutResponse, error) {
+	img := req.OsImage
+	if img == nil {
+		return nil, status.Error(codes.InvalidArgument, "os_image must be set")
+	}
+	if img.Scheme != "http" && img.Scheme != "https" {
+		return nil, status.Error(codes.InvalidArgument, "os_image.scheme must be http or https")
+	}
+	if img.Host == "" || img.Repository == "" {
+		return nil, status.Error(codes.InvalidArgument, "os_image.host and os_image.repository must be set")
+	}
+	if img.Digest == "" {
+		return nil, status.Error(codes.InvalidArgument, "os_image.digest must be set")
+	}
+
+	policy := &apb.RolloutPolicy{}
+	if req.Policy != nil {
+		policy = proto.Clone(req.Policy).(*apb.RolloutPolicy)
+	}
+	switch policy.ActivationMode {
+	case apb.ActivationMode_ACTIVATION_MODE_REBOOT, apb.ActivationMode_ACTIVATION_MODE_KEXEC:
+	default:
+		return nil, status.Error(codes.InvalidArgument, "policy.activation_mode must be REBOOT or KEXEC")
+	}
+	if policy.MaxUnavailable == 0 {
+		policy.MaxUnavailable = 1
+	}
+	if policy.HealthTimeout == nil {
+		policy.HealthTimeout = dpb.New(rolloutDefaultHealthTimeout)
+	} else if err := policy.HealthTimeout.CheckValid(); err != nil || policy.HealthTimeout.AsDuration() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "policy.health_timeout must be positive")
+	}
+	if gt := policy.HealthGateTimeout; gt != nil {
+		if err := gt.CheckValid(); err != nil || gt.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "policy.health_gate_timeout must be positive")
+		}
+	}
+
+	filter, err := buildNodeFilter(ctx, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	l.muRollout.Lock()
+	defer l.muRollout.Unlock()
+
+	prev, err := rolloutLoad(ctx, l.leadership)
+	switch {
+	case errors.Is(err, errRolloutNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		if prev.State == apb.Rollout_STATE_RUNNING {
+			return nil, status.Errorf(codes.FailedPrecondition, "rollout %s is still running", prev.Id)
+		}
+		for _, rn := range prev.Nodes {
+			if rn.State == apb.Rollout_Node_STATE_UPDATING {
+				return nil, status.Errorf(codes.FailedPrecondition, "node %s is still being updated by rollout %s", rn.Id, prev.Id)
+			}
+		}
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not generate rollout ID: %v", err)
+	}
+	ro := &apb.Rollout{
+		Id:      hex.EncodeToString(idBytes),
+		OsImage: img,
+		Policy:  policy,
+		State:   apb.Rollout_STATE_RUNNING,
+		Created: tpb.Now(),
+	}
+
+	l.muNodes.Lock()
+	res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+	if err != nil {
+		l.muNodes.Unlock()
+		return nil, status.Errorf(codes.Unavailable, "could not retrieve list of nodes: %v", err)
+	}
+	now := time.Now()
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		node, err := nodeUnmarshal(kv)
+		if err != nil {
+			rpc.Trace(ctx).Printf("Unmarshalling node %q failed: %v", kv.Value, err)
+			continue
+		}
+		if node.state != cpb.NodeState_NODE_STATE_UP {
+			continue
+		}
+		keep, err := filter(ctx, l.apiNode(node, now))
+		if err != nil {
+			l.muNodes.Unlock()
+			return nil, err
+		}
+		if !keep {
+			continue
+		}
+		ro.Nodes = append(ro.Nodes, &apb.Rollout_Node{
+			Id:    node.ID(),
+			State: apb.Rollout_Node_STATE_PENDING,
+		})
+	}
+	l.muNodes.Unlock()
+
+	if len(ro.Nodes) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "no nodes match the given filter")
+	}
+	if err := rolloutSave(ctx, l.leadership, ro); err != nil {
+		return nil, err
+	}
+	return &apb.StartRolloutResponse{
+		Rollout: ro,
+	}, nil
+}
+
+// GetRollout implements Management.GetRollout.
+func (l *leaderManagement) GetRollout(ctx context.Context, req *apb.GetRolloutRequest) (*apb.GetRolloutResponse, error) {
+	l.muRollout.Lock()
+	defer l.muRollout.Unlock()
+
+	ro, err := rolloutLoad(ctx, l.leadership)
+	switch {
+	case errors.Is(err, errRolloutNotFound):
+		return &apb.GetRolloutResponse{}, nil
+	case err != nil:
+		return nil, err
+	}
+	return &apb.GetRolloutResponse{
+		Rollout: ro,
+	}, nil
+}
+
+// PauseRollout implements Management.PauseRollout.
+func (l *leaderManagement) PauseRollout(ctx context.Context, req *apb.PauseRolloutRequest) (*apb.PauseRolloutResponse, error) {
+	l.muRollout.Lock()
+	defer l.muRollout.Unlock()
+
+	ro, err := rolloutLoad(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+	target := apb.Rollout_STATE_RUNNING
+	if req.Paused {
+		target = apb.Rollout_STATE_PAUSED
+	}
+	switch ro.State {
+	case target:
+		// No-op for idempotency.
+		return &apb.PauseRolloutResponse{Rollout: ro}, nil
+	case apb.Rollout_STATE_RUNNING, apb.Rollout_STATE_PAUSED:
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "rollout in state %s cannot be paused or resumed", ro.State)
+	}
+	ro.State = target
+	if err := rolloutSave(ctx, l.leadership, ro); err != nil {
+		return nil, err
+	}
+	return &apb.PauseRolloutResponse{
+		Rollout: ro,
+	}, nil
+}
+
+// backgroundRollout progresses the current rollout, if any, by instructing
+// nodes to update and by observing them come back after updating.
+func (l *leaderBackground) backgroundRollout(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	for {
+		// Process every 5 seconds.
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := l.doRollout(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *leaderBackground) doRollout(ctx context.Context) error {
+	l.muRollout.Lock()
+	defer l.muRollout.Unlock()
+
+	ro, err := rolloutLoad(ctx, l.leadership)
+	if errors.Is(err, errRolloutNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not load rollout: %w", err)
+	}
+	if ro.State != apb.Rollout_STATE_RUNNING && ro.State != apb.Rollout_STATE_PAUSED {
+		return nil
+	}
+
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+	if err != nil {
+		return fmt.Errorf("could not get nodes: %w", err)
+	}
+	nodes := make(map[string]*Node)
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		node, err := nodeUnmarshal(kv)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal node %q: %w", kv.Key, err)
+		}
+		nodes[node.ID()] = node
+	}
+
+	now := time.Now()
+	timeout := ro.Policy.HealthTimeout.AsDuration()
+	changed := false
+
+	// setUpdate sets or clears the pending update on a node and saves it.
+	setUpdate := func(node *Node, update *ipb.NodeUpdate) error {
+		node.update = update
+		if err := nodeSave(ctx, l.leadership, node); err != nil {
+			return fmt.Errorf("could not save node %s: %w", node.ID(), err)
+		}
+		return nil
+	}
+
+	// First, check on nodes which are currently being updated.
+	inFlight := 0
+	consensusInFlight := 0
+	for _, rn := range ro.Nodes {
+		if rn.State != apb.Rollout_Node_STATE_UPDATING {
+			continue
+		}
+		node, ok := nodes[rn.Id]
+		if !ok {
+			rn.State = apb.Rollout_Node_STATE_SKIPPED
+			rn.Message = "node was removed from the cluster"
+			changed = true
+			continue
+		}
+		if rb := node.status.GetLastRollback(); rb != nil && node.updateStatus.GetRolledBackUpdateId() == ro.Id {
+			supervisor.Logger(ctx).Warningf("Rollout %s: node %s rolled back the update, stopping rollout", ro.Id, rn.Id)
+			rn.State = apb.Rollout_Node_STATE_FAILED
+			rn.Message = fmt.Sprintf("node rolled back the update: %s", rb.Reason)
+			ro.State = apb.Rollout_STATE_FAILED
+			changed = true
+			if err := setUpdate(node, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		health, _ := l.nodeHealth(node, now)
+		bootID := node.status.GetBootId()
+		if len(bootID) != 0 && !bytes.Equal(bootID, rn.InitialBootId) && health == apb.Node_HEALTH_HEALTHY {
+			// The node might have rebooted for another reason, eg. a crash, before
+			// activating the update.
+			if digest := node.status.GetOsImageDigest(); digest != ro.OsImage.Digest {
+				msg := "node rebooted, but is not running the rolled out OS image"
+				if rn.Message != msg {
+					rn.Message = msg
+					changed = true
+				}
+			} else {
+				supervisor.Logger(ctx).Infof("Rollout %s: node %s updated successfully", ro.Id, rn.Id)
+				rn.State = apb.Rollout_Node_STATE_DONE
+				rn.Message = ""
+				changed = true
+				if err := setUpdate(node, nil); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if uf := node.status.GetUpdateFailure(); uf != nil && node.updateStatus.GetFailedUpdateId() == ro.Id {
+			// The node keeps retrying, so only surface the failure for now.
+			msg := fmt.Sprintf("node failed to install the update: %s", uf.Reason)
+			if rn.Message != msg {
+				rn.Message = msg
+				changed = true
+			}
+		}
+		if now.Sub(rn.Started.AsTime()) > timeout {
+			supervisor.Logger(ctx).Warningf("Rollout %s: node %s did not become healthy within %s, stopping rollout", ro.Id, rn.Id, timeout)
+			rn.State = apb.Rollout_Node_STATE_FAILED
+			if rn.Message == "" {
+				rn.Message = fmt.Sprintf("node did not come back healthy within %s", timeout)
+			}
+			ro.State = apb.Rollout_STATE_FAILED
+			changed = true
+			if err := setUpdate(node, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		inFlight += 1
+		if node.consensusMember != nil {
+			consensusInFlight += 1
+		}
+	}
+
+	// If the rollout failed, stop updating all other nodes which are still
+	// in flight, so that their pending update gets cleared and a new rollout
+	// can be started.
+	if ro.State == apb.Rollout_STATE_FAILED {
+		for _, rn := range ro.Nodes {
+			if rn.State != apb.Rollout_Node_STATE_UPDATING {
+				continue
+			}
+			rn.State = apb.Rollout_Node_STATE_FAILED
+			rn.Message = "rollout failed while the node was being updated"
+			if node, ok := nodes[rn.Id]; ok {
+				if err := setUpdate(node, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Then, start updating further nodes if the rollout is running and the
+	// policy permits it.
+	if ro.State == apb.Rollout_STATE_RUNNING {
+		for _, rn := range ro.Nodes {
+			if inFlight >= int(ro.Policy.MaxUnavailable) {
+				break
+			}
+			if rn.State != apb.Rollout_Node_STATE_PENDING {
+				continue
+			}
+			node, ok := nodes[rn.Id]
+			if !ok || node.state != cpb.NodeState_NODE_STATE_UP {
+				rn.State = apb.Rollout_Node_STATE_SKIPPED
+				rn.Message = "node is not part of the cluster anymore"
+				changed = true
+				continue
+			}
+			health, _ := l.nodeHealth(node, now)
+			switch health {
+			case apb.Node_HEALTH_HEALTHY:
+			case apb.Node_HEALTH_UNKNOWN:
+				// Leadership just started, wait for heartbeats.
+				continue
+			default:
+				rn.State = apb.Rollout_Node_STATE_SKIPPED
+				rn.Message = fmt.Sprintf("node was not healthy (%s)", health)
+				changed = true
+				continue
+			}
+			if node.consensusMember != nil && !l.rolloutCanTakeDownConsensusMember(node, nodes, consensusInFlight, now) {
+				continue
+			}
+
+			supervisor.Logger(ctx).Infof("Rollout %s: instructing node %s to update", ro.Id, rn.Id)
+			rn.State = apb.Rollout_Node_STATE_UPDATING
+			rn.Started = tpb.New(now)
+			rn.InitialBootId = node.status.GetBootId()
+			changed = true
+			err := setUpdate(node, &ipb.NodeUpdate{
+				Id:                ro.Id,
+				OsImage:           ro.OsImage,
+				ActivationMode:    ro.Policy.ActivationMode,
+				HealthGateTimeout: ro.Policy.HealthGateTimeout,
+			})
+			if err != nil {
+				return err
+			}
+			inFlight += 1
+			if node.consensusMember != nil {
+				consensusInFlight += 1
+			}
+		}
+	}
+
+	// Finally, check whether the rollout is done.
+	if ro.State == apb.Rollout_STATE_RUNNING {
+		done := true
+		for _, rn := range ro.Nodes {
+			if rn.State != apb.Rollout_Node_STATE_DONE && rn.State != apb.Rollout_Node_STATE_SKIPPED {
+				done = false
+				break
+			}
+		}
+		if done {
+			supervisor.Logger(ctx).Infof("Rollout %s: done", ro.Id)
+			ro.State = apb.Rollout_STATE_DONE
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := rolloutSave(ctx, l.leadership, ro); err != nil {
+		return fmt.Errorf("could not save rollout: %w", err)
+	}
+	return nil
+}
+
+// rolloutCanTakeDownConsensusMember returns whether the given consensus member
+// node can be updated without risking loss of etcd quorum. Only one consensus
+// member is ever updated at a time, and only if enough of the other consensus
+// members are healthy to maintain quorum without it.
+func (l *leadership) rolloutCanTakeDownConsensusMember(node *Node, nodes map[string]*Node, consensusInFlight int, now time.Time) bool {
+	if consensusInFlight > 0 {
+		return false
+	}
+	total := 0
+	healthyOthers := 0
+	for _, n := range nodes {
+		if n.consensusMember == nil {
+			continue
+		}
+		total += 1
+		if n.ID() == node.ID() {
+			continue
+		}
+		if health, _ := l.nodeHealth(n, now); health == apb.Node_HEALTH_HEALTHY {
+			healthyOthers += 1
+		}
+	}
+	if total <= 2 {
+		// Clusters with less than three consensus members cannot tolerate the
+		// loss of any member. The resulting downtime is unavoidable and expected
+		// by the operator, so only make sure the other member (if any) is
+		// healthy.
+		return healthyOthers == total-1
+	}
+	return healthyOthers >= total/2+1
+}
@@ -0,0 +1,60 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+const (
+	// snapshotChunkSize is the maximum amount of snapshot data sent in a
+	// single TakeSnapshotResponse.
+	snapshotChunkSize = 1 << 20
+)
+
+// TakeSnapshot implements Management.TakeSnapshot, which streams a snapshot of
+// the consensus database. The snapshot is taken from the local etcd member,
+// which is always a consensus member as the curator leader runs colocated
+// with it.
+func (l *leaderManagement) TakeSnapshot(req *apb.TakeSnapshotRequest, srv apb.Management_TakeSnapshotServer) error {
+	ctx := srv.Context()
+
+	if l.consensusStatus == nil {
+		return status.Error(codes.Unavailable, "consensus not available")
+	}
+	r, err := l.consensusStatus.Snapshot(ctx)
+	if err != nil {
+		rpc.Trace(ctx).Printf("Snapshot failed: %v", err)
+		return status.Error(codes.Unavailable, "could not take snapshot")
+	}
+	defer r.Close()
+
+	buf := make([]byte, snapshotChunkSize)
+	total := 0
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := srv.Send(&apb.TakeSnapshotResponse{Data: buf[:n]}); err != nil {
+				return err
+			}
+			total += n
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			rpc.Trace(ctx).Printf("Reading snapshot failed after %d bytes: %v", total, err)
+			return status.Error(codes.Unavailable, "could not read snapshot")
+		}
+	}
+	rpc.Trace(ctx).Printf("Sent snapshot of %d bytes", total)
+	return nil
+}
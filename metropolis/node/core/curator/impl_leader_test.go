@@ -6,14 +6,19 @@ package curator
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"maps"
+	"math/big"
 	"net"
 	"strings"
 	"testing"
@@ -24,9 +29,12 @@ import (
 	"go.etcd.io/etcd/tests/v3/integration"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"k8s.io/utils/ptr"
 
@@ -35,10 +43,12 @@ import (
 	"source.monogon.dev/metropolis/node/core/consensus/client"
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 	ppb "source.monogon.dev/metropolis/node/core/curator/proto/private"
+	"source.monogon.dev/metropolis/node/core/curator/watcher"
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	apb "source.monogon.dev/metropolis/proto/api"
 	cpb "source.monogon.dev/metropolis/proto/common"
+	epb "source.monogon.dev/metropolis/proto/ext"
 	"source.monogon.dev/osbase/logtree"
 	"source.monogon.dev/osbase/pki"
 	"source.monogon.dev/osbase/supervisor"
@@ -215,7 +225,9 @@ func fakeLeader(t *testing.T, opts ...*fakeLeaderOption) fakeLeaderData {
 
 	// Create a curator gRPC server which performs authentication as per the created
 	// ServerSecurity and is backed by the created leader.
-	srv := grpc.NewServer(sec.GRPCOptions(lt.MustLeveledFor("leader"))...)
+	grpcOpts := sec.GRPCOptions(lt.MustLeveledFor("leader"))
+	grpcOpts = append(grpcOpts, rpc.AuditOptions(lt.MustLeveledFor("leader"), leadership.auditRecord)...)
+	srv := grpc.NewServer(grpcOpts...)
 	ipb.RegisterCuratorServer(srv, leader)
 	ipb.RegisterCuratorLocalServer(srv, leader)
 	apb.RegisterAAAServer(srv, leader)
@@ -844,6 +856,9 @@ func TestJoin(t *testing.T) {
 		jkey:             jpub,
 		state:            cpb.NodeState_NODE_STATE_UP,
 		tpmUsage:         cpb.NodeTPMUsage_NODE_TPM_USAGE_PRESENT_AND_USED,
+		measuredBoot: &cpb.NodeMeasuredBoot{
+			Result: cpb.NodeAttestation_RESULT_PASSED,
+		},
 	}
 	if err := nodeSave(ctx, cl.l, &node); err != nil {
 		t.Fatalf("nodeSave failed: %v", err)
@@ -874,6 +889,15 @@ func TestJoin(t *testing.T) {
 	if !bytes.Equal(cuk, jr.ClusterUnlockKey) {
 		t.Fatal("JoinNode returned an invalid CUK.")
 	}
+
+	// The measurements of the node's previous boot must have been discarded.
+	joined, err := nodeLoad(ctx, cl.l, node.id)
+	if err != nil {
+		t.Fatalf("nodeLoad failed: %v", err)
+	}
+	if joined.measuredBoot != nil {
+		t.Errorf("Measured boot status after JoinNode is %v, wanted none", joined.measuredBoot)
+	}
 }
 
 // TestClusterUpdateNodeStatus exercises the Curator.UpdateNodeStatus RPC by
@@ -1421,6 +1445,44 @@ func TestGetCurrentLeader(t *testing.T) {
 	}
 }
 
+// TestIssueNodeCertificate exercises whether nodes can retrieve their own node
+// certificate from the curator, as used for renewal.
+func TestIssueNodeCertificate(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	curator := ipb.NewCuratorClient(cl.localNodeConn)
+	res, err := curator.IssueCertificate(ctx, &ipb.IssueCertificateRequest{
+		Kind: &ipb.IssueCertificateRequest_Node_{
+			Node: &ipb.IssueCertificateRequest_Node{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("IssueCertificate: %v", err)
+	}
+	n := res.GetNode()
+	if n == nil {
+		t.Fatalf("No node certificate in response")
+	}
+	if !bytes.Equal(n.CaCertificate, cl.ca.Raw) {
+		t.Errorf("Wrong CA certificate returned")
+	}
+	cert, err := x509.ParseCertificate(n.NodeCertificate)
+	if err != nil {
+		t.Fatalf("Could not parse node certificate: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(cl.ca); err != nil {
+		t.Errorf("Node certificate not signed by CA: %v", err)
+	}
+	if id, err := identity.VerifyNodeInCluster(cert, cl.ca); err != nil || id != cl.localNodeID {
+		t.Errorf("Node certificate is for %q (%v), wanted %q", id, err, cl.localNodeID)
+	}
+	if want, got := cert.NotBefore.Add(identity.NodeCertificateLifetime), cert.NotAfter; !want.Equal(got) {
+		t.Errorf("Node certificate expires at %s, wanted %s", got, want)
+	}
+}
+
 // TestIssueKubernetesWorkerCertificate exercises whether we can retrieve
 // Kubernetes Worker certificates from the curator.
 func TestIssueKubernetesWorkerCertificate(t *testing.T) {
@@ -1826,6 +1888,544 @@ func TestClusterTPMModeSetting(t *testing.T) {
 	}
 }
 
+// TestTPMAttestation exercises the enforcement of the cluster TPM attestation
+// policy in RegisterNode and the verification of attestations against pending
+// challenges. Attestations passing verification require a real TPM and are
+// exercised by the HA end-to-end test.
+func TestTPMAttestation(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t, &fakeLeaderOption{
+		icc: &cpb.ClusterConfiguration{
+			ClusterDomain:         "cluster.test",
+			TpmMode:               cpb.ClusterConfiguration_TPM_MODE_REQUIRED,
+			StorageSecurityPolicy: cpb.ClusterConfiguration_STORAGE_SECURITY_POLICY_NEEDS_ENCRYPTION_AND_AUTHENTICATION,
+			TpmAttestation: &cpb.ClusterConfiguration_TPMAttestation{
+				Required: true,
+			},
+		},
+	})
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	cur := ipb.NewCuratorClient(cl.otherNodeConn)
+
+	// Challenges can only be made for valid keys.
+	_, err := cur.GetAttestationChallenge(ctx, &ipb.GetAttestationChallengeRequest{})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("GetAttestationChallenge without keys: wanted %s, got %v", want, err)
+	}
+	_, err = cur.GetAttestationChallenge(ctx, &ipb.GetAttestationChallengeRequest{
+		EkPublicKey: []byte("foo"),
+		AkPublic:    []byte("bar"),
+	})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("GetAttestationChallenge with invalid keys: wanted %s, got %v", want, err)
+	}
+
+	resT, err := mgmt.GetRegisterTicket(ctx, &apb.GetRegisterTicketRequest{})
+	if err != nil {
+		t.Fatalf("GetRegisterTicket failed: %v", err)
+	}
+	nodeJoinPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate node join keypair: %v", err)
+	}
+
+	// Registration must fail without attestation, or with an attestation for
+	// an unknown challenge.
+	for _, a := range []*ipb.Attestation{nil, {Nonce: []byte("foo")}} {
+		_, err = cur.RegisterNode(ctx, &ipb.RegisterNodeRequest{
+			RegisterTicket: resT.Ticket,
+			JoinKey:        nodeJoinPub,
+			HaveLocalTpm:   true,
+			Attestation:    a,
+		})
+		if want, got := codes.PermissionDenied, status.Code(err); want != got {
+			t.Errorf("RegisterNode with attestation %v: wanted %s, got %v", a, want, err)
+		}
+	}
+
+	// Once attestation is not required anymore, registration succeeds and the
+	// outcome is recorded.
+	_, err = mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+		NewConfig:  &cpb.ClusterConfiguration{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureCluster failed: %v", err)
+	}
+	_, err = cur.RegisterNode(ctx, &ipb.RegisterNodeRequest{
+		RegisterTicket: resT.Ticket,
+		JoinKey:        nodeJoinPub,
+		HaveLocalTpm:   true,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	nodes := getNodes(t, ctx, mgmt, fmt.Sprintf("node.id == %q", cl.otherNodeID))
+	if len(nodes) != 1 {
+		t.Fatalf("expected one node, got %d", len(nodes))
+	}
+	if a := nodes[0].Attestation; a == nil || a.Result != cpb.NodeAttestation_RESULT_MISSING || a.Operation != "RegisterNode" {
+		t.Errorf("expected missing attestation in RegisterNode, got %v", a)
+	}
+
+	// The EK of a node which registered without passing attestation is only
+	// recorded by an explicit SetNodeEndorsementKey call.
+	if ek := nodes[0].EkPublicKey; ek != nil {
+		t.Errorf("expected no EK recorded after RegisterNode, got %x", ek)
+	}
+	ekPub, err := x509.MarshalPKIXPublicKey(nodeJoinPub)
+	if err != nil {
+		t.Fatalf("could not marshal EK: %v", err)
+	}
+	for i, te := range []struct {
+		req  *apb.SetNodeEndorsementKeyRequest
+		want codes.Code
+	}{
+		{&apb.SetNodeEndorsementKeyRequest{Node: &apb.SetNodeEndorsementKeyRequest_Id{Id: "metropolis-nonexistent"}, EkPublicKey: ekPub}, codes.NotFound},
+		{&apb.SetNodeEndorsementKeyRequest{Node: &apb.SetNodeEndorsementKeyRequest_Id{Id: cl.otherNodeID}}, codes.InvalidArgument},
+		{&apb.SetNodeEndorsementKeyRequest{Node: &apb.SetNodeEndorsementKeyRequest_Id{Id: cl.otherNodeID}, EkPublicKey: []byte("ek")}, codes.InvalidArgument},
+		{&apb.SetNodeEndorsementKeyRequest{Node: &apb.SetNodeEndorsementKeyRequest_Id{Id: cl.otherNodeID}, EkPublicKey: ekPub}, codes.OK},
+		// The EK of a node cannot be replaced.
+		{&apb.SetNodeEndorsementKeyRequest{Node: &apb.SetNodeEndorsementKeyRequest_Id{Id: cl.otherNodeID}, EkPublicKey: ekPub}, codes.FailedPrecondition},
+	} {
+		_, err := mgmt.SetNodeEndorsementKey(ctx, te.req)
+		if got := status.Code(err); got != te.want {
+			t.Errorf("case %d: SetNodeEndorsementKey: wanted %s, got %v", i, te.want, err)
+		}
+	}
+	nodes = getNodes(t, ctx, mgmt, fmt.Sprintf("node.id == %q", cl.otherNodeID))
+	if len(nodes) != 1 {
+		t.Fatalf("expected one node, got %d", len(nodes))
+	}
+	if !bytes.Equal(nodes[0].EkPublicKey, ekPub) {
+		t.Errorf("expected EK %x recorded, got %x", ekPub, nodes[0].EkPublicKey)
+	}
+
+	// Verify attestations against pending challenges directly, as valid
+	// challenges cannot be solved without a TPM.
+	l := &leaderCurator{leadership: cl.l}
+	caller := []byte("caller")
+	ek := []byte("ek")
+	secret := []byte("secret")
+	addChallenge := func(nonce string, expires time.Time) {
+		l.ls.muAttestation.Lock()
+		defer l.ls.muAttestation.Unlock()
+		if l.ls.attestationChallenges == nil {
+			l.ls.attestationChallenges = make(map[string]*attestationChallenge)
+		}
+		l.ls.attestationChallenges[nonce] = &attestationChallenge{
+			nonce:   []byte(nonce),
+			caller:  caller,
+			ekPub:   ek,
+			secret:  secret,
+			expires: expires,
+		}
+	}
+	valid := time.Now().Add(time.Minute)
+	addChallenge("expired", time.Now().Add(-time.Minute))
+	addChallenge("other-caller", valid)
+	addChallenge("wrong-secret", valid)
+	addChallenge("other-ek", valid)
+	addChallenge("invalid-quote", valid)
+
+	for i, te := range []struct {
+		caller []byte
+		a      *ipb.Attestation
+		ekPub  []byte
+		result cpb.NodeAttestation_Result
+		reason string
+	}{
+		{caller, nil, nil, cpb.NodeAttestation_RESULT_MISSING, ""},
+		{caller, &ipb.Attestation{Nonce: []byte("unknown")}, nil, cpb.NodeAttestation_RESULT_FAILED, "unknown or expired challenge"},
+		{caller, &ipb.Attestation{Nonce: []byte("expired"), ActivatedSecret: secret}, nil, cpb.NodeAttestation_RESULT_FAILED, "unknown or expired challenge"},
+		{[]byte("other"), &ipb.Attestation{Nonce: []byte("other-caller"), ActivatedSecret: secret}, nil, cpb.NodeAttestation_RESULT_FAILED, "challenge was retrieved by another caller"},
+		{caller, &ipb.Attestation{Nonce: []byte("wrong-secret"), ActivatedSecret: []byte("wrong")}, nil, cpb.NodeAttestation_RESULT_FAILED, "invalid activated secret"},
+		{caller, &ipb.Attestation{Nonce: []byte("other-ek"), ActivatedSecret: secret}, []byte("other-ek"), cpb.NodeAttestation_RESULT_FAILED, "endorsement key differs from the one recorded for the node"},
+		{caller, &ipb.Attestation{Nonce: []byte("invalid-quote"), ActivatedSecret: secret}, ek, cpb.NodeAttestation_RESULT_FAILED, "invalid quote: "},
+		// Challenges are single-use.
+		{caller, &ipb.Attestation{Nonce: []byte("invalid-quote"), ActivatedSecret: secret}, ek, cpb.NodeAttestation_RESULT_FAILED, "unknown or expired challenge"},
+	} {
+		res := l.verifyAttestation(ctx, "JoinNode", te.caller, te.a, te.ekPub, nil)
+		if res.Result != te.result || !strings.HasPrefix(res.Reason, te.reason) || res.Operation != "JoinNode" {
+			t.Errorf("case %d: wanted %s (%q), got %s (%q)", i, te.result, te.reason, res.Result, res.Reason)
+		}
+	}
+
+	// Every caller can only have one pending challenge, and the number of
+	// pending challenges per source address is limited.
+	newChallenge := func(caller, source string) *attestationChallenge {
+		return &attestationChallenge{
+			nonce:   []byte(caller + "-" + source + "-" + time.Now().String()),
+			caller:  []byte(caller),
+			source:  source,
+			expires: valid,
+		}
+	}
+	countChallenges := func() int {
+		l.ls.muAttestation.Lock()
+		defer l.ls.muAttestation.Unlock()
+		return len(l.ls.attestationChallenges)
+	}
+	l.ls.muAttestation.Lock()
+	l.ls.attestationChallenges = nil
+	l.ls.muAttestation.Unlock()
+	for range 2 {
+		if err := l.addAttestationChallenge(newChallenge("repeated", "192.0.2.1")); err != nil {
+			t.Fatalf("addAttestationChallenge failed: %v", err)
+		}
+	}
+	if want, got := 1, countChallenges(); want != got {
+		t.Errorf("wanted %d pending challenge for a repeated caller, got %d", want, got)
+	}
+	for i := 1; i < maxAttestationChallengesPerSource; i++ {
+		if err := l.addAttestationChallenge(newChallenge(fmt.Sprintf("caller-%d", i), "192.0.2.1")); err != nil {
+			t.Fatalf("addAttestationChallenge failed: %v", err)
+		}
+	}
+	err = l.addAttestationChallenge(newChallenge("one-too-many", "192.0.2.1"))
+	if want, got := codes.ResourceExhausted, status.Code(err); want != got {
+		t.Errorf("addAttestationChallenge over the per-source limit: wanted %s, got %v", want, err)
+	}
+	if err := l.addAttestationChallenge(newChallenge("one-too-many", "192.0.2.2")); err != nil {
+		t.Errorf("addAttestationChallenge from another source failed: %v", err)
+	}
+}
+
+// TestEKCertificate exercises the verification of EK certificates against the
+// manufacturer CAs configured in the cluster's TPM attestation policy.
+func TestEKCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	// newCert returns a DER-encoded certificate for pub, which is self-signed
+	// if parent is nil. EK certificates are modeled after the TCG EK
+	// Credential Profile, with a critical directoryName subject alternative
+	// name and the EK certificate extended key usage.
+	newCert := func(pub any, parent *x509.Certificate, parentKey any) []byte {
+		t.Helper()
+		dirName, err := asn1.Marshal(pkix.Name{CommonName: "TPM"}.ToRDNSequence())
+		if err != nil {
+			t.Fatal(err)
+		}
+		san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: dirName}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:       big.NewInt(1),
+			NotBefore:          time.Now().Add(-time.Hour),
+			NotAfter:           time.Now().Add(time.Hour),
+			KeyUsage:           x509.KeyUsageKeyEncipherment,
+			UnknownExtKeyUsage: []asn1.ObjectIdentifier{{2, 23, 133, 8, 1}},
+			ExtraExtensions: []pkix.Extension{
+				{Id: oidSubjectAltName, Critical: true, Value: san},
+			},
+		}
+		if parent == nil {
+			parent = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	caKey := newKey()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM Manufacturer CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err = x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ekKey := newKey()
+	ekPub, err := x509.MarshalPKIXPublicKey(&ekKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := newCert(&ekKey.PublicKey, ca, caKey)
+	selfSigned := newCert(&ekKey.PublicKey, nil, ekKey)
+
+	// The certificate must certify the submitted EK.
+	otherKey := newKey()
+	otherPub, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseEKCertificate(issued, otherPub); err == nil {
+		t.Errorf("EK certificate for another key accepted")
+	}
+	_, _, err = newAttestationChallenge([]byte("caller"), &ipb.GetAttestationChallengeRequest{
+		EkPublicKey: ekPub,
+		AkPublic:    []byte("ak"),
+	})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got || !strings.Contains(err.Error(), "ek_certificate") {
+		t.Errorf("GetAttestationChallenge without EK certificate: wanted %s, got %v", want, err)
+	}
+
+	policy := &cpb.ClusterConfiguration_TPMAttestation{
+		EkCaCertificates: [][]byte{caDER},
+	}
+	secret := []byte("secret")
+	for i, te := range []struct {
+		cert   []byte
+		policy *cpb.ClusterConfiguration_TPMAttestation
+		reason string
+	}{
+		// A self-generated EK, eg. of a software TPM, is rejected.
+		{selfSigned, policy, "invalid endorsement key certificate: "},
+		{nil, policy, "invalid endorsement key certificate: missing"},
+		// Without configured CAs, the certificate is not verified.
+		{selfSigned, nil, "invalid quote: "},
+		// An EK certified by the manufacturer passes, and the attestation
+		// fails at the quote, which cannot be made without a TPM.
+		{issued, policy, "invalid quote: "},
+	} {
+		c := &attestationChallenge{
+			nonce:  []byte("nonce"),
+			ekPub:  ekPub,
+			secret: secret,
+		}
+		if te.cert != nil {
+			c.ekCert, err = parseEKCertificate(te.cert, ekPub)
+			if err != nil {
+				t.Fatalf("case %d: parsing EK certificate failed: %v", i, err)
+			}
+		}
+		a := &ipb.Attestation{Nonce: c.nonce, ActivatedSecret: secret}
+		res := checkAttestation(ctx, &cpb.NodeAttestation{}, c, a, ekPub, te.policy)
+		if res.Result != cpb.NodeAttestation_RESULT_FAILED || !strings.HasPrefix(res.Reason, te.reason) {
+			t.Errorf("case %d: wanted failure (%q), got %s (%q)", i, te.reason, res.Result, res.Reason)
+		}
+	}
+
+	// Invalid CA certificates are rejected in the cluster configuration.
+	err = validateTPMAttestation(&cpb.ClusterConfiguration_TPMAttestation{
+		EkCaCertificates: [][]byte{[]byte("invalid")},
+	}, cpb.ClusterConfiguration_TPM_MODE_REQUIRED)
+	if err == nil {
+		t.Errorf("invalid EK CA certificate accepted")
+	}
+}
+
+// TestReportMeasurements exercises the ReportMeasurements flow up to the point
+// where a TPM would be required, and the exposure of its outcome in GetNodes.
+func TestReportMeasurements(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+
+	// report performs a ReportMeasurements call with the given first message
+	// and returns the error of the first response.
+	report := func(req *ipb.ReportMeasurementsRequest) error {
+		ctx, ctxC := context.WithCancel(ctx)
+		defer ctxC()
+		srv, err := cur.ReportMeasurements(ctx)
+		if err != nil {
+			return err
+		}
+		if err := srv.Send(req); err != nil {
+			return err
+		}
+		_, err = srv.Recv()
+		return err
+	}
+	challenge := &ipb.ReportMeasurementsRequest{
+		Kind: &ipb.ReportMeasurementsRequest_Challenge{
+			Challenge: &ipb.GetAttestationChallengeRequest{
+				EkPublicKey: []byte("foo"),
+				AkPublic:    []byte("bar"),
+			},
+		},
+	}
+
+	// Nodes without a recorded EK cannot report measurements.
+	if want, got := codes.FailedPrecondition, status.Code(report(challenge)); want != got {
+		t.Errorf("ReportMeasurements without EK: wanted %s, got %s", want, got)
+	}
+
+	node, err := nodeLoad(ctx, cl.l, cl.localNodeID)
+	if err != nil {
+		t.Fatalf("could not load node: %v", err)
+	}
+	node.ekPub = []byte("ek")
+	if err := nodeSave(ctx, cl.l, node); err != nil {
+		t.Fatalf("could not save node: %v", err)
+	}
+
+	// The exchange must start with a valid challenge request.
+	reportFirst := &ipb.ReportMeasurementsRequest{
+		Kind: &ipb.ReportMeasurementsRequest_Report_{
+			Report: &ipb.ReportMeasurementsRequest_Report{},
+		},
+	}
+	if want, got := codes.InvalidArgument, status.Code(report(reportFirst)); want != got {
+		t.Errorf("ReportMeasurements starting with report: wanted %s, got %s", want, got)
+	}
+	if want, got := codes.InvalidArgument, status.Code(report(challenge)); want != got {
+		t.Errorf("ReportMeasurements with invalid keys: wanted %s, got %s", want, got)
+	}
+
+	// Verify reports directly, as valid challenges cannot be solved without a
+	// TPM.
+	c := &attestationChallenge{
+		nonce:   []byte("nonce"),
+		ekPub:   []byte("ek"),
+		secret:  []byte("secret"),
+		expires: time.Now().Add(time.Minute),
+	}
+	for i, te := range []struct {
+		a      *ipb.Attestation
+		ekPub  []byte
+		reason string
+	}{
+		{&ipb.Attestation{Nonce: []byte("other"), ActivatedSecret: c.secret}, c.ekPub, "attestation failed: nonce does not match challenge"},
+		{&ipb.Attestation{Nonce: c.nonce, ActivatedSecret: []byte("wrong")}, c.ekPub, "attestation failed: invalid activated secret"},
+		{&ipb.Attestation{Nonce: c.nonce, ActivatedSecret: c.secret}, []byte("other"), "attestation failed: endorsement key differs"},
+		{&ipb.Attestation{Nonce: c.nonce, ActivatedSecret: c.secret}, c.ekPub, "attestation failed: invalid quote: "},
+	} {
+		res := verifyMeasurements(ctx, c, &ipb.ReportMeasurementsRequest_Report{Attestation: te.a}, te.ekPub, nil)
+		if res.Result != cpb.NodeAttestation_RESULT_FAILED || !strings.HasPrefix(res.Reason, te.reason) {
+			t.Errorf("case %d: wanted failure (%q), got %s (%q)", i, te.reason, res.Result, res.Reason)
+		}
+	}
+
+	// The outcome is exposed in GetNodes, and can be filtered on.
+	node, err = nodeLoad(ctx, cl.l, cl.localNodeID)
+	if err != nil {
+		t.Fatalf("could not load node: %v", err)
+	}
+	node.measuredBoot = &cpb.NodeMeasuredBoot{
+		Result: cpb.NodeAttestation_RESULT_FAILED,
+		Reason: "boot application not allowed",
+	}
+	if err := nodeSave(ctx, cl.l, node); err != nil {
+		t.Fatalf("could not save node: %v", err)
+	}
+	for _, filter := range []string{
+		"node.attestation_status == ATTESTATION_STATUS_FAILED",
+		"node.measured_boot.result == RESULT_FAILED",
+	} {
+		nodes := getNodes(t, ctx, mgmt, filter)
+		if len(nodes) != 1 || nodes[0].Id != cl.localNodeID {
+			t.Errorf("GetNodes(%q) returned %v, wanted only %s", filter, nodes, cl.localNodeID)
+			continue
+		}
+		if mb := nodes[0].MeasuredBoot; mb == nil || mb.Reason != "boot application not allowed" {
+			t.Errorf("GetNodes(%q) returned measured boot %v", filter, mb)
+		}
+	}
+	if nodes := getNodes(t, ctx, mgmt, "node.attestation_status == ATTESTATION_STATUS_VERIFIED"); len(nodes) != 0 {
+		t.Errorf("GetNodes returned verified nodes %v, wanted none", nodes)
+	}
+
+	// Verified measurements are checked against the measured boot policy
+	// whenever it changes.
+	app := bytes.Repeat([]byte{1}, 32)
+	node.measuredBoot = &cpb.NodeMeasuredBoot{
+		Result:           cpb.NodeAttestation_RESULT_PASSED,
+		BootApplications: [][]byte{app},
+		EventLogVerified: true,
+	}
+	if err := nodeSave(ctx, cl.l, node); err != nil {
+		t.Fatalf("could not save node: %v", err)
+	}
+	for i, te := range []struct {
+		policy *cpb.ClusterConfiguration_MeasuredBootPolicy
+		result cpb.NodeAttestation_Result
+		reason string
+	}{
+		{&cpb.ClusterConfiguration_MeasuredBootPolicy{AllowedBootApplications: [][]byte{bytes.Repeat([]byte{2}, 32)}}, cpb.NodeAttestation_RESULT_FAILED, "boot application 0101"},
+		{&cpb.ClusterConfiguration_MeasuredBootPolicy{AllowedBootApplications: [][]byte{app}}, cpb.NodeAttestation_RESULT_PASSED, ""},
+		{&cpb.ClusterConfiguration_MeasuredBootPolicy{RequireSecureBoot: true}, cpb.NodeAttestation_RESULT_FAILED, "secure boot is not enabled"},
+		{nil, cpb.NodeAttestation_RESULT_PASSED, ""},
+	} {
+		_, err := mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+			NewConfig:  &cpb.ClusterConfiguration{MeasuredBootPolicy: te.policy},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"measured_boot_policy"}},
+		})
+		if err != nil {
+			t.Fatalf("case %d: ConfigureCluster failed: %v", i, err)
+		}
+		nodes := getNodes(t, ctx, mgmt, fmt.Sprintf("node.id == %q", cl.localNodeID))
+		if len(nodes) != 1 {
+			t.Fatalf("case %d: expected one node, got %d", i, len(nodes))
+		}
+		if mb := nodes[0].MeasuredBoot; mb.Result != te.result || !strings.HasPrefix(mb.Reason, te.reason) {
+			t.Errorf("case %d: wanted %s (%q), got %s (%q)", i, te.result, te.reason, mb.Result, mb.Reason)
+		}
+	}
+}
+
+func TestNodeAttestationStatus(t *testing.T) {
+	passed := cpb.NodeAttestation_RESULT_PASSED
+	failed := cpb.NodeAttestation_RESULT_FAILED
+	missing := cpb.NodeAttestation_RESULT_MISSING
+	for i, te := range []struct {
+		attestation  *cpb.NodeAttestation
+		measuredBoot *cpb.NodeMeasuredBoot
+		want         apb.Node_AttestationStatus
+	}{
+		{nil, nil, apb.Node_ATTESTATION_STATUS_UNATTESTED},
+		{&cpb.NodeAttestation{Result: missing}, nil, apb.Node_ATTESTATION_STATUS_UNATTESTED},
+		{&cpb.NodeAttestation{Result: passed}, nil, apb.Node_ATTESTATION_STATUS_ATTESTED},
+		{&cpb.NodeAttestation{Result: passed}, &cpb.NodeMeasuredBoot{Result: missing}, apb.Node_ATTESTATION_STATUS_ATTESTED},
+		{&cpb.NodeAttestation{Result: passed}, &cpb.NodeMeasuredBoot{Result: passed}, apb.Node_ATTESTATION_STATUS_VERIFIED},
+		{nil, &cpb.NodeMeasuredBoot{Result: passed}, apb.Node_ATTESTATION_STATUS_VERIFIED},
+		{&cpb.NodeAttestation{Result: passed}, &cpb.NodeMeasuredBoot{Result: failed}, apb.Node_ATTESTATION_STATUS_FAILED},
+		{&cpb.NodeAttestation{Result: failed}, &cpb.NodeMeasuredBoot{Result: passed}, apb.Node_ATTESTATION_STATUS_FAILED},
+	} {
+		node := &Node{attestation: te.attestation, measuredBoot: te.measuredBoot}
+		if got := nodeAttestationStatus(node); got != te.want {
+			t.Errorf("case %d: wanted %s, got %s", i, te.want, got)
+		}
+	}
+}
+
+func TestPCRsMatch(t *testing.T) {
+	pcrs := make([][]byte, attestationPCRs)
+	for i := range pcrs {
+		pcrs[i] = bytes.Repeat([]byte{byte(i)}, 32)
+	}
+	for i, te := range []struct {
+		expected map[uint32][]byte
+		match    bool
+	}{
+		{map[uint32][]byte{}, true},
+		{map[uint32][]byte{0: pcrs[0], 7: pcrs[7]}, true},
+		{map[uint32][]byte{0: pcrs[0], 7: pcrs[8]}, false},
+		{map[uint32][]byte{16: pcrs[0]}, false},
+	} {
+		if got := pcrsMatch(pcrs, te.expected); got != te.match {
+			t.Errorf("case %d: wanted %v, got %v", i, te.match, got)
+		}
+	}
+}
+
 func TestNodeLabels(t *testing.T) {
 	ctx, ctxC := context.WithCancel(context.Background())
 	defer ctxC()
@@ -1931,6 +2531,72 @@ func TestNodeLabels(t *testing.T) {
 	}
 }
 
+// TestNodeTimeConfiguration exercises setting and clearing the time
+// configuration override of a node, and its propagation to the node's Watch.
+func TestNodeTimeConfiguration(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+
+	update := func(servers ...string) error {
+		_, err := mgmt.UpdateNodeTimeConfiguration(ctx, &apb.UpdateNodeTimeConfigurationRequest{
+			Node: &apb.UpdateNodeTimeConfigurationRequest_Id{
+				Id: cl.localNodeID,
+			},
+			TimeConfiguration: &cpb.NodeTimeConfiguration{
+				NtpServers: servers,
+			},
+		})
+		return err
+	}
+	// getOverride returns the time configuration of the local node, both as
+	// returned by Management.GetNodes and Curator.Watch.
+	getOverride := func() (*cpb.NodeTimeConfiguration, *cpb.NodeTimeConfiguration) {
+		t.Helper()
+		nodes := getNodes(t, ctx, mgmt, fmt.Sprintf("node.id == %q", cl.localNodeID))
+		if len(nodes) != 1 {
+			t.Fatalf("Expected 1 node, got %d", len(nodes))
+		}
+		w := watcher.WatchNode(ctx, cur, cl.localNodeID)
+		defer w.Close()
+		if !w.Next() {
+			t.Fatalf("Watch: %v", w.Error())
+		}
+		return nodes[0].TimeConfiguration, w.Node().TimeConfiguration
+	}
+
+	if err := update("ntp.example.com", "192.0.2.1"); err != nil {
+		t.Fatalf("UpdateNodeTimeConfiguration: %v", err)
+	}
+	want := &cpb.NodeTimeConfiguration{NtpServers: []string{"ntp.example.com", "192.0.2.1"}}
+	fromNodes, fromWatch := getOverride()
+	for _, got := range []*cpb.NodeTimeConfiguration{fromNodes, fromWatch} {
+		if !proto.Equal(want, got) {
+			t.Errorf("Wanted time configuration %v, got %v", want, got)
+		}
+	}
+
+	// Invalid NTP servers must be rejected without changing the configuration.
+	err := update("ntp.example.com:123")
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("UpdateNodeTimeConfiguration with invalid server returned %v, wanted %s", err, want)
+	}
+	if got, _ := getOverride(); !proto.Equal(want, got) {
+		t.Errorf("Time configuration changed by invalid request: %v", got)
+	}
+
+	// An empty list of NTP servers clears the override.
+	if err := update(); err != nil {
+		t.Fatalf("UpdateNodeTimeConfiguration: %v", err)
+	}
+	if a, b := getOverride(); a != nil || b != nil {
+		t.Errorf("Time configuration not cleared: %v, %v", a, b)
+	}
+}
+
 // TestBackgroundSyncEtcd tests that backgroundSyncEtcd behaves as expected.
 func TestBackgroundSyncEtcd(t *testing.T) {
 	// Obtain a supervisor context.
@@ -2048,3 +2714,1157 @@ type ConsensusNodeState struct {
 	kubernetesController bool
 	etcdMember           bool
 }
+
+// TestRollout exercises a cluster-wide rollout, from starting it through
+// Management.StartRollout, through the leader instructing nodes to update one
+// by one, to its completion.
+func TestRollout(t *testing.T) {
+	// Obtain a supervisor context.
+	ctxChannel := make(chan context.Context)
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		ctxChannel <- ctx
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	ctx := <-ctxChannel
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	background := leaderBackground{leadership: cl.l}
+
+	// Create two healthy worker nodes to be updated.
+	var nodes []*Node
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, putNode(t, ctx, cl.l, func(n *Node) {
+			n.state = cpb.NodeState_NODE_STATE_UP
+			n.status = &cpb.NodeStatus{BootId: []byte("boot-1")}
+		}))
+	}
+	heartbeat := func() {
+		for _, n := range nodes {
+			cl.l.ls.heartbeatTimestamps.Store(n.ID(), time.Now())
+		}
+	}
+	heartbeat()
+	// reboot simulates a node rebooting into the image with the given digest.
+	reboot := func(n *Node, digest string) {
+		t.Helper()
+		node, err := nodeLoad(ctx, cl.l, n.ID())
+		if err != nil {
+			t.Fatalf("nodeLoad: %v", err)
+		}
+		node.status = &cpb.NodeStatus{BootId: []byte("boot-2"), OsImageDigest: digest}
+		if err := nodeSave(ctx, cl.l, node); err != nil {
+			t.Fatalf("nodeSave: %v", err)
+		}
+		heartbeat()
+	}
+	// assertState checks the states of the rollout and of its nodes, and that
+	// exactly the nodes which are being updated have an update set.
+	assertState := func(state apb.Rollout_State, nodeStates ...apb.Rollout_Node_State) {
+		t.Helper()
+		res, err := mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+		if err != nil {
+			t.Fatalf("GetRollout: %v", err)
+		}
+		ro := res.Rollout
+		if ro.State != state {
+			t.Fatalf("Rollout state is %s, wanted %s", ro.State, state)
+		}
+		for i, rn := range ro.Nodes {
+			if rn.State != nodeStates[i] {
+				t.Fatalf("Node %d state is %s, wanted %s", i, rn.State, nodeStates[i])
+			}
+			node, err := nodeLoad(ctx, cl.l, rn.Id)
+			if err != nil {
+				t.Fatalf("nodeLoad: %v", err)
+			}
+			updating := rn.State == apb.Rollout_Node_STATE_UPDATING
+			if want, got := updating, node.update != nil; want != got {
+				t.Fatalf("Node %d has update: %v, wanted %v", i, got, want)
+			}
+			if updating && node.update.Id != ro.Id {
+				t.Fatalf("Node %d has update ID %q, wanted %q", i, node.update.Id, ro.Id)
+			}
+		}
+	}
+	doRollout := func() {
+		t.Helper()
+		if err := background.doRollout(ctx); err != nil {
+			t.Fatalf("doRollout: %v", err)
+		}
+	}
+
+	// No rollout should exist yet.
+	res, err := mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetRollout: %v", err)
+	}
+	if res.Rollout != nil {
+		t.Fatalf("Unexpected rollout: %v", res.Rollout)
+	}
+
+	req := &apb.StartRolloutRequest{
+		OsImage: &apb.OSImageRef{
+			Scheme:     "https",
+			Host:       "registry.example",
+			Repository: "monogon-os/node",
+			Digest:     "sha256:345db5d8fc468218c5232bf54a1358b6825c28d658fa12c9a1edcc7539690686",
+		},
+		Policy: &apb.RolloutPolicy{
+			ActivationMode: apb.ActivationMode_ACTIVATION_MODE_REBOOT,
+		},
+		Filter: fmt.Sprintf("node.id == %q || node.id == %q", nodes[0].ID(), nodes[1].ID()),
+	}
+	// Invalid requests should be rejected.
+	for i, mut := range []func(r *apb.StartRolloutRequest){
+		func(r *apb.StartRolloutRequest) { r.OsImage.Digest = "" },
+		func(r *apb.StartRolloutRequest) { r.Policy.ActivationMode = apb.ActivationMode_ACTIVATION_MODE_NONE },
+		func(r *apb.StartRolloutRequest) { r.Filter = "false" },
+	} {
+		r := proto.Clone(req).(*apb.StartRolloutRequest)
+		mut(r)
+		if _, err := mgmt.StartRollout(ctx, r); err == nil {
+			t.Errorf("Invalid request %d: StartRollout succeeded", i)
+		}
+	}
+
+	sres, err := mgmt.StartRollout(ctx, req)
+	if err != nil {
+		t.Fatalf("StartRollout: %v", err)
+	}
+	if want, got := uint32(1), sres.Rollout.Policy.MaxUnavailable; want != got {
+		t.Errorf("MaxUnavailable is %d, wanted default %d", got, want)
+	}
+	// Node order in the rollout is not defined, so reorder our nodes to match.
+	if sres.Rollout.Nodes[0].Id != nodes[0].ID() {
+		nodes[0], nodes[1] = nodes[1], nodes[0]
+	}
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_PENDING, apb.Rollout_Node_STATE_PENDING)
+
+	// Starting another rollout while this one is running should fail.
+	if _, err := mgmt.StartRollout(ctx, req); err == nil {
+		t.Fatalf("Second StartRollout succeeded")
+	}
+
+	// The first node should be instructed to update, but not the second one.
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_UPDATING, apb.Rollout_Node_STATE_PENDING)
+	// Without the node rebooting, nothing should change.
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_UPDATING, apb.Rollout_Node_STATE_PENDING)
+
+	// A failure to install the update is surfaced in the rollout.
+	node, err := nodeLoad(ctx, cl.l, nodes[0].ID())
+	if err != nil {
+		t.Fatalf("nodeLoad: %v", err)
+	}
+	node.status = &cpb.NodeStatus{
+		BootId: []byte("boot-1"),
+		UpdateFailure: &cpb.NodeUpdateFailure{
+			Reason: "test",
+		},
+	}
+	node.updateStatus = &ipb.NodeUpdateStatus{
+		FailedUpdateId: sres.Rollout.Id,
+	}
+	if err := nodeSave(ctx, cl.l, node); err != nil {
+		t.Fatalf("nodeSave: %v", err)
+	}
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_UPDATING, apb.Rollout_Node_STATE_PENDING)
+	res, err = mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetRollout: %v", err)
+	}
+	if want, got := "node failed to install the update: test", res.Rollout.Nodes[0].Message; want != got {
+		t.Errorf("Node message is %q, wanted %q", got, want)
+	}
+
+	// A node which rebooted without activating the update, eg. because it
+	// crashed, is still being updated.
+	reboot(nodes[0], "")
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_UPDATING, apb.Rollout_Node_STATE_PENDING)
+
+	// Once the first node has rebooted into the new image, the second node is
+	// updated.
+	reboot(nodes[0], req.OsImage.Digest)
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_UPDATING)
+
+	// Pausing the rollout lets the in-flight update finish, but keeps the
+	// rollout paused.
+	if _, err := mgmt.PauseRollout(ctx, &apb.PauseRolloutRequest{Paused: true}); err != nil {
+		t.Fatalf("PauseRollout: %v", err)
+	}
+	reboot(nodes[1], req.OsImage.Digest)
+	doRollout()
+	assertState(apb.Rollout_STATE_PAUSED, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_DONE)
+
+	// Resuming the rollout lets it finish.
+	if _, err := mgmt.PauseRollout(ctx, &apb.PauseRolloutRequest{Paused: false}); err != nil {
+		t.Fatalf("PauseRollout: %v", err)
+	}
+	doRollout()
+	assertState(apb.Rollout_STATE_DONE, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_DONE)
+
+	// A finished rollout cannot be paused, but a new one can be started.
+	if _, err := mgmt.PauseRollout(ctx, &apb.PauseRolloutRequest{Paused: true}); err == nil {
+		t.Fatalf("PauseRollout on finished rollout succeeded")
+	}
+	if _, err := mgmt.StartRollout(ctx, req); err != nil {
+		t.Fatalf("StartRollout after finished rollout: %v", err)
+	}
+	res, err = mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetRollout: %v", err)
+	}
+	if res.Rollout.Nodes[0].Id != nodes[0].ID() {
+		nodes[0], nodes[1] = nodes[1], nodes[0]
+	}
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_UPDATING, apb.Rollout_Node_STATE_PENDING)
+
+	// rollBack simulates the node rolling back the update with the given ID.
+	rollBack := func(n *Node, updateID string) {
+		t.Helper()
+		node, err := nodeLoad(ctx, cl.l, n.ID())
+		if err != nil {
+			t.Fatalf("nodeLoad: %v", err)
+		}
+		node.status = &cpb.NodeStatus{
+			BootId:        []byte("boot-3"),
+			OsImageDigest: req.OsImage.Digest,
+			LastRollback: &cpb.NodeRollback{
+				Reason:    "test",
+				Timestamp: timestamppb.Now(),
+			},
+		}
+		node.updateStatus = &ipb.NodeUpdateStatus{
+			RolledBackUpdateId: updateID,
+		}
+		if err := nodeSave(ctx, cl.l, node); err != nil {
+			t.Fatalf("nodeSave: %v", err)
+		}
+		heartbeat()
+	}
+
+	// A rollback of another update, regardless of its time, is ignored.
+	rollBack(nodes[0], sres.Rollout.Id)
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_UPDATING)
+	doRollout()
+	assertState(apb.Rollout_STATE_RUNNING, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_UPDATING)
+
+	// A node which rolls back the update fails the rollout.
+	rollBack(nodes[1], res.Rollout.Id)
+	doRollout()
+	assertState(apb.Rollout_STATE_FAILED, apb.Rollout_Node_STATE_DONE, apb.Rollout_Node_STATE_FAILED)
+}
+
+// TestRolloutFailure ensures that when a rollout fails while multiple nodes are
+// being updated, all of them are resolved and a new rollout can be started.
+func TestRolloutFailure(t *testing.T) {
+	ctxChannel := make(chan context.Context)
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		ctxChannel <- ctx
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	ctx := <-ctxChannel
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	background := leaderBackground{leadership: cl.l}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		n := putNode(t, ctx, cl.l, func(n *Node) {
+			n.state = cpb.NodeState_NODE_STATE_UP
+			n.status = &cpb.NodeStatus{BootId: []byte("boot-1")}
+		})
+		cl.l.ls.heartbeatTimestamps.Store(n.ID(), time.Now())
+		ids = append(ids, n.ID())
+	}
+
+	req := &apb.StartRolloutRequest{
+		OsImage: &apb.OSImageRef{
+			Scheme:     "https",
+			Host:       "registry.example",
+			Repository: "monogon-os/node",
+			Digest:     "sha256:345db5d8fc468218c5232bf54a1358b6825c28d658fa12c9a1edcc7539690686",
+		},
+		Policy: &apb.RolloutPolicy{
+			ActivationMode: apb.ActivationMode_ACTIVATION_MODE_REBOOT,
+			MaxUnavailable: 2,
+		},
+		Filter: fmt.Sprintf("node.id in [%q, %q, %q]", ids[0], ids[1], ids[2]),
+	}
+	if _, err := mgmt.StartRollout(ctx, req); err != nil {
+		t.Fatalf("StartRollout: %v", err)
+	}
+	if err := background.doRollout(ctx); err != nil {
+		t.Fatalf("doRollout: %v", err)
+	}
+	res, err := mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetRollout: %v", err)
+	}
+	var updating []string
+	for _, rn := range res.Rollout.Nodes {
+		if rn.State == apb.Rollout_Node_STATE_UPDATING {
+			updating = append(updating, rn.Id)
+		}
+	}
+	if len(updating) != 2 {
+		t.Fatalf("%d nodes are updating, wanted 2", len(updating))
+	}
+
+	// Roll back the update on one of the two nodes.
+	node, err := nodeLoad(ctx, cl.l, updating[1])
+	if err != nil {
+		t.Fatalf("nodeLoad: %v", err)
+	}
+	node.status = &cpb.NodeStatus{
+		BootId: []byte("boot-2"),
+		LastRollback: &cpb.NodeRollback{
+			Reason:    "test",
+			Timestamp: timestamppb.Now(),
+		},
+	}
+	node.updateStatus = &ipb.NodeUpdateStatus{
+		RolledBackUpdateId: res.Rollout.Id,
+	}
+	if err := nodeSave(ctx, cl.l, node); err != nil {
+		t.Fatalf("nodeSave: %v", err)
+	}
+	if err := background.doRollout(ctx); err != nil {
+		t.Fatalf("doRollout: %v", err)
+	}
+
+	res, err = mgmt.GetRollout(ctx, &apb.GetRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetRollout: %v", err)
+	}
+	if want, got := apb.Rollout_STATE_FAILED, res.Rollout.State; want != got {
+		t.Fatalf("Rollout state is %s, wanted %s", got, want)
+	}
+	for _, rn := range res.Rollout.Nodes {
+		if rn.State == apb.Rollout_Node_STATE_UPDATING {
+			t.Errorf("Node %s is still updating", rn.Id)
+		}
+		node, err := nodeLoad(ctx, cl.l, rn.Id)
+		if err != nil {
+			t.Fatalf("nodeLoad: %v", err)
+		}
+		if node.update != nil {
+			t.Errorf("Node %s still has an update", rn.Id)
+		}
+	}
+
+	// A new rollout can be started.
+	if _, err := mgmt.StartRollout(ctx, req); err != nil {
+		t.Fatalf("StartRollout after failed rollout: %v", err)
+	}
+}
+
+// TestAccessControl exercises role and role binding management, and the
+// escrow of certificates for bound identities.
+func TestAccessControl(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate user keypair: %v", err)
+	}
+
+	// Invalid roles must be rejected.
+	for i, role := range []*apb.Role{
+		{Name: "Viewer"},
+		{Name: "viewer", Permissions: []epb.Permission{epb.Permission_PERMISSION_UNSPECIFIED}},
+		{Name: "viewer", Permissions: []epb.Permission{epb.Permission_PERMISSION_UPDATE_NODE_SELF}},
+	} {
+		_, err := mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: role})
+		if want, got := codes.InvalidArgument, status.Code(err); want != got {
+			t.Errorf("%d: PutRole returned %v, wanted %s", i, err, want)
+		}
+	}
+
+	// Binding to a role which doesn't exist must fail.
+	binding := &apb.RoleBinding{
+		Identity:  "ci-bot",
+		PublicKey: userPub,
+		Roles:     []string{"viewer"},
+	}
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: binding})
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Errorf("PutRoleBinding with missing role returned %v, wanted %s", err, want)
+	}
+
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "viewer",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	if _, err := mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: binding}); err != nil {
+		t.Fatalf("PutRoleBinding: %v", err)
+	}
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "owner",
+		PublicKey: userPub,
+	}})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("PutRoleBinding for owner returned %v, wanted %s", err, want)
+	}
+
+	roles, err := mgmt.ListRoles(ctx, &apb.ListRolesRequest{})
+	if err != nil {
+		t.Fatalf("ListRoles: %v", err)
+	}
+	if len(roles.Roles) != 1 || roles.Roles[0].Name != "viewer" {
+		t.Errorf("ListRoles returned %v, wanted only viewer", roles.Roles)
+	}
+	bindings, err := mgmt.ListRoleBindings(ctx, &apb.ListRoleBindingsRequest{})
+	if err != nil {
+		t.Fatalf("ListRoleBindings: %v", err)
+	}
+	if want, got := []*apb.RoleBinding{binding}, bindings.RoleBindings; !cmp.Equal(want, got, protocmp.Transform()) {
+		t.Errorf("ListRoleBindings returned %v, wanted %v", got, want)
+	}
+
+	// A bound role cannot be deleted.
+	_, err = mgmt.DeleteRole(ctx, &apb.DeleteRoleRequest{Name: "viewer"})
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Errorf("DeleteRole of bound role returned %v, wanted %s", err, want)
+	}
+
+	// Escrow a certificate for the bound identity, and make sure it contains
+	// the permissions of the bound role.
+	escrow := func(name string, priv ed25519.PrivateKey) (*tls.Certificate, error) {
+		creds, err := rpc.NewEphemeralCredentials(priv, rpc.WantRemoteCluster(cl.ca))
+		if err != nil {
+			t.Fatalf("NewEphemeralCredentials: %v", err)
+		}
+		conn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+			return cl.curatorLis.Dial()
+		}), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("Creating GRPC client failed: %v", err)
+		}
+		defer conn.Close()
+		return rpc.RetrieveIdentityCertificate(ctx, apb.NewAAAClient(conn), name, priv)
+	}
+	cert, err := escrow("ci-bot", userPriv)
+	if err != nil {
+		t.Fatalf("Escrow: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse emitted certificate: %v", err)
+	}
+	if want, got := "ci-bot", parsed.Subject.CommonName; want != got {
+		t.Errorf("Certificate is for %q, wanted %q", got, want)
+	}
+	if want, got := []string{"PERMISSION_READ_CLUSTER_STATUS"}, identity.UserPermissions(parsed); !cmp.Equal(want, got) {
+		t.Errorf("Certificate has permissions %v, wanted %v", got, want)
+	}
+
+	// Other keys and unbound identities must be rejected.
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate keypair: %v", err)
+	}
+	for _, name := range []string{"ci-bot", "someone-else"} {
+		_, err := escrow(name, otherPriv)
+		if want, got := codes.PermissionDenied, status.Code(err); want != got {
+			t.Errorf("Escrow of %q with other key returned %v, wanted %s", name, err, want)
+		}
+	}
+
+	// Users managing access can only grant permissions they hold themselves.
+	adminPub, adminPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate admin keypair: %v", err)
+	}
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "access-admin",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_MANAGE_ACCESS, epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "node-admin",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_APPROVE_NODE},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "access-admin",
+		PublicKey: adminPub,
+		Roles:     []string{"access-admin"},
+	}})
+	if err != nil {
+		t.Fatalf("PutRoleBinding: %v", err)
+	}
+	adminCert, err := escrow("access-admin", adminPriv)
+	if err != nil {
+		t.Fatalf("Escrow: %v", err)
+	}
+	adminConn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+		return cl.curatorLis.Dial()
+	}), grpc.WithTransportCredentials(rpc.NewAuthenticatedCredentials(*adminCert, rpc.WantRemoteCluster(cl.ca))))
+	if err != nil {
+		t.Fatalf("Creating GRPC client failed: %v", err)
+	}
+	defer adminConn.Close()
+	adminMgmt := apb.NewManagementClient(adminConn)
+
+	_, err = adminMgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "escalated",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_MANAGE_ACCESS, epb.Permission_PERMISSION_APPROVE_NODE},
+	}})
+	if want, got := codes.PermissionDenied, status.Code(err); want != got {
+		t.Errorf("PutRole with unheld permission returned %v, wanted %s", err, want)
+	}
+	for _, roles := range [][]string{{"node-admin"}, {"viewer", "node-admin"}} {
+		_, err = adminMgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+			Identity:  "access-admin",
+			PublicKey: adminPub,
+			Roles:     roles,
+		}})
+		if want, got := codes.PermissionDenied, status.Code(err); want != got {
+			t.Errorf("PutRoleBinding to %v returned %v, wanted %s", roles, err, want)
+		}
+	}
+	_, err = adminMgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "auditor",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Errorf("PutRole with held permission: %v", err)
+	}
+	_, err = adminMgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "auditor",
+		PublicKey: userPub,
+		Roles:     []string{"viewer", "auditor"},
+	}})
+	if err != nil {
+		t.Errorf("PutRoleBinding to held permissions: %v", err)
+	}
+	if _, err := mgmt.DeleteRoleBinding(ctx, &apb.DeleteRoleBindingRequest{Identity: "auditor"}); err != nil {
+		t.Fatalf("DeleteRoleBinding: %v", err)
+	}
+
+	// After removing the binding, the role can be deleted and the identity can no
+	// longer escrow certificates.
+	if _, err := mgmt.DeleteRoleBinding(ctx, &apb.DeleteRoleBindingRequest{Identity: "ci-bot"}); err != nil {
+		t.Fatalf("DeleteRoleBinding: %v", err)
+	}
+	if _, err := mgmt.DeleteRole(ctx, &apb.DeleteRoleRequest{Name: "viewer"}); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	_, err = escrow("ci-bot", userPriv)
+	if want, got := codes.PermissionDenied, status.Code(err); want != got {
+		t.Errorf("Escrow after binding removal returned %v, wanted %s", err, want)
+	}
+}
+
+// TestCertificateRevocation exercises listing and revoking of certificates
+// issued to users, and distribution of the resulting CRL to nodes.
+func TestCertificateRevocation(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate user keypair: %v", err)
+	}
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "viewer",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "ci-bot",
+		PublicKey: userPub,
+		Roles:     []string{"viewer"},
+	}})
+	if err != nil {
+		t.Fatalf("PutRoleBinding: %v", err)
+	}
+
+	escrow := func(name string) (*tls.Certificate, error) {
+		creds, err := rpc.NewEphemeralCredentials(userPriv, rpc.WantRemoteCluster(cl.ca))
+		if err != nil {
+			t.Fatalf("NewEphemeralCredentials: %v", err)
+		}
+		conn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+			return cl.curatorLis.Dial()
+		}), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("Creating GRPC client failed: %v", err)
+		}
+		defer conn.Close()
+		return rpc.RetrieveIdentityCertificate(ctx, apb.NewAAAClient(conn), name, userPriv)
+	}
+	cert, err := escrow("ci-bot")
+	if err != nil {
+		t.Fatalf("Escrow: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse emitted certificate: %v", err)
+	}
+	serial := parsed.SerialNumber.Text(16)
+
+	// The escrowed certificate must be listed.
+	list, err := mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+	if err != nil {
+		t.Fatalf("ListCertificates: %v", err)
+	}
+	if len(list.Certificates) != 1 {
+		t.Fatalf("ListCertificates returned %d certificates, wanted 1", len(list.Certificates))
+	}
+	c := list.Certificates[0]
+	if c.SerialNumber != serial || c.Identity != "ci-bot" || c.Revoked {
+		t.Errorf("ListCertificates returned %v, wanted unrevoked certificate %s of ci-bot", c, serial)
+	}
+
+	// Unknown and malformed serial numbers must be rejected.
+	for _, tc := range []struct {
+		serial string
+		want   codes.Code
+	}{
+		{"not-a-serial", codes.InvalidArgument},
+		{"1234", codes.NotFound},
+	} {
+		_, err := mgmt.RevokeCertificate(ctx, &apb.RevokeCertificateRequest{SerialNumber: tc.serial})
+		if got := status.Code(err); got != tc.want {
+			t.Errorf("RevokeCertificate(%q) returned %v, wanted %s", tc.serial, err, tc.want)
+		}
+	}
+
+	// Revoke the escrowed certificate, and ensure it appears in the CRL sent to
+	// nodes.
+	srv, err := ipb.NewCuratorClient(cl.localNodeConn).WatchCRL(ctx, &ipb.WatchCRLRequest{})
+	if err != nil {
+		t.Fatalf("WatchCRL: %v", err)
+	}
+	var revocations identity.Revocations
+	res, err := srv.Recv()
+	if err != nil {
+		t.Fatalf("WatchCRL.Recv: %v", err)
+	}
+	if err := revocations.Update(res.Crl, cl.ca); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if revocations.Revoked(parsed) {
+		t.Errorf("Certificate revoked before RevokeCertificate")
+	}
+	if _, err := mgmt.RevokeCertificate(ctx, &apb.RevokeCertificateRequest{SerialNumber: serial}); err != nil {
+		t.Fatalf("RevokeCertificate: %v", err)
+	}
+	res, err = srv.Recv()
+	if err != nil {
+		t.Fatalf("WatchCRL.Recv: %v", err)
+	}
+	if err := revocations.Update(res.Crl, cl.ca); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !revocations.Revoked(parsed) {
+		t.Errorf("Certificate not revoked after RevokeCertificate")
+	}
+
+	list, err = mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+	if err != nil {
+		t.Fatalf("ListCertificates: %v", err)
+	}
+	if len(list.Certificates) != 1 || !list.Certificates[0].Revoked {
+		t.Errorf("ListCertificates returned %v, wanted revoked certificate", list.Certificates)
+	}
+
+	// No further certificates may be escrowed for the revoked key.
+	_, err = escrow("ci-bot")
+	if want, got := codes.PermissionDenied, status.Code(err); want != got {
+		t.Errorf("Escrow after revocation returned %v, wanted %s", err, want)
+	}
+	// Other identities bound to the same key are not affected.
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "other-bot",
+		PublicKey: userPub,
+		Roles:     []string{"viewer"},
+	}})
+	if err != nil {
+		t.Fatalf("PutRoleBinding: %v", err)
+	}
+	if _, err := escrow("other-bot"); err != nil {
+		t.Errorf("Escrow for other identity after revocation: %v", err)
+	}
+
+	// Records of expired certificates are removed, but their keys stay
+	// revoked.
+	n, err := certificatesRemoveExpired(ctx, cl.l, time.Now())
+	if err != nil {
+		t.Fatalf("certificatesRemoveExpired: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Removed %d unexpired certificates", n)
+	}
+	n, err = certificatesRemoveExpired(ctx, cl.l, time.Now().Add(2*identity.UserCertificateLifetime))
+	if err != nil {
+		t.Fatalf("certificatesRemoveExpired: %v", err)
+	}
+	if want := 2; n != want {
+		t.Errorf("Removed %d expired certificates, wanted %d", n, want)
+	}
+	list, err = mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+	if err != nil {
+		t.Fatalf("ListCertificates: %v", err)
+	}
+	if len(list.Certificates) != 0 {
+		t.Errorf("ListCertificates returned %v after removing expired certificates, wanted none", list.Certificates)
+	}
+	_, err = escrow("ci-bot")
+	if want, got := codes.PermissionDenied, status.Code(err); want != got {
+		t.Errorf("Escrow after removing expired certificates returned %v, wanted %s", err, want)
+	}
+}
+
+// TestRotateOwnerKey exercises replacing the owner key, which revokes the
+// certificates of the previous key.
+func TestRotateOwnerKey(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	oldPub, oldPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate owner keypair: %v", err)
+	}
+	newPub, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate owner keypair: %v", err)
+	}
+	iom, err := proto.Marshal(&ppb.InitialOwner{PublicKey: oldPub})
+	if err != nil {
+		t.Fatalf("could not marshal initial owner: %v", err)
+	}
+	if _, err := cl.l.etcd.Put(ctx, initialOwnerEtcdPath, string(iom)); err != nil {
+		t.Fatalf("could not set initial owner: %v", err)
+	}
+
+	escrow := func(name string, priv ed25519.PrivateKey) (*tls.Certificate, error) {
+		creds, err := rpc.NewEphemeralCredentials(priv, rpc.WantRemoteCluster(cl.ca))
+		if err != nil {
+			t.Fatalf("NewEphemeralCredentials: %v", err)
+		}
+		conn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+			return cl.curatorLis.Dial()
+		}), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("Creating GRPC client failed: %v", err)
+		}
+		defer conn.Close()
+		return rpc.RetrieveIdentityCertificate(ctx, apb.NewAAAClient(conn), name, priv)
+	}
+	cert, err := escrow("owner", oldPriv)
+	if err != nil {
+		t.Fatalf("Escrow: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse emitted certificate: %v", err)
+	}
+	serial := parsed.SerialNumber.Text(16)
+
+	// Certificates of the current owner key cannot be revoked, as this would
+	// lock out the owner.
+	_, err = mgmt.RevokeCertificate(ctx, &apb.RevokeCertificateRequest{SerialNumber: serial})
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Errorf("RevokeCertificate of owner certificate returned %v, wanted %s", err, want)
+	}
+
+	// Users which do not hold all permissions cannot become the owner.
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate user keypair: %v", err)
+	}
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "owner-rotator",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_ROTATE_OWNER_KEY},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	_, err = mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+		Identity:  "rotator",
+		PublicKey: userPub,
+		Roles:     []string{"owner-rotator"},
+	}})
+	if err != nil {
+		t.Fatalf("PutRoleBinding: %v", err)
+	}
+	userCert, err := escrow("rotator", userPriv)
+	if err != nil {
+		t.Fatalf("Escrow: %v", err)
+	}
+	userConn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+		return cl.curatorLis.Dial()
+	}), grpc.WithTransportCredentials(rpc.NewAuthenticatedCredentials(*userCert, rpc.WantRemoteCluster(cl.ca))))
+	if err != nil {
+		t.Fatalf("Creating GRPC client failed: %v", err)
+	}
+	defer userConn.Close()
+	_, err = apb.NewManagementClient(userConn).RotateOwnerKey(ctx, &apb.RotateOwnerKeyRequest{PublicKey: userPub})
+	if want, got := codes.PermissionDenied, status.Code(err); want != got {
+		t.Errorf("RotateOwnerKey by user returned %v, wanted %s", err, want)
+	}
+
+	for i, pk := range [][]byte{nil, oldPub[:16], oldPub} {
+		_, err := mgmt.RotateOwnerKey(ctx, &apb.RotateOwnerKeyRequest{PublicKey: pk})
+		if want, got := codes.InvalidArgument, status.Code(err); want != got {
+			t.Errorf("%d: RotateOwnerKey returned %v, wanted %s", i, err, want)
+		}
+	}
+	if _, err := mgmt.RotateOwnerKey(ctx, &apb.RotateOwnerKeyRequest{PublicKey: newPub}); err != nil {
+		t.Fatalf("RotateOwnerKey: %v", err)
+	}
+
+	// The certificate of the previous key is revoked, and no further
+	// certificates are escrowed for it.
+	list, err := mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+	if err != nil {
+		t.Fatalf("ListCertificates: %v", err)
+	}
+	for _, c := range list.Certificates {
+		if c.SerialNumber == serial && !c.Revoked {
+			t.Errorf("Certificate of previous owner key not revoked")
+		}
+	}
+	if _, err := escrow("owner", oldPriv); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Escrow with previous owner key returned %v, wanted %s", err, codes.PermissionDenied)
+	}
+	if _, err := escrow("owner", newPriv); err != nil {
+		t.Errorf("Escrow with new owner key: %v", err)
+	}
+
+	// The previous key cannot become the owner key again.
+	_, err = mgmt.RotateOwnerKey(ctx, &apb.RotateOwnerKeyRequest{PublicKey: oldPub})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("RotateOwnerKey to previous key returned %v, wanted %s", err, want)
+	}
+}
+
+// TestRoleBindingRemovalRevocation exercises revocation of certificates whose
+// role binding was deleted or bound to another key.
+func TestRoleBindingRemovalRevocation(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate user keypair: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate keypair: %v", err)
+	}
+	_, err = mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "viewer",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	bind := func(pub ed25519.PublicKey) {
+		t.Helper()
+		_, err := mgmt.PutRoleBinding(ctx, &apb.PutRoleBindingRequest{RoleBinding: &apb.RoleBinding{
+			Identity:  "ci-bot",
+			PublicKey: pub,
+			Roles:     []string{"viewer"},
+		}})
+		if err != nil {
+			t.Fatalf("PutRoleBinding: %v", err)
+		}
+	}
+	escrow := func() *x509.Certificate {
+		t.Helper()
+		creds, err := rpc.NewEphemeralCredentials(userPriv, rpc.WantRemoteCluster(cl.ca))
+		if err != nil {
+			t.Fatalf("NewEphemeralCredentials: %v", err)
+		}
+		conn, err := grpc.NewClient("passthrough:///local", grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+			return cl.curatorLis.Dial()
+		}), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("Creating GRPC client failed: %v", err)
+		}
+		defer conn.Close()
+		cert, err := rpc.RetrieveIdentityCertificate(ctx, apb.NewAAAClient(conn), "ci-bot", userPriv)
+		if err != nil {
+			t.Fatalf("Escrow: %v", err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("Could not parse emitted certificate: %v", err)
+		}
+		return parsed
+	}
+	expectRevoked := func(cert *x509.Certificate, want bool) {
+		t.Helper()
+		crl, err := pkiCA.LoadCRL(ctx, cl.l.etcd)
+		if err != nil {
+			t.Fatalf("LoadCRL: %v", err)
+		}
+		var revocations identity.Revocations
+		if err := revocations.Update(crl.Raw, cl.ca); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if got := revocations.Revoked(cert); got != want {
+			t.Errorf("Certificate %s revoked: %v, wanted %v", cert.SerialNumber.Text(16), got, want)
+		}
+		list, err := mgmt.ListCertificates(ctx, &apb.ListCertificatesRequest{})
+		if err != nil {
+			t.Fatalf("ListCertificates: %v", err)
+		}
+		for _, c := range list.Certificates {
+			if c.SerialNumber != cert.SerialNumber.Text(16) {
+				continue
+			}
+			if c.Revoked != want || c.BindingRemoved != want {
+				t.Errorf("ListCertificates returned %v, wanted revoked and binding_removed %v", c, want)
+			}
+			return
+		}
+		t.Errorf("Certificate %s not listed", cert.SerialNumber.Text(16))
+	}
+
+	// Deleting the binding revokes its certificates.
+	bind(userPub)
+	first := escrow()
+	expectRevoked(first, false)
+	if _, err := mgmt.DeleteRoleBinding(ctx, &apb.DeleteRoleBindingRequest{Identity: "ci-bot"}); err != nil {
+		t.Fatalf("DeleteRoleBinding: %v", err)
+	}
+	expectRevoked(first, true)
+
+	// Binding the same key again allows it to escrow new certificates.
+	bind(userPub)
+	second := escrow()
+	expectRevoked(second, false)
+
+	// Replacing the binding with the same key keeps its certificates valid,
+	// binding another key revokes them.
+	bind(userPub)
+	expectRevoked(second, false)
+	bind(otherPub)
+	expectRevoked(second, true)
+}
+
+// TestAuditLog exercises recording of mutating calls in the audit log, and
+// their retrieval via Management.GetAuditLog.
+func TestAuditLog(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	// Perform a non-mutating call, a successful mutating call and a failing
+	// mutating call.
+	if _, err := mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{}); err != nil {
+		t.Fatalf("GetClusterInfo: %v", err)
+	}
+	_, err := mgmt.PutRole(ctx, &apb.PutRoleRequest{Role: &apb.Role{
+		Name:        "viewer",
+		Permissions: []epb.Permission{epb.Permission_PERMISSION_READ_CLUSTER_STATUS},
+	}})
+	if err != nil {
+		t.Fatalf("PutRole: %v", err)
+	}
+	if _, err := mgmt.DeleteRole(ctx, &apb.DeleteRoleRequest{Name: "nonexistent"}); err == nil {
+		t.Fatalf("DeleteRole of nonexistent role succeeded")
+	}
+
+	getLog := func(req *apb.GetAuditLogRequest) []*apb.AuditEntry {
+		t.Helper()
+		srv, err := mgmt.GetAuditLog(ctx, req)
+		if err != nil {
+			t.Fatalf("GetAuditLog: %v", err)
+		}
+		var res []*apb.AuditEntry
+		for {
+			entry, err := srv.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("GetAuditLog.Recv: %v", err)
+			}
+			res = append(res, entry)
+		}
+		return res
+	}
+
+	entries := getLog(&apb.GetAuditLogRequest{})
+	if want, got := 2, len(entries); want != got {
+		t.Fatalf("Got %d audit entries, wanted %d: %v", got, want, entries)
+	}
+	for i, e := range []struct {
+		method string
+		code   codes.Code
+	}{
+		{"/metropolis.proto.api.Management/PutRole", codes.OK},
+		{"/metropolis.proto.api.Management/DeleteRole", codes.NotFound},
+	} {
+		entry := entries[i]
+		if entry.Identity != "owner" {
+			t.Errorf("Entry %d: identity is %q, wanted owner", i, entry.Identity)
+		}
+		if entry.Method != e.method {
+			t.Errorf("Entry %d: method is %q, wanted %q", i, entry.Method, e.method)
+		}
+		if codes.Code(entry.Code) != e.code {
+			t.Errorf("Entry %d: code is %s, wanted %s", i, codes.Code(entry.Code), e.code)
+		}
+	}
+	if !strings.Contains(entries[1].Request, "nonexistent") {
+		t.Errorf("Entry 1: request %q does not mention the role name", entries[1].Request)
+	}
+	if entries[0].Id >= entries[1].Id {
+		t.Errorf("Entry IDs not increasing: %d, %d", entries[0].Id, entries[1].Id)
+	}
+
+	// Filters and resumption should limit the returned entries.
+	entries = getLog(&apb.GetAuditLogRequest{Filter: "entry.code != 0"})
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Method, "/DeleteRole") {
+		t.Errorf("Filtered audit log is %v, wanted only DeleteRole", entries)
+	}
+	entries = getLog(&apb.GetAuditLogRequest{AfterId: entries[0].Id})
+	if len(entries) != 0 {
+		t.Errorf("Audit log after last entry is %v, wanted empty", entries)
+	}
+
+	// New entries should be streamed when following.
+	fctx, fctxC := context.WithCancel(ctx)
+	defer fctxC()
+	srv, err := mgmt.GetAuditLog(fctx, &apb.GetAuditLogRequest{
+		Filter: `entry.method.endsWith("/DeleteRoleBinding")`,
+		Follow: true,
+	})
+	if err != nil {
+		t.Fatalf("GetAuditLog: %v", err)
+	}
+	if _, err := mgmt.DeleteRoleBinding(ctx, &apb.DeleteRoleBindingRequest{Identity: "nobody"}); err == nil {
+		t.Fatalf("DeleteRoleBinding of nonexistent binding succeeded")
+	}
+	entry, err := srv.Recv()
+	if err != nil {
+		t.Fatalf("GetAuditLog.Recv: %v", err)
+	}
+	if !strings.HasSuffix(entry.Method, "/DeleteRoleBinding") {
+		t.Errorf("Followed entry has method %q, wanted DeleteRoleBinding", entry.Method)
+	}
+
+	// Nodes report calls to their NodeManagement service, which are recorded
+	// with the reporting node.
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+	_, err = cur.ReportAuditEntry(ctx, &ipb.ReportAuditEntryRequest{Entry: &apb.AuditEntry{
+		Identity: "owner",
+		Method:   "/metropolis.proto.api.NodeManagement/Reboot",
+	}})
+	if err != nil {
+		t.Fatalf("ReportAuditEntry: %v", err)
+	}
+	_, err = cur.ReportAuditEntry(ctx, &ipb.ReportAuditEntryRequest{Entry: &apb.AuditEntry{
+		Identity: "owner",
+		Method:   "/metropolis.proto.api.Management/DeleteNode",
+	}})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("ReportAuditEntry for Management method returned %v, wanted %s", err, want)
+	}
+	entries = getLog(&apb.GetAuditLogRequest{Filter: `entry.node != ""`})
+	if len(entries) != 1 {
+		t.Fatalf("Got audit entries %v, wanted only the reported one", entries)
+	}
+	if want, got := cl.localNodeID, entries[0].Node; want != got {
+		t.Errorf("Reported entry has node %q, wanted %q", got, want)
+	}
+	if entries[0].Time == nil || entries[0].Identity != "owner" {
+		t.Errorf("Reported entry %v is missing time or identity", entries[0])
+	}
+}
+
+// TestTakeSnapshot exercises Management.TakeSnapshot, ensuring that it returns
+// a parseable snapshot and that the call is recorded in the audit log.
+func TestTakeSnapshot(t *testing.T) {
+	cl := fakeLeader(t)
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	srv, err := mgmt.TakeSnapshot(ctx, &apb.TakeSnapshotRequest{})
+	if err != nil {
+		t.Fatalf("TakeSnapshot: %v", err)
+	}
+	var data []byte
+	for {
+		res, err := srv.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("TakeSnapshot.Recv: %v", err)
+		}
+		data = append(data, res.Data...)
+	}
+	snap, err := consensus.ParseSnapshot(data)
+	if err != nil {
+		t.Fatalf("ParseSnapshot: %v", err)
+	}
+	if snap.Len() == 0 {
+		t.Errorf("Snapshot is empty")
+	}
+
+	asrv, err := mgmt.GetAuditLog(ctx, &apb.GetAuditLogRequest{
+		Filter: `entry.method.endsWith("/TakeSnapshot")`,
+	})
+	if err != nil {
+		t.Fatalf("GetAuditLog: %v", err)
+	}
+	entry, err := asrv.Recv()
+	if err != nil {
+		t.Fatalf("GetAuditLog.Recv: %v", err)
+	}
+	if entry.Identity != "owner" || codes.Code(entry.Code) != codes.OK {
+		t.Errorf("Unexpected audit entry: %v", entry)
+	}
+}
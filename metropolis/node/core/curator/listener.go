@@ -49,6 +49,10 @@ type listener struct {
 
 	consensus consensus.ServiceHandle
 	status    *memory.Value[*electionStatus]
+	// backupPath is passed to the leader, see Config.BackupPath.
+	backupPath string
+	// revocations are used by the gRPC server, see Config.Revocations.
+	revocations *identity.Revocations
 }
 
 // run is the listener runnable. It listens on the Curator's gRPC socket, either
@@ -79,6 +83,7 @@ func (l *listener) run(ctx context.Context) error {
 
 	sec := rpc.ServerSecurity{
 		NodeCredentials: l.node,
+		Revocations:     l.revocations,
 	}
 
 	// Prepare a gRPC server and listener.
@@ -88,6 +93,22 @@ func (l *listener) run(ctx context.Context) error {
 		MinTime:             time.Second,
 		PermitWithoutStream: true,
 	}))
+
+	// When running as a leader, additionally record calls in the audit log.
+	var lead *leadership
+	if st.leader != nil {
+		lead = &leadership{
+			lockKey:         st.leader.lockKey,
+			lockRev:         st.leader.lockRev,
+			leaderID:        l.node.ID(),
+			etcd:            l.etcd,
+			consensusStatus: l.consensusStatus,
+			consensus:       l.consensus,
+			nodeCredentials: l.node,
+			backupPath:      l.backupPath,
+		}
+		opts = append(opts, rpc.AuditOptions(logger, lead.auditRecord)...)
+	}
 	srv := grpc.NewServer(opts...)
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", node.CuratorServicePort))
 	if err != nil {
@@ -102,14 +123,7 @@ func (l *listener) run(ctx context.Context) error {
 		supervisor.Logger(ctx).Infof("This curator is a leader.")
 
 		// Create a leader instance and serve it over gRPC.
-		leader := newCuratorLeader(&leadership{
-			lockKey:         st.leader.lockKey,
-			lockRev:         st.leader.lockRev,
-			leaderID:        l.node.ID(),
-			etcd:            l.etcd,
-			consensusStatus: l.consensusStatus,
-			consensus:       l.consensus,
-		}, &l.node.Node)
+		leader := newCuratorLeader(lead, &l.node.Node)
 
 		cpb.RegisterCuratorServer(srv, leader)
 		cpb.RegisterCuratorLocalServer(srv, leader)
@@ -0,0 +1,41 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MetricsRegistry is the metrics registry in which all curator metrics are
+// registered.
+var MetricsRegistry = prometheus.NewRegistry()
+var MetricsFactory = promauto.With(MetricsRegistry)
+
+var (
+	backupLastSuccess = MetricsFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "metropolis",
+		Subsystem: "curator",
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful consensus backup taken by this curator leader.",
+	})
+	backupLastSize = MetricsFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "metropolis",
+		Subsystem: "curator",
+		Name:      "backup_last_size_bytes",
+		Help:      "Size of the last successful consensus backup taken by this curator leader, after encryption.",
+	})
+	backupFailures = MetricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "metropolis",
+		Subsystem: "curator",
+		Name:      "backup_failures_total",
+		Help:      "Number of consensus backups which failed.",
+	})
+	auditFailures = MetricsFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "metropolis",
+		Subsystem: "curator",
+		Name:      "audit_failures_total",
+		Help:      "Number of audited calls which could not be recorded in the audit log.",
+	})
+)
@@ -49,6 +49,48 @@ func reconfigureCluster(base, new, existing *cpb.ClusterConfiguration, mask *fie
 		if err != nil {
 			return nil, err
 		}
+		if !handled {
+			handled, err = reconfigureOSImageSigningKeys(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureBackup(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureLogShipping(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureDNS(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureTPMAttestation(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureMeasuredBootPolicy(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !handled {
+			handled, err = reconfigureTime(base, new, existing, merged, path)
+			if err != nil {
+				return nil, err
+			}
+		}
 		if !handled {
 			return nil, status.Errorf(codes.InvalidArgument, "cannot modify %s", path)
 		}
@@ -99,3 +141,168 @@ func reconfigureKubernetes(base, new, existing, merged *cpb.ClusterConfiguration
 
 	return true, nil
 }
+
+// reconfigureOSImageSigningKeys does a three-way merge of the trusted OS image
+// signing keys (new, existing and optional base) into merged, if path refers to
+// them.
+//
+// An error is returned if the new keys are invalid or if base doesn't match
+// existing. Otherwise, a boolean value is returned, indicating whether this
+// given field path was handled.
+func reconfigureOSImageSigningKeys(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if path != "os_image_signing_keys" {
+		return false, nil
+	}
+	if base != nil && cmp.Diff(base.OsImageSigningKeys, existing.OsImageSigningKeys) != "" {
+		return false, status.Error(codes.FailedPrecondition, "base_config.os_image_signing_keys different from current value")
+	}
+	if err := validateOSImageSigningKeys(new.OsImageSigningKeys); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.OsImageSigningKeys = new.OsImageSigningKeys
+	return true, nil
+}
+
+// reconfigureBackup does a three-way merge of the backup configuration (new,
+// existing and optional base) into merged, if path refers to it. The backup
+// configuration is always replaced as a whole.
+//
+// An error is returned if the new configuration is invalid or if base doesn't
+// match existing. Otherwise, a boolean value is returned, indicating whether
+// this given field path was handled.
+func reconfigureBackup(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "backup.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate backup subfields, only backup as a whole")
+	}
+	if path != "backup" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.Backup, existing.Backup) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.backup different from current value")
+	}
+	if err := validateBackup(new.Backup); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.Backup = new.Backup
+	return true, nil
+}
+
+// reconfigureLogShipping does a three-way merge of the log shipping
+// configuration (new, existing and optional base) into merged, if path refers
+// to it. The log shipping configuration is always replaced as a whole.
+//
+// An error is returned if the new configuration is invalid or if base doesn't
+// match existing. Otherwise, a boolean value is returned, indicating whether
+// this given field path was handled.
+func reconfigureLogShipping(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "log_shipping.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate log_shipping subfields, only log_shipping as a whole")
+	}
+	if path != "log_shipping" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.LogShipping, existing.LogShipping) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.log_shipping different from current value")
+	}
+	if err := validateLogShipping(new.LogShipping); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.LogShipping = new.LogShipping
+	return true, nil
+}
+
+// reconfigureDNS does a three-way merge of the DNS configuration (new,
+// existing and optional base) into merged, if path refers to it. The DNS
+// configuration is always replaced as a whole.
+//
+// An error is returned if the new configuration is invalid or if base doesn't
+// match existing. Otherwise, a boolean value is returned, indicating whether
+// this given field path was handled.
+func reconfigureDNS(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "dns.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate dns subfields, only dns as a whole")
+	}
+	if path != "dns" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.Dns, existing.Dns) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.dns different from current value")
+	}
+	if err := validateDNS(new.Dns); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.Dns = new.Dns
+	return true, nil
+}
+
+// reconfigureTPMAttestation does a three-way merge of the TPM attestation
+// configuration (new, existing and optional base) into merged, if path refers
+// to it. The TPM attestation configuration is always replaced as a whole.
+//
+// An error is returned if the new configuration is invalid or if base doesn't
+// match existing. Otherwise, a boolean value is returned, indicating whether
+// this given field path was handled.
+func reconfigureTPMAttestation(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "tpm_attestation.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate tpm_attestation subfields, only tpm_attestation as a whole")
+	}
+	if path != "tpm_attestation" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.TpmAttestation, existing.TpmAttestation) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.tpm_attestation different from current value")
+	}
+	if err := validateTPMAttestation(new.TpmAttestation, existing.TpmMode); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.TpmAttestation = new.TpmAttestation
+	return true, nil
+}
+
+// reconfigureMeasuredBootPolicy does a three-way merge of the measured boot
+// policy (new, existing and optional base) into merged, if path refers to it.
+// The measured boot policy is always replaced as a whole.
+//
+// An error is returned if the new policy is invalid or if base doesn't match
+// existing. Otherwise, a boolean value is returned, indicating whether this
+// given field path was handled.
+func reconfigureMeasuredBootPolicy(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "measured_boot_policy.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate measured_boot_policy subfields, only measured_boot_policy as a whole")
+	}
+	if path != "measured_boot_policy" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.MeasuredBootPolicy, existing.MeasuredBootPolicy) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.measured_boot_policy different from current value")
+	}
+	if err := validateMeasuredBootPolicy(new.MeasuredBootPolicy); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.MeasuredBootPolicy = new.MeasuredBootPolicy
+	return true, nil
+}
+
+// reconfigureTime does a three-way merge of the time configuration (new,
+// existing and optional base) into merged, if path refers to it. The time
+// configuration is always replaced as a whole.
+//
+// An error is returned if the new configuration is invalid or if base doesn't
+// match existing. Otherwise, a boolean value is returned, indicating whether
+// this given field path was handled.
+func reconfigureTime(base, new, existing, merged *cpb.ClusterConfiguration, path string) (bool, error) {
+	if strings.HasPrefix(path, "time.") {
+		return false, status.Error(codes.InvalidArgument, "cannot mutate time subfields, only time as a whole")
+	}
+	if path != "time" {
+		return false, nil
+	}
+	if base != nil && !proto.Equal(base.Time, existing.Time) {
+		return false, status.Error(codes.FailedPrecondition, "base_config.time different from current value")
+	}
+	if err := validateTime(new.Time); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	merged.Time = new.Time
+	return true, nil
+}
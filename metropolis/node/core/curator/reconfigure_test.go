@@ -4,10 +4,15 @@
 package curator
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	cpb "source.monogon.dev/metropolis/proto/common"
@@ -28,6 +33,75 @@ func TestReconfigureCluster(t *testing.T) {
 		return res
 	}
 
+	signingPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := x509.MarshalPKIXPublicKey(signingPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSigningKeys := func(cfg *cpb.ClusterConfiguration, keys ...[]byte) *cpb.ClusterConfiguration {
+		cfg.OsImageSigningKeys = keys
+		return cfg
+	}
+	withBackup := func(cfg *cpb.ClusterConfiguration, interval time.Duration) *cpb.ClusterConfiguration {
+		cfg.Backup = &cpb.ClusterConfiguration_Backup{
+			Interval: durationpb.New(interval),
+			Destination: &cpb.ClusterConfiguration_Backup_Local_{
+				Local: &cpb.ClusterConfiguration_Backup_Local{},
+			},
+		}
+		return cfg
+	}
+	withSyslog := func(cfg *cpb.ClusterConfiguration, address string) *cpb.ClusterConfiguration {
+		cfg.LogShipping = &cpb.ClusterConfiguration_LogShipping{
+			Destination: &cpb.ClusterConfiguration_LogShipping_Syslog_{
+				Syslog: &cpb.ClusterConfiguration_LogShipping_Syslog{
+					Address: address,
+				},
+			},
+		}
+		return cfg
+	}
+	withDNSUpstream := func(cfg *cpb.ClusterConfiguration, protocol cpb.ClusterConfiguration_DNS_Upstream_Protocol, address string) *cpb.ClusterConfiguration {
+		cfg.Dns = &cpb.ClusterConfiguration_DNS{
+			Upstreams: []*cpb.ClusterConfiguration_DNS_Upstream{
+				{Protocol: protocol, Address: address, ServerName: "dns.example.com"},
+			},
+		}
+		return cfg
+	}
+
+	withTPMAttestation := func(cfg *cpb.ClusterConfiguration, required bool, pcr uint32, value []byte) *cpb.ClusterConfiguration {
+		cfg.TpmAttestation = &cpb.ClusterConfiguration_TPMAttestation{
+			Required: required,
+			AllowedPcrValues: []*cpb.ClusterConfiguration_TPMAttestation_PCRValues{
+				{Pcrs: map[uint32][]byte{pcr: value}},
+			},
+		}
+		return cfg
+	}
+	withTPMRequired := func(cfg *cpb.ClusterConfiguration) *cpb.ClusterConfiguration {
+		cfg.TpmMode = cpb.ClusterConfiguration_TPM_MODE_REQUIRED
+		return cfg
+	}
+	pcrValue := make([]byte, 32)
+	withMeasuredBootPolicy := func(cfg *cpb.ClusterConfiguration, digest []byte) *cpb.ClusterConfiguration {
+		cfg.MeasuredBootPolicy = &cpb.ClusterConfiguration_MeasuredBootPolicy{
+			AllowedBootApplications: [][]byte{digest},
+			RequireSecureBoot:       true,
+		}
+		return cfg
+	}
+	withTime := func(cfg *cpb.ClusterConfiguration, servers ...string) *cpb.ClusterConfiguration {
+		cfg.Time = &cpb.ClusterConfiguration_Time{
+			NtpServers:           servers,
+			ConsensusTimeServers: true,
+		}
+		return cfg
+	}
+
 	for i, te := range []struct {
 		base       *cpb.ClusterConfiguration
 		new        *cpb.ClusterConfiguration
@@ -144,6 +218,188 @@ func TestReconfigureCluster(t *testing.T) {
 			result:     &cpb.ClusterConfiguration{},
 			shouldFail: true,
 		},
+		// Case 11: set OS image signing keys.
+		{
+			base:     &cpb.ClusterConfiguration{},
+			new:      withSigningKeys(&cpb.ClusterConfiguration{}, signingKey),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"os_image_signing_keys"}},
+			result:   withSigningKeys(mkCfg("^foo$"), signingKey),
+		},
+		// Case 12: base OS image signing keys different from existing.
+		{
+			base:       &cpb.ClusterConfiguration{},
+			new:        &cpb.ClusterConfiguration{},
+			existing:   withSigningKeys(mkCfg("^foo$"), signingKey),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"os_image_signing_keys"}},
+			shouldFail: true,
+		},
+		// Case 13: invalid OS image signing key.
+		{
+			new:        withSigningKeys(&cpb.ClusterConfiguration{}, []byte("invalid")),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"os_image_signing_keys"}},
+			shouldFail: true,
+		},
+		// Case 14: enable backups.
+		{
+			base:     &cpb.ClusterConfiguration{},
+			new:      withBackup(&cpb.ClusterConfiguration{}, time.Hour),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"backup"}},
+			result:   withBackup(mkCfg("^foo$"), time.Hour),
+		},
+		// Case 15: disable backups.
+		{
+			base:     withBackup(&cpb.ClusterConfiguration{}, time.Hour),
+			new:      &cpb.ClusterConfiguration{},
+			existing: withBackup(mkCfg("^foo$"), time.Hour),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"backup"}},
+			result:   mkCfg("^foo$"),
+		},
+		// Case 16: base backup configuration different from existing.
+		{
+			base:       withBackup(&cpb.ClusterConfiguration{}, 2*time.Hour),
+			new:        &cpb.ClusterConfiguration{},
+			existing:   withBackup(mkCfg("^foo$"), time.Hour),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"backup"}},
+			shouldFail: true,
+		},
+		// Case 17: backup interval too short.
+		{
+			new:        withBackup(&cpb.ClusterConfiguration{}, time.Second),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"backup"}},
+			shouldFail: true,
+		},
+		// Case 18: backup subfields cannot be mutated.
+		{
+			new:        withBackup(&cpb.ClusterConfiguration{}, time.Hour),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"backup.interval"}},
+			shouldFail: true,
+		},
+		// Case 19: enable log shipping.
+		{
+			new:      withSyslog(&cpb.ClusterConfiguration{}, "syslog.example.com:6514"),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"log_shipping"}},
+			result:   withSyslog(mkCfg("^foo$"), "syslog.example.com:6514"),
+		},
+		// Case 20: base log shipping configuration different from existing.
+		{
+			base:       &cpb.ClusterConfiguration{},
+			new:        &cpb.ClusterConfiguration{},
+			existing:   withSyslog(mkCfg("^foo$"), "syslog.example.com:6514"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"log_shipping"}},
+			shouldFail: true,
+		},
+		// Case 21: syslog address without port.
+		{
+			new:        withSyslog(&cpb.ClusterConfiguration{}, "syslog.example.com"),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"log_shipping"}},
+			shouldFail: true,
+		},
+		// Case 22: configure an encrypted DNS upstream.
+		{
+			new:      withDNSUpstream(&cpb.ClusterConfiguration{}, cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS, "[2001:db8::1]:443"),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"dns"}},
+			result:   withDNSUpstream(mkCfg("^foo$"), cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS, "[2001:db8::1]:443"),
+		},
+		// Case 23: DNS upstream address is not an IP address.
+		{
+			new:        withDNSUpstream(&cpb.ClusterConfiguration{}, cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_TLS, "dns.example.com:853"),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"dns"}},
+			shouldFail: true,
+		},
+		// Case 24: DNS upstream without protocol.
+		{
+			new:        withDNSUpstream(&cpb.ClusterConfiguration{}, cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_INVALID, "192.0.2.1"),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"dns"}},
+			shouldFail: true,
+		},
+		// Case 25: recording TPM attestation against a PCR policy.
+		{
+			new:      withTPMAttestation(&cpb.ClusterConfiguration{}, false, 7, pcrValue),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+			result:   withTPMAttestation(mkCfg("^foo$"), false, 7, pcrValue),
+		},
+		// Case 26: requiring TPM attestation needs TPM mode REQUIRED.
+		{
+			new:        withTPMAttestation(&cpb.ClusterConfiguration{}, true, 7, pcrValue),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+			shouldFail: true,
+		},
+		// Case 27: requiring TPM attestation with TPM mode REQUIRED.
+		{
+			new:      withTPMAttestation(&cpb.ClusterConfiguration{}, true, 7, pcrValue),
+			existing: withTPMRequired(mkCfg("^foo$")),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+			result:   withTPMAttestation(withTPMRequired(mkCfg("^foo$")), true, 7, pcrValue),
+		},
+		// Case 28: PCR index out of range.
+		{
+			new:        withTPMAttestation(&cpb.ClusterConfiguration{}, false, 16, pcrValue),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+			shouldFail: true,
+		},
+		// Case 29: PCR value is not a SHA256 value.
+		{
+			new:        withTPMAttestation(&cpb.ClusterConfiguration{}, false, 7, pcrValue[:20]),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+			shouldFail: true,
+		},
+		// Case 30: setting a measured boot policy.
+		{
+			new:      withMeasuredBootPolicy(&cpb.ClusterConfiguration{}, pcrValue),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"measured_boot_policy"}},
+			result:   withMeasuredBootPolicy(mkCfg("^foo$"), pcrValue),
+		},
+		// Case 31: allowed boot application is not a SHA256 digest.
+		{
+			new:        withMeasuredBootPolicy(&cpb.ClusterConfiguration{}, pcrValue[:20]),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"measured_boot_policy"}},
+			shouldFail: true,
+		},
+		// Case 32: measured boot policy subfields cannot be mutated.
+		{
+			new:        withMeasuredBootPolicy(&cpb.ClusterConfiguration{}, pcrValue),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"measured_boot_policy.require_secure_boot"}},
+			shouldFail: true,
+		},
+		// Case 33: configuring NTP servers.
+		{
+			new:      withTime(&cpb.ClusterConfiguration{}, "ntp.example.com", "192.0.2.1"),
+			existing: mkCfg("^foo$"),
+			mask:     &fieldmaskpb.FieldMask{Paths: []string{"time"}},
+			result:   withTime(mkCfg("^foo$"), "ntp.example.com", "192.0.2.1"),
+		},
+		// Case 34: NTP server with port.
+		{
+			new:        withTime(&cpb.ClusterConfiguration{}, "ntp.example.com:123"),
+			existing:   mkCfg("^foo$"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"time"}},
+			shouldFail: true,
+		},
+		// Case 35: base time configuration different from existing.
+		{
+			base:       &cpb.ClusterConfiguration{},
+			new:        &cpb.ClusterConfiguration{},
+			existing:   withTime(mkCfg("^foo$"), "ntp.example.com"),
+			mask:       &fieldmaskpb.FieldMask{Paths: []string{"time"}},
+			shouldFail: true,
+		},
 	} {
 		{
 			got, err := reconfigureCluster(te.base, te.new, te.existing, te.mask)
@@ -0,0 +1,423 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+	epb "source.monogon.dev/metropolis/proto/ext"
+)
+
+const (
+	// roleEtcdPrefix is the etcd key prefix under which apb.Roles are stored,
+	// keyed by their name.
+	roleEtcdPrefix = "/aaa/roles/"
+	// roleBindingEtcdPrefix is the etcd key prefix under which apb.RoleBindings
+	// are stored, keyed by their identity.
+	roleBindingEtcdPrefix = "/aaa/bindings/"
+	// certificateEtcdPrefix is the etcd key prefix under which apb.Certificates
+	// issued by AAA.Escrow are stored, keyed by their serial number.
+	certificateEtcdPrefix = "/aaa/certificates/"
+	// revokedKeyEtcdPrefix is the etcd key prefix under which the
+	// apb.Certificates whose revocation revoked their public key are stored,
+	// keyed by their identity and hex-encoded public key. Unlike certificate
+	// records, these are never removed.
+	revokedKeyEtcdPrefix = "/aaa/revoked-keys/"
+
+	// certificateRetention is how long certificate records are kept after
+	// their certificate expired.
+	certificateRetention = 7 * 24 * time.Hour
+)
+
+var (
+	// reAccessName matches valid role names and role binding identities.
+	reAccessName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+	errRoleNotFound        = status.Error(codes.NotFound, "role not found")
+	errRoleBindingNotFound = status.Error(codes.NotFound, "role binding not found")
+	errCertificateNotFound = status.Error(codes.NotFound, "certificate not found")
+	errKeyNotRevoked       = status.Error(codes.NotFound, "public key not revoked")
+)
+
+// validateRole checks that a role received from a user is valid, returning a
+// gRPC status otherwise.
+func validateRole(r *apb.Role) error {
+	if r == nil {
+		return status.Error(codes.InvalidArgument, "role must be set")
+	}
+	if !reAccessName.MatchString(r.Name) {
+		return status.Errorf(codes.InvalidArgument, "invalid role name %q", r.Name)
+	}
+	for _, p := range r.Permissions {
+		switch p {
+		case epb.Permission_PERMISSION_UNSPECIFIED:
+			return status.Error(codes.InvalidArgument, "role contains unspecified permission")
+		case epb.Permission_PERMISSION_UPDATE_NODE_SELF:
+			return status.Errorf(codes.InvalidArgument, "%s can only be granted to nodes", p)
+		}
+		if _, ok := epb.Permission_name[int32(p)]; !ok {
+			return status.Errorf(codes.InvalidArgument, "unknown permission %d", p)
+		}
+	}
+	return nil
+}
+
+// validateRoleBinding checks that a role binding received from a user is
+// valid, returning a gRPC status otherwise. It does not check that the bound
+// roles exist.
+func validateRoleBinding(b *apb.RoleBinding) error {
+	if b == nil {
+		return status.Error(codes.InvalidArgument, "role_binding must be set")
+	}
+	if b.Identity == "owner" {
+		return status.Error(codes.InvalidArgument, "the owner identity cannot be bound to roles")
+	}
+	if !reAccessName.MatchString(b.Identity) {
+		return status.Errorf(codes.InvalidArgument, "invalid identity %q", b.Identity)
+	}
+	if len(b.PublicKey) != ed25519.PublicKeySize {
+		return status.Error(codes.InvalidArgument, "public_key must be an Ed25519 public key")
+	}
+	seen := make(map[string]bool)
+	for _, r := range b.Roles {
+		if !reAccessName.MatchString(r) {
+			return status.Errorf(codes.InvalidArgument, "invalid role name %q", r)
+		}
+		if seen[r] {
+			return status.Errorf(codes.InvalidArgument, "duplicate role %q", r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// accessLoad loads a single access object (role or role binding) stored under
+// key into msg, within a given active leadership. If the key does not exist,
+// notFound is returned. All returned errors are gRPC statuses that are safe to
+// return to untrusted callers.
+func accessLoad(ctx context.Context, l *leadership, key string, msg proto.Message, notFound error) error {
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(key))
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not retrieve %s: %v", key, err)
+		return status.Errorf(codes.Unavailable, "could not retrieve access data: %v", err)
+	}
+	kvs := res.Responses[0].GetResponseRange().Kvs
+	if len(kvs) != 1 {
+		return notFound
+	}
+	if err := proto.Unmarshal(kvs[0].Value, msg); err != nil {
+		rpc.Trace(ctx).Printf("could not unmarshal %s: %v", key, err)
+		return status.Errorf(codes.Unavailable, "could not unmarshal access data")
+	}
+	return nil
+}
+
+// accessList calls fn with the raw value of every access object stored under
+// the given prefix, within a given active leadership, in key order.
+func accessList(ctx context.Context, l *leadership, prefix string, fn func(value []byte) error) error {
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)))
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not list %s: %v", prefix, err)
+		return status.Errorf(codes.Unavailable, "could not list access data: %v", err)
+	}
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		if err := fn(kv.Value); err != nil {
+			rpc.Trace(ctx).Printf("could not unmarshal %s: %v", kv.Key, err)
+			return status.Errorf(codes.Unavailable, "could not unmarshal access data")
+		}
+	}
+	return nil
+}
+
+// accessSave saves the given access object under key, or deletes the key if
+// msg is nil, within a given active leadership.
+func accessSave(ctx context.Context, l *leadership, key string, msg proto.Message) error {
+	op := clientv3.OpDelete(key)
+	if msg != nil {
+		bytes, err := proto.Marshal(msg)
+		if err != nil {
+			rpc.Trace(ctx).Printf("could not marshal %s: %v", key, err)
+			return status.Errorf(codes.Unavailable, "could not marshal access data")
+		}
+		op = clientv3.OpPut(key, string(bytes))
+	}
+	if _, err := l.txnAsLeader(ctx, op); err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not save %s: %v", key, err)
+		return status.Error(codes.Unavailable, "could not save access data")
+	}
+	return nil
+}
+
+func roleLoad(ctx context.Context, l *leadership, name string) (*apb.Role, error) {
+	var r apb.Role
+	if err := accessLoad(ctx, l, roleEtcdPrefix+name, &r, errRoleNotFound); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func rolesList(ctx context.Context, l *leadership) ([]*apb.Role, error) {
+	var res []*apb.Role
+	err := accessList(ctx, l, roleEtcdPrefix, func(value []byte) error {
+		var r apb.Role
+		if err := proto.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		res = append(res, &r)
+		return nil
+	})
+	return res, err
+}
+
+func roleBindingLoad(ctx context.Context, l *leadership, identity string) (*apb.RoleBinding, error) {
+	var b apb.RoleBinding
+	if err := accessLoad(ctx, l, roleBindingEtcdPrefix+identity, &b, errRoleBindingNotFound); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func roleBindingsList(ctx context.Context, l *leadership) ([]*apb.RoleBinding, error) {
+	var res []*apb.RoleBinding
+	err := accessList(ctx, l, roleBindingEtcdPrefix, func(value []byte) error {
+		var b apb.RoleBinding
+		if err := proto.Unmarshal(value, &b); err != nil {
+			return err
+		}
+		res = append(res, &b)
+		return nil
+	})
+	return res, err
+}
+
+// roleBindingPermissions returns the names of all permissions granted by the
+// roles of a role binding, without duplicates. Roles which don't exist
+// (anymore) grant no permissions.
+func roleBindingPermissions(ctx context.Context, l *leadership, b *apb.RoleBinding) ([]string, error) {
+	var res []string
+	seen := make(map[epb.Permission]bool)
+	for _, name := range b.Roles {
+		r, err := roleLoad(ctx, l, name)
+		if err == errRoleNotFound {
+			rpc.Trace(ctx).Printf("role %q of identity %q not found, skipping", name, b.Identity)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range r.Permissions {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			res = append(res, p.String())
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no permissions granted")
+	}
+	return res, nil
+}
+
+// certificateRecord returns the apb.Certificate recorded for a user
+// certificate. Its revocation status is not stored, but retrieved from the
+// CRL of the cluster CA when listing certificates.
+func certificateRecord(cert *x509.Certificate) *apb.Certificate {
+	return &apb.Certificate{
+		SerialNumber: cert.SerialNumber.Text(16),
+		Identity:     cert.Subject.CommonName,
+		PublicKey:    cert.PublicKey.(ed25519.PublicKey),
+		NotBefore:    timestamppb.New(cert.NotBefore),
+		NotAfter:     timestamppb.New(cert.NotAfter),
+		Certificate:  cert.Raw,
+	}
+}
+
+func certificateSave(ctx context.Context, l *leadership, cert *x509.Certificate) error {
+	return certificateRecordSave(ctx, l, certificateRecord(cert))
+}
+
+func certificateRecordSave(ctx context.Context, l *leadership, c *apb.Certificate) error {
+	return certificateRecordSaveAt(ctx, l, certificateEtcdPrefix+c.SerialNumber, c)
+}
+
+func certificateRecordSaveAt(ctx context.Context, l *leadership, key string, c *apb.Certificate) error {
+	// The revoked field is retrieved from the CRL, don't store it.
+	c = proto.Clone(c).(*apb.Certificate)
+	c.Revoked = false
+	return accessSave(ctx, l, key, c)
+}
+
+func certificateLoad(ctx context.Context, l *leadership, serial *big.Int) (*apb.Certificate, error) {
+	var c apb.Certificate
+	if err := accessLoad(ctx, l, certificateEtcdPrefix+serial.Text(16), &c, errCertificateNotFound); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// certificatesList returns all recorded user certificates, with their revoked
+// field set according to the current CRL of the cluster CA.
+func certificatesList(ctx context.Context, l *leadership) ([]*apb.Certificate, error) {
+	var res []*apb.Certificate
+	err := accessList(ctx, l, certificateEtcdPrefix, func(value []byte) error {
+		var c apb.Certificate
+		if err := proto.Unmarshal(value, &c); err != nil {
+			return err
+		}
+		res = append(res, &c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := pkiCA.LoadCRL(ctx, l.etcd)
+	if err != nil {
+		rpc.Trace(ctx).Printf("could not load CRL: %v", err)
+		return nil, status.Error(codes.Unavailable, "could not load CRL")
+	}
+	revoked := make(map[string]bool)
+	for _, rc := range crl.List.TBSCertList.RevokedCertificates {
+		revoked[rc.SerialNumber.Text(16)] = true
+	}
+	for _, c := range res {
+		c.Revoked = revoked[c.SerialNumber]
+	}
+	return res, nil
+}
+
+// certificatesRemoveExpired removes the records of all certificates which
+// expired before the given time, and returns how many were removed. Public
+// keys revoked with their certificates stay revoked. It must be called with
+// muAccess held.
+func certificatesRemoveExpired(ctx context.Context, l *leadership, before time.Time) (int, error) {
+	var expired []string
+	err := accessList(ctx, l, certificateEtcdPrefix, func(value []byte) error {
+		var c apb.Certificate
+		if err := proto.Unmarshal(value, &c); err != nil {
+			return err
+		}
+		if c.NotAfter.AsTime().Before(before) {
+			expired = append(expired, c.SerialNumber)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, serial := range expired {
+		if err := accessSave(ctx, l, certificateEtcdPrefix+serial, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+func revokedKeyPath(name string, pk ed25519.PublicKey) string {
+	return revokedKeyEtcdPrefix + name + "/" + hex.EncodeToString(pk)
+}
+
+// revokedKeySave records that the identity and public key of the given
+// certificate have been revoked with it.
+func revokedKeySave(ctx context.Context, l *leadership, c *apb.Certificate) error {
+	return certificateRecordSaveAt(ctx, l, revokedKeyPath(c.Identity, c.PublicKey), c)
+}
+
+// publicKeyRevoked returns whether a certificate issued for the given identity
+// and public key has been revoked with RevokeCertificate or RotateOwnerKey.
+// Certificates revoked because their role binding was removed are ignored, so
+// that the same key can be bound again.
+func publicKeyRevoked(ctx context.Context, l *leadership, name string, pk ed25519.PublicKey) (bool, error) {
+	var c apb.Certificate
+	err := accessLoad(ctx, l, revokedKeyPath(name, pk), &c, errKeyNotRevoked)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, errKeyNotRevoked):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// revokeBindingCertificates revokes all unexpired certificates recorded for
+// the given identity, as the role binding they were escrowed under has been
+// removed. It must be called with muAccess held.
+func revokeBindingCertificates(ctx context.Context, l *leadership, name string) error {
+	return revokeIdentityCertificates(ctx, l, name, true)
+}
+
+// revokeIdentityCertificates revokes all unexpired certificates recorded for
+// the given identity. If bindingRemoved is set, the certificates are marked as
+// revoked because of a removed role binding, which lets their keys be bound
+// again. Otherwise, their keys are considered revoked, as if revoked with
+// RevokeCertificate. It must be called with muAccess held.
+func revokeIdentityCertificates(ctx context.Context, l *leadership, name string, bindingRemoved bool) error {
+	certs, err := certificatesList(ctx, l)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var revoke []*apb.Certificate
+	var serials []*big.Int
+	for _, c := range certs {
+		if c.Identity != name || c.Revoked || now.After(c.NotAfter.AsTime()) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(c.SerialNumber, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial number %q in certificate record", c.SerialNumber)
+		}
+		revoke = append(revoke, c)
+		serials = append(serials, serial)
+	}
+	if len(revoke) == 0 {
+		return nil
+	}
+	// Mark the records first, so that a failure to update the CRL does not
+	// leave behind certificates which block their key from being bound again,
+	// or keys which are not considered revoked.
+	for _, c := range revoke {
+		if bindingRemoved {
+			c.BindingRemoved = true
+			if err := certificateRecordSave(ctx, l, c); err != nil {
+				return err
+			}
+		} else if err := revokedKeySave(ctx, l, c); err != nil {
+			return err
+		}
+	}
+	if err := pkiCA.RevokeSerial(ctx, l.etcd, serials...); err != nil {
+		rpc.Trace(ctx).Printf("could not revoke certificates: %v", err)
+		return status.Error(codes.Unavailable, "could not revoke certificates")
+	}
+	rpc.Trace(ctx).Printf("Revoked %d certificates of %q", len(revoke), name)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+const (
+	// auditEtcdPrefix is the etcd key prefix under which apb.AuditEntries are
+	// stored, keyed by their zero-padded ID so that key order matches ID order.
+	auditEtcdPrefix = "/audit/"
+
+	// auditRetention is how long audit entries are kept in etcd. Older entries
+	// are removed whenever a new entry is appended.
+	auditRetention = 90 * 24 * time.Hour
+)
+
+// auditKey returns the etcd key of the audit entry with the given ID.
+func auditKey(id uint64) string {
+	return fmt.Sprintf("%s%020d", auditEtcdPrefix, id)
+}
+
+// auditIDFromKey parses the ID of an audit entry from its etcd key.
+func auditIDFromKey(key []byte) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(string(key), auditEtcdPrefix), 10, 64)
+}
+
+// auditAppend assigns an ID to the given entry and appends it to the audit log,
+// within a given active leadership. Audit entry IDs are nanosecond timestamps
+// of the time at which they were appended, made strictly monotonic across
+// leaders by never going below the last ID stored in etcd. All returned errors
+// are gRPC statuses that are safe to return to untrusted callers.
+func auditAppend(ctx context.Context, l *leadership, entry *apb.AuditEntry) error {
+	l.muAudit.Lock()
+	defer l.muAudit.Unlock()
+
+	// Retrieve the last ID from etcd when appending the first entry as this
+	// leader.
+	if l.ls.auditLastID == 0 {
+		res, err := l.txnAsLeader(ctx, clientv3.OpGet(auditEtcdPrefix, clientv3.WithLastKey()...))
+		if err != nil {
+			if rpcErr, ok := rpcError(err); ok {
+				return rpcErr
+			}
+			rpc.Trace(ctx).Printf("could not retrieve last audit entry: %v", err)
+			return status.Error(codes.Unavailable, "could not retrieve last audit entry")
+		}
+		if kvs := res.Responses[0].GetResponseRange().Kvs; len(kvs) == 1 {
+			id, err := auditIDFromKey(kvs[0].Key)
+			if err != nil {
+				rpc.Trace(ctx).Printf("invalid audit entry key %q: %v", kvs[0].Key, err)
+				return status.Error(codes.Unavailable, "invalid audit entry key")
+			}
+			l.ls.auditLastID = id
+		}
+	}
+
+	now := time.Now()
+	id := uint64(now.UnixNano())
+	if id <= l.ls.auditLastID {
+		id = l.ls.auditLastID + 1
+	}
+	entry.Id = id
+
+	bytes, err := proto.Marshal(entry)
+	if err != nil {
+		rpc.Trace(ctx).Printf("could not marshal audit entry: %v", err)
+		return status.Error(codes.Unavailable, "could not marshal audit entry")
+	}
+	cutoff := uint64(now.Add(-auditRetention).UnixNano())
+	_, err = l.txnAsLeader(ctx,
+		clientv3.OpPut(auditKey(id), string(bytes)),
+		clientv3.OpDelete(auditKey(0), clientv3.WithRange(auditKey(cutoff))),
+	)
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not save audit entry: %v", err)
+		return status.Error(codes.Unavailable, "could not save audit entry")
+	}
+	l.ls.auditLastID = id
+	return nil
+}
@@ -5,7 +5,14 @@ package curator
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc/codes"
@@ -14,6 +21,9 @@ import (
 
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/osbase/oci/registry"
+	"source.monogon.dev/osbase/oci/signature"
 
 	cpb "source.monogon.dev/metropolis/proto/common"
 )
@@ -29,6 +39,28 @@ type Cluster struct {
 	TPMMode                             cpb.ClusterConfiguration_TPMMode
 	StorageSecurityPolicy               cpb.ClusterConfiguration_StorageSecurityPolicy
 	NodeLabelsToSynchronizeToKubernetes []*cpb.ClusterConfiguration_Kubernetes_NodeLabelsToSynchronize
+	// OSImageSigningKeys are the DER-encoded public keys trusted to sign OS
+	// images. If empty, OS images don't need to be signed.
+	OSImageSigningKeys [][]byte
+	// Backup configures periodic backups of the consensus database. If nil,
+	// backups are disabled.
+	Backup *cpb.ClusterConfiguration_Backup
+	// LogShipping configures shipping of all nodes' logs to a collector. If
+	// nil, logs are not shipped.
+	LogShipping *cpb.ClusterConfiguration_LogShipping
+	// DNS configures the DNS forwarder of all nodes. If nil, nodes use the
+	// DNS servers from their network configuration.
+	DNS *cpb.ClusterConfiguration_DNS
+	// TPMAttestation configures remote attestation of nodes by their TPM. If
+	// nil, nodes are attested, but the outcome is only recorded.
+	TPMAttestation *cpb.ClusterConfiguration_TPMAttestation
+	// MeasuredBootPolicy configures the verification of the measured boot
+	// event logs reported by nodes. If nil, event logs are only verified
+	// against the nodes' PCRs.
+	MeasuredBootPolicy *cpb.ClusterConfiguration_MeasuredBootPolicy
+	// Time configures the time synchronization of all nodes. If nil, nodes
+	// synchronize with the public NTP pool.
+	Time *cpb.ClusterConfiguration_Time
 }
 
 // DefaultClusterConfiguration is the default cluster configuration for a newly
@@ -176,10 +208,39 @@ func clusterFromProto(cc *cpb.ClusterConfiguration) (*Cluster, error) {
 		return nil, fmt.Errorf("invalid StorageSecurityPolicy: %v", cc.StorageSecurityPolicy)
 	}
 
+	if err := validateOSImageSigningKeys(cc.OsImageSigningKeys); err != nil {
+		return nil, err
+	}
+	if err := validateBackup(cc.Backup); err != nil {
+		return nil, err
+	}
+	if err := validateLogShipping(cc.LogShipping); err != nil {
+		return nil, err
+	}
+	if err := validateDNS(cc.Dns); err != nil {
+		return nil, err
+	}
+	if err := validateTPMAttestation(cc.TpmAttestation, cc.TpmMode); err != nil {
+		return nil, err
+	}
+	if err := validateMeasuredBootPolicy(cc.MeasuredBootPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateTime(cc.Time); err != nil {
+		return nil, err
+	}
+
 	c := &Cluster{
 		ClusterDomain:         cc.ClusterDomain,
 		TPMMode:               cc.TpmMode,
 		StorageSecurityPolicy: cc.StorageSecurityPolicy,
+		OSImageSigningKeys:    cc.OsImageSigningKeys,
+		Backup:                cc.Backup,
+		LogShipping:           cc.LogShipping,
+		DNS:                   cc.Dns,
+		TPMAttestation:        cc.TpmAttestation,
+		MeasuredBootPolicy:    cc.MeasuredBootPolicy,
+		Time:                  cc.Time,
 	}
 	if kc := cc.Kubernetes; kc != nil {
 		c.NodeLabelsToSynchronizeToKubernetes = kc.NodeLabelsToSynchronize
@@ -206,6 +267,25 @@ func (c *Cluster) proto() (*cpb.ClusterConfiguration, error) {
 		return nil, fmt.Errorf("invalid StorageSecurityPolicy %d", c.StorageSecurityPolicy)
 	}
 
+	if err := validateBackup(c.Backup); err != nil {
+		return nil, err
+	}
+	if err := validateLogShipping(c.LogShipping); err != nil {
+		return nil, err
+	}
+	if err := validateDNS(c.DNS); err != nil {
+		return nil, err
+	}
+	if err := validateTPMAttestation(c.TPMAttestation, c.TPMMode); err != nil {
+		return nil, err
+	}
+	if err := validateMeasuredBootPolicy(c.MeasuredBootPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateTime(c.Time); err != nil {
+		return nil, err
+	}
+
 	return &cpb.ClusterConfiguration{
 		ClusterDomain:         c.ClusterDomain,
 		TpmMode:               c.TPMMode,
@@ -213,9 +293,171 @@ func (c *Cluster) proto() (*cpb.ClusterConfiguration, error) {
 		Kubernetes: &cpb.ClusterConfiguration_Kubernetes{
 			NodeLabelsToSynchronize: c.NodeLabelsToSynchronizeToKubernetes,
 		},
+		OsImageSigningKeys: c.OSImageSigningKeys,
+		Backup:             c.Backup,
+		LogShipping:        c.LogShipping,
+		Dns:                c.DNS,
+		TpmAttestation:     c.TPMAttestation,
+		MeasuredBootPolicy: c.MeasuredBootPolicy,
+		Time:               c.Time,
 	}, nil
 }
 
+// validateOSImageSigningKeys checks that all given OS image signing keys can
+// be used to verify OS image signatures.
+func validateOSImageSigningKeys(keys [][]byte) error {
+	for i, key := range keys {
+		if _, err := signature.ParsePublicKey(key); err != nil {
+			return fmt.Errorf("invalid OSImageSigningKeys[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateBackup checks that the given backup configuration is either disabled
+// or complete.
+func validateBackup(b *cpb.ClusterConfiguration_Backup) error {
+	if b == nil || b.Interval == nil {
+		return nil
+	}
+	if err := b.Interval.CheckValid(); err != nil {
+		return fmt.Errorf("invalid Backup.Interval: %w", err)
+	}
+	if b.Interval.AsDuration() < time.Minute {
+		return fmt.Errorf("invalid Backup.Interval: must be at least one minute")
+	}
+	switch dst := b.Destination.(type) {
+	case *cpb.ClusterConfiguration_Backup_Oci:
+		if dst.Oci.Scheme != "https" && dst.Oci.Scheme != "http" {
+			return fmt.Errorf("invalid Backup.Oci.Scheme %q", dst.Oci.Scheme)
+		}
+		if dst.Oci.Host == "" {
+			return fmt.Errorf("invalid Backup.Oci.Host: must be set")
+		}
+		if !registry.RepositoryRegexp.MatchString(dst.Oci.Repository) {
+			return fmt.Errorf("invalid Backup.Oci.Repository %q", dst.Oci.Repository)
+		}
+	case *cpb.ClusterConfiguration_Backup_Local_:
+	default:
+		return fmt.Errorf("invalid Backup.Destination: must be set")
+	}
+	return nil
+}
+
+// validateLogShipping checks that the given log shipping configuration is
+// either disabled or complete.
+func validateLogShipping(ls *cpb.ClusterConfiguration_LogShipping) error {
+	if ls == nil || ls.Destination == nil {
+		return nil
+	}
+	if _, err := logtree.DN(ls.Dn).Path(); err != nil {
+		return fmt.Errorf("invalid LogShipping.Dn %q: %w", ls.Dn, err)
+	}
+	switch dst := ls.Destination.(type) {
+	case *cpb.ClusterConfiguration_LogShipping_Syslog_:
+		if _, _, err := net.SplitHostPort(dst.Syslog.Address); err != nil {
+			return fmt.Errorf("invalid LogShipping.Syslog.Address %q: %w", dst.Syslog.Address, err)
+		}
+	case *cpb.ClusterConfiguration_LogShipping_Otlp:
+		u, err := url.Parse(dst.Otlp.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid LogShipping.Otlp.Endpoint: %w", err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid LogShipping.Otlp.Endpoint %q: must be an http or https URL", dst.Otlp.Endpoint)
+		}
+	}
+	return nil
+}
+
+// validateDNS checks that all encrypted upstream DNS servers in the given DNS
+// configuration are complete.
+func validateDNS(d *cpb.ClusterConfiguration_DNS) error {
+	for i, u := range d.GetUpstreams() {
+		switch u.Protocol {
+		case cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_TLS:
+			if u.Path != "" {
+				return fmt.Errorf("invalid DNS.Upstreams[%d].Path: only valid for HTTPS", i)
+			}
+		case cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS:
+			if u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+				return fmt.Errorf("invalid DNS.Upstreams[%d].Path %q: must start with /", i, u.Path)
+			}
+		default:
+			return fmt.Errorf("invalid DNS.Upstreams[%d].Protocol: must be set", i)
+		}
+		host, port, err := net.SplitHostPort(u.Address)
+		if err != nil {
+			// No port given, the IP address might still be in brackets.
+			host, port = strings.TrimSuffix(strings.TrimPrefix(u.Address, "["), "]"), ""
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid DNS.Upstreams[%d].Address %q: must be an IP address with optional port", i, u.Address)
+		}
+		if port != "" {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return fmt.Errorf("invalid DNS.Upstreams[%d].Address %q: invalid port", i, u.Address)
+			}
+		}
+		for j, ca := range u.CaCertificates {
+			if _, err := x509.ParseCertificate(ca); err != nil {
+				return fmt.Errorf("invalid DNS.Upstreams[%d].CaCertificates[%d]: %w", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTPMAttestation checks that the given TPM attestation configuration
+// can be enforced with the given TPM mode, that all expected PCR values are
+// SHA256 values of SRTM PCRs, and that all EK CA certificates are valid.
+func validateTPMAttestation(a *cpb.ClusterConfiguration_TPMAttestation, mode cpb.ClusterConfiguration_TPMMode) error {
+	if a.GetRequired() && mode != cpb.ClusterConfiguration_TPM_MODE_REQUIRED {
+		return fmt.Errorf("invalid TPMAttestation.Required: requires TpmMode REQUIRED")
+	}
+	for i, values := range a.GetAllowedPcrValues() {
+		if len(values.Pcrs) == 0 {
+			return fmt.Errorf("invalid TPMAttestation.AllowedPcrValues[%d]: must not be empty", i)
+		}
+		for index, value := range values.Pcrs {
+			if index >= attestationPCRs {
+				return fmt.Errorf("invalid TPMAttestation.AllowedPcrValues[%d]: PCR %d out of range", i, index)
+			}
+			if len(value) != sha256.Size {
+				return fmt.Errorf("invalid TPMAttestation.AllowedPcrValues[%d]: PCR %d must be %d bytes long", i, index, sha256.Size)
+			}
+		}
+	}
+	for i, der := range a.GetEkCaCertificates() {
+		if _, err := x509.ParseCertificate(der); err != nil {
+			return fmt.Errorf("invalid TPMAttestation.EkCaCertificates[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateMeasuredBootPolicy checks that all allowed boot applications of the
+// given measured boot policy are SHA256 digests.
+func validateMeasuredBootPolicy(p *cpb.ClusterConfiguration_MeasuredBootPolicy) error {
+	for i, digest := range p.GetAllowedBootApplications() {
+		if len(digest) != sha256.Size {
+			return fmt.Errorf("invalid MeasuredBootPolicy.AllowedBootApplications[%d]: must be %d bytes long", i, sha256.Size)
+		}
+	}
+	return nil
+}
+
+// validateTime checks that all NTP servers of the given time configuration are
+// IP addresses or domain names.
+func validateTime(t *cpb.ClusterConfiguration_Time) error {
+	for i, server := range t.GetNtpServers() {
+		if err := common.ValidateNTPServer(server); err != nil {
+			return fmt.Errorf("invalid Time.NtpServers[%d] %q: %w", i, server, err)
+		}
+	}
+	return nil
+}
+
 func clusterLoad(ctx context.Context, l *leadership) (*Cluster, error) {
 	rpc.Trace(ctx).Printf("loadCluster...")
 	res, err := l.txnAsLeader(ctx, clientv3.OpGet(clusterConfigurationKey))
@@ -23,6 +23,7 @@ import (
 	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/osbase/pki"
 
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 	ppb "source.monogon.dev/metropolis/node/core/curator/proto/private"
 	cpb "source.monogon.dev/metropolis/proto/common"
 )
@@ -68,6 +69,8 @@ type Node struct {
 	state cpb.NodeState
 
 	status *cpb.NodeStatus
+	// updateStatus is reported by the node along with its status.
+	updateStatus *ipb.NodeUpdateStatus
 
 	tpmUsage cpb.NodeTPMUsage
 
@@ -95,6 +98,26 @@ type Node struct {
 	networkPrefixes []netip.Prefix
 
 	labels map[string]string
+
+	// update, if set, is the OS update that this node has been requested to
+	// perform by the rollout logic in the curator leader.
+	update *ipb.NodeUpdate
+
+	// ekPub is the DER-encoded PKIX public key of the endorsement key of the
+	// node's TPM, or nil if the node did not pass attestation when registering
+	// and none was set by Management.SetNodeEndorsementKey since. Once set,
+	// the node can only pass attestation with this TPM.
+	ekPub []byte
+	// attestation is the outcome of the last attestation of the node, or nil
+	// if the node was never attested.
+	attestation *cpb.NodeAttestation
+	// measuredBoot is the outcome of the verification of the measured boot
+	// event log last reported by the node, or nil if the node never reported
+	// one.
+	measuredBoot *cpb.NodeMeasuredBoot
+	// timeConfiguration, if set, overrides the time configuration of the
+	// cluster for this node.
+	timeConfiguration *cpb.NodeTimeConfiguration
 }
 
 type NewNodeData struct {
@@ -104,6 +127,9 @@ type NewNodeData struct {
 	JPub     []byte
 	TPMUsage cpb.NodeTPMUsage
 	Labels   map[string]string
+	// EKPub is the DER-encoded PKIX public key of the endorsement key of the
+	// node's TPM, if any. It is trusted without attestation.
+	EKPub []byte
 }
 
 // NewNodeForBootstrap creates a brand new node without regard for any other
@@ -119,6 +145,7 @@ func NewNodeForBootstrap(n *NewNodeData) Node {
 		state:            cpb.NodeState_NODE_STATE_UP,
 		tpmUsage:         n.TPMUsage,
 		labels:           n.Labels,
+		ekPub:            n.EKPub,
 	}
 }
 
@@ -247,15 +274,21 @@ func (n *Node) etcdJoinKeyPath() (string, error) {
 // etcd.
 func (n *Node) proto() *ppb.Node {
 	msg := &ppb.Node{
-		Id:               n.id,
-		ClusterUnlockKey: n.clusterUnlockKey,
-		PublicKey:        n.pubkey,
-		JoinKey:          n.jkey,
-		FsmState:         n.state,
-		Roles:            &cpb.NodeRoles{},
-		Status:           n.status,
-		TpmUsage:         n.tpmUsage,
-		Labels:           &cpb.NodeLabels{},
+		Id:                n.id,
+		ClusterUnlockKey:  n.clusterUnlockKey,
+		PublicKey:         n.pubkey,
+		JoinKey:           n.jkey,
+		FsmState:          n.state,
+		Roles:             &cpb.NodeRoles{},
+		Status:            n.status,
+		UpdateStatus:      n.updateStatus,
+		TpmUsage:          n.tpmUsage,
+		Labels:            &cpb.NodeLabels{},
+		Update:            n.update,
+		EkPublicKey:       n.ekPub,
+		Attestation:       n.attestation,
+		MeasuredBoot:      n.measuredBoot,
+		TimeConfiguration: n.timeConfiguration,
 	}
 	if n.kubernetesWorker != nil {
 		msg.Roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{}
@@ -320,14 +353,20 @@ func nodeUnmarshal(kv *mvccpb.KeyValue) (*Node, error) {
 		return nil, fmt.Errorf("node ID mismatch (etcd key: %q, value: %q)", id, valueID)
 	}
 	n := &Node{
-		clusterUnlockKey: msg.ClusterUnlockKey,
-		id:               id,
-		pubkey:           msg.PublicKey,
-		jkey:             msg.JoinKey,
-		state:            msg.FsmState,
-		status:           msg.Status,
-		tpmUsage:         msg.TpmUsage,
-		labels:           make(map[string]string),
+		clusterUnlockKey:  msg.ClusterUnlockKey,
+		id:                id,
+		pubkey:            msg.PublicKey,
+		jkey:              msg.JoinKey,
+		state:             msg.FsmState,
+		status:            msg.Status,
+		updateStatus:      msg.UpdateStatus,
+		tpmUsage:          msg.TpmUsage,
+		labels:            make(map[string]string),
+		update:            msg.Update,
+		ekPub:             msg.EkPublicKey,
+		attestation:       msg.Attestation,
+		measuredBoot:      msg.MeasuredBoot,
+		timeConfiguration: msg.TimeConfiguration,
 	}
 	if msg.Roles.KubernetesWorker != nil {
 		n.kubernetesWorker = &NodeRoleKubernetesWorker{}
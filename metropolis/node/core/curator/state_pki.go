@@ -4,6 +4,8 @@
 package curator
 
 import (
+	"fmt"
+
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/osbase/pki"
 )
@@ -21,3 +23,18 @@ var (
 		Name:      "cluster-ca",
 	}
 )
+
+// pkiNodeCertificate returns the certificate of a node, issued by the cluster CA
+// for the node's public key. It is reissued by Ensure when the node needs to
+// renew it.
+func pkiNodeCertificate(node *Node) *pki.Certificate {
+	return &pki.Certificate{
+		Namespace: &pkiNamespace,
+		Issuer:    pkiCA,
+		Template:  identity.NodeCertificate(node.ID()),
+		Mode:      pki.CertificateExternal,
+		PublicKey: node.pubkey,
+		Name:      fmt.Sprintf("node-%s", node.ID()),
+		Lifetime:  identity.NodeCertificateLifetime,
+	}
+}
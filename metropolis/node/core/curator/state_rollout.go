@@ -0,0 +1,74 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package curator
+
+import (
+	"context"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+const (
+	// rolloutEtcdPath is the etcd key under which the current (or last)
+	// apb.Rollout is stored.
+	rolloutEtcdPath = "/cluster/rollout"
+)
+
+var (
+	errRolloutNotFound = status.Error(codes.NotFound, "no rollout")
+)
+
+// rolloutLoad loads the current rollout from etcd, within a given active
+// leadership. All returned errors are gRPC statuses that are safe to return to
+// untrusted callers. If no rollout was ever started, errRolloutNotFound is
+// returned.
+func rolloutLoad(ctx context.Context, l *leadership) (*apb.Rollout, error) {
+	rpc.Trace(ctx).Printf("rolloutLoad...")
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(rolloutEtcdPath))
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return nil, rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not retrieve rollout: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "could not retrieve rollout: %v", err)
+	}
+	kvs := res.Responses[0].GetResponseRange().Kvs
+	if len(kvs) != 1 {
+		return nil, errRolloutNotFound
+	}
+	var ro apb.Rollout
+	if err := proto.Unmarshal(kvs[0].Value, &ro); err != nil {
+		rpc.Trace(ctx).Printf("could not unmarshal rollout: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "could not unmarshal rollout")
+	}
+	return &ro, nil
+}
+
+// rolloutSave saves the given rollout into etcd, within a given active
+// leadership. All returned errors are gRPC statuses that are safe to return to
+// untrusted callers.
+func rolloutSave(ctx context.Context, l *leadership, ro *apb.Rollout) error {
+	rpc.Trace(ctx).Printf("rolloutSave(%s)...", ro.Id)
+	roBytes, err := proto.Marshal(ro)
+	if err != nil {
+		rpc.Trace(ctx).Printf("could not marshal rollout: %v", err)
+		return status.Errorf(codes.Unavailable, "could not marshal rollout")
+	}
+	_, err = l.txnAsLeader(ctx, clientv3.OpPut(rolloutEtcdPath, string(roBytes)))
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not save rollout: %v", err)
+		return status.Error(codes.Unavailable, "could not save rollout")
+	}
+	rpc.Trace(ctx).Printf("rolloutSave(%s): write ok", ro.Id)
+	return nil
+}
@@ -9,8 +9,26 @@ import (
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
+	"time"
 )
 
+const (
+	// NodeCertificateLifetime is the validity period of node certificates. Nodes
+	// renew their certificates well before they expire, see RenewalTime.
+	NodeCertificateLifetime = 365 * 24 * time.Hour
+	// UserCertificateLifetime is the validity period of user certificates. Users
+	// renew their certificates by escrowing new ones with their private key.
+	UserCertificateLifetime = 30 * 24 * time.Hour
+)
+
+// RenewalTime returns the time after which a certificate should be renewed,
+// which is once two thirds of its validity period have passed. This matches the
+// point at which osbase/pki reissues certificates stored in the cluster.
+func RenewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime / 3 * 2)
+}
+
 // UserCertificate makes a Metropolis-compatible user certificate template.
 func UserCertificate(identity string) x509.Certificate {
 	return x509.Certificate{
@@ -24,6 +42,23 @@ func UserCertificate(identity string) x509.Certificate {
 	}
 }
 
+// ScopedUserCertificate makes a Metropolis-compatible user certificate template
+// for an identity which is only granted the given permissions. Permissions are
+// opaque strings to this package, and are stored as the organizational units of
+// the certificate subject. Consumers of the certificate can retrieve them with
+// UserPermissions.
+func ScopedUserCertificate(identity string, permissions []string) x509.Certificate {
+	c := UserCertificate(identity)
+	c.Subject.OrganizationalUnit = permissions
+	return c
+}
+
+// UserPermissions returns the permissions of a user certificate made from a
+// ScopedUserCertificate template.
+func UserPermissions(user *x509.Certificate) []string {
+	return user.Subject.OrganizationalUnit
+}
+
 // NodeCertificate makes a Metropolis-compatible node certificate template.
 func NodeCertificate(nodeID string) x509.Certificate {
 	return x509.Certificate{
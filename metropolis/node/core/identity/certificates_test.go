@@ -181,3 +181,25 @@ func TestCertificates(t *testing.T) {
 		}
 	}
 }
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Unix(1700000000, 0)
+	for i, te := range []struct {
+		notAfter time.Time
+		want     time.Time
+	}{
+		{notBefore.Add(3 * time.Hour), notBefore.Add(2 * time.Hour)},
+		{notBefore.Add(NodeCertificateLifetime), notBefore.Add(NodeCertificateLifetime / 3 * 2)},
+	} {
+		cert := &x509.Certificate{NotBefore: notBefore, NotAfter: te.notAfter}
+		if got := RenewalTime(cert); !got.Equal(te.want) {
+			t.Errorf("case %d: wanted %s, got %s", i, te.want, got)
+		}
+	}
+
+	// Certificates valid forever must not need renewal any time soon.
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: time.Unix(253402300799, 0)}
+	if got, min := RenewalTime(cert), notBefore.Add(100*365*24*time.Hour); got.Before(min) {
+		t.Errorf("certificate valid forever: wanted renewal after %s, got %s", min, got)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/x509"
 	"encoding/hex"
 	"fmt"
+	"sync/atomic"
 )
 
 // Node is the public part of the credentials of a node. They are
@@ -73,9 +74,16 @@ func (n *Node) Certificate() *x509.Certificate {
 //
 // It must never be made available to any node other than the node it has been
 // emitted for.
+//
+// The node certificate can be replaced by a renewed one with Renew, which is
+// visible to all users of the NodeCredentials (and its copies). Users which
+// keep TLS connections or listeners open should use GetCertificate and
+// GetClientCertificate, so that new connections use the renewed certificate.
 type NodeCredentials struct {
 	Node
 	private ed25519.PrivateKey
+	// renewed is the certificate set by the last call to Renew, if any.
+	renewed *atomic.Pointer[x509.Certificate]
 }
 
 // NewNodeCredentials wraps a pair of CA and node DER-encoded certificates plus
@@ -100,17 +108,66 @@ func NewNodeCredentials(priv, cert, ca []byte) (*NodeCredentials, error) {
 	return &NodeCredentials{
 		Node:    *nc,
 		private: priv,
+		renewed: new(atomic.Pointer[x509.Certificate]),
 	}, nil
 }
 
+// Certificate returns the current node certificate, which is the one last set
+// by Renew, if any.
+func (n *NodeCredentials) Certificate() *x509.Certificate {
+	if n.renewed != nil {
+		if cert := n.renewed.Load(); cert != nil {
+			return cert
+		}
+	}
+	return n.node
+}
+
+// Renew replaces the node certificate by the given DER-encoded certificate,
+// which must be emitted by the same cluster CA for the same node.
+func (n *NodeCredentials) Renew(cert []byte) error {
+	if n.renewed == nil {
+		return fmt.Errorf("credentials cannot be renewed")
+	}
+	certParsed, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return fmt.Errorf("could not parse node certificate: %w", err)
+	}
+	id, err := VerifyNodeInCluster(certParsed, n.ca)
+	if err != nil {
+		return fmt.Errorf("could not verify node certificate within cluster CA: %w", err)
+	}
+	if id != n.ID() {
+		return fmt.Errorf("certificate emitted for node %s, wanted %s", id, n.ID())
+	}
+	n.renewed.Store(certParsed)
+	return nil
+}
+
 func (n *NodeCredentials) TLSCredentials() tls.Certificate {
+	cert := n.Certificate()
 	return tls.Certificate{
-		Leaf:        n.node,
-		Certificate: [][]byte{n.node.Raw},
+		Leaf:        cert,
+		Certificate: [][]byte{cert.Raw},
 		PrivateKey:  n.private,
 	}
 }
 
+// GetCertificate returns the current TLS credentials. It can be used as
+// tls.Config.GetCertificate by servers, in which case tls.Config.Certificates
+// must be empty.
+func (n *NodeCredentials) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := n.TLSCredentials()
+	return &cert, nil
+}
+
+// GetClientCertificate returns the current TLS credentials. It can be used as
+// tls.Config.GetClientCertificate by clients.
+func (n *NodeCredentials) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert := n.TLSCredentials()
+	return &cert, nil
+}
+
 type PKIDirectory interface {
 	ReadAll() (ca, cert *x509.Certificate, key ed25519.PrivateKey, err error)
 	WriteAll(cert []byte, key ed25519.PrivateKey, ca []byte) error
@@ -118,7 +175,7 @@ type PKIDirectory interface {
 
 // Save stores the given node credentials in local storage.
 func (n *NodeCredentials) Save(d PKIDirectory) error {
-	return d.WriteAll(n.node.Raw, n.private, n.ca.Raw)
+	return d.WriteAll(n.Certificate().Raw, n.private, n.ca.Raw)
 }
 
 // Read initializes NodeCredentials' contents with the data stored in the
@@ -131,6 +188,7 @@ func (n *NodeCredentials) Read(d PKIDirectory) error {
 	n.ca = ca
 	n.node = cert
 	n.private = key
+	n.renewed = new(atomic.Pointer[x509.Certificate])
 	return nil
 }
 
@@ -0,0 +1,96 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestNodeCredentialsRenew ensures that renewed certificates are visible to
+// copies of NodeCredentials and used for TLS, and that certificates of other
+// nodes are rejected.
+func TestNodeCredentialsRenew(t *testing.T) {
+	caPub, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	caTemplate := CACertificate("test metropolis CA")
+	basic(&caTemplate)
+	caCertBytes, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, caPub, caPriv)
+	if err != nil {
+		t.Fatalf("CreateCertificate (CA): %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caCertBytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate (CA): %v", err)
+	}
+
+	// issue returns a node certificate for pub, with the given serial and
+	// validity.
+	issue := func(pub ed25519.PublicKey, serial int64, notAfter time.Time) []byte {
+		t.Helper()
+		template := NodeCertificate(NodeID(pub))
+		basic(&template)
+		template.SerialNumber = big.NewInt(serial)
+		template.NotAfter = notAfter
+		certBytes, err := x509.CreateCertificate(rand.Reader, &template, caCert, pub, caPriv)
+		if err != nil {
+			t.Fatalf("CreateCertificate (node): %v", err)
+		}
+		return certBytes
+	}
+
+	nodePub, nodePriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	now := time.Now()
+	creds, err := NewNodeCredentials(nodePriv, issue(nodePub, 1, now.Add(time.Hour)), caCertBytes)
+	if err != nil {
+		t.Fatalf("NewNodeCredentials: %v", err)
+	}
+	cp := *creds
+
+	renewed := issue(nodePub, 2, now.Add(2*time.Hour))
+	if err := creds.Renew(renewed); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if want, got := int64(2), cp.Certificate().SerialNumber.Int64(); want != got {
+		t.Errorf("copy has certificate %d, wanted %d", got, want)
+	}
+	if want, got := creds.ID(), cp.ID(); want != got {
+		t.Errorf("renewed credentials have ID %q, wanted %q", got, want)
+	}
+	server, err := creds.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	if want, got := int64(2), server.Leaf.SerialNumber.Int64(); want != got {
+		t.Errorf("server certificate is %d, wanted %d", got, want)
+	}
+	client, err := creds.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil {
+		t.Fatalf("GetClientCertificate: %v", err)
+	}
+	if want, got := int64(2), client.Leaf.SerialNumber.Int64(); want != got {
+		t.Errorf("client certificate is %d, wanted %d", got, want)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := creds.Renew(issue(otherPub, 3, now.Add(2*time.Hour))); err == nil {
+		t.Errorf("Renew with certificate of another node succeeded")
+	}
+	if want, got := int64(2), creds.Certificate().SerialNumber.Int64(); want != got {
+		t.Errorf("certificate is %d after failed renewal, wanted %d", got, want)
+	}
+}
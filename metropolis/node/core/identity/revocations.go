@@ -0,0 +1,51 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// Revocations is the set of certificates revoked by the cluster CA, as
+// distributed in its certificate revocation list. It is safe for concurrent
+// use. A nil or zero Revocations does not contain any revoked certificates.
+type Revocations struct {
+	mu sync.RWMutex
+	// serials of revoked certificates, as decimal strings.
+	serials map[string]bool
+}
+
+// Update replaces the set of revoked certificates with those listed in the
+// given DER-encoded certificate revocation list, after ensuring that it has
+// been signed by the given CA.
+func (r *Revocations) Update(crl []byte, ca *x509.Certificate) error {
+	list, err := x509.ParseRevocationList(crl)
+	if err != nil {
+		return fmt.Errorf("could not parse CRL: %w", err)
+	}
+	if err := list.CheckSignatureFrom(ca); err != nil {
+		return fmt.Errorf("CRL not signed by cluster CA: %w", err)
+	}
+	serials := make(map[string]bool)
+	for _, e := range list.RevokedCertificateEntries {
+		serials[e.SerialNumber.String()] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.serials = serials
+	return nil
+}
+
+// Revoked returns true if the given certificate has been revoked.
+func (r *Revocations) Revoked(cert *x509.Certificate) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.serials[cert.SerialNumber.String()]
+}
@@ -0,0 +1,95 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestRevocations exercises updating Revocations from CRLs and checking
+// certificates against them.
+func TestRevocations(t *testing.T) {
+	newCA := func() (*x509.Certificate, ed25519.PrivateKey) {
+		t.Helper()
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		template := CACertificate("test metropolis CA")
+		basic(&template)
+		der, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+		if err != nil {
+			t.Fatalf("CreateCertificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("ParseCertificate: %v", err)
+		}
+		return cert, priv
+	}
+	newCRL := func(ca *x509.Certificate, priv ed25519.PrivateKey, serials ...int64) []byte {
+		t.Helper()
+		template := &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: time.Now(),
+			NextUpdate: time.Unix(253402300799, 0),
+		}
+		for _, s := range serials {
+			template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+				SerialNumber:   big.NewInt(s),
+				RevocationTime: time.Now(),
+			})
+		}
+		crl, err := x509.CreateRevocationList(rand.Reader, template, ca, priv)
+		if err != nil {
+			t.Fatalf("CreateRevocationList: %v", err)
+		}
+		return crl
+	}
+	cert := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial)}
+	}
+
+	var nilRevocations *Revocations
+	if nilRevocations.Revoked(cert(1)) {
+		t.Errorf("nil Revocations contains revoked certificate")
+	}
+
+	ca, caPriv := newCA()
+	var r Revocations
+	if r.Revoked(cert(1)) {
+		t.Errorf("zero Revocations contains revoked certificate")
+	}
+	if err := r.Update(newCRL(ca, caPriv, 1, 2), ca); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for serial, want := range map[int64]bool{1: true, 2: true, 3: false} {
+		if got := r.Revoked(cert(serial)); got != want {
+			t.Errorf("Serial %d: wanted revoked %v, got %v", serial, want, got)
+		}
+	}
+
+	// A CRL signed by another CA must be rejected, and not change the set of
+	// revoked certificates.
+	otherCA, otherPriv := newCA()
+	if err := r.Update(newCRL(otherCA, otherPriv, 3), ca); err == nil {
+		t.Errorf("Update with CRL from other CA succeeded")
+	}
+	if r.Revoked(cert(3)) || !r.Revoked(cert(1)) {
+		t.Errorf("Revocations changed by rejected CRL")
+	}
+
+	// A new CRL replaces the previous one.
+	if err := r.Update(newCRL(ca, caPriv, 3), ca); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if r.Revoked(cert(1)) || !r.Revoked(cert(3)) {
+		t.Errorf("Revocations not replaced by new CRL")
+	}
+}
@@ -6,6 +6,7 @@ package declarative
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"golang.org/x/sys/unix"
@@ -56,7 +57,9 @@ func (f *FSPlacement) Write(d []byte, mode os.FileMode) error {
 	tmp := f.FullPath() + ".__metropolis_tmp"
 	defer os.Remove(tmp)
 
-	tf, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE, mode)
+	// Truncate the temporary file, as it might be left over from a previous
+	// write which was interrupted.
+	tf, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return fmt.Errorf("temporary file open failed: %w", err)
 	}
@@ -81,6 +84,15 @@ func (f *FSPlacement) Write(d []byte, mode os.FileMode) error {
 	if err := unix.Rename(tmp, f.FullPath()); err != nil {
 		return fmt.Errorf("renaming target file failed: %w", err)
 	}
+	// Fsync the directory to make the rename durable.
+	dir, err := os.Open(filepath.Dir(f.FullPath()))
+	if err != nil {
+		return fmt.Errorf("opening target directory failed: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("target directory sync failed: %w", err)
+	}
 
 	return nil
 }
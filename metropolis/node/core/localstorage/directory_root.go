@@ -50,6 +50,7 @@ func (r *Root) Start(ctx context.Context, updateSvc *update.Service) error {
 		r.Ephemeral.Consensus,
 		r.Ephemeral.Containerd, r.Ephemeral.Containerd.Tmp, r.Ephemeral.Containerd.RunSC, r.Ephemeral.Containerd.IPAM,
 		r.Ephemeral.FlexvolumePlugins,
+		r.Ephemeral.Chrony,
 		r.ESP.Metropolis,
 	} {
 		err := d.MkdirAll(0700)
@@ -78,6 +78,19 @@ type DataNodeDirectory struct {
 	declarative.Directory
 	Credentials    PKIDirectory     `dir:"credentials"`
 	PersistedRoles declarative.File `file:"roles.pb"`
+	// CRL contains the DER-encoded certificate revocation list of the cluster
+	// CA last retrieved from the curator, which is used until a current one
+	// has been retrieved after a reboot.
+	CRL declarative.File `file:"crl.der"`
+	// AppliedUpdate contains the ID of the last cluster-requested update (see
+	// curator NodeUpdate) which this node has acted upon.
+	AppliedUpdate declarative.File `file:"applied_update"`
+	// ImageCache contains the OS image last installed by the update service,
+	// which it serves to other nodes.
+	ImageCache declarative.Directory `dir:"image_cache"`
+	// Logs contains the persisted log journals of the current and previous
+	// boots.
+	Logs declarative.Directory `dir:"logs"`
 }
 
 type DataEtcdDirectory struct {
@@ -85,6 +98,14 @@ type DataEtcdDirectory struct {
 	PeerPKI PKIDirectory          `dir:"peer_pki"`
 	PeerCRL declarative.File      `file:"peer_crl"`
 	Data    declarative.Directory `dir:"data"`
+	// ForceNewCluster is a marker file which, if present, makes the consensus
+	// service start etcd as the only member of a new cluster. It is removed
+	// once etcd started successfully.
+	ForceNewCluster declarative.File `file:"force_new_cluster"`
+	// Backups contains consensus database backups taken by the curator leader
+	// running on this node, if the cluster is configured to store backups
+	// locally.
+	Backups declarative.Directory `dir:"backups"`
 }
 
 type DataKubernetesDirectory struct {
@@ -153,6 +174,7 @@ type EphemeralDirectory struct {
 	Consensus         EphemeralConsensusDirectory  `dir:"consensus"`
 	Containerd        EphemeralContainerdDirectory `dir:"containerd"`
 	FlexvolumePlugins declarative.Directory        `dir:"flexvolume_plugins"`
+	Chrony            declarative.Directory        `dir:"chrony"`
 	MachineID         declarative.File             `file:"machine-id"`
 }
 
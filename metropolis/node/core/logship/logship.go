@@ -0,0 +1,268 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package logship implements shipping of a node's logs to a remote collector,
+// as configured by the LogShipping field of the ClusterConfiguration.
+//
+// Log entries are read from a LogTree as they are logged and placed in a
+// bounded buffer, from which they are sent to the collector in batches. While
+// the collector is unreachable, entries accumulate in the buffer, and once it
+// is full, the oldest entries are dropped. This way, a slow or unavailable
+// collector never blocks logging on the node.
+package logship
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"source.monogon.dev/go/logging"
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// DefaultBufferSize is the number of entries buffered if not configured
+	// otherwise in Service.
+	DefaultBufferSize = 10000
+	// maxBatchSize is the maximum number of entries sent in one request to the
+	// collector.
+	maxBatchSize = 512
+)
+
+// Service ships log entries from a LogTree to a collector. It must be
+// configured by setting its exported fields before calling Run.
+type Service struct {
+	// LogTree from which log entries are shipped.
+	LogTree *logtree.LogTree
+	// Config is the log shipping configuration from the cluster. Its
+	// destination must be set.
+	Config *cpb.ClusterConfiguration_LogShipping
+	// NodeID identifies the node in shipped log entries.
+	NodeID string
+	// GetClientCertificate, if set, returns the TLS client certificate presented
+	// to the collector. It is called for every new connection, so that renewed
+	// certificates are presented without restarting the service.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	// BufferSize is the maximum number of entries buffered while they cannot be
+	// shipped. Defaults to DefaultBufferSize if zero.
+	BufferSize int
+
+	// mu guards all fields below.
+	mu sync.Mutex
+	// pending are the entries not yet shipped, oldest first.
+	pending []*record
+	// nextSeq is the sequence number of the next entry added to pending.
+	nextSeq uint64
+	// dropped is the number of entries dropped since the last successful send.
+	dropped uint64
+	// notifyC is signaled whenever entries are added to pending.
+	notifyC chan struct{}
+}
+
+// record is a log entry waiting to be shipped.
+type record struct {
+	// seq is a number which increases monotonically with every record.
+	seq   uint64
+	entry *logtree.LogEntry
+	// time is the time at which the entry was logged. For raw entries, which
+	// do not carry a timestamp, it is the time at which the entry was read.
+	time time.Time
+}
+
+// sink is a collector to which records can be sent.
+type sink interface {
+	// send sends the given records to the collector. If it returns an error,
+	// the records are sent again later, unless it is a permanentError.
+	send(ctx context.Context, records []*record) error
+	// close releases all resources held by the sink.
+	close()
+}
+
+// permanentError is returned by a sink if the collector rejected records in a
+// way which would not change by sending them again.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// newSink returns the sink for the configured destination.
+func (s *Service) newSink() (sink, error) {
+	switch dst := s.Config.GetDestination().(type) {
+	case *cpb.ClusterConfiguration_LogShipping_Syslog_:
+		return newSyslogSink(dst.Syslog, s.NodeID, s.GetClientCertificate), nil
+	case *cpb.ClusterConfiguration_LogShipping_Otlp:
+		return newOTLPSink(dst.Otlp, s.NodeID, s.GetClientCertificate), nil
+	default:
+		return nil, fmt.Errorf("no log shipping destination configured")
+	}
+}
+
+// Run ships log entries until the context is canceled. Entries logged before
+// Run was called are not shipped.
+func (s *Service) Run(ctx context.Context) error {
+	sk, err := s.newSink()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.notifyC == nil {
+		s.notifyC = make(chan struct{}, 1)
+	}
+	s.mu.Unlock()
+
+	reader, err := s.LogTree.Read(logtree.DN(s.Config.Dn), logtree.WithChildren(), logtree.WithStream())
+	if err != nil {
+		sk.close()
+		return fmt.Errorf("could not read logs: %w", err)
+	}
+	defer reader.Close()
+
+	if err := supervisor.Run(ctx, "sender", func(ctx context.Context) error {
+		return s.runSender(ctx, sk)
+	}); err != nil {
+		sk.close()
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-reader.Stream:
+			if !ok {
+				return fmt.Errorf("log stream closed")
+			}
+			s.add(e)
+		}
+	}
+}
+
+// add places an entry in the buffer, dropping the oldest entry if the buffer
+// is full.
+func (s *Service) add(e *logtree.LogEntry) {
+	t := time.Now()
+	if e.Leveled != nil {
+		t = e.Leveled.Timestamp()
+	}
+	size := s.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= size {
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	s.pending = append(s.pending, &record{seq: s.nextSeq, entry: e, time: t})
+	s.nextSeq++
+	select {
+	case s.notifyC <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns up to maxBatchSize of the oldest buffered entries, without
+// removing them from the buffer.
+func (s *Service) peek() []*record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(len(s.pending), maxBatchSize)
+	return append([]*record(nil), s.pending[:n]...)
+}
+
+// ack removes all entries up to and including the one with the given sequence
+// number from the buffer, and returns the number of entries dropped since the
+// last call.
+func (s *Service) ack(seq uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := 0
+	for i < len(s.pending) && s.pending[i].seq <= seq {
+		i++
+	}
+	s.pending = s.pending[i:]
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+// runSender sends buffered entries to the collector in batches, retrying with
+// backoff while the collector is unavailable.
+func (s *Service) runSender(ctx context.Context, sk sink) error {
+	defer sk.close()
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = 0
+	bo.MaxInterval = time.Minute
+	failing := false
+	for {
+		batch := s.peek()
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.notifyC:
+			}
+			continue
+		}
+
+		err := sk.send(ctx, batch)
+		var perr *permanentError
+		if err != nil && !errors.As(err, &perr) {
+			// Only log state changes, as every log line from here is also
+			// shipped.
+			if !failing {
+				supervisor.Logger(ctx).Warningf("Could not ship logs, buffering: %v", err)
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(bo.NextBackOff()):
+			}
+			continue
+		}
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("Collector rejected %d log entries: %v", len(batch), err)
+		}
+		bo.Reset()
+		dropped := s.ack(batch[len(batch)-1].seq)
+		if failing {
+			supervisor.Logger(ctx).Infof("Shipping logs again, %d entries were dropped while the collector was unavailable", dropped)
+			failing = false
+		} else if dropped > 0 {
+			supervisor.Logger(ctx).Warningf("Dropped %d log entries as the collector could not keep up", dropped)
+		}
+	}
+}
+
+// severity returns the severity of an entry. Raw entries are treated as INFO.
+func (r *record) severity() logging.Severity {
+	if r.entry.Leveled != nil {
+		return r.entry.Leveled.Severity()
+	}
+	return logging.INFO
+}
+
+// message returns the message of an entry, with multiple lines of a leveled
+// entry joined by newlines.
+func (r *record) message() string {
+	if r.entry.Leveled != nil {
+		return r.entry.Leveled.MessagesJoined()
+	}
+	return r.entry.Raw.Data
+}
@@ -0,0 +1,241 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package logship
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// logUntil logs a ping message to the given DN every 10ms until the returned
+// function is called. This is used to wait for the service to be subscribed to
+// the LogTree, as it does not ship entries logged before.
+func logUntil(lt *logtree.LogTree, dn logtree.DN) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			lt.MustLeveledFor(dn).Info("ping")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// readSyslogMessage reads a single octet-counted message.
+func readSyslogMessage(r *bufio.Reader) (string, error) {
+	lenStr, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(lenStr, " "))
+	if err != nil {
+		return "", fmt.Errorf("invalid length %q: %w", lenStr, err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func TestSyslog(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer lis.Close()
+
+	lt := logtree.New()
+	s := &Service{
+		LogTree: lt,
+		Config: &cpb.ClusterConfiguration_LogShipping{
+			Destination: &cpb.ClusterConfiguration_LogShipping_Syslog_{
+				Syslog: &cpb.ClusterConfiguration_LogShipping_Syslog{
+					Address: lis.Addr().String(),
+				},
+			},
+			Dn: "test",
+		},
+		NodeID: "metropolis-1234",
+	}
+	ctxC, _ := supervisor.TestHarness(t, s.Run)
+	defer ctxC()
+
+	stop := logUntil(lt, "test")
+	conn, err := lis.Accept()
+	if err != nil {
+		stop()
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	_, err = readSyslogMessage(r)
+	stop()
+	if err != nil {
+		t.Fatalf("reading first message: %v", err)
+	}
+
+	lt.MustLeveledFor("other").Info("not shipped")
+	lt.MustLeveledFor("test.sub").Warning("hello\nworld")
+
+	want := regexp.MustCompile(`^<12>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z metropolis-1234 test\.sub - - \[logtree@32473 dn="test\.sub"\] logship_test\.go:\d+\] hello\nworld$`)
+	for {
+		msg, err := readSyslogMessage(r)
+		if err != nil {
+			t.Fatalf("reading message: %v", err)
+		}
+		if strings.Contains(msg, "not shipped") {
+			t.Fatalf("entry outside of DN was shipped: %q", msg)
+		}
+		if strings.HasSuffix(msg, "ping") {
+			continue
+		}
+		if !want.MatchString(msg) {
+			t.Fatalf("unexpected message %q", msg)
+		}
+		break
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	lt := logtree.New()
+	reader, err := lt.Read("", logtree.WithChildren(), logtree.WithStream())
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer reader.Close()
+	dn := logtree.DN("a.very.long.dn.which.does.not.fit.into.the.app.name.field")
+	lt.MustLeveledFor(dn).Error("failed")
+	e := <-reader.Stream
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	got := formatSyslog(&record{entry: e, time: ts}, "")
+	want := `<11>1 2024-01-02T03:04:05.000006Z - a.very.long.dn.which.does.not.fit.into.the.app.n - - [logtree@32473 dn="a.very.long.dn.which.does.not.fit.into.the.app.name.field"] logship_test.go:`
+	if !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "] failed") {
+		t.Errorf("wanted %q...] failed, got %q", want, got)
+	}
+}
+
+func TestOTLPBuffering(t *testing.T) {
+	var (
+		mu sync.Mutex
+		// up is whether the collector accepts requests.
+		up       bool
+		received []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				for _, lr := range sl.LogRecords {
+					if lr.Body.StringValue == "ping" {
+						continue
+					}
+					received = append(received, fmt.Sprintf("%s %s", lr.SeverityText, lr.Body.StringValue))
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	lt := logtree.New()
+	s := &Service{
+		LogTree: lt,
+		Config: &cpb.ClusterConfiguration_LogShipping{
+			Destination: &cpb.ClusterConfiguration_LogShipping_Otlp{
+				Otlp: &cpb.ClusterConfiguration_LogShipping_OTLP{
+					Endpoint: srv.URL + "/v1/logs",
+				},
+			},
+			Dn: "test",
+		},
+		NodeID:     "metropolis-1234",
+		BufferSize: 5,
+	}
+	ctxC, _ := supervisor.TestHarness(t, s.Run)
+	defer ctxC()
+
+	// Wait until the service buffers entries.
+	stop := logUntil(lt, "test")
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+
+	// While the collector is down, only the newest entries are retained.
+	for i := 0; i < 10; i++ {
+		lt.MustLeveledFor("test").Infof("entry %d", i)
+	}
+	lt.MustLeveledFor("test").Error("last")
+	for {
+		s.mu.Lock()
+		last := s.pending[len(s.pending)-1].message()
+		s.mu.Unlock()
+		if last == "last" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+	want := "INFO entry 6,INFO entry 7,INFO entry 8,INFO entry 9,ERROR last"
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		mu.Lock()
+		got := strings.Join(received, ",")
+		mu.Unlock()
+		if got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wanted %q, got %q", want, got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
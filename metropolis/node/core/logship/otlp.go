@@ -0,0 +1,178 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package logship
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"source.monogon.dev/go/logging"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// otlpTimeout bounds a single request to the collector.
+const otlpTimeout = 30 * time.Second
+
+// otlpSink sends records to an OpenTelemetry collector using OTLP/HTTP with
+// JSON encoding.
+type otlpSink struct {
+	endpoint string
+	nodeID   string
+	client   *http.Client
+}
+
+func newOTLPSink(cfg *cpb.ClusterConfiguration_LogShipping_OTLP, nodeID string, getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) *otlpSink {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if getCert != nil {
+		transport.TLSClientConfig = &tls.Config{
+			GetClientCertificate: getCert,
+		}
+	}
+	return &otlpSink{
+		endpoint: cfg.Endpoint,
+		nodeID:   nodeID,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   otlpTimeout,
+		},
+	}
+}
+
+// The following types are the subset of the OTLP JSON encoding of an
+// ExportLogsServiceRequest used by otlpSink.
+
+type otlpRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	// Timestamps are nanoseconds since the epoch, encoded as strings as they
+	// are 64-bit integers.
+	TimeUnixNano         string         `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpSeverity maps logtree severities to OTLP severity numbers and texts.
+var otlpSeverity = map[logging.Severity]struct {
+	number int
+	text   string
+}{
+	logging.INFO:    {9, "INFO"},
+	logging.WARNING: {13, "WARN"},
+	logging.ERROR:   {17, "ERROR"},
+	logging.FATAL:   {21, "FATAL"},
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// otlpRecord converts a record to an OTLP log record. Raw entries have no
+// timestamp and severity of their own, so only the observed time is set and
+// the severity is left unspecified.
+func otlpRecord(r *record) otlpLogRecord {
+	lr := otlpLogRecord{
+		ObservedTimeUnixNano: strconv.FormatInt(r.time.UnixNano(), 10),
+		Body:                 otlpAnyValue{StringValue: r.message()},
+		Attributes: []otlpKeyValue{
+			otlpString("logtree.dn", string(r.entry.DN)),
+		},
+	}
+	if l := r.entry.Leveled; l != nil {
+		sev := otlpSeverity[l.Severity()]
+		lr.TimeUnixNano = lr.ObservedTimeUnixNano
+		lr.SeverityNumber = sev.number
+		lr.SeverityText = sev.text
+		lr.Attributes = append(lr.Attributes, otlpString("code.location", l.Location()))
+	}
+	return lr
+}
+
+func (s *otlpSink) send(ctx context.Context, records []*record) error {
+	req := otlpRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{
+					otlpString("service.name", "metropolis"),
+					otlpString("host.name", s.nodeID),
+				},
+			},
+			ScopeLogs: []otlpScopeLogs{{
+				Scope: otlpScope{Name: "logtree"},
+			}},
+		}},
+	}
+	sl := &req.ResourceLogs[0].ScopeLogs[0]
+	for _, r := range records {
+		sl.LogRecords = append(sl.LogRecords, otlpRecord(r))
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return &permanentError{fmt.Errorf("could not marshal request: %w", err)}
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{fmt.Errorf("could not create request: %w", err)}
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(hreq)
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests, res.StatusCode == http.StatusRequestTimeout, res.StatusCode >= 500:
+		return fmt.Errorf("collector returned %s", res.Status)
+	default:
+		return &permanentError{fmt.Errorf("collector returned %s", res.Status)}
+	}
+}
+
+func (s *otlpSink) close() {
+	s.client.CloseIdleConnections()
+}
@@ -0,0 +1,143 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package logship
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"source.monogon.dev/go/logging"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// syslogFacility is the facility of all shipped messages, user-level.
+	syslogFacility = 1
+	// syslogMaxAppName is the maximum length of the APP-NAME field.
+	syslogMaxAppName = 48
+	// syslogSDID is the ID of the STRUCTURED-DATA element carrying the full
+	// DN of an entry. Custom IDs require a private enterprise number, this is
+	// the one reserved for documentation by RFC 5612.
+	syslogSDID = "logtree@32473"
+	// syslogTimeout bounds connecting to the collector and writing a batch.
+	syslogTimeout = 30 * time.Second
+)
+
+// syslogSink sends records as RFC 5424 messages over a TCP connection, framed
+// by octet counting as per RFC 6587.
+type syslogSink struct {
+	address  string
+	hostname string
+	// tlsConfig is used to establish the connection if set. Otherwise, plain
+	// TCP is used.
+	tlsConfig *tls.Config
+	// conn is the current connection to the collector, if any.
+	conn net.Conn
+}
+
+func newSyslogSink(cfg *cpb.ClusterConfiguration_LogShipping_Syslog, hostname string, getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) *syslogSink {
+	s := &syslogSink{
+		address:  cfg.Address,
+		hostname: hostname,
+	}
+	if cfg.Tls {
+		s.tlsConfig = &tls.Config{
+			GetClientCertificate: getCert,
+		}
+	}
+	return s
+}
+
+func (s *syslogSink) send(ctx context.Context, records []*record) error {
+	if s.conn == nil {
+		ctx, cancel := context.WithTimeout(ctx, syslogTimeout)
+		defer cancel()
+		var conn net.Conn
+		var err error
+		if s.tlsConfig != nil {
+			d := tls.Dialer{Config: s.tlsConfig}
+			conn, err = d.DialContext(ctx, "tcp", s.address)
+		} else {
+			var d net.Dialer
+			conn, err = d.DialContext(ctx, "tcp", s.address)
+		}
+		if err != nil {
+			return fmt.Errorf("could not connect to collector: %w", err)
+		}
+		s.conn = conn
+	}
+
+	var buf bytes.Buffer
+	for _, r := range records {
+		msg := formatSyslog(r, s.hostname)
+		fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+	}
+	// A partially written batch is sent again in full, so the collector might
+	// see some messages twice.
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.close()
+		return fmt.Errorf("could not write to collector: %w", err)
+	}
+	return nil
+}
+
+func (s *syslogSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// syslogSeverity maps logtree severities to syslog severities.
+var syslogSeverity = map[logging.Severity]int{
+	logging.INFO:    6, // informational
+	logging.WARNING: 4, // warning
+	logging.ERROR:   3, // error
+	logging.FATAL:   2, // critical
+}
+
+// formatSyslog formats a record as an RFC 5424 message. The full DN of the
+// entry is carried as STRUCTURED-DATA, as APP-NAME is limited in length and
+// only contains a prefix of it for collectors which ignore STRUCTURED-DATA.
+// The location of a leveled entry is prepended to the message.
+func formatSyslog(r *record, hostname string) string {
+	appName := syslogHeaderField(string(r.entry.DN))
+	if len(appName) > syslogMaxAppName {
+		appName = appName[:syslogMaxAppName]
+	}
+	msg := r.message()
+	if r.entry.Leveled != nil {
+		msg = r.entry.Leveled.Location() + "] " + msg
+	}
+	pri := syslogFacility*8 + syslogSeverity[r.severity()]
+	ts := r.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	sd := fmt.Sprintf("[%s dn=\"%s\"]", syslogSDID, syslogParamValue(string(r.entry.DN)))
+	return fmt.Sprintf("<%d>1 %s %s %s - - %s %s", pri, ts, syslogHeaderField(hostname), appName, sd, msg)
+}
+
+// syslogParamValue escapes s for use as a STRUCTURED-DATA parameter value.
+func syslogParamValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// syslogHeaderField returns s with all characters not allowed in syslog header
+// fields replaced, or the NILVALUE if s is empty.
+func syslogHeaderField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -12,6 +12,7 @@ import (
 
 	"source.monogon.dev/go/logging"
 	"source.monogon.dev/metropolis/node/core/cluster"
+	"source.monogon.dev/metropolis/node/core/curator"
 	"source.monogon.dev/metropolis/node/core/devmgr"
 	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
@@ -31,6 +32,10 @@ import (
 	"source.monogon.dev/osbase/tpm"
 )
 
+// supervisorState records the state of all runnables of the supervision tree,
+// which is served by NodeManagement.GetRunnables.
+var supervisorState = &supervisor.InMemoryMetrics{}
+
 func main() {
 	bringup.Runnable(root).RunWith(bringup.Config{
 		Console: bringup.ConsoleConfig{
@@ -40,6 +45,7 @@ func main() {
 		Supervisor: bringup.SupervisorConfig{
 			Metrics: []supervisor.Metrics{
 				supervisor.NewMetricsPrometheus(metrics.CoreRegistry),
+				supervisorState,
 			},
 		},
 	})
@@ -119,9 +125,9 @@ func root(ctx context.Context) error {
 	}
 
 	metrics.CoreRegistry.MustRegister(dns.MetricsRegistry)
+	metrics.CoreRegistry.MustRegister(curator.MetricsRegistry)
 	networkSvc := network.New(nil, []string{"hosts", "kubernetes"})
 	networkSvc.DHCPVendorClassID = "dev.monogon.metropolis.node.v1"
-	timeSvc := timesvc.New()
 	devmgrSvc := devmgr.New()
 
 	// This function initializes a headless Delve if this is a debug build or
@@ -133,9 +139,11 @@ func root(ctx context.Context) error {
 	if err := declarative.PlaceFS(root, "/"); err != nil {
 		panic(fmt.Errorf("when placing root FS: %w", err))
 	}
+	timeSvc := timesvc.New(&root.Ephemeral)
 
 	updateSvc := &update.Service{
-		Logger: supervisor.MustSubLogger(ctx, "update"),
+		ImageCachePath: root.Data.Node.ImageCache.FullPath(),
+		Logger:         supervisor.MustSubLogger(ctx, "update"),
 	}
 	// Make node-wide cluster resolver.
 	res := resolver.New(ctx, resolver.WithLogger(supervisor.MustSubLogger(ctx, "resolver")))
@@ -192,9 +200,12 @@ func root(ctx context.Context) error {
 	rs := roleserve.New(roleserve.Config{
 		StorageRoot: root,
 		Network:     networkSvc,
+		Time:        timeSvc,
 		Resolver:    res,
 		LogTree:     supervisor.LogTree(ctx),
 		Update:      updateSvc,
+
+		SupervisorState: supervisorState,
 	})
 	if err := supervisor.Run(ctx, "role", rs.Run); err != nil {
 		return fmt.Errorf("failed to start role service: %w", err)
@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"source.monogon.dev/metropolis/node"
@@ -51,6 +52,35 @@ type Exporter struct {
 // prometheus metrics exported by the node core should register here.
 var CoreRegistry = prometheus.NewRegistry()
 
+// CertificateExpiry is the expiry time of the certificates used by the node
+// core, by certificate name. Certificates are renewed well before they expire,
+// so alerting on certificates which are close to expiry catches failing
+// renewals.
+var CertificateExpiry = promauto.With(CoreRegistry).NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "metropolis",
+	Subsystem: "node",
+	Name:      "certificate_expiry_timestamp_seconds",
+	Help:      "Unix time at which a certificate used by this node expires.",
+}, []string{"certificate"})
+
+// ClockSynchronized is 1 if the node's system clock is synchronized to its time
+// sources, 0 otherwise.
+var ClockSynchronized = promauto.With(CoreRegistry).NewGauge(prometheus.GaugeOpts{
+	Namespace: "metropolis",
+	Subsystem: "node",
+	Name:      "clock_synchronized",
+	Help:      "Whether the system clock of this node is synchronized (1) or not (0).",
+})
+
+// ClockOffset is the last measured offset of the node's system clock from its
+// time reference. Positive values mean the local clock is ahead.
+var ClockOffset = promauto.With(CoreRegistry).NewGauge(prometheus.GaugeOpts{
+	Namespace: "metropolis",
+	Subsystem: "node",
+	Name:      "clock_offset_seconds",
+	Help:      "Offset of the system clock of this node from its time reference.",
+})
+
 // DefaultExporters are the exporters which we run by default in Metropolis.
 var DefaultExporters = []*Exporter{
 	{
@@ -51,17 +51,13 @@ type Service struct {
 
 // listen starts the public TLS listener for the service.
 func (s *Service) listen() (net.Listener, error) {
-	cert := s.Credentials.TLSCredentials()
-
 	pool := x509.NewCertPool()
 	pool.AddCert(s.Credentials.ClusterCA())
 
 	tlsc := tls.Config{
-		Certificates: []tls.Certificate{
-			cert,
-		},
-		ClientAuth: tls.RequireAndVerifyClientCert,
-		ClientCAs:  pool,
+		GetCertificate: s.Credentials.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      pool,
 		// TODO(q3k): use VerifyPeerCertificate/VerifyConnection to check that the
 		// incoming client is allowed to access metrics. Currently we allow
 		// anyone/anything with a valid cluster certificate to access them.
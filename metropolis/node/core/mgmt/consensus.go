@@ -0,0 +1,40 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package mgmt
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/node/core/consensus"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+func (s *Service) ForceNewConsensus(ctx context.Context, req *apb.ForceNewConsensusRequest) (*apb.ForceNewConsensusResponse, error) {
+	if req.NodeId != s.NodeCredentials.ID() {
+		return nil, status.Errorf(codes.InvalidArgument, "node_id must be set to the ID of this node (%s)", s.NodeCredentials.ID())
+	}
+	if s.LocalConsensus == nil {
+		return nil, status.Error(codes.Unimplemented, "consensus not available on this node")
+	}
+	con, err := s.LocalConsensus(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get local consensus service: %v", err)
+	}
+	if con == nil {
+		return nil, status.Error(codes.FailedPrecondition, "this node is not a consensus member")
+	}
+	err = con.ForceNewCluster(ctx, req.BypassQuorumCheck)
+	if errors.Is(err, consensus.ErrHasQuorum) {
+		return nil, status.Error(codes.FailedPrecondition, "consensus still has quorum, refusing to force a new cluster")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not force new cluster: %v", err)
+	}
+	return &apb.ForceNewConsensusResponse{}, nil
+}
@@ -14,25 +14,60 @@ import (
 	"google.golang.org/grpc"
 
 	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/consensus"
 	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/metropolis/node/core/update"
 	"source.monogon.dev/osbase/logtree"
 	"source.monogon.dev/osbase/supervisor"
 
 	apb "source.monogon.dev/metropolis/proto/api"
+	netpb "source.monogon.dev/osbase/net/proto"
 )
 
+// NetworkConfigurator is the part of network.Service used by
+// NodeManagement.SetNetworkConfig.
+type NetworkConfigurator interface {
+	SetStaticConfig(ctx context.Context, config *netpb.Net) error
+	CurrentStaticConfig() *netpb.Net
+}
+
 // Service implements metropolis.proto.api.NodeManagement.
 type Service struct {
 	// NodeCredentials used to set up gRPC server.
 	NodeCredentials *identity.NodeCredentials
+	// Revocations used to reject revoked certificates in the gRPC server.
+	Revocations *identity.Revocations
 	// LogTree from which NodeManagement.Logs will be served.
 	LogTree *logtree.LogTree
 	// Update service handle for performing updates via the API.
 	UpdateService *update.Service
+	// LocalConsensus returns the consensus service running on this node for
+	// NodeManagement.ForceNewConsensus, or nil if this node is not a consensus
+	// member.
+	LocalConsensus func(ctx context.Context) (*consensus.Service, error)
+	// Runnables is the state of the supervision tree of this node, served by
+	// NodeManagement.GetRunnables.
+	Runnables *supervisor.InMemoryMetrics
+	// Network service of this node, reconfigured by
+	// NodeManagement.SetNetworkConfig.
+	Network NetworkConfigurator
+	// ESP to which network configurations accepted by
+	// NodeManagement.SetNetworkConfig are persisted.
+	ESP *localstorage.ESPMetropolisDirectory
+	// CuratorReachable blocks until a curator running on another node can be
+	// reached from this node, or returns an error if ctx is canceled before or
+	// there is no other node to reach. It is used by
+	// NodeManagement.SetNetworkConfig to confirm a new network configuration.
+	CuratorReachable func(ctx context.Context) error
+	// ReportAudit records calls to audited NodeManagement methods in the
+	// cluster audit log. If nil, calls are not audited.
+	ReportAudit func(ctx context.Context, entry *apb.AuditEntry) error
 	// Serialized UpdateNode RPCs
 	updateMutex sync.Mutex
+	// Serialized SetNetworkConfig RPCs
+	networkMutex sync.Mutex
 
 	// Automatically populated on Run.
 	LogService
@@ -51,9 +86,20 @@ func (s *Service) Run(ctx context.Context) error {
 
 	sec := rpc.ServerSecurity{
 		NodeCredentials: s.NodeCredentials,
+		Revocations:     s.Revocations,
 	}
 	logger := supervisor.MustSubLogger(ctx, "rpc")
 	opts := sec.GRPCOptions(logger)
+	if s.ReportAudit != nil {
+		// Reporting is best-effort, as node management must keep working while
+		// the curator is unreachable.
+		opts = append(opts, rpc.AuditOptions(logger, func(ctx context.Context, entry *apb.AuditEntry) error {
+			if err := s.ReportAudit(ctx, entry); err != nil {
+				logger.Warningf("Could not report audit entry for call to %s by %s: %v", entry.Method, entry.Identity, err)
+			}
+			return nil
+		})...)
+	}
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", node.NodeManagementPort))
 	if err != nil {
 		return fmt.Errorf("failed to listen on node management socket socket: %w", err)
@@ -0,0 +1,138 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package mgmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/network"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	netpb "source.monogon.dev/osbase/net/proto"
+)
+
+// defaultNetworkConfirmTimeout is used for SetNetworkConfig calls which do
+// not specify a confirm_timeout.
+const defaultNetworkConfirmTimeout = time.Minute
+
+// networkRevertTimeout is the time SetNetworkConfig waits for the previous
+// configuration to be reapplied after the new one failed. It is a variable
+// for tests.
+var networkRevertTimeout = time.Minute
+
+func (s *Service) SetNetworkConfig(ctx context.Context, req *apb.SetNetworkConfigRequest) (*apb.SetNetworkConfigResponse, error) {
+	if s.Network == nil || s.CuratorReachable == nil || s.ESP == nil {
+		return nil, status.Error(codes.Unimplemented, "network configuration not available on this node")
+	}
+	ok := s.networkMutex.TryLock()
+	if ok {
+		defer s.networkMutex.Unlock()
+	} else {
+		return nil, status.Error(codes.Aborted, "another SetNetworkConfig RPC is in progress on this node")
+	}
+	timeout := defaultNetworkConfirmTimeout
+	if req.ConfirmTimeout != nil {
+		if err := req.ConfirmTimeout.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid confirm_timeout: %v", err)
+		}
+		timeout = req.ConfirmTimeout.AsDuration()
+	}
+	if err := network.ValidateStaticConfig(req.NetworkConfig); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// The new configuration might break the connection over which this call
+	// was made, which must not interrupt confirming or reverting it.
+	ctx = context.WithoutCancel(ctx)
+	logger := s.LogTree.MustLeveledFor("root.mgmt.network")
+
+	prev := s.Network.CurrentStaticConfig()
+	logger.Infof("Applying new network configuration, confirming within %s...", timeout)
+	if err := s.applyNetworkConfig(ctx, req.NetworkConfig, timeout); err != nil {
+		logger.Errorf("New network configuration failed, reverting: %v", err)
+		// If the previous configuration does not apply in time, the network
+		// service keeps retrying in the background.
+		rctx, cancel := context.WithTimeout(ctx, networkRevertTimeout)
+		defer cancel()
+		if rerr := s.Network.SetStaticConfig(rctx, prev); rerr != nil {
+			logger.Errorf("Failed to revert to previous network configuration: %v", rerr)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "new network configuration reverted: %v", err)
+	}
+
+	if err := s.persistNetworkConfig(req.NetworkConfig); err != nil {
+		logger.Errorf("Failed to persist new network configuration: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "new network configuration applied, but could not be persisted: %v", err)
+	}
+	logger.Infof("New network configuration confirmed and persisted.")
+	return &apb.SetNetworkConfigResponse{}, nil
+}
+
+// applyNetworkConfig applies the given network configuration and waits until
+// the curator can be reached using it.
+func (s *Service) applyNetworkConfig(ctx context.Context, config *netpb.Net, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := s.Network.SetStaticConfig(ctx, config); err != nil {
+		return fmt.Errorf("could not apply configuration: %w", err)
+	}
+	if err := s.CuratorReachable(ctx); err != nil {
+		return fmt.Errorf("curator not reachable: %w", err)
+	}
+	return nil
+}
+
+// persistNetworkConfig writes the given network configuration to the ESP, so
+// that it is used on subsequent boots. Both files are written atomically via a
+// temporary file, and the node parameters last, so that a crash leaves behind
+// either the previous or the new configuration.
+func (s *Service) persistNetworkConfig(config *netpb.Net) error {
+	// The network configuration from the node parameters takes precedence on
+	// boot, so it needs to be replaced as well. They are read before writing
+	// anything, so that a failure to read them does not leave the ESP
+	// half-updated.
+	var params *apb.NodeParameters
+	exists, err := s.ESP.NodeParameters.Exists()
+	if err != nil {
+		return fmt.Errorf("while checking for node parameters: %w", err)
+	}
+	// Nodes might not have node parameters on the ESP, eg. when they are
+	// provided through qemu fwcfg.
+	if exists {
+		params, err = s.ESP.NodeParameters.Unmarshal()
+		if err != nil {
+			return fmt.Errorf("while reading node parameters: %w", err)
+		}
+	}
+
+	if config == nil {
+		err := os.Remove(s.ESP.NetworkConfiguration.FullPath())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("while removing network configuration: %w", err)
+		}
+	} else if err := s.ESP.NetworkConfiguration.Marshal(config); err != nil {
+		return err
+	}
+
+	if params == nil || params.NetworkConfig == nil {
+		return nil
+	}
+	params.NetworkConfig = config
+	paramsRaw, err := proto.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("while marshaling node parameters: %w", err)
+	}
+	if err := s.ESP.NodeParameters.Write(paramsRaw, 0644); err != nil {
+		return fmt.Errorf("while writing node parameters: %w", err)
+	}
+	return nil
+}
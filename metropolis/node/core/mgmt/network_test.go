@@ -0,0 +1,185 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package mgmt
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
+	"source.monogon.dev/osbase/logtree"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	netpb "source.monogon.dev/osbase/net/proto"
+)
+
+// fakeNetwork is a NetworkConfigurator which records the configurations
+// applied to it.
+type fakeNetwork struct {
+	mu      sync.Mutex
+	current *netpb.Net
+	applied []*netpb.Net
+	// apply, if set, is called for every configuration to be applied and
+	// returns the error with which applying it fails.
+	apply func(ctx context.Context, config *netpb.Net) error
+}
+
+func (f *fakeNetwork) SetStaticConfig(ctx context.Context, config *netpb.Net) error {
+	f.mu.Lock()
+	f.current = config
+	f.applied = append(f.applied, config)
+	f.mu.Unlock()
+	if f.apply != nil {
+		return f.apply(ctx, config)
+	}
+	return nil
+}
+
+func (f *fakeNetwork) CurrentStaticConfig() *netpb.Net {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
+
+func nameserverConfig(ip string) *netpb.Net {
+	return &netpb.Net{Nameserver: []*netpb.Nameserver{{Ip: ip}}}
+}
+
+func TestSetNetworkConfig(t *testing.T) {
+	prev := nameserverConfig("192.0.2.1")
+	next := nameserverConfig("192.0.2.2")
+	unreachable := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	hangOnRevert := func(ctx context.Context, config *netpb.Net) error {
+		if proto.Equal(config, prev) {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	for _, te := range []struct {
+		name string
+		// params are the node parameters on the ESP, if any.
+		params    []byte
+		reachable func(ctx context.Context) error
+		apply     func(ctx context.Context, config *netpb.Net) error
+		// want is the expected status code of the call, and applied the
+		// configurations expected to be applied in order.
+		want    codes.Code
+		applied []*netpb.Net
+		// persisted is whether next is expected to be persisted.
+		persisted bool
+	}{
+		{
+			name:      "confirmed",
+			want:      codes.OK,
+			applied:   []*netpb.Net{next},
+			persisted: true,
+		},
+		{
+			name:      "confirmed with node parameters",
+			params:    mustMarshal(t, &apb.NodeParameters{NetworkConfig: prev}),
+			want:      codes.OK,
+			applied:   []*netpb.Net{next},
+			persisted: true,
+		},
+		{
+			name:      "curator unreachable",
+			reachable: unreachable,
+			want:      codes.FailedPrecondition,
+			applied:   []*netpb.Net{next, prev},
+		},
+		{
+			name:      "revert hangs",
+			reachable: unreachable,
+			apply:     hangOnRevert,
+			want:      codes.FailedPrecondition,
+			applied:   []*netpb.Net{next, prev},
+		},
+		{
+			name:    "corrupted node parameters",
+			params:  []byte("definitely not a protobuf"),
+			want:    codes.Unavailable,
+			applied: []*netpb.Net{next},
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			networkRevertTimeout = 100 * time.Millisecond
+
+			var esp localstorage.ESPMetropolisDirectory
+			if err := declarative.PlaceFS(&esp, t.TempDir()); err != nil {
+				t.Fatalf("PlaceFS: %v", err)
+			}
+			if te.params != nil {
+				if err := esp.NodeParameters.Write(te.params, 0644); err != nil {
+					t.Fatalf("Writing node parameters failed: %v", err)
+				}
+			}
+			reachable := te.reachable
+			if reachable == nil {
+				reachable = func(context.Context) error { return nil }
+			}
+			net := &fakeNetwork{current: prev, apply: te.apply}
+			s := &Service{
+				LogTree:          logtree.New(),
+				Network:          net,
+				ESP:              &esp,
+				CuratorReachable: reachable,
+			}
+
+			_, err := s.SetNetworkConfig(context.Background(), &apb.SetNetworkConfigRequest{
+				NetworkConfig:  next,
+				ConfirmTimeout: durationpb.New(100 * time.Millisecond),
+			})
+			if got := status.Code(err); got != te.want {
+				t.Fatalf("SetNetworkConfig returned %v, wanted %s", err, te.want)
+			}
+			if len(net.applied) != len(te.applied) {
+				t.Fatalf("Applied %d configurations, wanted %d", len(net.applied), len(te.applied))
+			}
+			for i := range te.applied {
+				if !proto.Equal(net.applied[i], te.applied[i]) {
+					t.Errorf("Configuration %d is %v, wanted %v", i, net.applied[i], te.applied[i])
+				}
+			}
+
+			persisted, err := esp.NetworkConfiguration.Unmarshal()
+			if err != nil {
+				t.Fatalf("Reading persisted configuration failed: %v", err)
+			}
+			if got := proto.Equal(persisted, next); got != te.persisted {
+				t.Errorf("Persisted configuration is %v, wanted persisted: %v", persisted, te.persisted)
+			}
+			if te.persisted && te.params != nil {
+				params, err := esp.NodeParameters.Unmarshal()
+				if err != nil {
+					t.Fatalf("Reading node parameters failed: %v", err)
+				}
+				if !proto.Equal(params.NetworkConfig, next) {
+					t.Errorf("Node parameters contain %v, wanted %v", params.NetworkConfig, next)
+				}
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package mgmt
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/osbase/supervisor"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var runnableStates = map[supervisor.NodeState]apb.GetRunnablesResponse_Runnable_State{
+	supervisor.NodeStateNew:      apb.GetRunnablesResponse_Runnable_STATE_NEW,
+	supervisor.NodeStateHealthy:  apb.GetRunnablesResponse_Runnable_STATE_HEALTHY,
+	supervisor.NodeStateDead:     apb.GetRunnablesResponse_Runnable_STATE_DEAD,
+	supervisor.NodeStateDone:     apb.GetRunnablesResponse_Runnable_STATE_DONE,
+	supervisor.NodeStateCanceled: apb.GetRunnablesResponse_Runnable_STATE_CANCELED,
+}
+
+func (s *Service) GetRunnables(ctx context.Context, req *apb.GetRunnablesRequest) (*apb.GetRunnablesResponse, error) {
+	if s.Runnables == nil {
+		return nil, status.Error(codes.Unimplemented, "supervision tree state not available on this node")
+	}
+	if strings.HasPrefix(req.Dn, ".") || strings.HasSuffix(req.Dn, ".") {
+		return nil, status.Error(codes.InvalidArgument, "dn must not start or end with a dot")
+	}
+
+	now := time.Now()
+	res := &apb.GetRunnablesResponse{}
+	for dn, st := range s.Runnables.DNs() {
+		if req.Dn != "" && dn != req.Dn && !strings.HasPrefix(dn, req.Dn+".") {
+			continue
+		}
+		r := &apb.GetRunnablesResponse_Runnable{
+			Dn:          dn,
+			State:       runnableStates[st.State],
+			Restarts:    st.Restarts,
+			TimeInState: durationpb.New(now.Sub(st.Transition)),
+		}
+		if st.LastError != nil {
+			r.LastError = st.LastError.Error()
+			r.LastErrorTime = timestamppb.New(st.LastErrorTime)
+		}
+		res.Runnables = append(res.Runnables, r)
+	}
+	// Sort by DN parts instead of the DN string, so that every runnable
+	// directly follows its parent even if a sibling of the parent has the
+	// parent's name as a prefix.
+	slices.SortFunc(res.Runnables, func(a, b *apb.GetRunnablesResponse_Runnable) int {
+		return slices.Compare(strings.Split(a.Dn, "."), strings.Split(b.Dn, "."))
+	})
+	return res, nil
+}
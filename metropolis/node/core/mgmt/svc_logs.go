@@ -5,6 +5,7 @@ package mgmt
 
 import (
 	"errors"
+	"regexp"
 	"strings"
 
 	"google.golang.org/grpc/codes"
@@ -18,6 +19,9 @@ import (
 
 const (
 	logFilterMax = 10
+	// logRegexpMaxLength is the maximum length of a regexp in a MessageRegexp
+	// filter.
+	logRegexpMaxLength = 1024
 )
 
 // LogService implements NodeManagement.Logs. This is split away from the rest of
@@ -102,6 +106,10 @@ func (s *LogService) Logs(req *api.LogsRequest, srv api.NodeManagement_LogsServe
 		options = append(options, logtree.WithStream())
 	}
 
+	if len(req.BootId) != 0 {
+		options = append(options, logtree.FromBoot(req.BootId))
+	}
+
 	// Parse proto filters into logtree options.
 	for i, filter := range req.Filters {
 		switch inner := filter.Filter.(type) {
@@ -117,6 +125,33 @@ func (s *LogService) Logs(req *api.LogsRequest, srv api.NodeManagement_LogsServe
 				return status.Errorf(codes.InvalidArgument, "filter %d has invalid severity: %v", i, err)
 			}
 			options = append(options, logtree.LeveledWithMinimumSeverity(severity))
+		case *cpb.LogFilter_TimeRange_:
+			tr := inner.TimeRange
+			if tr.Since != nil {
+				if err := tr.Since.CheckValid(); err != nil {
+					return status.Errorf(codes.InvalidArgument, "filter %d has invalid since: %v", i, err)
+				}
+				options = append(options, logtree.Since(tr.Since.AsTime()))
+			}
+			if tr.Until != nil {
+				if err := tr.Until.CheckValid(); err != nil {
+					return status.Errorf(codes.InvalidArgument, "filter %d has invalid until: %v", i, err)
+				}
+				options = append(options, logtree.Until(tr.Until.AsTime()))
+			}
+		case *cpb.LogFilter_MessageRegexp_:
+			expr := inner.MessageRegexp.Regexp
+			if len(expr) > logRegexpMaxLength {
+				return status.Errorf(codes.InvalidArgument, "filter %d has regexp longer than %d bytes", i, logRegexpMaxLength)
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "filter %d has invalid regexp: %v", i, err)
+			}
+			options = append(options, logtree.MessageMatching(re))
+		case *cpb.LogFilter_MessageSubstring_:
+			re := regexp.MustCompile(regexp.QuoteMeta(inner.MessageSubstring.Substring))
+			options = append(options, logtree.MessageMatching(re))
 		}
 	}
 
@@ -125,6 +160,10 @@ func (s *LogService) Logs(req *api.LogsRequest, srv api.NodeManagement_LogsServe
 	case err == nil:
 	case errors.Is(err, logtree.ErrRawAndLeveled):
 		return status.Errorf(codes.InvalidArgument, "requested only raw and only leveled logs simultaneously")
+	case errors.Is(err, logtree.ErrStreamFromBoot):
+		return status.Errorf(codes.InvalidArgument, "cannot stream logs from a previous boot")
+	case errors.Is(err, logtree.ErrUnknownBoot):
+		return status.Errorf(codes.NotFound, "no logs available for the requested boot")
 	default:
 		return status.Errorf(codes.Unavailable, "could not retrieve logs: %v", err)
 	}
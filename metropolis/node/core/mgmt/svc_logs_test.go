@@ -18,6 +18,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"source.monogon.dev/metropolis/proto/api"
 	cpb "source.monogon.dev/metropolis/proto/common"
@@ -288,6 +289,7 @@ func TestLogService_Logs_Filters(t *testing.T) {
 	s, cl := dut(t)
 
 	mgmt := api.NewNodeManagementClient(cl)
+	start := time.Now()
 	s.LogTree.MustLeveledFor("main").Infof("Hello")
 	s.LogTree.MustLeveledFor("main").Infof("Starting...")
 	s.LogTree.MustLeveledFor("main").Warningf("Something failed!")
@@ -358,6 +360,88 @@ func TestLogService_Logs_Filters(t *testing.T) {
 				mkLeveledEntry("main", "e", "Something failed very hard!"),
 			},
 		},
+		// Case 3: request time range
+		{
+			req: &api.LogsRequest{
+				Dn:           "main",
+				BacklogMode:  api.LogsRequest_BACKLOG_MODE_COUNT,
+				BacklogCount: 2,
+				StreamMode:   api.LogsRequest_STREAM_MODE_DISABLE,
+				Filters: []*cpb.LogFilter{
+					{
+						Filter: &cpb.LogFilter_TimeRange_{
+							TimeRange: &cpb.LogFilter_TimeRange{
+								Since: timestamppb.New(start),
+								Until: timestamppb.New(start.Add(time.Hour)),
+							},
+						},
+					},
+				},
+			},
+			want: []*lpb.LogEntry{
+				mkLeveledEntry("main", "w", "Something failed!"),
+				mkLeveledEntry("main", "e", "Something failed very hard!"),
+			},
+		},
+		// Case 4: request time range before all entries
+		{
+			req: &api.LogsRequest{
+				Dn:          "main",
+				BacklogMode: api.LogsRequest_BACKLOG_MODE_ALL,
+				StreamMode:  api.LogsRequest_STREAM_MODE_DISABLE,
+				Filters: []*cpb.LogFilter{
+					{
+						Filter: &cpb.LogFilter_TimeRange_{
+							TimeRange: &cpb.LogFilter_TimeRange{
+								Until: timestamppb.New(start),
+							},
+						},
+					},
+				},
+			},
+			want: nil,
+		},
+		// Case 5: request regexp
+		{
+			req: &api.LogsRequest{
+				Dn:          "main",
+				BacklogMode: api.LogsRequest_BACKLOG_MODE_ALL,
+				StreamMode:  api.LogsRequest_STREAM_MODE_DISABLE,
+				Filters: []*cpb.LogFilter{
+					{
+						Filter: &cpb.LogFilter_MessageRegexp_{
+							MessageRegexp: &cpb.LogFilter_MessageRegexp{
+								Regexp: "^(Hello|medium)",
+							},
+						},
+					},
+				},
+			},
+			want: []*lpb.LogEntry{
+				mkLeveledEntry("main", "i", "Hello"),
+				mkRawEntry("main", "medium rare"),
+			},
+		},
+		// Case 6: request substring, which is not a regexp
+		{
+			req: &api.LogsRequest{
+				Dn:          "main",
+				BacklogMode: api.LogsRequest_BACKLOG_MODE_ALL,
+				StreamMode:  api.LogsRequest_STREAM_MODE_DISABLE,
+				Filters: []*cpb.LogFilter{
+					{
+						Filter: &cpb.LogFilter_MessageSubstring_{
+							MessageSubstring: &cpb.LogFilter_MessageSubstring{
+								Substring: "...",
+							},
+						},
+					},
+				},
+			},
+			want: []*lpb.LogEntry{
+				mkLeveledEntry("main", "i", "Starting..."),
+			},
+		},
 	} {
 		srv, err := mgmt.Logs(ctx, te.req)
 		if err != nil {
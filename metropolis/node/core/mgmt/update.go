@@ -5,15 +5,26 @@ package mgmt
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"source.monogon.dev/metropolis/node/core/update"
+
 	apb "source.monogon.dev/metropolis/proto/api"
 )
 
 func (s *Service) UpdateNode(ctx context.Context, req *apb.UpdateNodeRequest) (*apb.UpdateNodeResponse, error) {
+	return s.InstallUpdate(ctx, req, "")
+}
+
+// InstallUpdate implements UpdateNode. The given updateID, if any, identifies
+// an update requested by the cluster as part of a rollout, and is reported back
+// to the cluster if the node rolls back the update.
+func (s *Service) InstallUpdate(ctx context.Context, req *apb.UpdateNodeRequest, updateID string) (*apb.UpdateNodeResponse, error) {
 	ok := s.updateMutex.TryLock()
 	if ok {
 		defer s.updateMutex.Unlock()
@@ -24,8 +35,18 @@ func (s *Service) UpdateNode(ctx context.Context, req *apb.UpdateNodeRequest) (*
 		return nil, status.Errorf(codes.InvalidArgument, "activation_mode needs to be explicitly specified")
 	}
 	if err := s.UpdateService.InstallImage(ctx, req.OsImage, req.ActivationMode == apb.ActivationMode_ACTIVATION_MODE_KEXEC); err != nil {
+		if errors.Is(err, update.ErrImageNotTrusted) {
+			return nil, status.Errorf(codes.PermissionDenied, "rejected OS image: %v", err)
+		}
 		return nil, status.Errorf(codes.Unavailable, "error installing update: %v", err)
 	}
+	var healthGateTimeout time.Duration
+	if req.HealthGateTimeout != nil {
+		healthGateTimeout = req.HealthGateTimeout.AsDuration()
+	}
+	if err := s.UpdateService.ArmHealthGate(healthGateTimeout, updateID); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "error arming health gate: %v", err)
+	}
 	if req.ActivationMode != apb.ActivationMode_ACTIVATION_MODE_NONE {
 
 		methodString, method := "reboot", unix.LINUX_REBOOT_CMD_RESTART
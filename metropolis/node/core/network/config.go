@@ -0,0 +1,139 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"source.monogon.dev/osbase/supervisor"
+
+	netpb "source.monogon.dev/osbase/net/proto"
+)
+
+// configRequest is a network configuration to be applied by the config
+// runnable.
+type configRequest struct {
+	// static is the static network configuration to apply, or nil if
+	// autoconfiguration should be used.
+	static *netpb.Net
+
+	// done is closed once the configuration has been applied for the first
+	// time, or failed to apply. err is set to the error with which it failed,
+	// if any.
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error
+}
+
+func newConfigRequest(static *netpb.Net) *configRequest {
+	return &configRequest{
+		static: static,
+		done:   make(chan struct{}),
+	}
+}
+
+// finish marks the configuration as applied, or as failed to apply if err is
+// set. Only the first call has any effect.
+func (r *configRequest) finish(err error) {
+	r.doneOnce.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
+
+// initConfig sets the initially requested configuration from StaticConfig.
+func (s *Service) initConfig() {
+	s.configOnce.Do(func() {
+		s.config.Set(newConfigRequest(s.StaticConfig))
+	})
+}
+
+// ValidateStaticConfig returns an error if the given static network
+// configuration is structurally invalid, eg. references undefined interfaces.
+// A configuration passing validation can still fail to apply, eg. if a
+// referenced device does not exist.
+func ValidateStaticConfig(config *netpb.Net) error {
+	if config == nil {
+		return nil
+	}
+	if _, err := getSortedIfaces(config); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// SetStaticConfig replaces the network configuration of the node at runtime.
+// If config is nil, autoconfiguration is used instead. The previous
+// configuration is torn down before the new one is applied.
+//
+// It blocks until the new configuration has been applied, or returns the
+// error with which applying it failed. In the latter case, the service keeps
+// retrying to apply it until it is replaced by another call, eg. to revert to
+// the previous configuration as returned by CurrentStaticConfig.
+func (s *Service) SetStaticConfig(ctx context.Context, config *netpb.Net) error {
+	if err := ValidateStaticConfig(config); err != nil {
+		return err
+	}
+	s.initConfig()
+	req := newConfigRequest(config)
+	s.config.Set(req)
+
+	select {
+	case <-req.done:
+		return req.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CurrentStaticConfig returns the static network configuration last requested
+// either through StaticConfig or SetStaticConfig, or nil if
+// autoconfiguration is used.
+func (s *Service) CurrentStaticConfig() *netpb.Net {
+	s.initConfig()
+	w := s.config.Watch()
+	defer w.Close()
+	// The value is always set by initConfig, so this does not block.
+	req, _ := w.Get(context.Background())
+	return req.static
+}
+
+// runConfig applies the currently requested network configuration, and
+// restarts to apply a new configuration whenever it changes.
+func (s *Service) runConfig(ctx context.Context) error {
+	s.initConfig()
+	w := s.config.Watch()
+	defer w.Close()
+
+	req, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	// DNS servers of the previous configuration are not valid anymore, the
+	// new one provides its own.
+	s.resetDNSServers()
+	if req.static == nil {
+		err = supervisor.Run(ctx, "dynamic", func(ctx context.Context) error {
+			err := s.runDynamicConfig(ctx)
+			req.finish(err)
+			return err
+		})
+	} else {
+		err = supervisor.Run(ctx, "static", func(ctx context.Context) error {
+			return s.runStaticConfig(ctx, req)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	if _, err := w.Get(ctx); err != nil {
+		return err
+	}
+	supervisor.Logger(ctx).Infof("Configuration changed, restarting...")
+	return fmt.Errorf("config changed, restarting")
+}
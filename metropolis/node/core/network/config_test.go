@@ -0,0 +1,91 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	netpb "source.monogon.dev/osbase/net/proto"
+)
+
+// TestSetStaticConfig exercises the handoff of configurations between
+// SetStaticConfig and the config runnable, which is simulated by finishing
+// requests directly.
+func TestSetStaticConfig(t *testing.T) {
+	ctx := context.Background()
+	initial := &netpb.Net{Nameserver: []*netpb.Nameserver{{Ip: "192.0.2.1"}}}
+	s := &Service{StaticConfig: initial}
+	if got := s.CurrentStaticConfig(); !proto.Equal(got, initial) {
+		t.Errorf("CurrentStaticConfig is %v, wanted %v", got, initial)
+	}
+
+	w := s.config.Watch()
+	defer w.Close()
+	if _, err := w.Get(ctx); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// set calls SetStaticConfig with the given context and returns the
+	// resulting request together with a channel receiving the result of the
+	// call.
+	set := func(ctx context.Context, config *netpb.Net) (*configRequest, <-chan error) {
+		t.Helper()
+		res := make(chan error, 1)
+		go func() {
+			res <- s.SetStaticConfig(ctx, config)
+		}()
+		req, err := w.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !proto.Equal(req.static, config) {
+			t.Errorf("Requested configuration is %v, wanted %v", req.static, config)
+		}
+		return req, res
+	}
+
+	// A configuration which applies.
+	next := &netpb.Net{Nameserver: []*netpb.Nameserver{{Ip: "192.0.2.2"}}}
+	req, res := set(ctx, next)
+	req.finish(nil)
+	if err := <-res; err != nil {
+		t.Errorf("SetStaticConfig: %v", err)
+	}
+	if got := s.CurrentStaticConfig(); !proto.Equal(got, next) {
+		t.Errorf("CurrentStaticConfig is %v, wanted %v", got, next)
+	}
+
+	// A configuration which fails to apply.
+	errApply := errors.New("device not found")
+	req, res = set(ctx, initial)
+	req.finish(errApply)
+	if err := <-res; !errors.Is(err, errApply) {
+		t.Errorf("SetStaticConfig returned %v, wanted %v", err, errApply)
+	}
+
+	// A configuration which does not apply in time remains requested.
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, res = set(tctx, nil)
+	if err := <-res; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetStaticConfig returned %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	if got := s.CurrentStaticConfig(); got != nil {
+		t.Errorf("CurrentStaticConfig is %v, wanted autoconfiguration", got)
+	}
+
+	// Invalid configurations are rejected without being requested.
+	invalid := &netpb.Net{Interface: []*netpb.Interface{{Name: "invalid/name"}}}
+	if err := s.SetStaticConfig(ctx, invalid); err == nil {
+		t.Errorf("SetStaticConfig of invalid configuration succeeded")
+	}
+	if got := s.CurrentStaticConfig(); got != nil {
+		t.Errorf("CurrentStaticConfig is %v after invalid configuration, wanted autoconfiguration", got)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package callback contains minimal callbacks for configuring the kernel with
+// information received over DHCPv6.
+//
+// These directly configure the relevant kernel subsytems and need to own
+// certain parts of them as documented on a per- callback basis to make sure
+// that they can recover from restarts and crashes of the DHCPv6 client.
+package callback
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c"
+)
+
+// Compose can be used to chain multiple callbacks
+func Compose(callbacks ...dhcp6c.LeaseCallback) dhcp6c.LeaseCallback {
+	return func(lease *dhcp6c.Lease) error {
+		for _, cb := range callbacks {
+			if err := cb(lease); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// lifetimeSecs converts an absolute expiry time into a remaining lifetime in
+// seconds as used by netlink. Lifetimes are clamped just below infinity, as
+// the kernel marks addresses with an infinite lifetime as permanent which
+// would make them indistinguishable from statically configured ones.
+func lifetimeSecs(until time.Time) int {
+	secs := math.Ceil(time.Until(until).Seconds())
+	if secs < 0 {
+		return 0
+	}
+	if secs >= math.MaxUint32 {
+		return math.MaxUint32 - 1
+	}
+	return int(secs)
+}
+
+// isDHCPAddr returns true if the given address could have been configured by
+// ManageAddresses.
+func isDHCPAddr(addr *netlink.Addr) bool {
+	if addr.Flags&unix.IFA_F_PERMANENT != 0 {
+		return false
+	}
+	if ones, bits := addr.Mask.Size(); ones != 128 || bits != 128 {
+		return false
+	}
+	return addr.IP.IsGlobalUnicast()
+}
+
+// ManageAddresses sets up and tears down the non-temporary addresses assigned
+// via DHCPv6. It takes exclusive ownership of all global IPv6 /128 addresses
+// on the given interface which do not have IFA_F_PERMANENT set, so it's not
+// possible to run multiple stateful DHCPv6 clients on a single interface.
+// Addresses configured via SLAAC are never /128 and are thus not affected.
+func ManageAddresses(iface netlink.Link) dhcp6c.LeaseCallback {
+	return func(lease *dhcp6c.Lease) error {
+		var newAddrs []dhcp6c.Address
+		if lease != nil {
+			newAddrs = lease.Addresses
+		}
+
+		addrs, err := netlink.AddrList(iface, netlink.FAMILY_V6)
+		if err != nil {
+			return fmt.Errorf("netlink failed to list addresses: %w", err)
+		}
+
+		for _, addr := range addrs {
+			if !isDHCPAddr(&addr) {
+				continue
+			}
+			// Don't touch addresses which are still assigned as AddrReplace
+			// will atomically update their lifetimes.
+			var found bool
+			for _, newAddr := range newAddrs {
+				if newAddr.IP.Equal(addr.IP) {
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+			if err := netlink.AddrDel(iface, &addr); !os.IsNotExist(err) && err != nil {
+				return fmt.Errorf("failed to delete address: %w", err)
+			}
+		}
+
+		for _, a := range newAddrs {
+			if err := netlink.AddrReplace(iface, &netlink.Addr{
+				IPNet:       &net.IPNet{IP: a.IP, Mask: net.CIDRMask(128, 128)},
+				ValidLft:    lifetimeSecs(a.ValidUntil),
+				PreferedLft: lifetimeSecs(a.PreferredUntil),
+				// On-link prefixes are announced via Router Advertisements,
+				// a prefix route for a single address is useless.
+				Flags: unix.IFA_F_NOPREFIXROUTE,
+			}); err != nil {
+				return fmt.Errorf("failed to update address %s: %w", a.IP, err)
+			}
+		}
+		return nil
+	}
+}
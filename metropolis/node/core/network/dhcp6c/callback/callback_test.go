@@ -0,0 +1,120 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c"
+)
+
+func trivialLeaseFromIPs(ips ...net.IP) *dhcp6c.Lease {
+	lease := &dhcp6c.Lease{
+		ExpiresAt: time.Now().Add(60 * time.Second),
+	}
+	for _, ip := range ips {
+		lease.Addresses = append(lease.Addresses, dhcp6c.Address{
+			IP:             ip,
+			PreferredUntil: time.Now().Add(30 * time.Second),
+			ValidUntil:     time.Now().Add(60 * time.Second),
+		})
+	}
+	return lease
+}
+
+var (
+	testIP1      = net.ParseIP("2001:db8::1")
+	testIP2      = net.ParseIP("2001:db8::2")
+	testHostNet1 = net.IPNet{IP: testIP1, Mask: net.CIDRMask(128, 128)}
+	testHostNet2 = net.IPNet{IP: testIP2, Mask: net.CIDRMask(128, 128)}
+	testSLAACNet = net.IPNet{IP: net.ParseIP("2001:db8:1::1"), Mask: net.CIDRMask(64, 128)}
+)
+
+func TestManageAddressesCallback(t *testing.T) {
+	if os.Getenv("IN_KTEST") != "true" {
+		t.Skip("Not in ktest")
+	}
+
+	var tests = []struct {
+		name         string
+		initialAddrs []netlink.Addr
+		newLease     *dhcp6c.Lease
+		expectedIPs  []string
+	}{
+		// Lifetimes are necessary, otherwise the Kernel sets the
+		// IFA_F_PERMANENT flag behind our back.
+		{
+			name:         "RemoveOldIPs",
+			initialAddrs: []netlink.Addr{{IPNet: &testHostNet1, ValidLft: 60, PreferedLft: 60}},
+			newLease:     nil,
+			expectedIPs:  nil,
+		},
+		{
+			name:         "IgnoresPermanentAndSLAACIPs",
+			initialAddrs: []netlink.Addr{{IPNet: &testHostNet1, Flags: unix.IFA_F_PERMANENT}, {IPNet: &testSLAACNet, ValidLft: 60, PreferedLft: 60}},
+			newLease:     trivialLeaseFromIPs(testIP2),
+			expectedIPs:  []string{"2001:db8:1::1/64", "2001:db8::1/128", "2001:db8::2/128"},
+		},
+		{
+			name:         "AssignsNewIPs",
+			initialAddrs: []netlink.Addr{},
+			newLease:     trivialLeaseFromIPs(testIP1, testIP2),
+			expectedIPs:  []string{"2001:db8::1/128", "2001:db8::2/128"},
+		},
+		{
+			name:         "UpdatesIPs",
+			initialAddrs: []netlink.Addr{{IPNet: &testHostNet1, ValidLft: 60, PreferedLft: 60}, {IPNet: &testHostNet2, ValidLft: 60, PreferedLft: 60}},
+			newLease:     trivialLeaseFromIPs(testIP2),
+			expectedIPs:  []string{"2001:db8::2/128"},
+		},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testLink := &netlink.Dummy{
+				LinkAttrs: netlink.LinkAttrs{
+					Name:  fmt.Sprintf("maddr-test-%d", i),
+					Flags: unix.IFF_UP,
+				},
+			}
+			if err := netlink.LinkAdd(testLink); err != nil {
+				t.Fatalf("test cannot set up network interface: %v", err)
+			}
+			defer netlink.LinkDel(testLink)
+			for _, addr := range test.initialAddrs {
+				if err := netlink.AddrAdd(testLink, &addr); err != nil {
+					t.Fatalf("test cannot set up initial addrs: %v", err)
+				}
+			}
+			cb := ManageAddresses(testLink)
+			if err := cb(test.newLease); err != nil {
+				t.Fatalf("callback returned an error: %v", err)
+			}
+			addrs, err := netlink.AddrList(testLink, netlink.FAMILY_V6)
+			if err != nil {
+				t.Fatalf("test cannot read back addrs from interface: %v", err)
+			}
+			var ips []string
+			for _, addr := range addrs {
+				// Ignore the automatically-configured link-local address.
+				if addr.IP.IsLinkLocalUnicast() {
+					continue
+				}
+				ips = append(ips, addr.IPNet.String())
+			}
+			slices.Sort(ips)
+			if diff := cmp.Diff(test.expectedIPs, ips); diff != "" {
+				t.Errorf("Wrong IPs on interface (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,850 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package dhcp6c
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c/transport"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+type state int
+
+const (
+	// stateSoliciting sends Solicit messages to the network and waits for
+	// either an Advertise or (in case of Rapid Commit) a Reply.
+	stateSoliciting state = iota
+	// stateRequesting sends Request messages containing the server identifier
+	// and bindings of the selected Advertise and waits for a Reply. If it
+	// doesn't get a usable one it transitions back into soliciting.
+	stateRequesting
+	// stateBound just waits until the renewal time (T1) of the lease expires.
+	stateBound
+	// stateRenewing sends Renew messages to the server which handed out the
+	// lease and waits for a Reply until the rebinding time (T2) expires. If the
+	// server replies without any usable bindings it transitions back into
+	// soliciting.
+	stateRenewing
+	// stateRebinding sends Rebind messages to any server and waits for a Reply
+	// until the lease expires. Reply processing is identical to stateRenewing.
+	stateRebinding
+	// stateInformationRequesting sends Information-request messages to obtain
+	// configuration information without any bindings (stateless DHCPv6) and
+	// waits for a Reply.
+	stateInformationRequesting
+	// stateInformed just waits until the information refresh time of the
+	// obtained configuration information expires.
+	stateInformed
+)
+
+func (s state) String() string {
+	switch s {
+	case stateSoliciting:
+		return "SOLICITING"
+	case stateRequesting:
+		return "REQUESTING"
+	case stateBound:
+		return "BOUND"
+	case stateRenewing:
+		return "RENEWING"
+	case stateRebinding:
+		return "REBINDING"
+	case stateInformationRequesting:
+		return "INFORMATION-REQUESTING"
+	case stateInformed:
+		return "INFORMED"
+	default:
+		return "INVALID"
+	}
+}
+
+const (
+	// RFC8415 Section 21.23: Default and minimum information refresh time.
+	defaultInformationRefreshTime = 24 * time.Hour
+	minInformationRefreshTime     = 10 * time.Minute
+
+	// The Elapsed Time option is limited to 0xffff hundredths of a second.
+	maxElapsedTime = 0xffff * 10 * time.Millisecond
+)
+
+// Transport represents a mechanism over which DHCPv6 messages can be exchanged
+// with servers on the local link.
+type Transport interface {
+	// Open connects the transport. Can only be called after calling Close() or
+	// after creating a new transport.
+	Open() error
+	// Send attempts to send the given DHCPv6 payload message to all servers
+	// once. An empty return value does not indicate that the message was
+	// successfully received.
+	Send(payload *dhcpv6.Message) error
+	// SetReceiveDeadline sets a deadline for Receive() calls after which they
+	// return with ErrDeadlineExceeded
+	SetReceiveDeadline(time.Time) error
+	// Receive waits for a DHCPv6 message to arrive and returns it. If the
+	// deadline expires without a message arriving it will return
+	// ErrDeadlineExceeded. If the message is completely malformed it will
+	// return an instance of InvalidMessageError.
+	Receive() (*dhcpv6.Message, error)
+	// Close closes the given transport. Calls to any of the above methods
+	// other than Open will fail if the transport is closed.
+	Close() error
+}
+
+type LeaseCallback func(*Lease) error
+
+// Client implements a DHCPv6 client.
+type Client struct {
+	// RequestedOptions contains a list of extra options this client is
+	// interested in. DNS Recursive Name Servers are always requested.
+	RequestedOptions dhcpv6.OptionCodes
+
+	// RequestAddress makes the client request a non-temporary address via an
+	// IA_NA.
+	RequestAddress bool
+
+	// RequestPrefixDelegation makes the client request a delegated prefix via
+	// an IA_PD.
+	//
+	// If neither RequestAddress nor RequestPrefixDelegation are set, the client
+	// only obtains configuration information (stateless DHCPv6).
+	RequestPrefixDelegation bool
+
+	// ClientIdentifier is the DUID used by DHCPv6 servers to identify this
+	// client. NewClient sets it to a DUID-LL based on the hardware address of
+	// the interface.
+	ClientIdentifier dhcpv6.DUID
+
+	// Backoff strategies for each state. These all have sane defaults,
+	// override them only if necessary.
+	SolicitBackoff            backoff.BackOff
+	RequestBackoff            backoff.BackOff
+	RenewBackoff              backoff.BackOff
+	RebindBackoff             backoff.BackOff
+	InformationRequestBackoff backoff.BackOff
+
+	// LeaseCallback is called every time a lease is aquired, renewed or lost
+	LeaseCallback LeaseCallback
+
+	state state
+	// stateEntered is the time at which the current state has been entered.
+	// It is used to populate the Elapsed Time option.
+	stateEntered time.Time
+
+	iface *net.Interface
+	iaid  [4]byte
+
+	// now can be used to override time for testing
+	now func() time.Time
+
+	conn Transport
+
+	// Valid in state Requesting
+	advertise *dhcpv6.Message
+
+	// Valid in states Bound, Renewing, Rebinding, Informed
+	lease *dhcpv6.Message
+	// Valid in states Bound, Renewing, Rebinding
+	leaseDeadline      time.Time
+	leaseRenewDeadline time.Time
+	// Valid in states Bound, Informed
+	leaseBoundDeadline time.Time
+}
+
+// defaultBackoffOpts can be passed to NewExponentialBackOff and configures it
+// to retry infinitely and use the initial and maximum retransmission times
+// from RFC8415 Section 7.6 for Solicit messages, which are tighter than the
+// ones for other messages.
+func defaultBackoffOpts(b *backoff.ExponentialBackOff) {
+	b.MaxElapsedTime = 0 // No Timeout
+	b.InitialInterval = 1 * time.Second
+	b.MaxInterval = 30 * time.Second
+	b.RandomizationFactor = 0.1
+}
+
+// NewClient instantiates (but doesn't start) a new DHCPv6 client.
+// To have a working client in stateful mode it's required to set
+// LeaseCallback to something that is capable of configuring the assigned
+// addresses on the given interface. Routes are not part of DHCPv6 and need to
+// be obtained via Router Advertisements. A simple example with the callback
+// package thus looks like this:
+//
+//	c := dhcp6c.NewClient(yourInterface)
+//	c.RequestAddress = true
+//	c.LeaseCallback = callback.Compose(callback.ManageAddresses(yourInterface), yourCallback)
+//	c.Run(ctx)
+func NewClient(iface *net.Interface) (*Client, error) {
+	// Check if the hardware address contains at least one non-zero value.
+	// This exists to catch undefined/non-supplied hardware address values,
+	// it does not check for L2 protocol-specific hardware address constraints.
+	hasValidHWAddr := false
+	for _, b := range iface.HardwareAddr {
+		if b != 0x00 {
+			hasValidHWAddr = true
+			break
+		}
+	}
+	if !hasValidHWAddr || len(iface.HardwareAddr) < 4 {
+		return nil, fmt.Errorf("iface HardwareAddr is invalid (only zeroes or invalid length): %x", iface.HardwareAddr)
+	}
+	// Use the last four bytes of the hardware address as the identity
+	// association identifier. This keeps it stable across restarts of the
+	// client and unique between interfaces.
+	var iaid [4]byte
+	copy(iaid[:], iface.HardwareAddr[len(iface.HardwareAddr)-4:])
+
+	requestBackoff := backoff.NewExponentialBackOff(defaultBackoffOpts,
+		// Abort after 30s and go back to soliciting
+		backoff.WithMaxElapsedTime(30*time.Second))
+
+	renewBackoff := backoff.NewExponentialBackOff(defaultBackoffOpts,
+		// Increase maximum interval to reduce chatter when the server is down
+		backoff.WithMaxInterval(5*time.Minute))
+
+	rebindBackoff := backoff.NewExponentialBackOff(defaultBackoffOpts,
+		// Increase maximum interval to reduce chatter when the server is down
+		backoff.WithMaxInterval(5*time.Minute))
+
+	return &Client{
+		state: stateSoliciting,
+		conn:  transport.NewMulticastTransport(iface),
+		iface: iface,
+		iaid:  iaid,
+		ClientIdentifier: &dhcpv6.DUIDLL{
+			HWType:        iana.HWTypeEthernet,
+			LinkLayerAddr: iface.HardwareAddr,
+		},
+		RequestedOptions:          dhcpv6.OptionCodes{},
+		now:                       time.Now,
+		SolicitBackoff:            backoff.NewExponentialBackOff(defaultBackoffOpts),
+		RequestBackoff:            requestBackoff,
+		RenewBackoff:              renewBackoff,
+		RebindBackoff:             rebindBackoff,
+		InformationRequestBackoff: backoff.NewExponentialBackOff(defaultBackoffOpts),
+	}, nil
+}
+
+// stateful returns true if the client requests any bindings from servers.
+func (c *Client) stateful() bool {
+	return c.RequestAddress || c.RequestPrefixDelegation
+}
+
+// setState transitions the FSM into the given state and resets the backoff
+// used in that state, if any.
+func (c *Client) setState(s state) {
+	c.state = s
+	c.stateEntered = c.now()
+	switch s {
+	case stateSoliciting:
+		c.SolicitBackoff.Reset()
+	case stateRequesting:
+		c.RequestBackoff.Reset()
+	case stateRenewing:
+		c.RenewBackoff.Reset()
+	case stateRebinding:
+		c.RebindBackoff.Reset()
+	case stateInformationRequesting:
+		c.InformationRequestBackoff.Reset()
+	}
+}
+
+// validReply checks if the given message is a response to this client from a
+// server at all.
+func (c *Client) validReply(msg *dhcpv6.Message) bool {
+	// RFC8415 Section 16.3 and 16.10
+	if msg.Options.ServerID() == nil {
+		return false
+	}
+	cid := msg.Options.ClientID()
+	if cid == nil || !cid.Equal(c.ClientIdentifier) {
+		return false
+	}
+	return true
+}
+
+// usableLifetimes checks if the given lifetimes of an address or prefix are
+// valid and not already expired.
+func usableLifetimes(preferred, valid time.Duration) bool {
+	// RFC8415 Section 21.6 and 21.22
+	return valid > 0 && preferred <= valid
+}
+
+// isSuccess returns true if the given status code option is not set or
+// indicates success.
+func isSuccess(s *dhcpv6.OptStatusCode) bool {
+	return s == nil || s.StatusCode == iana.StatusSuccess
+}
+
+// usableIANA returns a copy of the IA_NA in msg belonging to this client with
+// all unusable addresses removed. It returns nil if there is no such IA_NA or
+// it does not contain any usable addresses.
+func (c *Client) usableIANA(msg *dhcpv6.Message) *dhcpv6.OptIANA {
+	for _, ia := range msg.Options.IANA() {
+		if ia.IaId != c.iaid || !isSuccess(ia.Options.Status()) {
+			continue
+		}
+		res := &dhcpv6.OptIANA{IaId: ia.IaId, T1: ia.T1, T2: ia.T2}
+		for _, a := range ia.Options.Addresses() {
+			// Ignore IPs that are in no way valid for an interface
+			// (multicast, loopback, ...) as well as IPv4 addresses.
+			if a.IPv6Addr.To16() == nil || a.IPv6Addr.To4() != nil || !a.IPv6Addr.IsGlobalUnicast() {
+				continue
+			}
+			if !usableLifetimes(a.PreferredLifetime, a.ValidLifetime) || !isSuccess(a.Options.Status()) {
+				continue
+			}
+			res.Options.Add(a)
+		}
+		if len(res.Options.Addresses()) > 0 {
+			return res
+		}
+	}
+	return nil
+}
+
+// usableIAPD returns a copy of the IA_PD in msg belonging to this client with
+// all unusable prefixes removed. It returns nil if there is no such IA_PD or
+// it does not contain any usable prefixes.
+func (c *Client) usableIAPD(msg *dhcpv6.Message) *dhcpv6.OptIAPD {
+	for _, opt := range msg.Options.Get(dhcpv6.OptionIAPD) {
+		ia, ok := opt.(*dhcpv6.OptIAPD)
+		if !ok || ia.IaId != c.iaid || !isSuccess(ia.Options.Status()) {
+			continue
+		}
+		res := &dhcpv6.OptIAPD{IaId: ia.IaId, T1: ia.T1, T2: ia.T2}
+		for _, p := range ia.Options.Prefixes() {
+			if p.Prefix == nil || p.Prefix.IP.To4() != nil {
+				continue
+			}
+			if ones, bits := p.Prefix.Mask.Size(); bits != 128 || ones == 0 {
+				continue
+			}
+			if !usableLifetimes(p.PreferredLifetime, p.ValidLifetime) || !isSuccess(p.Options.Status()) {
+				continue
+			}
+			res.Options.Add(p)
+		}
+		if len(res.Options.Prefixes()) > 0 {
+			return res
+		}
+	}
+	return nil
+}
+
+// acceptableReply checks if the given Advertise or Reply message contains
+// usable bindings (or just configuration information in stateless mode) and
+// normalizes it by removing all bindings which cannot be used or were not
+// requested.
+// This is intentionally not exposed to users as it only ever returns true
+// if at least one of the requested bindings is usable, which is more lenient
+// than requiring all of them.
+func (c *Client) acceptableReply(msg *dhcpv6.Message) bool {
+	if !isSuccess(msg.Options.Status()) {
+		return false
+	}
+	var ias []dhcpv6.Option
+	if c.RequestAddress {
+		if ia := c.usableIANA(msg); ia != nil {
+			ias = append(ias, ia)
+		}
+	}
+	if c.RequestPrefixDelegation {
+		if ia := c.usableIAPD(msg); ia != nil {
+			ias = append(ias, ia)
+		}
+	}
+	msg.Options.Del(dhcpv6.OptionIANA)
+	msg.Options.Del(dhcpv6.OptionIAPD)
+	for _, ia := range ias {
+		msg.Options.Add(ia)
+	}
+	return !c.stateful() || len(ias) > 0
+}
+
+// requestIAs returns the IA options to include in a message requesting the
+// bindings of the given message. Otherwise the requested IAs are empty.
+func (c *Client) requestIAs(msg *dhcpv6.Message) []dhcpv6.Option {
+	var ias []dhcpv6.Option
+	// RFC8415 Section 18.2: T1, T2 and lifetimes in IAs sent by the client
+	// should be zero.
+	if c.RequestAddress {
+		ia := &dhcpv6.OptIANA{IaId: c.iaid}
+		if msg != nil {
+			if boundIA := c.usableIANA(msg); boundIA != nil {
+				for _, a := range boundIA.Options.Addresses() {
+					ia.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: a.IPv6Addr})
+				}
+			}
+		}
+		ias = append(ias, ia)
+	}
+	if c.RequestPrefixDelegation {
+		ia := &dhcpv6.OptIAPD{IaId: c.iaid}
+		if msg != nil {
+			if boundIA := c.usableIAPD(msg); boundIA != nil {
+				for _, p := range boundIA.Options.Prefixes() {
+					ia.Options.Add(&dhcpv6.OptIAPrefix{Prefix: p.Prefix})
+				}
+			}
+		}
+		ias = append(ias, ia)
+	}
+	return ias
+}
+
+func earliestDeadline(dl1, dl2 time.Time) time.Time {
+	if dl1.Before(dl2) {
+		return dl1
+	} else {
+		return dl2
+	}
+}
+
+// newMsg creates a new DHCPv6 message of a given type and adds common options.
+func (c *Client) newMsg(t dhcpv6.MessageType) (*dhcpv6.Message, error) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create message: %w", err)
+	}
+	msg.MessageType = t
+	msg.AddOption(dhcpv6.OptClientID(c.ClientIdentifier))
+	elapsed := c.now().Sub(c.stateEntered)
+	if elapsed > maxElapsedTime {
+		elapsed = maxElapsedTime
+	}
+	msg.AddOption(dhcpv6.OptElapsedTime(elapsed))
+	requested := append(dhcpv6.OptionCodes{dhcpv6.OptionDNSRecursiveNameServer}, c.RequestedOptions...)
+	if t == dhcpv6.MessageTypeInformationRequest {
+		requested = append(requested, dhcpv6.OptionInformationRefreshTime)
+	}
+	msg.AddOption(dhcpv6.OptRequestedOption(requested...))
+	return msg, nil
+}
+
+// transactionStateSpec describes a state which is driven by a DHCPv6 message
+// transaction (sending a specific message and then transitioning into a
+// different state depending on the received messages)
+type transactionStateSpec struct {
+	// ctx is a context for canceling the process
+	ctx context.Context
+
+	// stateDeadline is a fixed external deadline for how long the FSM can
+	// remain in this state.
+	// If it's exceeded the stateDeadlineExceeded callback is called and
+	// responsible for transitioning out of this state. It can be left empty to
+	// signal that there's no external deadline for the state.
+	stateDeadline time.Time
+
+	// backoff controls how long to wait for answers until handing control back
+	// to the FSM.
+	// Since the FSM hasn't advanced until then this means we just get called
+	// again and retransmit.
+	backoff backoff.BackOff
+
+	// requestType is the type of DHCPv6 message sent out in this state.
+	requestType dhcpv6.MessageType
+
+	// setExtraOptions can modify the request and set extra options before
+	// transmitting. Returning an error here aborts the FSM an can be used to
+	// terminate when no valid request can be constructed.
+	setExtraOptions func(msg *dhcpv6.Message) error
+
+	// handleMessage gets called for every parseable (not necessarily valid)
+	// DHCPv6 message received by the transport which belongs to the current
+	// transaction. It should return an error for every message that doesn't
+	// advance the state machine and no error for every one that does. It is
+	// responsible for advancing the FSM if the required information is
+	// present.
+	handleMessage func(msg *dhcpv6.Message, sentTime time.Time) error
+
+	// stateDeadlineExceeded gets called if either the backoff returns
+	// backoff.Stop or the stateDeadline runs out. It is responsible for
+	// advancing the FSM into the next state.
+	stateDeadlineExceeded func() error
+}
+
+func (c *Client) runTransactionState(s transactionStateSpec) error {
+	sentTime := c.now()
+	msg, err := c.newMsg(s.requestType)
+	if err != nil {
+		return fmt.Errorf("failed to get new DHCPv6 message: %w", err)
+	}
+	if err := s.setExtraOptions(msg); err != nil {
+		return fmt.Errorf("failed to create DHCPv6 message: %w", err)
+	}
+
+	wait := s.backoff.NextBackOff()
+	if wait == backoff.Stop {
+		return s.stateDeadlineExceeded()
+	}
+
+	receiveDeadline := sentTime.Add(wait)
+	if !s.stateDeadline.IsZero() {
+		receiveDeadline = earliestDeadline(s.stateDeadline, receiveDeadline)
+
+		// Jump out if deadline expires in less than 10ms. This nearly
+		// eliminates the problem of sending two different requests
+		// back-to-back.
+		if s.stateDeadline.Add(-10 * time.Millisecond).Before(sentTime) {
+			return s.stateDeadlineExceeded()
+		}
+	}
+
+	if err := c.conn.Send(msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	if err := c.conn.SetReceiveDeadline(receiveDeadline); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	for {
+		reply, err := c.conn.Receive()
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		default:
+		}
+		if errors.Is(err, transport.ErrDeadlineExceeded) {
+			return nil
+		}
+		var e transport.InvalidMessageError
+		if errors.As(err, &e) {
+			// Packet couldn't be read. Maybe log at some point in the future.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive packet: %w", err)
+		}
+		if reply.TransactionID != msg.TransactionID { // Not our transaction
+			continue
+		}
+		if !c.validReply(reply) {
+			continue
+		}
+		err = s.handleMessage(reply, sentTime)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, ErrInvalidMsg) {
+			return err
+		}
+	}
+}
+
+var ErrInvalidMsg = errors.New("invalid message")
+
+func (c *Client) runState(ctx context.Context) error {
+	switch c.state {
+	case stateSoliciting:
+		return c.runTransactionState(transactionStateSpec{
+			ctx:         ctx,
+			backoff:     c.SolicitBackoff,
+			requestType: dhcpv6.MessageTypeSolicit,
+			setExtraOptions: func(msg *dhcpv6.Message) error {
+				msg.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRapidCommit})
+				for _, ia := range c.requestIAs(nil) {
+					msg.AddOption(ia)
+				}
+				return nil
+			},
+			handleMessage: func(msg *dhcpv6.Message, sentTime time.Time) error {
+				switch msg.MessageType {
+				case dhcpv6.MessageTypeAdvertise:
+					if c.acceptableReply(msg) {
+						c.advertise = msg
+						c.setState(stateRequesting)
+						return nil
+					}
+				case dhcpv6.MessageTypeReply:
+					// Only valid as a response to Rapid Commit.
+					if msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil && c.acceptableReply(msg) {
+						return c.transitionToBound(msg, sentTime)
+					}
+				}
+				return ErrInvalidMsg
+			},
+		})
+	case stateRequesting:
+		return c.runTransactionState(transactionStateSpec{
+			ctx:         ctx,
+			backoff:     c.RequestBackoff,
+			requestType: dhcpv6.MessageTypeRequest,
+			setExtraOptions: func(msg *dhcpv6.Message) error {
+				msg.AddOption(dhcpv6.OptServerID(c.advertise.Options.ServerID()))
+				for _, ia := range c.requestIAs(c.advertise) {
+					msg.AddOption(ia)
+				}
+				return nil
+			},
+			handleMessage: func(msg *dhcpv6.Message, sentTime time.Time) error {
+				if msg.MessageType != dhcpv6.MessageTypeReply {
+					return ErrInvalidMsg
+				}
+				if c.acceptableReply(msg) {
+					return c.transitionToBound(msg, sentTime)
+				}
+				// The server refused to assign any of the advertised
+				// bindings.
+				c.requestingToSoliciting()
+				return nil
+			},
+			stateDeadlineExceeded: func() error {
+				c.requestingToSoliciting()
+				return nil
+			},
+		})
+	case stateBound, stateInformed:
+		select {
+		case <-time.After(c.leaseBoundDeadline.Sub(c.now())):
+			if c.state == stateBound {
+				c.setState(stateRenewing)
+			} else {
+				c.setState(stateInformationRequesting)
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case stateRenewing:
+		return c.runTransactionState(transactionStateSpec{
+			ctx:           ctx,
+			backoff:       c.RenewBackoff,
+			requestType:   dhcpv6.MessageTypeRenew,
+			stateDeadline: c.leaseRenewDeadline,
+			setExtraOptions: func(msg *dhcpv6.Message) error {
+				msg.AddOption(dhcpv6.OptServerID(c.lease.Options.ServerID()))
+				for _, ia := range c.requestIAs(c.lease) {
+					msg.AddOption(ia)
+				}
+				return nil
+			},
+			handleMessage: c.handleLeaseReply,
+			stateDeadlineExceeded: func() error {
+				c.setState(stateRebinding)
+				return nil
+			},
+		})
+	case stateRebinding:
+		return c.runTransactionState(transactionStateSpec{
+			ctx:           ctx,
+			backoff:       c.RebindBackoff,
+			requestType:   dhcpv6.MessageTypeRebind,
+			stateDeadline: c.leaseDeadline,
+			setExtraOptions: func(msg *dhcpv6.Message) error {
+				for _, ia := range c.requestIAs(c.lease) {
+					msg.AddOption(ia)
+				}
+				return nil
+			},
+			handleMessage: c.handleLeaseReply,
+			stateDeadlineExceeded: func() error {
+				return c.leaseToSoliciting()
+			},
+		})
+	case stateInformationRequesting:
+		return c.runTransactionState(transactionStateSpec{
+			ctx:         ctx,
+			backoff:     c.InformationRequestBackoff,
+			requestType: dhcpv6.MessageTypeInformationRequest,
+			setExtraOptions: func(msg *dhcpv6.Message) error {
+				return nil
+			},
+			handleMessage: func(msg *dhcpv6.Message, sentTime time.Time) error {
+				if msg.MessageType == dhcpv6.MessageTypeReply && c.acceptableReply(msg) {
+					return c.transitionToInformed(msg, sentTime)
+				}
+				return ErrInvalidMsg
+			},
+		})
+	}
+	return errors.New("state machine in invalid state")
+}
+
+// handleLeaseReply processes Reply messages to Renew and Rebind messages.
+func (c *Client) handleLeaseReply(msg *dhcpv6.Message, sentTime time.Time) error {
+	if msg.MessageType != dhcpv6.MessageTypeReply {
+		return ErrInvalidMsg
+	}
+	if c.acceptableReply(msg) {
+		return c.transitionToBound(msg, sentTime)
+	}
+	// The server does not want us to keep any of our bindings.
+	return c.leaseToSoliciting()
+}
+
+// Run runs the DHCPv6 client until the given context is canceled. All
+// bindings are dropped via LeaseCallback when it returns.
+func (c *Client) Run(ctx context.Context) error {
+	if c.LeaseCallback == nil {
+		panic("LeaseCallback must be set before calling Run")
+	}
+	logger := supervisor.Logger(ctx)
+
+	if err := c.conn.Open(); err != nil {
+		return fmt.Errorf("failed to open DHCPv6 transport: %w", err)
+	}
+	defer c.cleanup()
+
+	if c.stateful() {
+		c.setState(stateSoliciting)
+	} else {
+		c.setState(stateInformationRequesting)
+	}
+	for {
+		oldState := c.state
+		if err := c.runState(ctx); err != nil {
+			return err
+		}
+		if c.state != oldState {
+			logger.Infof("%s => %s", oldState, c.state)
+		}
+	}
+}
+
+func (c *Client) cleanup() {
+	c.conn.Close()
+	if c.lease != nil {
+		c.lease = nil
+		c.LeaseCallback(nil)
+	}
+}
+
+func (c *Client) requestingToSoliciting() {
+	c.advertise = nil
+	c.setState(stateSoliciting)
+}
+
+func (c *Client) leaseToSoliciting() error {
+	c.setState(stateSoliciting)
+	c.lease = nil
+	if err := c.LeaseCallback(nil); err != nil {
+		return fmt.Errorf("lease callback failed: %w", err)
+	}
+	return nil
+}
+
+func leaseFromReply(reply *dhcpv6.Message, sentTime, expiresAt time.Time) *Lease {
+	l := &Lease{
+		Options:   reply.Options.Options,
+		ExpiresAt: expiresAt,
+	}
+	for _, ia := range reply.Options.IANA() {
+		for _, a := range ia.Options.Addresses() {
+			l.Addresses = append(l.Addresses, Address{
+				IP:             a.IPv6Addr,
+				PreferredUntil: sentTime.Add(a.PreferredLifetime),
+				ValidUntil:     sentTime.Add(a.ValidLifetime),
+			})
+		}
+	}
+	for _, opt := range reply.Options.Get(dhcpv6.OptionIAPD) {
+		ia := opt.(*dhcpv6.OptIAPD)
+		for _, p := range ia.Options.Prefixes() {
+			l.Prefixes = append(l.Prefixes, Prefix{
+				Prefix:         p.Prefix,
+				PreferredUntil: sentTime.Add(p.PreferredLifetime),
+				ValidUntil:     sentTime.Add(p.ValidLifetime),
+			})
+		}
+	}
+	return l
+}
+
+// leaseTimes calculates the renewal time (T1), rebinding time (T2) and
+// lifetime of all bindings in the given normalized reply. T1 and T2 are the
+// earliest ones of all IAs. If an IA doesn't specify them they are derived
+// from the shortest preferred lifetime in it as recommended by RFC8415
+// Section 14.2.
+func leaseTimes(reply *dhcpv6.Message) (t1, t2, lifetime time.Duration) {
+	first := true
+	addIA := func(iaT1, iaT2 time.Duration, preferred, valid []time.Duration) {
+		var minPreferred time.Duration
+		for i := range preferred {
+			if i == 0 || preferred[i] < minPreferred {
+				minPreferred = preferred[i]
+			}
+			if valid[i] > lifetime {
+				lifetime = valid[i]
+			}
+		}
+		if iaT1 == 0 || iaT2 == 0 || iaT1 > iaT2 {
+			iaT1 = time.Duration(float64(minPreferred) * 0.5)
+			iaT2 = time.Duration(float64(minPreferred) * 0.8)
+		}
+		if first || iaT1 < t1 {
+			t1 = iaT1
+		}
+		if first || iaT2 < t2 {
+			t2 = iaT2
+		}
+		first = false
+	}
+	for _, ia := range reply.Options.IANA() {
+		var preferred, valid []time.Duration
+		for _, a := range ia.Options.Addresses() {
+			preferred = append(preferred, a.PreferredLifetime)
+			valid = append(valid, a.ValidLifetime)
+		}
+		addIA(ia.T1, ia.T2, preferred, valid)
+	}
+	for _, opt := range reply.Options.Get(dhcpv6.OptionIAPD) {
+		ia := opt.(*dhcpv6.OptIAPD)
+		var preferred, valid []time.Duration
+		for _, p := range ia.Options.Prefixes() {
+			preferred = append(preferred, p.PreferredLifetime)
+			valid = append(valid, p.ValidLifetime)
+		}
+		addIA(ia.T1, ia.T2, preferred, valid)
+	}
+	// Clamp T1 and T2 to the lifetime. Otherwise the state machine might
+	// misbehave.
+	if t2 > lifetime {
+		t2 = lifetime
+	}
+	if t1 > t2 {
+		t1 = t2
+	}
+	return
+}
+
+func (c *Client) transitionToBound(reply *dhcpv6.Message, sentTime time.Time) error {
+	// Guaranteed to contain at least one binding, others are filtered.
+	t1, t2, lifetime := leaseTimes(reply)
+	c.leaseDeadline = sentTime.Add(lifetime)
+	c.leaseBoundDeadline = sentTime.Add(t1)
+	c.leaseRenewDeadline = sentTime.Add(t2)
+
+	if err := c.LeaseCallback(leaseFromReply(reply, sentTime, c.leaseDeadline)); err != nil {
+		return fmt.Errorf("lease callback failed: %w", err)
+	}
+
+	c.advertise = nil
+	c.lease = reply
+	c.setState(stateBound)
+	return nil
+}
+
+func (c *Client) transitionToInformed(reply *dhcpv6.Message, sentTime time.Time) error {
+	refresh := reply.Options.InformationRefreshTime(defaultInformationRefreshTime)
+	if refresh < minInformationRefreshTime {
+		refresh = minInformationRefreshTime
+	}
+	c.leaseBoundDeadline = sentTime.Add(refresh)
+
+	if err := c.LeaseCallback(leaseFromReply(reply, sentTime, time.Time{})); err != nil {
+		return fmt.Errorf("lease callback failed: %w", err)
+	}
+
+	c.lease = reply
+	c.setState(stateInformed)
+	return nil
+}
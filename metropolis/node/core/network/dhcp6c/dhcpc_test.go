@@ -0,0 +1,450 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package dhcp6c
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c/transport"
+)
+
+type fakeTime struct {
+	time time.Time
+}
+
+func newFakeTime(t time.Time) *fakeTime {
+	return &fakeTime{
+		time: t,
+	}
+}
+
+func (ft *fakeTime) Now() time.Time {
+	return ft.time
+}
+
+func (ft *fakeTime) Advance(d time.Duration) {
+	ft.time = ft.time.Add(d)
+}
+
+type mockTransport struct {
+	sentPacket     *dhcpv6.Message
+	sendError      error
+	setDeadline    time.Time
+	receivePackets []*dhcpv6.Message
+	receiveError   error
+	receiveIdx     int
+	closed         bool
+}
+
+func (mt *mockTransport) sendPackets(pkts ...*dhcpv6.Message) {
+	mt.receiveIdx = 0
+	mt.receivePackets = pkts
+}
+
+func (mt *mockTransport) Open() error {
+	mt.closed = false
+	return nil
+}
+
+func (mt *mockTransport) Send(payload *dhcpv6.Message) error {
+	mt.sentPacket = payload
+	return mt.sendError
+}
+
+func (mt *mockTransport) Receive() (*dhcpv6.Message, error) {
+	if mt.receiveError != nil {
+		return nil, mt.receiveError
+	}
+	if len(mt.receivePackets) > mt.receiveIdx {
+		packet := mt.receivePackets[mt.receiveIdx]
+		packet, err := dhcpv6.MessageFromBytes(packet.ToBytes()) // Clone packet
+		if err != nil {
+			panic("ToBytes => FromBytes failed")
+		}
+		packet.TransactionID = mt.sentPacket.TransactionID
+		mt.receiveIdx++
+		return packet, nil
+	}
+	return nil, transport.ErrDeadlineExceeded
+}
+
+func (mt *mockTransport) SetReceiveDeadline(t time.Time) error {
+	mt.setDeadline = t
+	return nil
+}
+
+func (mt *mockTransport) Close() error {
+	mt.closed = true
+	return nil
+}
+
+type mockBackoff struct {
+	indefinite bool
+	values     []time.Duration
+	idx        int
+}
+
+func newMockBackoff(vals []time.Duration, indefinite bool) *mockBackoff {
+	return &mockBackoff{values: vals, indefinite: indefinite}
+}
+
+func (mb *mockBackoff) NextBackOff() time.Duration {
+	if mb.idx < len(mb.values) || mb.indefinite {
+		val := mb.values[mb.idx%len(mb.values)]
+		mb.idx++
+		return val
+	}
+	return backoff.Stop
+}
+
+func (mb *mockBackoff) Reset() {
+	mb.idx = 0
+}
+
+var (
+	testHWAddr   = net.HardwareAddr{0x12, 0x23, 0x34, 0x45, 0x56, 0x67}
+	testIAID     = [4]byte{0x34, 0x45, 0x56, 0x67}
+	testClientID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: testHWAddr}
+	testServerID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+	testIP       = net.ParseIP("2001:db8::2")
+	testPrefix   = &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(56, 128)}
+)
+
+type dhcpClientPuppet struct {
+	ft *fakeTime
+	mt *mockTransport
+	c  *Client
+}
+
+func newPuppetClient(initState state) *dhcpClientPuppet {
+	ft := newFakeTime(time.Date(2020, 10, 28, 15, 02, 32, 352, time.UTC))
+	mt := &mockTransport{}
+	c := &Client{
+		state:                     initState,
+		now:                       ft.Now,
+		iface:                     &net.Interface{MTU: 9324, HardwareAddr: testHWAddr},
+		iaid:                      testIAID,
+		ClientIdentifier:          testClientID,
+		RequestAddress:            true,
+		conn:                      mt,
+		SolicitBackoff:            newMockBackoff([]time.Duration{1 * time.Second}, true),
+		RequestBackoff:            newMockBackoff([]time.Duration{1 * time.Second, 2 * time.Second}, false),
+		RenewBackoff:              newMockBackoff([]time.Duration{1 * time.Second}, true),
+		RebindBackoff:             newMockBackoff([]time.Duration{1 * time.Second}, true),
+		InformationRequestBackoff: newMockBackoff([]time.Duration{1 * time.Second}, true),
+	}
+	return &dhcpClientPuppet{
+		ft: ft,
+		mt: mt,
+		c:  c,
+	}
+}
+
+func newResponse(m dhcpv6.MessageType) *dhcpv6.Message {
+	msg := &dhcpv6.Message{MessageType: m}
+	msg.AddOption(dhcpv6.OptServerID(testServerID))
+	msg.AddOption(dhcpv6.OptClientID(testClientID))
+	return msg
+}
+
+// withAddress adds an IA_NA for the test client containing testIP with the
+// given valid lifetime.
+func withAddress(msg *dhcpv6.Message, valid time.Duration) *dhcpv6.Message {
+	ia := &dhcpv6.OptIANA{IaId: testIAID}
+	ia.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: testIP, PreferredLifetime: valid, ValidLifetime: valid})
+	msg.AddOption(ia)
+	return msg
+}
+
+func TestClient_runTransactionState(t *testing.T) {
+	p := newPuppetClient(stateSoliciting)
+	err := p.c.runTransactionState(transactionStateSpec{
+		ctx:         context.Background(),
+		backoff:     newMockBackoff([]time.Duration{1 * time.Second}, true),
+		requestType: dhcpv6.MessageTypeSolicit,
+		setExtraOptions: func(msg *dhcpv6.Message) error {
+			msg.AddOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+			return nil
+		},
+		handleMessage: func(msg *dhcpv6.Message, sentTime time.Time) error {
+			return nil
+		},
+		stateDeadlineExceeded: func() error {
+			panic("shouldn't be called")
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv6.MessageTypeSolicit, p.mt.sentPacket.MessageType)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, p.mt.sentPacket.Options.DNS())
+	assert.True(t, p.mt.sentPacket.Options.ClientID().Equal(testClientID), "Client ID not sent")
+	assert.True(t, p.mt.sentPacket.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer), "DNS servers not requested")
+}
+
+// TestAcceptableReply tests if unusable bindings are removed from replies and
+// replies without any usable bindings are refused.
+func TestAcceptableReply(t *testing.T) {
+	p := newPuppetClient(stateSoliciting)
+	p.c.RequestPrefixDelegation = true
+
+	reply := withAddress(newResponse(dhcpv6.MessageTypeReply), 10*time.Second)
+	// IA_NA for a different IAID
+	reply.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{1, 2, 3, 4}})
+	// IA_PD with only an expired prefix
+	pd := &dhcpv6.OptIAPD{IaId: testIAID}
+	pd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: testPrefix})
+	reply.AddOption(pd)
+	assert.True(t, p.c.acceptableReply(reply), "Reply with a valid address is not acceptable")
+	assert.Len(t, reply.Options.IANA(), 1)
+	assert.Nil(t, reply.GetOneOption(dhcpv6.OptionIAPD), "Unusable IA_PD not removed")
+
+	noBindings := newResponse(dhcpv6.MessageTypeReply)
+	assert.False(t, p.c.acceptableReply(noBindings), "Reply without bindings is acceptable")
+
+	failed := withAddress(newResponse(dhcpv6.MessageTypeReply), 10*time.Second)
+	failed.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusUnspecFail})
+	assert.False(t, p.c.acceptableReply(failed), "Reply with failure status is acceptable")
+}
+
+// TestSolicitRequesting tests if the DHCPv6 state machine in soliciting state
+// properly selects the first valid Advertise and transitions to requesting
+// state.
+func TestSolicitRequesting(t *testing.T) {
+	p := newPuppetClient(stateSoliciting)
+
+	// Intentionally bad advertise without any addresses.
+	terribleAdvertise := newResponse(dhcpv6.MessageTypeAdvertise)
+	advertise := withAddress(newResponse(dhcpv6.MessageTypeAdvertise), 10*time.Second)
+
+	p.mt.sendPackets(terribleAdvertise, advertise)
+
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dhcpv6.MessageTypeSolicit, p.mt.sentPacket.MessageType)
+	assert.NotNil(t, p.mt.sentPacket.Options.OneIANA(), "Solicit doesn't contain IA_NA")
+	assert.Equal(t, stateRequesting, p.c.state, "DHCPv6 client didn't process advertise")
+	assert.Equal(t, testIP, p.c.advertise.Options.OneIANA().Options.OneAddress().IPv6Addr, "DHCPv6 client selected invalid advertise")
+}
+
+// TestSolicitRapidCommit tests if the DHCPv6 state machine in soliciting state
+// transitions directly to bound if a Rapid Commit Reply is received.
+func TestSolicitRapidCommit(t *testing.T) {
+	p := newPuppetClient(stateSoliciting)
+
+	reply := withAddress(newResponse(dhcpv6.MessageTypeReply), 10*time.Second)
+	reply.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRapidCommit})
+
+	p.mt.sendPackets(reply)
+	p.c.LeaseCallback = func(lease *Lease) error {
+		assert.Len(t, lease.Addresses, 1)
+		assert.Equal(t, testIP, lease.Addresses[0].IP, "new lease has wrong IP")
+		return nil
+	}
+
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stateBound, p.c.state, "DHCPv6 client didn't process rapid commit reply")
+}
+
+// TestRequestingBound tests if the DHCPv6 state machine in requesting state
+// processes a valid Reply and transitions to bound state.
+func TestRequestingBound(t *testing.T) {
+	p := newPuppetClient(stateRequesting)
+
+	p.c.advertise = withAddress(newResponse(dhcpv6.MessageTypeAdvertise), 10*time.Second)
+	reply := withAddress(newResponse(dhcpv6.MessageTypeReply), 10*time.Second)
+
+	p.mt.sendPackets(reply)
+	p.c.LeaseCallback = func(lease *Lease) error {
+		assert.Equal(t, testIP, lease.Addresses[0].IP, "new lease has wrong IP")
+		assert.Equal(t, p.ft.Now().Add(10*time.Second), lease.ExpiresAt, "new lease has wrong expiry")
+		return nil
+	}
+
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dhcpv6.MessageTypeRequest, p.mt.sentPacket.MessageType)
+	assert.True(t, p.mt.sentPacket.Options.ServerID().Equal(testServerID), "Request doesn't contain server ID")
+	assert.Equal(t, testIP, p.mt.sentPacket.Options.OneIANA().Options.OneAddress().IPv6Addr, "Request doesn't contain advertised address")
+	assert.Equal(t, stateBound, p.c.state, "DHCPv6 client didn't process reply")
+	// Default T1 and T2 are derived from the preferred lifetime.
+	assert.Equal(t, p.ft.Now().Add(5*time.Second), p.c.leaseBoundDeadline)
+	assert.Equal(t, p.ft.Now().Add(8*time.Second), p.c.leaseRenewDeadline)
+}
+
+// TestRequestingSoliciting tests if the DHCPv6 state machine in requesting
+// state transitions back to soliciting if it takes too long to get a valid
+// Reply.
+func TestRequestingSoliciting(t *testing.T) {
+	p := newPuppetClient(stateRequesting)
+	p.c.advertise = withAddress(newResponse(dhcpv6.MessageTypeAdvertise), 10*time.Second)
+
+	for i := 0; i < 10; i++ {
+		p.mt.sendPackets()
+		if err := p.c.runState(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if p.c.state == stateSoliciting {
+			break
+		}
+		assert.Equal(t, dhcpv6.MessageTypeRequest, p.mt.sentPacket.MessageType, "Invalid message type for requesting")
+		p.ft.time = p.mt.setDeadline
+
+		if i == 9 {
+			t.Fatal("Too many tries while requesting, backoff likely wrong")
+		}
+	}
+	assert.Equal(t, stateSoliciting, p.c.state, "DHCPv6 client didn't switch back to soliciting after requesting expired")
+	assert.Nil(t, p.c.advertise)
+}
+
+// TestBoundRenewingBound tests if the DHCPv6 state machine in bound correctly
+// transitions to renewing after the renewal time expires and back to bound
+// after a successful renewal.
+func TestBoundRenewingBound(t *testing.T) {
+	p := newPuppetClient(stateBound)
+
+	lease := withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second)
+	p.c.lease = lease
+	p.c.leaseDeadline = p.ft.Now().Add(60 * time.Second)
+	p.c.leaseBoundDeadline = p.ft.Now().Add(30 * time.Second)
+	p.c.leaseRenewDeadline = p.ft.Now().Add(48 * time.Second)
+
+	p.ft.Advance(30*time.Second + 5*time.Millisecond)
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stateRenewing, p.c.state, "DHCPv6 client not renewing")
+
+	p.mt.sendPackets(withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second))
+	var gotLease *Lease
+	p.c.LeaseCallback = func(lease *Lease) error {
+		gotLease = lease
+		return nil
+	}
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dhcpv6.MessageTypeRenew, p.mt.sentPacket.MessageType)
+	assert.True(t, p.mt.sentPacket.Options.ServerID().Equal(testServerID), "Renew doesn't contain server ID")
+	assert.Equal(t, stateBound, p.c.state, "DHCPv6 client didn't renew")
+	assert.NotNil(t, gotLease, "lease callback not called")
+	assert.Equal(t, p.ft.Now().Add(60*time.Second), p.c.leaseDeadline, "lease deadline not updated")
+}
+
+// TestRenewingRebinding tests if the DHCPv6 state machine in renewing state
+// correctly transitions to rebinding after the rebinding time expires.
+func TestRenewingRebinding(t *testing.T) {
+	p := newPuppetClient(stateRenewing)
+
+	p.c.lease = withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second)
+	p.c.leaseDeadline = p.ft.Now().Add(30 * time.Second)
+	p.c.leaseRenewDeadline = p.ft.Now().Add(18 * time.Second)
+
+	for i := 0; i < 20; i++ {
+		p.mt.sendPackets()
+		if err := p.c.runState(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if p.c.state == stateRebinding {
+			break
+		}
+		p.ft.time = p.mt.setDeadline
+
+		if i == 19 {
+			t.Fatal("Too many tries while renewing, backoff likely wrong")
+		}
+	}
+	assert.Equal(t, stateRebinding, p.c.state, "DHCPv6 client not rebinding after renewing expired")
+}
+
+// TestRebindingBound tests if the DHCPv6 state machine in rebinding state
+// transitions to bound after a successful rebind.
+func TestRebindingBound(t *testing.T) {
+	p := newPuppetClient(stateRebinding)
+
+	p.c.lease = withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second)
+	p.c.leaseDeadline = p.ft.Now().Add(30 * time.Second)
+
+	p.mt.sendPackets(withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second))
+	p.c.LeaseCallback = func(lease *Lease) error {
+		return nil
+	}
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dhcpv6.MessageTypeRebind, p.mt.sentPacket.MessageType)
+	assert.Nil(t, p.mt.sentPacket.Options.ServerID(), "Rebind contains server ID")
+	assert.Equal(t, stateBound, p.c.state, "DHCPv6 client didn't rebind")
+}
+
+// TestRebindingSoliciting tests if the DHCPv6 state machine in rebinding
+// state transitions back to soliciting and drops the lease once it expires.
+func TestRebindingSoliciting(t *testing.T) {
+	p := newPuppetClient(stateRebinding)
+
+	p.c.lease = withAddress(newResponse(dhcpv6.MessageTypeReply), 60*time.Second)
+	p.c.leaseDeadline = p.ft.Now().Add(30 * time.Second)
+
+	var callbackCalled bool
+	p.c.LeaseCallback = func(lease *Lease) error {
+		assert.Nil(t, lease, "lease not dropped")
+		callbackCalled = true
+		return nil
+	}
+
+	for i := 0; i < 40; i++ {
+		p.mt.sendPackets()
+		if err := p.c.runState(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if p.c.state == stateSoliciting {
+			break
+		}
+		p.ft.time = p.mt.setDeadline
+
+		if i == 39 {
+			t.Fatal("Too many tries while rebinding, backoff likely wrong")
+		}
+	}
+	assert.Equal(t, stateSoliciting, p.c.state, "DHCPv6 client not soliciting after rebinding expired")
+	assert.True(t, callbackCalled, "lease callback not called")
+	assert.Nil(t, p.c.lease)
+}
+
+// TestInformationRequestInformed tests if the DHCPv6 state machine in
+// stateless mode processes a Reply to an Information-request and waits for
+// the information refresh time.
+func TestInformationRequestInformed(t *testing.T) {
+	p := newPuppetClient(stateInformationRequesting)
+	p.c.RequestAddress = false
+
+	reply := newResponse(dhcpv6.MessageTypeReply)
+	reply.AddOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+	reply.AddOption(dhcpv6.OptInformationRefreshTime(time.Hour))
+	p.mt.sendPackets(reply)
+	p.c.LeaseCallback = func(lease *Lease) error {
+		assert.Equal(t, DNSServers{net.ParseIP("2001:db8::53")}, lease.DNSServers())
+		assert.Empty(t, lease.Addresses)
+		return nil
+	}
+
+	if err := p.c.runState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dhcpv6.MessageTypeInformationRequest, p.mt.sentPacket.MessageType)
+	assert.True(t, p.mt.sentPacket.IsOptionRequested(dhcpv6.OptionInformationRefreshTime), "Information refresh time not requested")
+	assert.Equal(t, stateInformed, p.c.state, "DHCPv6 client didn't process reply")
+	assert.Equal(t, p.ft.Now().Add(time.Hour), p.c.leaseBoundDeadline)
+}
@@ -0,0 +1,35 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dhcp6c provides a client implementation of the DHCPv6 protocol
+// (RFC8415) for Linux-based systems. It supports obtaining non-temporary
+// addresses (IA_NA), delegated prefixes (IA_PD) and, if neither is requested,
+// only configuration information like DNS servers (stateless DHCPv6).
+// Its structure mirrors the one of the dhcp4c package:
+//   - The core DHCPv6 state machine, which lives in dhcpc.go
+//   - Mechanisms to send and receive DHCPv6 messages, which live in transport/
+//   - Standard callbacks which implement necessary kernel configuration steps in
+//     a simple and standalone way living in callback/
+//
+// DHCPv6 does not distribute routes or on-link prefixes, so this client is
+// meant to be used alongside Router Advertisement processing (which the Linux
+// kernel does on its own if accept_ra is enabled).
+//
+// The client slightly bends the specification in the following cases:
+//   - The first acceptable Advertise is selected instead of collecting them
+//     during the first retransmission interval and selecting the one with the
+//     highest preference. Servers with a preference of 255 are thus not treated
+//     specially.
+//   - A new transaction ID is used for every retransmission, just like in the
+//     DHCPv4 client. Servers treat every retransmission as a new transaction.
+//   - Replies are accepted if at least one of the requested IAs contains usable
+//     bindings instead of requiring all of them. The remaining IAs are not
+//     requested again until the next Solicit.
+//   - The Server Unicast option is ignored, all messages are sent to the
+//     All_DHCP_Relay_Agents_and_Servers multicast address.
+//   - Bindings are not released when the client is stopped, they just expire
+//     on the server.
+//   - Reconfigure messages are not supported.
+//   - Duplicate Address Detection is left to the kernel, which performs it for
+//     every added IPv6 address. Addresses failing it are not declined.
+package dhcp6c
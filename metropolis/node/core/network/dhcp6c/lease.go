@@ -0,0 +1,96 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package dhcp6c
+
+import (
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Address is a non-temporary address assigned to the client via an IA_NA.
+type Address struct {
+	IP             net.IP
+	PreferredUntil time.Time
+	ValidUntil     time.Time
+}
+
+// Prefix is a prefix delegated to the client via an IA_PD.
+type Prefix struct {
+	Prefix         *net.IPNet
+	PreferredUntil time.Time
+	ValidUntil     time.Time
+}
+
+// Lease represents the state obtained from a DHCPv6 server. It consists of the
+// addresses and prefixes bound to the client, an expiration timestamp after
+// which all of them are invalid and the options received from the server. It
+// also contains some smart getters for commonly-used options which extract
+// only valid information from options.
+//
+// Leases obtained via stateless DHCPv6 contain no addresses or prefixes and
+// have a zero ExpiresAt as the information in them does not expire.
+type Lease struct {
+	Addresses []Address
+	Prefixes  []Prefix
+	ExpiresAt time.Time
+	Options   dhcpv6.Options
+}
+
+// DNSServers represents an ordered collection of DNS servers
+type DNSServers []net.IP
+
+func (a DNSServers) Equal(b DNSServers) bool {
+	if len(a) == len(b) {
+		if len(a) == 0 {
+			return true // both are empty or nil
+		}
+		for i, aVal := range a {
+			if !aVal.Equal(b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// DNSServers returns all unique valid DNS servers from the DHCPv6 DNS
+// Recursive Name Server option. Link-local servers are not considered valid
+// as the option does not specify the interface they are reachable on.
+// It returns nil if the lease is nil.
+func (l *Lease) DNSServers() DNSServers {
+	if l == nil {
+		return nil
+	}
+	rawServers := dhcpv6.MessageOptions{Options: l.Options}.DNS()
+	var servers DNSServers
+	serversSeenMap := make(map[[16]byte]bool)
+	for _, s := range rawServers {
+		if s.To4() != nil || !s.IsGlobalUnicast() {
+			continue
+		}
+		ip6 := [16]byte(s.To16())
+		if serversSeenMap[ip6] {
+			continue
+		}
+		serversSeenMap[ip6] = true
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+// PrefixNets returns the delegated prefixes of the lease.
+// It returns nil if the lease is nil.
+func (l *Lease) PrefixNets() []*net.IPNet {
+	if l == nil {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, p := range l.Prefixes {
+		nets = append(nets, p.Prefix)
+	}
+	return nets
+}
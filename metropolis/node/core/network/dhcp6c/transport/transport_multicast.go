@@ -0,0 +1,115 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"golang.org/x/sys/unix"
+)
+
+// RFC2474 Section 4.2.2.1 with reference to RFC791 Section 3.1 (Network
+// Control Precedence)
+const dscpCS7 = 0x7 << 3
+
+// MulticastTransport implements a DHCPv6 transport based on a normal Linux UDP
+// socket bound to a single interface. Messages are sent to the
+// All_DHCP_Relay_Agents_and_Servers multicast address, replies are received on
+// the DHCPv6 client port. As DHCPv6 only uses link-local addresses for this,
+// it works without any global address being configured on the interface.
+type MulticastTransport struct {
+	udpConn *net.UDPConn
+	iface   *net.Interface
+}
+
+func NewMulticastTransport(iface *net.Interface) *MulticastTransport {
+	return &MulticastTransport{
+		iface: iface,
+	}
+}
+
+func (t *MulticastTransport) Open() error {
+	if t.udpConn != nil {
+		return errors.New("multicast transport already open")
+	}
+	rawFd, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to get socket: %w", err)
+	}
+	filePtr := os.NewFile(uintptr(rawFd), "dhcp6-udp")
+	defer filePtr.Close()
+	if err := unix.BindToDevice(rawFd, t.iface.Name); err != nil {
+		return fmt.Errorf("failed to bind UDP interface to device: %w", err)
+	}
+	if err := unix.SetsockoptInt(rawFd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscpCS7<<2); err != nil {
+		return fmt.Errorf("failed to set DSCP CS7: %w", err)
+	}
+	if err := unix.SetsockoptInt(rawFd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, t.iface.Index); err != nil {
+		return fmt.Errorf("failed to set multicast interface: %w", err)
+	}
+	if err := unix.Bind(rawFd, &unix.SockaddrInet6{Port: dhcpv6.DefaultClientPort}); err != nil {
+		return fmt.Errorf("failed to bind UDP multicast interface: %w", err)
+	}
+	conn, err := net.FileConn(filePtr)
+	if err != nil {
+		return fmt.Errorf("failed to initialize runtime-supported UDP connection: %w", err)
+	}
+	realConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		panic("UDP socket imported into Go runtime is no longer a UDP socket")
+	}
+	t.udpConn = realConn
+	return nil
+}
+
+func (t *MulticastTransport) Send(payload *dhcpv6.Message) error {
+	if t.udpConn == nil {
+		return errors.New("multicast transport closed")
+	}
+	_, err := t.udpConn.WriteToUDP(payload.ToBytes(), &net.UDPAddr{
+		IP:   dhcpv6.AllDHCPRelayAgentsAndServers,
+		Port: dhcpv6.DefaultServerPort,
+		Zone: t.iface.Name,
+	})
+	return err
+}
+
+func (t *MulticastTransport) SetReceiveDeadline(deadline time.Time) error {
+	return t.udpConn.SetReadDeadline(deadline)
+}
+
+func (t *MulticastTransport) Receive() (*dhcpv6.Message, error) {
+	if t.udpConn == nil {
+		return nil, errors.New("multicast transport closed")
+	}
+	receiveBuf := make([]byte, math.MaxUint16)
+	n, _, err := t.udpConn.ReadFromUDP(receiveBuf)
+	if err != nil {
+		return nil, deadlineFromTimeout(err)
+	}
+	msg, err := dhcpv6.MessageFromBytes(receiveBuf[:n])
+	if err != nil {
+		return nil, NewInvalidMessageError(err)
+	}
+	return msg, nil
+}
+
+func (t *MulticastTransport) Close() error {
+	if t.udpConn == nil {
+		return nil
+	}
+	err := t.udpConn.Close()
+	t.udpConn = nil
+	if err != nil && errors.Is(err, net.ErrClosed) {
+		//nolint:returnerrcheck
+		return nil
+	}
+	return err
+}
@@ -6,9 +6,12 @@ package network
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/google/nftables"
 	"github.com/google/nftables/binaryutil"
@@ -19,6 +22,7 @@ import (
 	"source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/network/dhcp4c"
 	dhcpcb "source.monogon.dev/metropolis/node/core/network/dhcp4c/callback"
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c"
 	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/net/dns"
 	"source.monogon.dev/osbase/net/dns/forward"
@@ -35,7 +39,8 @@ import (
 // itself must be long-lived.
 type Service struct {
 	// If set, use the given static network configuration instead of relying on
-	// autoconfiguration.
+	// autoconfiguration. This is only the initial configuration, which can be
+	// replaced at runtime with SetStaticConfig.
 	StaticConfig *netpb.Net
 
 	// Vendor Class identifier of the system
@@ -48,8 +53,16 @@ type Service struct {
 	dhcp *dhcp4c.Client
 	// dhcpAddress is the current address obtained from DHCP.
 	dhcpAddress net.IP
-	// dnsServers are the current DNS servers obtained from DHCP.
-	dnsServers dhcp4c.DNSServers
+	// dnsServers are the current DNS servers by source, which is either
+	// dnsSourceStatic or the DHCP client on an interface, see dnsSourceDHCP4
+	// and dnsSourceDHCP6.
+	dnsServers map[string][]net.IP
+	// delegatedPrefixes are the current prefixes delegated via DHCPv6, by
+	// interface name.
+	delegatedPrefixes map[string][]*net.IPNet
+	// dhcpMu guards dnsServers and delegatedPrefixes, as DHCP clients on
+	// multiple interfaces can update them concurrently.
+	dhcpMu sync.Mutex
 
 	// nftConn is a shared file descriptor handle to nftables, automatically
 	// initialized on first use.
@@ -59,6 +72,15 @@ type Service struct {
 
 	// Status is the current status of the network as seen by the service.
 	Status memory.Value[*Status]
+
+	// DelegatedPrefixes are the IPv6 prefixes currently delegated to this node
+	// via DHCPv6 prefix delegation.
+	DelegatedPrefixes memory.Value[[]*net.IPNet]
+
+	// config is the network configuration currently requested to be applied,
+	// initialized from StaticConfig once and then updated by SetStaticConfig.
+	config     memory.Value[*configRequest]
+	configOnce sync.Once
 }
 
 // New instantiates a new network service. If autoconfiguration is desired,
@@ -151,18 +173,10 @@ func nfifname(n string) []byte {
 // current lease to the rest of Metropolis. It updates the DNS service's
 // configuration to use the received upstream servers, and notifies the rest of
 // Metropolis via an event value that the network configuration has changed.
-func (s *Service) statusCallback(ctx context.Context) dhcp4c.LeaseCallback {
+func (s *Service) statusCallback(ctx context.Context, iface string) dhcp4c.LeaseCallback {
 	return func(lease *dhcp4c.Lease) error {
 		// Reconfigure DNS if needed.
-		newServers := lease.DNSServers()
-		if !newServers.Equal(s.dnsServers) {
-			s.dnsServers = newServers
-			newAddrs := make([]string, len(newServers))
-			for i, ip := range newServers {
-				newAddrs[i] = net.JoinHostPort(ip.String(), "53")
-			}
-			s.dnsForward.DNSServers.Set(newAddrs)
-		}
+		s.setDNSServers(dnsSourceDHCP4(iface), lease.DNSServers())
 
 		var newAddress net.IP
 		if lease != nil {
@@ -184,6 +198,122 @@ func (s *Service) statusCallback(ctx context.Context) dhcp4c.LeaseCallback {
 	}
 }
 
+// dhcp6StatusCallback is the DHCPv6 client callback connecting updates to the
+// current lease on the given interface to the rest of Metropolis. It updates
+// the DNS service's configuration to additionally use the received upstream
+// servers, and publishes the prefixes delegated on all interfaces.
+func (s *Service) dhcp6StatusCallback(ctx context.Context, iface string) dhcp6c.LeaseCallback {
+	return func(lease *dhcp6c.Lease) error {
+		s.setDNSServers(dnsSourceDHCP6(iface), lease.DNSServers())
+
+		s.dhcpMu.Lock()
+		defer s.dhcpMu.Unlock()
+		if s.delegatedPrefixes == nil {
+			s.delegatedPrefixes = make(map[string][]*net.IPNet)
+		}
+		newPrefixes := lease.PrefixNets()
+		if !slices.EqualFunc(newPrefixes, s.delegatedPrefixes[iface], func(a, b *net.IPNet) bool {
+			return a.String() == b.String()
+		}) {
+			if len(newPrefixes) > 0 {
+				s.delegatedPrefixes[iface] = newPrefixes
+				supervisor.Logger(ctx).Infof("New delegated prefixes on %s: %v", iface, newPrefixes)
+			} else {
+				delete(s.delegatedPrefixes, iface)
+				supervisor.Logger(ctx).Warningf("Lost delegated prefixes on %s", iface)
+			}
+			var all []*net.IPNet
+			for _, name := range slices.Sorted(maps.Keys(s.delegatedPrefixes)) {
+				all = append(all, s.delegatedPrefixes[name]...)
+			}
+			s.DelegatedPrefixes.Set(all)
+		}
+		return nil
+	}
+}
+
+// dnsSourceStatic is the source of DNS servers from the static network
+// configuration.
+const dnsSourceStatic = "static"
+
+// dnsSourceDHCP4 returns the source of DNS servers obtained via DHCPv4 on the
+// given interface.
+func dnsSourceDHCP4(iface string) string {
+	return "dhcp4/" + iface
+}
+
+// dnsSourceDHCP6 returns the source of DNS servers obtained via DHCPv6 on the
+// given interface.
+func dnsSourceDHCP6(iface string) string {
+	return "dhcp6/" + iface
+}
+
+// setDNSServers sets the DNS servers obtained from the given source, replacing
+// the ones previously obtained from it, and reconfigures the DNS forwarder if
+// they changed.
+func (s *Service) setDNSServers(source string, servers []net.IP) {
+	s.dhcpMu.Lock()
+	defer s.dhcpMu.Unlock()
+	if slices.EqualFunc(servers, s.dnsServers[source], net.IP.Equal) {
+		return
+	}
+	if s.dnsServers == nil {
+		s.dnsServers = make(map[string][]net.IP)
+	}
+	if len(servers) > 0 {
+		s.dnsServers[source] = servers
+	} else {
+		delete(s.dnsServers, source)
+	}
+	s.updateDNSServers()
+}
+
+// resetDNSServers removes the DNS servers obtained from all sources, as they
+// belong to a network configuration which is being replaced.
+func (s *Service) resetDNSServers() {
+	s.dhcpMu.Lock()
+	defer s.dhcpMu.Unlock()
+	if len(s.dnsServers) == 0 {
+		return
+	}
+	clear(s.dnsServers)
+	s.updateDNSServers()
+}
+
+// updateDNSServers configures the DNS forwarder to use the DNS servers of all
+// sources: the static configuration first, followed by the DHCPv4 and DHCPv6
+// clients ordered by interface name. Servers obtained from multiple sources
+// are only used once. dhcpMu must be held.
+func (s *Service) updateDNSServers() {
+	sources := slices.SortedFunc(maps.Keys(s.dnsServers), func(a, b string) int {
+		if (a == dnsSourceStatic) != (b == dnsSourceStatic) {
+			if a == dnsSourceStatic {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	var newAddrs []string
+	for _, source := range sources {
+		for _, ip := range s.dnsServers[source] {
+			addr := net.JoinHostPort(ip.String(), "53")
+			if !slices.Contains(newAddrs, addr) {
+				newAddrs = append(newAddrs, addr)
+			}
+		}
+	}
+	s.dnsForward.DNSServers.Set(newAddrs)
+}
+
+// SetEncryptedDNSUpstreams configures the DNS forwarder to use the given DNS
+// over TLS or DNS over HTTPS upstreams instead of the DNS servers obtained via
+// DHCP or static configuration. If upstreams is empty, the latter are used
+// again.
+func (s *Service) SetEncryptedDNSUpstreams(upstreams []forward.Upstream) {
+	s.dnsForward.EncryptedUpstreams.Set(upstreams)
+}
+
 func (s *Service) useInterface(ctx context.Context, iface netlink.Link) error {
 	var err error
 	s.dhcp, err = dhcp4c.NewClient(netlinkLinkToNetInterface(iface))
@@ -192,13 +322,24 @@ func (s *Service) useInterface(ctx context.Context, iface netlink.Link) error {
 	}
 	s.dhcp.VendorClassIdentifier = s.DHCPVendorClassID
 	s.dhcp.RequestedOptions = []dhcpv4.OptionCode{dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer, dhcpv4.OptionClasslessStaticRoute}
-	s.dhcp.LeaseCallback = dhcpcb.Compose(dhcpcb.ManageIP(iface), arpAnnounceCB(iface), dhcpcb.ManageRoutes(iface), s.statusCallback(ctx))
+	s.dhcp.LeaseCallback = dhcpcb.Compose(dhcpcb.ManageIP(iface), arpAnnounceCB(iface), dhcpcb.ManageRoutes(iface), s.statusCallback(ctx, iface.Attrs().Name))
 	err = supervisor.Run(ctx, "dhcp", s.dhcp.Run)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// Configure IPv6 via Router Advertisements, and use stateless DHCPv6 to
+	// obtain further information like DNS servers. Stateful DHCPv6 and prefix
+	// delegation need to be enabled in a static configuration.
+	opts := sysctl.Options{
+		"net.ipv6.conf." + iface.Attrs().Name + ".accept_ra": "1",
+	}
+	if err := opts.Apply(); err != nil {
+		return fmt.Errorf("failed enabling accept_ra for interface %q: %w", iface.Attrs().Name, err)
+	}
+	return s.runDHCPv6(ctx, iface, &netpb.IPv6Autoconfig{
+		Dhcpv6Mode: netpb.IPv6Autoconfig_DHCPV6_MODE_STATELESS,
+	})
 }
 
 // RFC2474 Section 4.2.2.1 with reference to RFC791 Section 3.1 (Network
@@ -241,12 +382,7 @@ func (s *Service) Run(ctx context.Context) error {
 
 	supervisor.Run(ctx, "linkstate", s.runLinkState)
 
-	// Choose between autoconfig and static config runnables
-	if s.StaticConfig == nil {
-		supervisor.Run(ctx, "dynamic", s.runDynamicConfig)
-	} else {
-		supervisor.Run(ctx, "static", s.runStaticConfig)
-	}
+	supervisor.Run(ctx, "config", s.runConfig)
 
 	supervisor.Run(ctx, "dns", s.DNS.Run)
 	supervisor.Run(ctx, "dns-forward", s.dnsForward.Run)
@@ -0,0 +1,108 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package network
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+func TestDHCP6StatusCallback(t *testing.T) {
+	ctxC := make(chan context.Context)
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		ctxC <- ctx
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	ctx := <-ctxC
+
+	s := New(nil, nil)
+	lease := func(dns string, prefix string) *dhcp6c.Lease {
+		_, pnet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+		return &dhcp6c.Lease{
+			Prefixes: []dhcp6c.Prefix{{Prefix: pnet}},
+			Options:  dhcpv6.Options{dhcpv6.OptDNS(net.ParseIP(dns))},
+		}
+	}
+	expect := func(wantDNS, wantPrefixes []string) {
+		t.Helper()
+		dns, err := s.dnsForward.DNSServers.Watch().Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !slices.Equal(dns, wantDNS) {
+			t.Errorf("DNS servers are %v, wanted %v", dns, wantDNS)
+		}
+		prefixes, err := s.DelegatedPrefixes.Watch().Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		var got []string
+		for _, p := range prefixes {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, wantPrefixes) {
+			t.Errorf("Delegated prefixes are %v, wanted %v", got, wantPrefixes)
+		}
+	}
+
+	// Leases on multiple interfaces are combined, ordered by interface.
+	eth1 := s.dhcp6StatusCallback(ctx, "eth1")
+	eth0 := s.dhcp6StatusCallback(ctx, "eth0")
+	if err := eth1(lease("2001:db8::53", "2001:db8:1::/56")); err != nil {
+		t.Fatal(err)
+	}
+	if err := eth0(lease("2001:db8::153", "2001:db8:2::/56")); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"[2001:db8::153]:53", "[2001:db8::53]:53"}, []string{"2001:db8:2::/56", "2001:db8:1::/56"})
+
+	// Losing the lease on one interface keeps the other one.
+	if err := eth0(nil); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"[2001:db8::53]:53"}, []string{"2001:db8:1::/56"})
+}
+
+// TestDNSServerSources ensures that DNS servers from the static configuration
+// and from DHCP clients are used together instead of replacing each other.
+func TestDNSServerSources(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil, nil)
+	expect := func(want ...string) {
+		t.Helper()
+		got, err := s.dnsForward.DNSServers.Watch().Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("DNS servers are %v, wanted %v", got, want)
+		}
+	}
+
+	s.setDNSServers(dnsSourceDHCP6("eth0"), []net.IP{net.ParseIP("2001:db8::53")})
+	s.setDNSServers(dnsSourceDHCP4("eth0"), []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("192.0.2.1")})
+	s.setDNSServers(dnsSourceStatic, []net.IP{net.ParseIP("192.0.2.1")})
+	// Static servers come first, servers from multiple sources are only used
+	// once.
+	expect("192.0.2.1:53", "192.0.2.53:53", "[2001:db8::53]:53")
+
+	// Updating one source keeps the others.
+	s.setDNSServers(dnsSourceDHCP4("eth0"), nil)
+	expect("192.0.2.1:53", "[2001:db8::53]:53")
+
+	s.resetDNSServers()
+	expect()
+}
@@ -22,6 +22,8 @@ import (
 	"source.monogon.dev/go/logging"
 	"source.monogon.dev/metropolis/node/core/network/dhcp4c"
 	dhcpcb "source.monogon.dev/metropolis/node/core/network/dhcp4c/callback"
+	"source.monogon.dev/metropolis/node/core/network/dhcp6c"
+	dhcp6cb "source.monogon.dev/metropolis/node/core/network/dhcp6c/callback"
 	"source.monogon.dev/osbase/supervisor"
 	"source.monogon.dev/osbase/sysctl"
 
@@ -33,10 +35,20 @@ var vlanProtoMap = map[netpb.VLAN_Protocol]netlink.VlanProtocol{
 	netpb.VLAN_PROTOCOL_SVLAN: netlink.VLAN_PROTOCOL_8021AD,
 }
 
-func (s *Service) runStaticConfig(ctx context.Context) error {
+// runStaticConfig applies the static network configuration of the given
+// request. All changes are reverted when the runnable fails or its context is
+// canceled, so that another configuration can be applied afterwards.
+func (s *Service) runStaticConfig(ctx context.Context, req *configRequest) (err error) {
+	// Report failure to apply the configuration to SetStaticConfig.
+	defer func() {
+		if err != nil {
+			req.finish(err)
+		}
+	}()
+
 	l := supervisor.Logger(ctx)
-	var success bool
-	sortedInterfaces, err := getSortedIfaces(s)
+	config := req.static
+	sortedInterfaces, err := getSortedIfaces(config)
 	if err != nil {
 		return err
 	}
@@ -90,10 +102,8 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 				return fmt.Errorf("failed to add link %q: %w", i.Name, err)
 			}
 			defer func() {
-				if !success {
-					if err := netlink.LinkDel(newLink); err != nil {
-						l.Errorf("Failed to delete link on teardown: %v", err)
-					}
+				if err := netlink.LinkDel(newLink); err != nil {
+					l.Errorf("Failed to delete link on teardown: %v", err)
 				}
 			}()
 		} else {
@@ -101,10 +111,8 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 				return fmt.Errorf("failed to modify link %q: %w", i.Name, err)
 			}
 			defer func() {
-				if !success {
-					if err := netlink.LinkSetDown(newLink); err != nil {
-						l.Errorf("Failed to set link down: %v", err)
-					}
+				if err := netlink.LinkSetDown(newLink); err != nil {
+					l.Errorf("Failed to set link down: %v", err)
 				}
 			}()
 		}
@@ -128,38 +136,55 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 			if err := opts.Apply(); err != nil {
 				return fmt.Errorf("failed enabling accept_ra for interface %q: %w", newLink.Attrs().Name, err)
 			}
-			// TODO(lorenz): Actually implement DHCPv6/Managed flag
+			if i.Ipv6Autoconfig.Dhcpv6Mode != netpb.IPv6Autoconfig_DHCPV6_MODE_DISABLE {
+				if err := s.runDHCPv6(ctx, newLinkWithAttrs, i.Ipv6Autoconfig); err != nil {
+					return fmt.Errorf("error enabling DHCPv6 on %q: %w", newLink.Attrs().Name, err)
+				}
+			}
 		}
 		for _, a := range i.Address {
-			if err := addAddrFromSpec(a, newLink); err != nil {
+			addr, err := addAddrFromSpec(a, newLink)
+			if err != nil {
 				return fmt.Errorf("failed adding address %q to link: %w", a, err)
 			}
+			// Addresses are kept on links which are set down, so they need
+			// to be removed explicitly.
+			defer func() {
+				if err := netlink.AddrDel(newLink, addr); err != nil {
+					l.Errorf("Failed to delete address on teardown: %v", err)
+				}
+			}()
 		}
 		for _, r := range i.Route {
-			if err := routeFromSpec(r, newLink); err != nil {
+			route, err := routeFromSpec(r, newLink)
+			if err != nil {
 				return fmt.Errorf("failed creating route on interface %q: %w", i.Name, err)
 			}
+			defer func() {
+				if err := netlink.RouteDel(route); err != nil && !errors.Is(err, unix.ESRCH) {
+					l.Errorf("Failed to delete route on teardown: %v", err)
+				}
+			}()
 		}
 		l.Infof("Configured interface %q", i.Name)
 	}
-	var nsAddrList []string
-	for _, ns := range s.StaticConfig.Nameserver {
+	var nameservers []net.IP
+	for _, ns := range config.Nameserver {
 		nsIP := net.ParseIP(ns.Ip)
 		if nsIP == nil {
 			l.Warningf("failed to parse %q as nameserver IP", ns.Ip)
 			continue
 		}
-		nsAddr := net.JoinHostPort(nsIP.String(), "53")
-		nsAddrList = append(nsAddrList, nsAddr)
-	}
-	if len(nsAddrList) > 0 {
-		s.dnsForward.DNSServers.Set(nsAddrList)
+		nameservers = append(nameservers, nsIP)
 	}
+	// Nameservers from the static configuration are used in addition to, and
+	// before, the ones obtained via DHCP.
+	s.setDNSServers(dnsSourceStatic, nameservers)
 
 	if !hasIPv4Autoconfig {
 		var selectedAddr net.IP
 	ifLoop:
-		for _, i := range s.StaticConfig.Interface {
+		for _, i := range config.Interface {
 			if i.Ipv4Autoconfig != nil {
 				continue
 			}
@@ -181,9 +206,11 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 	}
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
-	success = true
-	supervisor.Signal(ctx, supervisor.SignalDone)
-	return nil
+	req.finish(nil)
+	// Keep running until canceled, so that the configuration is torn down by
+	// the deferred functions above before another one gets applied.
+	<-ctx.Done()
+	return ctx.Err()
 }
 
 func (s *Service) runDHCPv4(ctx context.Context, lnk netlink.Link) error {
@@ -192,21 +219,40 @@ func (s *Service) runDHCPv4(ctx context.Context, lnk netlink.Link) error {
 		return fmt.Errorf("failed creating DHCPv4 client: %w", err)
 	}
 	c.RequestedOptions = []dhcpv4.OptionCode{dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer, dhcpv4.OptionClasslessStaticRoute}
-	c.LeaseCallback = dhcpcb.Compose(dhcpcb.ManageIP(lnk), arpAnnounceCB(lnk), dhcpcb.ManageRoutes(lnk), s.statusCallback(ctx))
+	c.LeaseCallback = dhcpcb.Compose(dhcpcb.ManageIP(lnk), arpAnnounceCB(lnk), dhcpcb.ManageRoutes(lnk), s.statusCallback(ctx, lnk.Attrs().Name))
 	return supervisor.Run(ctx, "dhcp-"+lnk.Attrs().Name, c.Run)
 }
 
+func (s *Service) runDHCPv6(ctx context.Context, lnk netlink.Link, config *netpb.IPv6Autoconfig) error {
+	c, err := dhcp6c.NewClient(netlinkLinkToNetInterface(lnk))
+	if err != nil {
+		return fmt.Errorf("failed creating DHCPv6 client: %w", err)
+	}
+	c.RequestAddress = config.Dhcpv6Mode == netpb.IPv6Autoconfig_DHCPV6_MODE_STATEFUL
+	c.RequestPrefixDelegation = config.RequestPrefixDelegation
+	statusCB := s.dhcp6StatusCallback(ctx, lnk.Attrs().Name)
+	if c.RequestAddress {
+		c.LeaseCallback = dhcp6cb.Compose(dhcp6cb.ManageAddresses(lnk), statusCB)
+	} else {
+		c.LeaseCallback = statusCB
+	}
+	return supervisor.Run(ctx, "dhcp6-"+lnk.Attrs().Name, c.Run)
+}
+
 // getSortedIfaces returns a list of all interfaces to be configured in
 // an order which is valid to configure them in, ie. parent interfaces get
 // configured before child interfaces. It also validates that all interfaces
 // referenced do in fact exist in the configuration.
-func getSortedIfaces(s *Service) ([]*netpb.Interface, error) {
+func getSortedIfaces(config *netpb.Net) ([]*netpb.Interface, error) {
 	var depGraph toposort.Graph[string]
 	ifMap := make(map[string]*netpb.Interface)
-	for _, iface := range s.StaticConfig.Interface {
+	for _, iface := range config.Interface {
 		if err := isValidDevName(iface.Name); err != nil {
 			return nil, fmt.Errorf("invalid interface name %q: %w", iface.Name, err)
 		}
+		if ac := iface.Ipv6Autoconfig; ac != nil && ac.RequestPrefixDelegation && ac.Dhcpv6Mode != netpb.IPv6Autoconfig_DHCPV6_MODE_STATEFUL {
+			return nil, fmt.Errorf("interface %q requests prefix delegation without stateful DHCPv6", iface.Name)
+		}
 		ifMap[iface.Name] = iface
 		depGraph.AddNode(iface.Name)
 		switch it := iface.Type.(type) {
@@ -374,13 +420,13 @@ func bondIfaceFromSpec(it *netpb.Interface_Bond, i *netpb.Interface) (*netlink.B
 	return newBond, nil
 }
 
-func addAddrFromSpec(a string, link netlink.Link) error {
+func addAddrFromSpec(a string, link netlink.Link) (*netlink.Addr, error) {
 	var addr netlink.Addr
 	ipNet, err := addressOrPrefix(a)
 	if err != nil {
 		// Error already contains original string and enough wrapping
 		// is already done, so pass through directly.
-		return err
+		return nil, err
 	}
 	addr.IPNet = ipNet
 	if ones, size := addr.Mask.Size(); ones == size {
@@ -392,19 +438,19 @@ func addAddrFromSpec(a string, link netlink.Link) error {
 	// Kernel will add the on-link prefix for us in the routing
 	// table if required.
 	if err := netlink.AddrAdd(link, &addr); err != nil {
-		return fmt.Errorf("failed to add to kernel interface: %w", err)
+		return nil, fmt.Errorf("failed to add to kernel interface: %w", err)
 	}
-	return nil
+	return &addr, nil
 }
 
-func routeFromSpec(r *netpb.Interface_Route, link netlink.Link) error {
+func routeFromSpec(r *netpb.Interface_Route, link netlink.Link) (*netlink.Route, error) {
 	var route netlink.Route
 	dst, err := addressOrPrefix(r.Destination)
 	if err != nil {
-		return fmt.Errorf("destination invalid: %w", err)
+		return nil, fmt.Errorf("destination invalid: %w", err)
 	}
 	if !dst.IP.Mask(dst.Mask).Equal(dst.IP) {
-		return fmt.Errorf("destination %v has bits in the mask set", r.Destination)
+		return nil, fmt.Errorf("destination %v has bits in the mask set", r.Destination)
 	}
 	route.Dst = dst
 	route.Protocol = unix.RTPROT_STATIC
@@ -413,14 +459,14 @@ func routeFromSpec(r *netpb.Interface_Route, link netlink.Link) error {
 	if r.SourceIp != "" {
 		srcIP := net.ParseIP(r.SourceIp)
 		if srcIP == nil {
-			return fmt.Errorf("failed parsing %q as IP", r.SourceIp)
+			return nil, fmt.Errorf("failed parsing %q as IP", r.SourceIp)
 		}
 		route.Src = srcIP
 	}
 	if r.GatewayIp != "" {
 		gwIP := net.ParseIP(r.GatewayIp)
 		if gwIP == nil {
-			return fmt.Errorf("failed parsing %q as IP", r.GatewayIp)
+			return nil, fmt.Errorf("failed parsing %q as IP", r.GatewayIp)
 		}
 		route.Gw = gwIP
 
@@ -429,9 +475,9 @@ func routeFromSpec(r *netpb.Interface_Route, link netlink.Link) error {
 		route.Flags |= int(netlink.FLAG_ONLINK)
 	}
 	if err := netlink.RouteAdd(&route); err != nil {
-		return fmt.Errorf("failed creating kernel route %q: %w", r.Destination, err)
+		return nil, fmt.Errorf("failed creating kernel route %q: %w", r.Destination, err)
 	}
-	return nil
+	return &route, nil
 }
 
 func addressOrPrefix(s string) (*net.IPNet, error) {
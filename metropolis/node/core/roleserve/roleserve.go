@@ -44,11 +44,13 @@ import (
 
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/clusternet"
+	"source.monogon.dev/metropolis/node/core/consensus"
 	"source.monogon.dev/metropolis/node/core/curator"
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/network"
 	"source.monogon.dev/metropolis/node/core/rpc/resolver"
+	timesvc "source.monogon.dev/metropolis/node/core/time"
 	"source.monogon.dev/metropolis/node/core/update"
 	cpb "source.monogon.dev/metropolis/proto/common"
 	"source.monogon.dev/osbase/event/memory"
@@ -66,6 +68,10 @@ type Config struct {
 	// Network is a handle to the network service, used by workloads.
 	Network *network.Service
 
+	// Time is a handle to the time service, which is configured from the
+	// cluster and whose status is reported to the cluster.
+	Time *timesvc.Service
+
 	// resolver is the main, long-lived, authenticated cluster resolver that is used
 	// for all subsequent gRPC calls by the subordinates of the roleserver. It is
 	// created early in the roleserver lifecycle, and is seeded with node
@@ -76,6 +82,10 @@ type Config struct {
 	Update *update.Service
 
 	LogTree *logtree.LogTree
+
+	// SupervisorState is the state of the supervision tree of the node, served
+	// by the node management API.
+	SupervisorState *supervisor.InMemoryMetrics
 }
 
 // Service is the roleserver/“Role Server” service. See the package-level
@@ -90,6 +100,12 @@ type Service struct {
 	clusterDirectorySaved memory.Value[bool]
 	localControlPlane     memory.Value[*localControlPlane]
 	CuratorConnection     memory.Value[*CuratorConnection]
+	heartbeats            memory.Value[uint64]
+	updateFailure         memory.Value[*updateFailure]
+	clusterConfiguration  memory.Value[*cpb.ClusterConfiguration]
+	// revocations are kept up to date by the crl worker, and used by all
+	// services authenticating cluster users.
+	revocations identity.Revocations
 
 	controlPlane *workerControlPlane
 	statusPush   *workerStatusPush
@@ -100,6 +116,16 @@ type Service struct {
 	clusternet   *workerClusternet
 	hostsfile    *workerHostsfile
 	metrics      *workerMetrics
+	healthGate   *workerHealthGate
+	imageCache   *workerImageCache
+	logShip      *workerLogShip
+	dns          *workerDNS
+	measurements *workerMeasurements
+	certRenewal  *workerCertRenewal
+	crl          *workerCRL
+	time         *workerTime
+
+	clusterConfig *workerClusterConfig
 }
 
 // New creates a Role Server services from a Config.
@@ -116,20 +142,25 @@ func New(c Config) *Service {
 
 		localControlPlane: &s.localControlPlane,
 		curatorConnection: &s.CuratorConnection,
+		revocations:       &s.revocations,
 	}
 
 	s.statusPush = &workerStatusPush{
 		network: s.Network,
+		update:  s.Update,
+		time:    s.Time,
 
 		curatorConnection:     &s.CuratorConnection,
 		localControlPlane:     &s.localControlPlane,
 		clusterDirectorySaved: &s.clusterDirectorySaved,
+		updateFailure:         &s.updateFailure,
 	}
 
 	s.heartbeat = &workerHeartbeat{
 		network: s.Network,
 
 		curatorConnection: &s.CuratorConnection,
+		heartbeats:        &s.heartbeats,
 	}
 
 	s.kubernetes = &workerKubernetes{
@@ -142,6 +173,7 @@ func New(c Config) *Service {
 
 		kubernetesStatus: &s.KubernetesStatus,
 		podNetwork:       &s.podNetwork,
+		revocations:      &s.revocations,
 	}
 
 	s.rolefetch = &workerRoleFetch{
@@ -152,9 +184,17 @@ func New(c Config) *Service {
 	}
 
 	s.nodeMgmt = &workerNodeMgmt{
+		storageRoot:       s.StorageRoot,
 		curatorConnection: &s.CuratorConnection,
+		localControlPlane: &s.localControlPlane,
 		logTree:           s.LogTree,
 		updateService:     s.Update,
+		supervisorState:   s.SupervisorState,
+		network:           s.Network,
+		revocations:       &s.revocations,
+		clusterConfig:     &s.clusterConfiguration,
+
+		updateFailure: &s.updateFailure,
 	}
 
 	s.clusternet = &workerClusternet{
@@ -178,6 +218,65 @@ func New(c Config) *Service {
 		localControlplane: &s.localControlPlane,
 	}
 
+	s.healthGate = &workerHealthGate{
+		update: s.Update,
+
+		curatorConnection: &s.CuratorConnection,
+		heartbeats:        &s.heartbeats,
+		localRoles:        &s.LocalRoles,
+		localControlPlane: &s.localControlPlane,
+	}
+
+	s.imageCache = &workerImageCache{
+		update: s.Update,
+
+		curatorConnection: &s.CuratorConnection,
+	}
+
+	s.logShip = &workerLogShip{
+		logTree: s.LogTree,
+
+		curatorConnection: &s.CuratorConnection,
+		clusterConfig:     &s.clusterConfiguration,
+	}
+
+	s.dns = &workerDNS{
+		network: s.Network,
+
+		clusterConfig: &s.clusterConfiguration,
+	}
+
+	s.measurements = &workerMeasurements{
+		curatorConnection: &s.CuratorConnection,
+	}
+
+	s.certRenewal = &workerCertRenewal{
+		storageRoot: s.StorageRoot,
+
+		curatorConnection: &s.CuratorConnection,
+	}
+
+	s.crl = &workerCRL{
+		storageRoot: s.StorageRoot,
+
+		curatorConnection: &s.CuratorConnection,
+
+		revocations: &s.revocations,
+	}
+
+	s.time = &workerTime{
+		time: s.Time,
+
+		curatorConnection: &s.CuratorConnection,
+		clusterConfig:     &s.clusterConfiguration,
+	}
+
+	s.clusterConfig = &workerClusterConfig{
+		curatorConnection: &s.CuratorConnection,
+
+		clusterConfig: &s.clusterConfiguration,
+	}
+
 	return s
 }
 
@@ -199,6 +298,11 @@ type BootstrapData struct {
 		// Reported TPM usage by the node.
 		TPMUsage cpb.NodeTPMUsage
 
+		// DER-encoded PKIX public key of the endorsement key of the node's
+		// TPM, if any. Recorded in the cluster to attest the node when it
+		// joins the cluster.
+		EKPublicKey []byte
+
 		// Initial labels for the node.
 		Labels map[string]string
 	}
@@ -209,6 +313,15 @@ type BootstrapData struct {
 		InitialOwnerKey []byte
 		// Initial cluster configuration.
 		Configuration *curator.Cluster
+
+		// Restore is set if the cluster is restored from a consensus snapshot
+		// instead of being bootstrapped from scratch. InitialOwnerKey and
+		// Configuration are then ignored, as they are part of the snapshot.
+		Restore *consensus.Snapshot
+		// RestoreCACertificate is the DER-encoded CA certificate which the
+		// restored cluster is expected to have, or nil if it should not be
+		// checked.
+		RestoreCACertificate []byte
 	}
 }
 
@@ -238,7 +351,7 @@ func (s *Service) ProvideRegisterData(credentials identity.NodeCredentials, dire
 	s.CuratorConnection.Set(newCuratorConnection(&credentials, s.Resolver))
 }
 
-func (s *Service) ProvideJoinData(credentials identity.NodeCredentials, directory *cpb.ClusterDirectory) {
+func (s *Service) ProvideJoinData(ctx context.Context, credentials identity.NodeCredentials, directory *cpb.ClusterDirectory) {
 	// This is the first time we have the node ID, tell the resolver that it's
 	// available on the loopback interface.
 	s.Resolver.AddOverride(credentials.ID(), resolver.NodeByHostPort("127.0.0.1", uint16(common.CuratorServicePort)))
@@ -252,6 +365,12 @@ func (s *Service) ProvideJoinData(credentials identity.NodeCredentials, director
 		}
 	}
 
+	// Services authenticating cluster users are started once the curator
+	// connection is available, so the last known CRL has to be loaded first.
+	if err := loadPersistedCRL(s.StorageRoot, &s.revocations, credentials.ClusterCA()); err != nil {
+		supervisor.Logger(ctx).Warningf("Could not load persisted CRL: %v", err)
+	}
+
 	s.CuratorConnection.Set(newCuratorConnection(&credentials, s.Resolver))
 	s.clusterDirectorySaved.Set(true)
 }
@@ -268,6 +387,15 @@ func (s *Service) Run(ctx context.Context) error {
 	supervisor.Run(ctx, "clusternet", s.clusternet.run)
 	supervisor.Run(ctx, "hostsfile", s.hostsfile.run)
 	supervisor.Run(ctx, "metrics", s.metrics.run)
+	supervisor.Run(ctx, "healthgate", s.healthGate.run)
+	supervisor.Run(ctx, "imagecache", s.imageCache.run)
+	supervisor.Run(ctx, "logship", s.logShip.run)
+	supervisor.Run(ctx, "dns", s.dns.run)
+	supervisor.Run(ctx, "measurements", s.measurements.run)
+	supervisor.Run(ctx, "certrenewal", s.certRenewal.run)
+	supervisor.Run(ctx, "crl", s.crl.run)
+	supervisor.Run(ctx, "time", s.time.run)
+	supervisor.Run(ctx, "clusterconfig", s.clusterConfig.run)
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
 
 	<-ctx.Done()
@@ -4,6 +4,9 @@
 package roleserve
 
 import (
+	"context"
+	"sync/atomic"
+
 	"google.golang.org/grpc"
 
 	"source.monogon.dev/metropolis/node/core/consensus"
@@ -12,6 +15,8 @@ import (
 	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/metropolis/node/core/rpc/resolver"
 	"source.monogon.dev/metropolis/node/kubernetes"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
 // localControlPlane is an internal EventValue structure which carries
@@ -48,16 +53,15 @@ func (l *localControlPlane) exists() bool {
 type CuratorConnection struct {
 	Credentials *identity.NodeCredentials
 	resolver    *resolver.Resolver
-	conn        *grpc.ClientConn
+	conn        *curatorConn
 }
 
 func newCuratorConnection(creds *identity.NodeCredentials, res *resolver.Resolver) *CuratorConnection {
-	c := rpc.NewAuthenticatedCredentials(creds.TLSCredentials(), rpc.WantRemoteCluster(creds.ClusterCA()))
-	conn, err := grpc.NewClient(resolver.MetropolisControlAddress, grpc.WithTransportCredentials(c), grpc.WithResolvers(res))
-	if err != nil {
-		// TODO: triple check that NewClient will not fail
-		panic(err)
+	conn := &curatorConn{
+		creds:    creds,
+		resolver: res,
 	}
+	conn.cur.Store(conn.dial())
 	return &CuratorConnection{
 		Credentials: creds,
 		resolver:    res,
@@ -69,6 +73,58 @@ func (c *CuratorConnection) nodeID() string {
 	return c.Credentials.ID()
 }
 
+// curatorConn is a gRPC client connection to the cluster's control plane, which
+// can be replaced by a new connection without having to recreate the clients
+// using it. This is used to present renewed node credentials to the cluster, as
+// TLS connections keep using the certificate they were established with.
+type curatorConn struct {
+	creds    *identity.NodeCredentials
+	resolver *resolver.Resolver
+	cur      atomic.Pointer[grpc.ClientConn]
+}
+
+func (c *curatorConn) dial() *grpc.ClientConn {
+	creds := rpc.NewAuthenticatedNodeCredentials(c.creds, rpc.WantRemoteCluster(c.creds.ClusterCA()))
+	conn, err := grpc.NewClient(resolver.MetropolisControlAddress, grpc.WithTransportCredentials(creds), grpc.WithResolvers(c.resolver))
+	if err != nil {
+		// TODO: triple check that NewClient will not fail
+		panic(err)
+	}
+	return conn
+}
+
+// Invoke implements grpc.ClientConnInterface.
+func (c *curatorConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return c.cur.Load().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface.
+func (c *curatorConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return c.cur.Load().NewStream(ctx, desc, method, opts...)
+}
+
+// reconnect replaces the underlying connection by a new one, which uses the
+// current node credentials. Calls started afterwards use the new connection,
+// while calls already in progress continue on the previous one. The previous
+// connection is returned, and must be closed by the caller.
+func (c *curatorConn) reconnect() *grpc.ClientConn {
+	return c.cur.Swap(c.dial())
+}
+
+// Close closes the underlying connection.
+func (c *curatorConn) Close() error {
+	return c.cur.Load().Close()
+}
+
+// updateFailure is an internal EventValue structure which carries a failure to
+// install an update requested by the cluster.
+type updateFailure struct {
+	// updateID is the ID of the update which could not be installed.
+	updateID string
+	// status is the failure as reported in the node status.
+	status *cpb.NodeUpdateFailure
+}
+
 // KubernetesStatus is an Event Value structure populated by a running
 // Kubernetes instance. It allows external services to access the Kubernetes
 // Service whenever available (ie. enabled and started by the Role Server).
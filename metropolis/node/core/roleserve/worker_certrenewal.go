@@ -0,0 +1,120 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/metropolis/node/core/metrics"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+)
+
+// workerCertRenewal renews the node certificate before it expires. The renewed
+// certificate is persisted and swapped into the node credentials in place, so
+// that all servers and clients using them present it on new connections
+// without having to be restarted.
+type workerCertRenewal struct {
+	storageRoot *localstorage.Root
+
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+}
+
+func (s *workerCertRenewal) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	cur := ipb.NewCuratorClient(cc.conn)
+	for {
+		cert := cc.Credentials.Certificate()
+		metrics.CertificateExpiry.WithLabelValues("node").Set(float64(cert.NotAfter.Unix()))
+		renewAt := identity.RenewalTime(cert)
+		supervisor.Logger(ctx).Infof("Node certificate expires at %s, renewing after %s.", cert.NotAfter, renewAt)
+		select {
+		case <-time.After(time.Until(renewAt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		bo := backoff.NewExponentialBackOff()
+		bo.MaxInterval = time.Hour
+		bo.MaxElapsedTime = 0
+		var creds *identity.NodeCredentials
+		err = backoff.Retry(func() error {
+			creds, err = renewNodeCredentials(ctx, cur, cc.Credentials)
+			if err != nil {
+				supervisor.Logger(ctx).Warningf("Could not renew node certificate (expires at %s): %v", cert.NotAfter, err)
+			}
+			return err
+		}, backoff.WithContext(bo, ctx))
+		if err != nil {
+			return err
+		}
+
+		if err := creds.Save(&s.storageRoot.Data.Node.Credentials); err != nil {
+			return fmt.Errorf("while saving node credentials: %w", err)
+		}
+		if err := cc.Credentials.Renew(creds.Certificate().Raw); err != nil {
+			return fmt.Errorf("while renewing node credentials: %w", err)
+		}
+		supervisor.Logger(ctx).Infof("Renewed node certificate, now expiring at %s.", creds.Certificate().NotAfter)
+
+		// Calls on the established connection to the control plane still present
+		// the previous certificate. Replace the connection, and close the previous
+		// one once the previous certificate expired, at which point calls on it
+		// would be refused anyway.
+		prev := cc.conn.reconnect()
+		time.AfterFunc(time.Until(cert.NotAfter), func() {
+			prev.Close()
+		})
+	}
+}
+
+// renewNodeCredentials retrieves a renewed certificate for the given node
+// credentials from the cluster.
+func renewNodeCredentials(ctx context.Context, cur ipb.CuratorClient, creds *identity.NodeCredentials) (*identity.NodeCredentials, error) {
+	res, err := cur.IssueCertificate(ctx, &ipb.IssueCertificateRequest{
+		Kind: &ipb.IssueCertificateRequest_Node_{
+			Node: &ipb.IssueCertificateRequest_Node{},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("IssueCertificate: %w", err)
+	}
+	n := res.GetNode()
+	if n == nil {
+		return nil, fmt.Errorf("no node certificate in response")
+	}
+	if !bytes.Equal(n.CaCertificate, creds.ClusterCA().Raw) {
+		return nil, fmt.Errorf("cluster returned different CA certificate")
+	}
+	priv := creds.TLSCredentials().PrivateKey.(ed25519.PrivateKey)
+	renewed, err := identity.NewNodeCredentials(priv, n.NodeCertificate, n.CaCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node certificate: %w", err)
+	}
+	// The cluster only renews the certificate once it's due according to its
+	// own clock, so it might still return the current certificate.
+	if !renewed.Certificate().NotAfter.After(creds.Certificate().NotAfter) {
+		return nil, fmt.Errorf("cluster did not renew certificate yet")
+	}
+	return renewed, nil
+}
@@ -0,0 +1,71 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// clusterConfigPollInterval is the interval at which the cluster configuration
+// is retrieved, as the curator does not allow watching it.
+const clusterConfigPollInterval = time.Minute
+
+// workerClusterConfig is an internal bookkeeping service responsible for
+// populating clusterConfig with the cluster configuration, which it polls
+// periodically from the curator. Workers which apply parts of the cluster
+// configuration to this node watch clusterConfig instead of retrieving the
+// cluster configuration themselves.
+type workerClusterConfig struct {
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+
+	// clusterConfig will be written whenever the cluster configuration
+	// changes.
+	clusterConfig *memory.Value[*cpb.ClusterConfiguration]
+}
+
+func (s *workerClusterConfig) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	mgmt := apb.NewManagementClient(cc.conn)
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	var cfg *cpb.ClusterConfiguration
+	t := time.NewTicker(clusterConfigPollInterval)
+	defer t.Stop()
+	for {
+		info, err := mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+		if err != nil {
+			return fmt.Errorf("while getting cluster info: %w", err)
+		}
+		if info.ClusterConfiguration == nil {
+			return fmt.Errorf("cluster info contains no cluster configuration")
+		}
+		if cfg == nil || !proto.Equal(cfg, info.ClusterConfiguration) {
+			cfg = info.ClusterConfiguration
+			s.clusterConfig.Set(cfg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -6,6 +6,7 @@ package roleserve
 import (
 	"context"
 	"net"
+	"net/netip"
 
 	"source.monogon.dev/metropolis/node/core/clusternet"
 	"source.monogon.dev/metropolis/node/core/localstorage"
@@ -16,6 +17,10 @@ import (
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 )
 
+// clusterNet is the prefix of the cluster network, which is routed through
+// the clusternet mesh.
+var clusterNet = netip.MustParsePrefix("10.192.0.0/11")
+
 type workerClusternet struct {
 	storageRoot *localstorage.Root
 
@@ -40,8 +45,8 @@ func (s *workerClusternet) run(ctx context.Context) error {
 	svc := clusternet.Service{
 		Curator: cur,
 		ClusterNet: net.IPNet{
-			IP:   []byte{10, 192, 0, 0},
-			Mask: net.IPMask{255, 224, 0, 0},
+			IP:   clusterNet.Addr().AsSlice(),
+			Mask: net.CIDRMask(clusterNet.Bits(), clusterNet.Addr().BitLen()),
 		},
 		DataDirectory:             &s.storageRoot.Data.Kubernetes.ClusterNetworking,
 		LocalKubernetesPodNetwork: s.podNetwork,
@@ -7,9 +7,13 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+
 	"source.monogon.dev/metropolis/node/core/consensus"
 	"source.monogon.dev/metropolis/node/core/curator"
 	"source.monogon.dev/metropolis/node/core/identity"
@@ -53,6 +57,8 @@ type workerControlPlane struct {
 	localControlPlane *memory.Value[*localControlPlane]
 	// curatorConnection will be written.
 	curatorConnection *memory.Value[*CuratorConnection]
+	// revocations will be used by the curator.
+	revocations *identity.Revocations
 }
 
 // controlPlaneStartup is used internally to provide a reduced (as in MapReduce)
@@ -150,6 +156,7 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 							Ephemeral:      &s.storageRoot.Ephemeral.Consensus,
 							NodeID:         bd.Node.ID,
 							NodePrivateKey: bd.Node.PrivateKey,
+							Restore:        bd.Cluster.Restore,
 						},
 						bootstrap: bd,
 					})
@@ -236,6 +243,17 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 		// Start Control Plane if we have a config.
 		if startup.consensusConfig == nil {
 			supervisor.Logger(ctx).Infof("No consensus config, not starting up control plane.")
+			// Any etcd data left over from previously being a consensus member (eg.
+			// before the cluster was recovered with ForceNewConsensus) is stale, and
+			// would prevent this node from joining consensus again later. Only
+			// discard it once the cluster's removal of the role has been persisted,
+			// so that the data of a consensus member is never lost to a transient
+			// lack of configuration.
+			if s.consensusRoleRemoved(ctx) {
+				if err := consensus.DiscardData(&s.storageRoot.Data.Etcd); err != nil {
+					return fmt.Errorf("failed to discard stale consensus data: %w", err)
+				}
+			}
 			s.localControlPlane.Set(nil)
 		} else {
 			supervisor.Logger(ctx).Infof("Got config, starting consensus and curator...")
@@ -284,6 +302,7 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 					JPub:     jpub,
 					TPMUsage: b.Node.TPMUsage,
 					Labels:   b.Node.Labels,
+					EKPub:    b.Node.EKPublicKey,
 				})
 
 				// The first node always runs consensus.
@@ -296,9 +315,16 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 				n.EnableKubernetesController()
 
 				var nodeCert []byte
-				caCert, nodeCert, err = curator.BootstrapNodeFinish(ctx, ckv, &n, b.Cluster.InitialOwnerKey, b.Cluster.Configuration)
-				if err != nil {
-					return fmt.Errorf("while bootstrapping node: %w", err)
+				if b.Cluster.Restore != nil {
+					caCert, nodeCert, err = curator.RestoreNodeFinish(ctx, ckv, &n, b.Cluster.RestoreCACertificate)
+					if err != nil {
+						return fmt.Errorf("while restoring node: %w", err)
+					}
+				} else {
+					caCert, nodeCert, err = curator.BootstrapNodeFinish(ctx, ckv, &n, b.Cluster.InitialOwnerKey, b.Cluster.Configuration)
+					if err != nil {
+						return fmt.Errorf("while bootstrapping node: %w", err)
+					}
 				}
 				// ... and build new credentials from bootstrap step.
 				creds, err = identity.NewNodeCredentials(b.Node.PrivateKey, nodeCert, caCert)
@@ -341,6 +367,8 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 				NodeCredentials: creds,
 				Consensus:       con,
 				LeaderTTL:       10 * time.Second,
+				BackupPath:      s.storageRoot.Data.Etcd.Backups.FullPath(),
+				Revocations:     s.revocations,
 			})
 			if err := supervisor.Run(ctx, "curator", cur.Run); err != nil {
 				return fmt.Errorf("failed to start curator: %w", err)
@@ -374,3 +402,22 @@ func (s *workerControlPlane) run(ctx context.Context) error {
 	<-ctx.Done()
 	return ctx.Err()
 }
+
+// consensusRoleRemoved returns whether the node roles last received from the
+// cluster and persisted by the Role Fetcher do not contain the ConsensusMember
+// role.
+func (s *workerControlPlane) consensusRoleRemoved(ctx context.Context) bool {
+	data, err := s.storageRoot.Data.Node.PersistedRoles.Read()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			supervisor.Logger(ctx).Warningf("Could not read persisted roles, keeping consensus data: %v", err)
+		}
+		return false
+	}
+	var nr cpb.NodeRoles
+	if err := proto.Unmarshal(data, &nr); err != nil {
+		supervisor.Logger(ctx).Warningf("Could not unmarshal persisted roles, keeping consensus data: %v", err)
+		return false
+	}
+	return nr.ConsensusMember == nil
+}
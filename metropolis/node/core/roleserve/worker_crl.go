@@ -0,0 +1,79 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+)
+
+// workerCRL keeps the revoked certificates of this node up to date with the
+// certificate revocation list of the cluster CA, as retrieved from the
+// curator. Every retrieved CRL is persisted, and loaded by loadPersistedCRL
+// when the node rejoins the cluster. Until a CRL is available, no certificates
+// are considered revoked.
+type workerCRL struct {
+	storageRoot *localstorage.Root
+
+	curatorConnection *memory.Value[*CuratorConnection]
+
+	// revocations will be written.
+	revocations *identity.Revocations
+}
+
+func (s *workerCRL) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	cur := ipb.NewCuratorClient(cc.conn)
+	srv, err := cur.WatchCRL(ctx, &ipb.WatchCRLRequest{})
+	if err != nil {
+		return fmt.Errorf("WatchCRL: %w", err)
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	for {
+		res, err := srv.Recv()
+		if err != nil {
+			return fmt.Errorf("WatchCRL.Recv: %w", err)
+		}
+		if err := s.revocations.Update(res.Crl, cc.Credentials.ClusterCA()); err != nil {
+			return fmt.Errorf("invalid CRL: %w", err)
+		}
+		if err := s.storageRoot.Data.Node.CRL.Write(res.Crl, 0600); err != nil {
+			return fmt.Errorf("while persisting CRL: %w", err)
+		}
+		supervisor.Logger(ctx).Infof("Updated certificate revocation list.")
+	}
+}
+
+// loadPersistedCRL loads the certificate revocation list persisted by
+// workerCRL into revocations, so that certificates which were revoked before a
+// reboot are rejected even before a current CRL is retrieved from the curator.
+func loadPersistedCRL(storageRoot *localstorage.Root, revocations *identity.Revocations, ca *x509.Certificate) error {
+	exists, err := storageRoot.Data.Node.CRL.Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	crl, err := storageRoot.Data.Node.CRL.Read()
+	if err != nil {
+		return err
+	}
+	return revocations.Update(crl, ca)
+}
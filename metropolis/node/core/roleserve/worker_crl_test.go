@@ -0,0 +1,92 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
+)
+
+// TestLoadPersistedCRL ensures that a persisted CRL is only loaded if it has
+// been signed by the cluster CA.
+func TestLoadPersistedCRL(t *testing.T) {
+	root := &localstorage.Root{}
+	if err := declarative.PlaceFS(root, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(root.Data.Node.FullPath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// newCA returns a self-signed CA certificate and its private key.
+	newCA := func() (*x509.Certificate, ed25519.PrivateKey) {
+		t.Helper()
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		template := identity.CACertificate("test metropolis CA")
+		template.BasicConstraintsValid = true
+		template.NotBefore = time.Now()
+		template.NotAfter = time.Now().Add(time.Hour)
+		certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+		if err != nil {
+			t.Fatalf("CreateCertificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			t.Fatalf("ParseCertificate: %v", err)
+		}
+		return cert, priv
+	}
+	ca, caPriv := newCA()
+	otherCA, _ := newCA()
+	revoked := &x509.Certificate{SerialNumber: big.NewInt(42)}
+
+	// Nothing is revoked before a CRL has been persisted.
+	var revocations identity.Revocations
+	if err := loadPersistedCRL(root, &revocations, ca); err != nil {
+		t.Fatalf("loadPersistedCRL without CRL: %v", err)
+	}
+	if revocations.Revoked(revoked) {
+		t.Errorf("Certificate revoked without CRL")
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number: big.NewInt(1),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: revoked.SerialNumber, RevocationTime: time.Now()},
+		},
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, ca, caPriv)
+	if err != nil {
+		t.Fatalf("CreateRevocationList: %v", err)
+	}
+	if err := root.Data.Node.CRL.Write(crl, 0600); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := loadPersistedCRL(root, &revocations, otherCA); err == nil {
+		t.Errorf("loadPersistedCRL with CRL of other CA succeeded")
+	}
+	if revocations.Revoked(revoked) {
+		t.Errorf("Certificate revoked by CRL of other CA")
+	}
+	if err := loadPersistedCRL(root, &revocations, ca); err != nil {
+		t.Fatalf("loadPersistedCRL: %v", err)
+	}
+	if !revocations.Revoked(revoked) {
+		t.Errorf("Certificate not revoked after loading CRL")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/network"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/net/dns/forward"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// workerDNS configures the node's DNS forwarder with the encrypted upstream
+// DNS servers from the cluster configuration.
+//
+// The upstreams are kept configured if the worker stops, eg. because the
+// curator connection is lost, so that the forwarder does not fall back to
+// sending queries in cleartext.
+type workerDNS struct {
+	network *network.Service
+
+	// clusterConfig will be read.
+	clusterConfig *memory.Value[*cpb.ClusterConfiguration]
+}
+
+func (s *workerDNS) run(ctx context.Context) error {
+	w := s.clusterConfig.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for cluster configuration...")
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	var applied *cpb.ClusterConfiguration_DNS
+	first := true
+	for {
+		clusterConfig, err := w.Get(ctx)
+		if err != nil {
+			return err
+		}
+		cfg := clusterConfig.Dns
+		if !first && proto.Equal(applied, cfg) {
+			continue
+		}
+		if err := s.apply(ctx, cfg); err != nil {
+			supervisor.Logger(ctx).Warningf("Could not apply DNS configuration: %v", err)
+			continue
+		}
+		applied = cfg
+		first = false
+	}
+}
+
+// apply configures the DNS forwarder with the upstreams from cfg.
+func (s *workerDNS) apply(ctx context.Context, cfg *cpb.ClusterConfiguration_DNS) error {
+	upstreams, err := dnsUpstreamsFromProto(cfg)
+	if err != nil {
+		return err
+	}
+	if len(upstreams) == 0 {
+		supervisor.Logger(ctx).Infof("No encrypted upstream DNS servers configured.")
+	} else {
+		supervisor.Logger(ctx).Infof("Using %d encrypted upstream DNS servers.", len(upstreams))
+	}
+	s.network.SetEncryptedDNSUpstreams(upstreams)
+	return nil
+}
+
+// dnsUpstreamsFromProto converts the encrypted upstream DNS servers of a
+// cluster DNS configuration into forwarder upstreams.
+func dnsUpstreamsFromProto(cfg *cpb.ClusterConfiguration_DNS) ([]forward.Upstream, error) {
+	var upstreams []forward.Upstream
+	for i, u := range cfg.GetUpstreams() {
+		var up forward.Upstream
+		var defaultPort string
+		switch u.Protocol {
+		case cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_TLS:
+			up.Protocol = forward.ProtocolTLS
+			defaultPort = "853"
+		case cpb.ClusterConfiguration_DNS_Upstream_PROTOCOL_HTTPS:
+			up.Protocol = forward.ProtocolHTTPS
+			up.Path = u.Path
+			defaultPort = "443"
+		default:
+			return nil, fmt.Errorf("upstream %d: invalid protocol %s", i, u.Protocol)
+		}
+
+		host, port, err := net.SplitHostPort(u.Address)
+		if err != nil {
+			// No port given, the IP address might still be in brackets.
+			host, port = strings.TrimSuffix(strings.TrimPrefix(u.Address, "["), "]"), defaultPort
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("upstream %d: invalid address %q", i, u.Address)
+		}
+		up.Addr = net.JoinHostPort(ip.String(), port)
+		up.ServerName = u.ServerName
+
+		for j, der := range u.CaCertificates {
+			ca, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %d: CA certificate %d: %w", i, j, err)
+			}
+			up.RootCAs = append(up.RootCAs, ca)
+		}
+		upstreams = append(upstreams, up)
+	}
+	return upstreams, nil
+}
@@ -0,0 +1,151 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/metropolis/node/core/consensus"
+	"source.monogon.dev/metropolis/node/core/update"
+	"source.monogon.dev/osbase/event"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// healthGateHeartbeats is the number of heartbeats which need to be
+	// acknowledged by the cluster for the health gate to pass.
+	healthGateHeartbeats = 3
+)
+
+// workerHealthGate is the update health gate. If the node is running the first
+// boot of an update with an armed health gate, it waits for the node to become
+// healthy and then permanently activates the update. If the node doesn't
+// become healthy before the gate's deadline, the update is reverted and the
+// node reboots into the previous version.
+//
+// The node is considered healthy once it has joined the cluster, had a few
+// heartbeats acknowledged by the cluster, and (if it is a consensus member)
+// its local etcd member is running again.
+type workerHealthGate struct {
+	update *update.Service
+
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+	// heartbeats will be read.
+	heartbeats *memory.Value[uint64]
+	// localRoles will be read.
+	localRoles *memory.Value[*cpb.NodeRoles]
+	// localControlPlane will be read.
+	localControlPlane *memory.Value[*localControlPlane]
+}
+
+func (s *workerHealthGate) run(ctx context.Context) error {
+	gate, err := s.update.HealthGate()
+	if err != nil {
+		return fmt.Errorf("could not get health gate: %w", err)
+	}
+	if gate == nil {
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	}
+
+	// The gate's timeout counts from the start of the kernel, so that restarts
+	// of this worker don't extend it.
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		return fmt.Errorf("could not get uptime: %w", err)
+	}
+	timeout := gate.Timeout.AsDuration()
+	deadline := time.Now().Add(timeout - time.Duration(ts.Nano()))
+	supervisor.Logger(ctx).Infof("Running an update with health gate, waiting for node to become healthy until %s...", deadline.Format(time.RFC3339))
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	gctx, gctxC := context.WithDeadline(ctx, deadline)
+	defer gctxC()
+	err = s.waitHealthy(gctx)
+	if err == nil {
+		supervisor.Logger(ctx).Infof("Node is healthy, marking boot as successful.")
+		if err := s.update.MarkBootSuccessful(); err != nil {
+			return fmt.Errorf("failed to mark boot as successful: %w", err)
+		}
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	reason := fmt.Sprintf("node did not become healthy within %s: %v", timeout, err)
+	supervisor.Logger(ctx).Errorf("Health gate failed, rolling back: %s", reason)
+	if err := s.update.RevertTrialBoot(reason); err != nil {
+		return fmt.Errorf("failed to revert update: %w", err)
+	}
+	// TODO(#253): Tell Supervisor to shut down gracefully and reboot
+	unix.Unmount(s.update.ESPPath, 0)
+	unix.Sync()
+	if err := unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART); err != nil {
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// waitHealthy blocks until the node is considered healthy, or returns an
+// error describing what the node was waiting for when ctx got canceled.
+func (s *workerHealthGate) waitHealthy(ctx context.Context) error {
+	cw := s.curatorConnection.Watch()
+	defer cw.Close()
+	if _, err := cw.Get(ctx); err != nil {
+		return fmt.Errorf("while waiting to join the cluster: %w", err)
+	}
+
+	hw := s.heartbeats.Watch()
+	defer hw.Close()
+	_, err := hw.Get(ctx, event.Filter(func(n uint64) bool {
+		return n >= healthGateHeartbeats
+	}))
+	if err != nil {
+		return fmt.Errorf("while waiting for heartbeats: %w", err)
+	}
+
+	rw := s.localRoles.Watch()
+	defer rw.Close()
+	roles, err := rw.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("while waiting for node roles: %w", err)
+	}
+	if roles.GetConsensusMember() == nil {
+		return nil
+	}
+
+	lw := s.localControlPlane.Watch()
+	defer lw.Close()
+	lcp, err := lw.Get(ctx, event.Filter(func(lcp *localControlPlane) bool {
+		return lcp.exists()
+	}))
+	if err != nil {
+		return fmt.Errorf("while waiting for local control plane: %w", err)
+	}
+	stw := lcp.consensus.Watch()
+	defer stw.Close()
+	_, err = stw.Get(ctx, event.Filter(func(st *consensus.Status) bool {
+		return st.Running()
+	}))
+	if err != nil {
+		return fmt.Errorf("while waiting for etcd to rejoin: %w", err)
+	}
+	return nil
+}
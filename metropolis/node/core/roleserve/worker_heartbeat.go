@@ -23,6 +23,13 @@ type workerHeartbeat struct {
 
 	// curatorConnection will be read.
 	curatorConnection *memory.Value[*CuratorConnection]
+
+	// heartbeats will be written with the number of heartbeats acknowledged
+	// by the cluster since the node started.
+	heartbeats *memory.Value[uint64]
+	// heartbeatCount is the backing counter for heartbeats, retained across
+	// restarts of this worker.
+	heartbeatCount uint64
 }
 
 func (s *workerHeartbeat) run(ctx context.Context) error {
@@ -57,6 +64,8 @@ func (s *workerHeartbeat) run(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("while receiving a heartbeat reply: %w", err)
 		}
+		s.heartbeatCount += 1
+		s.heartbeats.Set(s.heartbeatCount)
 
 		time.Sleep(time.Until(next))
 	}
@@ -0,0 +1,91 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+
+	"source.monogon.dev/metropolis/node/core/curator/watcher"
+	"source.monogon.dev/metropolis/node/core/update"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+)
+
+// workerImageCache serves the OS image cache of the update service to other
+// nodes, and keeps the update service informed about the other nodes of the
+// cluster, so that OS images can be fetched from them instead of the upstream
+// registry.
+type workerImageCache struct {
+	update *update.Service
+
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+}
+
+func (s *workerImageCache) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	supervisor.Logger(ctx).Infof("Got curator connection, starting...")
+
+	err = supervisor.Run(ctx, "peers", func(ctx context.Context) error {
+		return s.watchPeers(ctx, cc)
+	})
+	if err != nil {
+		return err
+	}
+	return s.update.ServeImageCache(ctx, cc.Credentials)
+}
+
+// watchPeers provides the update service with all other nodes of the cluster
+// which have an external address.
+func (s *workerImageCache) watchPeers(ctx context.Context, cc *CuratorConnection) error {
+	cur := ipb.NewCuratorClient(cc.conn)
+	self := cc.nodeID()
+	peers := make(map[string]string)
+
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	return watcher.WatchNodes(ctx, cur, watcher.SimpleFollower{
+		FilterFn: func(a *ipb.Node) bool {
+			if a.Id == self {
+				return false
+			}
+			if a.Status == nil {
+				return false
+			}
+			if a.Status.ExternalAddress == "" {
+				return false
+			}
+			return true
+		},
+		EqualsFn: func(a *ipb.Node, b *ipb.Node) bool {
+			return a.Status.ExternalAddress == b.Status.ExternalAddress
+		},
+		OnNewUpdated: func(new *ipb.Node) error {
+			peers[new.Id] = new.Status.ExternalAddress
+			return nil
+		},
+		OnDeleted: func(prev *ipb.Node) error {
+			delete(peers, prev.Id)
+			return nil
+		},
+		OnBatchDone: func() error {
+			var list []update.ImagePeer
+			for id, host := range peers {
+				list = append(list, update.ImagePeer{
+					ID:   id,
+					Host: host,
+				})
+			}
+			s.update.ProvideImagePeers(cc.Credentials, list)
+			return nil
+		},
+	})
+}
@@ -41,6 +41,7 @@ type workerKubernetes struct {
 	curatorConnection *memory.Value[*CuratorConnection]
 	kubernetesStatus  *memory.Value[*KubernetesStatus]
 	podNetwork        *memory.Value[*clusternet.Prefixes]
+	revocations       *identity.Revocations
 }
 
 // kubernetesStartup is used internally to provide a reduced (as in MapReduce
@@ -171,6 +172,7 @@ func (s *workerKubernetes) run(ctx context.Context) error {
 			Network:        s.network,
 			Curator:        d.curator,
 			Management:     d.management,
+			Revocations:    s.revocations,
 		})
 		// Start Kubernetes.
 		if err := supervisor.Run(ctx, "run", controller.Run); err != nil {
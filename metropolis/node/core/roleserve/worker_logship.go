@@ -0,0 +1,74 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/logship"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// workerLogShip ships this node's logs to a collector, if configured in the
+// cluster configuration. The worker restarts whenever the log shipping
+// configuration changes.
+type workerLogShip struct {
+	logTree *logtree.LogTree
+
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+	// clusterConfig will be read.
+	clusterConfig *memory.Value[*cpb.ClusterConfiguration]
+}
+
+func (s *workerLogShip) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	cw := s.clusterConfig.Watch()
+	defer cw.Close()
+	supervisor.Logger(ctx).Infof("Waiting for cluster configuration...")
+	clusterConfig, err := cw.Get(ctx)
+	if err != nil {
+		return err
+	}
+	cfg := clusterConfig.LogShipping
+	if cfg.GetDestination() != nil {
+		supervisor.Logger(ctx).Infof("Shipping logs to collector...")
+		svc := &logship.Service{
+			LogTree:              s.logTree,
+			Config:               cfg,
+			NodeID:               cc.nodeID(),
+			GetClientCertificate: cc.Credentials.GetClientCertificate,
+		}
+		if err := supervisor.Run(ctx, "shipper", svc.Run); err != nil {
+			return err
+		}
+	} else {
+		supervisor.Logger(ctx).Infof("Log shipping disabled.")
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	for {
+		clusterConfig, err := cw.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if !proto.Equal(cfg, clusterConfig.LogShipping) {
+			return fmt.Errorf("log shipping configuration changed, restarting")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+	"source.monogon.dev/osbase/tpm"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// workerMeasurements reports the measured boot event log of the node to the
+// cluster once per boot, if the node has a TPM.
+type workerMeasurements struct {
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+}
+
+func (s *workerMeasurements) run(ctx context.Context) error {
+	if !tpm.IsInitialized() {
+		supervisor.Logger(ctx).Infof("No TPM, not reporting measurements.")
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	}
+
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	cur := ipb.NewCuratorClient(cc.conn)
+
+	res, err := s.report(ctx, cur)
+	if status.Code(err) == codes.FailedPrecondition {
+		// The cluster doesn't know the node's TPM, retrying won't help.
+		supervisor.Logger(ctx).Warningf("Cluster refused measurements: %v", err)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if res.Reason != "" {
+		supervisor.Logger(ctx).Warningf("Reported measurements, cluster says: %s (%s)", res.Result, res.Reason)
+	} else {
+		supervisor.Logger(ctx).Infof("Reported measurements, cluster says: %s", res.Result)
+	}
+	supervisor.Signal(ctx, supervisor.SignalDone)
+	return nil
+}
+
+// report performs a ReportMeasurements call against the curator.
+func (s *workerMeasurements) report(ctx context.Context, cur ipb.CuratorClient) (*cpb.NodeMeasuredBoot, error) {
+	ekPub, ekCert, err := tpm.GetEKPublic()
+	if err != nil {
+		return nil, fmt.Errorf("could not get EK: %w", err)
+	}
+	akPub, err := tpm.GetAKPublic()
+	if err != nil {
+		return nil, fmt.Errorf("could not get AK: %w", err)
+	}
+	// Firmware without TCG2 support or a kexec'd kernel don't provide an event
+	// log. Report the measurements without it, which is then recorded by the
+	// cluster.
+	eventLog, err := tpm.GetMeasurementLog()
+	if err != nil {
+		supervisor.Logger(ctx).Warningf("Could not read event log, reporting without: %v", err)
+		eventLog = nil
+	}
+
+	ctx, ctxC := context.WithCancel(ctx)
+	defer ctxC()
+	srv, err := cur.ReportMeasurements(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = srv.Send(&ipb.ReportMeasurementsRequest{
+		Kind: &ipb.ReportMeasurementsRequest_Challenge{
+			Challenge: &ipb.GetAttestationChallengeRequest{
+				EkPublicKey:   ekPub,
+				AkPublic:      akPub,
+				EkCertificate: ekCert,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while sending challenge request: %w", err)
+	}
+	res, err := srv.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("while receiving challenge: %w", err)
+	}
+	ch := res.GetChallenge()
+	if ch == nil {
+		return nil, fmt.Errorf("expected challenge, got %v", res)
+	}
+
+	secret, err := tpm.SolveAKChallenge(ch.CredentialBlob, ch.EncryptedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("could not solve challenge: %w", err)
+	}
+	quote, signature, err := tpm.AttestPlatform(ch.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not quote PCRs: %w", err)
+	}
+	pcrs, err := tpm.GetPCRs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read PCRs: %w", err)
+	}
+	err = srv.Send(&ipb.ReportMeasurementsRequest{
+		Kind: &ipb.ReportMeasurementsRequest_Report_{
+			Report: &ipb.ReportMeasurementsRequest_Report{
+				Attestation: &ipb.Attestation{
+					Nonce:           ch.Nonce,
+					ActivatedSecret: secret,
+					Quote:           quote,
+					QuoteSignature:  signature,
+					Pcrs:            pcrs,
+				},
+				EventLog: eventLog,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while sending report: %w", err)
+	}
+	res, err = srv.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("while receiving result: %w", err)
+	}
+	if res.GetResult() == nil {
+		return nil, fmt.Errorf("expected result, got %v", res)
+	}
+	return res.GetResult(), nil
+}
@@ -4,19 +4,51 @@
 package roleserve
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	common "source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/consensus"
+	"source.monogon.dev/metropolis/node/core/curator/watcher"
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/mgmt"
+	"source.monogon.dev/metropolis/node/core/network"
+	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/metropolis/node/core/update"
 	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/logtree"
 	"source.monogon.dev/osbase/supervisor"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
 type workerNodeMgmt struct {
+	storageRoot       *localstorage.Root
 	curatorConnection *memory.Value[*CuratorConnection]
+	localControlPlane *memory.Value[*localControlPlane]
 	logTree           *logtree.LogTree
 	updateService     *update.Service
+	supervisorState   *supervisor.InMemoryMetrics
+	network           *network.Service
+	revocations       *identity.Revocations
+
+	// clusterConfig will be read.
+	clusterConfig *memory.Value[*cpb.ClusterConfiguration]
+	// updateFailure will be written.
+	updateFailure *memory.Value[*updateFailure]
 }
 
 func (s *workerNodeMgmt) run(ctx context.Context) error {
@@ -29,10 +61,232 @@ func (s *workerNodeMgmt) run(ctx context.Context) error {
 	}
 
 	supervisor.Logger(ctx).Infof("Got cluster membership, starting...")
-	srv := mgmt.Service{
+
+	// The data partition is mounted now, so logs can be persisted there to make
+	// them available after a reboot.
+	err = s.logTree.Persist(logtree.PersistOptions{
+		Directory: s.storageRoot.Data.Node.Logs.FullPath(),
+		BootID:    getBootID(ctx),
+	})
+	if err != nil {
+		supervisor.Logger(ctx).Warningf("Could not persist logs: %v", err)
+	}
+
+	srv := &mgmt.Service{
 		NodeCredentials: cc.Credentials,
+		Revocations:     s.revocations,
 		LogTree:         s.logTree,
 		UpdateService:   s.updateService,
+		LocalConsensus:  s.localConsensus,
+		Runnables:       s.supervisorState,
+		Network:         s.network,
+		ESP:             &s.storageRoot.ESP.Metropolis,
+		CuratorReachable: func(ctx context.Context) error {
+			return curatorReachable(ctx, cc)
+		},
+		ReportAudit: func(ctx context.Context, entry *apb.AuditEntry) error {
+			_, err := ipb.NewCuratorClient(cc.conn).ReportAuditEntry(ctx, &ipb.ReportAuditEntryRequest{Entry: entry})
+			return err
+		},
+	}
+	if err := supervisor.Run(ctx, "signingkeys", func(ctx context.Context) error {
+		return s.runSigningKeys(ctx)
+	}); err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "update", func(ctx context.Context) error {
+		return s.runUpdate(ctx, cc, srv)
+	}); err != nil {
+		return err
 	}
 	return srv.Run(ctx)
 }
+
+// localConsensus returns the consensus service running on this node, if any.
+func (s *workerNodeMgmt) localConsensus(ctx context.Context) (*consensus.Service, error) {
+	w := s.localControlPlane.Watch()
+	defer w.Close()
+	// The local control plane is set as soon as the control plane worker made
+	// its decision, so don't wait long for it.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	lcp, err := w.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lcp == nil {
+		return nil, nil
+	}
+	return lcp.consensus, nil
+}
+
+// curatorReachable blocks until a curator running on another node can be
+// reached from this node. Curators are dialed directly at the addresses of the
+// cluster directory instead of through the resolver, as the resolver routes
+// calls to the local curator over the loopback interface on control plane
+// nodes, which does not exercise the network configuration at all. A new
+// connection is used for every attempt, as the connection of cc might still be
+// stuck on a network configuration which has been replaced in the meantime.
+//
+// If there are no other nodes in the cluster directory, reachability cannot be
+// confirmed and an error is returned.
+func curatorReachable(ctx context.Context, cc *CuratorConnection) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = 0
+	return backoff.Retry(func() error {
+		actx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		err := remoteCuratorReachable(actx, cc)
+		if err != nil && ctx.Err() != nil {
+			return backoff.Permanent(fmt.Errorf("%w (last error: %w)", ctx.Err(), err))
+		}
+		return err
+	}, backoff.WithContext(bo, ctx))
+}
+
+// remoteCuratorReachable makes a single attempt at reaching any curator running
+// on another node, see curatorReachable.
+func remoteCuratorReachable(ctx context.Context, cc *CuratorConnection) error {
+	conn := newCuratorConnection(cc.Credentials, cc.resolver)
+	defer conn.conn.Close()
+	info, err := apb.NewManagementClient(conn.conn).GetClusterInfo(ctx, &apb.GetClusterInfoRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return fmt.Errorf("could not get cluster directory: %w", err)
+	}
+	var hosts []string
+	for _, n := range info.ClusterDirectory.GetNodes() {
+		if n.Id == cc.Credentials.ID() {
+			continue
+		}
+		for _, addr := range n.Addresses {
+			if ip := net.ParseIP(addr.Host); ip != nil && ip.IsLoopback() {
+				continue
+			}
+			hosts = append(hosts, addr.Host)
+		}
+	}
+	if len(hosts) == 0 {
+		return backoff.Permanent(errors.New("no other node to confirm reachability with"))
+	}
+
+	// Not every node runs a curator, so try all of them at once and succeed
+	// as soon as any of them responds.
+	creds := rpc.NewAuthenticatedNodeCredentials(cc.Credentials, rpc.WantRemoteCluster(cc.Credentials.ClusterCA()))
+	errC := make(chan error, len(hosts))
+	for _, host := range hosts {
+		go func() {
+			rc, err := grpc.NewClient(net.JoinHostPort(host, common.CuratorServicePort.PortString()), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				errC <- err
+				return
+			}
+			defer rc.Close()
+			_, err = apb.NewManagementClient(rc).GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+			if err != nil {
+				err = fmt.Errorf("%s: %w", host, err)
+			}
+			errC <- err
+		}()
+	}
+	var errs []error
+	for range hosts {
+		err := <-errC
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("no curator on another node reachable: %w", errors.Join(errs...))
+}
+
+// runSigningKeys provides the update service with the OS image signing keys
+// from the cluster configuration whenever they change, so that changes to the
+// trusted keys take effect on this node.
+func (s *workerNodeMgmt) runSigningKeys(ctx context.Context) error {
+	w := s.clusterConfig.Watch()
+	defer w.Close()
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	var keys [][]byte
+	first := true
+	for {
+		cfg, err := w.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if !first && slices.EqualFunc(keys, cfg.OsImageSigningKeys, bytes.Equal) {
+			continue
+		}
+		if err := s.updateService.ProvideSigningKeys(cfg.OsImageSigningKeys); err != nil {
+			supervisor.Logger(ctx).Warningf("Could not provide OS image signing keys: %v", err)
+			continue
+		}
+		keys = cfg.OsImageSigningKeys
+		first = false
+	}
+}
+
+// runUpdate watches the local node for updates requested by the cluster (as
+// part of a rollout) and applies them through the node management service.
+//
+// The ID of the last applied update is persisted once the update has been
+// installed, so that every update is acted upon at most once, even if the node
+// reboots while the cluster still requests the same update. If installing the
+// update fails, the failure is reported in the node status and the runnable
+// exits, so that the supervisor retries it with backoff.
+func (s *workerNodeMgmt) runUpdate(ctx context.Context, cc *CuratorConnection, srv *mgmt.Service) error {
+	applied, err := s.storageRoot.Data.Node.AppliedUpdate.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	appliedID := string(applied)
+
+	// Make sure that the signing keys are known before applying any update, as
+	// the update would otherwise be rejected.
+	cw := s.clusterConfig.Watch()
+	cfg, err := cw.Get(ctx)
+	cw.Close()
+	if err != nil {
+		return err
+	}
+	if err := s.updateService.ProvideSigningKeys(cfg.OsImageSigningKeys); err != nil {
+		return err
+	}
+
+	cur := ipb.NewCuratorClient(cc.conn)
+	w := watcher.WatchNode(ctx, cur, cc.nodeID())
+	defer w.Close()
+
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	for w.Next() {
+		u := w.Node().Update
+		if u == nil || u.Id == appliedID {
+			continue
+		}
+		supervisor.Logger(ctx).Infof("Cluster requested update %s, installing...", u.Id)
+		_, err := srv.InstallUpdate(ctx, &apb.UpdateNodeRequest{
+			OsImage:           u.OsImage,
+			ActivationMode:    u.ActivationMode,
+			HealthGateTimeout: u.HealthGateTimeout,
+		}, u.Id)
+		if err != nil {
+			s.updateFailure.Set(&updateFailure{
+				updateID: u.Id,
+				status: &cpb.NodeUpdateFailure{
+					Reason:    err.Error(),
+					Timestamp: timestamppb.Now(),
+				},
+			})
+			return fmt.Errorf("failed to install update %s: %w", u.Id, err)
+		}
+		s.updateFailure.Set(nil)
+		// The node might be rebooting into the update already, but does so only
+		// after a grace period.
+		if err := s.storageRoot.Data.Node.AppliedUpdate.Write([]byte(u.Id), 0600); err != nil {
+			return err
+		}
+		appliedID = u.Id
+	}
+	return w.Error()
+}
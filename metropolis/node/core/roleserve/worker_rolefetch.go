@@ -51,20 +51,22 @@ func (s *workerRoleFetch) run(ctx context.Context) error {
 		} else {
 			var nr cpb.NodeRoles
 			if err := proto.Unmarshal(data, &nr); err != nil {
+				// Don't provide empty roles, which would stop all role-specific
+				// services until the cluster is reachable.
 				supervisor.Logger(ctx).Errorf("Failed to unmarshal persisted roles: %w", err)
 			} else {
 				supervisor.Logger(ctx).Infof("Got persisted role data from disk:")
+				if nr.ConsensusMember != nil {
+					supervisor.Logger(ctx).Infof(" - control plane member, existing peers: %+v", nr.ConsensusMember.Peers)
+				}
+				if nr.KubernetesController != nil {
+					supervisor.Logger(ctx).Infof(" - kubernetes controller")
+				}
+				if nr.KubernetesWorker != nil {
+					supervisor.Logger(ctx).Infof(" - kubernetes worker")
+				}
+				s.localRoles.Set(&nr)
 			}
-			if nr.ConsensusMember != nil {
-				supervisor.Logger(ctx).Infof(" - control plane member, existing peers: %+v", nr.ConsensusMember.Peers)
-			}
-			if nr.KubernetesController != nil {
-				supervisor.Logger(ctx).Infof(" - kubernetes controller")
-			}
-			if nr.KubernetesWorker != nil {
-				supervisor.Logger(ctx).Infof(" - kubernetes worker")
-			}
-			s.localRoles.Set(&nr)
 		}
 	} else {
 		supervisor.Logger(ctx).Infof("No persisted node roles.")
@@ -101,9 +103,10 @@ func (s *workerRoleFetch) run(ctx context.Context) error {
 			if n.Roles.KubernetesWorker != nil {
 				supervisor.Logger(ctx).Infof(" - kubernetes worker")
 			}
-			s.localRoles.Set(n.Roles)
 
-			// Persist role data to disk.
+			// Persist role data to disk before providing it, as the Control Plane
+			// Worker relies on the persisted roles to decide whether to discard
+			// consensus data.
 			bytes, err := proto.Marshal(n.Roles)
 			if err != nil {
 				supervisor.Logger(ctx).Errorf("Failed to marshal node roles: %w", err)
@@ -113,6 +116,7 @@ func (s *workerRoleFetch) run(ctx context.Context) error {
 					supervisor.Logger(ctx).Errorf("Failed to write node roles: %w", err)
 				}
 			}
+			s.localRoles.Set(n.Roles)
 		}
 		return w.Error()
 	})
@@ -8,18 +8,23 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/network"
 	"source.monogon.dev/metropolis/node/core/productinfo"
+	timesvc "source.monogon.dev/metropolis/node/core/time"
+	"source.monogon.dev/metropolis/node/core/update"
 	"source.monogon.dev/osbase/event"
 	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/supervisor"
 
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	upb "source.monogon.dev/metropolis/node/core/update/proto"
 	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
@@ -27,6 +32,8 @@ import (
 // UpdateNodeStatus RPCs to a cluster whenever a Curator is available.
 type workerStatusPush struct {
 	network *network.Service
+	update  *update.Service
+	time    *timesvc.Service
 
 	// localControlPlane will be read
 	localControlPlane *memory.Value[*localControlPlane]
@@ -34,6 +41,8 @@ type workerStatusPush struct {
 	curatorConnection *memory.Value[*CuratorConnection]
 	// clusterDirectorySaved will be read.
 	clusterDirectorySaved *memory.Value[bool]
+	// updateFailure will be read.
+	updateFailure *memory.Value[*updateFailure]
 }
 
 // workerStatusPushChannels contain all the channels between the status pusher's
@@ -43,6 +52,12 @@ type workerStatusPushChannels struct {
 	address           chan string
 	localControlPlane chan *localControlPlane
 	curatorConnection chan *CuratorConnection
+	// timeStatus is the synchronization status of the node's clock. Retrieved
+	// from the time service.
+	timeStatus chan *cpb.NodeTimeStatus
+	// updateFailure is the last failure to install an update requested by the
+	// cluster, or nil. Retrieved from the node management worker.
+	updateFailure chan *updateFailure
 }
 
 // getBootID is defined as var to make it overridable from tests
@@ -60,13 +75,36 @@ var getBootID = func(ctx context.Context) []byte {
 	return bootID[:]
 }
 
+// timeOffsetThreshold is the change in clock offset above which an updated
+// time status is pushed to the cluster. Smaller changes are ignored, to not
+// update the node in the cluster every time the clock gets adjusted.
+const timeOffsetThreshold = 10 * time.Millisecond
+
+// timeStatusChanged returns true if the time status b differs significantly
+// from a.
+func timeStatusChanged(a, b *cpb.NodeTimeStatus) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	if a.Synchronized != b.Synchronized || a.Reference != b.Reference || a.Stratum != b.Stratum {
+		return true
+	}
+	diff := a.Offset.AsDuration() - b.Offset.AsDuration()
+	return diff > timeOffsetThreshold || diff < -timeOffsetThreshold
+}
+
 // workerStatusPushLoop runs the main loop acting on data received from
-// workerStatusPushChannels.
-func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels) error {
+// workerStatusPushChannels. The given lastRollback, if any, and osImageDigest
+// are reported as part of the node status.
+func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels, lastRollback *upb.Rollback, osImageDigest string) error {
 	status := cpb.NodeStatus{
-		Version: productinfo.Get().Version,
-		BootId:  getBootID(ctx),
+		Version:       productinfo.Get().Version,
+		BootId:        getBootID(ctx),
+		LastRollback:  lastRollback.GetRollback(),
+		OsImageDigest: osImageDigest,
 	}
+	var updateStatus ipb.NodeUpdateStatus
+	updateStatus.RolledBackUpdateId = lastRollback.GetUpdateId()
 
 	var cur ipb.CuratorClient
 	var nodeID string
@@ -98,6 +136,30 @@ func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels)
 				changed = true
 			}
 
+		case ts := <-chans.timeStatus:
+			if timeStatusChanged(status.Time, ts) {
+				if status.Time.GetSynchronized() != ts.GetSynchronized() {
+					supervisor.Logger(ctx).Infof("Got new time status: synchronized: %v", ts.GetSynchronized())
+				}
+				status.Time = ts
+				changed = true
+			}
+
+		case uf := <-chans.updateFailure:
+			var failure *cpb.NodeUpdateFailure
+			var failedID string
+			if uf != nil {
+				failure, failedID = uf.status, uf.updateID
+			}
+			if !proto.Equal(status.UpdateFailure, failure) || updateStatus.FailedUpdateId != failedID {
+				if failure != nil {
+					supervisor.Logger(ctx).Warningf("Got update failure: %s", failure.Reason)
+				}
+				status.UpdateFailure = failure
+				updateStatus.FailedUpdateId = failedID
+				changed = true
+			}
+
 		case lcp := <-chans.localControlPlane:
 			if status.RunningCurator == nil && lcp.exists() {
 				supervisor.Logger(ctx).Infof("Got new local curator state: running")
@@ -116,10 +178,14 @@ func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels)
 		if cur != nil && nodeID != "" && changed && status.ExternalAddress != "" {
 			txt, _ := prototext.Marshal(&status)
 			supervisor.Logger(ctx).Infof("Submitting status: %q", txt)
-			_, err := cur.UpdateNodeStatus(ctx, &ipb.UpdateNodeStatusRequest{
+			req := &ipb.UpdateNodeStatusRequest{
 				NodeId: nodeID,
 				Status: &status,
-			})
+			}
+			if updateStatus.FailedUpdateId != "" || updateStatus.RolledBackUpdateId != "" {
+				req.UpdateStatus = &updateStatus
+			}
+			_, err := cur.UpdateNodeStatus(ctx, req)
 			if err != nil {
 				return fmt.Errorf("UpdateNodeStatus failed: %w", err)
 			}
@@ -132,6 +198,8 @@ func (s *workerStatusPush) run(ctx context.Context) error {
 		address:           make(chan string),
 		curatorConnection: make(chan *CuratorConnection),
 		localControlPlane: make(chan *localControlPlane),
+		timeStatus:        make(chan *cpb.NodeTimeStatus),
+		updateFailure:     make(chan *updateFailure),
 	}
 
 	// All the channel sends in the map runnables are preemptible by a context
@@ -161,7 +229,27 @@ func (s *workerStatusPush) run(ctx context.Context) error {
 	})
 	supervisor.Run(ctx, "pipe-local-control-plane", event.Pipe[*localControlPlane](s.localControlPlane, chans.localControlPlane))
 	supervisor.Run(ctx, "pipe-curator-connection", event.Pipe[*CuratorConnection](s.curatorConnection, chans.curatorConnection))
+	if s.updateFailure != nil {
+		supervisor.Run(ctx, "pipe-update-failure", event.Pipe[*updateFailure](s.updateFailure, chans.updateFailure))
+	}
+	if s.time != nil {
+		supervisor.Run(ctx, "pipe-time-status", event.Pipe[*cpb.NodeTimeStatus](&s.time.Status, chans.timeStatus))
+	}
+
+	var lastRollback *upb.Rollback
+	var osImageDigest string
+	if s.update != nil {
+		rb, err := s.update.LastRollback()
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("Could not get last rollback: %v", err)
+		}
+		lastRollback = rb
+		osImageDigest, err = s.update.RunningImageDigest()
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("Could not get running OS image digest: %v", err)
+		}
+	}
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
-	return workerStatusPushLoop(ctx, &chans)
+	return workerStatusPushLoop(ctx, &chans, lastRollback, osImageDigest)
 }
@@ -97,7 +97,7 @@ func TestWorkerStatusPush(t *testing.T) {
 
 	go supervisor.TestHarness(t, func(ctx context.Context) error {
 		supervisor.Signal(ctx, supervisor.SignalHealthy)
-		return workerStatusPushLoop(ctx, &chans)
+		return workerStatusPushLoop(ctx, &chans, nil, "")
 	})
 
 	// Build a loopback gRPC server served by the statusRecordingCurator and connect
@@ -126,10 +126,13 @@ func TestWorkerStatusPush(t *testing.T) {
 	eph := util.NewEphemeralClusterCredentials(t, 1)
 	nodeID := eph.Nodes[0].ID()
 
+	conn := &curatorConn{}
+	conn.cur.Store(cl)
+
 	// Actual test code starts here.
 	chans.curatorConnection <- &CuratorConnection{
 		Credentials: eph.Nodes[0],
-		conn:        cl,
+		conn:        conn,
 	}
 	cur.expectReports(t, nil)
 
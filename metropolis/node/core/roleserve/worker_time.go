@@ -0,0 +1,232 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"context"
+	"maps"
+	"net/netip"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/core/curator/watcher"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/supervisor"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	timesvc "source.monogon.dev/metropolis/node/core/time"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// workerTime configures the node's time service from the cluster
+// configuration, the node's own time configuration override and, if consensus
+// members act as time servers, the nodes of the cluster.
+//
+// The time service is kept configured if the worker stops, eg. because the
+// curator connection is lost.
+type workerTime struct {
+	time *timesvc.Service
+
+	// curatorConnection will be read.
+	curatorConnection *memory.Value[*CuratorConnection]
+	// clusterConfig will be read.
+	clusterConfig *memory.Value[*cpb.ClusterConfiguration]
+}
+
+func (s *workerTime) run(ctx context.Context) error {
+	w := s.curatorConnection.Watch()
+	defer w.Close()
+	supervisor.Logger(ctx).Infof("Waiting for curator connection...")
+	cc, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	cur := ipb.NewCuratorClient(cc.conn)
+
+	configC := make(chan *cpb.ClusterConfiguration_Time)
+	nodesC := make(chan map[string]*ipb.Node)
+
+	// The channel sends are preemptible by a context cancelation, as the main
+	// loop might have exited.
+	supervisor.Run(ctx, "config", func(ctx context.Context) error {
+		w := s.clusterConfig.Watch()
+		defer w.Close()
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		var cfg *cpb.ClusterConfiguration_Time
+		first := true
+		for {
+			clusterConfig, err := w.Get(ctx)
+			if err != nil {
+				return err
+			}
+			newCfg := clusterConfig.GetTime()
+			if !first && proto.Equal(cfg, newCfg) {
+				continue
+			}
+			select {
+			case configC <- newCfg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			cfg = newCfg
+			first = false
+		}
+	})
+	supervisor.Run(ctx, "nodes", func(ctx context.Context) error {
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		nodes := make(map[string]*ipb.Node)
+		return watcher.WatchNodes(ctx, cur, watcher.SimpleFollower{
+			EqualsFn: func(a *ipb.Node, b *ipb.Node) bool {
+				if (a.Roles.GetConsensusMember() == nil) != (b.Roles.GetConsensusMember() == nil) {
+					return false
+				}
+				if clusternetAddress(a) != clusternetAddress(b) || a.GetStatus().GetExternalAddress() != b.GetStatus().GetExternalAddress() {
+					return false
+				}
+				return proto.Equal(a.TimeConfiguration, b.TimeConfiguration)
+			},
+			OnNewUpdated: func(n *ipb.Node) error {
+				nodes[n.Id] = n
+				return nil
+			},
+			OnDeleted: func(n *ipb.Node) error {
+				delete(nodes, n.Id)
+				return nil
+			},
+			OnBatchDone: func() error {
+				select {
+				case nodesC <- maps.Clone(nodes):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				return nil
+			},
+		})
+	})
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	var cfg *cpb.ClusterConfiguration_Time
+	var nodes map[string]*ipb.Node
+	haveConfig := false
+	var applied *timesvc.Config
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case cfg = <-configC:
+			haveConfig = true
+		case nodes = <-nodesC:
+		}
+		if !haveConfig || nodes == nil {
+			continue
+		}
+		tc := timeConfig(cc.nodeID(), cfg, nodes)
+		if applied.Equal(tc) {
+			continue
+		}
+		self := nodes[cc.nodeID()]
+		if cfg.GetConsensusTimeServers() && self.GetRoles().GetConsensusMember() == nil && len(self.GetTimeConfiguration().GetNtpServers()) == 0 && len(timeServers(cc.nodeID(), nodes)) == 0 {
+			supervisor.Logger(ctx).Warningf("Consensus members act as time servers, but none have an address, falling back to upstream servers %v, pools %v.", tc.Servers, tc.Pools)
+		}
+		supervisor.Logger(ctx).Infof("New time configuration: servers %v, pools %v, serving: %v (to %v)", tc.Servers, tc.Pools, tc.Serve, tc.Allow)
+		s.time.Configure(tc)
+		applied = tc
+	}
+}
+
+// timeConfig builds the time service configuration of the node with the given
+// ID from the cluster's time configuration and the nodes in the cluster.
+//
+// The node synchronizes with its own time configuration override if set,
+// otherwise with the NTP servers from the cluster configuration, or the
+// default pool if none are configured. If consensus members act as time
+// servers, consensus members additionally synchronize with each other and
+// serve time to the other nodes of the cluster, and all other nodes
+// synchronize with the consensus members unless they have an override. Time
+// is exchanged over the cluster network where possible, as NTP itself is not
+// authenticated, and otherwise over the external addresses of the nodes.
+func timeConfig(nodeID string, cfg *cpb.ClusterConfiguration_Time, nodes map[string]*ipb.Node) *timesvc.Config {
+	self := nodes[nodeID]
+	override := self.GetTimeConfiguration().GetNtpServers()
+
+	upstream := &timesvc.Config{
+		Pools: []string{timesvc.DefaultPool},
+	}
+	if len(override) > 0 {
+		upstream = &timesvc.Config{Servers: slices.Clone(override)}
+	} else if len(cfg.GetNtpServers()) > 0 {
+		upstream = &timesvc.Config{Servers: slices.Clone(cfg.NtpServers)}
+	}
+	if !cfg.GetConsensusTimeServers() {
+		return upstream
+	}
+
+	members := timeServers(nodeID, nodes)
+	if self.GetRoles().GetConsensusMember() != nil {
+		upstream.Servers = append(upstream.Servers, members...)
+		upstream.Serve = true
+		// Requests from nodes are sent either from within the cluster network,
+		// or from their external address.
+		upstream.Allow = []string{clusterNet.String()}
+		for _, id := range slices.Sorted(maps.Keys(nodes)) {
+			addr, err := netip.ParseAddr(nodes[id].GetStatus().GetExternalAddress())
+			if id == nodeID || err != nil {
+				continue
+			}
+			upstream.Allow = append(upstream.Allow, netip.PrefixFrom(addr, addr.BitLen()).String())
+		}
+		return upstream
+	}
+	if len(override) > 0 || len(members) == 0 {
+		return upstream
+	}
+	return &timesvc.Config{Servers: members}
+}
+
+// timeServers returns the addresses of all consensus members but the node with
+// the given ID, at which they serve time if consensus members act as time
+// servers.
+func timeServers(nodeID string, nodes map[string]*ipb.Node) []string {
+	var res []string
+	for _, id := range slices.Sorted(maps.Keys(nodes)) {
+		n := nodes[id]
+		if id == nodeID || n.Roles.GetConsensusMember() == nil {
+			continue
+		}
+		if addr := timeAddress(n); addr.IsValid() {
+			res = append(res, addr.String())
+		}
+	}
+	return res
+}
+
+// timeAddress returns the address at which the given node serves time, ie.
+// its cluster network address or, if it doesn't have one, its external
+// address. Only Kubernetes workers have cluster network addresses.
+func timeAddress(n *ipb.Node) netip.Addr {
+	if addr := clusternetAddress(n); addr.IsValid() {
+		return addr
+	}
+	addr, err := netip.ParseAddr(n.GetStatus().GetExternalAddress())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// clusternetAddress returns the address of the given node within the cluster
+// network, ie. the first address of the first prefix it announces within
+// clusterNet, or an invalid address if it doesn't announce any. Traffic
+// between such addresses is carried by the encrypted clusternet mesh.
+func clusternetAddress(n *ipb.Node) netip.Addr {
+	for _, p := range n.GetClusternet().GetPrefixes() {
+		prefix, err := netip.ParsePrefix(p.Cidr)
+		if err != nil || prefix.IsSingleIP() || !clusterNet.Contains(prefix.Addr()) || prefix.Bits() < clusterNet.Bits() {
+			continue
+		}
+		return prefix.Masked().Addr().Next()
+	}
+	return netip.Addr{}
+}
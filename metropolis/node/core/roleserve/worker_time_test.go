@@ -0,0 +1,152 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package roleserve
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	timesvc "source.monogon.dev/metropolis/node/core/time"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+func TestTimeConfig(t *testing.T) {
+	node := func(id, addr string, member bool, override ...string) *ipb.Node {
+		n := &ipb.Node{
+			Id:     id,
+			Roles:  &cpb.NodeRoles{},
+			Status: &cpb.NodeStatus{ExternalAddress: addr},
+			Clusternet: &cpb.NodeClusterNetworking{
+				Prefixes: []*cpb.NodeClusterNetworking_Prefix{
+					{Cidr: addr + "/32"},
+					{Cidr: "10.192." + strings.TrimPrefix(addr, "10.0.0.") + ".0/24"},
+				},
+			},
+		}
+		if member {
+			n.Roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
+		}
+		if len(override) > 0 {
+			n.TimeConfiguration = &cpb.NodeTimeConfiguration{NtpServers: override}
+		}
+		return n
+	}
+	nodes := map[string]*ipb.Node{
+		"a": node("a", "10.0.0.1", true),
+		"b": node("b", "10.0.0.2", true),
+		"c": node("c", "10.0.0.3", false),
+		"d": node("d", "10.0.0.4", false, "192.0.2.10"),
+		"e": node("e", "10.0.0.5", true, "192.0.2.10"),
+		// f and g are not Kubernetes workers, and thus don't have cluster
+		// network addresses. They exchange time over their external addresses.
+		"f": {
+			Id:     "f",
+			Roles:  &cpb.NodeRoles{ConsensusMember: &cpb.NodeRoles_ConsensusMember{}},
+			Status: &cpb.NodeStatus{ExternalAddress: "10.0.0.6"},
+			Clusternet: &cpb.NodeClusterNetworking{
+				Prefixes: []*cpb.NodeClusterNetworking_Prefix{{Cidr: "10.0.0.6/32"}},
+			},
+		},
+		"g": {
+			Id:     "g",
+			Roles:  &cpb.NodeRoles{},
+			Status: &cpb.NodeStatus{ExternalAddress: "10.0.0.7"},
+		},
+	}
+	servers := &cpb.ClusterConfiguration_Time{
+		NtpServers: []string{"192.0.2.1"},
+	}
+	consensus := &cpb.ClusterConfiguration_Time{
+		NtpServers:           []string{"192.0.2.1"},
+		ConsensusTimeServers: true,
+	}
+
+	for _, te := range []struct {
+		name   string
+		nodeID string
+		cfg    *cpb.ClusterConfiguration_Time
+		want   *timesvc.Config
+	}{
+		{"default", "c", nil, &timesvc.Config{Pools: []string{timesvc.DefaultPool}}},
+		{"servers", "c", servers, &timesvc.Config{Servers: []string{"192.0.2.1"}}},
+		{"override", "d", servers, &timesvc.Config{Servers: []string{"192.0.2.10"}}},
+		{"consensus member", "a", consensus, &timesvc.Config{
+			Servers: []string{"192.0.2.1", "10.192.2.1", "10.192.5.1", "10.0.0.6"},
+			Serve:   true,
+			Allow:   []string{"10.192.0.0/11", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32", "10.0.0.5/32", "10.0.0.6/32", "10.0.0.7/32"},
+		}},
+		{"consensus member default pool", "a", &cpb.ClusterConfiguration_Time{ConsensusTimeServers: true}, &timesvc.Config{
+			Servers: []string{"10.192.2.1", "10.192.5.1", "10.0.0.6"},
+			Pools:   []string{timesvc.DefaultPool},
+			Serve:   true,
+			Allow:   []string{"10.192.0.0/11", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32", "10.0.0.5/32", "10.0.0.6/32", "10.0.0.7/32"},
+		}},
+		{"consensus member override", "e", consensus, &timesvc.Config{
+			Servers: []string{"192.0.2.10", "10.192.1.1", "10.192.2.1", "10.0.0.6"},
+			Serve:   true,
+			Allow:   []string{"10.192.0.0/11", "10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32", "10.0.0.6/32", "10.0.0.7/32"},
+		}},
+		{"consensus member outside cluster network", "f", consensus, &timesvc.Config{
+			Servers: []string{"192.0.2.1", "10.192.1.1", "10.192.2.1", "10.192.5.1"},
+			Serve:   true,
+			Allow:   []string{"10.192.0.0/11", "10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32", "10.0.0.5/32", "10.0.0.7/32"},
+		}},
+		{"consensus non-member", "c", consensus, &timesvc.Config{
+			Servers: []string{"10.192.1.1", "10.192.2.1", "10.192.5.1", "10.0.0.6"},
+		}},
+		{"consensus non-member outside cluster network", "g", consensus, &timesvc.Config{
+			Servers: []string{"10.192.1.1", "10.192.2.1", "10.192.5.1", "10.0.0.6"},
+		}},
+		{"consensus non-member override", "d", consensus, &timesvc.Config{
+			Servers: []string{"192.0.2.10"},
+		}},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			got := timeConfig(te.nodeID, te.cfg, nodes)
+			if diff := cmp.Diff(te.want, got); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestTimeConfigDedicatedConsensus ensures that time is served by consensus
+// members in clusters without any Kubernetes workers.
+func TestTimeConfigDedicatedConsensus(t *testing.T) {
+	node := func(id, addr string, member bool) *ipb.Node {
+		n := &ipb.Node{
+			Id:     id,
+			Roles:  &cpb.NodeRoles{},
+			Status: &cpb.NodeStatus{ExternalAddress: addr},
+			Clusternet: &cpb.NodeClusterNetworking{
+				Prefixes: []*cpb.NodeClusterNetworking_Prefix{{Cidr: addr + "/32"}},
+			},
+		}
+		if member {
+			n.Roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
+		}
+		return n
+	}
+	nodes := map[string]*ipb.Node{
+		"a": node("a", "10.0.0.1", true),
+		"b": node("b", "10.0.0.2", false),
+	}
+	cfg := &cpb.ClusterConfiguration_Time{ConsensusTimeServers: true}
+
+	want := &timesvc.Config{
+		Pools: []string{timesvc.DefaultPool},
+		Serve: true,
+		Allow: []string{"10.192.0.0/11", "10.0.0.2/32"},
+	}
+	if diff := cmp.Diff(want, timeConfig("a", cfg, nodes)); diff != "" {
+		t.Errorf("unexpected member config (-want +got):\n%s", diff)
+	}
+	want = &timesvc.Config{Servers: []string{"10.0.0.1"}}
+	if diff := cmp.Diff(want, timeConfig("b", cfg, nodes)); diff != "" {
+		t.Errorf("unexpected non-member config (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/go/logging"
+	apb "source.monogon.dev/metropolis/proto/api"
+	epb "source.monogon.dev/metropolis/proto/ext"
+)
+
+const (
+	// auditRequestMaxLength is the maximum length of the request summary stored
+	// in an audit entry.
+	auditRequestMaxLength = 1024
+	// auditRecordTimeout is the time given to an AuditRecorder to record an
+	// entry.
+	auditRecordTimeout = 10 * time.Second
+)
+
+// unauditedPermissions are the permissions which do not cause an RPC to be
+// recorded in the audit log. These are either permissions which only allow
+// reading cluster state, or the permission used by nodes to report their own
+// state (which happens continuously and is not a management action).
+//
+// Any RPC which needs at least one other permission is audited.
+var unauditedPermissions = map[epb.Permission]bool{
+	epb.Permission_PERMISSION_GET_REGISTER_TICKET: true,
+	epb.Permission_PERMISSION_READ_CLUSTER_STATUS: true,
+	epb.Permission_PERMISSION_READ_NODE_LOGS:      true,
+	epb.Permission_PERMISSION_READ_AUDIT_LOG:      true,
+	epb.Permission_PERMISSION_UPDATE_NODE_SELF:    true,
+}
+
+// isAudited returns whether calls to the given gRPC method are recorded in the
+// audit log.
+func isAudited(method string) bool {
+	need, err := MethodPermissions(method)
+	if err != nil {
+		return false
+	}
+	for p, v := range need {
+		if v && !unauditedPermissions[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// auditIdentity returns the identity of the caller as recorded in the audit
+// log.
+func auditIdentity(pi *PeerInfo) string {
+	switch {
+	case pi == nil:
+		return "unknown"
+	case pi.User != nil:
+		return pi.User.Identity
+	case pi.Node != nil:
+		return "node:" + pi.Node.ID
+	default:
+		return "unauthenticated"
+	}
+}
+
+// An AuditRecorder records an entry for a completed call to an audited method,
+// eg. by appending it to the cluster audit log. The given context is not
+// canceled when the caller goes away, as the call might still have taken
+// effect, but carries a deadline.
+//
+// If the entry could not be recorded, an error is returned. The call then
+// fails, so that callers notice that it was not audited, even though it might
+// have taken effect.
+type AuditRecorder func(ctx context.Context, entry *apb.AuditEntry) error
+
+// AuditOptions returns gRPC server options which pass every completed call to
+// an audited method to rec. They must be applied after the options returned
+// by ServerSecurity.GRPCOptions, as they rely on the PeerInfo set by them.
+// Calls which could not be recorded are logged to logger.
+func AuditOptions(logger logging.Leveled, rec AuditRecorder) []grpc.ServerOption {
+	a := &auditor{
+		logger: logger,
+		rec:    rec,
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(a.streamInterceptor),
+	}
+}
+
+type auditor struct {
+	logger logging.Leveled
+	rec    AuditRecorder
+}
+
+func (a *auditor) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if !isAudited(info.FullMethod) {
+		return resp, err
+	}
+	if aerr := a.record(ctx, info.FullMethod, req, err); aerr != nil {
+		return nil, aerr
+	}
+	return resp, err
+}
+
+// auditServerStream wraps a grpc.ServerStream, keeping the first message
+// received from the client, ie. the request of server-streaming RPCs.
+type auditServerStream struct {
+	grpc.ServerStream
+	req interface{}
+}
+
+func (a *auditServerStream) RecvMsg(m interface{}) error {
+	err := a.ServerStream.RecvMsg(m)
+	if err == nil && a.req == nil {
+		a.req = m
+	}
+	return err
+}
+
+func (a *auditor) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !isAudited(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	as := &auditServerStream{ServerStream: ss}
+	err := handler(srv, as)
+	if aerr := a.record(ss.Context(), info.FullMethod, as.req, err); aerr != nil {
+		return aerr
+	}
+	return err
+}
+
+// record builds the entry for a completed call and passes it to the
+// AuditRecorder. If the entry could not be recorded, the failure is logged and
+// a gRPC status to be returned to the caller instead of the call's result is
+// returned.
+func (a *auditor) record(ctx context.Context, method string, req interface{}, err error) error {
+	entry := &apb.AuditEntry{
+		Time:     tpb.Now(),
+		Identity: auditIdentity(GetPeerInfo(ctx)),
+		Method:   method,
+	}
+	if m, ok := req.(proto.Message); ok {
+		entry.Request = auditRequest(m)
+	}
+	if err != nil {
+		st := status.Convert(err)
+		entry.Code = int32(st.Code())
+		entry.Error = st.Message()
+	}
+
+	// Record the call even if the caller went away in the meantime, as the
+	// call might still have taken effect.
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditRecordTimeout)
+	defer cancel()
+	if rerr := a.rec(rctx, entry); rerr != nil {
+		a.logger.Errorf("Could not record call to %s by %s in audit log: %v", entry.Method, entry.Identity, rerr)
+		return status.Error(codes.Unavailable, "call completed, but could not be recorded in the audit log")
+	}
+	return nil
+}
+
+// auditRequest returns the summary of a request stored in an audit entry: the
+// request in protobuf text format, with fields marked with the debug_redact
+// option redacted, truncated if too long.
+func auditRequest(m proto.Message) string {
+	m = proto.Clone(m)
+	auditRedact(m.ProtoReflect())
+	res := prototext.MarshalOptions{}.Format(m)
+	if len(res) > auditRequestMaxLength {
+		res = res[:auditRequestMaxLength] + "..."
+	}
+	return res
+}
+
+// auditRedactedValue replaces redacted string and bytes fields.
+const auditRedactedValue = "[REDACTED]"
+
+// auditRedact redacts all fields marked with the debug_redact option in m and
+// its submessages. Redacted string and bytes fields are replaced with
+// auditRedactedValue, other redacted fields are cleared.
+func auditRedact(m protoreflect.Message) {
+	var redact []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if opts, ok := fd.Options().(*descriptorpb.FieldOptions); ok && opts.GetDebugRedact() {
+			redact = append(redact, fd)
+			return true
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					auditRedact(mv.Message())
+					return true
+				})
+			}
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				auditRedact(v.List().Get(i).Message())
+			}
+		default:
+			auditRedact(v.Message())
+		}
+		return true
+	})
+	for _, fd := range redact {
+		switch {
+		case fd.IsList() || fd.IsMap():
+			m.Clear(fd)
+		case fd.Kind() == protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(auditRedactedValue))
+		case fd.Kind() == protoreflect.BytesKind:
+			m.Set(fd, protoreflect.ValueOfBytes([]byte(auditRedactedValue)))
+		default:
+			m.Clear(fd)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	apb "source.monogon.dev/metropolis/proto/api"
+	"source.monogon.dev/osbase/logtree"
+)
+
+// TestAuditRequestRedaction ensures that fields marked with debug_redact, also
+// in submessages, do not end up in the request summary of audit entries.
+func TestAuditRequestRedaction(t *testing.T) {
+	req := &ipb.RegisterNodeRequest{
+		RegisterTicket: []byte("ticket"),
+		Attestation: &ipb.Attestation{
+			Nonce:           []byte("nonce"),
+			ActivatedSecret: []byte("very-secret"),
+		},
+	}
+	got := auditRequest(req)
+	if strings.Contains(got, "very-secret") {
+		t.Errorf("Request summary %q contains redacted field", got)
+	}
+	for _, want := range []string{"ticket", "nonce", auditRedactedValue} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Request summary %q does not contain %q", got, want)
+		}
+	}
+	// The request itself must not be modified.
+	if want, got := "very-secret", string(req.Attestation.ActivatedSecret); want != got {
+		t.Errorf("Request was modified, secret is %q, wanted %q", got, want)
+	}
+}
+
+// TestAuditRecordFailure ensures that audited calls fail if they could not be
+// recorded.
+func TestAuditRecordFailure(t *testing.T) {
+	lt := logtree.New()
+	var recorded []*apb.AuditEntry
+	var recErr error
+	a := &auditor{
+		logger: lt.MustLeveledFor("test"),
+		rec: func(ctx context.Context, entry *apb.AuditEntry) error {
+			recorded = append(recorded, entry)
+			return recErr
+		},
+	}
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/metropolis.proto.api.Management/ApproveNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &apb.ApproveNodeResponse{}, nil
+	}
+	ctx := context.Background()
+
+	if _, err := a.unaryInterceptor(ctx, &apb.ApproveNodeRequest{}, info, handler); err != nil {
+		t.Errorf("Call failed: %v", err)
+	}
+	recErr = errors.New("etcd unavailable")
+	_, err := a.unaryInterceptor(ctx, &apb.ApproveNodeRequest{}, info, handler)
+	if want, got := codes.Unavailable, status.Code(err); want != got {
+		t.Errorf("Call with failed audit returned %v, wanted %s", err, want)
+	}
+	if want, got := 2, len(recorded); want != got {
+		t.Errorf("Recorded %d entries, wanted %d", got, want)
+	}
+
+	// Calls to unaudited methods are not recorded and not affected.
+	info.FullMethod = "/metropolis.proto.api.Management/GetNodes"
+	if _, err := a.unaryInterceptor(ctx, &apb.GetNodesRequest{}, info, handler); err != nil {
+		t.Errorf("Unaudited call failed: %v", err)
+	}
+	if want, got := 2, len(recorded); want != got {
+		t.Errorf("Recorded %d entries, wanted %d", got, want)
+	}
+}
@@ -205,12 +205,41 @@ func NewAuthenticatedCredentials(cert tls.Certificate, opts ...CredentialsOpt) c
 	return credentials.NewTLS(NewAuthenticatedTLSConfig(cert, opts...))
 }
 
+// NewAuthenticatedNodeTLSConfig is like NewAuthenticatedTLSConfig, but presents
+// the current certificate of the given node credentials on every new
+// connection, so that renewed credentials are picked up without having to
+// recreate the config.
+func NewAuthenticatedNodeTLSConfig(creds *identity.NodeCredentials, opts ...CredentialsOpt) *tls.Config {
+	config := NewAuthenticatedTLSConfig(creds.TLSCredentials(), opts...)
+	config.Certificates = nil
+	config.GetClientCertificate = creds.GetClientCertificate
+	return config
+}
+
+// NewAuthenticatedNodeCredentials is like NewAuthenticatedCredentials, but
+// presents the current certificate of the given node credentials on every new
+// connection, see NewAuthenticatedNodeTLSConfig.
+func NewAuthenticatedNodeCredentials(creds *identity.NodeCredentials, opts ...CredentialsOpt) credentials.TransportCredentials {
+	return credentials.NewTLS(NewAuthenticatedNodeTLSConfig(creds, opts...))
+}
+
 // RetrieveOwnerCertificate uses AAA.Escrow to retrieve a cluster manager
 // certificate for the initial owner of the cluster, authenticated by the
 // public/private key set in the clusters NodeParameters.ClusterBoostrap.
 //
 // The retrieved certificate can be used to dial further cluster RPCs.
 func RetrieveOwnerCertificate(ctx context.Context, aaa apb.AAAClient, private ed25519.PrivateKey) (*tls.Certificate, error) {
+	return RetrieveIdentityCertificate(ctx, aaa, "owner", private)
+}
+
+// RetrieveIdentityCertificate uses AAA.Escrow to retrieve a cluster manager
+// certificate for the given identity, authenticated by a public/private key
+// which is either the owner key (for the 'owner' identity) or the key of the
+// identity's role binding.
+//
+// The retrieved certificate can be used to dial further cluster RPCs, with the
+// permissions granted by the identity's roles.
+func RetrieveIdentityCertificate(ctx context.Context, aaa apb.AAAClient, name string, private ed25519.PrivateKey) (*tls.Certificate, error) {
 	srv, err := aaa.Escrow(ctx)
 	if err != nil {
 		if st, ok := status.FromError(err); ok {
@@ -220,7 +249,7 @@ func RetrieveOwnerCertificate(ctx context.Context, aaa apb.AAAClient, private ed
 	}
 	if err := srv.Send(&apb.EscrowRequest{
 		Parameters: &apb.EscrowRequest_Parameters{
-			RequestedIdentityName: "owner",
+			RequestedIdentityName: name,
 			PublicKey:             private.Public().(ed25519.PublicKey),
 		},
 	}); err != nil {
@@ -87,3 +87,14 @@ func getMethodInfo(methodName string) (*methodInfo, error) {
 	}
 	return res, nil
 }
+
+// MethodPermissions returns the permissions needed to call a given method, as
+// retrieved from grpc.{Stream,Unary}ServerInfo.FullMethod. Methods which can be
+// called without authentication need no permissions.
+func MethodPermissions(methodName string) (Permissions, error) {
+	mi, err := getMethodInfo(methodName)
+	if err != nil {
+		return nil, err
+	}
+	return mi.need, nil
+}
@@ -58,14 +58,24 @@ type PeerInfoNode struct {
 
 	// Permissions are the set of permissions this node has.
 	Permissions Permissions
+
+	// CertificateExpired is set if the node presented an expired certificate.
+	// This is only permitted for calls to Curator.IssueCertificate, so that nodes
+	// which were offline for longer than the lifetime of their certificate can
+	// still renew it.
+	CertificateExpired bool
 }
 
 // PeerInfoUser contains information about a user on the other side of a gRPC
 // connection.
 type PeerInfoUser struct {
-	// Identity is an opaque identifier for the user. MVP: Currently this is always
-	// "manager".
+	// Identity is an opaque identifier for the user. It is either "owner" or the
+	// identity of a role binding.
 	Identity string
+
+	// Permissions are the set of permissions this user has. The owner has all
+	// permissions, other users have the permissions listed in their certificate.
+	Permissions Permissions
 }
 
 type PeerInfoUnauthenticated struct {
@@ -102,8 +112,11 @@ func (p *PeerInfo) CheckPermissions(need Permissions) error {
 		}
 		return nil
 	} else if p.User != nil {
-		// MVP: all permissions are granted to all users.
-		// TODO(q3k): check authz.Need once we have a user/identity system implemented.
+		for n, v := range need {
+			if v && !p.User.Permissions[n] {
+				return status.Errorf(codes.PermissionDenied, "user missing %s permission", n.String())
+			}
+		}
 		return nil
 	} else if p.Node != nil {
 		for n, v := range need {
@@ -125,7 +138,7 @@ func (p *PeerInfo) String() string {
 	case p.Node != nil:
 		return fmt.Sprintf("node: %s, %s", p.Node.ID, p.Node.Permissions)
 	case p.User != nil:
-		return fmt.Sprintf("user: %s", p.User.Identity)
+		return fmt.Sprintf("user: %s, %s", p.User.Identity, p.User.Permissions)
 	case p.Unauthenticated != nil:
 		return fmt.Sprintf("unauthenticated: pubkey %s", hex.EncodeToString(p.Unauthenticated.SelfSignedPublicKey))
 	default:
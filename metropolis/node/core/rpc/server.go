@@ -4,6 +4,9 @@
 package rpc
 
 import (
+	"crypto/x509"
+
+	"source.monogon.dev/metropolis/node/core/identity"
 	epb "source.monogon.dev/metropolis/proto/ext"
 )
 
@@ -16,3 +19,37 @@ var (
 		epb.Permission_PERMISSION_UPDATE_NODE_SELF:    true,
 	}
 )
+
+// ownerPermissions returns the set of all permissions, which are given to the
+// owner of the cluster.
+func ownerPermissions() Permissions {
+	res := make(Permissions)
+	for v := range epb.Permission_name {
+		if p := epb.Permission(v); p != epb.Permission_PERMISSION_UNSPECIFIED {
+			res[p] = true
+		}
+	}
+	return res
+}
+
+// userPermissions returns the permissions granted to a user with the given
+// identity and certificate. The owner has all permissions, while all other
+// users only have the permissions listed in their certificate, as issued by the
+// curator from the user's role binding. Unknown permissions are ignored, as
+// they might have been issued by a newer version of the curator.
+func userPermissions(id string, cert *x509.Certificate) Permissions {
+	if id == "owner" {
+		return ownerPermissions()
+	}
+	res := make(Permissions)
+	for _, name := range identity.UserPermissions(cert) {
+		v, ok := epb.Permission_value[name]
+		if !ok {
+			continue
+		}
+		if p := epb.Permission(v); p != epb.Permission_PERMISSION_UNSPECIFIED && p != epb.Permission_PERMISSION_UPDATE_NODE_SELF {
+			res[p] = true
+		}
+	}
+	return res
+}
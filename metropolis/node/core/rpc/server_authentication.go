@@ -8,6 +8,7 @@ import (
 	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -17,6 +18,8 @@ import (
 
 	"source.monogon.dev/go/logging"
 	"source.monogon.dev/metropolis/node/core/identity"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 )
 
 // ServerSecurity are the security options of a RPC server that will run
@@ -27,6 +30,8 @@ type ServerSecurity struct {
 	// NodeCredentials which will be used to run the gRPC server, and whose CA
 	// certificate will be used to authenticate incoming requests.
 	NodeCredentials *identity.NodeCredentials
+	// Revocations, if set, are used to reject revoked certificates.
+	Revocations *identity.Revocations
 
 	// nodePermissions is used by tests to inject the permissions available to a
 	// node. When not set, it defaults to the global nodePermissions map.
@@ -42,8 +47,8 @@ type ServerSecurity struct {
 // settings for authenticating itself to callers.
 func (s *ServerSecurity) GRPCOptions(logger logging.Leveled) []grpc.ServerOption {
 	externalCreds := credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{s.NodeCredentials.TLSCredentials()},
-		ClientAuth:   tls.RequestClientCert,
+		GetCertificate: s.NodeCredentials.GetCertificate,
+		ClientAuth:     tls.RequestClientCert,
 	})
 
 	return []grpc.ServerOption{
@@ -135,7 +140,7 @@ func (s *ServerSecurity) authenticationCheck(ctx context.Context, methodName str
 		return s.getPeerInfoUnauthenticated(ctx)
 	}
 
-	pi, err := s.getPeerInfo(ctx)
+	pi, err := s.getPeerInfo(ctx, methodName)
 	if err != nil {
 		return nil, err
 	}
@@ -153,7 +158,10 @@ func (s *ServerSecurity) authenticationCheck(ctx context.Context, methodName str
 // The returned PeerInfo can then be used to perform authorization checks based
 // on the configured authentication of a given gRPC method, as described by the
 // metropolis.proto.ext.authorization extension.
-func (s *ServerSecurity) getPeerInfo(ctx context.Context) (*PeerInfo, error) {
+//
+// Expired certificates are rejected, except for node certificates presented
+// when calling Curator.IssueCertificate (see PeerInfoNode.CertificateExpired).
+func (s *ServerSecurity) getPeerInfo(ctx context.Context, methodName string) (*PeerInfo, error) {
 	cert, err := GetPeerCertificate(ctx)
 	if err != nil {
 		return nil, err
@@ -163,28 +171,42 @@ func (s *ServerSecurity) getPeerInfo(ctx context.Context) (*PeerInfo, error) {
 	if err := cert.CheckSignatureFrom(s.NodeCredentials.ClusterCA()); err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "certificate not signed by cluster CA: %v", err)
 	}
+	if s.Revocations.Revoked(cert) {
+		return nil, status.Errorf(codes.Unauthenticated, "certificate has been revoked")
+	}
+	// Check whether the certificate has expired. Its NotBefore is not checked, as
+	// the clocks of the issuing and the verifying node might be out of sync.
+	expired := time.Now().After(cert.NotAfter)
 
 	id, errNode := identity.VerifyNodeInCluster(cert, s.NodeCredentials.ClusterCA())
 	if errNode == nil {
 		// This is a Metropolis node.
+		if expired && methodName != ipb.Curator_IssueCertificate_FullMethodName {
+			return nil, status.Errorf(codes.Unauthenticated, "certificate expired at %s", cert.NotAfter)
+		}
 		np := s.nodePermissions
 		if np == nil {
 			np = nodePermissions
 		}
 		return &PeerInfo{
 			Node: &PeerInfoNode{
-				ID:          id,
-				Permissions: np,
+				ID:                 id,
+				Permissions:        np,
+				CertificateExpired: expired,
 			},
 		}, nil
 	}
 
+	if expired {
+		return nil, status.Errorf(codes.Unauthenticated, "certificate expired at %s", cert.NotAfter)
+	}
 	userid, errUser := identity.VerifyUserInCluster(cert, s.NodeCredentials.ClusterCA())
 	if errUser == nil {
 		// This is a Metropolis user/manager.
 		return &PeerInfo{
 			User: &PeerInfoUser{
-				Identity: userid,
+				Identity:    userid,
+				Permissions: userPermissions(userid, cert),
 			},
 		}, nil
 	}
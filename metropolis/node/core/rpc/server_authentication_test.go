@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/x509"
 	"net"
 	"testing"
 
@@ -16,6 +17,7 @@ import (
 	"google.golang.org/grpc/test/bufconn"
 
 	cpb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	"source.monogon.dev/metropolis/node/core/identity"
 	apb "source.monogon.dev/metropolis/proto/api"
 	epb "source.monogon.dev/metropolis/proto/ext"
 	"source.monogon.dev/metropolis/test/util"
@@ -41,8 +43,10 @@ func TestExternalServerSecurity(t *testing.T) {
 	for k, v := range nodePermissions {
 		permissions[k] = v
 	}
+	revocations := &identity.Revocations{}
 	ss := ServerSecurity{
 		NodeCredentials: eph.Nodes[0],
+		Revocations:     revocations,
 		nodePermissions: permissions,
 	}
 
@@ -102,6 +106,80 @@ func TestExternalServerSecurity(t *testing.T) {
 	}
 	permissions[epb.Permission_PERMISSION_GET_REGISTER_TICKET] = false
 
+	// Authenticate as a node with an expired certificate, ensure that it can only
+	// call IssueCertificate to renew it.
+	cl, err = grpc.NewClient("passthrough:///local",
+		grpc.WithTransportCredentials(NewAuthenticatedNodeCredentials(eph.NewExpiredNode(t), WantRemoteCluster(eph.CA))),
+		withLocalDialer)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cl.Close()
+	cur := cpb.NewCuratorClient(cl)
+	_, err = cur.UpdateNodeStatus(ctx, &cpb.UpdateNodeStatusRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unauthenticated {
+		t.Errorf("UpdateNodeStatus (by expired node) returned %v, wanted codes.Unauthenticated", err)
+	}
+	_, err = cur.IssueCertificate(ctx, &cpb.IssueCertificateRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unimplemented {
+		t.Errorf("IssueCertificate (by expired node) returned %v, wanted codes.Unimplemented", err)
+	}
+
+	// Authenticate as a scoped user which is only allowed to read the cluster
+	// status, ensure that GetClusterInfo runs but GetRegisterTicket is refused.
+	// Unknown permissions and permissions reserved for nodes are ignored.
+	scoped := eph.NewScopedUser(t, "viewer", []string{
+		epb.Permission_PERMISSION_READ_CLUSTER_STATUS.String(),
+		epb.Permission_PERMISSION_UPDATE_NODE_SELF.String(),
+		"PERMISSION_DOES_NOT_EXIST",
+	})
+	cl, err = grpc.NewClient("passthrough:///local",
+		grpc.WithTransportCredentials(NewAuthenticatedCredentials(scoped, WantRemoteCluster(eph.CA))),
+		withLocalDialer)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cl.Close()
+	mgmt = apb.NewManagementClient(cl)
+	_, err = mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unimplemented {
+		t.Errorf("GetClusterInfo (by scoped user) returned %v, wanted codes.Unimplemented", err)
+	}
+	_, err = mgmt.GetRegisterTicket(ctx, &apb.GetRegisterTicketRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.PermissionDenied {
+		t.Errorf("GetRegisterTicket (by scoped user) returned %v, wanted codes.PermissionDenied", err)
+	}
+	cur = cpb.NewCuratorClient(cl)
+	_, err = cur.UpdateNodeStatus(ctx, &cpb.UpdateNodeStatusRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.PermissionDenied {
+		t.Errorf("UpdateNodeStatus (by scoped user) returned %v, wanted codes.PermissionDenied", err)
+	}
+
+	// Revoke the certificate of the scoped user, ensure that it is refused
+	// while other certificates are still accepted.
+	scopedCert, err := x509.ParseCertificate(scoped.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	if err := revocations.Update(eph.NewCRL(t, scopedCert), eph.CA); err != nil {
+		t.Fatalf("Revocations.Update: %v", err)
+	}
+	_, err = mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unauthenticated {
+		t.Errorf("GetClusterInfo (by revoked scoped user) returned %v, wanted codes.Unauthenticated", err)
+	}
+	cl, err = grpc.NewClient("passthrough:///local",
+		grpc.WithTransportCredentials(NewAuthenticatedCredentials(eph.Manager, WantRemoteCluster(eph.CA))),
+		withLocalDialer)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cl.Close()
+	_, err = apb.NewManagementClient(cl).GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unimplemented {
+		t.Errorf("GetClusterInfo (by manager) returned %v, wanted codes.Unimplemented", err)
+	}
+
 	// Authenticate with an ephemeral/self-signed certificate, ensure that
 	// GetRegisterTicket is refused (this is because GetRegisterTicket requires an
 	// authenticated connection).
@@ -6,8 +6,11 @@
 // Metropolis nodes need accurate time both for themselves (for log
 // timestamping, validating certain certificates, ...) as well as workloads
 // running on top of it expecting accurate time.
-// This initial implementation is very minimalistic, running just a stateless
-// NTP client per node for the whole lifecycle of it.
+// The service runs a chrony NTP client, by default against the Monogon NTP
+// pool. The time sources can be reconfigured at runtime (eg. from the cluster
+// configuration), and the node can optionally serve time to other nodes.
+// The synchronization state of the clock is exported as a NodeTimeStatus and
+// as metrics.
 // This implementation is simple, but is fairly unsafe as NTP by itself does
 // not offer any cryptography, so it's easy to tamper with the responses.
 // See #73 for further work in that direction.
@@ -16,27 +19,61 @@ package time
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 
 	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/fileargs"
 	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
-// Service implements the time service. See package documentation for further
-// information.
-type Service struct{}
+// TODO(#72): Apply for a NTP pool vendor zone
 
-func New() *Service {
-	return &Service{}
+// DefaultPool is the NTP pool used if no other time sources are configured.
+const DefaultPool = "ntp.monogon.dev"
+
+// Config is the configuration of the time service.
+type Config struct {
+	// Servers are the NTP servers (IP addresses or host names) to synchronize
+	// with.
+	Servers []string
+	// Pools are the NTP pools (host names resolving to multiple servers) to
+	// synchronize with.
+	Pools []string
+	// Serve enables serving time to other hosts on NTPPort. A serving node keeps
+	// serving time even if none of its time sources are reachable.
+	Serve bool
+	// Allow are the prefixes (in CIDR notation) of the hosts which are served
+	// time if Serve is set. Requests from all other hosts are ignored.
+	Allow []string
 }
 
-func (s *Service) Run(ctx context.Context) error {
-	// TODO(#72): Apply for a NTP pool vendor zone
-	config := strings.Join([]string{
-		"pool ntp.monogon.dev iburst",
+// Equal returns true if both configurations are identical.
+func (c *Config) Equal(o *Config) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return slices.Equal(c.Servers, o.Servers) && slices.Equal(c.Pools, o.Pools) && c.Serve == o.Serve && slices.Equal(c.Allow, o.Allow)
+}
+
+// chronyConfig returns the chrony configuration file for this Config, with
+// chrony placing its logs into logDir.
+func (c *Config) chronyConfig(logDir string) string {
+	var lines []string
+	for _, s := range c.Servers {
+		lines = append(lines, fmt.Sprintf("server %s iburst", s))
+	}
+	for _, p := range c.Pools {
+		lines = append(lines, fmt.Sprintf("pool %s iburst", p))
+	}
+	lines = append(lines,
 		"bindcmdaddress /",
 		"stratumweight 0.01",
 		"leapsecmode slew",
@@ -44,18 +81,112 @@ func (s *Service) Run(ctx context.Context) error {
 		"makestep 2.0 3",
 		"rtconutc",
 		"rtcsync",
-	}, "\n")
+		// The tracking log is used to retrieve the synchronization status, as
+		// our chrony build does not include the command and monitoring
+		// interface.
+		"logdir "+logDir,
+		"log tracking",
+		"logbanner 0",
+	)
+	if c.Serve {
+		lines = append(lines, "port "+node.NTPPort.PortString())
+		for _, a := range c.Allow {
+			lines = append(lines, "allow "+a)
+		}
+		lines = append(lines,
+			"ratelimit interval 1 burst 16",
+			// Keep serving time if all time sources are unreachable. All
+			// serving nodes are expected to use each other as time sources,
+			// they then agree on a common time in orphan mode.
+			"local stratum 10 orphan",
+		)
+	} else {
+		lines = append(lines, "port 0")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Service implements the time service. See package documentation for further
+// information.
+type Service struct {
+	// Status is the synchronization status of the system clock, updated
+	// whenever chrony adjusts the clock.
+	Status memory.Value[*cpb.NodeTimeStatus]
+
+	ephemeral *localstorage.EphemeralDirectory
+	config    memory.Value[*Config]
+}
+
+// New creates a time service which places its runtime data in the given
+// ephemeral directory. It synchronizes against DefaultPool until configured
+// otherwise.
+func New(ephemeral *localstorage.EphemeralDirectory) *Service {
+	s := &Service{
+		ephemeral: ephemeral,
+	}
+	s.config.Set(&Config{
+		Pools: []string{DefaultPool},
+	})
+	return s
+}
+
+// Configure sets the configuration of the time service. The service restarts
+// chrony to apply it if it differs from the current configuration.
+func (s *Service) Configure(c *Config) {
+	s.config.Set(c)
+}
+
+func (s *Service) Run(ctx context.Context) error {
+	w := s.config.Watch()
+	defer w.Close()
+
+	cfg, err := w.Get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "chrony", func(ctx context.Context) error {
+		return s.runChrony(ctx, cfg)
+	}); err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "tracking", s.runTracking); err != nil {
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	for {
+		newCfg, err := w.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if !cfg.Equal(newCfg) {
+			break
+		}
+	}
+	supervisor.Logger(ctx).Infof("Configuration changed, restarting...")
+	return fmt.Errorf("config changed, restarting")
+}
+
+// runChrony runs chrony with the given configuration.
+func (s *Service) runChrony(ctx context.Context, cfg *Config) error {
+	// chrony drops privileges before it first writes its logs.
+	logDir := s.ephemeral.Chrony.FullPath()
+	if err := os.Chown(logDir, node.TimeUid, node.TimeUid); err != nil {
+		return fmt.Errorf("cannot chown log directory: %w", err)
+	}
+
 	args, err := fileargs.New()
 	if err != nil {
 		return fmt.Errorf("cannot create fileargs: %w", err)
 	}
 	defer args.Close()
+	supervisor.Logger(ctx).Infof("Starting chrony with %d servers and %d pools, serving: %v (%d prefixes)", len(cfg.Servers), len(cfg.Pools), cfg.Serve, len(cfg.Allow))
 	cmd := exec.CommandContext(ctx,
 		"/time/chrony",
 		"-d",
 		"-i", strconv.Itoa(node.TimeUid),
 		"-g", strconv.Itoa(node.TimeUid),
-		"-f", args.ArgPath("chrony.conf", []byte(config)),
+		"-f", args.ArgPath("chrony.conf", []byte(cfg.chronyConfig(logDir))),
 	)
 	cmd.Stdout = supervisor.RawLogger(ctx)
 	cmd.Stderr = supervisor.RawLogger(ctx)
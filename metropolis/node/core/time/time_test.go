@@ -0,0 +1,85 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package time
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+func TestChronyConfig(t *testing.T) {
+	for i, te := range []struct {
+		cfg  Config
+		want []string
+		not  []string
+	}{
+		{
+			cfg:  Config{Pools: []string{DefaultPool}},
+			want: []string{"pool ntp.monogon.dev iburst", "log tracking", "port 0"},
+			not:  []string{"server ", "allow", "local "},
+		},
+		{
+			cfg:  Config{Servers: []string{"192.0.2.1", "ntp.example.com"}},
+			want: []string{"server 192.0.2.1 iburst", "server ntp.example.com iburst"},
+			not:  []string{"pool ", "allow"},
+		},
+		{
+			cfg:  Config{Servers: []string{"192.0.2.1"}, Serve: true, Allow: []string{"10.192.0.0/11"}},
+			want: []string{"server 192.0.2.1 iburst", "port 123", "allow 10.192.0.0/11", "local stratum 10 orphan"},
+			not:  []string{"port 0"},
+		},
+		{
+			// Time is never served to everyone.
+			cfg:  Config{Servers: []string{"192.0.2.1"}, Serve: true},
+			want: []string{"port 123", "local stratum 10 orphan"},
+			not:  []string{"allow"},
+		},
+	} {
+		lines := strings.Split(te.cfg.chronyConfig("/ephemeral/chrony"), "\n")
+		has := func(prefix string) bool {
+			for _, l := range lines {
+				if strings.HasPrefix(l, prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, w := range te.want {
+			if !has(w) {
+				t.Errorf("%d: config is missing %q", i, w)
+			}
+		}
+		for _, n := range te.not {
+			if has(n) {
+				t.Errorf("%d: config unexpectedly contains %q", i, n)
+			}
+		}
+	}
+}
+
+func TestParseTrackingLine(t *testing.T) {
+	st, err := parseTrackingLine("2024-01-01 00:00:00 192.0.2.1     2     -1.234      0.012 -1.500e-03 N  1  2.345e-06 -1.234e-07  1.234e-02  3.456e-03  1.234e-02")
+	if err != nil {
+		t.Fatalf("parseTrackingLine: %v", err)
+	}
+	want := &cpb.NodeTimeStatus{
+		Offset:    durationpb.New(-1500 * time.Microsecond),
+		Reference: "192.0.2.1",
+		Stratum:   2,
+	}
+	if diff := cmp.Diff(want, st, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected status (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseTrackingLine("2024-01-01 00:00:00 192.0.2.1"); err == nil {
+		t.Errorf("truncated line parsed without error")
+	}
+}
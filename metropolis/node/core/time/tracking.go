@@ -0,0 +1,134 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package time
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"source.monogon.dev/metropolis/node/core/metrics"
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// trackingLogTail is the amount of bytes read from the end of the tracking
+// log, which is enough to contain its last line.
+const trackingLogTail = 4096
+
+// runTracking periodically updates Status and the clock metrics from the
+// kernel clock state and chrony's tracking log.
+func (s *Service) runTracking(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	t := time.NewTicker(10 * time.Second)
+	defer t.Stop()
+	var last *cpb.NodeTimeStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		st := &cpb.NodeTimeStatus{}
+		line, err := readLastLine(filepath.Join(s.ephemeral.Chrony.FullPath(), "tracking.log"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Not yet synchronized for the first time.
+		case err != nil:
+			return fmt.Errorf("while reading tracking log: %w", err)
+		case line != "":
+			st, err = parseTrackingLine(line)
+			if err != nil {
+				return fmt.Errorf("while parsing tracking log: %w", err)
+			}
+		}
+
+		var tx unix.Timex
+		state, err := unix.Adjtimex(&tx)
+		if err != nil {
+			return fmt.Errorf("adjtimex failed: %w", err)
+		}
+		st.Synchronized = state != unix.TIME_ERROR && tx.Status&unix.STA_UNSYNC == 0
+
+		if st.Synchronized {
+			metrics.ClockSynchronized.Set(1)
+		} else {
+			metrics.ClockSynchronized.Set(0)
+		}
+		metrics.ClockOffset.Set(st.Offset.AsDuration().Seconds())
+
+		if !proto.Equal(last, st) {
+			s.Status.Set(st)
+			last = st
+		}
+	}
+}
+
+// readLastLine returns the last non-empty line of a file.
+func readLastLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() > trackingLogTail {
+		if _, err := f.Seek(-trackingLogTail, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if i := bytes.LastIndexByte(data, '\n'); i != -1 {
+		data = data[i+1:]
+	}
+	return string(data), nil
+}
+
+// parseTrackingLine parses a line of chrony's tracking log into a
+// NodeTimeStatus, leaving Synchronized unset. Lines have the following
+// columns:
+//
+//	Date (UTC) Time     IP Address   St   Freq ppm   Skew ppm     Offset L Co  Offset sd Rem. corr. Root delay Root disp. Max. error
+//	2024-01-01 00:00:00 192.0.2.1     2     -1.234      0.012  1.234e-05 N  1  2.345e-06 -1.234e-07  1.234e-02  3.456e-03  1.234e-02
+func parseTrackingLine(line string) (*cpb.NodeTimeStatus, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("expected at least 7 fields, got %d", len(fields))
+	}
+	stratum, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stratum %q: %w", fields[3], err)
+	}
+	offset, err := strconv.ParseFloat(fields[6], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offset %q: %w", fields[6], err)
+	}
+	return &cpb.NodeTimeStatus{
+		Offset:    durationpb.New(time.Duration(offset * float64(time.Second))),
+		Reference: fields[2],
+		Stratum:   uint32(stratum),
+	}, nil
+}
@@ -0,0 +1,125 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package update
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"source.monogon.dev/osbase/blockdev"
+	"source.monogon.dev/osbase/oci/osimage"
+)
+
+const (
+	// deltaMaxFetchChunks is the maximum number of consecutive chunks fetched
+	// with a single request during a delta installation.
+	deltaMaxFetchChunks = 16
+)
+
+// installSystemDelta writes the system payload into the target slot, only
+// fetching chunks which are not already available locally. A chunk is
+// available if the target slot already contains it at the right offset, which
+// is the case when resuming an interrupted installation of the same image, or
+// if the running slot contains a chunk with the same hash, which is the case
+// for most of the system image in small point releases.
+//
+// All chunks are verified against the chunk hashes of the new image before
+// they are written, so the contents of the local slots don't need to be
+// trusted. Fetched chunks are written immediately, so retrying a failed
+// installation continues where it left off.
+func (s *Service) installSystemDelta(payload *osimage.ChunkedPayload, source, target *blockdev.Device) error {
+	chunkSize := payload.Info.HashChunkSize
+	sourceSize := source.BlockCount() * source.BlockSize()
+	targetSize := target.BlockCount() * target.BlockSize()
+	if payload.Info.Size > targetSize {
+		return fmt.Errorf("system image of size %d does not fit into slot of size %d", payload.Info.Size, targetSize)
+	}
+	buf := make([]byte, chunkSize)
+
+	// Index the chunks of the running slot by their hash.
+	sourceChunks := make(map[[sha256.Size]byte]int64)
+	for offset := int64(0); offset+chunkSize <= sourceSize; offset += chunkSize {
+		if _, err := source.ReadAt(buf, offset); err != nil {
+			return fmt.Errorf("while reading running slot: %w", err)
+		}
+		hash := sha256.Sum256(buf)
+		if _, ok := sourceChunks[hash]; !ok {
+			sourceChunks[hash] = offset
+		}
+	}
+
+	var missing []int
+	var present, copied, fetched int64
+	for i := range payload.ChunkCount() {
+		offset, length := payload.ChunkRange(i)
+		expectedHash, err := payload.ChunkHash(i)
+		if err != nil {
+			return err
+		}
+		chunk := buf[:length]
+
+		ok, err := readChunkMatching(target, chunk, offset, expectedHash)
+		if err != nil {
+			return fmt.Errorf("while reading target slot: %w", err)
+		}
+		if ok {
+			present += length
+			continue
+		}
+
+		// A short last chunk is not in the index, but may be unchanged at the same
+		// offset in the running slot.
+		sourceOffset, ok := sourceChunks[expectedHash]
+		if !ok && length < chunkSize && offset+length <= sourceSize {
+			sourceOffset, ok = offset, true
+		}
+		if ok {
+			ok, err = readChunkMatching(source, chunk, sourceOffset, expectedHash)
+			if err != nil {
+				return fmt.Errorf("while reading running slot: %w", err)
+			}
+		}
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		if _, err := target.WriteAt(chunk, offset); err != nil {
+			return fmt.Errorf("while writing target slot: %w", err)
+		}
+		copied += length
+	}
+
+	s.Logger.Infof("Delta update: %d bytes already present, %d bytes copied from running slot, fetching %d of %d chunks", present, copied, len(missing), payload.ChunkCount())
+	for len(missing) > 0 {
+		first, count := missing[0], 1
+		for count < len(missing) && count < deltaMaxFetchChunks && missing[count] == first+count {
+			count++
+		}
+		missing = missing[count:]
+		content, err := payload.ReadChunks(first, count)
+		if err != nil {
+			return fmt.Errorf("while fetching chunks: %w", err)
+		}
+		offset, _ := payload.ChunkRange(first)
+		if _, err := target.WriteAt(content, offset); err != nil {
+			return fmt.Errorf("while writing target slot: %w", err)
+		}
+		fetched += int64(len(content))
+	}
+	s.Logger.Infof("Delta update: fetched %d bytes instead of %d bytes", fetched, payload.Info.Size)
+	return target.Sync()
+}
+
+// readChunkMatching reads len(chunk) bytes at offset from dev into chunk and
+// returns whether they match the expected hash. Reads beyond the end of the
+// device don't match.
+func readChunkMatching(dev *blockdev.Device, chunk []byte, offset int64, expectedHash [sha256.Size]byte) (bool, error) {
+	if offset+int64(len(chunk)) > dev.BlockCount()*dev.BlockSize() {
+		return false, nil
+	}
+	if _, err := dev.ReadAt(chunk, offset); err != nil {
+		return false, err
+	}
+	return sha256.Sum256(chunk) == expectedHash, nil
+}
@@ -70,6 +70,10 @@ func testosRunnable(ctx context.Context) error {
 	if err := updateSvc.MarkBootSuccessful(); err != nil {
 		supervisor.Logger(ctx).Errorf("error marking boot successful: %w", err)
 	}
+	// The test images are not signed.
+	if err := updateSvc.ProvideSigningKeys(nil); err != nil {
+		return err
+	}
 
 	_, err = os.Stat("/sys/firmware/qemu_fw_cfg/by_name/opt/use_kexec/raw")
 	useKexec := err == nil
@@ -0,0 +1,237 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"source.monogon.dev/metropolis/node/core/productinfo"
+
+	abloaderpb "source.monogon.dev/metropolis/node/abloader/spec"
+	upb "source.monogon.dev/metropolis/node/core/update/proto"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+const (
+	// DefaultHealthGateTimeout is the health gate timeout used if none is
+	// specified when installing an update.
+	DefaultHealthGateTimeout = 10 * time.Minute
+
+	healthGatePath = "EFI/metropolis/health_gate.pb"
+	rollbackPath   = "EFI/metropolis/rollback.pb"
+)
+
+// ArmHealthGate configures a health gate for the slot which was set to boot
+// next by InstallImage. It must be called after InstallImage and before
+// activating the update, and fails if no update has been installed.
+//
+// When the node boots into the updated slot, it does not permanently activate
+// it until the node has been determined to be healthy (see HealthGate and
+// MarkBootSuccessful). If that doesn't happen within the given timeout, the
+// node is expected to call RevertTrialBoot and reboot into the previous slot.
+//
+// The given updateID, if any, identifies the update requested by the cluster
+// and is recorded with a rollback of the update.
+func (s *Service) ArmHealthGate(timeout time.Duration, updateID string) error {
+	if s.ESPPath == "" {
+		return errors.New("no ESP information provided to update service, cannot continue")
+	}
+	activeSlot := s.CurrentlyRunningSlot()
+	if activeSlot == SlotInvalid {
+		return errors.New("unable to determine active slot, cannot continue")
+	}
+	if !s.staged.Load() {
+		return errors.New("no update installed, not arming health gate")
+	}
+	if timeout <= 0 {
+		timeout = DefaultHealthGateTimeout
+	}
+	gate := &upb.HealthGate{
+		Slot:            abloaderpb.Slot(activeSlot.Other()),
+		Timeout:         durationpb.New(timeout),
+		PreviousVersion: productinfo.Get().Version,
+		UpdateId:        updateID,
+	}
+	if err := s.writeESPProto(healthGatePath, gate); err != nil {
+		return fmt.Errorf("while writing health gate: %w", err)
+	}
+	s.Logger.Infof("Armed health gate for slot %v with timeout %v", activeSlot.Other(), timeout)
+	return nil
+}
+
+// HealthGate returns the health gate of the currently running slot, if the
+// node is currently running the first boot of an update with an armed health
+// gate. In this case, MarkBootSuccessful must only be called once the node has
+// been determined to be healthy. Otherwise, nil is returned.
+func (s *Service) HealthGate() (*upb.HealthGate, error) {
+	if s.ESPPath == "" {
+		return nil, errors.New("no ESP information provided to update service, cannot continue")
+	}
+	var gate upb.HealthGate
+	if err := s.readESPProto(healthGatePath, &gate); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("while reading health gate: %w", err)
+	}
+	if Slot(gate.Slot) != s.CurrentlyRunningSlot() {
+		return nil, nil
+	}
+	abState, err := s.getABState()
+	if err != nil {
+		return nil, fmt.Errorf("while getting A/B loader state: %w", err)
+	}
+	if abState.ActiveSlot == gate.Slot {
+		// Already permanently activated.
+		return nil, nil
+	}
+	return &gate, nil
+}
+
+// RevertTrialBoot is called when the currently running slot failed to pass its
+// health gate. It ensures the next boot starts the previous (still active) slot
+// and records the rollback, to be reported by LastRollback. The caller is
+// responsible for rebooting the node afterwards.
+func (s *Service) RevertTrialBoot(reason string) error {
+	gate, err := s.HealthGate()
+	if err != nil {
+		return err
+	}
+	if gate == nil {
+		return errors.New("not running a gated update, cannot revert")
+	}
+	abState, err := s.getABState()
+	if err != nil {
+		return fmt.Errorf("while getting A/B loader state: %w", err)
+	}
+	// The loader already reset next_slot when booting us, but make sure it
+	// stays that way.
+	err = s.setABState(&abloaderpb.ABLoaderData{
+		ActiveSlot: abState.ActiveSlot,
+	})
+	if err != nil {
+		return fmt.Errorf("while setting A/B loader state: %w", err)
+	}
+	s.recordRollback(gate, reason)
+	s.Logger.Warningf("Reverting update in slot %v: %s", s.CurrentlyRunningSlot(), reason)
+	return nil
+}
+
+// LastRollback returns the last automatic rollback performed by this node, or
+// nil if there was none since the last successfully activated update.
+func (s *Service) LastRollback() (*upb.Rollback, error) {
+	if s.ESPPath == "" {
+		return nil, errors.New("no ESP information provided to update service, cannot continue")
+	}
+	var rb upb.Rollback
+	if err := s.readESPProto(rollbackPath, &rb); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rb, nil
+}
+
+// recordRollback persists a NodeRollback for the given health gate and removes
+// the gate, as it has been acted upon.
+func (s *Service) recordRollback(gate *upb.HealthGate, reason string) {
+	rb := &cpb.NodeRollback{
+		FromVersion: productinfo.Get().Version,
+		ToVersion:   gate.PreviousVersion,
+		Reason:      reason,
+		Timestamp:   timestamppb.Now(),
+	}
+	if Slot(gate.Slot) != s.CurrentlyRunningSlot() {
+		// We're running the previous version, the failed one is unknown.
+		rb.FromVersion = nil
+		rb.ToVersion = productinfo.Get().Version
+	}
+	err := s.writeESPProto(rollbackPath, &upb.Rollback{
+		Rollback: rb,
+		UpdateId: gate.UpdateId,
+	})
+	if err != nil {
+		s.Logger.Errorf("Failed to record rollback: %v", err)
+	}
+	s.clearHealthGate()
+}
+
+// finishHealthGate is called by MarkBootSuccessful. It clears the health gate
+// and rollback records once an update has been permanently activated, and
+// records a rollback if a gated update never made it to its health gate (eg.
+// because it failed to boot).
+func (s *Service) finishHealthGate(activated bool) {
+	if activated {
+		s.clearHealthGate()
+		if err := os.Remove(filepath.Join(s.ESPPath, rollbackPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.Logger.Warningf("Failed to remove rollback record: %v", err)
+		}
+		return
+	}
+	var gate upb.HealthGate
+	if err := s.readESPProto(healthGatePath, &gate); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.Logger.Warningf("Failed to read health gate: %v", err)
+		}
+		return
+	}
+	if Slot(gate.Slot) == s.CurrentlyRunningSlot() {
+		return
+	}
+	// The gate is for the other slot, but we're running and are successful. This
+	// means that the updated slot was booted and didn't make it to the health
+	// gate, and that the loader fell back to the active slot.
+	s.recordRollback(&gate, fmt.Sprintf("slot %v failed to boot", Slot(gate.Slot)))
+}
+
+func (s *Service) clearHealthGate() {
+	if err := os.Remove(filepath.Join(s.ESPPath, healthGatePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.Logger.Warningf("Failed to remove health gate: %v", err)
+	}
+}
+
+func (s *Service) readESPProto(path string, m proto.Message) error {
+	raw, err := os.ReadFile(filepath.Join(s.ESPPath, path))
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(raw, m)
+}
+
+// writeESPProto atomically replaces the file at the given path on the ESP with
+// the given message, so that a crash never leaves a partially written file
+// behind.
+func (s *Service) writeESPProto(path string, m proto.Message) error {
+	raw, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("while marshaling: %w", err)
+	}
+	path = filepath.Join(s.ESPPath, path)
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(raw); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,461 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package update
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/osbase/blockdev"
+	"source.monogon.dev/osbase/oci"
+	"source.monogon.dev/osbase/oci/osimage"
+	"source.monogon.dev/osbase/oci/registry"
+	"source.monogon.dev/osbase/oci/signature"
+	"source.monogon.dev/osbase/supervisor"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+const (
+	// imageCachePeers is the maximum number of peers which are asked for an OS
+	// image before falling back to the upstream registry.
+	imageCachePeers = 3
+	// imageCacheProbeTimeout is the time a peer has to return the manifest of an
+	// OS image before the next peer is tried.
+	imageCacheProbeTimeout = 10 * time.Second
+
+	imageCacheCurrent   = "current"
+	imageCacheStaging   = "staging"
+	imageCacheRefFile   = "image_ref.pb"
+	imageCacheSignature = "signature"
+)
+
+var errNoImagePeers = errors.New("no peers available")
+
+// ImagePeer is another node of the cluster which might serve OS images from
+// its image cache.
+type ImagePeer struct {
+	// ID of the node, used to authenticate it.
+	ID string
+	// Host is the address of the node.
+	Host string
+}
+
+// ProvideImagePeers provides the update service with the other nodes of the
+// cluster, which are asked for OS images before falling back to the upstream
+// registry. The given credentials are used to authenticate to them.
+func (s *Service) ProvideImagePeers(creds *identity.NodeCredentials, peers []ImagePeer) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	s.peersCreds = creds
+	s.peers = peers
+}
+
+// installFromPeers attempts to install the given image by fetching it from the
+// image cache of other nodes.
+func (s *Service) installFromPeers(ctx context.Context, imageRef *apb.OSImageRef, withKexec bool) error {
+	s.peersMu.Lock()
+	creds := s.peersCreds
+	peers := make([]ImagePeer, len(s.peers))
+	copy(peers, s.peers)
+	s.peersMu.Unlock()
+
+	if creds == nil || len(peers) == 0 {
+		return errNoImagePeers
+	}
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > imageCachePeers {
+		peers = peers[:imageCachePeers]
+	}
+
+	var errs []error
+	for _, peer := range peers {
+		client := &registry.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: rpc.NewAuthenticatedNodeTLSConfig(creds, rpc.WantRemoteCluster(creds.ClusterCA()), rpc.WantRemoteNode(peer.ID)),
+			},
+			Scheme:     "https",
+			Host:       net.JoinHostPort(peer.Host, node.OSImageCachePort.PortString()),
+			Repository: imageRef.Repository,
+		}
+		// Check that the peer has the image before committing to it.
+		probeCtx, cancel := context.WithTimeout(ctx, imageCacheProbeTimeout)
+		_, err := client.Read(probeCtx, imageRef.Tag, imageRef.Digest)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer.ID, err))
+			continue
+		}
+		s.Logger.Infof("Fetching OS image from peer %s", peer.ID)
+		s.configureClient(client)
+		if err := s.installImage(ctx, client, imageRef, withKexec); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer.ID, err))
+			continue
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// imageCacheWriter stores an OS image in the image cache while it is being
+// installed. The image is only made available to other nodes once it has been
+// fully installed, see commit.
+type imageCacheWriter struct {
+	s     *Service
+	dir   string
+	ref   *apb.OSImageRef
+	image *oci.Image
+	// signature is the signature image of the image, if it was verified. It is
+	// served along with the image, so that other nodes can verify it without
+	// access to the upstream registry.
+	signature *oci.Image
+}
+
+// newImageCacheWriter prepares storing the given image in the image cache. It
+// returns an image which must be used instead of the given image for the
+// installation, and which writes the blobs read from it into the cache. If the
+// image cache is not configured or not usable, nil and the given image are
+// returned.
+func (s *Service) newImageCacheWriter(imageRef *apb.OSImageRef, image *oci.Image) (*imageCacheWriter, *oci.Image) {
+	if s.ImageCachePath == "" {
+		return nil, image
+	}
+	dir := filepath.Join(s.ImageCachePath, imageCacheStaging)
+	if err := os.RemoveAll(dir); err != nil {
+		s.Logger.Warningf("Image cache unavailable: %v", err)
+		return nil, image
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0700); err != nil {
+		s.Logger.Warningf("Image cache unavailable: %v", err)
+		return nil, image
+	}
+	cachingImage, err := oci.NewImage(image.RawManifest, image.ManifestDigest, &cachingBlobs{
+		image: image,
+		dir:   dir,
+	})
+	if err != nil {
+		s.Logger.Warningf("Image cache unavailable: %v", err)
+		return nil, image
+	}
+	w := &imageCacheWriter{
+		s:     s,
+		dir:   dir,
+		ref:   imageRef,
+		image: cachingImage,
+	}
+	return w, cachingImage
+}
+
+// commit completes the cached image and replaces the previously cached image
+// with it. Blobs of uncompressed system images which were not fetched in full
+// (see installSystemDelta) are copied from the slot they were installed to.
+func (w *imageCacheWriter) commit(osImage *osimage.Image, slot Slot) error {
+	for pi, info := range osImage.Config.Payloads {
+		layer := &w.image.Manifest.Layers[pi]
+		if _, err := os.Stat(cacheBlobPath(w.dir, layer)); err == nil {
+			continue
+		}
+		if info.Name != "system" || layer.MediaType != osimage.MediaTypePayloadUncompressed {
+			return fmt.Errorf("payload %q was not fetched", info.Name)
+		}
+		part, err := openSystemSlot(slot, blockdev.WithReadonly)
+		if err != nil {
+			return err
+		}
+		err = writeCacheBlob(w.dir, layer, io.NewSectionReader(part, 0, layer.Size))
+		part.Close()
+		if err != nil {
+			return fmt.Errorf("while copying system image: %w", err)
+		}
+	}
+
+	for descriptor := range w.image.Descriptors() {
+		if int64(len(descriptor.Data)) == descriptor.Size {
+			// Embedded data is written as part of the layout below.
+			continue
+		}
+		if _, err := os.Stat(cacheBlobPath(w.dir, descriptor)); err != nil {
+			return fmt.Errorf("blob %s was not fetched", descriptor.Digest)
+		}
+	}
+
+	// All blobs are present, write the remaining files of the OCI layout.
+	if err := writeLayout(w.dir, w.image); err != nil {
+		return err
+	}
+	if w.signature != nil {
+		if err := writeLayout(filepath.Join(w.dir, imageCacheSignature), w.signature); err != nil {
+			return fmt.Errorf("while writing signature: %w", err)
+		}
+	}
+	refRaw, err := proto.Marshal(w.ref)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, imageCacheRefFile), refRaw, 0600); err != nil {
+		return err
+	}
+
+	current := filepath.Join(w.s.ImageCachePath, imageCacheCurrent)
+	if err := os.RemoveAll(current); err != nil {
+		return err
+	}
+	if err := os.Rename(w.dir, current); err != nil {
+		return err
+	}
+	w.s.cacheServer.Store(nil)
+	w.s.Logger.Infof("Stored OS image %s in image cache", w.image.ManifestDigest)
+	return nil
+}
+
+// abort removes the partially cached image.
+func (w *imageCacheWriter) abort() {
+	os.RemoveAll(w.dir)
+}
+
+// writeLayout writes the OCI layout of the given image into dir. Files which
+// already exist, like blobs written while installing the image, are kept.
+func writeLayout(dir string, image *oci.Image) error {
+	layout, err := oci.CreateLayout(image)
+	if err != nil {
+		return err
+	}
+	for path, n := range layout.Walk() {
+		fullPath := filepath.Join(dir, path)
+		if n.Mode.IsDir() {
+			if err := os.MkdirAll(fullPath, 0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Stat(fullPath); err == nil {
+			continue
+		}
+		content, err := n.Content.Open()
+		if err != nil {
+			return err
+		}
+		raw, err := io.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(fullPath, raw, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheBlobPath(dir string, descriptor *ocispecv1.Descriptor) string {
+	_, encoded, _ := oci.ParseDigest(string(descriptor.Digest))
+	return filepath.Join(dir, "blobs", "sha256", encoded)
+}
+
+// writeCacheBlob writes a blob into the image cache directory, after verifying
+// it against its digest.
+func writeCacheBlob(dir string, descriptor *ocispecv1.Descriptor, r io.Reader) error {
+	path := cacheBlobPath(dir, descriptor)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(f, h), r, descriptor.Size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && fmt.Sprintf("sha256:%x", h.Sum(nil)) != string(descriptor.Digest) {
+		err = fmt.Errorf("blob failed verification against digest %s", descriptor.Digest)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// cachingBlobs writes every blob which is read in full into a directory.
+type cachingBlobs struct {
+	image *oci.Image
+	dir   string
+}
+
+func (b *cachingBlobs) Blob(descriptor *ocispecv1.Descriptor) (io.ReadCloser, error) {
+	if _, _, err := oci.ParseDigest(string(descriptor.Digest)); err != nil {
+		return nil, err
+	}
+	blob, err := b.image.Blob(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	path := cacheBlobPath(b.dir, descriptor)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		// Caching is best-effort.
+		return blob, nil
+	}
+	return &cachingReader{
+		blob:       blob,
+		file:       f,
+		hash:       sha256.New(),
+		path:       path,
+		descriptor: descriptor,
+	}, nil
+}
+
+func (b *cachingBlobs) BlobRange(descriptor *ocispecv1.Descriptor, offset, length int64) (io.ReadCloser, error) {
+	return b.image.BlobRange(descriptor, offset, length)
+}
+
+type cachingReader struct {
+	blob       io.ReadCloser
+	file       *os.File
+	hash       hash.Hash
+	written    int64
+	failed     bool
+	path       string
+	descriptor *ocispecv1.Descriptor
+}
+
+func (r *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = r.blob.Read(p)
+	if n > 0 && !r.failed {
+		r.hash.Write(p[:n])
+		if _, err := r.file.Write(p[:n]); err != nil {
+			r.failed = true
+		}
+		r.written += int64(n)
+	}
+	return
+}
+
+func (r *cachingReader) Close() error {
+	err := r.blob.Close()
+	closeErr := r.file.Close()
+	complete := !r.failed && closeErr == nil && r.written == r.descriptor.Size &&
+		fmt.Sprintf("sha256:%x", r.hash.Sum(nil)) == string(r.descriptor.Digest)
+	if !complete || os.Rename(r.path+".tmp", r.path) != nil {
+		os.Remove(r.path + ".tmp")
+	}
+	return err
+}
+
+// ServeImageCache serves the OS image in the image cache to other nodes of the
+// cluster on node.OSImageCachePort, using the OCI distribution API. Only nodes
+// of the cluster are allowed to connect.
+func (s *Service) ServeImageCache(ctx context.Context, creds *identity.NodeCredentials) error {
+	pool := x509.NewCertPool()
+	pool.AddCert(creds.ClusterCA())
+	tlsc := tls.Config{
+		GetCertificate: creds.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      pool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no client certificate")
+			}
+			_, err := identity.VerifyNodeInCluster(cs.PeerCertificates[0], creds.ClusterCA())
+			return err
+		},
+	}
+	lis, err := tls.Listen("tcp", net.JoinHostPort("", node.OSImageCachePort.PortString()), &tlsc)
+	if err != nil {
+		return fmt.Errorf("listen failed: %w", err)
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	srv := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			cs, err := s.imageCacheServer()
+			if err != nil {
+				supervisor.Logger(ctx).Warningf("Image cache unavailable: %v", err)
+			}
+			if cs == nil {
+				http.NotFound(w, req)
+				return
+			}
+			cs.ServeHTTP(w, req)
+		}),
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err = srv.Serve(lis)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("Serve(): %w", err)
+}
+
+// imageCacheServer returns a registry server for the currently cached image,
+// or nil if no image is cached.
+func (s *Service) imageCacheServer() (*registry.Server, error) {
+	if cs := s.cacheServer.Load(); cs != nil {
+		return cs, nil
+	}
+	if s.ImageCachePath == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(s.ImageCachePath, imageCacheCurrent)
+	refRaw, err := os.ReadFile(filepath.Join(dir, imageCacheRefFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ref apb.OSImageRef
+	if err := proto.Unmarshal(refRaw, &ref); err != nil {
+		return nil, err
+	}
+	image, err := oci.ReadLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	cs := registry.NewServer()
+	if err := cs.AddImage(ref.Repository, ref.Tag, image); err != nil {
+		return nil, err
+	}
+	sigDir := filepath.Join(dir, imageCacheSignature)
+	if _, err := os.Stat(sigDir); err == nil {
+		sig, err := oci.ReadLayout(sigDir)
+		if err != nil {
+			return nil, fmt.Errorf("while reading signature: %w", err)
+		}
+		sigTag, err := signature.Tag(ref.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := cs.AddImage(ref.Repository, sigTag, sig); err != nil {
+			return nil, err
+		}
+	}
+	s.cacheServer.CompareAndSwap(nil, cs)
+	return cs, nil
+}
@@ -0,0 +1,85 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package update
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"source.monogon.dev/osbase/oci"
+	"source.monogon.dev/osbase/oci/registry"
+	"source.monogon.dev/osbase/oci/signature"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+// ErrImageNotTrusted is returned by InstallImage if the OS image is not signed
+// by any of the trusted signing keys.
+var ErrImageNotTrusted = errors.New("OS image is not signed by a trusted key")
+
+// ProvideSigningKeys sets the DER-encoded public keys trusted to sign OS
+// images, as configured in the cluster. If keys is empty, OS images are not
+// required to be signed. Until this is called, InstallImage refuses to install
+// any image.
+func (s *Service) ProvideSigningKeys(keys [][]byte) error {
+	parsed := make([]crypto.PublicKey, 0, len(keys))
+	for i, key := range keys {
+		k, err := signature.ParsePublicKey(key)
+		if err != nil {
+			return fmt.Errorf("invalid signing key %d: %w", i, err)
+		}
+		parsed = append(parsed, k)
+	}
+	s.signingKeysMu.Lock()
+	defer s.signingKeysMu.Unlock()
+	s.signingKeys = parsed
+	s.signingKeysKnown = true
+	return nil
+}
+
+// verifyImageSignature checks that the image referenced by imageRef is signed by
+// one of the trusted signing keys, and returns the signature image, or nil if
+// images are not required to be signed. The signature is fetched with the given
+// client, ie. from the same source as the image itself, which might be the
+// image cache of another node. As the signature covers the manifest digest, the
+// source doesn't need to be trusted.
+func (s *Service) verifyImageSignature(ctx context.Context, client *registry.Client, imageRef *apb.OSImageRef) (*oci.Image, error) {
+	s.signingKeysMu.Lock()
+	keys, known := s.signingKeys, s.signingKeysKnown
+	s.signingKeysMu.Unlock()
+	if !known {
+		return nil, errors.New("trusted OS image signing keys not yet known")
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	tag, err := signature.Tag(imageRef.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	sig, err := client.Read(ctx, tag, "")
+	if err != nil {
+		var clientErr *registry.ClientError
+		if errors.As(err, &clientErr) && clientErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: no signature found for %s", ErrImageNotTrusted, imageRef.Digest)
+		}
+		return nil, fmt.Errorf("failed to fetch OS image signature: %w", err)
+	}
+	if err := signature.Verify(sig, imageRef.Digest, keys); err != nil {
+		if errors.Is(err, signature.ErrNoValidSignature) {
+			return nil, fmt.Errorf("%w: %w", ErrImageNotTrusted, err)
+		}
+		return nil, fmt.Errorf("failed to verify OS image signature: %w", err)
+	}
+	s.Logger.Infof("OS image %s has a valid signature", imageRef.Digest)
+	return sig, nil
+}
@@ -6,6 +6,7 @@ package update
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/sha256"
 	"debug/pe"
 	_ "embed"
@@ -17,6 +18,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -27,6 +30,7 @@ import (
 
 	"source.monogon.dev/go/logging"
 	"source.monogon.dev/metropolis/installer/install"
+	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/productinfo"
 	"source.monogon.dev/osbase/blockdev"
 	"source.monogon.dev/osbase/efivarfs"
@@ -36,6 +40,7 @@ import (
 	"source.monogon.dev/osbase/oci/registry"
 
 	abloaderpb "source.monogon.dev/metropolis/node/abloader/spec"
+	upb "source.monogon.dev/metropolis/node/core/update/proto"
 	apb "source.monogon.dev/metropolis/proto/api"
 )
 
@@ -49,8 +54,28 @@ type Service struct {
 	// Partition number (1-based) of the ESP in the GPT partitions array.
 	ESPPartNumber uint32
 
+	// ImageCachePath is the path of a directory on persistent storage in which
+	// the last installed OS image is kept, to be served to other nodes. If empty,
+	// installed images are not cached.
+	ImageCachePath string
+
 	// Logger service for the update service.
 	Logger logging.Leveled
+
+	peersMu    sync.Mutex
+	peersCreds *identity.NodeCredentials
+	peers      []ImagePeer
+	// cacheServer serves the currently cached image. It is created lazily and
+	// reset whenever the cached image changes.
+	cacheServer atomic.Pointer[registry.Server]
+
+	signingKeysMu    sync.Mutex
+	signingKeys      []crypto.PublicKey
+	signingKeysKnown bool
+
+	// staged is set once an update has been installed into the inactive slot
+	// and set to be activated.
+	staged atomic.Bool
 }
 
 type Slot int
@@ -96,6 +121,12 @@ func (s Slot) EFIBootPath() string {
 	}
 }
 
+// installedImagePath returns the path of the InstalledImage record of the
+// given slot, relative to the ESP.
+func (s Slot) installedImagePath() string {
+	return fmt.Sprintf("EFI/metropolis/installed_image_%s.pb", s)
+}
+
 var slotRegexp = regexp.MustCompile(`PARTLABEL=METROPOLIS-SYSTEM-([AB])`)
 
 // ProvideESP is a convenience function for providing information about the
@@ -133,6 +164,9 @@ var bootVarRegexp = regexp.MustCompile(`^Boot([0-9A-Fa-f]{4})$`)
 // installed and booted and this function is called, the updated version is
 // marked as default. If an issue occurs during boot and so this function is
 // not called the old version will be started again on next boot.
+//
+// If the update has an armed health gate (see HealthGate), this function must
+// only be called once the node passed the health gate.
 func (s *Service) MarkBootSuccessful() error {
 	if s.ESPPath == "" {
 		return errors.New("no ESP information provided to update service, cannot continue")
@@ -163,8 +197,10 @@ func (s *Service) MarkBootSuccessful() error {
 			return fmt.Errorf("while setting next A/B slot: %w", err)
 		}
 		s.Logger.Infof("Permanently activated slot %v", activeSlot)
+		s.finishHealthGate(true)
 	} else {
 		s.Logger.Infof("Normal boot from slot %v", activeSlot)
+		s.finishHealthGate(false)
 	}
 
 	return nil
@@ -225,12 +261,12 @@ func (s *Service) KexecLoadNext() error {
 	return nil
 }
 
-func openSystemSlot(slot Slot) (*blockdev.Device, error) {
+func openSystemSlot(slot Slot, opts ...blockdev.Option) (*blockdev.Device, error) {
 	switch slot {
 	case SlotA:
-		return blockdev.Open("/dev/system-a")
+		return blockdev.Open("/dev/system-a", opts...)
 	case SlotB:
-		return blockdev.Open("/dev/system-b")
+		return blockdev.Open("/dev/system-b", opts...)
 	default:
 		return nil, errors.New("invalid slot identifier given")
 	}
@@ -262,6 +298,22 @@ func (s *Service) setABState(d *abloaderpb.ABLoaderData) error {
 // InstallImage fetches the given image, installs it into the currently inactive
 // slot and sets that slot to boot next. If it doesn't return an error, a reboot
 // boots into the new slot.
+//
+// If the system image is uncompressed, only the chunks of it which are not
+// already present in one of the slots are downloaded. This makes small updates
+// cheap, and allows a failed installation to be resumed by calling InstallImage
+// again.
+//
+// The image is fetched from the image cache of other nodes of the cluster if
+// possible (see ProvideImagePeers), and from the registry in imageRef
+// otherwise. Once installed, it is kept in the local image cache to be served
+// to other nodes.
+//
+// If the cluster requires OS images to be signed (see ProvideSigningKeys), the
+// signature is fetched from the same source as the image and verified before
+// anything is written, and an error wrapping ErrImageNotTrusted is returned if
+// the image is not signed by a trusted key. The signature is kept in the image
+// cache along with the image.
 func (s *Service) InstallImage(ctx context.Context, imageRef *apb.OSImageRef, withKexec bool) error {
 	if imageRef == nil {
 		return fmt.Errorf("missing OS image in OS installation request")
@@ -273,26 +325,61 @@ func (s *Service) InstallImage(ctx context.Context, imageRef *apb.OSImageRef, wi
 		return errors.New("no ESP information provided to update service, cannot continue")
 	}
 
-	downloadCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
-	defer cancel()
+	err := s.installFromPeers(ctx, imageRef, withKexec)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !errors.Is(err, errNoImagePeers) {
+		s.Logger.Infof("Could not fetch OS image from peers, falling back to upstream registry: %v", err)
+	}
 
 	client := &registry.Client{
-		GetBackOff: func() backoff.BackOff {
-			return backoff.NewExponentialBackOff()
-		},
-		RetryNotify: func(err error, d time.Duration) {
-			s.Logger.Warningf("Error while fetching OS image, retrying in %v: %v", d, err)
-		},
-		UserAgent:  "MonogonOS/" + productinfo.Get().VersionString,
 		Scheme:     imageRef.Scheme,
 		Host:       imageRef.Host,
 		Repository: imageRef.Repository,
 	}
+	s.configureClient(client)
+	return s.installImage(ctx, client, imageRef, withKexec)
+}
+
+// configureClient sets the options common to all registry clients used to
+// fetch OS images.
+func (s *Service) configureClient(client *registry.Client) {
+	client.GetBackOff = func() backoff.BackOff {
+		return backoff.NewExponentialBackOff()
+	}
+	client.RetryNotify = func(err error, d time.Duration) {
+		s.Logger.Warningf("Error while fetching OS image, retrying in %v: %v", d, err)
+	}
+	client.UserAgent = "MonogonOS/" + productinfo.Get().VersionString
+}
+
+// installImage implements InstallImage, fetching the image with the given
+// client.
+func (s *Service) installImage(ctx context.Context, client *registry.Client, imageRef *apb.OSImageRef, withKexec bool) error {
+	// Anything staged before is overwritten.
+	s.staged.Store(false)
+
+	sig, err := s.verifyImageSignature(ctx, client, imageRef)
+	if err != nil {
+		return err
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
 
 	image, err := client.Read(downloadCtx, imageRef.Tag, imageRef.Digest)
 	if err != nil {
 		return fmt.Errorf("failed to fetch OS image: %w", err)
 	}
+	cache, image := s.newImageCacheWriter(imageRef, image)
+	if cache != nil {
+		cache.signature = sig
+		defer cache.abort()
+	}
 
 	osImage, err := osimage.Read(image)
 	if err != nil {
@@ -314,23 +401,46 @@ func (s *Service) InstallImage(ctx context.Context, imageRef *apb.OSImageRef, wi
 	}
 	targetSlot := activeSlot.Other()
 
+	// The target slot doesn't contain a known image until the installation has
+	// finished.
+	if err := os.Remove(filepath.Join(s.ESPPath, targetSlot.installedImagePath())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove installed image record: %w", err)
+	}
+
 	systemPart, err := openSystemSlot(targetSlot)
 	if err != nil {
 		return status.Errorf(codes.Internal, "Inactive system slot unavailable: %v", err)
 	}
-	systemImageContent, err := systemImage.Open()
-	if err != nil {
-		systemPart.Close()
-		return fmt.Errorf("failed to open system image: %w", err)
-	}
-	_, err = io.Copy(blockdev.NewRWS(systemPart), systemImageContent)
-	systemImageContent.Close()
-	closeErr := systemPart.Close()
-	if err == nil {
-		err = closeErr
-	}
-	if err != nil {
-		return status.Errorf(codes.Unavailable, "Failed to copy system image: %v", err)
+	if chunkedImage, err := osImage.PayloadChunked("system"); err == nil {
+		var runningPart *blockdev.Device
+		runningPart, err = openSystemSlot(activeSlot, blockdev.WithReadonly)
+		if err == nil {
+			err = s.installSystemDelta(chunkedImage, runningPart, systemPart)
+			runningPart.Close()
+		}
+		closeErr := systemPart.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "Failed to install system image: %v", err)
+		}
+	} else {
+		// Compressed images can only be downloaded in full.
+		systemImageContent, err := systemImage.Open()
+		if err != nil {
+			systemPart.Close()
+			return fmt.Errorf("failed to open system image: %w", err)
+		}
+		_, err = io.Copy(blockdev.NewRWS(systemPart), systemImageContent)
+		systemImageContent.Close()
+		closeErr := systemPart.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "Failed to copy system image: %v", err)
+		}
 	}
 
 	bootFile, err := os.Create(filepath.Join(s.ESPPath, targetSlot.EFIBootPath()))
@@ -348,6 +458,16 @@ func (s *Service) InstallImage(ctx context.Context, imageRef *apb.OSImageRef, wi
 		return fmt.Errorf("failed to write boot file: %w", err)
 	}
 
+	if err := s.writeESPProto(targetSlot.installedImagePath(), &upb.InstalledImage{Digest: imageRef.Digest}); err != nil {
+		return fmt.Errorf("failed to write installed image record: %w", err)
+	}
+
+	if cache != nil {
+		if err := cache.commit(osImage, targetSlot); err != nil {
+			s.Logger.Warningf("Failed to store OS image in image cache: %v", err)
+		}
+	}
+
 	if withKexec {
 		if err := s.stageKexec(bootFile, targetSlot); err != nil {
 			return fmt.Errorf("while kexec staging: %w", err)
@@ -362,9 +482,31 @@ func (s *Service) InstallImage(ctx context.Context, imageRef *apb.OSImageRef, wi
 		}
 	}
 
+	s.staged.Store(true)
 	return nil
 }
 
+// RunningImageDigest returns the digest of the OS image in the currently
+// running slot, or an empty string if that slot was not installed by
+// InstallImage.
+func (s *Service) RunningImageDigest() (string, error) {
+	if s.ESPPath == "" {
+		return "", errors.New("no ESP information provided to update service, cannot continue")
+	}
+	slot := s.CurrentlyRunningSlot()
+	if slot == SlotInvalid {
+		return "", errors.New("unable to determine active slot, cannot continue")
+	}
+	var img upb.InstalledImage
+	if err := s.readESPProto(slot.installedImagePath(), &img); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("while reading installed image record: %w", err)
+	}
+	return img.Digest, nil
+}
+
 // newMemfile creates a new file which is not located on a specific filesystem,
 // but is instead backed by anonymous memory.
 func newMemfile(name string, flags int) (*os.File, error) {
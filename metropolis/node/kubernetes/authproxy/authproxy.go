@@ -31,6 +31,8 @@ type Service struct {
 	KPKI *pki.PKI
 	// Node contains the node credentials
 	Node *identity.NodeCredentials
+	// Revocations are used to reject revoked Metropolis certificates.
+	Revocations *identity.Revocations
 }
 
 func (s *Service) getTLSCert(ctx context.Context, name pki.KubeCertificateName) (*tls.Certificate, error) {
@@ -115,17 +117,16 @@ func (s *Service) Run(ctx context.Context) error {
 	standardProxy.ErrorHandler = errorHandler
 	noHTTP2Proxy.ErrorHandler = errorHandler
 
-	serverCert := s.Node.TLSCredentials()
 	clientCAs := x509.NewCertPool()
 	clientCAs.AddCert(s.Node.ClusterCA())
 	server := &http.Server{
 		Addr: ":" + node.KubernetesAPIWrappedPort.PortString(),
 		TLSConfig: &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			NextProtos:   []string{"h2", "http/1.1"},
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-			ClientCAs:    clientCAs,
-			Certificates: []tls.Certificate{serverCert},
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			ClientCAs:      clientCAs,
+			GetCertificate: s.Node.GetCertificate,
 		},
 		// Limits match @io_k8s_apiserver/pkg/server:secure_serving.go Serve()
 		MaxHeaderBytes:    1 << 20,
@@ -136,6 +137,9 @@ func (s *Service) Run(ctx context.Context) error {
 			// Guaranteed to exist because of RequireAndVerifyClientCert
 			clientCert := req.TLS.VerifiedChains[0][0]
 			clientIdentity, err := identity.VerifyUserInCluster(clientCert, s.Node.ClusterCA())
+			if err == nil && s.Revocations.Revoked(clientCert) {
+				err = fmt.Errorf("certificate has been revoked")
+			}
 			if err != nil {
 				respondWithK8sStatus(rw, &metav1.Status{
 					Status:  metav1.StatusFailure,
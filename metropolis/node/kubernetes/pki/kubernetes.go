@@ -21,6 +21,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"k8s.io/client-go/tools/clientcmd"
@@ -72,6 +73,11 @@ const (
 )
 
 const (
+	// workerCertificateLifetime is the validity period of the certificates
+	// issued to Kubernetes worker nodes. Workers request new certificates once
+	// two thirds of it have passed.
+	workerCertificateLifetime = 90 * 24 * time.Hour
+
 	// etcdPrefix is where all the PKI data is stored in etcd.
 	etcdPrefix = "/kube-pki/"
 	// serviceAccountKeyName is the etcd path part that is used to store the
@@ -345,6 +351,7 @@ func (k *PKI) Kubelet(ctx context.Context, name string, pubkey ed25519.PublicKey
 		Template:  opki.Server([]string{name}, nil),
 		Mode:      opki.CertificateExternal,
 		PublicKey: pubkey,
+		Lifetime:  workerCertificateLifetime,
 	}
 	clientName := fmt.Sprintf("kubelet-%s-client", name)
 	client = &opki.Certificate{
@@ -354,6 +361,7 @@ func (k *PKI) Kubelet(ctx context.Context, name string, pubkey ed25519.PublicKey
 		Template:  opki.Client(name, []string{"system:nodes"}),
 		Mode:      opki.CertificateExternal,
 		PublicKey: pubkey,
+		Lifetime:  workerCertificateLifetime,
 	}
 	return server, client, nil
 }
@@ -375,6 +383,7 @@ func (k *PKI) CSIProvisioner(ctx context.Context, name string, pubkey ed25519.Pu
 		Template:  opki.Client(name, []string{"metropolis:csi-provisioner"}),
 		Mode:      opki.CertificateExternal,
 		PublicKey: pubkey,
+		Lifetime:  workerCertificateLifetime,
 	}
 	return client, nil
 }
@@ -396,6 +405,7 @@ func (k *PKI) NetServices(ctx context.Context, name string, pubkey ed25519.Publi
 		Template:  opki.Client(name, []string{"metropolis:netservices"}),
 		Mode:      opki.CertificateExternal,
 		PublicKey: pubkey,
+		Lifetime:  workerCertificateLifetime,
 	}
 	return client, nil
 }
@@ -38,6 +38,8 @@ type ConfigController struct {
 	Node       *identity.NodeCredentials
 	Curator    ipb.CuratorClient
 	Management apb.ManagementClient
+	// Revocations are used by the authproxy to reject revoked certificates.
+	Revocations *identity.Revocations
 }
 
 type Controller struct {
@@ -169,8 +171,9 @@ func (s *Controller) Run(ctx context.Context) error {
 	supervisor.Logger(ctx).Info("Reconciler is done.")
 
 	authProxy := authproxy.Service{
-		KPKI: s.c.KPKI,
-		Node: s.c.Node,
+		KPKI:        s.c.KPKI,
+		Node:        s.c.Node,
+		Revocations: s.c.Revocations,
 	}
 
 	metricsProxy := metricsproxy.Service{
@@ -6,11 +6,13 @@ package kubernetes
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/netip"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -18,6 +20,7 @@ import (
 	"source.monogon.dev/go/net/tinylb"
 	"source.monogon.dev/metropolis/node"
 	oclusternet "source.monogon.dev/metropolis/node/core/clusternet"
+	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/metrics"
 	"source.monogon.dev/metropolis/node/core/network"
@@ -159,6 +162,17 @@ func (s *Worker) Run(ctx context.Context) error {
 	}
 	kw := res.Kind.(*ipb.IssueCertificateResponse_KubernetesWorker_).KubernetesWorker
 
+	// Certificates are only issued when starting, so the worker needs to restart
+	// once any of them needs renewal.
+	certs, err := parseWorkerCertificates(kw)
+	if err != nil {
+		return err
+	}
+	for name, cert := range certs {
+		metrics.CertificateExpiry.WithLabelValues(name).Set(float64(cert.NotAfter.Unix()))
+	}
+	renewAt, notAfter := certificatesExpiry(certs)
+
 	// ...write them...
 	if err := kubelet.setCertificates(kw); err != nil {
 		return fmt.Errorf("failed to write kubelet certs: %w", err)
@@ -266,8 +280,80 @@ func (s *Worker) Run(ctx context.Context) error {
 	}
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
-	<-ctx.Done()
-	return nil
+	supervisor.Logger(ctx).Infof("Certificates will be renewed after %s.", renewAt)
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(time.Until(renewAt)):
+	}
+
+	// The curator decides whether certificates need renewal by its own clock,
+	// which might lag behind ours, in which case it returns the current
+	// certificates again. Only restart once it issued renewed certificates, which
+	// it will then also return after the restart.
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = time.Hour
+	bo.MaxElapsedTime = 0
+	err = backoff.Retry(func() error {
+		res, err := s.c.CuratorClient.IssueCertificate(ctx, &ipb.IssueCertificateRequest{
+			Kind: &ipb.IssueCertificateRequest_KubernetesWorker_{
+				KubernetesWorker: kwr,
+			},
+		})
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("Could not renew certificates: %v", err)
+			return err
+		}
+		renewed, err := parseWorkerCertificates(res.GetKubernetesWorker())
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if _, renewedNotAfter := certificatesExpiry(renewed); !renewedNotAfter.After(notAfter) {
+			supervisor.Logger(ctx).Infof("Curator did not renew certificates yet, retrying.")
+			return fmt.Errorf("certificates not renewed yet")
+		}
+		return nil
+	}, backoff.WithContext(bo, ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("certificates renewed, restarting")
+}
+
+// parseWorkerCertificates parses the certificates issued to a Kubernetes
+// worker, keyed by name.
+func parseWorkerCertificates(kw *ipb.IssueCertificateResponse_KubernetesWorker) (map[string]*x509.Certificate, error) {
+	certs := make(map[string]*x509.Certificate)
+	for name, certBytes := range map[string][]byte{
+		"kubelet-server":  kw.GetKubeletServerCertificate(),
+		"kubelet-client":  kw.GetKubeletClientCertificate(),
+		"csi-provisioner": kw.GetCsiProvisionerCertificate(),
+		"netservices":     kw.GetNetservicesCertificate(),
+	} {
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s certificate: %w", name, err)
+		}
+		certs[name] = cert
+	}
+	return certs, nil
+}
+
+// certificatesExpiry returns the earliest renewal time and the earliest expiry
+// of the given certificates.
+func certificatesExpiry(certs map[string]*x509.Certificate) (renewAt, notAfter time.Time) {
+	for _, cert := range certs {
+		if t := identity.RenewalTime(cert); renewAt.IsZero() || t.Before(renewAt) {
+			renewAt = t
+		}
+		if notAfter.IsZero() || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return renewAt, notAfter
 }
 
 func connectByKubeconfig(kubeconfig []byte) (*kubernetes.Clientset, informers.SharedInformerFactory, error) {
@@ -53,6 +53,14 @@ const (
 	// MetricsContainerdListenerPort is the TCP port on which the
 	// containerd metrics endpoint, bound to 127.0.0.1, is exposed.
 	MetricsContainerdListenerPort Port = 7846
+	// OSImageCachePort is the TCP port on which the update service serves the
+	// OS image it last installed to other nodes, secured using TLS and the
+	// Cluster/Node certificates.
+	OSImageCachePort Port = 7847
+	// RestoreUnlockPort is the TCP port on which a node restoring a cluster
+	// from a snapshot waits for the key of the snapshot to be submitted via
+	// RestoreUnlock.
+	RestoreUnlockPort Port = 7848
 	// KubernetesAPIPort is the TCP port on which the Kubernetes API is
 	// exposed.
 	KubernetesAPIPort Port = 6443
@@ -66,6 +74,9 @@ const (
 	// DebuggerPort is the port on which the delve debugger runs (on debug
 	// builds only). Not to be confused with DebugServicePort.
 	DebuggerPort Port = 2345
+	// NTPPort is the UDP port on which consensus members serve time to the rest
+	// of the cluster, if enabled in the cluster configuration.
+	NTPPort Port = 123
 )
 
 var SystemPorts = []Port{
@@ -81,10 +92,13 @@ var SystemPorts = []Port{
 	MetricsKubeControllerManagerListenerPort,
 	MetricsKubeAPIServerListenerPort,
 	MetricsContainerdListenerPort,
+	OSImageCachePort,
+	RestoreUnlockPort,
 	KubernetesAPIPort,
 	KubernetesAPIWrappedPort,
 	KubernetesWorkerLocalAPIPort,
 	DebuggerPort,
+	NTPPort,
 }
 
 func (p Port) String() string {
@@ -113,6 +127,10 @@ func (p Port) String() string {
 		return "metrics-kubernetes-api-server"
 	case MetricsContainerdListenerPort:
 		return "metrics-containerd"
+	case OSImageCachePort:
+		return "os-image-cache"
+	case RestoreUnlockPort:
+		return "restore-unlock"
 	case KubernetesAPIPort:
 		return "kubernetes-api"
 	case KubernetesAPIWrappedPort:
@@ -121,6 +139,8 @@ func (p Port) String() string {
 		return "kubernetes-worker-local-api"
 	case DebuggerPort:
 		return "delve"
+	case NTPPort:
+		return "ntp"
 	}
 	return "unknown"
 }
@@ -6,6 +6,7 @@ package node
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"regexp"
 )
 
@@ -62,3 +63,13 @@ func ValidateClusterDomain(d string) error {
 	}
 	return validateDomainName(d)
 }
+
+// ValidateNTPServer returns an error if the passed string is neither an IP
+// address nor a valid domain name, and can thus not be used as the address of
+// an NTP server.
+func ValidateNTPServer(s string) error {
+	if _, err := netip.ParseAddr(s); err == nil {
+		return nil
+	}
+	return validateDomainName(s)
+}
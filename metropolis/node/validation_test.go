@@ -50,3 +50,22 @@ func TestValidateDomainName(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateNTPServer(t *testing.T) {
+	for _, te := range []struct {
+		in   string
+		want error
+	}{
+		{"ntp.example.com", nil},
+		{"192.0.2.1", nil},
+		{"2001:db8::1", nil},
+		{"[2001:db8::1]", errDomainNameInvalid},
+		{"ntp.example.com:123", errDomainNameInvalid},
+		{"", errDomainNameInvalid},
+		{"1.1.1", errDomainNameEndsInNumber},
+	} {
+		if got := ValidateNTPServer(te.in); !errors.Is(got, te.want) {
+			t.Errorf("%q: wanted %v, got %v", te.in, te.want, got)
+		}
+	}
+}
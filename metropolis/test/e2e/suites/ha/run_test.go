@@ -6,15 +6,20 @@ package ha
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	mlaunch "source.monogon.dev/metropolis/test/launch"
 	"source.monogon.dev/metropolis/test/localregistry"
 	"source.monogon.dev/metropolis/test/util"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
 var (
@@ -50,7 +55,9 @@ const (
 
 // TestE2ECoreHA exercises the basics of a high-availability control plane by
 // starting up a 3-node cluster, turning all nodes into ConsensusMembers, then
-// performing a rolling restart.
+// performing a rolling restart. The cluster requires TPM attestation, which
+// is thus exercised against the emulated TPMs of the nodes when registering
+// and rejoining.
 func TestE2ECoreHA(t *testing.T) {
 	// Set a global timeout to make sure this terminates
 	ctx, cancel := context.WithTimeout(context.Background(), globalTestTimeout)
@@ -73,6 +80,14 @@ func TestE2ECoreHA(t *testing.T) {
 			// ESP, 2 system partitions, and data partition.
 			DiskBytes: (128 + 2*1024 + 512) * 1024 * 1024,
 		},
+		InitialClusterConfiguration: &cpb.ClusterConfiguration{
+			ClusterDomain:         "cluster.test",
+			TpmMode:               cpb.ClusterConfiguration_TPM_MODE_REQUIRED,
+			StorageSecurityPolicy: cpb.ClusterConfiguration_STORAGE_SECURITY_POLICY_NEEDS_ENCRYPTION_AND_AUTHENTICATION,
+			TpmAttestation: &cpb.ClusterConfiguration_TPMAttestation{
+				Required: true,
+			},
+		},
 	}
 	cluster, err := mlaunch.LaunchCluster(ctx, clusterOptions)
 	if err != nil {
@@ -97,6 +112,18 @@ func TestE2ECoreHA(t *testing.T) {
 	})
 	util.TestEventual(t, "Heartbeat test successful", ctx, 20*time.Second, cluster.AllNodesHealthy)
 
+	curC, err := cluster.CuratorClient()
+	if err != nil {
+		t.Fatalf("Could not get CuratorClient: %v", err)
+	}
+	mgmt := apb.NewManagementClient(curC)
+
+	// All nodes but the bootstrap node registered into the cluster, which
+	// requires them to pass attestation.
+	util.TestEventual(t, "Nodes attested on registration", ctx, smallTestTimeout, func(ctx context.Context) error {
+		return checkAttestations(ctx, mgmt, cluster.NodeIDs[1:], cpb.NodeAttestation_RESULT_PASSED, "RegisterNode")
+	})
+
 	// Perform rolling restart of all nodes. When a node rejoins it must be able to
 	// contact the cluster, so this also exercises that the cluster is serving even
 	// with the node having rebooted.
@@ -109,4 +136,67 @@ func TestE2ECoreHA(t *testing.T) {
 			return nil
 		})
 	}
+
+	// All nodes rejoined after the restart, which requires them to pass
+	// attestation, with the EK of the bootstrap node having been recorded
+	// during bootstrap.
+	util.TestEventual(t, "Nodes attested on join", ctx, smallTestTimeout, func(ctx context.Context) error {
+		return checkAttestations(ctx, mgmt, cluster.NodeIDs, cpb.NodeAttestation_RESULT_PASSED, "JoinNode")
+	})
+
+	// Only allow PCR values which no node can have, but don't require
+	// attestation. A node must then still be able to rejoin, with the failed
+	// attestation being recorded.
+	util.MustTestEventual(t, "Configure PCR policy", ctx, smallTestTimeout, func(ctx context.Context) error {
+		_, err := mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+			NewConfig: &cpb.ClusterConfiguration{
+				TpmAttestation: &cpb.ClusterConfiguration_TPMAttestation{
+					AllowedPcrValues: []*cpb.ClusterConfiguration_TPMAttestation_PCRValues{
+						{Pcrs: map[uint32][]byte{0: make([]byte, 32)}},
+					},
+				},
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"tpm_attestation"}},
+		})
+		return err
+	})
+	util.MustTestEventual(t, "Node rejoin with disallowed PCRs successful", ctx, 60*time.Second, func(ctx context.Context) error {
+		return cluster.RebootNode(ctx, 1)
+	})
+	util.TestEventual(t, "Node attestation failure recorded", ctx, smallTestTimeout, func(ctx context.Context) error {
+		return checkAttestations(ctx, mgmt, cluster.NodeIDs[1:2], cpb.NodeAttestation_RESULT_FAILED, "JoinNode")
+	})
+}
+
+// checkAttestations returns an error if the last attestation of any of the
+// given nodes did not have the given result and operation.
+func checkAttestations(ctx context.Context, mgmt apb.ManagementClient, ids []string, result cpb.NodeAttestation_Result, operation string) error {
+	srv, err := mgmt.GetNodes(ctx, &apb.GetNodesRequest{})
+	if err != nil {
+		return fmt.Errorf("GetNodes: %w", err)
+	}
+	attestations := make(map[string]*cpb.NodeAttestation)
+	for {
+		node, err := srv.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("GetNodes.Recv: %w", err)
+		}
+		attestations[node.Id] = node.Attestation
+	}
+	for _, id := range ids {
+		a := attestations[id]
+		if a == nil {
+			return fmt.Errorf("node %s: no attestation recorded", id)
+		}
+		if a.Result != result || a.Operation != operation {
+			return fmt.Errorf("node %s: wanted %s in %s, got %s in %s (%s)", id, result, operation, a.Result, a.Operation, a.Reason)
+		}
+		if a.Result == cpb.NodeAttestation_RESULT_PASSED && len(a.Pcrs) != 16 {
+			return fmt.Errorf("node %s: wanted 16 PCR values, got %d", id, len(a.Pcrs))
+		}
+	}
+	return nil
 }
@@ -9,7 +9,9 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"math/big"
 	"testing"
+	"time"
 
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/osbase/pki"
@@ -58,7 +60,8 @@ func NewEphemeralClusterCredentials(t *testing.T, nodes int) *EphemeralClusterCr
 			Certificate: [][]byte{managerBytes},
 			PrivateKey:  managerCert.PrivateKey,
 		},
-		CA: ca,
+		CA:     ca,
+		caCert: &caCert,
 	}
 
 	for i := 0; i < nodes; i++ {
@@ -100,4 +103,73 @@ type EphemeralClusterCredentials struct {
 	// CA is the x509 certificate of the CA certificate for the cluster. Manager and
 	// Node certificates are signed by this CA.
 	CA *x509.Certificate
+
+	caCert *pki.Certificate
+}
+
+// NewScopedUser creates a TLS certificate authenticating the bearer as a
+// Metropolis user with the given identity, which is only granted the given
+// permissions.
+func (e *EphemeralClusterCredentials) NewScopedUser(t *testing.T, identityName string, permissions []string) tls.Certificate {
+	t.Helper()
+	userCert := pki.Certificate{
+		Namespace: e.caCert.Namespace,
+		Issuer:    e.caCert,
+		Template:  identity.ScopedUserCertificate(identityName, permissions),
+		Mode:      pki.CertificateEphemeral,
+	}
+	userBytes, err := userCert.Ensure(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Could not ensure user certificate: %v", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{userBytes},
+		PrivateKey:  userCert.PrivateKey,
+	}
+}
+
+// NewExpiredNode creates credentials for a new node of the cluster, whose
+// certificate has already expired.
+func (e *EphemeralClusterCredentials) NewExpiredNode(t *testing.T) *identity.NodeCredentials {
+	t.Helper()
+	npk, npr, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate node keypair: %v", err)
+	}
+	template := identity.NodeCertificate(identity.NodeID(npk))
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-2 * time.Hour)
+	template.NotAfter = time.Now().Add(-time.Hour)
+	template.BasicConstraintsValid = true
+	nodeBytes, err := x509.CreateCertificate(rand.Reader, &template, e.CA, npk, e.caCert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Could not create node certificate: %v", err)
+	}
+	node, err := identity.NewNodeCredentials(npr, nodeBytes, e.CA.Raw)
+	if err != nil {
+		t.Fatalf("Could not build node credentials: %v", err)
+	}
+	return node
+}
+
+// NewCRL creates a DER-encoded certificate revocation list signed by the
+// cluster CA, which revokes the given certificates.
+func (e *EphemeralClusterCredentials) NewCRL(t *testing.T, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: pki.UnknownNotAfter,
+	}
+	for _, cert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, e.CA, e.caCert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Could not create CRL: %v", err)
+	}
+	return crl
 }
@@ -0,0 +1,410 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// This file implements combinators, which derive new Values from one or more
+// existing Values. They only implement ValueWatch, as the derived data is
+// always determined by the inputs and cannot be Set directly.
+//
+// All combinators work on any ValueWatch implementation, eg. memory.Value and
+// etcd.Value. They are however intended for non-ranged Values: a ranged
+// etcd.Value returns updates to different keys on subsequent Get calls, which
+// the combinators treat as subsequent versions of the same data.
+
+// Map returns a Value which contains the data of the given Value converted by
+// fn. fn is called on every Get and should be cheap and side-effect free.
+//
+// Predicates passed to Get are applied to the converted data. BacklogOnly is
+// passed through to the given Value.
+func Map[A, B any](v ValueWatch[A], fn func(A) B) ValueWatch[B] {
+	return &mapValue[A, B]{
+		v:  v,
+		fn: fn,
+	}
+}
+
+type mapValue[A, B any] struct {
+	v  ValueWatch[A]
+	fn func(A) B
+}
+
+func (m *mapValue[A, B]) Watch() Watcher[B] {
+	return &mapWatcher[A, B]{
+		w:  m.v.Watch(),
+		fn: m.fn,
+	}
+}
+
+type mapWatcher[A, B any] struct {
+	w  Watcher[A]
+	fn func(A) B
+}
+
+func (m *mapWatcher[A, B]) Get(ctx context.Context, opts ...GetOption[B]) (B, error) {
+	var predicate func(B) bool
+	var innerOpts []GetOption[A]
+	for _, opt := range opts {
+		if opt.Predicate != nil {
+			predicate = opt.Predicate
+		}
+		if opt.BacklogOnly {
+			innerOpts = append(innerOpts, BacklogOnly[A]())
+		}
+	}
+
+	for {
+		a, err := m.w.Get(ctx, innerOpts...)
+		if err != nil {
+			var empty B
+			return empty, err
+		}
+		b := m.fn(a)
+		if predicate != nil && !predicate(b) {
+			continue
+		}
+		return b, nil
+	}
+}
+
+func (m *mapWatcher[A, B]) Close() error {
+	return m.w.Close()
+}
+
+// DistinctUntilChanged returns a Value which contains the data of the given
+// Value, but whose watchers only return data which is different from the data
+// they last returned.
+func DistinctUntilChanged[T comparable](v ValueWatch[T]) ValueWatch[T] {
+	return DistinctUntilChangedFunc(v, func(a, b T) bool {
+		return a == b
+	})
+}
+
+// DistinctUntilChangedFunc is like DistinctUntilChanged, but uses the given
+// function to determine whether two versions of the data are equal.
+func DistinctUntilChangedFunc[T any](v ValueWatch[T], eq func(a, b T) bool) ValueWatch[T] {
+	return &distinctValue[T]{
+		v:  v,
+		eq: eq,
+	}
+}
+
+type distinctValue[T any] struct {
+	v  ValueWatch[T]
+	eq func(a, b T) bool
+}
+
+func (d *distinctValue[T]) Watch() Watcher[T] {
+	return &distinctWatcher[T]{
+		w:  d.v.Watch(),
+		eq: d.eq,
+	}
+}
+
+type distinctWatcher[T any] struct {
+	w  Watcher[T]
+	eq func(a, b T) bool
+	// last is the data last returned by Get, valid if lastSet is true.
+	last    T
+	lastSet bool
+}
+
+func (d *distinctWatcher[T]) Get(ctx context.Context, opts ...GetOption[T]) (T, error) {
+	var predicate func(T) bool
+	var innerOpts []GetOption[T]
+	for _, opt := range opts {
+		if opt.Predicate != nil {
+			predicate = opt.Predicate
+		}
+		if opt.BacklogOnly {
+			innerOpts = append(innerOpts, opt)
+		}
+	}
+
+	for {
+		v, err := d.w.Get(ctx, innerOpts...)
+		if err != nil {
+			return v, err
+		}
+		if d.lastSet && d.eq(d.last, v) {
+			continue
+		}
+		if predicate != nil && !predicate(v) {
+			continue
+		}
+		d.last = v
+		d.lastSet = true
+		return v, nil
+	}
+}
+
+func (d *distinctWatcher[T]) Close() error {
+	return d.w.Close()
+}
+
+// Debounce returns a Value which contains the data of the given Value, but
+// whose watchers only return data once it has not been updated for the given
+// duration. This is useful to not act on every intermediate update of a Value
+// which is changing rapidly, eg. while a system is settling after a cascading
+// state change.
+//
+// A Get call on a watcher of the returned Value does not return until the data
+// has been stable for at least the given duration, even if the data has never
+// been updated since the watcher was created. BacklogOnly is not supported.
+func Debounce[T any](v ValueWatch[T], d time.Duration) ValueWatch[T] {
+	return &debounceValue[T]{
+		v: v,
+		d: d,
+	}
+}
+
+type debounceValue[T any] struct {
+	v ValueWatch[T]
+	d time.Duration
+}
+
+func (d *debounceValue[T]) Watch() Watcher[T] {
+	return &debounceWatcher[T]{
+		w: d.v.Watch(),
+		d: d.d,
+	}
+}
+
+type debounceWatcher[T any] struct {
+	w Watcher[T]
+	d time.Duration
+}
+
+func (d *debounceWatcher[T]) Get(ctx context.Context, opts ...GetOption[T]) (T, error) {
+	var predicate func(T) bool
+	for _, opt := range opts {
+		if opt.Predicate != nil {
+			predicate = opt.Predicate
+		}
+		if opt.BacklogOnly {
+			var empty T
+			return empty, errors.New("BacklogOnly is not implemented for debounced watchers")
+		}
+	}
+
+	for {
+		v, err := d.w.Get(ctx)
+		if err != nil {
+			return v, err
+		}
+		// Keep replacing v with newer data until no update arrives within the
+		// debounce duration. The inner watcher stays usable after a Get is
+		// aborted by its context expiring.
+		for {
+			tctx, tctxC := context.WithTimeout(ctx, d.d)
+			nv, err := d.w.Get(tctx)
+			expired := tctx.Err() != nil
+			tctxC()
+			if err == nil {
+				v = nv
+				continue
+			}
+			if expired && ctx.Err() == nil {
+				break
+			}
+			return nv, err
+		}
+		if predicate != nil && !predicate(v) {
+			continue
+		}
+		return v, nil
+	}
+}
+
+func (d *debounceWatcher[T]) Close() error {
+	return d.w.Close()
+}
+
+// Combine2 returns a Value which contains the data of two given Values
+// combined by fn. Watchers of the returned Value return new data whenever any
+// of the given Values is updated, once all of them have data. fn is called on
+// every Get and should be cheap and side-effect free.
+//
+// Every watcher of the returned Value keeps watchers of the given Values open
+// in background goroutines until it is Closed. If any of them returns an
+// error, all subsequent Get calls return that error, and the watcher should
+// be Closed and replaced by a new one. BacklogOnly is not supported.
+func Combine2[A, B, R any](a ValueWatch[A], b ValueWatch[B], fn func(A, B) R) ValueWatch[R] {
+	return &combineValue[A, B, R]{
+		a:  a,
+		b:  b,
+		fn: fn,
+	}
+}
+
+// Combine3 is like Combine2, but combines three Values.
+func Combine3[A, B, C, R any](a ValueWatch[A], b ValueWatch[B], c ValueWatch[C], fn func(A, B, C) R) ValueWatch[R] {
+	ab := Combine2(a, b, func(a A, b B) pair[A, B] {
+		return pair[A, B]{a: a, b: b}
+	})
+	return Combine2(ab, c, func(ab pair[A, B], c C) R {
+		return fn(ab.a, ab.b, c)
+	})
+}
+
+type pair[A, B any] struct {
+	a A
+	b B
+}
+
+type combineValue[A, B, R any] struct {
+	a  ValueWatch[A]
+	b  ValueWatch[B]
+	fn func(A, B) R
+}
+
+func (c *combineValue[A, B, R]) Watch() Watcher[R] {
+	ctx, ctxC := context.WithCancel(context.Background())
+	w := &combineWatcher[A, B, R]{
+		fn:      c.fn,
+		ctxC:    ctxC,
+		changed: make(chan struct{}, 1),
+		getSem:  make(chan struct{}, 1),
+	}
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		pump(ctx, w, c.a.Watch(), func(v A) {
+			w.a = v
+			w.aSet = true
+		})
+	}()
+	go func() {
+		defer w.wg.Done()
+		pump(ctx, w, c.b.Watch(), func(v B) {
+			w.b = v
+			w.bSet = true
+		})
+	}()
+	return w
+}
+
+// combineWatcher implements the Watcher interface for watchers returned by
+// Combine2.
+type combineWatcher[A, B, R any] struct {
+	fn func(A, B) R
+
+	// ctxC cancels the goroutines pumping data from the inner watchers, which
+	// are tracked by wg.
+	ctxC context.CancelFunc
+	wg   sync.WaitGroup
+
+	// mu guards a, aSet, b, bSet and err.
+	mu sync.Mutex
+	// a and b are the latest data retrieved from the inner watchers, valid if
+	// aSet and bSet are true respectively.
+	a    A
+	aSet bool
+	b    B
+	bSet bool
+	// err is the first error returned by any of the inner watchers.
+	err error
+
+	// changed is a buffered channel of size 1 which is written to whenever
+	// any of the above fields is updated.
+	changed chan struct{}
+	// getSem ensures that only a single .Get() call is active, see
+	// memory.Value.
+	getSem chan struct{}
+}
+
+// pump runs Get on an inner watcher of a combineWatcher until ctx is canceled,
+// storing retrieved data using set.
+func pump[A, B, R, T any](ctx context.Context, c *combineWatcher[A, B, R], w Watcher[T], set func(T)) {
+	defer w.Close()
+	for {
+		v, err := w.Get(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.mu.Lock()
+				if c.err == nil {
+					c.err = err
+				}
+				c.mu.Unlock()
+				c.notify()
+			}
+			return
+		}
+		c.mu.Lock()
+		set(v)
+		c.mu.Unlock()
+		c.notify()
+	}
+}
+
+// notify wakes up a pending or the next Get call.
+func (c *combineWatcher[A, B, R]) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *combineWatcher[A, B, R]) Get(ctx context.Context, opts ...GetOption[R]) (R, error) {
+	var empty R
+	select {
+	case c.getSem <- struct{}{}:
+	default:
+		return empty, fmt.Errorf("cannot Get() concurrently on a single waiter")
+	}
+	defer func() {
+		<-c.getSem
+	}()
+
+	var predicate func(R) bool
+	for _, opt := range opts {
+		if opt.Predicate != nil {
+			predicate = opt.Predicate
+		}
+		if opt.BacklogOnly {
+			return empty, errors.New("BacklogOnly is not implemented for combined watchers")
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return empty, ctx.Err()
+		case <-c.changed:
+		}
+
+		c.mu.Lock()
+		if err := c.err; err != nil {
+			c.mu.Unlock()
+			// Make sure subsequent Get calls also return the error.
+			c.notify()
+			return empty, err
+		}
+		if !c.aSet || !c.bSet {
+			c.mu.Unlock()
+			continue
+		}
+		a, b := c.a, c.b
+		c.mu.Unlock()
+
+		r := c.fn(a, b)
+		if predicate != nil && !predicate(r) {
+			continue
+		}
+		return r, nil
+	}
+}
+
+func (c *combineWatcher[A, B, R]) Close() error {
+	c.ctxC()
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,313 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package event_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"source.monogon.dev/osbase/event"
+	"source.monogon.dev/osbase/event/memory"
+)
+
+// combinators are used to run the semantics tests of memory.Value against
+// all combinators. Each builds a Value which contains the same data as the
+// given source.
+var combinators = map[string]func(src *memory.Value[int]) event.ValueWatch[int]{
+	"Map": func(src *memory.Value[int]) event.ValueWatch[int] {
+		return event.Map[int, int](src, func(v int) int {
+			return v
+		})
+	},
+	"DistinctUntilChanged": func(src *memory.Value[int]) event.ValueWatch[int] {
+		return event.DistinctUntilChanged[int](src)
+	},
+	"Debounce": func(src *memory.Value[int]) event.ValueWatch[int] {
+		return event.Debounce[int](src, 10*time.Millisecond)
+	},
+	"Combine2": func(src *memory.Value[int]) event.ValueWatch[int] {
+		var other memory.Value[string]
+		other.Set("foo")
+		return event.Combine2[int, string](src, &other, func(v int, _ string) int {
+			return v
+		})
+	},
+	"Combine3": func(src *memory.Value[int]) event.ValueWatch[int] {
+		var b memory.Value[string]
+		b.Set("foo")
+		var c memory.Value[bool]
+		c.Set(true)
+		return event.Combine3[int, string, bool](src, &b, &c, func(v int, _ string, _ bool) int {
+			return v
+		})
+	},
+}
+
+// TestCombinatorsAsync ensures that watchers of combinators catch up to the
+// newest data Set on their source, like TestAsync in memory.
+func TestCombinatorsAsync(t *testing.T) {
+	for name, combinator := range combinators {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var src memory.Value[int]
+			src.Set(0)
+
+			watcher := combinator(&src).Watch()
+			defer watcher.Close()
+			val, err := watcher.Get(ctx)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if want, got := 0, val; want != got {
+				t.Fatalf("Value: got %d, wanted %d", got, want)
+			}
+
+			for i := 1; i <= 100; i++ {
+				src.Set(i)
+			}
+
+			// Retry for combinators which pick up updates asynchronously and
+			// might thus return intermediate data first.
+			for {
+				val, err = watcher.Get(ctx)
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				if val == 100 {
+					break
+				}
+				if val > 100 {
+					t.Fatalf("Value: got %d, wanted at most 100", val)
+				}
+			}
+		})
+	}
+}
+
+// TestCombinatorsMultipleGets verifies that calling .Get() on a single watcher
+// of a combinator from two goroutines is prevented by returning an error in
+// exactly one of them.
+func TestCombinatorsMultipleGets(t *testing.T) {
+	for name, combinator := range combinators {
+		t.Run(name, func(t *testing.T) {
+			ctx, ctxC := context.WithCancel(context.Background())
+			defer ctxC()
+			var src memory.Value[int]
+
+			w := combinator(&src).Watch()
+			defer w.Close()
+
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, err := w.Get(ctx)
+					errs <- err
+				}()
+			}
+			if err := <-errs; err == nil || errors.Is(err, ctx.Err()) {
+				t.Fatalf("A Get call returned %v, while it should have blocked or returned an error", err)
+			}
+			// Unblock the other Get, so that the watcher can be Closed.
+			ctxC()
+			<-errs
+		})
+	}
+}
+
+// TestCombinatorsCanceling exercises whether a context canceling in a .Get()
+// of a combinator's watcher gracefully aborts that particular Get call, but
+// also allows subsequent use of the same watcher.
+func TestCombinatorsCanceling(t *testing.T) {
+	for name, combinator := range combinators {
+		t.Run(name, func(t *testing.T) {
+			var src memory.Value[int]
+			ctx, ctxC := context.WithCancel(context.Background())
+
+			watcher := combinator(&src).Watch()
+			defer watcher.Close()
+
+			errs := make(chan error, 1)
+			go func() {
+				_, err := watcher.Get(ctx)
+				errs <- err
+			}()
+
+			ctxC()
+			if want, got := ctx.Err(), <-errs; !errors.Is(got, want) {
+				t.Fatalf("Get should've returned %v, got %v", want, got)
+			}
+
+			ctx = context.Background()
+			go func() {
+				_, err := watcher.Get(ctx)
+				errs <- err
+			}()
+
+			src.Set(1)
+			if want, got := error(nil), <-errs; !errors.Is(got, want) {
+				t.Fatalf("Get should've returned %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// TestCombinatorsSetAfterWatch ensures that if the source of a combinator is
+// updated between a Watch and the initial Get, only the newest Set value is
+// returned.
+func TestCombinatorsSetAfterWatch(t *testing.T) {
+	for name, combinator := range combinators {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var src memory.Value[int]
+			src.Set(0)
+
+			watcher := combinator(&src).Watch()
+			defer watcher.Close()
+			src.Set(1)
+
+			// Combinators with background goroutines might still pick up the
+			// initial value if those have not run yet, so allow for that.
+			data, err := watcher.Get(ctx)
+			if err == nil && data == 0 {
+				data, err = watcher.Get(ctx)
+			}
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if want, got := 1, data; want != got {
+				t.Errorf("Get should've returned %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// TestMap exercises converting data and applying predicates to the converted
+// data.
+func TestMap(t *testing.T) {
+	ctx := context.Background()
+	var src memory.Value[int]
+	m := event.Map[int, string](&src, func(v int) string {
+		if v%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	})
+	w := m.Watch()
+	defer w.Close()
+
+	src.Set(1)
+	go func() {
+		src.Set(3)
+		src.Set(4)
+	}()
+	val, err := w.Get(ctx, event.Filter(func(v string) bool {
+		return v == "even"
+	}))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if want, got := "even", val; want != got {
+		t.Errorf("Get should've returned %q, got %q", want, got)
+	}
+}
+
+// TestCombine2 ensures that a combined Value only has data once all inputs do,
+// and is updated whenever any of them changes.
+func TestCombine2(t *testing.T) {
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+
+	var a memory.Value[int]
+	var b memory.Value[string]
+	type ab struct {
+		a int
+		b string
+	}
+	w := event.Combine2[int, string](&a, &b, func(a int, b string) ab {
+		return ab{a, b}
+	}).Watch()
+	defer w.Close()
+
+	a.Set(1)
+	sctx, sctxC := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err := w.Get(sctx)
+	sctxC()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get with only one input set should've timed out, got %v", err)
+	}
+
+	expect := func(want ab) {
+		t.Helper()
+		val, err := w.Get(ctx, event.Filter(func(v ab) bool {
+			return v == want
+		}))
+		if err != nil {
+			t.Fatalf("Get(%+v): %v", want, err)
+		}
+		if val != want {
+			t.Errorf("Get should've returned %+v, got %+v", want, val)
+		}
+	}
+	b.Set("foo")
+	expect(ab{1, "foo"})
+	a.Set(2)
+	expect(ab{2, "foo"})
+	b.Set("bar")
+	expect(ab{2, "bar"})
+}
+
+// TestDistinctUntilChanged ensures that repeated Sets of the same data are
+// not returned.
+func TestDistinctUntilChanged(t *testing.T) {
+	ctx := context.Background()
+	var src memory.Value[int]
+	w := event.DistinctUntilChanged[int](&src).Watch()
+	defer w.Close()
+
+	src.Set(1)
+	if val, err := w.Get(ctx); err != nil || val != 1 {
+		t.Fatalf("Get should've returned 1, got %d, %v", val, err)
+	}
+	src.Set(1)
+	sctx, sctxC := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err := w.Get(sctx)
+	sctxC()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get after setting the same data should've timed out, got %v", err)
+	}
+	src.Set(2)
+	if val, err := w.Get(ctx); err != nil || val != 2 {
+		t.Fatalf("Get should've returned 2, got %d, %v", val, err)
+	}
+}
+
+// TestDebounce ensures that data is only returned once it has settled.
+func TestDebounce(t *testing.T) {
+	ctx := context.Background()
+	var src memory.Value[int]
+	w := event.Debounce[int](&src, 50*time.Millisecond).Watch()
+	defer w.Close()
+
+	// Keep updating the source faster than the debounce duration.
+	go func() {
+		for i := 1; i <= 10; i++ {
+			src.Set(i)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	val, err := w.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if want, got := 10, val; want != got {
+		t.Errorf("Get should've returned %d, got %d", want, got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Get returned after %s, before the debounce duration", elapsed)
+	}
+}
@@ -5,9 +5,11 @@ package logtree
 
 import (
 	"errors"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"source.monogon.dev/go/logging"
 )
@@ -96,6 +98,10 @@ type journal struct {
 	// provided filters (eg. to limit events to subtrees that interest that particular
 	// subscriber).
 	subscribers []*subscriber
+
+	// persist writes entries to disk if persistence has been enabled with
+	// LogTree.Persist, otherwise it is nil.
+	persist *persister
 }
 
 // newJournal creates a new empty journal. All journals are independent from
@@ -161,6 +167,43 @@ func filterSeverity(atLeast logging.Severity) filter {
 	}
 }
 
+// filterTimeRange returns a filter that accepts all leveled log entries with a
+// timestamp at or after since and before until. A zero since or until leaves
+// the range open on that side.
+func filterTimeRange(since, until time.Time) filter {
+	return func(e *entry) bool {
+		if e.leveled == nil {
+			return false
+		}
+		ts := e.leveled.timestamp
+		if !since.IsZero() && ts.Before(since) {
+			return false
+		}
+		if !until.IsZero() && !ts.Before(until) {
+			return false
+		}
+		return true
+	}
+}
+
+// filterMessageMatching returns a filter that accepts all log entries in which
+// re matches any line of a leveled message, or the data of a raw entry.
+func filterMessageMatching(re *regexp.Regexp) filter {
+	return func(e *entry) bool {
+		if e.raw != nil {
+			return re.MatchString(e.raw.Data)
+		}
+		if e.leveled != nil {
+			for _, m := range e.leveled.messages {
+				if re.MatchString(m) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
 func filterOnlyRaw(e *entry) bool {
 	return e.raw != nil
 }
@@ -117,6 +117,10 @@ func (j *journal) append(e *entry) {
 		j.head = e
 	}
 
+	if j.persist != nil {
+		j.persist.write(e)
+	}
+
 	// Create quota if necessary.
 	if _, ok := j.quota[e.origin]; !ok {
 		j.quota[e.origin] = &quota{origin: e.origin, max: 8192}
@@ -0,0 +1,603 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package logtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	lpb "source.monogon.dev/osbase/logtree/proto"
+)
+
+// PersistOptions configures persistent storage of a LogTree's journal, see
+// LogTree.Persist.
+type PersistOptions struct {
+	// Directory in which journals are stored. Every boot gets a subdirectory
+	// named after its boot ID, which contains a journal file per DN.
+	Directory string
+	// BootID identifies the current boot. Its journal can be read from later
+	// boots by passing the same ID to FromBoot.
+	BootID []byte
+	// QuotaPerDN is the maximum size in bytes of the journal of a single DN
+	// within a boot. Once half of it is used up, the journal file is rotated,
+	// replacing the previously rotated file. Defaults to 1MiB if zero.
+	QuotaPerDN int64
+	// Quota is the maximum size in bytes of the journals of all DNs within a
+	// boot. Once it is used up, the rotated files of the DNs using up the most
+	// space are removed first. Defaults to 64MiB if zero.
+	Quota int64
+	// MaxPreviousBoots is the number of previous boots for which journals are
+	// kept. Journals of older boots are removed when calling Persist. Defaults
+	// to 3 if zero.
+	MaxPreviousBoots int
+}
+
+var (
+	// ErrUnknownBoot is returned by Read if FromBoot refers to a boot for which
+	// no persisted journal is available.
+	ErrUnknownBoot = errors.New("no journal available for boot")
+	// ErrStreamFromBoot is returned by Read if both FromBoot (for a previous
+	// boot) and WithStream are requested.
+	ErrStreamFromBoot = errors.New("cannot stream logs of a previous boot")
+)
+
+const (
+	persistFileSuffix    = ".journal"
+	persistRotatedSuffix = ".journal.1"
+)
+
+const (
+	// persistFlushInterval is the maximum time for which logged journal
+	// entries are queued before being written to their files.
+	persistFlushInterval = time.Second
+	// persistMaxQueued is the maximum size in bytes of the entries queued for
+	// writing. Further entries are dropped until the queue has been written,
+	// so that logging never blocks on slow storage.
+	persistMaxQueued = 4 << 20
+	// persistMaxOpenFiles is the maximum number of journal files kept open.
+	// Once reached, the least recently written file is closed before opening
+	// another one.
+	persistMaxOpenFiles = 32
+)
+
+// persister writes journal entries to disk. Entries are queued with write
+// while journal.mu is held, and written to disk by flush without holding it,
+// so that logging is never blocked by disk I/O.
+type persister struct {
+	// directory is the directory containing the journals of all boots, dir the
+	// one containing the journal of the current boot. These and all other
+	// options are not modified after the persister is created.
+	directory string
+	dir       string
+	bootID    string
+	// maxFileSize is the size after which a journal file is rotated.
+	maxFileSize int64
+	// quota is the maximum total size of all journal files of this boot.
+	quota int64
+
+	// seq is the sequence number of the next entry. It is used to restore the
+	// global order of entries across DNs when reading them back. It is
+	// protected by journal.mu.
+	seq uint64
+
+	// mu protects the fields below, up to writeMu.
+	mu sync.Mutex
+	// queue contains the records not yet written to disk, in order.
+	queue []persistRecord
+	// queued is the total size of the records in queue.
+	queued int
+	// flushTimer flushes the queue once it fires. It is nil if the queue is
+	// empty.
+	flushTimer *time.Timer
+
+	// writeMu serializes flushes, and protects the fields below.
+	writeMu sync.Mutex
+	// files are the currently open journal files, by DN.
+	files map[DN]*persistFile
+	// sizes are the sizes of the journal files of all DNs within this boot,
+	// total the sum of all of them.
+	sizes map[DN]*persistSizes
+	total int64
+	// uses counts writes, it is used to find the least recently written file.
+	uses uint64
+}
+
+// persistRecord is a serialized entry queued for writing.
+type persistRecord struct {
+	dn   DN
+	data []byte
+}
+
+type persistFile struct {
+	f *os.File
+	w *bufio.Writer
+	// lastUse is the value of persister.uses when the file was last written.
+	lastUse uint64
+}
+
+// persistSizes are the sizes of the current and the rotated journal file of a
+// DN.
+type persistSizes struct {
+	current int64
+	rotated int64
+}
+
+// Persist enables persistent storage of this LogTree's journal, as configured
+// by opts. All entries currently held in memory are written immediately, and
+// all further entries are written as they are logged.
+//
+// Entries are queued for up to a second before being written to the page
+// cache, so all but the most recent entries survive a crash of the process,
+// but not necessarily a crash of the system. Entries logged while the queue
+// is full are dropped, and failing writes are ignored, as there is no way to
+// report them other than logging.
+//
+// Persist may be called multiple times with the same directory, in which case
+// all but the first call have no effect.
+func (l *LogTree) Persist(opts PersistOptions) error {
+	if len(opts.BootID) == 0 {
+		return fmt.Errorf("boot ID must be set")
+	}
+	if opts.QuotaPerDN == 0 {
+		opts.QuotaPerDN = 1 << 20
+	}
+	if opts.Quota == 0 {
+		opts.Quota = 64 << 20
+	}
+	if opts.MaxPreviousBoots == 0 {
+		opts.MaxPreviousBoots = 3
+	}
+	bootID := hex.EncodeToString(opts.BootID)
+
+	j := l.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.persist != nil {
+		if j.persist.directory == opts.Directory && j.persist.bootID == bootID {
+			return nil
+		}
+		return fmt.Errorf("already persisting to %s", j.persist.directory)
+	}
+
+	if err := os.MkdirAll(opts.Directory, 0700); err != nil {
+		return fmt.Errorf("could not create journal directory: %w", err)
+	}
+	if err := prunePersistedBoots(opts.Directory, bootID, opts.MaxPreviousBoots); err != nil {
+		return err
+	}
+	dir := filepath.Join(opts.Directory, bootID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not create journal directory: %w", err)
+	}
+	p := &persister{
+		directory:   opts.Directory,
+		dir:         dir,
+		bootID:      bootID,
+		maxFileSize: opts.QuotaPerDN / 2,
+		quota:       opts.Quota,
+		files:       make(map[DN]*persistFile),
+		sizes:       make(map[DN]*persistSizes),
+	}
+	// Continue the sequence and the accounting of sizes if this boot was
+	// already persisted to before.
+	records, err := readPersistedBoot(dir, func(DN) bool { return true }, nil)
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		p.seq = records[len(records)-1].seq + 1
+	}
+	if err := p.loadSizes(); err != nil {
+		return err
+	}
+
+	// Queue all entries held in memory regardless of the queue limit, they are
+	// written right away.
+	for cur := j.head; cur != nil; cur = cur.nextGlobal {
+		if r, ok := p.record(cur); ok {
+			p.queue = append(p.queue, r)
+			p.queued += len(r.data)
+		}
+	}
+	j.persist = p
+	go p.flush()
+	return nil
+}
+
+// loadSizes initializes the sizes of the journal files of this boot from
+// disk.
+func (p *persister) loadSizes() error {
+	files, err := os.ReadDir(p.dir)
+	if err != nil {
+		return fmt.Errorf("could not list journal files: %w", err)
+	}
+	for _, file := range files {
+		name, rotated := strings.CutSuffix(file.Name(), persistRotatedSuffix)
+		if !rotated {
+			var ok bool
+			name, ok = strings.CutSuffix(file.Name(), persistFileSuffix)
+			if !ok {
+				continue
+			}
+		}
+		dn, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return fmt.Errorf("could not stat journal file: %w", err)
+		}
+		sz := p.sizesOf(DN(dn))
+		if rotated {
+			sz.rotated = info.Size()
+		} else {
+			sz.current = info.Size()
+		}
+		p.total += info.Size()
+	}
+	return nil
+}
+
+// prunePersistedBoots removes the journals of all but the newest maxBoots
+// boots other than the current one from the given directory.
+func prunePersistedBoots(directory, current string, maxBoots int) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return fmt.Errorf("could not list journal directory: %w", err)
+	}
+	type boot struct {
+		name string
+		info os.FileInfo
+	}
+	var boots []boot
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == current {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("could not stat journal of boot %s: %w", e.Name(), err)
+		}
+		boots = append(boots, boot{name: e.Name(), info: info})
+	}
+	if len(boots) <= maxBoots {
+		return nil
+	}
+	// Journal directories of previous boots are not modified anymore, so their
+	// modification time is roughly the end of the boot.
+	sort.Slice(boots, func(i, j int) bool {
+		return boots[i].info.ModTime().Before(boots[j].info.ModTime())
+	})
+	for _, b := range boots[:len(boots)-maxBoots] {
+		if err := os.RemoveAll(filepath.Join(directory, b.name)); err != nil {
+			return fmt.Errorf("could not remove journal of boot %s: %w", b.name, err)
+		}
+	}
+	return nil
+}
+
+// persistFileName returns the name of the journal file for a given DN. DNs
+// are escaped, as they might contain slashes.
+func persistFileName(dn DN) string {
+	return url.PathEscape(string(dn)) + persistFileSuffix
+}
+
+// persistPath returns the path of the current or rotated journal file of a
+// DN.
+func (p *persister) persistPath(dn DN, rotated bool) string {
+	path := filepath.Join(p.dir, persistFileName(dn))
+	if rotated {
+		path = strings.TrimSuffix(path, persistFileSuffix) + persistRotatedSuffix
+	}
+	return path
+}
+
+// record serializes an entry into a record, assigning it the next sequence
+// number. It must be called with journal.mu taken as RW.
+func (p *persister) record(e *entry) (persistRecord, bool) {
+	ep := e.external().Proto()
+	if ep == nil {
+		return persistRecord{}, false
+	}
+	data, err := proto.Marshal(ep)
+	if err != nil {
+		return persistRecord{}, false
+	}
+	// Records are the entry's sequence number, followed by the length of the
+	// serialized entry, followed by the serialized entry.
+	record := binary.AppendUvarint(nil, p.seq)
+	record = binary.AppendUvarint(record, uint64(len(data)))
+	record = append(record, data...)
+	p.seq++
+	return persistRecord{dn: e.origin, data: record}, true
+}
+
+// write queues an entry to be written to the journal file of its DN. It must
+// be called with journal.mu taken as RW, and does not perform any I/O.
+func (p *persister) write(e *entry) {
+	r, ok := p.record(e)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queued+len(r.data) > persistMaxQueued {
+		return
+	}
+	p.queue = append(p.queue, r)
+	p.queued += len(r.data)
+	if p.flushTimer == nil {
+		p.flushTimer = time.AfterFunc(persistFlushInterval, p.flush)
+	}
+}
+
+// flush writes all queued entries to their files. It must be called without
+// holding journal.mu.
+func (p *persister) flush() {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.queued = 0
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	p.mu.Unlock()
+
+	for _, r := range queue {
+		p.writeRecord(r)
+	}
+	for _, pf := range p.files {
+		pf.w.Flush()
+	}
+}
+
+func (p *persister) sizesOf(dn DN) *persistSizes {
+	sz, ok := p.sizes[dn]
+	if !ok {
+		sz = &persistSizes{}
+		p.sizes[dn] = sz
+	}
+	return sz
+}
+
+// writeRecord appends a record to the journal file of its DN, rotating the
+// file and enforcing the quota if needed. It must be called with writeMu
+// taken.
+func (p *persister) writeRecord(r persistRecord) {
+	sz := p.sizesOf(r.dn)
+	if sz.current > 0 && sz.current+int64(len(r.data)) > p.maxFileSize {
+		p.closeFile(r.dn)
+		if err := os.Rename(p.persistPath(r.dn, false), p.persistPath(r.dn, true)); err != nil {
+			return
+		}
+		p.total -= sz.rotated
+		sz.rotated, sz.current = sz.current, 0
+	}
+	pf, ok := p.files[r.dn]
+	if !ok {
+		if len(p.files) >= persistMaxOpenFiles {
+			p.closeLeastRecentlyUsed()
+		}
+		f, err := os.OpenFile(p.persistPath(r.dn, false), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return
+		}
+		pf = &persistFile{f: f, w: bufio.NewWriter(f)}
+		p.files[r.dn] = pf
+	}
+	p.uses++
+	pf.lastUse = p.uses
+	n, _ := pf.w.Write(r.data)
+	sz.current += int64(n)
+	p.total += int64(n)
+
+	for p.total > p.quota && p.evict() {
+	}
+}
+
+// closeFile flushes and closes the journal file of a DN, if it is open.
+func (p *persister) closeFile(dn DN) {
+	pf, ok := p.files[dn]
+	if !ok {
+		return
+	}
+	pf.w.Flush()
+	pf.f.Close()
+	delete(p.files, dn)
+}
+
+// closeLeastRecentlyUsed closes the journal file which was written least
+// recently.
+func (p *persister) closeLeastRecentlyUsed() {
+	var lru DN
+	var lruUse uint64
+	found := false
+	for dn, pf := range p.files {
+		if !found || pf.lastUse < lruUse {
+			lru, lruUse, found = dn, pf.lastUse, true
+		}
+	}
+	if found {
+		p.closeFile(lru)
+	}
+}
+
+// evict frees up space to stay within the quota by removing the largest
+// rotated journal file or, if there are none, by truncating the largest
+// journal file. It returns false if there was nothing to remove.
+func (p *persister) evict() bool {
+	var victim DN
+	var victimSize int64
+	rotated := false
+	for dn, sz := range p.sizes {
+		switch {
+		case sz.rotated > 0 && (!rotated || sz.rotated > victimSize):
+			victim, victimSize, rotated = dn, sz.rotated, true
+		case !rotated && sz.current > victimSize:
+			victim, victimSize = dn, sz.current
+		}
+	}
+	if victimSize == 0 {
+		return false
+	}
+	sz := p.sizes[victim]
+	if rotated {
+		if err := os.Remove(p.persistPath(victim, true)); err != nil {
+			return false
+		}
+		sz.rotated = 0
+	} else {
+		p.closeFile(victim)
+		if err := os.Truncate(p.persistPath(victim, false), 0); err != nil {
+			return false
+		}
+		sz.current = 0
+	}
+	p.total -= victimSize
+	return true
+}
+
+// persistedRecord is an entry read back from a persisted journal.
+type persistedRecord struct {
+	seq   uint64
+	entry *entry
+}
+
+// readPersistedBoot reads all entries from the journal of a boot whose DN
+// matches dnFilter and which pass all filters, in the order in which they
+// were logged.
+func readPersistedBoot(dir string, dnFilter func(DN) bool, filters []filter) ([]persistedRecord, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list journal files: %w", err)
+	}
+	var res []persistedRecord
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), persistFileSuffix)
+		if !ok {
+			name, ok = strings.CutSuffix(file.Name(), persistRotatedSuffix)
+		}
+		if !ok {
+			continue
+		}
+		dn, err := url.PathUnescape(name)
+		if err != nil || !dnFilter(DN(dn)) {
+			continue
+		}
+		records, err := readPersistedFile(filepath.Join(dir, file.Name()), filters)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, records...)
+	}
+	slices.SortFunc(res, func(a, b persistedRecord) int {
+		switch {
+		case a.seq < b.seq:
+			return -1
+		case a.seq > b.seq:
+			return 1
+		}
+		return 0
+	})
+	return res, nil
+}
+
+// readPersistedFile reads all entries passing filters from a journal file. A
+// truncated record at the end of the file, as left behind by a crash, is
+// ignored.
+func readPersistedFile(path string, filters []filter) ([]persistedRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal file: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var res []persistedRecord
+	for {
+		seq, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > 1<<20 {
+			break
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		var ep lpb.LogEntry
+		if err := proto.Unmarshal(data, &ep); err != nil {
+			continue
+		}
+		le, err := LogEntryFromProto(&ep)
+		if err != nil {
+			continue
+		}
+		e := &entry{
+			origin:  le.DN,
+			leveled: le.Leveled,
+			raw:     le.Raw,
+		}
+		passed := true
+		for _, filter := range filters {
+			if !filter(e) {
+				passed = false
+				break
+			}
+		}
+		if passed {
+			res = append(res, persistedRecord{seq: seq, entry: e})
+		}
+	}
+	return res, nil
+}
+
+// read returns up to count of the newest entries of a previous boot which
+// pass all filters. It only accesses immutable fields of the persister, so
+// journal.mu does not need to be taken when calling this function.
+func (p *persister) read(bootID []byte, count int, dn DN, recursive bool, filters ...filter) ([]*entry, error) {
+	dir := filepath.Join(p.directory, hex.EncodeToString(bootID))
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnknownBoot
+	}
+	dnFilter := func(d DN) bool { return d == dn }
+	if recursive {
+		sub := filterSubtree(dn)
+		dnFilter = func(d DN) bool { return sub(&entry{origin: d}) }
+	}
+	records, err := readPersistedBoot(dir, dnFilter, filters)
+	if err != nil {
+		return nil, err
+	}
+	if count != BacklogAllAvailable && len(records) > count {
+		records = records[len(records)-count:]
+	}
+	res := make([]*entry, len(records))
+	for i, r := range records {
+		res[i] = r.entry
+	}
+	return res, nil
+}
@@ -0,0 +1,283 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package logtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flushPersisted writes all buffered journal entries of lt to disk.
+func flushPersisted(lt *LogTree) {
+	lt.journal.mu.RLock()
+	p := lt.journal.persist
+	lt.journal.mu.RUnlock()
+	p.flush()
+}
+
+func TestPersistAcrossBoots(t *testing.T) {
+	dir := t.TempDir()
+
+	// First boot: log some entries before and after enabling persistence.
+	lt := New()
+	lt.MustLeveledFor("main").Info("before persist")
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	// Repeated calls have no effect.
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	lt.MustLeveledFor("main.sub").Info("in sub")
+	lt.MustLeveledFor("other").Info("in other")
+	lt.MustLeveledFor("main").Info("after persist")
+	flushPersisted(lt)
+
+	// Second boot.
+	lt2 := New()
+	if err := lt2.Persist(PersistOptions{Directory: dir, BootID: []byte{2}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	lt2.MustLeveledFor("main").Info("second boot")
+
+	for _, te := range []struct {
+		dn   DN
+		opts []LogReadOption
+		want []string
+	}{
+		{"main", nil, []string{"before persist", "after persist"}},
+		{"main", []LogReadOption{WithChildren()}, []string{"before persist", "in sub", "after persist"}},
+		{"", []LogReadOption{WithChildren()}, []string{"before persist", "in sub", "in other", "after persist"}},
+		{"main", []LogReadOption{WithChildren(), WithBacklog(1)}, []string{"after persist"}},
+	} {
+		opts := append([]LogReadOption{FromBoot([]byte{1}), WithBacklog(BacklogAllAvailable)}, te.opts...)
+		reader, err := lt2.Read(te.dn, opts...)
+		if err != nil {
+			t.Fatalf("Read(%q): %v", te.dn, err)
+		}
+		var got []string
+		for _, e := range reader.Backlog {
+			got = append(got, e.Leveled.MessagesJoined())
+		}
+		if want, got := strings.Join(te.want, ","), strings.Join(got, ","); want != got {
+			t.Errorf("Read(%q): wanted %q, got %q", te.dn, want, got)
+		}
+	}
+
+	// The current boot is read from memory, and can be streamed.
+	reader, err := lt2.Read("main", FromBoot([]byte{2}), WithBacklog(BacklogAllAvailable), WithStream())
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	reader.Close()
+	if want, got := 1, len(reader.Backlog); want != got {
+		t.Errorf("wanted %d entries of current boot, got %d", want, got)
+	}
+
+	if _, err := lt2.Read("main", FromBoot([]byte{1}), WithStream()); !errors.Is(err, ErrStreamFromBoot) {
+		t.Errorf("streaming previous boot: wanted ErrStreamFromBoot, got %v", err)
+	}
+	if _, err := lt2.Read("main", FromBoot([]byte{3}), WithBacklog(BacklogAllAvailable)); !errors.Is(err, ErrUnknownBoot) {
+		t.Errorf("reading unknown boot: wanted ErrUnknownBoot, got %v", err)
+	}
+}
+
+func TestPersistRotation(t *testing.T) {
+	dir := t.TempDir()
+	quota := int64(16 << 10)
+
+	lt := New()
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}, QuotaPerDN: quota}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		lt.MustLeveledFor("chatty").Infof("chatty %d", i)
+	}
+	lt.MustLeveledFor("solemn").Info("solemn")
+	flushPersisted(lt)
+
+	var total int64
+	files, err := os.ReadDir(filepath.Join(dir, "01"))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "chatty") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			t.Fatalf("Info: %v", err)
+		}
+		total += info.Size()
+	}
+	if total > quota {
+		t.Errorf("journal of chatty uses %d bytes, more than quota of %d", total, quota)
+	}
+
+	lt2 := New()
+	if err := lt2.Persist(PersistOptions{Directory: dir, BootID: []byte{2}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	reader, err := lt2.Read("chatty", FromBoot([]byte{1}), WithBacklog(BacklogAllAvailable))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(reader.Backlog) == 0 || len(reader.Backlog) == 1000 {
+		t.Fatalf("wanted some but not all entries to be retained, got %d", len(reader.Backlog))
+	}
+	// The newest entries are retained, in order.
+	for i, e := range reader.Backlog {
+		want := fmt.Sprintf("chatty %d", 1000-len(reader.Backlog)+i)
+		if got := e.Leveled.MessagesJoined(); want != got {
+			t.Fatalf("entry %d: wanted %q, got %q", i, want, got)
+		}
+	}
+	// Other DNs are not affected by the chatty one.
+	reader, err = lt2.Read("solemn", FromBoot([]byte{1}), WithBacklog(BacklogAllAvailable))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want, got := 1, len(reader.Backlog); want != got {
+		t.Errorf("wanted %d solemn entries, got %d", want, got)
+	}
+}
+
+func TestPersistPruneBoots(t *testing.T) {
+	dir := t.TempDir()
+	for i := byte(1); i <= 4; i++ {
+		lt := New()
+		if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{i}, MaxPreviousBoots: 2}); err != nil {
+			t.Fatalf("Persist: %v", err)
+		}
+		lt.MustLeveledFor("main").Infof("boot %d", i)
+		// Boots are ordered by modification time, make sure it differs.
+		time.Sleep(50 * time.Millisecond)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if want, got := "02,03,04", strings.Join(got, ","); want != got {
+		t.Errorf("wanted boots %q, got %q", want, got)
+	}
+}
+
+func TestPersistFlush(t *testing.T) {
+	dir := t.TempDir()
+	lt := New()
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	lt.MustLeveledFor("main").Info("buffered")
+
+	// The entry is written once the flush interval passed.
+	path := filepath.Join(dir, "01", persistFileName("main"))
+	deadline := time.Now().Add(10 * persistFlushInterval)
+	for {
+		info, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat: %v", err)
+		}
+		if err == nil && info.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("journal entry not written after %s", 10*persistFlushInterval)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPersistQuota(t *testing.T) {
+	dir := t.TempDir()
+	quota := int64(64 << 10)
+
+	lt := New()
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}, QuotaPerDN: 16 << 10, Quota: quota}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		lt.MustLeveledFor(DN(fmt.Sprintf("dn%d", i%16))).Infof("entry %d", i)
+	}
+	flushPersisted(lt)
+
+	var total int64
+	files, err := os.ReadDir(filepath.Join(dir, "01"))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			t.Fatalf("Info: %v", err)
+		}
+		total += info.Size()
+	}
+	if total > quota {
+		t.Errorf("journal uses %d bytes, more than quota of %d", total, quota)
+	}
+
+	// The newest entry of every DN is retained.
+	lt2 := New()
+	if err := lt2.Persist(PersistOptions{Directory: dir, BootID: []byte{2}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	for i := 2000 - 16; i < 2000; i++ {
+		dn := DN(fmt.Sprintf("dn%d", i%16))
+		reader, err := lt2.Read(dn, FromBoot([]byte{1}), WithBacklog(1))
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if len(reader.Backlog) != 1 {
+			t.Fatalf("%s: wanted 1 entry, got %d", dn, len(reader.Backlog))
+		}
+		if want, got := fmt.Sprintf("entry %d", i), reader.Backlog[0].Leveled.MessagesJoined(); want != got {
+			t.Errorf("%s: wanted %q, got %q", dn, want, got)
+		}
+	}
+}
+
+func TestPersistOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	lt := New()
+	if err := lt.Persist(PersistOptions{Directory: dir, BootID: []byte{1}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	dns := 2 * persistMaxOpenFiles
+	for i := 0; i < 3*dns; i++ {
+		lt.MustLeveledFor(DN(fmt.Sprintf("dn%d", i%dns))).Infof("entry %d", i)
+	}
+	flushPersisted(lt)
+
+	p := lt.journal.persist
+	p.writeMu.Lock()
+	open := len(p.files)
+	p.writeMu.Unlock()
+	if open > persistMaxOpenFiles {
+		t.Errorf("%d journal files open, wanted at most %d", open, persistMaxOpenFiles)
+	}
+
+	// Entries written after reopening a file are appended.
+	lt2 := New()
+	if err := lt2.Persist(PersistOptions{Directory: dir, BootID: []byte{2}}); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	reader, err := lt2.Read("dn0", FromBoot([]byte{1}), WithBacklog(BacklogAllAvailable))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want, got := 3, len(reader.Backlog); want != got {
+		t.Errorf("wanted %d entries, got %d", want, got)
+	}
+}
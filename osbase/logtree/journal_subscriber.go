@@ -44,11 +44,16 @@ func (j *journal) notify(e *entry) {
 			newSub = append(newSub, sub)
 		}
 
+		passed := true
 		for _, filter := range sub.filters {
 			if !filter(e) {
-				continue
+				passed = false
+				break
 			}
 		}
+		if !passed {
+			continue
+		}
 		select {
 		case sub.dataC <- e.external():
 		default:
@@ -4,8 +4,11 @@
 package logtree
 
 import (
+	"encoding/hex"
 	"errors"
+	"regexp"
 	"sync/atomic"
+	"time"
 
 	"source.monogon.dev/go/logging"
 )
@@ -18,6 +21,10 @@ type LogReadOption struct {
 	onlyLeveled                bool
 	onlyRaw                    bool
 	leveledWithMinimumSeverity logging.Severity
+	fromBoot                   []byte
+	since                      time.Time
+	until                      time.Time
+	messageMatching            *regexp.Regexp
 }
 
 // WithChildren makes Read return/stream data for both a given DN and all its
@@ -47,6 +54,26 @@ func LeveledWithMinimumSeverity(s logging.Severity) LogReadOption {
 	return LogReadOption{leveledWithMinimumSeverity: s}
 }
 
+// FromBoot makes Read return log entries from the boot with the given ID
+// instead of the current one, as persisted by LogTree.Persist. This cannot be
+// combined with WithStream, unless the ID is the one of the current boot.
+func FromBoot(bootID []byte) LogReadOption { return LogReadOption{fromBoot: bootID} }
+
+// Since makes Read return only leveled log entries with a timestamp at or after
+// t. Raw entries carry no timestamp and are thus never returned.
+func Since(t time.Time) LogReadOption { return LogReadOption{since: t} }
+
+// Until makes Read return only leveled log entries with a timestamp before t.
+// Raw entries carry no timestamp and are thus never returned.
+func Until(t time.Time) LogReadOption { return LogReadOption{until: t} }
+
+// MessageMatching makes Read return only log entries in which re matches any
+// line of a leveled entry's message, or the data of a raw entry. If passed
+// multiple times, entries must match all given expressions.
+func MessageMatching(re *regexp.Regexp) LogReadOption {
+	return LogReadOption{messageMatching: re}
+}
+
 // LogReader permits reading an already existing backlog of log entries and to
 // stream further ones.
 type LogReader struct {
@@ -94,14 +121,14 @@ var (
 // whether only entries for that particular DN are returned, or for all sub-DNs as
 // well.
 func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
-	l.journal.mu.RLock()
-	defer l.journal.mu.RUnlock()
-
 	var backlog int
 	var stream bool
 	var recursive bool
 	var leveledSeverity logging.Severity
 	var onlyRaw, onlyLeveled bool
+	var fromBoot []byte
+	var since, until time.Time
+	var messageMatching []*regexp.Regexp
 
 	for _, opt := range opts {
 		if opt.withBacklog > 0 || opt.withBacklog == BacklogAllAvailable {
@@ -122,11 +149,34 @@ func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
 		if opt.onlyRaw {
 			onlyRaw = true
 		}
+		if opt.fromBoot != nil {
+			fromBoot = opt.fromBoot
+		}
+		if !opt.since.IsZero() {
+			since = opt.since
+		}
+		if !opt.until.IsZero() {
+			until = opt.until
+		}
+		if opt.messageMatching != nil {
+			messageMatching = append(messageMatching, opt.messageMatching)
+		}
 	}
 
 	if onlyLeveled && onlyRaw {
 		return nil, ErrRawAndLeveled
 	}
+	// Entries of the current boot are served from memory.
+	l.journal.mu.RLock()
+	persist := l.journal.persist
+	l.journal.mu.RUnlock()
+	previousBoot := fromBoot != nil
+	if previousBoot && persist != nil && hex.EncodeToString(fromBoot) == persist.bootID {
+		previousBoot = false
+	}
+	if previousBoot && stream {
+		return nil, ErrStreamFromBoot
+	}
 
 	var filters []filter
 	if onlyLeveled {
@@ -143,6 +193,37 @@ func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
 	if leveledSeverity != "" {
 		filters = append(filters, filterSeverity(leveledSeverity))
 	}
+	if !since.IsZero() || !until.IsZero() {
+		filters = append(filters, filterTimeRange(since, until))
+	}
+	for _, re := range messageMatching {
+		filters = append(filters, filterMessageMatching(re))
+	}
+
+	// Entries of previous boots are read from disk without holding the journal
+	// lock, so that logging is not blocked meanwhile.
+	if previousBoot {
+		var entries []*entry
+		if backlog > 0 || backlog == BacklogAllAvailable {
+			if persist == nil {
+				return nil, ErrUnknownBoot
+			}
+			var err error
+			entries, err = persist.read(fromBoot, backlog, dn, recursive, filters...)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lr := &LogReader{}
+		lr.Backlog = make([]*LogEntry, len(entries))
+		for i, entry := range entries {
+			lr.Backlog[i] = entry.external()
+		}
+		return lr, nil
+	}
+
+	l.journal.mu.RLock()
+	defer l.journal.mu.RUnlock()
 
 	var entries []*entry
 	if backlog > 0 || backlog == BacklogAllAvailable {
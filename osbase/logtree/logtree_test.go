@@ -5,6 +5,7 @@ package logtree
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -174,6 +175,27 @@ func TestStream(t *testing.T) {
 	}
 }
 
+func TestStreamFiltered(t *testing.T) {
+	tree := New()
+	res, err := tree.Read("main", WithChildren(), WithStream())
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer res.Close()
+
+	tree.MustLeveledFor("other").Info("hello, other")
+	tree.MustLeveledFor("main.sub").Info("hello, main")
+
+	select {
+	case p := <-res.Stream:
+		if want, got := "hello, main", p.Leveled.MessagesJoined(); want != got {
+			t.Errorf("wanted %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no entry received")
+	}
+}
+
 func TestVerbose(t *testing.T) {
 	tree := New()
 
@@ -259,6 +281,66 @@ func TestSeverity(t *testing.T) {
 	}
 }
 
+func TestTimeRange(t *testing.T) {
+	tree := New()
+	tree.MustLeveledFor("main").Info("too early")
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	tree.MustLeveledFor("main").Info("in range")
+	fmt.Fprintf(tree.MustRawFor("main.raw"), "raw, without timestamp\n")
+	tree.MustLeveledFor("main").Info("also in range")
+	until := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	tree.MustLeveledFor("main").Info("too late")
+
+	for _, te := range []struct {
+		opts []LogReadOption
+		want string
+	}{
+		{[]LogReadOption{Since(since), Until(until)}, "in range,also in range"},
+		{[]LogReadOption{Since(since)}, "in range,also in range,too late"},
+		{[]LogReadOption{Until(until)}, "too early,in range,also in range"},
+		{[]LogReadOption{Since(since), Until(until), WithBacklog(1)}, "also in range"},
+	} {
+		opts := append([]LogReadOption{WithChildren(), WithBacklog(BacklogAllAvailable)}, te.opts...)
+		reader, err := tree.Read("main", opts...)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		var got []string
+		for _, e := range reader.Backlog {
+			got = append(got, e.Leveled.MessagesJoined())
+		}
+		if want, got := te.want, strings.Join(got, ","); want != got {
+			t.Errorf("wanted %q, got %q", want, got)
+		}
+	}
+}
+
+func TestMessageMatching(t *testing.T) {
+	tree := New()
+	tree.MustLeveledFor("main").Info("etcd: request timeout")
+	tree.MustLeveledFor("main").Info("first line\netcd: connection timeout")
+	tree.MustLeveledFor("main").Info("etcd: all good")
+	fmt.Fprintf(tree.MustRawFor("main.raw"), "etcd: raw timeout\n")
+
+	reader, err := tree.Read("main", WithChildren(), WithBacklog(BacklogAllAvailable), MessageMatching(regexp.MustCompile("etcd.*timeout")))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	var got []string
+	for _, e := range reader.Backlog {
+		if e.Leveled != nil {
+			got = append(got, e.Leveled.Messages()[0])
+		} else {
+			got = append(got, e.Raw.Data)
+		}
+	}
+	if want, got := "etcd: request timeout,first line,etcd: raw timeout", strings.Join(got, ","); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
 func TestAddedStackDepth(t *testing.T) {
 	tree := New()
 	helper := func(msg string) {
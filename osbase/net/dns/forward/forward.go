@@ -26,6 +26,7 @@ import (
 
 	"github.com/miekg/dns"
 
+	"source.monogon.dev/osbase/event"
 	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/net/dns/forward/cache"
 	"source.monogon.dev/osbase/net/dns/forward/proxy"
@@ -44,8 +45,14 @@ const (
 // Forward represents a plugin instance that can proxy requests to another (DNS)
 // server. It has a list of proxies each representing one upstream proxy.
 type Forward struct {
+	// DNSServers are the addresses of plain DNS upstream servers, usually
+	// obtained via DHCP or static network configuration.
 	DNSServers memory.Value[[]string]
-	upstreams  atomic.Pointer[[]*proxy.Proxy]
+	// EncryptedUpstreams are upstream servers reached over DNS over TLS or
+	// DNS over HTTPS. If any are set, they are used instead of DNSServers,
+	// such that queries are never sent upstream in cleartext.
+	EncryptedUpstreams memory.Value[[]Upstream]
+	upstreams          atomic.Pointer[[]*proxy.Proxy]
 
 	concurrent atomic.Int64
 
@@ -58,23 +65,34 @@ type Forward struct {
 
 // New returns a new Forward.
 func New() *Forward {
-	return &Forward{
+	f := &Forward{
 		seed:  maphash.MakeSeed(),
 		cache: cache.New[*cacheItem](cacheCapacity),
 		now:   time.Now,
 	}
+	// Upstreams are only configured once both sources have data, so start
+	// with neither configured.
+	f.DNSServers.Set(nil)
+	f.EncryptedUpstreams.Set(nil)
+	return f
 }
 
 func (f *Forward) Run(ctx context.Context) error {
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
 
-	var lastAddrs []string
-	var upstreams []*proxy.Proxy
+	var lastKeys []string
+	upstreams := make(map[string]*proxy.Proxy)
 
-	w := f.DNSServers.Watch()
+	v := event.Combine2(&f.DNSServers, &f.EncryptedUpstreams, func(addrs []string, encrypted []Upstream) []Upstream {
+		if len(encrypted) != 0 {
+			return encrypted
+		}
+		return upstreamsFromServers(addrs)
+	})
+	w := v.Watch()
 	defer w.Close()
 	for {
-		addrs, err := w.Get(ctx)
+		specs, err := w.Get(ctx)
 		if err != nil {
 			for _, p := range upstreams {
 				p.Stop()
@@ -83,39 +101,46 @@ func (f *Forward) Run(ctx context.Context) error {
 			return err
 		}
 
-		if len(addrs) > maxUpstreams {
-			addrs = addrs[:maxUpstreams]
+		if len(specs) > maxUpstreams {
+			specs = specs[:maxUpstreams]
 		}
 
-		if slices.Equal(addrs, lastAddrs) {
+		keys := upstreamKeys(specs)
+		if slices.Equal(keys, lastKeys) {
 			continue
 		}
-		lastAddrs = addrs
-		supervisor.Logger(ctx).Infof("New upstream DNS servers: %s", addrs)
-
-		newAddrs := make(map[string]bool)
-		for _, addr := range addrs {
-			newAddrs[addr] = true
+		lastKeys = keys
+		names := make([]string, len(specs))
+		for i := range specs {
+			names[i] = specs[i].String()
 		}
-		var newUpstreams []*proxy.Proxy
-		for _, p := range upstreams {
-			if newAddrs[p.Addr()] {
-				delete(newAddrs, p.Addr())
-				newUpstreams = append(newUpstreams, p)
+		supervisor.Logger(ctx).Infof("New upstream DNS servers: %s", names)
+
+		newUpstreams := make(map[string]*proxy.Proxy)
+		var list []*proxy.Proxy
+		for i := range specs {
+			key := keys[i]
+			if _, ok := newUpstreams[key]; ok {
+				continue
+			}
+			p, ok := upstreams[key]
+			if ok {
+				delete(upstreams, key)
 			} else {
-				p.Stop()
+				p = specs[i].newProxy()
+				p.SetExpire(connectionExpire)
+				p.GetHealthchecker().SetRecursionDesired(true)
+				p.GetHealthchecker().SetDomain(".")
+				p.Start(healthcheckInterval)
 			}
+			newUpstreams[key] = p
+			list = append(list, p)
 		}
-		for newAddr := range newAddrs {
-			p := proxy.NewProxy(newAddr)
-			p.SetExpire(connectionExpire)
-			p.GetHealthchecker().SetRecursionDesired(true)
-			p.GetHealthchecker().SetDomain(".")
-			p.Start(healthcheckInterval)
-			newUpstreams = append(newUpstreams, p)
+		for _, p := range upstreams {
+			p.Stop()
 		}
 		upstreams = newUpstreams
-		f.upstreams.Store(&newUpstreams)
+		f.upstreams.Store(&list)
 	}
 }
 
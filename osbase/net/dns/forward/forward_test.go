@@ -130,6 +130,67 @@ func TestUpstreams(t *testing.T) {
 	expectReply(t, req, proxyReply{Answer: []dns.RR{answerRecord2}})
 }
 
+func upstreamAddrs(f *Forward) []string {
+	var addrs []string
+	if upstreams := f.upstreams.Load(); upstreams != nil {
+		for _, p := range *upstreams {
+			addrs = append(addrs, p.Addr())
+		}
+	}
+	return addrs
+}
+
+// TestEncryptedUpstreams tests that encrypted upstreams replace plain DNS
+// servers while they are configured.
+func TestEncryptedUpstreams(t *testing.T) {
+	forward := New()
+	supervisor.TestHarness(t, forward.Run)
+
+	forward.DNSServers.Set([]string{"[2001:db8::1]:53"})
+	time.Sleep(10 * time.Millisecond)
+	if got, want := upstreamAddrs(forward), []string{"[2001:db8::1]:53"}; !slices.Equal(got, want) {
+		t.Errorf("Want upstreams %v, got %v", want, got)
+	}
+
+	forward.EncryptedUpstreams.Set([]Upstream{
+		{Protocol: ProtocolTLS, Addr: "[2001:db8::2]:853", ServerName: "dns.example.com"},
+		{Protocol: ProtocolHTTPS, Addr: "[2001:db8::3]:443", ServerName: "dns.example.com"},
+		// Duplicates are ignored.
+		{Protocol: ProtocolTLS, Addr: "[2001:db8::2]:853", ServerName: "dns.example.com"},
+	})
+	time.Sleep(10 * time.Millisecond)
+	if got, want := upstreamAddrs(forward), []string{"[2001:db8::2]:853", "[2001:db8::3]:443"}; !slices.Equal(got, want) {
+		t.Errorf("Want upstreams %v, got %v", want, got)
+	}
+
+	// Plain DNS servers are ignored while encrypted upstreams are configured.
+	forward.DNSServers.Set([]string{"[2001:db8::4]:53"})
+	time.Sleep(10 * time.Millisecond)
+	if got, want := upstreamAddrs(forward), []string{"[2001:db8::2]:853", "[2001:db8::3]:443"}; !slices.Equal(got, want) {
+		t.Errorf("Want upstreams %v, got %v", want, got)
+	}
+
+	forward.EncryptedUpstreams.Set(nil)
+	time.Sleep(10 * time.Millisecond)
+	if got, want := upstreamAddrs(forward), []string{"[2001:db8::4]:53"}; !slices.Equal(got, want) {
+		t.Errorf("Want upstreams %v, got %v", want, got)
+	}
+}
+
+func TestUpstreamURL(t *testing.T) {
+	for _, tc := range []struct {
+		upstream Upstream
+		want     string
+	}{
+		{Upstream{Protocol: ProtocolHTTPS, Addr: "192.0.2.1:443", ServerName: "dns.example.com"}, "https://dns.example.com:443/dns-query"},
+		{Upstream{Protocol: ProtocolHTTPS, Addr: "[2001:db8::1]:8443", Path: "/resolve"}, "https://[2001:db8::1]:8443/resolve"},
+	} {
+		if got := tc.upstream.url(); got != tc.want {
+			t.Errorf("Want URL %q, got %q", tc.want, got)
+		}
+	}
+}
+
 // TestHealthcheck tests that if one of multiple upstreams is broken,
 // this upstream receives health check queries, and that client queries
 // succeed since they are retried on the good upstream.
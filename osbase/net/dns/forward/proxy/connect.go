@@ -84,7 +84,12 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 }
 
 // Connect selects an upstream, sends the request and waits for a response.
+// useTCP is ignored for DNS over TLS and DNS over HTTPS upstreams.
 func (p *Proxy) Connect(m *dns.Msg, useTCP bool) (*dns.Msg, error) {
+	if p.doh != nil {
+		return p.doh.exchange(m, p.writeTimeout+p.readTimeout)
+	}
+
 	proto := "udp"
 	if useTCP {
 		proto = "tcp"
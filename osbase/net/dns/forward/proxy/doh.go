@@ -0,0 +1,114 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohMediaType is the media type of DNS messages sent over HTTPS, see RFC
+// 8484, Section 6.
+const dohMediaType = "application/dns-message"
+
+// dohTransport sends DNS queries to an upstream using DNS over HTTPS as
+// specified in RFC 8484. Connections are always made to the configured
+// address, so that the host name in the URL does not need to be resolved,
+// which would otherwise require a working DNS resolver. HTTP/2 is used to
+// multiplex concurrent queries over a single connection.
+type dohTransport struct {
+	url       string
+	transport *http.Transport
+	client    *http.Client
+}
+
+func newDoHTransport(addr, url string, cfg *tls.Config) *dohTransport {
+	dialer := &net.Dialer{Timeout: maxDialTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig:     cfg,
+		TLSHandshakeTimeout: maxDialTimeout,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     defaultExpire,
+	}
+	return &dohTransport{
+		url:       url,
+		transport: transport,
+		client:    &http.Client{Transport: transport},
+	}
+}
+
+// exchange sends m to the upstream and returns the reply. The ID of m is set
+// to zero as recommended by RFC 8484, Section 4.1.
+func (t *dohTransport) exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	m.Id = 0
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %w", os.ErrDeadlineExceeded, err)
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %q", res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != dohMediaType {
+		return nil, fmt.Errorf("unexpected content type %q", ct)
+	}
+	// DNS messages are at most 64 KiB in size.
+	body, err := io.ReadAll(io.LimitReader(res.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %w", os.ErrDeadlineExceeded, err)
+		}
+		return nil, err
+	}
+	if len(body) > dns.MaxMsgSize {
+		return nil, fmt.Errorf("reply exceeds maximum DNS message size")
+	}
+
+	ret := new(dns.Msg)
+	if err := ret.Unpack(body); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// setExpire sets the time after which idle connections are closed.
+func (t *dohTransport) setExpire(expire time.Duration) {
+	t.transport.IdleConnTimeout = expire
+}
+
+// close closes all idle connections.
+func (t *dohTransport) close() {
+	t.transport.CloseIdleConnections()
+}
@@ -0,0 +1,251 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"source.monogon.dev/osbase/net/dns/test"
+)
+
+// testCertificate returns a self-signed certificate for dns.example.com and
+// the loopback addresses.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.com"},
+		DNSNames:              []string{"dns.example.com"},
+		IPAddresses:           []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// newDoHServer starts a DNS over HTTPS server which answers queries with
+// handler. It fails the test if a request is not made over HTTP/2.
+func newDoHServer(t *testing.T, cert tls.Certificate, handler func(r *dns.Msg) *dns.Msg) *httptest.Server {
+	t.Helper()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("Expected HTTP/2 request, got %s", r.Proto)
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		buf, err := handler(req).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(buf)
+	}))
+	s.EnableHTTP2 = true
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	s.StartTLS()
+	return s
+}
+
+func TestDoHProxy(t *testing.T) {
+	cert, pool := testCertificate(t)
+	s := newDoHServer(t, cert, func(r *dns.Msg) *dns.Msg {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.RR("example.org. IN A 127.0.0.1"))
+		return ret
+	})
+	defer s.Close()
+
+	// The host in the URL is not resolvable, the connection must be made to
+	// the given address.
+	addr := s.Listener.Addr().String()
+	p := NewDoHProxy(addr, "https://dns.example.com/dns-query", &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    pool,
+	})
+	p.Start(5 * time.Second)
+	defer p.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	resp, err := p.Connect(m, false)
+	if err != nil {
+		t.Fatalf("Failed to connect to DoH server: %v", err)
+	}
+	if x := resp.Answer[0].Header().Name; x != "example.org." {
+		t.Errorf("Expected %s, got %s", "example.org.", x)
+	}
+}
+
+func TestDoHProxyUntrusted(t *testing.T) {
+	cert, _ := testCertificate(t)
+	_, otherPool := testCertificate(t)
+	s := newDoHServer(t, cert, func(r *dns.Msg) *dns.Msg {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		return ret
+	})
+	defer s.Close()
+
+	p := NewDoHProxy(s.Listener.Addr().String(), "https://dns.example.com/dns-query", &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    otherPool,
+	})
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	var certErr *tls.CertificateVerificationError
+	if _, err := p.Connect(m, false); !errors.As(err, &certErr) {
+		t.Errorf("Expected certificate verification error, got %v", err)
+	}
+}
+
+func TestDoHProxyTimeout(t *testing.T) {
+	cert, pool := testCertificate(t)
+	done := make(chan struct{})
+	s := newDoHServer(t, cert, func(r *dns.Msg) *dns.Msg {
+		<-done
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		return ret
+	})
+	defer s.Close()
+	defer close(done)
+
+	p := NewDoHProxy(s.Listener.Addr().String(), "https://dns.example.com/dns-query", &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    pool,
+	})
+	p.readTimeout = 50 * time.Millisecond
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if _, err := p.Connect(m, false); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestDoHHealth(t *testing.T) {
+	cert, pool := testCertificate(t)
+	var i atomic.Uint32
+	s := newDoHServer(t, cert, func(r *dns.Msg) *dns.Msg {
+		if r.Question[0].Name == "." && r.RecursionDesired {
+			i.Add(1)
+		}
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		return ret
+	})
+	defer s.Close()
+
+	p := NewDoHProxy(s.Listener.Addr().String(), "https://dns.example.com/dns-query", &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    pool,
+	})
+	if err := p.GetHealthchecker().Check(p); err != nil {
+		t.Errorf("check failed: %v", err)
+	}
+	if got := i.Load(); got != 1 {
+		t.Errorf("Expected number of health checks with RecursionDesired==true to be %d, got %d", 1, got)
+	}
+	if got := p.Fails(); got != 0 {
+		t.Errorf("Expected no fails, got %d", got)
+	}
+
+	s.Close()
+	if err := p.GetHealthchecker().Check(p); err == nil {
+		t.Errorf("Expected check to fail after server was closed")
+	}
+	if got := p.Fails(); got != 1 {
+		t.Errorf("Expected 1 fail, got %d", got)
+	}
+}
+
+func TestDoTProxy(t *testing.T) {
+	cert, pool := testCertificate(t)
+	l, err := tls.Listen("tcp", "[::1]:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{
+		Listener: l,
+		Net:      "tcp-tls",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			ret := new(dns.Msg)
+			ret.SetReply(r)
+			ret.Answer = append(ret.Answer, test.RR("example.org. IN A 127.0.0.1"))
+			w.WriteMsg(ret)
+		}),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.ActivateAndServe()
+	<-started
+	defer s.Shutdown()
+
+	p := NewProxy(l.Addr().String())
+	p.SetTLSConfig(&tls.Config{ServerName: "dns.example.com", RootCAs: pool})
+	p.Start(5 * time.Second)
+	defer p.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	// Queries which would use UDP are sent over TLS as well.
+	resp, err := p.Connect(m, false)
+	if err != nil {
+		t.Fatalf("Failed to connect to DoT server: %v", err)
+	}
+	if x := resp.Answer[0].Header().Name; x != "example.org." {
+		t.Errorf("Expected %s, got %s", "example.org.", x)
+	}
+	if err := p.GetHealthchecker().Check(p); err != nil {
+		t.Errorf("check failed: %v", err)
+	}
+}
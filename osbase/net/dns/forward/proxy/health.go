@@ -7,6 +7,7 @@ package proxy
 
 import (
 	"crypto/tls"
+	"errors"
 	"sync/atomic"
 	"time"
 
@@ -111,12 +112,19 @@ func (h *dnsHc) Check(p *Proxy) error {
 }
 
 func (h *dnsHc) send(addr string) error {
+	m, _, err := h.c.Exchange(healthcheckPing(h.domain, h.recursionDesired), addr)
+	return healthcheckResult(m, err)
+}
+
+func healthcheckPing(domain string, recursionDesired bool) *dns.Msg {
 	ping := new(dns.Msg)
-	ping.SetQuestion(h.domain, dns.TypeNS)
-	ping.MsgHdr.RecursionDesired = h.recursionDesired
+	ping.SetQuestion(domain, dns.TypeNS)
+	ping.MsgHdr.RecursionDesired = recursionDesired
 	ping.SetEdns0(AdvertiseUDPSize, false)
+	return ping
+}
 
-	m, _, err := h.c.Exchange(ping, addr)
+func healthcheckResult(m *dns.Msg, err error) error {
 	// If we got a header, we're alright,
 	// basically only care about I/O errors 'n stuff.
 	if err != nil && m != nil {
@@ -128,3 +136,82 @@ func (h *dnsHc) send(addr string) error {
 
 	return err
 }
+
+// dohHc is a health checker for a DNS over HTTPS endpoint. It sends the same
+// query as dnsHc, but over the DoH transport of the proxy being checked.
+type dohHc struct {
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	recursionDesired bool
+	domain           string
+}
+
+// NewDoHHealthChecker returns a new HealthChecker for DNS over HTTPS proxies.
+func NewDoHHealthChecker(recursionDesired bool, domain string) HealthChecker {
+	return &dohHc{
+		readTimeout:      1 * time.Second,
+		writeTimeout:     1 * time.Second,
+		recursionDesired: recursionDesired,
+		domain:           domain,
+	}
+}
+
+// SetTLSConfig does nothing, the TLS config of the proxy's DoH transport is
+// used instead.
+func (h *dohHc) SetTLSConfig(cfg *tls.Config) {}
+
+func (h *dohHc) GetTLSConfig() *tls.Config {
+	return nil
+}
+
+func (h *dohHc) SetRecursionDesired(recursionDesired bool) {
+	h.recursionDesired = recursionDesired
+}
+func (h *dohHc) GetRecursionDesired() bool {
+	return h.recursionDesired
+}
+
+func (h *dohHc) SetDomain(domain string) {
+	h.domain = domain
+}
+func (h *dohHc) GetDomain() string {
+	return h.domain
+}
+
+// SetTCPTransport does nothing, DNS over HTTPS always uses TCP.
+func (h *dohHc) SetTCPTransport() {}
+
+func (h *dohHc) GetReadTimeout() time.Duration {
+	return h.readTimeout
+}
+
+func (h *dohHc) SetReadTimeout(t time.Duration) {
+	h.readTimeout = t
+}
+
+func (h *dohHc) GetWriteTimeout() time.Duration {
+	return h.writeTimeout
+}
+
+func (h *dohHc) SetWriteTimeout(t time.Duration) {
+	h.writeTimeout = t
+}
+
+// Check is used as the up.Func in the up.Probe.
+func (h *dohHc) Check(p *Proxy) error {
+	var err error
+	if p.doh == nil {
+		err = errors.New("not a DNS over HTTPS proxy")
+	} else {
+		m, exErr := p.doh.exchange(healthcheckPing(h.domain, h.recursionDesired), h.writeTimeout+h.readTimeout)
+		err = healthcheckResult(m, exErr)
+	}
+	if err != nil {
+		healthcheckFailureCount.WithLabelValues(p.addr).Inc()
+		p.incrementFails()
+		return err
+	}
+
+	atomic.StoreUint32(&p.fails, 0)
+	return nil
+}
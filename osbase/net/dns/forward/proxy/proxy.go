@@ -20,6 +20,9 @@ type Proxy struct {
 	addr  string
 
 	transport *Transport
+	// doh is set if this proxy uses DNS over HTTPS, in which case transport
+	// is unused.
+	doh *dohTransport
 
 	writeTimeout time.Duration
 	readTimeout  time.Duration
@@ -45,17 +48,44 @@ func NewProxy(addr string) *Proxy {
 	return p
 }
 
+// NewDoHProxy returns a new proxy which sends queries to the DNS over HTTPS
+// endpoint at url. Connections are made to addr instead of the host in url,
+// which is only used for the TLS server name (unless overridden in cfg) and
+// the HTTP Host header.
+func NewDoHProxy(addr, url string, cfg *tls.Config) *Proxy {
+	p := &Proxy{
+		addr:         addr,
+		fails:        0,
+		probe:        up.New(),
+		writeTimeout: 2 * time.Second,
+		readTimeout:  2 * time.Second,
+		transport:    newTransport(addr),
+		doh:          newDoHTransport(addr, url, cfg),
+		health:       NewDoHHealthChecker(true, "."),
+	}
+
+	runtime.SetFinalizer(p, (*Proxy).finalizer)
+	return p
+}
+
 func (p *Proxy) Addr() string { return p.addr }
 
 // SetTLSConfig sets the TLS config in the lower p.transport
-// and in the healthchecking client.
+// and in the healthchecking client. This enables DNS over TLS. It has no
+// effect on DNS over HTTPS proxies, which get their TLS config on creation.
 func (p *Proxy) SetTLSConfig(cfg *tls.Config) {
 	p.transport.SetTLSConfig(cfg)
 	p.health.SetTLSConfig(cfg)
 }
 
 // SetExpire sets the expire duration in the lower p.transport.
-func (p *Proxy) SetExpire(expire time.Duration) { p.transport.SetExpire(expire) }
+func (p *Proxy) SetExpire(expire time.Duration) {
+	if p.doh != nil {
+		p.doh.setExpire(expire)
+		return
+	}
+	p.transport.SetExpire(expire)
+}
 
 func (p *Proxy) GetHealthchecker() HealthChecker {
 	return p.health
@@ -87,13 +117,22 @@ func (p *Proxy) Down(maxfails uint32) bool {
 }
 
 // Stop close stops the health checking goroutine.
-func (p *Proxy) Stop()      { p.probe.Stop() }
-func (p *Proxy) finalizer() { p.transport.Stop() }
+func (p *Proxy) Stop() { p.probe.Stop() }
+
+func (p *Proxy) finalizer() {
+	if p.doh != nil {
+		p.doh.close()
+		return
+	}
+	p.transport.Stop()
+}
 
 // Start starts the proxy's healthchecking.
 func (p *Proxy) Start(duration time.Duration) {
 	p.probe.Start(duration)
-	p.transport.Start()
+	if p.doh == nil {
+		p.transport.Start()
+	}
 }
 
 func (p *Proxy) SetReadTimeout(duration time.Duration) {
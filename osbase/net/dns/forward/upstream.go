@@ -0,0 +1,146 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package forward
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+
+	"source.monogon.dev/osbase/net/dns/forward/proxy"
+)
+
+// Protocol is the protocol used to reach an upstream DNS server.
+type Protocol int
+
+const (
+	// ProtocolDNS is plain DNS over UDP and TCP.
+	ProtocolDNS Protocol = iota
+	// ProtocolTLS is DNS over TLS as specified in RFC 7858.
+	ProtocolTLS
+	// ProtocolHTTPS is DNS over HTTPS as specified in RFC 8484.
+	ProtocolHTTPS
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolDNS:
+		return "dns"
+	case ProtocolTLS:
+		return "tls"
+	case ProtocolHTTPS:
+		return "https"
+	}
+	return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+// Upstream describes an upstream DNS server.
+type Upstream struct {
+	Protocol Protocol
+	// Addr is the IP address and port of the server. Connections are always
+	// made to this address, as names cannot be resolved before upstream
+	// servers are available.
+	Addr string
+	// ServerName is the name which the server's certificate is verified
+	// against. It is also sent as SNI and, for ProtocolHTTPS, as HTTP Host.
+	// If empty, the certificate is verified against the IP address in Addr.
+	// Unused for ProtocolDNS.
+	ServerName string
+	// RootCAs are the certificate authorities which the server's certificate
+	// must chain up to. If empty, the system's trusted CAs are used. Unused
+	// for ProtocolDNS.
+	RootCAs []*x509.Certificate
+	// Path is the URL path of the DNS over HTTPS endpoint. If empty,
+	// /dns-query is used. Only used for ProtocolHTTPS.
+	Path string
+}
+
+func (u *Upstream) String() string {
+	switch u.Protocol {
+	case ProtocolDNS:
+		return u.Addr
+	case ProtocolHTTPS:
+		return fmt.Sprintf("%s (%s)", u.url(), u.Addr)
+	}
+	if u.ServerName != "" {
+		return fmt.Sprintf("%s://%s (%s)", u.Protocol, u.ServerName, u.Addr)
+	}
+	return fmt.Sprintf("%s://%s", u.Protocol, u.Addr)
+}
+
+// key returns a string which uniquely identifies the upstream, including its
+// trusted CAs. Upstreams with the same key can share a proxy.
+func (u *Upstream) key() string {
+	h := sha256.New()
+	for _, ca := range u.RootCAs {
+		h.Write(ca.Raw)
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%x", u.Protocol, u.Addr, u.ServerName, u.Path, h.Sum(nil))
+}
+
+func (u *Upstream) url() string {
+	host, port, _ := net.SplitHostPort(u.Addr)
+	if u.ServerName != "" {
+		host = u.ServerName
+	}
+	path := u.Path
+	if path == "" {
+		path = "/dns-query"
+	}
+	return (&url.URL{Scheme: "https", Host: net.JoinHostPort(host, port), Path: path}).String()
+}
+
+func (u *Upstream) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		ServerName: u.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(u.Addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	if len(u.RootCAs) != 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		for _, ca := range u.RootCAs {
+			cfg.RootCAs.AddCert(ca)
+		}
+	}
+	return cfg
+}
+
+// newProxy creates an unstarted proxy for the upstream.
+func (u *Upstream) newProxy() *proxy.Proxy {
+	switch u.Protocol {
+	case ProtocolTLS:
+		p := proxy.NewProxy(u.Addr)
+		p.SetTLSConfig(u.tlsConfig())
+		return p
+	case ProtocolHTTPS:
+		return proxy.NewDoHProxy(u.Addr, u.url(), u.tlsConfig())
+	default:
+		return proxy.NewProxy(u.Addr)
+	}
+}
+
+// upstreamsFromServers returns plain DNS upstreams for the given addresses.
+func upstreamsFromServers(addrs []string) []Upstream {
+	upstreams := make([]Upstream, 0, len(addrs))
+	for _, addr := range addrs {
+		upstreams = append(upstreams, Upstream{Protocol: ProtocolDNS, Addr: addr})
+	}
+	return upstreams
+}
+
+// upstreamKeys returns the keys of the given upstreams.
+func upstreamKeys(upstreams []Upstream) []string {
+	keys := make([]string, 0, len(upstreams))
+	for _, u := range upstreams {
+		keys = append(keys, u.key())
+	}
+	return keys
+}
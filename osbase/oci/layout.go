@@ -84,6 +84,21 @@ func (r *layoutBlobs) Blob(descriptor *ocispecv1.Descriptor) (io.ReadCloser, err
 	return os.Open(blobPath)
 }
 
+func (r *layoutBlobs) BlobRange(descriptor *ocispecv1.Descriptor, offset, length int64) (io.ReadCloser, error) {
+	blobPath, err := layoutBlobPath(r.path, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{
+		Reader: io.NewSectionReader(file, offset, length),
+		Closer: file,
+	}, nil
+}
+
 func layoutBlobPath(layoutPath string, descriptor *ocispecv1.Descriptor) (string, error) {
 	algorithm, encoded, err := ParseDigest(string(descriptor.Digest))
 	if err != nil {
@@ -37,6 +37,15 @@ type Blobs interface {
 	Blob(*ocispecv1.Descriptor) (io.ReadCloser, error)
 }
 
+// RangeBlobs is an optional interface which image sources can implement if they
+// support efficiently retrieving a part of a blob.
+type RangeBlobs interface {
+	// BlobRange returns length bytes starting at offset of the contents of a
+	// blob from its descriptor. The range has already been validated against
+	// the descriptor size. It does not verify the contents against the digest.
+	BlobRange(descriptor *ocispecv1.Descriptor, offset, length int64) (io.ReadCloser, error)
+}
+
 // NewImage verifies the manifest against the expected digest if not empty,
 // then parses it and returns an [Image].
 func NewImage(rawManifest []byte, expectedDigest string, blobs Blobs) (*Image, error) {
@@ -94,6 +103,41 @@ func (i *Image) Blob(descriptor *ocispecv1.Descriptor) (io.ReadCloser, error) {
 	return i.blobs.Blob(descriptor)
 }
 
+// BlobRange returns length bytes starting at offset of the contents of a blob
+// from its descriptor. If the image source does not implement [RangeBlobs], the
+// blob is read from the start and the bytes before offset are discarded.
+// It does not verify the contents against the digest.
+func (i *Image) BlobRange(descriptor *ocispecv1.Descriptor, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 || offset+length > descriptor.Size {
+		return nil, fmt.Errorf("invalid range [%d, %d) for blob of size %d", offset, offset+length, descriptor.Size)
+	}
+	if int64(len(descriptor.Data)) == descriptor.Size {
+		return structfs.Bytes(descriptor.Data[offset : offset+length]).Open()
+	} else if len(descriptor.Data) != 0 {
+		return nil, fmt.Errorf("descriptor has embedded data of wrong length")
+	}
+	if rangeBlobs, ok := i.blobs.(RangeBlobs); ok {
+		return rangeBlobs.BlobRange(descriptor, offset, length)
+	}
+	blob, err := i.blobs.Blob(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, blob, offset); err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{
+		Reader: io.LimitReader(blob, length),
+		Closer: blob,
+	}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // ReadBlobVerified reads a blob into a byte slice and verifies it against the
 // digest.
 func (i *Image) ReadBlobVerified(descriptor *ocispecv1.Descriptor) ([]byte, error) {
@@ -13,6 +13,7 @@ import (
 	"io"
 
 	"github.com/klauspost/compress/zstd"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"source.monogon.dev/osbase/oci"
 	"source.monogon.dev/osbase/structfs"
@@ -115,6 +116,99 @@ func (i *Image) PayloadUnverified(name string) (structfs.Blob, error) {
 	return nil, fmt.Errorf("payload %q not found", name)
 }
 
+// PayloadChunked returns the payload of the given name for reading individual
+// chunks. This only works for uncompressed images, as chunks of a compressed
+// payload cannot be fetched independently.
+func (i *Image) PayloadChunked(name string) (*ChunkedPayload, error) {
+	for pi := range i.Config.Payloads {
+		info := &i.Config.Payloads[pi]
+		if info.Name == name {
+			layer := &i.image.Manifest.Layers[pi]
+			if layer.MediaType != MediaTypePayloadUncompressed {
+				return nil, fmt.Errorf("unsupported media type %q for chunked payload", layer.MediaType)
+			}
+			if layer.Size != info.Size {
+				return nil, fmt.Errorf("payload %q has size %d but layer has size %d", name, info.Size, layer.Size)
+			}
+			payload := &ChunkedPayload{
+				Info:       info,
+				image:      i.image,
+				descriptor: layer,
+			}
+			return payload, nil
+		}
+	}
+	return nil, fmt.Errorf("payload %q not found", name)
+}
+
+// ChunkedPayload allows reading ranges of chunks of a payload, for example to
+// only fetch chunks which are not already available locally. All data is
+// verified against hashes in the config before it is returned.
+type ChunkedPayload struct {
+	// Info describes the payload.
+	Info *PayloadInfo
+
+	image      *oci.Image
+	descriptor *ocispecv1.Descriptor
+}
+
+// ChunkCount returns the number of chunks of the payload.
+func (p *ChunkedPayload) ChunkCount() int {
+	return len(p.Info.ChunkHashesSHA256)
+}
+
+// ChunkRange returns the offset and length of the chunk with the given index.
+func (p *ChunkedPayload) ChunkRange(index int) (offset, length int64) {
+	offset = int64(index) * p.Info.HashChunkSize
+	length = min(p.Info.HashChunkSize, p.Info.Size-offset)
+	return
+}
+
+// ChunkHash returns the SHA256 hash of the chunk with the given index.
+func (p *ChunkedPayload) ChunkHash(index int) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	n, err := base64.RawStdEncoding.Decode(hash[:], []byte(p.Info.ChunkHashesSHA256[index]))
+	if err != nil {
+		return hash, fmt.Errorf("invalid chunk hash: %w", err)
+	}
+	if n != sha256.Size {
+		return hash, fmt.Errorf("invalid chunk hash length %d", n)
+	}
+	return hash, nil
+}
+
+// ReadChunks reads count consecutive chunks starting at the chunk with index
+// first, and returns their concatenated contents after verifying them.
+func (p *ChunkedPayload) ReadChunks(first, count int) ([]byte, error) {
+	if first < 0 || count <= 0 || first+count > p.ChunkCount() {
+		return nil, fmt.Errorf("invalid chunk range [%d, %d) for payload with %d chunks", first, first+count, p.ChunkCount())
+	}
+	offset, _ := p.ChunkRange(first)
+	lastOffset, lastLength := p.ChunkRange(first + count - 1)
+	length := lastOffset + lastLength - offset
+	blob, err := p.image.BlobRange(p.descriptor, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	content := make([]byte, length)
+	if _, err := io.ReadFull(blob, content); err != nil {
+		return nil, err
+	}
+	for i := range count {
+		chunkOffset, chunkLength := p.ChunkRange(first + i)
+		chunkOffset -= offset
+		expectedHash, err := p.ChunkHash(first + i)
+		if err != nil {
+			return nil, err
+		}
+		if sha256.Sum256(content[chunkOffset:chunkOffset+chunkLength]) != expectedHash {
+			return nil, fmt.Errorf("payload failed verification against hash of chunk %d", first+i)
+		}
+	}
+	return content, nil
+}
+
 type payloadBlob struct {
 	raw       structfs.Blob
 	mediaType string
@@ -101,6 +101,55 @@ func TestRead(t *testing.T) {
 	}
 }
 
+func TestPayloadChunked(t *testing.T) {
+	expectedPayload, err := os.ReadFile(xTestPayloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := oci.ReadLayout(xImageUncompressedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	osImage, err := Read(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := osImage.PayloadChunked("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := payload.ChunkCount()
+	if count < 3 {
+		t.Fatalf("test payload has only %d chunks", count)
+	}
+	for _, r := range [][2]int{{0, 1}, {1, count - 2}, {count - 1, 1}} {
+		content, err := payload.ReadChunks(r[0], r[1])
+		if err != nil {
+			t.Fatalf("ReadChunks(%d, %d): %v", r[0], r[1], err)
+		}
+		offset, _ := payload.ChunkRange(r[0])
+		if !bytes.Equal(content, expectedPayload[offset:offset+int64(len(content))]) {
+			t.Errorf("ReadChunks(%d, %d) returned wrong content", r[0], r[1])
+		}
+	}
+	if _, err := payload.ReadChunks(count-1, 2); err == nil {
+		t.Error("Expected ReadChunks past the end to fail")
+	}
+
+	image, err = oci.ReadLayout(xImagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	osImage, err = Read(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := osImage.PayloadChunked("test"); err == nil {
+		t.Error("Expected PayloadChunked of compressed payload to fail")
+	}
+}
+
 func TestVerification(t *testing.T) {
 	server := registry.NewServer()
 	srcImage, err := oci.ReadLayout(xImageUncompressedPath)
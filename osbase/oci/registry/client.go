@@ -2,11 +2,14 @@
 // SPDX-License-Identifier: Apache-2.0
 
 // Package registry contains a client and server implementation of the OCI
-// Distribution spec. Both client and server only support pulling. The server is
-// intended for use in tests.
+// Distribution spec. The client supports pulling, and pushing and deleting
+// single-manifest artifacts. The server only supports pulling, and serves
+// images which are already available locally, for example in tests or to
+// distribute images between nodes of a cluster.
 package registry
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -109,7 +112,7 @@ func (c *Client) Read(ctx context.Context, tag, digest string) (*oci.Image, erro
 			return err
 		}
 		req.Header.Set("Accept", ocispecv1.MediaTypeImageManifest)
-		resp, err := c.doGet(ctx, req)
+		resp, err := c.do(ctx, req)
 		if err != nil {
 			return err
 		}
@@ -147,7 +150,7 @@ func (r *clientBlobs) Blob(descriptor *ocispecv1.Descriptor) (io.ReadCloser, err
 		if err != nil {
 			return err
 		}
-		resp, err = r.client.doGet(r.ctx, req)
+		resp, err = r.client.do(r.ctx, req)
 		if err != nil {
 			return err
 		}
@@ -169,66 +172,112 @@ func (r *clientBlobs) Blob(descriptor *ocispecv1.Descriptor) (io.ReadCloser, err
 		client: r.client,
 		path:   blobPath,
 		pos:    0,
+		end:    descriptor.Size,
 		size:   descriptor.Size,
 	}
 	reader.resp.Store(resp)
 	return reader, nil
 }
 
+// BlobRange implements [oci.RangeBlobs] with HTTP range requests. If the
+// registry ignores the range and returns the full blob, the bytes before the
+// range are discarded.
+func (r *clientBlobs) BlobRange(descriptor *ocispecv1.Descriptor, offset, length int64) (io.ReadCloser, error) {
+	if !DigestRegexp.MatchString(string(descriptor.Digest)) {
+		return nil, fmt.Errorf("invalid blob digest %q", descriptor.Digest)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	ctx, cancel := context.WithCancelCause(r.ctx)
+	reader := &retryReader{
+		ctx:    ctx,
+		cancel: cancel,
+		client: r.client,
+		path:   fmt.Sprintf("/v2/%s/blobs/%s", r.client.Repository, descriptor.Digest),
+		pos:    offset,
+		end:    offset + length,
+		size:   descriptor.Size,
+	}
+	err := r.client.retry(ctx, reader.open)
+	if err != nil {
+		cancel(err)
+		return nil, err
+	}
+	return reader, nil
+}
+
+// retryReader reads the range [pos, end) of a blob of the given size. If the
+// response body fails before reaching the end, the remaining part is requested
+// again with a Range header.
 type retryReader struct {
 	ctx    context.Context
 	cancel context.CancelCauseFunc
 	client *Client
 	path   string
 	pos    int64
+	end    int64
 	size   int64
 	// resp is an atomic pointer because it may be concurrently written by Read()
 	// and read by Close().
 	resp atomic.Pointer[http.Response]
 }
 
+// open requests the remaining range of the blob. It is called from within
+// client.retry.
+func (r *retryReader) open() error {
+	req, err := r.client.newGet(r.path)
+	if err != nil {
+		return err
+	}
+	if r.end != r.size {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.pos, r.end-1))
+	} else if r.pos != 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.pos))
+	}
+	resp, err := r.client.do(r.ctx, req)
+	if err != nil {
+		return err
+	}
+	r.resp.Store(resp)
+	if err := context.Cause(r.ctx); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, err := io.CopyN(io.Discard, resp.Body, r.pos)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.pos)) {
+			resp.Body.Close()
+			return backoff.Permanent(errors.New("invalid content range"))
+		}
+	default:
+		return readClientError(resp, req)
+	}
+	return nil
+}
+
 func (r *retryReader) Read(p []byte) (n int, err error) {
-	if r.pos >= r.size {
+	if r.pos >= r.end {
 		return 0, io.EOF
 	}
 	if len(p) == 0 {
 		return 0, nil
 	}
-	if int64(len(p)) > r.size-r.pos {
-		p = p[:r.size-r.pos]
+	if int64(len(p)) > r.end-r.pos {
+		p = p[:r.end-r.pos]
 	}
 	closed := false
 	err = r.client.retry(r.ctx, func() error {
 		if closed {
-			req, err := r.client.newGet(r.path)
-			if err != nil {
-				return err
-			}
-			if r.pos != 0 {
-				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.pos))
-			}
-			resp, err := r.client.doGet(r.ctx, req)
-			if err != nil {
+			if err := r.open(); err != nil {
 				return err
 			}
-			r.resp.Store(resp)
-			if err := context.Cause(r.ctx); err != nil {
-				resp.Body.Close()
-				return err
-			}
-			switch resp.StatusCode {
-			case http.StatusOK:
-				_, err := io.CopyN(io.Discard, resp.Body, r.pos)
-				if err != nil {
-					return err
-				}
-			case http.StatusPartialContent:
-				if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.pos)) {
-					return backoff.Permanent(errors.New("invalid content range"))
-				}
-			default:
-				return readClientError(resp, req)
-			}
 		}
 		var err error
 		n, err = r.resp.Load().Body.Read(p)
@@ -243,7 +292,7 @@ func (r *retryReader) Read(p []byte) (n int, err error) {
 		r.resp.Load().Body.Close()
 		return err
 	})
-	if r.pos >= r.size {
+	if r.pos >= r.end {
 		err = io.EOF
 	} else if err == io.EOF {
 		err = io.ErrUnexpectedEOF
@@ -309,7 +358,15 @@ func (c *Client) newGet(path string) (*http.Request, error) {
 		Host:   c.Host,
 		Path:   path,
 	}
-	req, err := http.NewRequest("GET", u.String(), nil)
+	return c.newRequest("GET", u.String(), nil)
+}
+
+func (c *Client) newRequest(method, target string, body []byte) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target, bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -319,7 +376,7 @@ func (c *Client) newGet(path string) (*http.Request, error) {
 	return req, nil
 }
 
-func (c *Client) doGet(ctx context.Context, req *http.Request) (*http.Response, error) {
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
 	req = req.WithContext(ctx)
 	c.addAuthorization(req)
 	client := http.Client{Transport: c.Transport}
@@ -338,6 +395,12 @@ func (c *Client) doGet(ctx context.Context, req *http.Request) (*http.Response,
 			return nil, unauthorizedErr
 		}
 		c.addAuthorization(req)
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
 		resp, err = client.Do(req)
 		if err != nil {
 			return nil, redactURLError(err)
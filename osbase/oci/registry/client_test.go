@@ -4,8 +4,10 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
@@ -15,6 +17,7 @@ import (
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
 	"github.com/cenkalti/backoff/v4"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"source.monogon.dev/osbase/oci"
 )
@@ -81,6 +84,76 @@ func TestRetries(t *testing.T) {
 	}
 }
 
+func TestRange(t *testing.T) {
+	srcImage, err := oci.ReadLayout(xImagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.AddImage("test/repo", "test-tag", srcImage)
+	wrapper := &unreliableServer{
+		handler:   server,
+		blobLimit: 64 * 1024,
+		seen:      make(map[string]bool),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, wrapper)
+	wrapper.host = listener.Addr().String()
+
+	client := &Client{
+		GetBackOff: func() backoff.BackOff {
+			return backoff.NewExponentialBackOff(backoff.WithInitialInterval(time.Millisecond))
+		},
+		Scheme:     "http",
+		Host:       listener.Addr().String(),
+		Repository: "test/repo",
+	}
+
+	image, err := client.Read(context.Background(), "test-tag", srcImage.ManifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := &image.Manifest.Layers[0]
+	for _, r := range []struct {
+		offset, length int64
+	}{
+		{0, 1000},
+		{1000, 256 * 1024},
+		{layer.Size - 200*1024, 200 * 1024},
+		{layer.Size, 0},
+	} {
+		expected, err := readBlobRange(srcImage, layer, r.offset, r.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readBlobRange(image, layer, r.offset, r.length)
+		if err != nil {
+			t.Errorf("range [%d, %d): %v", r.offset, r.offset+r.length, err)
+			continue
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("range [%d, %d): content does not match", r.offset, r.offset+r.length)
+		}
+	}
+	if _, err := image.BlobRange(layer, layer.Size-10, 11); err == nil {
+		t.Error("Expected error for range exceeding blob size")
+	}
+}
+
+func readBlobRange(image *oci.Image, descriptor *ocispecv1.Descriptor, offset, length int64) ([]byte, error) {
+	blob, err := image.BlobRange(descriptor, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(blob)
+}
+
 type unreliableServer struct {
 	handler   http.Handler
 	host      string
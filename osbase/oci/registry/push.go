@@ -0,0 +1,235 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// PushBlob uploads a blob to the repository, unless the repository already
+// contains it. It returns the digest of the blob.
+func (c *Client) PushBlob(ctx context.Context, content []byte) (string, error) {
+	if !RepositoryRegexp.MatchString(c.Repository) {
+		return "", fmt.Errorf("invalid repository %q", c.Repository)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	base := url.URL{
+		Scheme: c.Scheme,
+		Host:   c.Host,
+	}
+
+	// Check if the blob already exists.
+	exists := false
+	err := c.retry(ctx, func() error {
+		u := base
+		u.Path = fmt.Sprintf("/v2/%s/blobs/%s", c.Repository, digest)
+		req, err := c.newRequest("HEAD", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			exists = true
+		case http.StatusNotFound:
+		default:
+			return readClientError(resp, req)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return digest, nil
+	}
+
+	// Start an upload session and upload the blob in a single request.
+	err = c.retry(ctx, func() error {
+		u := base
+		u.Path = fmt.Sprintf("/v2/%s/blobs/uploads/", c.Repository)
+		req, err := c.newRequest("POST", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			return readClientError(resp, req)
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return backoff.Permanent(fmt.Errorf("invalid upload location: %w", err))
+		}
+		query := location.Query()
+		query.Set("digest", digest)
+		location.RawQuery = query.Encode()
+
+		req, err = c.newRequest("PUT", location.String(), content)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err = c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return readClientError(resp, req)
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// PushManifest uploads a manifest to the repository and tags it. All blobs
+// referenced by the manifest must have been pushed before. It returns the
+// digest of the manifest.
+func (c *Client) PushManifest(ctx context.Context, tag, mediaType string, manifest []byte) (string, error) {
+	if !RepositoryRegexp.MatchString(c.Repository) {
+		return "", fmt.Errorf("invalid repository %q", c.Repository)
+	}
+	if !TagRegexp.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %q", tag)
+	}
+	u := url.URL{
+		Scheme: c.Scheme,
+		Host:   c.Host,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", c.Repository, tag),
+	}
+	err := c.retry(ctx, func() error {
+		req, err := c.newRequest("PUT", u.String(), manifest)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return readClientError(resp, req)
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)), nil
+}
+
+type tagList struct {
+	Tags []string `json:"tags"`
+}
+
+// linkNextRegexp matches the URL of a Link header with rel="next", which is
+// used by registries to paginate tag lists.
+var linkNextRegexp = regexp.MustCompile(`^\s*<([^>]+)>\s*;\s*rel="?next"?`)
+
+// ListTags returns all tags in the repository.
+func (c *Client) ListTags(ctx context.Context) ([]string, error) {
+	if !RepositoryRegexp.MatchString(c.Repository) {
+		return nil, fmt.Errorf("invalid repository %q", c.Repository)
+	}
+	next := &url.URL{
+		Scheme: c.Scheme,
+		Host:   c.Host,
+		Path:   fmt.Sprintf("/v2/%s/tags/list", c.Repository),
+	}
+	var tags []string
+	for next != nil {
+		var page tagList
+		var link string
+		err := c.retry(ctx, func() error {
+			req, err := c.newRequest("GET", next.String(), nil)
+			if err != nil {
+				return err
+			}
+			resp, err := c.do(ctx, req)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusOK {
+				return readClientError(resp, req)
+			}
+			defer resp.Body.Close()
+			body, err := readFullBody(resp, 10*1024*1024)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return backoff.Permanent(fmt.Errorf("failed to parse tag list: %w", err))
+			}
+			link = resp.Header.Get("Link")
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next = nil
+		if m := linkNextRegexp.FindStringSubmatch(link); m != nil {
+			ref, err := url.Parse(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Link header: %w", err)
+			}
+			next = (&url.URL{Scheme: c.Scheme, Host: c.Host}).ResolveReference(ref)
+		}
+	}
+	return tags, nil
+}
+
+// DeleteManifest deletes a manifest from the repository. The reference can
+// either be a digest, which deletes the manifest and all its tags, or a tag,
+// which only deletes the tag. Deleting by tag is not supported by all
+// registries.
+func (c *Client) DeleteManifest(ctx context.Context, reference string) error {
+	if !RepositoryRegexp.MatchString(c.Repository) {
+		return fmt.Errorf("invalid repository %q", c.Repository)
+	}
+	if !TagRegexp.MatchString(reference) && !DigestRegexp.MatchString(reference) {
+		return fmt.Errorf("invalid reference %q", reference)
+	}
+	u := url.URL{
+		Scheme: c.Scheme,
+		Host:   c.Host,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", c.Repository, reference),
+	}
+	return c.retry(ctx, func() error {
+		req, err := c.newRequest("DELETE", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+			return readClientError(resp, req)
+		}
+		resp.Body.Close()
+		return nil
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pushServer is a minimal in-memory registry which supports pushing, listing
+// tags (with pagination) and deleting manifests by tag.
+type pushServer struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func (s *pushServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/test/repo/")
+	switch {
+	case req.Method == "HEAD" && strings.HasPrefix(path, "blobs/"):
+		if _, ok := s.blobs[strings.TrimPrefix(path, "blobs/")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case req.Method == "GET" && strings.HasPrefix(path, "blobs/"):
+		blob, ok := s.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+	case req.Method == "POST" && path == "blobs/uploads/":
+		s.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/test/repo/blobs/uploads/%d?state=foo", s.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "PUT" && strings.HasPrefix(path, "blobs/uploads/"):
+		if req.URL.Query().Get("state") != "foo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(req.Body)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+		if req.URL.Query().Get("digest") != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blobs[digest] = content
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == "PUT" && strings.HasPrefix(path, "manifests/"):
+		content, _ := io.ReadAll(req.Body)
+		s.manifests[strings.TrimPrefix(path, "manifests/")] = content
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == "GET" && strings.HasPrefix(path, "manifests/"):
+		manifest, ok := s.manifests[strings.TrimPrefix(path, "manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ocispecv1.MediaTypeImageManifest)
+		w.Write(manifest)
+	case req.Method == "DELETE" && strings.HasPrefix(path, "manifests/"):
+		tag := strings.TrimPrefix(path, "manifests/")
+		if _, ok := s.manifests[tag]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.manifests, tag)
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "GET" && path == "tags/list":
+		// Return one tag per page.
+		var tags []string
+		for tag := range s.manifests {
+			tags = append(tags, tag)
+		}
+		slices.Sort(tags)
+		last := req.URL.Query().Get("last")
+		var page []string
+		for _, tag := range tags {
+			if tag > last {
+				page = append(page, tag)
+				break
+			}
+		}
+		if len(page) != 0 {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/test/repo/tags/list?n=1&last=%s>; rel="next"`, url.QueryEscape(page[0])))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"name": "test/repo", "tags": page})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestPush(t *testing.T) {
+	server := &pushServer{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := &Client{
+		Scheme:     "http",
+		Host:       strings.TrimPrefix(ts.URL, "http://"),
+		Repository: "test/repo",
+	}
+	ctx := context.Background()
+
+	layer := []byte("layer content")
+	layerDigest, err := client.PushBlob(ctx, layer)
+	if err != nil {
+		t.Fatalf("PushBlob: %v", err)
+	}
+	config := []byte("{}")
+	configDigest, err := client.PushBlob(ctx, config)
+	if err != nil {
+		t.Fatalf("PushBlob: %v", err)
+	}
+	// Pushing an existing blob must not upload it again.
+	if _, err := client.PushBlob(ctx, layer); err != nil {
+		t.Fatalf("PushBlob: %v", err)
+	}
+	if server.uploads != 2 {
+		t.Errorf("Got %d uploads, wanted 2", server.uploads)
+	}
+
+	manifest := ocispecv1.Manifest{
+		MediaType: ocispecv1.MediaTypeImageManifest,
+		Config: ocispecv1.Descriptor{
+			MediaType: ocispecv1.MediaTypeEmptyJSON,
+			Digest:    digest.Digest(configDigest),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispecv1.Descriptor{{
+			MediaType: "application/octet-stream",
+			Digest:    digest.Digest(layerDigest),
+			Size:      int64(len(layer)),
+		}},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"a", "b", "c"} {
+		if _, err := client.PushManifest(ctx, tag, ocispecv1.MediaTypeImageManifest, manifestBytes); err != nil {
+			t.Fatalf("PushManifest: %v", err)
+		}
+	}
+
+	// Read the pushed image back.
+	image, err := client.Read(ctx, "b", "")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	got, err := image.ReadBlobVerified(&image.Manifest.Layers[0])
+	if err != nil {
+		t.Fatalf("ReadBlobVerified: %v", err)
+	}
+	if string(got) != string(layer) {
+		t.Errorf("Got layer %q, wanted %q", got, layer)
+	}
+
+	if err := client.DeleteManifest(ctx, "b"); err != nil {
+		t.Fatalf("DeleteManifest: %v", err)
+	}
+	tags, err := client.ListTags(ctx)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if want := []string{"a", "c"}; !slices.Equal(tags, want) {
+		t.Errorf("Got tags %v, wanted %v", tags, want)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package signature implements verification of detached signatures of OCI
+// images, in the format produced by cosign with a key pair.
+//
+// The signature of an image is stored in the same repository as the image,
+// under a tag derived from the manifest digest of the image (see [Tag]). The
+// signature image contains one layer per signature. Each layer contains a
+// simple signing payload which names the signed manifest digest, and the
+// signature of that payload is stored base64-encoded in an annotation of the
+// layer descriptor.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"source.monogon.dev/osbase/oci"
+)
+
+const (
+	// MediaTypeSimpleSigning is the media type of signature layers.
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// AnnotationSignature is the annotation of a signature layer descriptor
+	// which contains the base64-encoded signature of the layer content.
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	payloadType = "cosign container image signature"
+	// maxPayloadSize is the maximum size of a signature payload. Payloads
+	// are small JSON documents, larger layers are not read.
+	maxPayloadSize = 64 * 1024
+)
+
+// ErrNoValidSignature is returned by [Verify] if the signature image does not
+// contain any signature of the image by any of the trusted keys.
+var ErrNoValidSignature = errors.New("no valid signature by a trusted key")
+
+// payload is the simple signing payload which is signed.
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// Tag returns the tag under which the signature of the image with the given
+// manifest digest is stored.
+func Tag(manifestDigest string) (string, error) {
+	algorithm, encoded, err := oci.ParseDigest(manifestDigest)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s.sig", algorithm, encoded), nil
+}
+
+// Payload returns a payload which, when signed, attests the image with the
+// given manifest digest. The reference is informational and is not checked
+// during verification.
+func Payload(reference, manifestDigest string) ([]byte, error) {
+	if _, _, err := oci.ParseDigest(manifestDigest); err != nil {
+		return nil, err
+	}
+	var p payload
+	p.Critical.Identity.DockerReference = reference
+	p.Critical.Image.DockerManifestDigest = manifestDigest
+	p.Critical.Type = payloadType
+	return json.Marshal(&p)
+}
+
+// ParsePublicKey parses a DER-encoded PKIX public key which can be used to
+// verify signatures. Supported are ECDSA keys on the P-256 curve, which is
+// the default of cosign, and Ed25519 keys.
+func ParsePublicKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return key, nil
+}
+
+// Verify checks that the signature image sig contains a valid signature of the
+// image with the given manifest digest by any of the given keys. The keys need
+// to be of a type returned by [ParsePublicKey].
+//
+// If the signature image contains no such signature, an error wrapping
+// [ErrNoValidSignature] is returned, which describes why the signatures
+// present were rejected.
+func Verify(sig *oci.Image, manifestDigest string, keys []crypto.PublicKey) error {
+	if _, _, err := oci.ParseDigest(manifestDigest); err != nil {
+		return err
+	}
+	var reasons []string
+	for i := range sig.Manifest.Layers {
+		layer := &sig.Manifest.Layers[i]
+		if layer.MediaType != MediaTypeSimpleSigning {
+			continue
+		}
+		if err := verifyLayer(sig, layer, manifestDigest, keys); err != nil {
+			reasons = append(reasons, fmt.Sprintf("signature %d: %v", i, err))
+			continue
+		}
+		return nil
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("%w: signature image contains no signatures", ErrNoValidSignature)
+	}
+	return fmt.Errorf("%w: %s", ErrNoValidSignature, strings.Join(reasons, "; "))
+}
+
+func verifyLayer(sig *oci.Image, layer *ocispecv1.Descriptor, manifestDigest string, keys []crypto.PublicKey) error {
+	sigB64, ok := layer.Annotations[AnnotationSignature]
+	if !ok {
+		return fmt.Errorf("missing %s annotation", AnnotationSignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if layer.Size > maxPayloadSize {
+		return fmt.Errorf("payload of size %d is too large", layer.Size)
+	}
+	content, err := sig.ReadBlobVerified(layer)
+	if err != nil {
+		return fmt.Errorf("could not read payload: %w", err)
+	}
+
+	var p payload
+	if err := json.Unmarshal(content, &p); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if p.Critical.Type != payloadType {
+		return fmt.Errorf("unexpected payload type %q", p.Critical.Type)
+	}
+	if got := p.Critical.Image.DockerManifestDigest; got != manifestDigest {
+		return fmt.Errorf("payload is for manifest %q, not %q", got, manifestDigest)
+	}
+
+	// Only check the signature after the payload was found to be for the right
+	// image, so that the error is more helpful.
+	for _, key := range keys {
+		if verifySignature(key, content, signature) {
+			return nil
+		}
+	}
+	return errors.New("not signed by any trusted key")
+}
+
+func verifySignature(key crypto.PublicKey, message, signature []byte) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	default:
+		return false
+	}
+}
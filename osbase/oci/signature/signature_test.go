@@ -0,0 +1,183 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"source.monogon.dev/osbase/oci"
+)
+
+const (
+	testDigest  = "sha256:345db5d8fc468218c5232bf54a1358b6825c28d658fa12c9a1edcc7539690686"
+	otherDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// testSignature is a payload and its signature, to be placed in a layer of a
+// signature image.
+type testSignature struct {
+	payload   []byte
+	signature []byte
+}
+
+// makeSignatureImage builds a signature image with the given signatures, with
+// the layer contents embedded in the manifest.
+func makeSignatureImage(t *testing.T, sigs ...testSignature) *oci.Image {
+	t.Helper()
+	manifest := ocispecv1.Manifest{
+		Versioned: ocispec.Versioned{SchemaVersion: 2},
+		MediaType: ocispecv1.MediaTypeImageManifest,
+		Config:    ocispecv1.DescriptorEmptyJSON,
+	}
+	for _, sig := range sigs {
+		manifest.Layers = append(manifest.Layers, ocispecv1.Descriptor{
+			MediaType: MediaTypeSimpleSigning,
+			Digest:    digest.FromBytes(sig.payload),
+			Size:      int64(len(sig.payload)),
+			Data:      sig.payload,
+			Annotations: map[string]string{
+				AnnotationSignature: base64.StdEncoding.EncodeToString(sig.signature),
+			},
+		})
+	}
+	rawManifest, err := json.Marshal(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := oci.NewImage(rawManifest, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func mustPayload(t *testing.T, manifestDigest string) []byte {
+	t.Helper()
+	p, err := Payload("registry.example/test", manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signEC := func(payload []byte) testSignature {
+		h := sha256.Sum256(payload)
+		sig, err := ecdsa.SignASN1(rand.Reader, ecKey, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testSignature{payload, sig}
+	}
+	signED := func(key ed25519.PrivateKey, payload []byte) testSignature {
+		return testSignature{payload, ed25519.Sign(key, payload)}
+	}
+
+	trusted := []crypto.PublicKey{&ecKey.PublicKey, edPub}
+	for i, te := range []struct {
+		name  string
+		sigs  []testSignature
+		valid bool
+	}{
+		{"ecdsa", []testSignature{signEC(mustPayload(t, testDigest))}, true},
+		{"ed25519", []testSignature{signED(edKey, mustPayload(t, testDigest))}, true},
+		{"none", nil, false},
+		{"untrusted key", []testSignature{signED(otherKey, mustPayload(t, testDigest))}, false},
+		{"other image", []testSignature{signEC(mustPayload(t, otherDigest))}, false},
+		{"tampered payload", []testSignature{{mustPayload(t, testDigest), signEC(mustPayload(t, otherDigest)).signature}}, false},
+		{"second valid", []testSignature{
+			signED(otherKey, mustPayload(t, testDigest)),
+			signED(edKey, mustPayload(t, testDigest)),
+		}, true},
+	} {
+		t.Run(fmt.Sprintf("%d-%s", i, te.name), func(t *testing.T) {
+			err := Verify(makeSignatureImage(t, te.sigs...), testDigest, trusted)
+			if te.valid && err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+			if !te.valid && !errors.Is(err, ErrNoValidSignature) {
+				t.Errorf("Expected ErrNoValidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, te := range []struct {
+		key   crypto.PublicKey
+		valid bool
+	}{
+		{&ecKey.PublicKey, true},
+		{edPub, true},
+		{&p384Key.PublicKey, false},
+	} {
+		der, err := x509.MarshalPKIXPublicKey(te.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParsePublicKey(der)
+		if te.valid && err != nil {
+			t.Errorf("%T: expected valid key, got %v", te.key, err)
+		}
+		if !te.valid && err == nil {
+			t.Errorf("%T: expected error", te.key)
+		}
+	}
+	if _, err := ParsePublicKey([]byte("garbage")); err == nil {
+		t.Error("Expected error for invalid key")
+	}
+}
+
+func TestTag(t *testing.T) {
+	tag, err := Tag(testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sha256-345db5d8fc468218c5232bf54a1358b6825c28d658fa12c9a1edcc7539690686.sig"; tag != want {
+		t.Errorf("Got tag %q, expected %q", tag, want)
+	}
+	if _, err := Tag("sha256:abc"); err == nil {
+		t.Error("Expected error for invalid digest")
+	}
+}
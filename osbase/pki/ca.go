@@ -47,7 +47,11 @@ func issueCertificate(req *Certificate, ca *x509.Certificate, caKey ed25519.Priv
 
 	req.Template.SerialNumber = serialNumber
 	req.Template.NotBefore = time.Now()
-	req.Template.NotAfter = UnknownNotAfter
+	if req.Lifetime != 0 {
+		req.Template.NotAfter = req.Template.NotBefore.Add(req.Lifetime)
+	} else {
+		req.Template.NotAfter = UnknownNotAfter
+	}
 	req.Template.BasicConstraintsValid = true
 
 	// Set the AuthorityKeyID to the SKID of the signing certificate (or self,
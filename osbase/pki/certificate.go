@@ -15,6 +15,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 
@@ -94,6 +95,13 @@ type Certificate struct {
 	// the user for External or Ephemeral certificates, and will be populated by the
 	// next Ensure call if missing.
 	PublicKey ed25519.PublicKey
+
+	// Lifetime is the validity period of the certificate when issued. If zero, the
+	// certificate is valid forever (ie. until UnknownNotAfter). Managed and
+	// External certificates with a Lifetime stored in etcd are reissued by Ensure
+	// once two thirds of their validity period have passed, or if they are valid
+	// for longer than Lifetime.
+	Lifetime time.Duration
 }
 
 func (n *Namespace) etcdPath(f string, args ...interface{}) string {
@@ -181,6 +189,9 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 		return nil, fmt.Errorf("failed to get certificate from etcd: %w", err)
 	}
 
+	// The revision of the certificate to replace, or zero if no certificate is
+	// stored yet.
+	var modRevision int64
 	if len(certRes.Kvs) == 1 {
 		certBytes := certRes.Kvs[0].Value
 		cert, err := x509.ParseCertificate(certBytes)
@@ -195,10 +206,14 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 			return nil, fmt.Errorf("certificate stored in etcd emitted for different public key")
 		}
 		// TODO(q3k): ensure issuer and template haven't changed
-		return certBytes, nil
+		if !c.needsRenewal(cert, time.Now()) {
+			return certBytes, nil
+		}
+		// Certificate is about to expire - issue a new one and replace it in etcd.
+		modRevision = certRes.Kvs[0].ModRevision
 	}
 
-	// No certificate found - issue one and save to etcd.
+	// No certificate found or renewal needed - issue one and save to etcd.
 	cert, err = c.Issuer.Issue(ctx, c, kv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to issue: %w", err)
@@ -206,7 +221,7 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 
 	res, err := kv.Txn(ctx).
 		If(
-			clientv3.Compare(clientv3.CreateRevision(certPath), "=", 0),
+			clientv3.Compare(clientv3.ModRevision(certPath), "=", modRevision),
 		).
 		Then(
 			clientv3.OpPut(certPath, string(cert)),
@@ -220,6 +235,21 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 	return
 }
 
+// needsRenewal returns whether a certificate previously issued for c should be
+// replaced by a newly issued one at the given time. This is the case once two
+// thirds of its validity period have passed, or if it's valid for longer than
+// c.Lifetime permits (eg. because it was issued before a Lifetime was set).
+func (c *Certificate) needsRenewal(cert *x509.Certificate, now time.Time) bool {
+	if c.Lifetime == 0 {
+		return false
+	}
+	if cert.NotAfter.After(now.Add(c.Lifetime)) {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return now.After(cert.NotBefore.Add(lifetime / 3 * 2))
+}
+
 // ensureKey retrieves or creates PublicKey as needed based on the Certificate
 // Mode. For Managed Certificates and Ephemeral Certificates with no PrivateKey
 // it will also populate PrivateKay.
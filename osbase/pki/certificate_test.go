@@ -10,6 +10,7 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"testing"
+	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
 	"go.etcd.io/etcd/tests/v3/integration"
@@ -193,3 +194,98 @@ func TestExternal(t *testing.T) {
 		t.Errorf("New server certificate has different x509 certificate")
 	}
 }
+
+// TestLifetime ensures that certificates with a Lifetime are issued with a
+// finite validity period, and reissued by Ensure when needed.
+func TestLifetime(t *testing.T) {
+	lt := logtree.New()
+	logtree.PipeAllToTest(t, lt)
+	tb, cancel := testutil.NewTestingTBProthesis("pki-lifetime")
+	defer cancel()
+	cluster := integration.NewClusterV3(tb, &integration.ClusterConfig{
+		Size: 1,
+		LoggerBuilder: func(memberName string) *zap.Logger {
+			dn := logtree.DN("etcd." + memberName)
+			return logtree.Zapify(lt.MustLeveledFor(dn), zap.WarnLevel)
+		},
+	})
+	cl := cluster.Client(0)
+	defer cluster.Terminate(tb)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+	ns := Namespaced("/test-lifetime/")
+
+	ca := &Certificate{
+		Namespace: &ns,
+		Issuer:    SelfSigned,
+		Name:      "ca",
+		Template:  CA("Test CA"),
+	}
+	pk, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	server := func(lifetime time.Duration) *x509.Certificate {
+		t.Helper()
+		c := &Certificate{
+			Namespace: &ns,
+			Issuer:    ca,
+			Name:      "server",
+			Template:  Server([]string{"server"}, nil),
+			Mode:      CertificateExternal,
+			PublicKey: pk,
+			Lifetime:  lifetime,
+		}
+		certBytes, err := c.Ensure(ctx, cl)
+		if err != nil {
+			t.Fatalf("Failed to Ensure server certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			t.Fatalf("Failed to parse server certificate: %v", err)
+		}
+		return cert
+	}
+
+	// Certificates without a Lifetime are valid forever.
+	cert := server(0)
+	if !cert.NotAfter.Equal(UnknownNotAfter) {
+		t.Errorf("Certificate without lifetime expires at %s", cert.NotAfter)
+	}
+	// Setting a Lifetime reissues such certificates...
+	cert = server(time.Hour)
+	if want, got := cert.NotBefore.Add(time.Hour), cert.NotAfter; !want.Equal(got) {
+		t.Errorf("Certificate with lifetime expires at %s, wanted %s", got, want)
+	}
+	// ... but not certificates that are still fresh.
+	if cert2 := server(time.Hour); !bytes.Equal(cert.Raw, cert2.Raw) {
+		t.Errorf("Fresh certificate was reissued")
+	}
+	// Lowering the Lifetime reissues the certificate again.
+	if cert2 := server(time.Minute); bytes.Equal(cert.Raw, cert2.Raw) {
+		t.Errorf("Certificate valid for longer than lifetime was not reissued")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	c := &Certificate{Lifetime: 3 * time.Hour}
+	for i, te := range []struct {
+		lifetime  time.Duration
+		notBefore time.Time
+		notAfter  time.Time
+		want      bool
+	}{
+		{0, now.Add(-time.Hour), UnknownNotAfter, false},
+		{3 * time.Hour, now.Add(-time.Hour), now.Add(2 * time.Hour), false},
+		{3 * time.Hour, now.Add(-150 * time.Minute), now.Add(30 * time.Minute), true},
+		{3 * time.Hour, now.Add(-4 * time.Hour), now.Add(-time.Hour), true},
+		{3 * time.Hour, now.Add(-time.Hour), UnknownNotAfter, true},
+	} {
+		c.Lifetime = te.lifetime
+		cert := &x509.Certificate{NotBefore: te.notBefore, NotAfter: te.notAfter}
+		if got := c.needsRenewal(cert, now); got != te.want {
+			t.Errorf("case %d: wanted %v, got %v", i, te.want, got)
+		}
+	}
+}
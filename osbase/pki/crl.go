@@ -10,6 +10,7 @@ import (
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
+	"slices"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -37,45 +38,19 @@ func (c *Certificate) crlPath() string {
 //
 // Only Managed and External certificates can be revoked.
 func (c Certificate) Revoke(ctx context.Context, kv clientv3.KV, hostname string) error {
-	crlPath := c.crlPath()
 	issuedCerts := c.Namespace.etcdPath("issued/")
-
-	res, err := kv.Txn(ctx).Then(
-		clientv3.OpGet(crlPath),
-		clientv3.OpGet(issuedCerts, clientv3.WithPrefix())).Commit()
+	res, err := kv.Get(ctx, issuedCerts, clientv3.WithPrefix())
 	if err != nil {
-		return fmt.Errorf("failed to retrieve certificates and CRL from etcd: %w", err)
-	}
-
-	// Parse certs, CRL and CRL revision from state.
-	var certs []*x509.Certificate
-	var crlRevision int64
-	var crl *pkix.CertificateList
-	for _, el := range res.Responses {
-		for _, kv := range el.GetResponseRange().GetKvs() {
-			if string(kv.Key) == crlPath {
-				crl, err = x509.ParseCRL(kv.Value)
-				if err != nil {
-					return fmt.Errorf("could not parse CRL from etcd: %w", err)
-				}
-				crlRevision = kv.CreateRevision
-			} else {
-				cert, err := x509.ParseCertificate(kv.Value)
-				if err != nil {
-					return fmt.Errorf("could not parse certificate %q from etcd: %w", string(kv.Key), err)
-				}
-				certs = append(certs, cert)
-			}
-		}
+		return fmt.Errorf("failed to retrieve certificates from etcd: %w", err)
 	}
-	if crl == nil {
-		return fmt.Errorf("could not find CRL in etcd")
-	}
-	revoked := crl.TBSCertList.RevokedCertificates
 
 	// Find requested hostname in issued certificates.
 	var serial *big.Int
-	for _, cert := range certs {
+	for _, kv := range res.Kvs {
+		cert, err := x509.ParseCertificate(kv.Value)
+		if err != nil {
+			return fmt.Errorf("could not parse certificate %q from etcd: %w", string(kv.Key), err)
+		}
 		for _, dnsName := range cert.DNSNames {
 			if dnsName == hostname {
 				serial = cert.SerialNumber
@@ -89,40 +64,93 @@ func (c Certificate) Revoke(ctx context.Context, kv clientv3.KV, hostname string
 	if serial == nil {
 		return fmt.Errorf("could not find requested hostname")
 	}
+	return c.RevokeSerial(ctx, kv, serial)
+}
+
+// RevokeSerial performs a CRL-based revocation of the certificates with the
+// given serial numbers issued by this CA. Unlike Revoke, this also works for
+// Ephemeral certificates, as the certificate does not need to be stored in
+// etcd. All revocations are written to the backing etcd store in a single CRL
+// update and will be available to consumers through the WatchCRL API.
+//
+// An error is returned if the CRL could not be emitted (eg. due to an etcd
+// communication error, a conflicting CRL write).
+func (c Certificate) RevokeSerial(ctx context.Context, kv clientv3.KV, serials ...*big.Int) error {
+	crlPath := c.crlPath()
+	res, err := kv.Get(ctx, crlPath)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve CRL from etcd: %w", err)
+	}
+	if len(res.Kvs) != 1 {
+		return fmt.Errorf("could not find CRL in etcd")
+	}
+	crl, err := x509.ParseCRL(res.Kvs[0].Value)
+	if err != nil {
+		return fmt.Errorf("could not parse CRL from etcd: %w", err)
+	}
+	crlRevision := res.Kvs[0].ModRevision
+	revoked := crl.TBSCertList.RevokedCertificates
 
-	// Check if certificate has already been revoked.
-	for _, revokedCert := range revoked {
-		if revokedCert.SerialNumber.Cmp(serial) == 0 {
-			return nil // Already revoked
+	// Revoke all certificates which have not already been revoked.
+	now := time.Now()
+	changed := false
+	for _, serial := range serials {
+		if slices.ContainsFunc(revoked, func(rc pkix.RevokedCertificate) bool {
+			return rc.SerialNumber.Cmp(serial) == 0
+		}) {
+			continue // Already revoked
 		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: now,
+		})
+		changed = true
+	}
+	if !changed {
+		return nil
 	}
 
-	// Otherwise, revoke and save new CRL.
-	revoked = append(revoked, pkix.RevokedCertificate{
-		SerialNumber:   serial,
-		RevocationTime: time.Now(),
-	})
+	// Save new CRL.
 
 	crlRaw, err := c.makeCRL(ctx, kv, revoked)
 	if err != nil {
 		return fmt.Errorf("when generating new CRL for revocation: %w", err)
 	}
 
-	res, err = kv.Txn(ctx).If(
-		clientv3.Compare(clientv3.CreateRevision(crlPath), "=", crlRevision),
+	txn, err := kv.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(crlPath), "=", crlRevision),
 	).Then(
 		clientv3.OpPut(crlPath, string(crlRaw)),
 	).Commit()
 	if err != nil {
 		return fmt.Errorf("when saving new CRL: %w", err)
 	}
-	if !res.Succeeded {
+	if !txn.Succeeded {
 		return fmt.Errorf("CRL save transaction failed, retry possible")
 	}
 
 	return nil
 }
 
+// LoadCRL returns the current CRL of this CA from etcd.
+func (c *Certificate) LoadCRL(ctx context.Context, kv clientv3.KV) (*CRL, error) {
+	res, err := kv.Get(ctx, c.crlPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve CRL from etcd: %w", err)
+	}
+	if len(res.Kvs) != 1 {
+		return nil, fmt.Errorf("could not find CRL in etcd")
+	}
+	crl, err := x509.ParseCRL(res.Kvs[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CRL from etcd: %w", err)
+	}
+	return &CRL{
+		Raw:  res.Kvs[0].Value,
+		List: crl,
+	}, nil
+}
+
 // makeCRL returns a valid CRL for a given list of certificates to be revoked.
 // The given etcd client is used to ensure this CA certificate exists in etcd,
 // but is not used to write any CRL to etcd.
@@ -141,3 +141,70 @@ func TestRevoke(t *testing.T) {
 		}
 	}
 }
+
+// TestRevokeSerial exercises revoking an Ephemeral certificate, which is not
+// stored in etcd, by its serial number.
+func TestRevokeSerial(t *testing.T) {
+	tb, cancel := testutil.NewTestingTBProthesis("pki-revoke-serial")
+	defer cancel()
+	cluster := integration.NewClusterV3(tb, &integration.ClusterConfig{
+		Size: 1,
+	})
+	cl := cluster.Client(0)
+	defer cluster.Terminate(tb)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+	ns := Namespaced("/test-revoke-serial/")
+
+	ca := &Certificate{
+		Namespace: &ns,
+		Issuer:    SelfSigned,
+		Name:      "ca",
+		Template:  CA("Test CA"),
+	}
+	eph := &Certificate{
+		Namespace: &ns,
+		Issuer:    ca,
+		Template:  Client("user", nil),
+		Mode:      CertificateEphemeral,
+	}
+	ephCertBytes, err := eph.Ensure(ctx, cl)
+	if err != nil {
+		t.Fatalf("Ensuring ephemeral certificate failed: %v", err)
+	}
+	ephCert, err := x509.ParseCertificate(ephCertBytes)
+	if err != nil {
+		t.Fatalf("Loading newly emitted ephemeral certificate failed: %v", err)
+	}
+
+	isRevoked := func() bool {
+		t.Helper()
+		crl, err := ca.LoadCRL(ctx, cl)
+		if err != nil {
+			t.Fatalf("LoadCRL failed: %v", err)
+		}
+		found := false
+		for _, el := range crl.List.TBSCertList.RevokedCertificates {
+			if el.SerialNumber.Cmp(ephCert.SerialNumber) == 0 {
+				if found {
+					t.Errorf("Certificate is on CRL twice")
+				}
+				found = true
+			}
+		}
+		return found
+	}
+	if isRevoked() {
+		t.Fatalf("Newly emitted certificate is already on CRL.")
+	}
+
+	// Revoking twice should be idempotent.
+	for i := 0; i < 2; i++ {
+		if err := ca.RevokeSerial(ctx, cl, ephCert.SerialNumber); err != nil {
+			t.Fatalf("RevokeSerial failed: %v", err)
+		}
+		if !isRevoked() {
+			t.Errorf("Revoked certificate not on CRL")
+		}
+	}
+}
@@ -33,17 +33,18 @@ type Runnable func(ctx context.Context) error
 // canceled and restarted.
 // The context here must be an existing Runnable context, and the spawned
 // runnables will run under the node that this context represents.
-func RunGroup(ctx context.Context, runnables map[string]Runnable) error {
+// The given options apply to all runnables of the group.
+func RunGroup(ctx context.Context, runnables map[string]Runnable, opts ...RunOpt) error {
 	node, unlock := fromContext(ctx)
 	defer unlock()
-	return node.runGroup(runnables)
+	return node.runGroup(runnables, opts...)
 }
 
 // Run starts a single runnable in its own group.
-func Run(ctx context.Context, name string, runnable Runnable) error {
+func Run(ctx context.Context, name string, runnable Runnable, opts ...RunOpt) error {
 	return RunGroup(ctx, map[string]Runnable{
 		name: runnable,
-	})
+	}, opts...)
 }
 
 // Signal tells the supervisor that the calling runnable has reached a certain
@@ -91,6 +92,10 @@ type supervisor struct {
 	// propagate panics, ie. don't catch them.
 	propagatePanic bool
 
+	// restartPolicy is used for all runnables started without
+	// WithRestartPolicy.
+	restartPolicy RestartPolicy
+
 	metrics *metricsFanout
 }
 
@@ -127,9 +132,10 @@ func WithMetrics(m Metrics) SupervisorOpt {
 // output.
 func New(ctx context.Context, rootRunnable Runnable, opts ...SupervisorOpt) *supervisor {
 	sup := &supervisor{
-		logtree: logtree.New(),
-		pReq:    make(chan *processorRequest),
-		metrics: &metricsFanout{},
+		logtree:       logtree.New(),
+		pReq:          make(chan *processorRequest),
+		metrics:       &metricsFanout{},
+		restartPolicy: DefaultRestartPolicy,
 	}
 
 	for _, o := range opts {
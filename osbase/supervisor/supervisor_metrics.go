@@ -17,6 +17,35 @@ type Metrics interface {
 	NotifyNodeState(dn string, state NodeState)
 }
 
+// ErrorMetrics can optionally be implemented by a Metrics implementation to
+// also be notified of the errors with which runnables died.
+type ErrorMetrics interface {
+	// NotifyNodeError is called whenever a runnable at a given DN died with an
+	// error, right before NotifyNodeState is called with NodeStateDead. The
+	// same constraints as for NotifyNodeState apply.
+	NotifyNodeError(dn string, err error)
+}
+
+// RestartMetrics can optionally be implemented by a Metrics implementation to
+// also be notified of the supervisor acting on the RestartPolicy of runnables.
+// The same constraints as for NotifyNodeState apply to all methods.
+//
+// The state reported by NotifyNodeGaveUp and NotifyNodeDegraded lasts until
+// the runnable at the given DN is started again, ie. until NotifyNodeState is
+// called with NodeStateNew for it.
+type RestartMetrics interface {
+	// NotifyNodeBackoff is called whenever a runnable at a given DN which died
+	// is going to be restarted after the given backoff.
+	NotifyNodeBackoff(dn string, backoff time.Duration)
+	// NotifyNodeGaveUp is called when the supervisor gives up restarting a
+	// runnable at a given DN, as it died more often than its RestartPolicy
+	// allows.
+	NotifyNodeGaveUp(dn string)
+	// NotifyNodeDegraded is called when the supervisor gave up restarting a
+	// child of the runnable at a given DN.
+	NotifyNodeDegraded(dn string)
+}
+
 // metricsFanout is used internally to fan out a single Metrics interface (which
 // it implements) onto multiple subordinate Metrics interfaces (as provided by
 // the user via WithMetrics).
@@ -30,6 +59,38 @@ func (m *metricsFanout) NotifyNodeState(dn string, state NodeState) {
 	}
 }
 
+func (m *metricsFanout) NotifyNodeError(dn string, err error) {
+	for _, sub := range m.sub {
+		if em, ok := sub.(ErrorMetrics); ok {
+			em.NotifyNodeError(dn, err)
+		}
+	}
+}
+
+func (m *metricsFanout) NotifyNodeBackoff(dn string, backoff time.Duration) {
+	for _, sub := range m.sub {
+		if rm, ok := sub.(RestartMetrics); ok {
+			rm.NotifyNodeBackoff(dn, backoff)
+		}
+	}
+}
+
+func (m *metricsFanout) NotifyNodeGaveUp(dn string) {
+	for _, sub := range m.sub {
+		if rm, ok := sub.(RestartMetrics); ok {
+			rm.NotifyNodeGaveUp(dn)
+		}
+	}
+}
+
+func (m *metricsFanout) NotifyNodeDegraded(dn string) {
+	for _, sub := range m.sub {
+		if rm, ok := sub.(RestartMetrics); ok {
+			rm.NotifyNodeDegraded(dn)
+		}
+	}
+}
+
 // InMemoryMetrics is a simple Metrics implementation that keeps an in-memory
 // mirror of the state of all DNs in the supervisor. The zero value for
 // InMemoryMetrics is ready to use.
@@ -45,6 +106,23 @@ type DNState struct {
 	State NodeState
 	// Transition is the time at which the runnable reached its State.
 	Transition time.Time
+	// Restarts is the number of times the runnable has been started again
+	// after it was first started.
+	Restarts uint64
+	// LastError is the error with which the runnable last died, or nil if it
+	// never died.
+	LastError error
+	// LastErrorTime is the time at which the runnable last died.
+	LastErrorTime time.Time
+	// Backoff is the backoff after which the runnable was last restarted, or
+	// is going to be restarted if it is DEAD.
+	Backoff time.Duration
+	// GaveUp is set if the supervisor gave up restarting the runnable, as it
+	// died more often than its RestartPolicy allows.
+	GaveUp bool
+	// Degraded is set if the supervisor gave up restarting a child of the
+	// runnable.
+	Degraded bool
 }
 
 func (m *InMemoryMetrics) NotifyNodeState(dn string, state NodeState) {
@@ -53,10 +131,54 @@ func (m *InMemoryMetrics) NotifyNodeState(dn string, state NodeState) {
 	if m.dns == nil {
 		m.dns = make(map[string]DNState)
 	}
-	m.dns[dn] = DNState{
-		State:      state,
-		Transition: time.Now(),
+	prev, ok := m.dns[dn]
+	if state == NodeStateNew {
+		if ok {
+			prev.Restarts++
+		}
+		prev.GaveUp = false
+		prev.Degraded = false
+	}
+	prev.State = state
+	prev.Transition = time.Now()
+	m.dns[dn] = prev
+}
+
+// update applies fn to the recorded state of a DN.
+func (m *InMemoryMetrics) update(dn string, fn func(st *DNState)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dns == nil {
+		m.dns = make(map[string]DNState)
 	}
+	st := m.dns[dn]
+	fn(&st)
+	m.dns[dn] = st
+}
+
+func (m *InMemoryMetrics) NotifyNodeError(dn string, err error) {
+	m.update(dn, func(st *DNState) {
+		st.LastError = err
+		st.LastErrorTime = time.Now()
+	})
+}
+
+func (m *InMemoryMetrics) NotifyNodeBackoff(dn string, backoff time.Duration) {
+	m.update(dn, func(st *DNState) {
+		st.Backoff = backoff
+	})
+}
+
+func (m *InMemoryMetrics) NotifyNodeGaveUp(dn string) {
+	m.update(dn, func(st *DNState) {
+		st.GaveUp = true
+	})
+}
+
+func (m *InMemoryMetrics) NotifyNodeDegraded(dn string) {
+	m.update(dn, func(st *DNState) {
+		st.Degraded = true
+	})
 }
 
 // DNs returns a copy (snapshot in time) of the recorded DN states, in a map from
@@ -4,6 +4,8 @@
 package supervisor
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -16,10 +18,16 @@ import (
 // The metrics exported are:
 //   - monogon_supervisor_dn_state_total
 //   - monogon_superfisor_dn_state_transition_count
+//   - monogon_supervisor_dn_backoff_seconds
+//   - monogon_supervisor_dn_gave_up
+//   - monogon_supervisor_dn_degraded
 type MetricsPrometheus struct {
-	exportedState *prometheus.GaugeVec
-	exportedEdge  *prometheus.CounterVec
-	cachedState   map[string]*NodeState
+	exportedState    *prometheus.GaugeVec
+	exportedEdge     *prometheus.CounterVec
+	exportedBackoff  *prometheus.GaugeVec
+	exportedGaveUp   *prometheus.GaugeVec
+	exportedDegraded *prometheus.GaugeVec
+	cachedState      map[string]*NodeState
 }
 
 // NewMetricsPrometheus initializes Supervisor metrics in a prometheus registry
@@ -42,6 +50,24 @@ func NewMetricsPrometheus(registry *prometheus.Registry) *MetricsPrometheus {
 			Help:        "Total count of supervisor runnable state transitions, broken up by DN and (old_state, new_state) tuple",
 			ConstLabels: nil,
 		}, []string{"dn", "old_state", "new_state"}),
+		exportedBackoff: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "monogon",
+			Subsystem: "supervisor",
+			Name:      "dn_backoff_seconds",
+			Help:      "Backoff after which a supervisor runnable was last restarted after dying, broken up by DN",
+		}, []string{"dn"}),
+		exportedGaveUp: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "monogon",
+			Subsystem: "supervisor",
+			Name:      "dn_gave_up",
+			Help:      "Set to 1 for supervisor runnables which are not restarted anymore as they died too often, broken up by DN",
+		}, []string{"dn"}),
+		exportedDegraded: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "monogon",
+			Subsystem: "supervisor",
+			Name:      "dn_degraded",
+			Help:      "Set to 1 for supervisor runnables with children which are not restarted anymore, broken up by DN",
+		}, []string{"dn"}),
 		cachedState: make(map[string]*NodeState),
 	}
 	return res
@@ -82,4 +108,23 @@ func (m *MetricsPrometheus) NotifyNodeState(dn string, state NodeState) {
 	}
 	m.exportEdge(dn, previous, state)
 	m.cachedState[dn] = &state
+
+	// A runnable which is started again is neither given up on nor degraded
+	// anymore.
+	if state == NodeStateNew {
+		m.exportedGaveUp.DeleteLabelValues(dn)
+		m.exportedDegraded.DeleteLabelValues(dn)
+	}
+}
+
+func (m *MetricsPrometheus) NotifyNodeBackoff(dn string, backoff time.Duration) {
+	m.exportedBackoff.WithLabelValues(dn).Set(backoff.Seconds())
+}
+
+func (m *MetricsPrometheus) NotifyNodeGaveUp(dn string) {
+	m.exportedGaveUp.WithLabelValues(dn).Set(1)
+}
+
+func (m *MetricsPrometheus) NotifyNodeDegraded(dn string) {
+	m.exportedDegraded.WithLabelValues(dn).Set(1)
 }
@@ -49,6 +49,15 @@ type node struct {
 
 	// Backoff used to keep runnables from being restarted too fast.
 	bo *backoff.ExponentialBackOff
+	// The restart policy of this node, which bo implements.
+	policy RestartPolicy
+	// Number of times the runnable has died since it last became healthy.
+	failures int
+	// gaveUp is set when the runnable has died more often than allowed by
+	// its policy, and will not be restarted until its parent is.
+	gaveUp bool
+	// degraded is set when the supervisor gave up on a child of this node.
+	degraded bool
 
 	// Context passed to the runnable, and its cancel function.
 	ctx  context.Context
@@ -161,25 +170,25 @@ func (n *node) groupSiblings(name string) map[string]bool {
 // newNode creates a new node with a given parent. It does not register it with
 // the parent (as that depends on group placement).
 func newNode(name string, runnable Runnable, sup *supervisor, parent *node) *node {
-	// We use exponential backoff for failed runnables, but at some point we
-	// cap at a given backoff time. To achieve this, we set MaxElapsedTime to
-	// 0, which will cap the backoff at MaxInterval.
-	bo := backoff.NewExponentialBackOff()
-	bo.MaxElapsedTime = 0
-
 	n := &node{
 		name:     name,
 		runnable: runnable,
 
-		bo: bo,
-
 		sup:    sup,
 		parent: parent,
 	}
+	n.setRestartPolicy(sup.restartPolicy)
 	n.reset()
 	return n
 }
 
+// setRestartPolicy sets the restart policy of the node and resets its backoff
+// accordingly.
+func (n *node) setRestartPolicy(p RestartPolicy) {
+	n.policy = p
+	n.bo = p.backoff()
+}
+
 // resetNode sets up all the dynamic fields of the node, in preparation of
 // starting a runnable. It clears the node's children, groups and resets its
 // context.
@@ -203,6 +212,8 @@ func (n *node) reset() {
 	// Clear children and state
 	n.state = NodeStateNew
 	n.signaledDone = false
+	n.gaveUp = false
+	n.degraded = false
 	n.children = make(map[string]*node)
 	n.reserved = make(map[string]bool)
 	n.groups = nil
@@ -236,7 +247,7 @@ func (s *supervisor) nodeByDN(dn string) *node {
 var reNodeName = regexp.MustCompile(`[a-z90-9_]{1,64}`)
 
 // runGroup schedules a new group of runnables to run on a node.
-func (n *node) runGroup(runnables map[string]Runnable) error {
+func (n *node) runGroup(runnables map[string]Runnable, opts ...RunOpt) error {
 	// Check that the parent node is in the right state.
 	if n.state != NodeStateNew {
 		return fmt.Errorf("cannot run new runnable on non-NEW node")
@@ -263,6 +274,9 @@ func (n *node) runGroup(runnables map[string]Runnable) error {
 			return fmt.Errorf("duplicate child name %q", name)
 		}
 		node := newNode(name, runnable, n.sup, n)
+		for _, o := range opts {
+			o(node)
+		}
 		n.children[name] = node
 
 		dns[name] = node.dn()
@@ -295,6 +309,7 @@ func (n *node) signal(signal SignalType) {
 		n.state = NodeStateHealthy
 		n.sup.metrics.NotifyNodeState(n.dn(), n.state)
 		n.bo.Reset()
+		n.failures = 0
 	case SignalDone:
 		if n.state != NodeStateHealthy {
 			panic(fmt.Errorf("node %s signaled done", n))
@@ -0,0 +1,103 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package supervisor
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// RestartPolicy configures how the supervisor restarts a runnable after it
+// died, ie. returned or panicked while it was not expected to.
+//
+// Zero values of the backoff fields are replaced by the values from
+// DefaultRestartPolicy, so a policy only needs to set the fields it wants to
+// change.
+type RestartPolicy struct {
+	// InitialBackoff is the time to wait before restarting a runnable after it
+	// first died.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the time to wait before restarting a runnable.
+	MaxBackoff time.Duration
+	// Multiplier by which the backoff grows with every consecutive failure.
+	Multiplier float64
+	// Jitter randomizes every backoff by up to this fraction of it in either
+	// direction, eg. 0.5 makes a backoff of 10s become 5s to 15s.
+	Jitter float64
+
+	// MaxFailures makes the supervisor give up restarting a runnable once it
+	// has died this many times in a row without becoming healthy in between.
+	// A runnable which has been given up on stays DEAD, without its group
+	// siblings being canceled, until its parent gets restarted for another
+	// reason. Its parent is then considered degraded, which is reported to
+	// Metrics implementing RestartMetrics.
+	//
+	// If zero, the runnable is restarted indefinitely.
+	MaxFailures int
+}
+
+// DefaultRestartPolicy is the policy used for all runnables for which no other
+// policy has been configured with WithRestartPolicy or
+// WithDefaultRestartPolicy.
+var DefaultRestartPolicy = RestartPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     time.Minute,
+	Multiplier:     1.5,
+	Jitter:         0.5,
+}
+
+// withDefaults returns the policy with all zero backoff fields replaced by the
+// values from DefaultRestartPolicy.
+func (p RestartPolicy) withDefaults() RestartPolicy {
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultRestartPolicy.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRestartPolicy.MaxBackoff
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = DefaultRestartPolicy.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultRestartPolicy.Jitter
+	}
+	return p
+}
+
+// backoff returns an exponential backoff implementing the policy.
+func (p RestartPolicy) backoff() *backoff.ExponentialBackOff {
+	p = p.withDefaults()
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = p.InitialBackoff
+	bo.MaxInterval = p.MaxBackoff
+	bo.Multiplier = p.Multiplier
+	bo.RandomizationFactor = p.Jitter
+	// We cap the backoff at MaxInterval instead of ever giving up, which is
+	// achieved by setting MaxElapsedTime to 0. Giving up is instead
+	// implemented by MaxFailures.
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+	return bo
+}
+
+// RunOpt are options for runnables started with Run or RunGroup.
+type RunOpt func(n *node)
+
+// WithRestartPolicy makes the supervisor restart the started runnables
+// according to the given policy instead of the supervisor's default policy.
+func WithRestartPolicy(p RestartPolicy) RunOpt {
+	return func(n *node) {
+		n.setRestartPolicy(p)
+	}
+}
+
+// WithDefaultRestartPolicy sets the restart policy used for all runnables
+// which have not been started with WithRestartPolicy, including the root
+// runnable.
+func WithDefaultRestartPolicy(p RestartPolicy) SupervisorOpt {
+	return func(s *supervisor) {
+		s.restartPolicy = p
+	}
+}
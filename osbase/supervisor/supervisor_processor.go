@@ -289,11 +289,27 @@ func (s *supervisor) processDied(r *processorRequestDied) {
 
 	// Mark as dead.
 	n.state = NodeStateDead
+	s.metrics.NotifyNodeError(r.dn, err)
 	s.metrics.NotifyNodeState(r.dn, n.state)
 
 	// Cancel that node's context, just in case something still depends on it.
 	n.ctxC()
 
+	// Give up on the runnable if its restart policy does not allow it to die
+	// this often. Its siblings are left running, and the GC will not restart it
+	// until its parent gets restarted.
+	n.failures++
+	if limit := n.policy.MaxFailures; limit > 0 && n.failures >= limit {
+		n.gaveUp = true
+		s.ilogger.Errorf("giving up on supervised node %s after %d consecutive failures", r.dn, n.failures)
+		s.metrics.NotifyNodeGaveUp(r.dn)
+		if n.parent != nil && !n.parent.degraded {
+			n.parent.degraded = true
+			s.metrics.NotifyNodeDegraded(n.parent.dn())
+		}
+		return
+	}
+
 	// Cancel all siblings.
 	if n.parent != nil {
 		for name := range n.parent.groupSiblings(n.name) {
@@ -458,6 +474,12 @@ func (s *supervisor) processGC() {
 		cur := queue[0]
 		queue = queue[1:]
 
+		// Nodes which have been given up on are only restarted along with
+		// their parent.
+		if cur.gaveUp {
+			continue
+		}
+
 		// If this node's context is canceled and it has exited, it should be
 		// restarted.
 		exited := cur.state == NodeStateDead || cur.state == NodeStateCanceled || cur.state == NodeStateDone
@@ -491,6 +513,7 @@ func (s *supervisor) processGC() {
 		bo := time.Duration(0)
 		if n.state == NodeStateDead {
 			bo = n.bo.NextBackOff()
+			s.metrics.NotifyNodeBackoff(dn, bo)
 		}
 
 		// Prepare node for rescheduling - remove its children, reset its state
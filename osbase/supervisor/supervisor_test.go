@@ -413,6 +413,68 @@ func TestBackoff(t *testing.T) {
 	}
 }
 
+// TestRestartPolicy exercises a restart policy which gives up on a runnable
+// after a number of failures. This must leave its group sibling running and
+// mark its parent as degraded.
+func TestRestartPolicy(t *testing.T) {
+	ctx, ctxC := context.WithTimeout(context.Background(), 20*time.Second)
+	defer ctxC()
+
+	started := make(chan struct{}, 10)
+	m := InMemoryMetrics{}
+	s := New(ctx, func(ctx context.Context) error {
+		err := RunGroup(ctx, map[string]Runnable{
+			"flaky": func(ctx context.Context) error {
+				started <- struct{}{}
+				return fmt.Errorf("failed")
+			},
+			"sibling": runnableBecomesHealthy(nil, nil),
+		}, WithRestartPolicy(RestartPolicy{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     20 * time.Millisecond,
+			MaxFailures:    3,
+		}))
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithPropagatePanic, WithMetrics(&m))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-ctx.Done():
+			t.Fatalf("flaky started only %d times", i)
+		}
+	}
+	s.waitSettleError(ctx, t)
+	select {
+	case <-started:
+		t.Errorf("flaky restarted after its policy should have given up")
+	default:
+	}
+
+	snap := m.DNs()
+	flaky := snap["root.flaky"]
+	if flaky.State != NodeStateDead || !flaky.GaveUp || flaky.Restarts != 2 {
+		t.Errorf("root.flaky: wanted dead, given up with 2 restarts, got %+v", flaky)
+	}
+	// The backoff is at most MaxBackoff plus jitter.
+	if flaky.Backoff <= 0 || flaky.Backoff > 30*time.Millisecond {
+		t.Errorf("root.flaky: wanted backoff within (0, 30ms], got %s", flaky.Backoff)
+	}
+	// The sibling is restarted along with flaky after the first two failures,
+	// but not after the last one.
+	if sibling := snap["root.sibling"]; sibling.State != NodeStateHealthy || sibling.Restarts != 2 {
+		t.Errorf("root.sibling: wanted healthy with 2 restarts, got %+v", sibling)
+	}
+	if root := snap["root"]; !root.Degraded {
+		t.Errorf("root: wanted degraded, got %+v", root)
+	}
+}
+
 // TestCancelRestart fails a runnable, but before its restart timeout expires,
 // also fails its parent. This should cause cancelation of the restart timeout.
 func TestCancelRestart(t *testing.T) {
@@ -820,6 +882,30 @@ func TestMetrics(t *testing.T) {
 	expectDN("root", NodeStateNew)
 	expectDN("root.one", NodeStateDone)
 	expectDN("root.one.two", NodeStateHealthy)
+
+	// one was restarted once after failing, and two was restarted along with it.
+	snap := m.DNs()
+	for _, te := range []struct {
+		dn        string
+		restarts  uint64
+		lastError string
+	}{
+		{"root", 0, ""},
+		{"root.one", 1, "failed"},
+		{"root.one.two", 1, ""},
+	} {
+		st := snap[te.dn]
+		if want, got := te.restarts, st.Restarts; want != got {
+			t.Errorf("Expected %q to have %d restarts, got %d", te.dn, want, got)
+		}
+		var lastError string
+		if st.LastError != nil {
+			lastError = st.LastError.Error()
+		}
+		if want, got := te.lastError, lastError; want != got {
+			t.Errorf("Expected %q to have last error %q, got %q", te.dn, want, got)
+		}
+	}
 }
 
 func ExampleNew() {
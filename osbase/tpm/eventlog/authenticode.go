@@ -0,0 +1,108 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+import (
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// AuthenticodeDigest calculates the SHA256 Authenticode digest of a PE/COFF
+// image, as measured by UEFI firmware into PCR 4 when starting an EFI
+// application. It excludes the checksum and the embedded signatures, if any, so
+// it does not change when an image is signed for Secure Boot.
+//
+// See the Windows Authenticode Portable Executable Signature Format
+// specification, section "Calculating the PE Image Hash".
+func AuthenticodeDigest(r io.ReaderAt, size int64) ([]byte, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing PE: %w", err)
+	}
+
+	// Find the offsets of the checksum and the certificate table directory
+	// entry, both of which are excluded from the digest.
+	var peOffset [4]byte
+	if _, err := r.ReadAt(peOffset[:], 0x3c); err != nil {
+		return nil, fmt.Errorf("while reading PE header offset: %w", err)
+	}
+	// Skip the PE signature and the COFF file header.
+	optionalHeader := int64(binary.LittleEndian.Uint32(peOffset[:])) + 4 + 20
+	checksum := optionalHeader + 64
+	var certDir int64
+	var sizeOfHeaders int64
+	var certTable pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		certDir = optionalHeader + 96 + pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8
+		sizeOfHeaders = int64(oh.SizeOfHeaders)
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			certTable = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	case *pe.OptionalHeader64:
+		certDir = optionalHeader + 112 + pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8
+		sizeOfHeaders = int64(oh.SizeOfHeaders)
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			certTable = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	default:
+		return nil, fmt.Errorf("PE has no optional header")
+	}
+	if sizeOfHeaders < certDir+8 || sizeOfHeaders > size {
+		return nil, fmt.Errorf("PE has invalid header size %d", sizeOfHeaders)
+	}
+
+	h := sha256.New()
+	hashRange := func(start, end int64) error {
+		if start < 0 || end > size || start > end {
+			return fmt.Errorf("range %d-%d out of bounds", start, end)
+		}
+		_, err := io.Copy(h, io.NewSectionReader(r, start, end-start))
+		return err
+	}
+
+	// Hash the headers, excluding the checksum and certificate table entry.
+	if err := hashRange(0, checksum); err != nil {
+		return nil, fmt.Errorf("while hashing headers: %w", err)
+	}
+	if err := hashRange(checksum+4, certDir); err != nil {
+		return nil, fmt.Errorf("while hashing headers: %w", err)
+	}
+	if err := hashRange(certDir+8, sizeOfHeaders); err != nil {
+		return nil, fmt.Errorf("while hashing headers: %w", err)
+	}
+	hashed := sizeOfHeaders
+
+	// Hash the sections in the order in which they appear in the file.
+	sections := make([]*pe.SectionHeader, 0, len(f.Sections))
+	for _, s := range f.Sections {
+		if s.Size == 0 {
+			continue
+		}
+		sections = append(sections, &s.SectionHeader)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].Offset < sections[j].Offset
+	})
+	for _, s := range sections {
+		start := int64(s.Offset)
+		if err := hashRange(start, start+int64(s.Size)); err != nil {
+			return nil, fmt.Errorf("while hashing section %q: %w", s.Name, err)
+		}
+		hashed += int64(s.Size)
+	}
+
+	// Hash any remaining data, excluding the certificate table at the end of
+	// the file.
+	if rest := size - hashed - int64(certTable.Size); rest > 0 {
+		if err := hashRange(hashed, hashed+rest); err != nil {
+			return nil, fmt.Errorf("while hashing trailing data: %w", err)
+		}
+	}
+	return h.Sum(nil), nil
+}
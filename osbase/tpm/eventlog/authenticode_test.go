@@ -0,0 +1,86 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	//go:embed testdata/unsigned.efi
+	unsignedImage []byte
+	//go:embed testdata/signed.efi
+	signedImage []byte
+)
+
+// Calculated independently of AuthenticodeDigest, see testdata/README.md.
+const fixtureDigest = "711ad1866b32b573b65e72b56ad2cfad5dd864c930a5abcfe4e4cecd01b66801"
+
+func TestAuthenticodeDigest(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		image []byte
+	}{
+		{"unsigned", unsignedImage},
+		{"signed", signedImage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			digest, err := AuthenticodeDigest(bytes.NewReader(tc.image), int64(len(tc.image)))
+			if err != nil {
+				t.Fatalf("AuthenticodeDigest: %v", err)
+			}
+			if got := hex.EncodeToString(digest); got != fixtureDigest {
+				t.Errorf("wanted digest %s, got %s", fixtureDigest, got)
+			}
+		})
+	}
+}
+
+// TestAuthenticodeDigestModified ensures that modifying hashed parts of the
+// image changes the digest, while modifying the checksum does not.
+func TestAuthenticodeDigestModified(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		offset  int
+		changed bool
+	}{
+		// Offset of the checksum in the optional header of the fixture.
+		{"checksum", 0x80 + 24 + 64, false},
+		{"text", 0x200, true},
+		{"data", 0x400, true},
+		{"trailing", len(unsignedImage) - 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image := bytes.Clone(unsignedImage)
+			image[tc.offset] ^= 0xff
+			digest, err := AuthenticodeDigest(bytes.NewReader(image), int64(len(image)))
+			if err != nil {
+				t.Fatalf("AuthenticodeDigest: %v", err)
+			}
+			if changed := hex.EncodeToString(digest) != fixtureDigest; changed != tc.changed {
+				t.Errorf("digest changed: %v, wanted %v", changed, tc.changed)
+			}
+		})
+	}
+}
+
+func TestAuthenticodeDigestInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		image []byte
+	}{
+		{"empty", nil},
+		{"truncated", unsignedImage[:0x300]},
+		{"not PE", bytes.Repeat([]byte{0x42}, 1024)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := AuthenticodeDigest(bytes.NewReader(tc.image), int64(len(tc.image))); err == nil {
+				t.Errorf("AuthenticodeDigest succeeded, wanted error")
+			}
+		})
+	}
+}
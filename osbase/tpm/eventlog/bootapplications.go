@@ -0,0 +1,28 @@
+// Copyright The Monogon Project Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+import (
+	"source.monogon.dev/osbase/tpm/eventlog/internal"
+)
+
+// BootApplications returns the digests of the EFI applications measured into
+// PCR 4 by the firmware, in the order in which they were started. For PE/COFF
+// images these are Authenticode digests, see AuthenticodeDigest.
+//
+// The events must have been verified against the PCR values, eg. by
+// EventLog.Verify.
+func BootApplications(events []Event) [][]byte {
+	var digests [][]byte
+	for _, e := range events {
+		if e.Index != 4 {
+			continue
+		}
+		if internal.EventType(e.Type) != internal.EFIBootServicesApplication {
+			continue
+		}
+		digests = append(digests, e.Digest)
+	}
+	return digests
+}